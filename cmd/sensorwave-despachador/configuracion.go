@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cbiale/sensorwave/despachador"
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// configuracion es el archivo de configuración del despachador.
+// Se escribe en YAML (o JSON) y se decodifica con las reglas de la API JSON, por lo
+// que las duraciones admiten números en nanosegundos o texto legible ("15m", "7d").
+type configuracion struct {
+	S3 configuracionS3 `json:"s3"` // Bucket con los registros y los datos migrados de los nodos (requerido)
+
+	Servidor struct {
+		Direccion          string         `json:"direccion"` // default: ":8080"
+		OrigenesCORS       []string       `json:"origenes_cors"`
+		TamañoMaximoCuerpo int64          `json:"tamaño_maximo_cuerpo"`
+		TimeoutLectura     tipos.Duracion `json:"timeout_lectura"`
+		TimeoutEscritura   tipos.Duracion `json:"timeout_escritura"`
+		TimeoutInactividad tipos.Duracion `json:"timeout_inactividad"`
+		SinRegistro        bool           `json:"sin_registro_solicitudes"`
+	} `json:"servidor"`
+
+	IntervaloSincronizacion tipos.Duracion `json:"intervalo_sincronizacion"` // default: 30s
+
+	CacheBloques struct {
+		TamañoMemoria int64  `json:"tamaño_memoria"`
+		Directorio    string `json:"directorio"`
+		TamañoDisco   int64  `json:"tamaño_disco"`
+	} `json:"cache_bloques"`
+
+	CacheResultados struct {
+		MaxEntradas int            `json:"max_entradas"`
+		TTL         tipos.Duracion `json:"ttl"`
+	} `json:"cache_resultados"`
+
+	SaludNodos struct {
+		UmbralFallos    int            `json:"umbral_fallos"`
+		EsperaReintento tipos.Duracion `json:"espera_reintento"`
+	} `json:"salud_nodos"`
+
+	Latidos struct {
+		UmbralInactivo     tipos.Duracion `json:"umbral_inactivo"`
+		UmbralDesconectado tipos.Duracion `json:"umbral_desconectado"`
+		EliminarTras       tipos.Duracion `json:"eliminar_tras"`
+	} `json:"latidos"`
+
+	Firmas struct {
+		ClavesConfiables     map[string]string `json:"claves_confiables"`
+		ArchivoConfianza     string            `json:"archivo_confianza"`
+		InscribirAlPrimerUso bool              `json:"inscribir_al_primer_uso"`
+		RequerirFirma        bool              `json:"requerir_firma"`
+	} `json:"firmas"`
+
+	Autenticacion struct {
+		Secreto         string            `json:"secreto"` // $SENSORWAVE_SECRETO_AUTENTICACION
+		SecretosPorNodo map[string]string `json:"secretos_por_nodo"`
+	} `json:"autenticacion"`
+
+	TLS struct {
+		ArchivoCA string `json:"archivo_ca"`
+	} `json:"tls"`
+
+	Autorizacion struct {
+		Habilitada   bool   `json:"habilitada"`
+		ClaveMaestra string `json:"clave_maestra"` // $SENSORWAVE_CLAVE_MAESTRA
+		SecretoJWT   string `json:"secreto_jwt"`   // $SENSORWAVE_SECRETO_JWT
+	} `json:"autorizacion"`
+}
+
+// configuracionS3 corresponde a tipos.ConfiguracionS3
+type configuracionS3 struct {
+	Endpoint        string `json:"endpoint"`
+	AccessKeyID     string `json:"access_key_id"`     // $S3_ACCESS_KEY_ID
+	SecretAccessKey string `json:"secret_access_key"` // $S3_SECRET_ACCESS_KEY
+	Bucket          string `json:"bucket"`
+	Region          string `json:"region"`
+	Directorio      string `json:"directorio"` // Directorio compartido en lugar del bucket
+}
+
+// cargarConfiguracion lee el archivo de configuración y aplica las variables de
+// entorno de los secretos, que tienen prioridad sobre el archivo
+func cargarConfiguracion(ruta string) (*configuracion, error) {
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo configuración: %v", err)
+	}
+
+	cfg := &configuracion{}
+	if err := tipos.DecodificarYAML(datos, cfg); err != nil {
+		return nil, fmt.Errorf("error en configuración %s: %v", ruta, err)
+	}
+
+	sobrescribirDesdeEntorno(&cfg.S3.AccessKeyID, "S3_ACCESS_KEY_ID")
+	sobrescribirDesdeEntorno(&cfg.S3.SecretAccessKey, "S3_SECRET_ACCESS_KEY")
+	sobrescribirDesdeEntorno(&cfg.Autenticacion.Secreto, "SENSORWAVE_SECRETO_AUTENTICACION")
+	sobrescribirDesdeEntorno(&cfg.Autorizacion.ClaveMaestra, "SENSORWAVE_CLAVE_MAESTRA")
+	sobrescribirDesdeEntorno(&cfg.Autorizacion.SecretoJWT, "SENSORWAVE_SECRETO_JWT")
+
+	return cfg, nil
+}
+
+// sobrescribirDesdeEntorno reemplaza el valor por el de la variable si está definida
+func sobrescribirDesdeEntorno(valor *string, variable string) {
+	if v, existe := os.LookupEnv(variable); existe {
+		*valor = v
+	}
+}
+
+// opcionesDespachador construye las opciones de despachador.Crear
+func (c *configuracion) opcionesDespachador() despachador.Opciones {
+	return despachador.Opciones{
+		ConfigS3: tipos.ConfiguracionS3{
+			Endpoint:        c.S3.Endpoint,
+			AccessKeyID:     c.S3.AccessKeyID,
+			SecretAccessKey: c.S3.SecretAccessKey,
+			Bucket:          c.S3.Bucket,
+			Region:          c.S3.Region,
+			Directorio:      c.S3.Directorio,
+		},
+		CacheBloques: despachador.OpcionesCacheBloques{
+			TamañoMemoria: c.CacheBloques.TamañoMemoria,
+			Directorio:    c.CacheBloques.Directorio,
+			TamañoDisco:   c.CacheBloques.TamañoDisco,
+		},
+		CacheResultados: despachador.OpcionesCacheResultados{
+			MaxEntradas: c.CacheResultados.MaxEntradas,
+			TTL:         c.CacheResultados.TTL.Duration(),
+		},
+		SaludNodos: despachador.OpcionesSaludNodos{
+			UmbralFallos:    c.SaludNodos.UmbralFallos,
+			EsperaReintento: c.SaludNodos.EsperaReintento.Duration(),
+		},
+		Latidos: despachador.OpcionesLatidos{
+			UmbralInactivo:     c.Latidos.UmbralInactivo.Duration(),
+			UmbralDesconectado: c.Latidos.UmbralDesconectado.Duration(),
+			EliminarTras:       c.Latidos.EliminarTras.Duration(),
+		},
+		IntervaloSincronizacion: c.IntervaloSincronizacion.Duration(),
+		Firmas: despachador.OpcionesFirmas{
+			ClavesConfiables:     c.Firmas.ClavesConfiables,
+			ArchivoConfianza:     c.Firmas.ArchivoConfianza,
+			InscribirAlPrimerUso: c.Firmas.InscribirAlPrimerUso,
+			RequerirFirma:        c.Firmas.RequerirFirma,
+		},
+		Autenticacion: despachador.OpcionesAutenticacion{
+			Secreto:         c.Autenticacion.Secreto,
+			SecretosPorNodo: c.Autenticacion.SecretosPorNodo,
+		},
+		TLS: despachador.OpcionesTLS{
+			ArchivoCA: c.TLS.ArchivoCA,
+		},
+		Autorizacion: despachador.OpcionesAutorizacion{
+			Habilitada:   c.Autorizacion.Habilitada,
+			ClaveMaestra: c.Autorizacion.ClaveMaestra,
+			SecretoJWT:   c.Autorizacion.SecretoJWT,
+		},
+	}
+}
+
+// opcionesServidor construye las opciones de despachador.NuevoServidor
+func (c *configuracion) opcionesServidor() despachador.OpcionesServidor {
+	return despachador.OpcionesServidor{
+		Direccion:              c.Servidor.Direccion,
+		OrigenesCORS:           c.Servidor.OrigenesCORS,
+		TamañoMaximoCuerpo:     c.Servidor.TamañoMaximoCuerpo,
+		TimeoutLectura:         c.Servidor.TimeoutLectura.Duration(),
+		TimeoutEscritura:       c.Servidor.TimeoutEscritura.Duration(),
+		TimeoutInactividad:     c.Servidor.TimeoutInactividad.Duration(),
+		SinRegistroSolicitudes: c.Servidor.SinRegistro,
+	}
+}
@@ -0,0 +1,73 @@
+// sensorwave-despachador ejecuta el despachador configurado desde un archivo YAML:
+// registro de nodos en S3, caches, verificación de firmas, autenticación hacia los
+// nodos edge y la API REST con sus claves API.
+//
+// Uso:
+//
+//	S3_ACCESS_KEY_ID=... S3_SECRET_ACCESS_KEY=... SENSORWAVE_SECRETO_AUTENTICACION=... \
+//	  sensorwave-despachador -config despachador.yaml
+//
+// Los secretos pueden omitirse del archivo: las variables de entorno S3_ACCESS_KEY_ID,
+// S3_SECRET_ACCESS_KEY, SENSORWAVE_SECRETO_AUTENTICACION, SENSORWAVE_CLAVE_MAESTRA y
+// SENSORWAVE_SECRETO_JWT tienen prioridad sobre él. Ver despachador.ejemplo.yaml para
+// todas las opciones.
+//
+// SIGINT o SIGTERM detienen el despachador esperando las solicitudes en curso.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cbiale/sensorwave/despachador"
+)
+
+// esperaCierre es el tiempo máximo que se esperan las solicitudes en curso al detenerse
+const esperaCierre = 30 * time.Second
+
+func main() {
+	rutaConfig := flag.String("config", os.Getenv("SENSORWAVE_CONFIG"), "archivo de configuración YAML (default: $SENSORWAVE_CONFIG)")
+	flag.Parse()
+
+	if *rutaConfig == "" {
+		log.Fatalf("Debe indicarse el archivo de configuración con -config")
+	}
+	cfg, err := cargarConfiguracion(*rutaConfig)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	ctx, detener := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer detener()
+
+	manager, err := despachador.Crear(cfg.opcionesDespachador())
+	if err != nil {
+		log.Fatalf("Error creando despachador: %v", err)
+	}
+
+	servidor := despachador.NuevoServidor(manager, cfg.opcionesServidor())
+	errores := make(chan error, 1)
+	go func() {
+		errores <- servidor.Iniciar()
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Printf("Señal recibida, deteniendo despachador...")
+	case err := <-errores:
+		manager.Cerrar()
+		log.Fatalf("%v", err)
+	}
+
+	ctxCierre, cancelar := context.WithTimeout(context.Background(), esperaCierre)
+	defer cancelar()
+	if err := servidor.Cerrar(ctxCierre); err != nil {
+		log.Printf("Error cerrando servidor HTTP: %v", err)
+	}
+	manager.Cerrar()
+}
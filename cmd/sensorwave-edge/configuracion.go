@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cbiale/sensorwave/edge"
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// configuracion es el archivo de configuración del nodo edge.
+// Se escribe en YAML (o JSON) y se decodifica con las reglas de la API JSON, por lo
+// que las duraciones admiten números en nanosegundos o texto legible ("15m", "7d").
+type configuracion struct {
+	BaseDatos string            `json:"base_datos"` // Directorio de PebbleDB (requerido)
+	Direccion string            `json:"direccion"`  // Dirección pública con la que el despachador consulta al nodo (requerida sin túnel)
+	Tags      map[string]string `json:"tags"`
+
+	TamañoBuffer  int            `json:"tamaño_buffer"`  // Mediciones en el canal de cada serie (default: 1000)
+	TimeoutBuffer tipos.Duracion `json:"timeout_buffer"` // Espera máxima al insertar con el canal lleno (default: 100ms)
+
+	// S3 habilita el registro en la nube, la API HTTP y la migración (ausente = modo local)
+	S3 *configuracionS3 `json:"s3"`
+
+	PuertoHTTP      string         `json:"puerto_http"`      // Puerto de la API HTTP (requerido con S3 sin túnel)
+	IntervaloLatido tipos.Duracion `json:"intervalo_latido"` // default: 30s
+
+	// Tunel es la URL del despachador al que el nodo abre un túnel inverso cuando no
+	// acepta conexiones entrantes (NAT, redes celulares)
+	Tunel string `json:"tunel"`
+
+	Autenticacion struct {
+		Secreto       string `json:"secreto"`       // Secreto compartido con el despachador ($SENSORWAVE_SECRETO_AUTENTICACION)
+		Deshabilitada bool   `json:"deshabilitada"` // Solo para desarrollo
+	} `json:"autenticacion"`
+
+	TLS struct {
+		Certificado string `json:"certificado"` // Archivo PEM del certificado
+		Clave       string `json:"clave"`       // Archivo PEM de la clave privada
+		Autofirmado bool   `json:"autofirmado"` // Certificado generado por el nodo
+	} `json:"tls"`
+
+	Migracion struct {
+		Intervalo tipos.Duracion `json:"intervalo"` // Intervalo de IniciarMigracionAutomatica (0 = deshabilitada)
+	} `json:"migracion"`
+
+	// Ejecutores registra en el motor de reglas acciones que publican en el middleware
+	Ejecutores []configuracionEjecutor `json:"ejecutores"`
+
+	// Las secciones series y reglas forman el manifiesto de aprovisionamiento
+	// (ver edge.DecodificarManifiesto)
+	manifiesto edge.ManifiestoAprovisionamiento
+
+	Aprovisionamiento struct {
+		EliminarAusentes bool `json:"eliminar_ausentes"` // Elimina series y reglas que no figuran en el archivo
+	} `json:"aprovisionamiento"`
+}
+
+// configuracionS3 corresponde a tipos.ConfiguracionS3
+type configuracionS3 struct {
+	Endpoint          string `json:"endpoint"`
+	AccessKeyID       string `json:"access_key_id"`     // $S3_ACCESS_KEY_ID
+	SecretAccessKey   string `json:"secret_access_key"` // $S3_SECRET_ACCESS_KEY
+	Bucket            string `json:"bucket"`
+	Region            string `json:"region"`
+	DisposicionClaves string `json:"disposicion_claves"`
+	Directorio        string `json:"directorio"` // Directorio compartido en lugar del bucket
+}
+
+// configuracionEjecutor es un ejecutor de acciones conectado al middleware
+type configuracionEjecutor struct {
+	Nombre    string `json:"nombre"`    // Tipo de acción que atiende (Accion.Tipo)
+	Protocolo string `json:"protocolo"` // mqtt, http o coap
+	Direccion string `json:"direccion"`
+	Puerto    string `json:"puerto"`
+}
+
+// cargarConfiguracion lee el archivo de configuración y aplica las variables de
+// entorno de los secretos, que tienen prioridad sobre el archivo
+func cargarConfiguracion(ruta string) (*configuracion, error) {
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo configuración: %v", err)
+	}
+
+	cfg := &configuracion{}
+	if err := tipos.DecodificarYAML(datos, cfg); err != nil {
+		return nil, fmt.Errorf("error en configuración %s: %v", ruta, err)
+	}
+	cfg.manifiesto, err = edge.DecodificarManifiesto(datos)
+	if err != nil {
+		return nil, fmt.Errorf("error en configuración %s: %v", ruta, err)
+	}
+
+	if cfg.S3 != nil {
+		sobrescribirDesdeEntorno(&cfg.S3.AccessKeyID, "S3_ACCESS_KEY_ID")
+		sobrescribirDesdeEntorno(&cfg.S3.SecretAccessKey, "S3_SECRET_ACCESS_KEY")
+	}
+	sobrescribirDesdeEntorno(&cfg.Autenticacion.Secreto, "SENSORWAVE_SECRETO_AUTENTICACION")
+
+	return cfg, nil
+}
+
+// sobrescribirDesdeEntorno reemplaza el valor por el de la variable si está definida
+func sobrescribirDesdeEntorno(valor *string, variable string) {
+	if v, existe := os.LookupEnv(variable); existe {
+		*valor = v
+	}
+}
+
+// opcionesEdge construye las opciones de edge.Crear
+func (c *configuracion) opcionesEdge() edge.Opciones {
+	opts := edge.Opciones{
+		NombreDB:              c.BaseDatos,
+		Direccion:             c.Direccion,
+		PuertoHTTP:            c.PuertoHTTP,
+		URLTunel:              c.Tunel,
+		TamañoBuffer:          c.TamañoBuffer,
+		TimeoutBuffer:         int64(c.TimeoutBuffer),
+		Tags:                  c.Tags,
+		IntervaloLatido:       c.IntervaloLatido.Duration(),
+		SecretoAutenticacion:  c.Autenticacion.Secreto,
+		SinAutenticacion:      c.Autenticacion.Deshabilitada,
+		ArchivoCertificadoTLS: c.TLS.Certificado,
+		ArchivoClaveTLS:       c.TLS.Clave,
+		TLSAutofirmado:        c.TLS.Autofirmado,
+	}
+	if c.S3 != nil {
+		opts.ConfigS3 = &tipos.ConfiguracionS3{
+			Endpoint:          c.S3.Endpoint,
+			AccessKeyID:       c.S3.AccessKeyID,
+			SecretAccessKey:   c.S3.SecretAccessKey,
+			Bucket:            c.S3.Bucket,
+			Region:            c.S3.Region,
+			DisposicionClaves: tipos.DisposicionClaves(c.S3.DisposicionClaves),
+			Directorio:        c.S3.Directorio,
+		}
+	}
+	return opts
+}
@@ -0,0 +1,152 @@
+// sensorwave-edge ejecuta un nodo edge configurado desde un archivo YAML: base de
+// datos Pebble, conexión a S3, API HTTP para el despachador, series, reglas y los
+// ejecutores que publican las acciones de las reglas en el middleware.
+//
+// Uso:
+//
+//	S3_ACCESS_KEY_ID=... S3_SECRET_ACCESS_KEY=... SENSORWAVE_SECRETO_AUTENTICACION=... \
+//	  sensorwave-edge -config edge.yaml
+//
+// Los secretos pueden omitirse del archivo: las variables de entorno S3_ACCESS_KEY_ID,
+// S3_SECRET_ACCESS_KEY y SENSORWAVE_SECRETO_AUTENTICACION tienen prioridad sobre él.
+// Ver edge.ejemplo.yaml para todas las opciones.
+//
+// Las secciones series y reglas del archivo son un manifiesto de aprovisionamiento
+// que se aplica al iniciar (ver edge.Aprovisionar). Con -simular se muestra el plan
+// de cambios sin aplicarlo y sin iniciar el nodo. SIGINT o SIGTERM detienen el nodo
+// cerrando la API HTTP, los ejecutores y la base de datos.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/cbiale/sensorwave/edge"
+	"github.com/cbiale/sensorwave/middleware"
+)
+
+func main() {
+	rutaConfig := flag.String("config", os.Getenv("SENSORWAVE_CONFIG"), "archivo de configuración YAML (default: $SENSORWAVE_CONFIG)")
+	simular := flag.Bool("simular", false, "solo mostrar el plan de aprovisionamiento de series y reglas")
+	flag.Parse()
+
+	if *rutaConfig == "" {
+		log.Fatalf("Debe indicarse el archivo de configuración con -config")
+	}
+	cfg, err := cargarConfiguracion(*rutaConfig)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if *simular {
+		if err := simularAprovisionamiento(cfg); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	ctx, detener := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer detener()
+
+	me, err := edge.Crear(cfg.opcionesEdge())
+	if err != nil {
+		log.Fatalf("Error creando nodo edge: %v", err)
+	}
+
+	clientes, err := iniciarNodo(me, cfg)
+	if err != nil {
+		cerrarNodo(me, clientes)
+		log.Fatalf("%v", err)
+	}
+
+	log.Printf("Nodo edge %s iniciado", me.ObtenerNodoID())
+	<-ctx.Done()
+
+	log.Printf("Señal recibida, deteniendo nodo edge...")
+	cerrarNodo(me, clientes)
+}
+
+// iniciarNodo registra los ejecutores, aprovisiona las series y reglas del archivo e
+// inicia la migración automática. Retorna los clientes del middleware conectados.
+func iniciarNodo(me *edge.ManagerEdge, cfg *configuracion) ([]middleware.Cliente, error) {
+	var clientes []middleware.Cliente
+	for _, e := range cfg.Ejecutores {
+		cliente, err := registrarEjecutor(me.MotorReglas, e)
+		if err != nil {
+			return clientes, fmt.Errorf("error en ejecutor %s: %v", e.Nombre, err)
+		}
+		clientes = append(clientes, cliente)
+	}
+
+	plan, err := me.Aprovisionar(cfg.manifiesto, edge.OpcionesAprovisionamiento{
+		EliminarAusentes: cfg.Aprovisionamiento.EliminarAusentes,
+	})
+	if err != nil {
+		return clientes, fmt.Errorf("error aprovisionando series y reglas: %v", err)
+	}
+	if !plan.Vacio() {
+		log.Printf("Aprovisionamiento:\n%s", plan)
+	}
+
+	if cfg.Migracion.Intervalo > 0 {
+		if cfg.S3 == nil {
+			log.Printf("Advertencia: migracion.intervalo se ignora sin configuración S3")
+		} else {
+			me.IniciarMigracionAutomatica(cfg.Migracion.Intervalo.Duration())
+		}
+	}
+
+	return clientes, nil
+}
+
+// registrarEjecutor conecta con el middleware según el protocolo del ejecutor
+func registrarEjecutor(motor *edge.MotorReglas, e configuracionEjecutor) (middleware.Cliente, error) {
+	switch strings.ToLower(e.Protocolo) {
+	case "mqtt":
+		return edge.RegistrarEjecutorMQTT(motor, e.Nombre, e.Direccion, e.Puerto)
+	case "http":
+		return edge.RegistrarEjecutorHTTP(motor, e.Nombre, e.Direccion, e.Puerto)
+	case "coap":
+		return edge.RegistrarEjecutorCoAP(motor, e.Nombre, e.Direccion, e.Puerto)
+	default:
+		return nil, fmt.Errorf("protocolo desconocido: %q (use mqtt, http o coap)", e.Protocolo)
+	}
+}
+
+// simularAprovisionamiento abre la base de datos en modo local (sin S3 ni API HTTP) y
+// muestra los cambios que aplicaría el archivo
+func simularAprovisionamiento(cfg *configuracion) error {
+	opts := cfg.opcionesEdge()
+	opts.ConfigS3 = nil
+	opts.PuertoHTTP = ""
+	me, err := edge.Crear(opts)
+	if err != nil {
+		return fmt.Errorf("error abriendo nodo edge: %v", err)
+	}
+	defer me.Cerrar()
+
+	plan, err := me.Aprovisionar(cfg.manifiesto, edge.OpcionesAprovisionamiento{
+		EliminarAusentes: cfg.Aprovisionamiento.EliminarAusentes,
+		Simular:          true,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(plan)
+	return nil
+}
+
+// cerrarNodo desconecta los ejecutores y cierra el nodo
+func cerrarNodo(me *edge.ManagerEdge, clientes []middleware.Cliente) {
+	for _, cliente := range clientes {
+		cliente.Desconectar()
+	}
+	me.Cerrar()
+	log.Printf("Nodo edge detenido")
+}
@@ -0,0 +1,64 @@
+// sensorwave-redisponer reorganiza los bloques migrados de un bucket S3 (o de un
+// directorio de almacenamiento local) a otra disposición de claves (plana o
+// particionada por fecha), actualizando los manifiestos de cada serie.
+//
+// Uso:
+//
+//	S3_ACCESS_KEY_ID=... S3_SECRET_ACCESS_KEY=... \
+//	  sensorwave-redisponer -endpoint http://localhost:3900 -bucket sensorwave-data -destino particionada
+//	sensorwave-redisponer -directorio /srv/sensorwave -destino particionada
+//
+// Se recomienda ejecutarlo con -simular primero y con la migración de los nodos
+// edge detenida. Los nodos deben configurarse con la nueva disposición
+// (ConfiguracionS3.DisposicionClaves o S3_DISPOSICION_CLAVES) para que los
+// bloques nuevos usen el mismo formato.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+func main() {
+	endpoint := flag.String("endpoint", os.Getenv("S3_ENDPOINT"), "URL del servidor S3 (default: $S3_ENDPOINT)")
+	bucket := flag.String("bucket", os.Getenv("S3_BUCKET"), "bucket de datos (default: $S3_BUCKET)")
+	region := flag.String("region", os.Getenv("S3_REGION"), "región S3 (default: $S3_REGION o us-east-1)")
+	directorio := flag.String("directorio", os.Getenv("S3_DIRECTORIO"), "directorio de almacenamiento local en lugar de S3 (default: $S3_DIRECTORIO)")
+	nodo := flag.String("nodo", "", "limitar la reorganización a un nodo")
+	destino := flag.String("destino", string(tipos.DisposicionParticionada), "disposición destino: plana o particionada")
+	simular := flag.Bool("simular", false, "solo mostrar los cambios, sin modificar el bucket")
+	flag.Parse()
+
+	cfg := tipos.ConfiguracionS3{
+		Endpoint:        *endpoint,
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Bucket:          *bucket,
+		Region:          *region,
+		Directorio:      *directorio,
+	}
+
+	almacenamiento, err := tipos.CrearAlmacenamiento(cfg)
+	if err != nil {
+		log.Fatalf("Error creando almacenamiento: %v", err)
+	}
+
+	resumen, err := tipos.RedisponerBloques(context.Background(), almacenamiento, tipos.OpcionesRedisposicion{
+		NodoID:  *nodo,
+		Destino: tipos.DisposicionClaves(*destino),
+		Simular: *simular,
+	})
+	if err != nil {
+		log.Fatalf("Error reorganizando bloques: %v", err)
+	}
+
+	log.Printf("Reorganización completada: %d bloques movidos, %d ya en disposición %s, %d manifiestos actualizados, %d errores",
+		resumen.Movidos, resumen.Omitidos, *destino, resumen.Manifiestos, resumen.Errores)
+	if resumen.Errores > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cbiale/sensorwave/despachador"
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// cliente habla con la API REST del despachador y con la API de administración
+// de los nodos edge
+type cliente struct {
+	despachador string        // URL base del despachador
+	token       string        // Clave API o JWT del despachador (vacío = sin autorización)
+	secreto     []byte        // Secreto compartido con los nodos (nil = solicitudes sin firmar)
+	edge        string        // URL del nodo que reemplaza la publicada en el registro
+	timeout     time.Duration // Tiempo máximo de cada solicitud
+	http        *http.Client
+}
+
+// errorRespuesta es el cuerpo de error del despachador
+type errorRespuesta struct {
+	Error string `json:"error"`
+}
+
+// solicitar ejecuta una solicitud JSON y retorna el cuerpo de la respuesta.
+// Un status fuera de 2xx se retorna como error con el mensaje del servidor.
+func (c *cliente) solicitar(ctx context.Context, clienteHTTP *http.Client, req *http.Request) ([]byte, error) {
+	ctx, cancelar := context.WithTimeout(ctx, c.timeout)
+	defer cancelar()
+
+	resp, err := clienteHTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error en request HTTP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	cuerpo, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo respuesta: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		mensaje := strings.TrimSpace(string(cuerpo))
+		var errResp errorRespuesta
+		if json.Unmarshal(cuerpo, &errResp) == nil && errResp.Error != "" {
+			mensaje = errResp.Error
+		}
+		return nil, fmt.Errorf("%s %s: %s (status %d)", req.Method, req.URL.Path, mensaje, resp.StatusCode)
+	}
+	return cuerpo, nil
+}
+
+// nuevaSolicitud crea una solicitud con el cuerpo serializado en JSON (nil = sin cuerpo)
+func nuevaSolicitud(metodo, url string, cuerpo interface{}) (*http.Request, []byte, error) {
+	var datos []byte
+	if cuerpo != nil {
+		var err error
+		datos, err = json.Marshal(cuerpo)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error serializando solicitud: %v", err)
+		}
+	}
+	req, err := http.NewRequest(metodo, url, bytes.NewReader(datos))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creando request: %v", err)
+	}
+	if cuerpo != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, datos, nil
+}
+
+// despachadorJSON ejecuta una solicitud contra el despachador.
+// Si destino no es nil se decodifica la respuesta en él. Retorna el cuerpo crudo.
+func (c *cliente) despachadorJSON(ctx context.Context, metodo, ruta string, cuerpo, destino interface{}) ([]byte, error) {
+	req, _, err := nuevaSolicitud(metodo, strings.TrimSuffix(c.despachador, "/")+ruta, cuerpo)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	datos, err := c.solicitar(ctx, c.http, req)
+	if err != nil {
+		return nil, err
+	}
+	if destino != nil {
+		if err := json.Unmarshal(datos, destino); err != nil {
+			return nil, fmt.Errorf("error deserializando respuesta: %v", err)
+		}
+	}
+	return datos, nil
+}
+
+// nodoEdge es el destino de una solicitud de administración
+type nodoEdge struct {
+	nodoID  string
+	url     string
+	cliente *http.Client
+}
+
+// resolverNodo obtiene del despachador la dirección y la huella del certificado
+// del nodo. Con -edge se usa esa URL y no se consulta al despachador.
+func (c *cliente) resolverNodo(ctx context.Context, nodoID string) (*nodoEdge, error) {
+	if c.edge != "" {
+		return &nodoEdge{nodoID: nodoID, url: strings.TrimSuffix(c.edge, "/"), cliente: c.http}, nil
+	}
+
+	var nodos []despachador.NodoResponse
+	if _, err := c.despachadorJSON(ctx, http.MethodGet, "/api/nodos", nil, &nodos); err != nil {
+		return nil, err
+	}
+	for _, nodo := range nodos {
+		if nodo.NodoID != nodoID {
+			continue
+		}
+		if nodo.Tunel && nodo.Direccion == "" {
+			return nil, fmt.Errorf("el nodo %s solo es accesible por túnel desde el despachador: indique su URL con -edge", nodoID)
+		}
+		destino := &nodoEdge{nodoID: nodoID, url: nodo.URLBase(), cliente: c.http}
+		if nodo.HuellaCertificado != "" {
+			// Certificado autofirmado: se confía solo en la huella publicada por el nodo
+			transporte := http.DefaultTransport.(*http.Transport).Clone()
+			transporte.TLSClientConfig = tipos.ConfiguracionTLSFijada(nodo.HuellaCertificado)
+			destino.cliente = &http.Client{Transport: transporte}
+		}
+		return destino, nil
+	}
+	return nil, fmt.Errorf("nodo no encontrado: %s", nodoID)
+}
+
+// edgeJSON ejecuta una solicitud firmada contra la API de administración de un nodo
+func (c *cliente) edgeJSON(ctx context.Context, nodoID, metodo, ruta string, cuerpo, destino interface{}) ([]byte, error) {
+	nodo, err := c.resolverNodo(ctx, nodoID)
+	if err != nil {
+		return nil, err
+	}
+
+	req, datos, err := nuevaSolicitud(metodo, nodo.url+ruta, cuerpo)
+	if err != nil {
+		return nil, err
+	}
+	if c.secreto != nil {
+		if err := tipos.FirmarSolicitud(req, datos, nodo.nodoID, c.secreto); err != nil {
+			return nil, fmt.Errorf("error firmando solicitud: %v", err)
+		}
+	}
+
+	respuesta, err := c.solicitar(ctx, nodo.cliente, req)
+	if err != nil {
+		return nil, err
+	}
+	if destino != nil && len(respuesta) > 0 {
+		if err := json.Unmarshal(respuesta, destino); err != nil {
+			return nil, fmt.Errorf("error deserializando respuesta: %v", err)
+		}
+	}
+	return respuesta, nil
+}
+
+// rutaSerie escapa cada segmento del path de una serie para usarlo en una URL
+func rutaSerie(path string) string {
+	segmentos := strings.Split(path, "/")
+	for i, s := range segmentos {
+		segmentos[i] = url.PathEscape(s)
+	}
+	return strings.Join(segmentos, "/")
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cbiale/sensorwave/despachador"
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// app es el estado compartido por los comandos
+type app struct {
+	cliente *cliente
+	formato string
+	salida  io.Writer
+	ahora   time.Time // Referencia de las expresiones relativas (now-1h)
+}
+
+// comando es un subcomando de swctl
+type comando struct {
+	nombre      string
+	descripcion string
+	ejecutar    func(ctx context.Context, a *app, args []string) error
+}
+
+// comandos en el orden en que se muestran en la ayuda
+var comandos = []comando{
+	{"nodos", "lista los nodos registrados con su conexión y salud", cmdNodos},
+	{"series", "lista las series (del despachador o de un nodo)", cmdSeries},
+	{"reglas", "lista las reglas de los nodos", cmdReglas},
+	{"rango", "consulta las mediciones de un rango", cmdRango},
+	{"ultimo", "consulta la última medición de cada serie", cmdUltimo},
+	{"agregacion", "agrega las mediciones de un rango, opcionalmente por intervalo", cmdAgregacion},
+	{"crear-serie", "crea una serie en un nodo", cmdCrearSerie},
+	{"eliminar-serie", "elimina una serie de un nodo y sus datos locales", cmdEliminarSerie},
+	{"habilitar-regla", "activa una regla de un nodo", cmdHabilitarRegla(true)},
+	{"deshabilitar-regla", "desactiva una regla de un nodo", cmdHabilitarRegla(false)},
+	{"migrar", "inicia la migración a S3 de un nodo y espera a que termine", cmdMigrar},
+	{"migracion", "muestra el progreso y la última migración a S3 de un nodo", cmdMigracion},
+}
+
+// buscarComando retorna el comando con el nombre dado
+func buscarComando(nombre string) (*comando, bool) {
+	for i := range comandos {
+		if comandos[i].nombre == nombre {
+			return &comandos[i], true
+		}
+	}
+	return nil, false
+}
+
+// flagsComando crea el conjunto de flags de un comando. Los flags van antes de
+// los argumentos: "swctl rango -desde now-1d sensor/temp".
+func flagsComando(nombre, uso string) *flag.FlagSet {
+	fs := flag.NewFlagSet(nombre, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Uso: swctl %s %s\n", nombre, uso)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// argumentos parsea los flags del comando y verifica la cantidad de argumentos
+func argumentos(fs *flag.FlagSet, args []string, minimo, maximo int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() < minimo || fs.NArg() > maximo {
+		fs.Usage()
+		return nil, fmt.Errorf("%s: cantidad de argumentos inválida", fs.Name())
+	}
+	return fs.Args(), nil
+}
+
+// tiempo interpreta una expresión de tiempo (nanosegundos, RFC3339 o now-1h)
+func (a *app) tiempo(expr string) (tipos.MarcaTiempo, error) {
+	t, err := tipos.ParsearTiempo(expr, a.ahora)
+	if err != nil {
+		return 0, err
+	}
+	return tipos.MarcaTiempo(t.UnixNano()), nil
+}
+
+// avisarNoDisponibles informa en stderr los nodos que no respondieron a una consulta
+func avisarNoDisponibles(nodos []string) {
+	if len(nodos) > 0 {
+		fmt.Fprintf(os.Stderr, "aviso: resultado parcial, nodos no disponibles: %s\n", strings.Join(nodos, ", "))
+	}
+}
+
+// ============================================================================
+// LISTADOS
+// ============================================================================
+
+func cmdNodos(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("nodos", "")
+	if _, err := argumentos(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	var nodos []despachador.NodoResponse
+	crudo, err := a.cliente.despachadorJSON(ctx, http.MethodGet, "/api/nodos", nil, &nodos)
+	if err != nil {
+		return err
+	}
+
+	t := &tabla{encabezados: []string{"NODO", "URL", "CONEXION", "CIRCUITO", "SERIES", "REGLAS", "ULTIMA_CONEXION", "VERSION"}}
+	for _, n := range nodos {
+		destino := n.URLBase()
+		if n.Tunel {
+			destino = "túnel"
+			if !n.TunelConectado {
+				destino = "túnel (cerrado)"
+			}
+		}
+		t.agregar(n.NodoID, destino, string(n.Conexion), string(n.Salud.Estado),
+			strconv.Itoa(len(n.Series)), strconv.Itoa(len(n.Reglas)),
+			formatearTiempo(n.UltimaConexion), n.Version)
+	}
+	return imprimir(a.salida, a.formato, t, crudo)
+}
+
+func cmdSeries(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("series", "[-nodo NODO] [PATRON]")
+	nodo := fs.String("nodo", "", "consultar directamente al nodo en lugar del despachador")
+	resto, err := argumentos(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	patron := "*"
+	if len(resto) == 1 {
+		patron = resto[0]
+	}
+
+	t := &tabla{encabezados: []string{"PATH", "NODO", "TIPO", "COMPRESION_BYTES", "COMPRESION_BLOQUE", "TAMANO_BLOQUE", "RETENCION", "TAGS"}}
+
+	if *nodo != "" {
+		var series []tipos.Serie
+		crudo, err := a.cliente.edgeJSON(ctx, *nodo, http.MethodGet, "/api/series", nil, &series)
+		if err != nil {
+			return err
+		}
+		for _, s := range series {
+			if !tipos.MatchPath(s.Path, patron) {
+				continue
+			}
+			t.agregar(s.Path, *nodo, s.TipoDatos.String(), string(s.CompresionBytes), string(s.CompresionBloque),
+				strconv.Itoa(s.TamañoBloque), formatearRetencion(s.TiempoAlmacenamiento), formatearTags(s.Tags))
+		}
+		return imprimir(a.salida, a.formato, t, crudo)
+	}
+
+	var series []despachador.SerieResponse
+	crudo, err := a.cliente.despachadorJSON(ctx, http.MethodGet, "/api/series?patron="+url.QueryEscape(patron), nil, &series)
+	if err != nil {
+		return err
+	}
+	for _, s := range series {
+		t.agregar(s.Path, s.NodoID, s.TipoDatos, s.CompresionBytes, s.CompresionBloque,
+			strconv.Itoa(s.TamanoBloque), formatearRetencion(s.TiempoAlmacenamiento), formatearTags(s.Tags))
+	}
+	return imprimir(a.salida, a.formato, t, crudo)
+}
+
+// formatearRetencion muestra el tiempo de almacenamiento de una serie
+func formatearRetencion(nanos int64) string {
+	if nanos == 0 {
+		return "-"
+	}
+	return time.Duration(nanos).String()
+}
+
+func cmdReglas(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("reglas", "[-nodo NODO] [-activas]")
+	nodo := fs.String("nodo", "", "solo las reglas de un nodo")
+	activas := fs.Bool("activas", false, "solo las reglas activas")
+	if _, err := argumentos(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	consulta := url.Values{}
+	if *nodo != "" {
+		consulta.Set("nodo", *nodo)
+	}
+	if *activas {
+		consulta.Set("activas", "true")
+	}
+	ruta := "/api/reglas"
+	if len(consulta) > 0 {
+		ruta += "?" + consulta.Encode()
+	}
+
+	var reglas []despachador.ReglaResponse
+	crudo, err := a.cliente.despachadorJSON(ctx, http.MethodGet, ruta, nil, &reglas)
+	if err != nil {
+		return err
+	}
+
+	t := &tabla{encabezados: []string{"ID", "NODO", "NOMBRE", "ACTIVA", "LOGICA", "CONDICIONES", "ACCIONES"}}
+	for _, r := range reglas {
+		acciones := make([]string, 0, len(r.Acciones))
+		for _, accion := range r.Acciones {
+			acciones = append(acciones, accion.Tipo+"→"+accion.Destino)
+		}
+		t.agregar(r.ID, r.NodoID, r.Nombre, strconv.FormatBool(r.Activa), r.Logica,
+			strconv.Itoa(len(r.Condiciones)), strings.Join(acciones, ","))
+	}
+	return imprimir(a.salida, a.formato, t, crudo)
+}
+
+// ============================================================================
+// CONSULTAS
+// ============================================================================
+
+func cmdRango(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("rango", "[-desde now-1h] [-hasta now] SERIE")
+	desde := fs.String("desde", "now-1h", "inicio del rango")
+	hasta := fs.String("hasta", "now", "fin del rango")
+	resto, err := argumentos(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	req := despachador.ConsultaRangoRequest{Serie: resto[0]}
+	if req.TiempoInicio, err = a.tiempo(*desde); err != nil {
+		return err
+	}
+	if req.TiempoFin, err = a.tiempo(*hasta); err != nil {
+		return err
+	}
+
+	var resp despachador.ConsultaRangoResponse
+	crudo, err := a.cliente.despachadorJSON(ctx, http.MethodPost, "/api/consulta/rango", req, &resp)
+	if err != nil {
+		return err
+	}
+	avisarNoDisponibles(resp.NodosNoDisponibles)
+
+	// Una fila por tiempo y una columna por serie
+	t := &tabla{encabezados: append([]string{"TIEMPO"}, resp.Series...)}
+	for i, tiempo := range resp.Tiempos {
+		fila := []string{formatearTiempo(tiempo)}
+		for j := range resp.Series {
+			var valor interface{}
+			if i < len(resp.Valores) && j < len(resp.Valores[i]) {
+				valor = resp.Valores[i][j]
+			}
+			fila = append(fila, formatearValor(valor))
+		}
+		t.agregar(fila...)
+	}
+	return imprimir(a.salida, a.formato, t, crudo)
+}
+
+func cmdUltimo(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("ultimo", "[-desde T] [-hasta T] SERIE")
+	desde := fs.String("desde", "", "buscar desde este momento (default: sin límite)")
+	hasta := fs.String("hasta", "", "buscar hasta este momento (default: sin límite)")
+	resto, err := argumentos(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	req := despachador.ConsultaUltimoRequest{Serie: resto[0]}
+	if *desde != "" {
+		inicio, err := a.tiempo(*desde)
+		if err != nil {
+			return err
+		}
+		req.TiempoInicio = &inicio
+	}
+	if *hasta != "" {
+		fin, err := a.tiempo(*hasta)
+		if err != nil {
+			return err
+		}
+		req.TiempoFin = &fin
+	}
+
+	var resp despachador.ConsultaUltimoResponse
+	crudo, err := a.cliente.despachadorJSON(ctx, http.MethodPost, "/api/consulta/ultimo", req, &resp)
+	if err != nil {
+		return err
+	}
+	avisarNoDisponibles(resp.NodosNoDisponibles)
+
+	t := &tabla{encabezados: []string{"SERIE", "TIEMPO", "VALOR"}}
+	for i, serie := range resp.Series {
+		var tiempo int64
+		var valor interface{}
+		if i < len(resp.Tiempos) {
+			tiempo = resp.Tiempos[i]
+		}
+		if i < len(resp.Valores) {
+			valor = resp.Valores[i]
+		}
+		t.agregar(serie, formatearTiempo(tiempo), formatearValor(valor))
+	}
+	return imprimir(a.salida, a.formato, t, crudo)
+}
+
+func cmdAgregacion(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("agregacion", "[-agregaciones promedio,maximo] [-desde now-1h] [-hasta now] [-intervalo 15m] SERIE")
+	agregaciones := fs.String("agregaciones", "promedio,minimo,maximo,count", "agregaciones separadas por comas (promedio, maximo, minimo, suma, count)")
+	desde := fs.String("desde", "now-1h", "inicio del rango")
+	hasta := fs.String("hasta", "now", "fin del rango")
+	intervalo := fs.String("intervalo", "", "agrupar en intervalos de esta duración (15m, 1h, 1d)")
+	resto, err := argumentos(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	inicio, err := a.tiempo(*desde)
+	if err != nil {
+		return err
+	}
+	fin, err := a.tiempo(*hasta)
+	if err != nil {
+		return err
+	}
+	lista := strings.Split(*agregaciones, ",")
+	for i := range lista {
+		lista[i] = strings.TrimSpace(lista[i])
+	}
+
+	if *intervalo == "" {
+		req := despachador.ConsultaAgregacionRequest{
+			Serie:        resto[0],
+			TiempoInicio: inicio,
+			TiempoFin:    fin,
+			Agregaciones: lista,
+		}
+		var resp despachador.ConsultaAgregacionResponse
+		crudo, err := a.cliente.despachadorJSON(ctx, http.MethodPost, "/api/consulta/agregacion", req, &resp)
+		if err != nil {
+			return err
+		}
+		avisarNoDisponibles(resp.NodosNoDisponibles)
+
+		// Una fila por serie y una columna por agregación
+		t := &tabla{encabezados: append([]string{"SERIE"}, resp.Agregaciones...)}
+		for j, serie := range resp.Series {
+			fila := []string{serie}
+			for i := range resp.Agregaciones {
+				valor := math.NaN()
+				if i < len(resp.Valores) && j < len(resp.Valores[i]) {
+					valor = resp.Valores[i][j]
+				}
+				fila = append(fila, formatearNumero(valor))
+			}
+			t.agregar(fila...)
+		}
+		return imprimir(a.salida, a.formato, t, crudo)
+	}
+
+	duracion, err := tipos.ParsearDuracion(*intervalo)
+	if err != nil {
+		return err
+	}
+	req := despachador.ConsultaAgregacionTemporalRequest{
+		Serie:        resto[0],
+		TiempoInicio: inicio,
+		TiempoFin:    fin,
+		Agregaciones: lista,
+		Intervalo:    tipos.Duracion(duracion),
+	}
+	var resp despachador.ConsultaAgregacionTemporalResponse
+	crudo, err := a.cliente.despachadorJSON(ctx, http.MethodPost, "/api/consulta/agregacion-temporal", req, &resp)
+	if err != nil {
+		return err
+	}
+	avisarNoDisponibles(resp.NodosNoDisponibles)
+
+	// Una fila por intervalo y serie, una columna por agregación
+	t := &tabla{encabezados: append([]string{"TIEMPO", "SERIE"}, resp.Agregaciones...)}
+	for b, tiempo := range resp.Tiempos {
+		for s, serie := range resp.Series {
+			fila := []string{formatearTiempo(tiempo), serie}
+			for i := range resp.Agregaciones {
+				valor := math.NaN()
+				if i < len(resp.Valores) && b < len(resp.Valores[i]) && s < len(resp.Valores[i][b]) {
+					valor = float64(resp.Valores[i][b][s])
+				}
+				fila = append(fila, formatearNumero(valor))
+			}
+			t.agregar(fila...)
+		}
+	}
+	return imprimir(a.salida, a.formato, t, crudo)
+}
+
+// ============================================================================
+// ADMINISTRACIÓN DE NODOS
+// ============================================================================
+
+// solicitudCrearSerie es el cuerpo de POST /api/series del edge
+type solicitudCrearSerie struct {
+	Path                 string            `json:"path"`
+	TipoDatos            string            `json:"tipo_datos"`
+	Tags                 map[string]string `json:"tags,omitempty"`
+	CompresionBytes      string            `json:"compresion_bytes,omitempty"`
+	CompresionBloque     string            `json:"compresion_bloque,omitempty"`
+	TamañoBloque         int               `json:"tamaño_bloque,omitempty"`
+	TiempoAlmacenamiento int64             `json:"tiempo_almacenamiento,omitempty"`
+}
+
+// flagTags acumula los -tag k=v de la línea de comandos
+type flagTags map[string]string
+
+func (f flagTags) String() string {
+	return formatearTags(f)
+}
+
+func (f flagTags) Set(valor string) error {
+	clave, v, ok := strings.Cut(valor, "=")
+	if !ok || clave == "" {
+		return fmt.Errorf("tag inválido %q (use clave=valor)", valor)
+	}
+	f[clave] = v
+	return nil
+}
+
+func cmdCrearSerie(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("crear-serie", "[-compresion-bytes ALG] [-compresion-bloque ALG] [-tamano-bloque N] [-retencion 7d] [-tag k=v]... NODO PATH TIPO")
+	compresionBytes := fs.String("compresion-bytes", "", "compresión de valores (SinCompresion, DeltaDelta, Xor, Bits, RLE, ...)")
+	compresionBloque := fs.String("compresion-bloque", "", "compresión de bloques (Ninguna, LZ4, ZSTD, Snappy, Gzip)")
+	tamañoBloque := fs.Int("tamano-bloque", 0, "mediciones por bloque (default del nodo: 100)")
+	retencion := fs.String("retencion", "", "tiempo de almacenamiento local antes de migrar (7d, 12h)")
+	tags := flagTags{}
+	fs.Var(tags, "tag", "tag clave=valor (repetible)")
+	resto, err := argumentos(fs, args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	req := solicitudCrearSerie{
+		Path:             resto[1],
+		TipoDatos:        resto[2],
+		Tags:             tags,
+		CompresionBytes:  *compresionBytes,
+		CompresionBloque: *compresionBloque,
+		TamañoBloque:     *tamañoBloque,
+	}
+	if *retencion != "" {
+		duracion, err := tipos.ParsearDuracion(*retencion)
+		if err != nil {
+			return err
+		}
+		req.TiempoAlmacenamiento = int64(duracion)
+	}
+
+	var serie tipos.Serie
+	crudo, err := a.cliente.edgeJSON(ctx, resto[0], http.MethodPost, "/api/series", req, &serie)
+	if err != nil {
+		return err
+	}
+
+	t := &tabla{encabezados: []string{"PATH", "NODO", "TIPO", "COMPRESION_BYTES", "COMPRESION_BLOQUE", "TAMANO_BLOQUE", "RETENCION", "TAGS"}}
+	t.agregar(serie.Path, resto[0], serie.TipoDatos.String(), string(serie.CompresionBytes), string(serie.CompresionBloque),
+		strconv.Itoa(serie.TamañoBloque), formatearRetencion(serie.TiempoAlmacenamiento), formatearTags(serie.Tags))
+	return imprimir(a.salida, a.formato, t, crudo)
+}
+
+func cmdEliminarSerie(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("eliminar-serie", "NODO PATH")
+	resto, err := argumentos(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	if _, err := a.cliente.edgeJSON(ctx, resto[0], http.MethodDelete, "/api/series/"+rutaSerie(resto[1]), nil, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.salida, "serie %s eliminada de %s\n", resto[1], resto[0])
+	return nil
+}
+
+// cmdHabilitarRegla retorna el comando que activa o desactiva una regla
+func cmdHabilitarRegla(activa bool) func(ctx context.Context, a *app, args []string) error {
+	nombre, accion := "habilitar-regla", "habilitar"
+	if !activa {
+		nombre, accion = "deshabilitar-regla", "deshabilitar"
+	}
+	return func(ctx context.Context, a *app, args []string) error {
+		fs := flagsComando(nombre, "NODO ID")
+		resto, err := argumentos(fs, args, 2, 2)
+		if err != nil {
+			return err
+		}
+
+		var regla tipos.Regla
+		ruta := "/api/reglas/" + url.PathEscape(resto[1]) + "/" + accion
+		crudo, err := a.cliente.edgeJSON(ctx, resto[0], http.MethodPost, ruta, nil, &regla)
+		if err != nil {
+			return err
+		}
+
+		t := &tabla{encabezados: []string{"ID", "NODO", "NOMBRE", "ACTIVA"}}
+		t.agregar(regla.ID, resto[0], regla.Nombre, strconv.FormatBool(regla.Activa))
+		return imprimir(a.salida, a.formato, t, crudo)
+	}
+}
+
+// informeMigracion es el informe de una migración de GET /api/migracion del edge
+type informeMigracion struct {
+	Tipo       string   `json:"tipo"`
+	Inicio     int64    `json:"inicio"`
+	Fin        int64    `json:"fin"`
+	Bloques    int      `json:"bloques"`
+	Procesados int      `json:"procesados"`
+	Migrados   int      `json:"migrados"`
+	Reanudados int      `json:"reanudados"`
+	Existentes int      `json:"existentes"`
+	Conflictos int      `json:"conflictos"`
+	Fallidos   int      `json:"fallidos"`
+	Bytes      int64    `json:"bytes"`
+	Errores    []string `json:"errores"`
+}
+
+// estadoMigracion es la respuesta de GET y POST /api/migracion del edge
+type estadoMigracion struct {
+	Solicitada bool              `json:"solicitada"`
+	EnCurso    *informeMigracion `json:"en_curso"`
+	Ultima     *informeMigracion `json:"ultima"`
+	Bloques    map[string]int    `json:"bloques"`
+}
+
+// encabezadosMigracion son las columnas de los informes de migración
+var encabezadosMigracion = []string{"NODO", "ESTADO", "TIPO", "INICIO", "FIN", "BLOQUES", "PROCESADOS",
+	"MIGRADOS", "REANUDADOS", "EXISTENTES", "CONFLICTOS", "FALLIDOS", "BYTES"}
+
+// agregarInformeMigracion agrega el informe como fila de la tabla
+func agregarInformeMigracion(t *tabla, nodo, estado string, informe *informeMigracion) {
+	t.agregar(nodo, estado, informe.Tipo, formatearTiempo(informe.Inicio), formatearTiempo(informe.Fin),
+		strconv.Itoa(informe.Bloques), strconv.Itoa(informe.Procesados), strconv.Itoa(informe.Migrados),
+		strconv.Itoa(informe.Reanudados), strconv.Itoa(informe.Existentes), strconv.Itoa(informe.Conflictos),
+		strconv.Itoa(informe.Fallidos), strconv.FormatInt(informe.Bytes, 10))
+}
+
+// cmdMigrar inicia la migración en el nodo, que la ejecuta en segundo plano, y consulta
+// su estado cada -intervalo hasta que termina. Cada consulta usa el timeout global, por
+// lo que la duración de la migración no está limitada por -timeout.
+func cmdMigrar(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("migrar", "[-intervalo 2s] NODO")
+	intervalo := fs.Duration("intervalo", 2*time.Second, "intervalo entre consultas del estado de la migración")
+	resto, err := argumentos(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if *intervalo <= 0 {
+		return fmt.Errorf("-intervalo debe ser positivo")
+	}
+
+	var estado estadoMigracion
+	if _, err := a.cliente.edgeJSON(ctx, resto[0], http.MethodPost, "/api/migracion", nil, &estado); err != nil {
+		return err
+	}
+
+	for estado.Solicitada {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("espera interrumpida; la migración continúa en %s (swctl migracion %s): %v", resto[0], resto[0], ctx.Err())
+		case <-time.After(*intervalo):
+		}
+		estado = estadoMigracion{}
+		if _, err := a.cliente.edgeJSON(ctx, resto[0], http.MethodGet, "/api/migracion", nil, &estado); err != nil {
+			return err
+		}
+	}
+	if estado.Ultima == nil {
+		return fmt.Errorf("el nodo %s no informó el resultado de la migración", resto[0])
+	}
+	informe := *estado.Ultima
+	crudo, err := json.Marshal(informe)
+	if err != nil {
+		return err
+	}
+
+	t := &tabla{encabezados: encabezadosMigracion}
+	agregarInformeMigracion(t, resto[0], "terminada", &informe)
+	if err := imprimir(a.salida, a.formato, t, crudo); err != nil {
+		return err
+	}
+
+	// Los bloques sin migrar se conservan en el nodo y se reintentan en la próxima migración
+	if sinMigrar := informe.Conflictos + informe.Fallidos; sinMigrar > 0 {
+		for _, detalle := range informe.Errores {
+			fmt.Fprintf(os.Stderr, "aviso: %s\n", detalle)
+		}
+		return fmt.Errorf("migración de %s incompleta: %d bloques sin migrar", resto[0], sinMigrar)
+	}
+	return nil
+}
+
+func cmdMigracion(ctx context.Context, a *app, args []string) error {
+	fs := flagsComando("migracion", "NODO")
+	resto, err := argumentos(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var estado estadoMigracion
+	crudo, err := a.cliente.edgeJSON(ctx, resto[0], http.MethodGet, "/api/migracion", nil, &estado)
+	if err != nil {
+		return err
+	}
+
+	t := &tabla{encabezados: encabezadosMigracion}
+	if estado.EnCurso != nil {
+		agregarInformeMigracion(t, resto[0], "en curso", estado.EnCurso)
+	}
+	if estado.Ultima != nil {
+		agregarInformeMigracion(t, resto[0], "terminada", estado.Ultima)
+	}
+	if err := imprimir(a.salida, a.formato, t, crudo); err != nil {
+		return err
+	}
+
+	// Bloques con la migración iniciada que se retoman en la próxima ejecución
+	if len(estado.Bloques) > 0 {
+		estados := make([]string, 0, len(estado.Bloques))
+		for _, nombre := range []string{"pendiente", "subido", "verificado"} {
+			if n := estado.Bloques[nombre]; n > 0 {
+				estados = append(estados, fmt.Sprintf("%d %s", n, nombre))
+			}
+		}
+		fmt.Fprintf(os.Stderr, "aviso: bloques con la migración sin terminar: %s\n", strings.Join(estados, ", "))
+	}
+	return nil
+}
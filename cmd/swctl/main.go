@@ -0,0 +1,144 @@
+// swctl es la herramienta de línea de comandos para operar SensorWave: lista
+// nodos, series y reglas, ejecuta consultas con tiempos legibles y administra
+// las series, reglas y migración de los nodos edge.
+//
+// Uso:
+//
+//	swctl [opciones globales] COMANDO [opciones del comando] ARGUMENTOS
+//
+// Ejemplos:
+//
+//	swctl nodos
+//	swctl series 'invernadero/*'
+//	swctl rango -desde now-6h invernadero/temperatura
+//	swctl -formato csv agregacion -intervalo 15m -desde now-1d/d 'invernadero/*'
+//	swctl crear-serie -compresion-bytes Xor -retencion 7d -tag unidad=celsius edge-01 invernadero/humedad Real
+//	swctl deshabilitar-regla edge-01 temperatura_alta
+//	swctl migrar edge-01
+//
+// Las consultas y los listados usan la API REST del despachador (autenticada
+// con -token). La administración de series, reglas y migración se envía
+// directamente al nodo: su dirección y la huella de su certificado se obtienen
+// del despachador (o de -edge) y las solicitudes se firman con el secreto
+// compartido de los nodos (-secreto).
+//
+// Los tiempos admiten Unix nanosegundos, RFC3339 o expresiones relativas
+// (now-1h, now-7d, now/d). Las opciones de cada comando van antes de sus
+// argumentos.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Defaults de las opciones globales
+const (
+	despachadorPorDefecto = "http://localhost:8080"
+	timeoutPorDefecto     = 30 * time.Second
+)
+
+func main() {
+	os.Exit(ejecutar(os.Args[1:]))
+}
+
+// ejecutar interpreta la línea de comandos y retorna el código de salida
+func ejecutar(args []string) int {
+	fs := flag.NewFlagSet("swctl", flag.ContinueOnError)
+	despachadorURL := fs.String("despachador", valorEntorno("SENSORWAVE_DESPACHADOR", despachadorPorDefecto), "URL del despachador (default: $SENSORWAVE_DESPACHADOR)")
+	token := fs.String("token", "", "clave API o JWT del despachador (default: $SENSORWAVE_TOKEN)")
+	secreto := fs.String("secreto", "", "secreto compartido con los nodos (default: $SENSORWAVE_SECRETO_AUTENTICACION)")
+	edgeURL := fs.String("edge", "", "URL del nodo para los comandos de administración (default: la registrada en el despachador)")
+	formato := fs.String("formato", formatoTabla, "formato de salida: tabla, csv o json")
+	timeout := fs.Duration("timeout", timeoutPorDefecto, "tiempo máximo de cada solicitud")
+	fs.Usage = func() { uso(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		uso(fs)
+		return 2
+	}
+	if *formato != formatoTabla && *formato != formatoCSV && *formato != formatoJSON {
+		fmt.Fprintf(os.Stderr, "swctl: formato desconocido: %s (use tabla, csv o json)\n", *formato)
+		return 2
+	}
+
+	// Los secretos se leen del entorno después de parsear para que la ayuda no los muestre
+	if *token == "" {
+		*token = os.Getenv("SENSORWAVE_TOKEN")
+	}
+	if *secreto == "" {
+		*secreto = os.Getenv("SENSORWAVE_SECRETO_AUTENTICACION")
+	}
+
+	cmd, existe := buscarComando(fs.Arg(0))
+	if !existe {
+		fmt.Fprintf(os.Stderr, "swctl: comando desconocido: %s\n\n", fs.Arg(0))
+		uso(fs)
+		return 2
+	}
+
+	a := &app{
+		cliente: &cliente{
+			despachador: *despachadorURL,
+			token:       *token,
+			edge:        *edgeURL,
+			timeout:     *timeout,
+			http:        &http.Client{},
+		},
+		formato: *formato,
+		salida:  os.Stdout,
+		ahora:   time.Now(),
+	}
+	if *secreto != "" {
+		a.cliente.secreto = []byte(*secreto)
+	}
+
+	ctx, cancelar := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancelar()
+
+	if err := cmd.ejecutar(ctx, a, fs.Args()[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "swctl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// valorEntorno retorna el valor de la variable o el default si no está definida
+func valorEntorno(variable, porDefecto string) string {
+	if v := os.Getenv(variable); v != "" {
+		return v
+	}
+	return porDefecto
+}
+
+// uso muestra la ayuda con las opciones globales y los comandos
+func uso(fs *flag.FlagSet) {
+	salida := fs.Output()
+	fmt.Fprintln(salida, "Uso: swctl [opciones globales] COMANDO [opciones del comando] ARGUMENTOS")
+	fmt.Fprintln(salida)
+	fmt.Fprintln(salida, "Comandos:")
+	for _, c := range comandos {
+		fmt.Fprintf(salida, "  %-20s %s\n", c.nombre, c.descripcion)
+	}
+	fmt.Fprintln(salida)
+	fmt.Fprintln(salida, "Opciones globales:")
+	fs.PrintDefaults()
+	fmt.Fprintln(salida)
+	fmt.Fprintln(salida, "Ayuda de un comando: swctl COMANDO -h")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Formatos de salida
+const (
+	formatoTabla = "tabla" // Columnas alineadas para la terminal
+	formatoCSV   = "csv"   // CSV con encabezado, para planillas o scripts
+	formatoJSON  = "json"  // Respuesta del servidor tal como llegó (indentada)
+)
+
+// tabla es el resultado de un comando antes de darle formato
+type tabla struct {
+	encabezados []string
+	filas       [][]string
+}
+
+// agregar agrega una fila a la tabla
+func (t *tabla) agregar(celdas ...string) {
+	t.filas = append(t.filas, celdas)
+}
+
+// imprimir escribe la tabla en el formato pedido. En formato json se escribe
+// la respuesta cruda del servidor en lugar de la tabla.
+func imprimir(w io.Writer, formato string, t *tabla, crudo []byte) error {
+	switch formato {
+	case formatoJSON:
+		var indentado bytes.Buffer
+		if err := json.Indent(&indentado, crudo, "", "  "); err != nil {
+			return fmt.Errorf("error formateando JSON: %v", err)
+		}
+		indentado.WriteByte('\n')
+		_, err := w.Write(indentado.Bytes())
+		return err
+
+	case formatoCSV:
+		escritor := csv.NewWriter(w)
+		escritor.Write(t.encabezados)
+		escritor.WriteAll(t.filas)
+		return escritor.Error()
+
+	case formatoTabla, "":
+		escritor := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(escritor, strings.Join(t.encabezados, "\t"))
+		for _, fila := range t.filas {
+			fmt.Fprintln(escritor, strings.Join(fila, "\t"))
+		}
+		return escritor.Flush()
+
+	default:
+		return fmt.Errorf("formato desconocido: %s (use tabla, csv o json)", formato)
+	}
+}
+
+// formatearTiempo muestra un tiempo Unix en nanosegundos como RFC3339 local
+func formatearTiempo(nanos int64) string {
+	if nanos == 0 {
+		return "-"
+	}
+	return time.Unix(0, nanos).Format(time.RFC3339Nano)
+}
+
+// formatearValor muestra un valor de una medición (número, booleano o texto)
+func formatearValor(v interface{}) string {
+	switch valor := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return formatearNumero(valor)
+	case string:
+		return valor
+	default:
+		return fmt.Sprint(valor)
+	}
+}
+
+// formatearNumero muestra un número sin ceros ni exponentes innecesarios
+func formatearNumero(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatearTags muestra los tags como k=v separados por comas, ordenados por clave
+func formatearTags(tags map[string]string) string {
+	pares := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pares = append(pares, k+"="+v)
+	}
+	sort.Strings(pares)
+	return strings.Join(pares, ",")
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cbiale/sensorwave/despachador"
+	"github.com/cbiale/sensorwave/tipos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+// HELPERS
+// ============================================================================
+
+// ahoraTest es la referencia fija de las expresiones relativas en los tests
+var ahoraTest = time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+
+// solicitudRecibida es una solicitud registrada por los servidores de prueba
+type solicitudRecibida struct {
+	metodo string
+	ruta   string
+	cuerpo []byte
+	header http.Header
+}
+
+// servidorPrueba es un despachador o edge de prueba que registra las solicitudes
+// recibidas y responde con el handler de cada ruta ("METODO /ruta")
+type servidorPrueba struct {
+	*httptest.Server
+	mu          sync.Mutex
+	solicitudes []solicitudRecibida
+}
+
+func nuevoServidorPrueba(t *testing.T, handlers map[string]http.HandlerFunc) *servidorPrueba {
+	s := &servidorPrueba{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cuerpo, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(cuerpo))
+		s.mu.Lock()
+		s.solicitudes = append(s.solicitudes, solicitudRecibida{r.Method, r.URL.RequestURI(), cuerpo, r.Header.Clone()})
+		s.mu.Unlock()
+
+		handler, existe := handlers[r.Method+" "+r.URL.Path]
+		if !existe {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// ultima retorna la última solicitud recibida
+func (s *servidorPrueba) ultima(t *testing.T) solicitudRecibida {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.NotEmpty(t, s.solicitudes)
+	return s.solicitudes[len(s.solicitudes)-1]
+}
+
+// responderJSON retorna un handler que responde el valor serializado con el status dado
+func responderJSON(status int, valor interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(valor)
+	}
+}
+
+// appTest crea la app de swctl contra el despachador dado, con la salida en un buffer
+func appTest(despachadorURL, formato string) (*app, *bytes.Buffer) {
+	salida := &bytes.Buffer{}
+	return &app{
+		cliente: &cliente{
+			despachador: despachadorURL,
+			token:       "token-prueba",
+			secreto:     []byte("secreto"),
+			timeout:     5 * time.Second,
+			http:        &http.Client{},
+		},
+		formato: formato,
+		salida:  salida,
+		ahora:   ahoraTest,
+	}, salida
+}
+
+// ejecutarComando ejecuta el comando de swctl con los argumentos dados
+func ejecutarComando(t *testing.T, a *app, nombre string, args ...string) error {
+	cmd, existe := buscarComando(nombre)
+	require.True(t, existe, nombre)
+	return cmd.ejecutar(context.Background(), a, args)
+}
+
+// despachadorConNodo crea un despachador de prueba que publica el nodo en la dirección del edge
+func despachadorConNodo(t *testing.T, nodoID string, edge *servidorPrueba) *servidorPrueba {
+	nodos := []despachador.NodoResponse{{Nodo: tipos.Nodo{NodoID: nodoID, Direccion: edge.URL}}}
+	return nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"GET /api/nodos": responderJSON(http.StatusOK, nodos),
+	})
+}
+
+// ============================================================================
+// TESTS DE CONSULTAS
+// ============================================================================
+
+// TestRango_TiemposRelativosYTabla verifica que las expresiones relativas se resuelven
+// respecto de ahora y que el resultado se muestra con una columna por serie
+func TestRango_TiemposRelativosYTabla(t *testing.T) {
+	t1 := ahoraTest.Add(-time.Hour).UnixNano()
+	t2 := ahoraTest.Add(-30 * time.Minute).UnixNano()
+	servidor := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"POST /api/consulta/rango": responderJSON(http.StatusOK, despachador.ConsultaRangoResponse{
+			Series:  []string{"sala/temp", "sala/hum"},
+			Tiempos: []int64{t1, t2},
+			Valores: [][]interface{}{{21.5, 40.0}, {22.0, nil}},
+		}),
+	})
+	a, salida := appTest(servidor.URL, formatoTabla)
+
+	require.NoError(t, ejecutarComando(t, a, "rango", "-desde", "now-6h", "-hasta", "now/d", "sala/*"))
+
+	solicitud := servidor.ultima(t)
+	assert.Equal(t, "Bearer token-prueba", solicitud.header.Get("Authorization"))
+	var req despachador.ConsultaRangoRequest
+	require.NoError(t, json.Unmarshal(solicitud.cuerpo, &req))
+	assert.Equal(t, "sala/*", req.Serie)
+	assert.Equal(t, tipos.MarcaTiempo(ahoraTest.Add(-6*time.Hour).UnixNano()), req.TiempoInicio)
+	assert.Equal(t, tipos.MarcaTiempo(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC).UnixNano()), req.TiempoFin)
+
+	lineas := strings.Split(strings.TrimSpace(salida.String()), "\n")
+	require.Len(t, lineas, 3)
+	assert.Equal(t, []string{"TIEMPO", "sala/temp", "sala/hum"}, strings.Fields(lineas[0]))
+	assert.Equal(t, []string{formatearTiempo(t1), "21.5", "40"}, strings.Fields(lineas[1]))
+	assert.Equal(t, []string{formatearTiempo(t2), "22"}, strings.Fields(lineas[2]), "valor ausente vacío")
+	t.Log("rango resuelve los tiempos relativos y muestra una columna por serie")
+}
+
+// TestRango_TiemposAbsolutos verifica que se aceptan Unix nanosegundos y RFC3339
+func TestRango_TiemposAbsolutos(t *testing.T) {
+	servidor := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"POST /api/consulta/rango": responderJSON(http.StatusOK, despachador.ConsultaRangoResponse{}),
+	})
+	a, _ := appTest(servidor.URL, formatoTabla)
+
+	require.NoError(t, ejecutarComando(t, a, "rango", "-desde", "1700000000000000000", "-hasta", "2024-01-15T10:30:00-03:00", "sensor"))
+
+	var req despachador.ConsultaRangoRequest
+	require.NoError(t, json.Unmarshal(servidor.ultima(t).cuerpo, &req))
+	assert.Equal(t, tipos.MarcaTiempo(1700000000000000000), req.TiempoInicio)
+	assert.Equal(t, tipos.MarcaTiempo(time.Date(2024, 1, 15, 13, 30, 0, 0, time.UTC).UnixNano()), req.TiempoFin)
+
+	err := ejecutarComando(t, a, "rango", "-desde", "ayer", "sensor")
+	assert.Error(t, err, "expresión de tiempo inválida")
+	t.Log("rango acepta tiempos absolutos y rechaza expresiones inválidas")
+}
+
+// TestUltimo_SinLimitesOmiteTiempos verifica que sin -desde ni -hasta la solicitud no
+// incluye los tiempos
+func TestUltimo_SinLimitesOmiteTiempos(t *testing.T) {
+	servidor := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"POST /api/consulta/ultimo": responderJSON(http.StatusOK, despachador.ConsultaUltimoResponse{
+			Series:  []string{"puerta/abierta"},
+			Tiempos: []int64{ahoraTest.UnixNano()},
+			Valores: []interface{}{true},
+		}),
+	})
+	a, salida := appTest(servidor.URL, formatoTabla)
+
+	require.NoError(t, ejecutarComando(t, a, "ultimo", "puerta/*"))
+
+	assert.JSONEq(t, `{"serie":"puerta/*"}`, string(servidor.ultima(t).cuerpo))
+	lineas := strings.Split(strings.TrimSpace(salida.String()), "\n")
+	require.Len(t, lineas, 2)
+	assert.Equal(t, []string{"puerta/abierta", formatearTiempo(ahoraTest.UnixNano()), "true"}, strings.Fields(lineas[1]))
+	t.Log("ultimo omite los tiempos no indicados")
+}
+
+// TestAgregacionTemporal_CSV verifica la solicitud con intervalo y la salida CSV con una
+// fila por intervalo y serie
+func TestAgregacionTemporal_CSV(t *testing.T) {
+	t1 := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC).UnixNano()
+	servidor := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"POST /api/consulta/agregacion-temporal": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"series":["a","b"],"tiempos":[`+jsonEntero(t1)+`],"agregaciones":["promedio","count"],`+
+				`"valores":[[[1.5,null]],[[3,0]]]}`)
+		},
+	})
+	a, salida := appTest(servidor.URL, formatoCSV)
+
+	require.NoError(t, ejecutarComando(t, a, "agregacion", "-agregaciones", "promedio, count", "-intervalo", "15m",
+		"-desde", "now-1d/d", "-hasta", "now", "sensor/*"))
+
+	var req despachador.ConsultaAgregacionTemporalRequest
+	require.NoError(t, json.Unmarshal(servidor.ultima(t).cuerpo, &req))
+	assert.Equal(t, []string{"promedio", "count"}, req.Agregaciones)
+	assert.Equal(t, tipos.Duracion(15*time.Minute), req.Intervalo)
+	assert.Equal(t, tipos.MarcaTiempo(t1), req.TiempoInicio)
+
+	filas, err := csv.NewReader(salida).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"TIEMPO", "SERIE", "promedio", "count"},
+		{formatearTiempo(t1), "a", "1.5", "3"},
+		{formatearTiempo(t1), "b", "", "0"},
+	}, filas)
+	t.Log("agregacion con intervalo arma la solicitud y escribe un CSV por intervalo y serie")
+}
+
+// TestAgregacion_JSONCrudo verifica que sin intervalo se usa la agregación simple y que el
+// formato json escribe la respuesta del servidor indentada
+func TestAgregacion_JSONCrudo(t *testing.T) {
+	servidor := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"POST /api/consulta/agregacion": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"series":["a"],"agregaciones":["maximo"],"valores":[[7]]}`)
+		},
+	})
+	a, salida := appTest(servidor.URL, formatoJSON)
+
+	require.NoError(t, ejecutarComando(t, a, "agregacion", "-agregaciones", "maximo", "a"))
+
+	assert.Equal(t, "/api/consulta/agregacion", servidor.ultima(t).ruta)
+	assert.Equal(t, "{\n  \"series\": [\n    \"a\"\n  ],\n  \"agregaciones\": [\n    \"maximo\"\n  ],\n  \"valores\": [\n    [\n      7\n    ]\n  ]\n}\n", salida.String())
+	t.Log("agregacion sin intervalo escribe la respuesta cruda en formato json")
+}
+
+// TestListados_ErrorDelServidor verifica que el mensaje de error del despachador se
+// informa con su status
+func TestListados_ErrorDelServidor(t *testing.T) {
+	servidor := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"GET /api/series": responderJSON(http.StatusUnauthorized, errorRespuesta{Error: "token inválido"}),
+	})
+	a, _ := appTest(servidor.URL, formatoTabla)
+
+	err := ejecutarComando(t, a, "series", "sala/*")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "token inválido")
+	assert.Contains(t, err.Error(), "status 401")
+	assert.Equal(t, "/api/series?patron=sala%2F%2A", servidor.ultima(t).ruta)
+	t.Log("Los errores del despachador se informan con su mensaje y status")
+}
+
+// TestNodos_Tabla verifica el listado de nodos
+func TestNodos_Tabla(t *testing.T) {
+	servidor := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"GET /api/nodos": responderJSON(http.StatusOK, []despachador.NodoResponse{{
+			Nodo:     tipos.Nodo{NodoID: "edge-01", Direccion: "10.0.0.5:8081", Version: "1.2.0"},
+			Salud:    despachador.SaludNodo{Estado: despachador.CircuitoCerrado},
+			Conexion: despachador.ConexionEnLinea,
+		}}),
+	})
+	a, salida := appTest(servidor.URL, formatoCSV)
+
+	require.NoError(t, ejecutarComando(t, a, "nodos"))
+
+	filas, err := csv.NewReader(salida).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, filas, 2)
+	assert.Equal(t, []string{"edge-01", "http://10.0.0.5:8081", "en_linea", string(despachador.CircuitoCerrado)}, filas[1][:4])
+	assert.Equal(t, "1.2.0", filas[1][len(filas[1])-1])
+	t.Log("nodos lista los nodos registrados")
+}
+
+// ============================================================================
+// TESTS DE ADMINISTRACIÓN DE NODOS
+// ============================================================================
+
+// TestCrearSerie_SolicitudFirmada verifica que la administración se envía al nodo
+// publicado por el despachador, firmada con el secreto compartido
+func TestCrearSerie_SolicitudFirmada(t *testing.T) {
+	verificador := tipos.NuevoVerificadorSolicitudes("edge-01", []byte("secreto"), 0)
+	edge := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"POST /api/series": func(w http.ResponseWriter, r *http.Request) {
+			cuerpo, _ := io.ReadAll(r.Body)
+			if err := verificador.Verificar(r, cuerpo); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			responderJSON(http.StatusOK, tipos.Serie{Path: "sala/hum", TipoDatos: tipos.Real, TamañoBloque: 100,
+				TiempoAlmacenamiento: int64(7 * 24 * time.Hour), Tags: map[string]string{"unidad": "%"}})(w, r)
+		},
+	})
+	servidor := despachadorConNodo(t, "edge-01", edge)
+	a, salida := appTest(servidor.URL, formatoTabla)
+
+	require.NoError(t, ejecutarComando(t, a, "crear-serie", "-compresion-bytes", "Xor", "-retencion", "7d",
+		"-tag", "unidad=%", "-tag", "piso=1", "edge-01", "sala/hum", "Real"))
+
+	var req solicitudCrearSerie
+	require.NoError(t, json.Unmarshal(edge.ultima(t).cuerpo, &req))
+	assert.Equal(t, solicitudCrearSerie{
+		Path:                 "sala/hum",
+		TipoDatos:            "Real",
+		Tags:                 map[string]string{"unidad": "%", "piso": "1"},
+		CompresionBytes:      "Xor",
+		TiempoAlmacenamiento: int64(7 * 24 * time.Hour),
+	}, req)
+	assert.Contains(t, salida.String(), "edge-01")
+
+	// Sin el secreto del nodo la solicitud se rechaza
+	a.cliente.secreto = []byte("otro")
+	err := ejecutarComando(t, a, "crear-serie", "edge-01", "sala/hum", "Real")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status 401")
+
+	err = ejecutarComando(t, a, "crear-serie", "-tag", "sin-valor", "edge-01", "sala/hum", "Real")
+	assert.Error(t, err, "tag sin =")
+	t.Log("crear-serie envía la solicitud firmada al nodo publicado por el despachador")
+}
+
+// TestEliminarSerie_EdgeDirectoYRutaEscapada verifica que con -edge no se consulta al
+// despachador y que cada segmento del path se escapa
+func TestEliminarSerie_EdgeDirectoYRutaEscapada(t *testing.T) {
+	edge := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"DELETE /api/series/sala 1/temp?": func(w http.ResponseWriter, r *http.Request) {},
+	})
+	a, salida := appTest("http://127.0.0.1:1", formatoTabla)
+	a.cliente.edge = edge.URL + "/"
+
+	require.NoError(t, ejecutarComando(t, a, "eliminar-serie", "edge-01", "sala 1/temp?"))
+
+	assert.Equal(t, "/api/series/sala%201/temp%3F", edge.ultima(t).ruta)
+	assert.Equal(t, "serie sala 1/temp? eliminada de edge-01\n", salida.String())
+	t.Log("eliminar-serie usa -edge y escapa los segmentos del path")
+}
+
+// TestAdministracion_NodoSoloTunel verifica que un nodo alcanzable solo por túnel
+// requiere -edge
+func TestAdministracion_NodoSoloTunel(t *testing.T) {
+	servidor := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"GET /api/nodos": responderJSON(http.StatusOK, []despachador.NodoResponse{{Nodo: tipos.Nodo{NodoID: "edge-01", Tunel: true}}}),
+	})
+	a, _ := appTest(servidor.URL, formatoTabla)
+
+	err := ejecutarComando(t, a, "habilitar-regla", "edge-01", "r1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-edge")
+
+	err = ejecutarComando(t, a, "habilitar-regla", "edge-02", "r1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nodo no encontrado")
+	t.Log("Los nodos solo accesibles por túnel requieren -edge")
+}
+
+// TestMigrar_EsperaElFin verifica que migrar inicia la migración, consulta el estado
+// hasta que termina y muestra el informe
+func TestMigrar_EsperaElFin(t *testing.T) {
+	var consultas int
+	informe := informeMigracion{Tipo: "por_tiempo", Inicio: 1, Fin: 2, Bloques: 3, Procesados: 3, Migrados: 3, Bytes: 300}
+	edge := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"POST /api/migracion": responderJSON(http.StatusAccepted, estadoMigracion{Solicitada: true}),
+		"GET /api/migracion": func(w http.ResponseWriter, r *http.Request) {
+			consultas++
+			if consultas < 3 {
+				responderJSON(http.StatusOK, estadoMigracion{Solicitada: true, EnCurso: &informeMigracion{Tipo: "por_tiempo"}})(w, r)
+				return
+			}
+			responderJSON(http.StatusOK, estadoMigracion{Ultima: &informe})(w, r)
+		},
+	})
+	servidor := despachadorConNodo(t, "edge-01", edge)
+	a, salida := appTest(servidor.URL, formatoCSV)
+
+	require.NoError(t, ejecutarComando(t, a, "migrar", "-intervalo", "1ms", "edge-01"))
+
+	assert.Equal(t, 3, consultas)
+	filas, err := csv.NewReader(salida).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, filas, 2)
+	assert.Equal(t, encabezadosMigracion, filas[0])
+	assert.Equal(t, []string{"edge-01", "terminada", "por_tiempo"}, filas[1][:3])
+	assert.Equal(t, "300", filas[1][len(filas[1])-1])
+	t.Log("migrar espera a que termine la migración en segundo plano")
+}
+
+// TestMigrar_Incompleta verifica que una migración con bloques sin migrar retorna error
+// y que una migración ya solicitada se informa
+func TestMigrar_Incompleta(t *testing.T) {
+	var enCurso bool
+	edge := nuevoServidorPrueba(t, map[string]http.HandlerFunc{
+		"POST /api/migracion": func(w http.ResponseWriter, r *http.Request) {
+			if enCurso {
+				http.Error(w, "ya hay una migración solicitada en curso", http.StatusConflict)
+				return
+			}
+			enCurso = true
+			responderJSON(http.StatusAccepted, estadoMigracion{Solicitada: true})(w, r)
+		},
+		"GET /api/migracion": responderJSON(http.StatusOK, estadoMigracion{
+			Ultima: &informeMigracion{Tipo: "por_tiempo", Bloques: 2, Migrados: 1, Fallidos: 1, Errores: []string{"sin conexión"}},
+		}),
+	})
+	a, _ := appTest("http://127.0.0.1:1", formatoTabla)
+	a.cliente.edge = edge.URL
+
+	err := ejecutarComando(t, a, "migrar", "-intervalo", "1ms", "edge-01")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 bloques sin migrar")
+
+	err = ejecutarComando(t, a, "migrar", "edge-01")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status 409")
+	t.Log("migrar informa las migraciones incompletas y las ya solicitadas")
+}
+
+// ============================================================================
+// TESTS DE LA LÍNEA DE COMANDOS
+// ============================================================================
+
+// TestEjecutar_CodigosDeSalida verifica los códigos de salida ante errores de uso
+func TestEjecutar_CodigosDeSalida(t *testing.T) {
+	assert.Equal(t, 2, ejecutar(nil), "sin comando")
+	assert.Equal(t, 2, ejecutar([]string{"desconocido"}))
+	assert.Equal(t, 2, ejecutar([]string{"-formato", "xml", "nodos"}))
+	assert.Equal(t, 0, ejecutar([]string{"-h"}))
+	assert.Equal(t, 0, ejecutar([]string{"rango", "-h"}))
+	assert.Equal(t, 1, ejecutar([]string{"rango"}), "falta la serie")
+	t.Log("ejecutar retorna 2 ante errores de uso y 1 ante errores del comando")
+}
+
+// TestFormatear verifica el formato de números, tags y retención
+func TestFormatear(t *testing.T) {
+	assert.Equal(t, "0.1", formatearNumero(0.1))
+	assert.Equal(t, "1e+21", formatearNumero(1e21))
+	assert.Equal(t, "", formatearValor(nil))
+	assert.Equal(t, "a=1,b=2", formatearTags(map[string]string{"b": "2", "a": "1"}))
+	assert.Equal(t, "-", formatearTiempo(0))
+	t.Log("Los valores se muestran sin ceros ni exponentes innecesarios")
+}
+
+// jsonEntero serializa un entero para armar respuestas JSON literales
+func jsonEntero(v int64) string {
+	datos, _ := json.Marshal(v)
+	return string(datos)
+}
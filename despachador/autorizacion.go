@@ -0,0 +1,465 @@
+package despachador
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// AUTORIZACIÓN DE LA API REST
+// Las solicitudes se autentican con una clave API ("Authorization: Bearer swk_...")
+// o con un JWT HS256 firmado con OpcionesAutorizacion.SecretoJWT. Cada credencial
+// tiene un rol (lector < operador < administrador) y puede restringirse a patrones
+// de series y a nodos con determinados tags. Las claves API se guardan en S3 en
+// claves_api/<id>.json (solo el hash del secreto) y se sincronizan junto con el
+// registro de nodos.
+// ============================================================================
+
+// Prefijo de las claves API en S3
+const prefijoClavesAPI = "claves_api/"
+
+// Prefijo de los tokens de claves API: swk_<id>_<secreto>
+const prefijoTokenClaveAPI = "swk_"
+
+// Rol determina las operaciones permitidas a una credencial
+type Rol string
+
+const (
+	RolLector        Rol = "lector"        // Consulta nodos, series, datos y reglas
+	RolOperador      Rol = "operador"      // Además, opera sobre los nodos
+	RolAdministrador Rol = "administrador" // Además, inscribe nodos y gestiona claves API
+)
+
+// nivel ordena los roles (0 = desconocido)
+func (r Rol) nivel() int {
+	switch r {
+	case RolLector:
+		return 1
+	case RolOperador:
+		return 2
+	case RolAdministrador:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Incluye indica si el rol tiene al menos los permisos de otro
+func (r Rol) Incluye(otro Rol) bool {
+	return r.nivel() > 0 && r.nivel() >= otro.nivel()
+}
+
+// ErrAccesoDenegado indica que la credencial no tiene acceso al recurso
+var ErrAccesoDenegado = errors.New("acceso denegado")
+
+// OpcionesAutorizacion configura la autenticación de la API REST
+type OpcionesAutorizacion struct {
+	// Habilitada exige credenciales en los handlers envueltos con RequerirRol.
+	// false = API abierta (compatibilidad con instalaciones anteriores).
+	Habilitada bool
+
+	// ClaveMaestra es un token con rol administrador y sin restricciones, para crear
+	// las primeras claves API. "" = sin clave maestra.
+	ClaveMaestra string
+
+	// SecretoJWT habilita JWT HS256 firmados con este secreto. "" = sin JWT.
+	SecretoJWT string
+}
+
+// ClaveAPI es una clave de acceso a la API REST guardada en S3
+type ClaveAPI struct {
+	ID          string            `json:"id"`
+	Nombre      string            `json:"nombre"`
+	HashSecreto string            `json:"hash_secreto"` // SHA-256 (hex) del secreto
+	Rol         Rol               `json:"rol"`
+	Series      []string          `json:"series,omitempty"`    // Patrones de series permitidos (vacío = todas)
+	TagsNodo    map[string]string `json:"tags_nodo,omitempty"` // Tags requeridos en el nodo (vacío = todos)
+	Creada      int64             `json:"creada"`              // Unix nanosegundos
+	Expira      int64             `json:"expira,omitempty"`    // Unix nanosegundos (0 = no expira)
+}
+
+// Permisos son los permisos de la credencial de una solicitud
+type Permisos struct {
+	Sujeto   string // ID de la clave API o sujeto del JWT
+	Rol      Rol
+	Series   []string          // Patrones de series permitidos (vacío = todas)
+	TagsNodo map[string]string // Tags requeridos en el nodo (vacío = todos)
+}
+
+// PermiteSerie indica si la serie (o patrón) está dentro de los patrones permitidos.
+// Permisos nil no restringe.
+func (p *Permisos) PermiteSerie(path string) bool {
+	if p == nil || len(p.Series) == 0 {
+		return true
+	}
+	for _, patron := range p.Series {
+		if tipos.MatchPath(path, patron) {
+			return true
+		}
+	}
+	return false
+}
+
+// PermiteNodo indica si el nodo tiene todos los tags requeridos. Permisos nil no restringe.
+func (p *Permisos) PermiteNodo(nodo tipos.Nodo) bool {
+	if p == nil {
+		return true
+	}
+	for clave, valor := range p.TagsNodo {
+		if nodo.Tags[clave] != valor {
+			return false
+		}
+	}
+	return true
+}
+
+// PermiteRegla indica si la regla es visible: su nodo y todas las series de sus
+// condiciones deben estar permitidos
+func (p *Permisos) PermiteRegla(regla tipos.Regla, nodo tipos.Nodo) bool {
+	if !p.PermiteNodo(nodo) {
+		return false
+	}
+	for _, condicion := range regla.Condiciones {
+		if !p.PermiteSerie(condicion.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// autorizador mantiene las claves API sincronizadas desde S3.
+// Un autorizador nil deja la API abierta.
+type autorizador struct {
+	opts OpcionesAutorizacion
+
+	muS3     sync.Mutex // Serializa la sincronización, la creación y la revocación
+	objetos  map[string]objetoSincronizado[ClaveAPI]
+	mu       sync.RWMutex
+	clavesID map[string]ClaveAPI // ID → clave
+}
+
+// nuevoAutorizador crea el autorizador (nil si la autorización está deshabilitada)
+func nuevoAutorizador(opts OpcionesAutorizacion) *autorizador {
+	if !opts.Habilitada {
+		return nil
+	}
+	return &autorizador{opts: opts, clavesID: make(map[string]ClaveAPI)}
+}
+
+// sincronizarClavesAPI actualiza las claves API con las guardadas en S3
+func (m *ManagerDespachador) sincronizarClavesAPI(ctx context.Context) error {
+	a := m.autorizacion
+	if a == nil {
+		return nil
+	}
+	a.muS3.Lock()
+	defer a.muS3.Unlock()
+
+	objetos, err := tipos.ListarObjetos(ctx, m.almacenamiento, prefijoClavesAPI)
+	if err != nil {
+		return fmt.Errorf("error listando claves API desde S3: %v", err)
+	}
+	a.objetos, _ = sincronizarObjetos(ctx, m, objetos, a.objetos, "clave API")
+
+	claves := make(map[string]ClaveAPI, len(a.objetos))
+	for _, objeto := range a.objetos {
+		claves[objeto.valor.ID] = objeto.valor
+	}
+	a.mu.Lock()
+	a.clavesID = claves
+	a.mu.Unlock()
+	return nil
+}
+
+// autenticar obtiene los permisos de la credencial de la solicitud
+func (a *autorizador) autenticar(r *http.Request) (*Permisos, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, errors.New("credencial requerida")
+	}
+
+	switch {
+	case a.opts.ClaveMaestra != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.opts.ClaveMaestra)) == 1:
+		return &Permisos{Sujeto: "clave-maestra", Rol: RolAdministrador}, nil
+	case strings.HasPrefix(token, prefijoTokenClaveAPI):
+		return a.autenticarClaveAPI(token)
+	case a.opts.SecretoJWT != "" && strings.Count(token, ".") == 2:
+		return verificarJWT(token, []byte(a.opts.SecretoJWT), time.Now())
+	default:
+		return nil, errors.New("credencial inválida")
+	}
+}
+
+// autenticarClaveAPI verifica un token swk_<id>_<secreto>
+func (a *autorizador) autenticarClaveAPI(token string) (*Permisos, error) {
+	id, secreto, ok := strings.Cut(strings.TrimPrefix(token, prefijoTokenClaveAPI), "_")
+	if !ok {
+		return nil, errors.New("clave API mal formada")
+	}
+
+	a.mu.RLock()
+	clave, existe := a.clavesID[id]
+	a.mu.RUnlock()
+
+	hash := sha256.Sum256([]byte(secreto))
+	if !existe || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(clave.HashSecreto)) != 1 {
+		return nil, errors.New("clave API inválida")
+	}
+	if clave.Expira != 0 && time.Now().UnixNano() > clave.Expira {
+		return nil, errors.New("clave API expirada")
+	}
+	return &Permisos{Sujeto: clave.ID, Rol: clave.Rol, Series: clave.Series, TagsNodo: clave.TagsNodo}, nil
+}
+
+// reclamosJWT son los reclamos reconocidos de un JWT
+type reclamosJWT struct {
+	Sujeto   string            `json:"sub"`
+	Expira   int64             `json:"exp"` // Unix segundos (requerido)
+	Rol      Rol               `json:"rol"`
+	Series   []string          `json:"series,omitempty"`
+	TagsNodo map[string]string `json:"tags_nodo,omitempty"`
+}
+
+// verificarJWT verifica un JWT HS256 y retorna sus permisos
+func verificarJWT(token string, secreto []byte, ahora time.Time) (*Permisos, error) {
+	partes := strings.Split(token, ".")
+	if len(partes) != 3 {
+		return nil, errors.New("JWT mal formado")
+	}
+
+	encabezadoJSON, err := base64.RawURLEncoding.DecodeString(partes[0])
+	if err != nil {
+		return nil, errors.New("JWT mal formado")
+	}
+	var encabezado struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(encabezadoJSON, &encabezado); err != nil || encabezado.Alg != "HS256" {
+		return nil, errors.New("JWT con algoritmo no soportado")
+	}
+
+	firma, err := base64.RawURLEncoding.DecodeString(partes[2])
+	if err != nil {
+		return nil, errors.New("JWT mal formado")
+	}
+	mac := hmac.New(sha256.New, secreto)
+	mac.Write([]byte(partes[0] + "." + partes[1]))
+	if !hmac.Equal(firma, mac.Sum(nil)) {
+		return nil, errors.New("firma del JWT inválida")
+	}
+
+	reclamosJSON, err := base64.RawURLEncoding.DecodeString(partes[1])
+	if err != nil {
+		return nil, errors.New("JWT mal formado")
+	}
+	var reclamos reclamosJWT
+	if err := json.Unmarshal(reclamosJSON, &reclamos); err != nil {
+		return nil, errors.New("reclamos del JWT inválidos")
+	}
+	if reclamos.Expira == 0 || ahora.Unix() >= reclamos.Expira {
+		return nil, errors.New("JWT expirado o sin expiración")
+	}
+	if reclamos.Rol.nivel() == 0 {
+		return nil, fmt.Errorf("rol del JWT desconocido: %q", reclamos.Rol)
+	}
+	return &Permisos{Sujeto: reclamos.Sujeto, Rol: reclamos.Rol, Series: reclamos.Series, TagsNodo: reclamos.TagsNodo}, nil
+}
+
+// ============================================================================
+// MIDDLEWARE
+// ============================================================================
+
+// clavePermisos es la clave de los permisos en el contexto de la solicitud
+type clavePermisos struct{}
+
+// permisosDe retorna los permisos de la solicitud (nil = sin restricciones)
+func permisosDe(r *http.Request) *Permisos {
+	permisos, _ := r.Context().Value(clavePermisos{}).(*Permisos)
+	return permisos
+}
+
+// RequerirRol envuelve un handler exigiendo una credencial con al menos el rol indicado.
+// Los permisos quedan en el contexto y los handlers aplican las restricciones de series
+// y nodos. Sin autorización habilitada el handler se invoca sin restricciones.
+func RequerirRol(manager *ManagerDespachador, rol Rol, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if manager.autorizacion == nil {
+			handler(w, r)
+			return
+		}
+
+		permisos, err := manager.autorizacion.autenticar(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sensorwave"`)
+			EnviarError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if !permisos.Rol.Incluye(rol) {
+			EnviarError(w, http.StatusForbidden, fmt.Sprintf("se requiere rol %s", rol))
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), clavePermisos{}, permisos)))
+	}
+}
+
+// autorizarSerie verifica que todas las series que resuelve el path (exacto o patrón)
+// estén permitidas. Si no hay series, la consulta informa el error habitual.
+func (m *ManagerDespachador) autorizarSerie(permisos *Permisos, path string) error {
+	if permisos == nil {
+		return nil
+	}
+	series, err := m.buscarSeriesPorPath(path)
+	if err != nil {
+		return nil
+	}
+	for _, s := range series {
+		if !permisos.PermiteSerie(s.path) || !permisos.PermiteNodo(s.nodo) {
+			return fmt.Errorf("%w a la serie '%s'", ErrAccesoDenegado, s.path)
+		}
+	}
+	return nil
+}
+
+// autorizarNodo verifica que el nodo esté permitido
+func (m *ManagerDespachador) autorizarNodo(permisos *Permisos, nodoID string) error {
+	if permisos == nil {
+		return nil
+	}
+	m.mu.RLock()
+	nodo, existe := m.nodos[nodoID]
+	m.mu.RUnlock()
+	if existe && !permisos.PermiteNodo(*nodo) {
+		return fmt.Errorf("%w al nodo '%s'", ErrAccesoDenegado, nodoID)
+	}
+	return nil
+}
+
+// nodoPorID retorna el nodo registrado (solo con su ID si ya no existe)
+func (m *ManagerDespachador) nodoPorID(nodoID string) tipos.Nodo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if nodo, existe := m.nodos[nodoID]; existe {
+		return *nodo
+	}
+	return tipos.Nodo{NodoID: nodoID}
+}
+
+// ============================================================================
+// GESTIÓN DE CLAVES API
+// ============================================================================
+
+// CrearClaveAPI crea una clave API con el nombre, rol y restricciones de la plantilla.
+// Retorna la clave guardada y el token, que solo se conoce en este momento.
+func (m *ManagerDespachador) CrearClaveAPI(plantilla ClaveAPI) (ClaveAPI, string, error) {
+	a := m.autorizacion
+	if a == nil {
+		return ClaveAPI{}, "", errors.New("autorización no habilitada")
+	}
+	if plantilla.Rol.nivel() == 0 {
+		return ClaveAPI{}, "", fmt.Errorf("rol desconocido: %q", plantilla.Rol)
+	}
+	for _, patron := range plantilla.Series {
+		if patron == "" {
+			return ClaveAPI{}, "", errors.New("patrón de serie vacío")
+		}
+	}
+
+	aleatorio := make([]byte, 8+32)
+	if _, err := rand.Read(aleatorio); err != nil {
+		return ClaveAPI{}, "", fmt.Errorf("error generando clave API: %v", err)
+	}
+	secreto := base64.RawURLEncoding.EncodeToString(aleatorio[8:])
+	hash := sha256.Sum256([]byte(secreto))
+
+	clave := plantilla
+	clave.ID = hex.EncodeToString(aleatorio[:8])
+	clave.HashSecreto = hex.EncodeToString(hash[:])
+	clave.Creada = time.Now().UnixNano()
+
+	datos, err := json.Marshal(clave)
+	if err != nil {
+		return ClaveAPI{}, "", fmt.Errorf("error serializando clave API: %v", err)
+	}
+
+	a.muS3.Lock()
+	defer a.muS3.Unlock()
+
+	_, err = m.almacenamiento.Guardar(context.TODO(), prefijoClavesAPI+clave.ID+".json", datos,
+		tipos.OpcionesGuardar{TipoContenido: "application/json"})
+	if err != nil {
+		return ClaveAPI{}, "", fmt.Errorf("error guardando clave API en S3: %v", err)
+	}
+
+	a.mu.Lock()
+	a.clavesID[clave.ID] = clave
+	a.mu.Unlock()
+
+	log.Printf("Clave API %s (%s) creada con rol %s", clave.ID, clave.Nombre, clave.Rol)
+	return clave, prefijoTokenClaveAPI + clave.ID + "_" + secreto, nil
+}
+
+// RevocarClaveAPI elimina una clave API
+func (m *ManagerDespachador) RevocarClaveAPI(id string) error {
+	a := m.autorizacion
+	if a == nil {
+		return errors.New("autorización no habilitada")
+	}
+	a.muS3.Lock()
+	defer a.muS3.Unlock()
+
+	a.mu.RLock()
+	_, existe := a.clavesID[id]
+	a.mu.RUnlock()
+	if !existe {
+		return fmt.Errorf("clave API '%s' no encontrada", id)
+	}
+
+	clave := prefijoClavesAPI + id + ".json"
+	if err := m.almacenamiento.Eliminar(context.TODO(), clave); err != nil {
+		return fmt.Errorf("error eliminando clave API de S3: %v", err)
+	}
+
+	a.mu.Lock()
+	delete(a.clavesID, id)
+	a.mu.Unlock()
+	delete(a.objetos, clave)
+
+	log.Printf("Clave API %s revocada", id)
+	return nil
+}
+
+// ListarClavesAPI retorna las claves API ordenadas por ID
+func (m *ManagerDespachador) ListarClavesAPI() []ClaveAPI {
+	a := m.autorizacion
+	if a == nil {
+		return nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	claves := make([]ClaveAPI, 0, len(a.clavesID))
+	for _, clave := range a.clavesID {
+		claves = append(claves, clave)
+	}
+	sort.Slice(claves, func(i, j int) bool {
+		return claves[i].ID < claves[j].ID
+	})
+	return claves
+}
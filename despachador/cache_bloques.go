@@ -0,0 +1,381 @@
+package despachador
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// CACHE DE BLOQUES S3
+// LRU en memoria de bloques descomprimidos con un segundo nivel opcional en
+// disco (bloques comprimidos). Las entradas se identifican por clave S3 y ETag:
+// un bloque con otro ETag se considera distinto y reemplaza al anterior.
+// ============================================================================
+
+const (
+	// Tamaño por defecto del nivel en memoria (bloques descomprimidos)
+	tamañoMemoriaCachePorDefecto = 64 << 20 // 64 MiB
+	// Tamaño por defecto del nivel en disco (bloques comprimidos)
+	tamañoDiscoCachePorDefecto = 1 << 30 // 1 GiB
+	// Tamaño estimado en memoria de una medición (tiempo + interface + valor)
+	tamañoEstimadoMedicion = 40
+	// Extensión de los archivos del nivel en disco
+	extensionBloqueCache = ".bloque"
+)
+
+// OpcionesCacheBloques configura la cache de bloques descargados de S3
+type OpcionesCacheBloques struct {
+	// TamañoMemoria es el tamaño máximo estimado en bytes de los bloques descomprimidos
+	// en memoria. 0 usa el valor por defecto (64 MiB); un valor negativo deshabilita la cache.
+	TamañoMemoria int64
+
+	// Directorio habilita el nivel en disco con los bloques comprimidos desalojados
+	// de memoria. Vacío = sin nivel en disco. Los archivos previos se descartan al iniciar.
+	Directorio string
+
+	// TamañoDisco es el tamaño máximo en bytes del nivel en disco. 0 = 1 GiB.
+	TamañoDisco int64
+}
+
+// EstadisticasCacheBloques contiene los contadores de la cache de bloques
+type EstadisticasCacheBloques struct {
+	AciertosMemoria int64   `json:"aciertos_memoria"` // Bloques servidos desde memoria
+	AciertosDisco   int64   `json:"aciertos_disco"`   // Bloques servidos desde disco
+	Revalidaciones  int64   `json:"revalidaciones"`   // Aciertos confirmados por S3 (304 Not Modified)
+	Fallos          int64   `json:"fallos"`           // Bloques descargados de S3
+	TasaAciertos    float64 `json:"tasa_aciertos"`    // Aciertos / (aciertos + fallos)
+	EntradasMemoria int     `json:"entradas_memoria"`
+	BytesMemoria    int64   `json:"bytes_memoria"`
+	EntradasDisco   int     `json:"entradas_disco"`
+	BytesDisco      int64   `json:"bytes_disco"`
+}
+
+// entradaCacheBloque es un bloque en alguno de los niveles de la cache
+type entradaCacheBloque struct {
+	clave      string
+	etag       string
+	mediciones []tipos.Medicion // Nivel memoria: bloque descomprimido
+	comprimido []byte           // Nivel memoria: se conserva para desalojar a disco
+	archivo    string           // Nivel disco: ruta del bloque comprimido
+	tamaño     int64
+}
+
+// cacheBloques es una cache LRU de dos niveles para bloques de S3
+type cacheBloques struct {
+	mu sync.Mutex
+
+	maxMemoria   int64
+	bytesMemoria int64
+	memoria      *list.List               // Frente = usado más recientemente
+	indice       map[string]*list.Element // clave S3 -> entrada en memoria
+
+	directorio string
+	maxDisco   int64
+	bytesDisco int64
+	disco      *list.List
+	indiceDisc map[string]*list.Element // clave S3 -> entrada en disco
+
+	aciertosMemoria int64
+	aciertosDisco   int64
+	revalidaciones  int64
+	fallos          int64
+}
+
+// nuevaCacheBloques crea la cache según las opciones. Retorna nil si está deshabilitada.
+func nuevaCacheBloques(opts OpcionesCacheBloques) (*cacheBloques, error) {
+	if opts.TamañoMemoria < 0 {
+		return nil, nil
+	}
+
+	c := &cacheBloques{
+		maxMemoria: opts.TamañoMemoria,
+		memoria:    list.New(),
+		indice:     make(map[string]*list.Element),
+		disco:      list.New(),
+		indiceDisc: make(map[string]*list.Element),
+	}
+	if c.maxMemoria == 0 {
+		c.maxMemoria = tamañoMemoriaCachePorDefecto
+	}
+
+	if opts.Directorio != "" {
+		if err := os.MkdirAll(opts.Directorio, 0o755); err != nil {
+			return nil, fmt.Errorf("error creando directorio de cache %s: %v", opts.Directorio, err)
+		}
+		// El índice del nivel en disco vive en memoria: los archivos de ejecuciones previas se descartan
+		previos, _ := filepath.Glob(filepath.Join(opts.Directorio, "*"+extensionBloqueCache))
+		for _, archivo := range previos {
+			os.Remove(archivo)
+		}
+		c.directorio = opts.Directorio
+		c.maxDisco = opts.TamañoDisco
+		if c.maxDisco == 0 {
+			c.maxDisco = tamañoDiscoCachePorDefecto
+		}
+	}
+
+	return c, nil
+}
+
+// etagConocido retorna el ETag del bloque cacheado con la clave dada (vacío si no está)
+func (c *cacheBloques) etagConocido(clave string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, existe := c.indice[clave]; existe {
+		return elem.Value.(*entradaCacheBloque).etag
+	}
+	if elem, existe := c.indiceDisc[clave]; existe {
+		return elem.Value.(*entradaCacheBloque).etag
+	}
+	return ""
+}
+
+// obtener busca un bloque por clave y ETag en memoria y luego en disco.
+// Los bloques en disco se descomprimen y se promueven a memoria.
+// El slice retornado es compartido: los llamadores no deben modificarlo.
+func (c *cacheBloques) obtener(clave, etag string, serie tipos.Serie) ([]tipos.Medicion, bool) {
+	if etag == "" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	if elem, existe := c.indice[clave]; existe {
+		entrada := elem.Value.(*entradaCacheBloque)
+		if entrada.etag == etag {
+			c.memoria.MoveToFront(elem)
+			c.aciertosMemoria++
+			c.mu.Unlock()
+			return entrada.mediciones, true
+		}
+		// El objeto cambió en S3: la entrada ya no es válida
+		c.quitarMemoria(elem)
+	}
+
+	elem, existe := c.indiceDisc[clave]
+	if !existe {
+		c.mu.Unlock()
+		return nil, false
+	}
+	entrada := elem.Value.(*entradaCacheBloque)
+	if entrada.etag != etag {
+		c.quitarDisco(elem)
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.disco.MoveToFront(elem)
+	archivo := entrada.archivo
+	c.mu.Unlock()
+
+	// Lectura y descompresión fuera del lock
+	comprimido, err := os.ReadFile(archivo)
+	if err != nil {
+		c.descartar(clave, etag)
+		return nil, false
+	}
+	mediciones, err := descomprimirBloqueSerie(comprimido, serie)
+	if err != nil {
+		c.descartar(clave, etag)
+		return nil, false
+	}
+
+	c.mu.Lock()
+	c.aciertosDisco++
+	c.mu.Unlock()
+	c.guardar(clave, etag, comprimido, mediciones)
+	return mediciones, true
+}
+
+// guardar incorpora un bloque al nivel en memoria, desalojando a disco los menos usados
+func (c *cacheBloques) guardar(clave, etag string, comprimido []byte, mediciones []tipos.Medicion) {
+	if etag == "" {
+		return
+	}
+
+	tamaño := int64(len(comprimido)) + int64(len(mediciones))*tamañoEstimadoMedicion
+	for _, med := range mediciones {
+		if s, ok := med.Valor.(string); ok {
+			tamaño += int64(len(s))
+		}
+	}
+	if tamaño > c.maxMemoria {
+		return // Un bloque mayor que la cache completa no se almacena
+	}
+
+	c.mu.Lock()
+	if elem, existe := c.indice[clave]; existe {
+		c.quitarMemoria(elem)
+	}
+	c.indice[clave] = c.memoria.PushFront(&entradaCacheBloque{
+		clave:      clave,
+		etag:       etag,
+		mediciones: mediciones,
+		comprimido: comprimido,
+		tamaño:     tamaño,
+	})
+	c.bytesMemoria += tamaño
+
+	var desalojadas []*entradaCacheBloque
+	for c.bytesMemoria > c.maxMemoria {
+		ultimo := c.memoria.Back()
+		desalojadas = append(desalojadas, ultimo.Value.(*entradaCacheBloque))
+		c.quitarMemoria(ultimo)
+	}
+	c.mu.Unlock()
+
+	// Escritura en disco fuera del lock: no bloquea las consultas a la cache
+	for _, entrada := range desalojadas {
+		c.desalojarADisco(entrada)
+	}
+}
+
+// registrarFallo cuenta una descarga desde S3
+func (c *cacheBloques) registrarFallo() {
+	c.mu.Lock()
+	c.fallos++
+	c.mu.Unlock()
+}
+
+// registrarRevalidacion cuenta un acierto confirmado por S3 con 304 Not Modified
+func (c *cacheBloques) registrarRevalidacion() {
+	c.mu.Lock()
+	c.revalidaciones++
+	c.mu.Unlock()
+}
+
+// descartar elimina un bloque de ambos niveles
+func (c *cacheBloques) descartar(clave, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, existe := c.indice[clave]; existe && elem.Value.(*entradaCacheBloque).etag == etag {
+		c.quitarMemoria(elem)
+	}
+	if elem, existe := c.indiceDisc[clave]; existe && elem.Value.(*entradaCacheBloque).etag == etag {
+		c.quitarDisco(elem)
+	}
+}
+
+// estadisticas retorna una copia de los contadores
+func (c *cacheBloques) estadisticas() EstadisticasCacheBloques {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := EstadisticasCacheBloques{
+		AciertosMemoria: c.aciertosMemoria,
+		AciertosDisco:   c.aciertosDisco,
+		Revalidaciones:  c.revalidaciones,
+		Fallos:          c.fallos,
+		EntradasMemoria: c.memoria.Len(),
+		BytesMemoria:    c.bytesMemoria,
+		EntradasDisco:   c.disco.Len(),
+		BytesDisco:      c.bytesDisco,
+	}
+	// Las revalidaciones se cuentan también como aciertos en memoria o disco
+	aciertos := c.aciertosMemoria + c.aciertosDisco
+	if total := aciertos + c.fallos; total > 0 {
+		stats.TasaAciertos = float64(aciertos) / float64(total)
+	}
+	return stats
+}
+
+// quitarMemoria elimina una entrada del nivel en memoria (requiere c.mu)
+func (c *cacheBloques) quitarMemoria(elem *list.Element) {
+	entrada := elem.Value.(*entradaCacheBloque)
+	c.memoria.Remove(elem)
+	delete(c.indice, entrada.clave)
+	c.bytesMemoria -= entrada.tamaño
+}
+
+// quitarDisco elimina una entrada del nivel en disco y su archivo (requiere c.mu)
+func (c *cacheBloques) quitarDisco(elem *list.Element) {
+	entrada := elem.Value.(*entradaCacheBloque)
+	c.disco.Remove(elem)
+	delete(c.indiceDisc, entrada.clave)
+	c.bytesDisco -= entrada.tamaño
+	os.Remove(entrada.archivo)
+}
+
+// desalojarADisco escribe en el nivel en disco un bloque desalojado de memoria.
+// Se llama sin c.mu: el archivo se escribe fuera del lock y luego se registra.
+func (c *cacheBloques) desalojarADisco(entrada *entradaCacheBloque) {
+	tamaño := int64(len(entrada.comprimido))
+	if c.directorio == "" || tamaño == 0 || tamaño > c.maxDisco {
+		return
+	}
+
+	c.mu.Lock()
+	if elem, existe := c.indiceDisc[entrada.clave]; existe && elem.Value.(*entradaCacheBloque).etag == entrada.etag {
+		c.disco.MoveToFront(elem) // Ya está en disco
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	archivo := filepath.Join(c.directorio, nombreArchivoCache(entrada.clave, entrada.etag))
+	if err := escribirArchivoCache(archivo, entrada.comprimido); err != nil {
+		log.Printf("Advertencia: no se pudo escribir el bloque %s en la cache de disco: %v", entrada.clave, err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, existe := c.indiceDisc[entrada.clave]; existe {
+		if elem.Value.(*entradaCacheBloque).etag == entrada.etag {
+			return // Registrado por otra consulta mientras se escribía (mismo archivo)
+		}
+		c.quitarDisco(elem)
+	}
+
+	c.indiceDisc[entrada.clave] = c.disco.PushFront(&entradaCacheBloque{
+		clave:   entrada.clave,
+		etag:    entrada.etag,
+		archivo: archivo,
+		tamaño:  tamaño,
+	})
+	c.bytesDisco += tamaño
+
+	for c.bytesDisco > c.maxDisco {
+		c.quitarDisco(c.disco.Back())
+	}
+}
+
+// escribirArchivoCache escribe el archivo en un temporal y lo renombra, para que una
+// lectura concurrente del mismo bloque nunca vea un archivo a medio escribir. Los
+// temporales que queden de una caída se descartan al iniciar (misma extensión).
+func escribirArchivoCache(archivo string, datos []byte) error {
+	temporal, err := os.CreateTemp(filepath.Dir(archivo), "tmp-*"+extensionBloqueCache)
+	if err != nil {
+		return err
+	}
+	if _, err := temporal.Write(datos); err != nil {
+		temporal.Close()
+		os.Remove(temporal.Name())
+		return err
+	}
+	if err := temporal.Close(); err != nil {
+		os.Remove(temporal.Name())
+		return err
+	}
+	if err := os.Rename(temporal.Name(), archivo); err != nil {
+		os.Remove(temporal.Name())
+		return err
+	}
+	return nil
+}
+
+// nombreArchivoCache genera un nombre de archivo único para una clave S3 y su ETag
+func nombreArchivoCache(clave, etag string) string {
+	hash := sha256.Sum256([]byte(clave + "\x00" + strings.Trim(etag, `"`)))
+	return hex.EncodeToString(hash[:]) + extensionBloqueCache
+}
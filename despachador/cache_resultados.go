@@ -0,0 +1,507 @@
+package despachador
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// CACHE DE RESULTADOS DE AGREGACIÓN
+// Las consultas de agregación se resuelven sobre acumuladores (cantidad, suma,
+// mínimo y máximo) por serie y bucket, que permiten calcular cualquier agregación
+// soportada y combinarse entre tramos de tiempo. La porción histórica de una
+// consulta (ya migrada a S3) se considera inmutable y se conserva sin vencimiento
+// (ver limiteInmutable); la cola reciente, que incluye el bucket abierto, se reutiliza
+// durante el TTL y luego se vuelve a consultar, incorporando a la porción histórica
+// lo que se migró entretanto.
+// ============================================================================
+
+// TTL por defecto de la cola reciente de un resultado cacheado
+const ttlCacheResultadosPorDefecto = 10 * time.Second
+
+// OpcionesCacheResultados configura la cache de resultados de agregación
+type OpcionesCacheResultados struct {
+	// MaxEntradas es la cantidad máxima de consultas cacheadas (LRU).
+	// 0 deshabilita la cache.
+	MaxEntradas int
+
+	// TTL es el tiempo durante el cual se reutiliza la cola reciente de un resultado
+	// sin volver a consultar a los nodos edge. 0 = 10 segundos.
+	TTL time.Duration
+}
+
+// EstadisticasCacheResultados contiene los contadores de la cache de resultados
+type EstadisticasCacheResultados struct {
+	Aciertos  int64 `json:"aciertos"`  // Resultados servidos íntegramente desde la cache
+	Parciales int64 `json:"parciales"` // Resultados que solo consultaron la cola reciente
+	Fallos    int64 `json:"fallos"`    // Resultados calculados desde cero
+	Entradas  int   `json:"entradas"`
+}
+
+// ============================================================================
+// ACUMULADORES
+// ============================================================================
+
+// acumuladorAgregacion resume los valores numéricos de una serie en un bucket
+type acumuladorAgregacion struct {
+	cantidad int64
+	suma     float64
+	minimo   float64
+	maximo   float64
+}
+
+// agregar incorpora un valor al acumulador
+func (a *acumuladorAgregacion) agregar(v float64) {
+	if a.cantidad == 0 || v < a.minimo {
+		a.minimo = v
+	}
+	if a.cantidad == 0 || v > a.maximo {
+		a.maximo = v
+	}
+	a.cantidad++
+	a.suma += v
+}
+
+// combinar incorpora otro acumulador del mismo bucket
+func (a *acumuladorAgregacion) combinar(b acumuladorAgregacion) {
+	if b.cantidad == 0 {
+		return
+	}
+	if a.cantidad == 0 || b.minimo < a.minimo {
+		a.minimo = b.minimo
+	}
+	if a.cantidad == 0 || b.maximo > a.maximo {
+		a.maximo = b.maximo
+	}
+	a.cantidad += b.cantidad
+	a.suma += b.suma
+}
+
+// calcular retorna la agregación pedida (math.NaN() si no hay valores)
+func (a acumuladorAgregacion) calcular(agregacion tipos.TipoAgregacion) float64 {
+	if a.cantidad == 0 {
+		return math.NaN()
+	}
+	switch agregacion {
+	case tipos.AgregacionPromedio:
+		return a.suma / float64(a.cantidad)
+	case tipos.AgregacionMaximo:
+		return a.maximo
+	case tipos.AgregacionMinimo:
+		return a.minimo
+	case tipos.AgregacionSuma:
+		return a.suma
+	case tipos.AgregacionCount:
+		return float64(a.cantidad)
+	default:
+		return math.NaN()
+	}
+}
+
+// acumuladosSeries contiene los acumuladores de cada serie (path) por bucket
+type acumuladosSeries map[string][]acumuladorAgregacion
+
+// copiar retorna una copia independiente de los acumuladores
+func (a acumuladosSeries) copiar() acumuladosSeries {
+	copia := make(acumuladosSeries, len(a))
+	for path, buckets := range a {
+		copia[path] = append([]acumuladorAgregacion(nil), buckets...)
+	}
+	return copia
+}
+
+// combinar incorpora los acumuladores de otro tramo con los mismos buckets
+func (a acumuladosSeries) combinar(b acumuladosSeries) {
+	for path, buckets := range b {
+		destino, existe := a[path]
+		if !existe {
+			a[path] = append([]acumuladorAgregacion(nil), buckets...)
+			continue
+		}
+		for i := range buckets {
+			destino[i].combinar(buckets[i])
+		}
+	}
+}
+
+// acumular incorpora las filas del resultado con tiempo en [desde, hasta].
+// Con intervalo 0 hay un único bucket; si no, los buckets comienzan en inicioBuckets.
+// Una serie se registra (aun sin valores numéricos) si tiene algún valor en el tramo.
+func (a acumuladosSeries) acumular(resultado tipos.ResultadoConsultaRango, desde, hasta, inicioBuckets, intervalo int64, numBuckets int) {
+	if numBuckets == 0 {
+		return
+	}
+	for filaIdx, tiempo := range resultado.Tiempos {
+		if tiempo < desde || tiempo > hasta {
+			continue
+		}
+		bucketIdx := 0
+		if intervalo > 0 {
+			bucketIdx = calcularBucketIdx(tiempo, inicioBuckets, intervalo, numBuckets)
+			if bucketIdx < 0 {
+				continue
+			}
+		}
+		for colIdx, path := range resultado.Series {
+			valor := resultado.Valores[filaIdx][colIdx]
+			if valor == nil {
+				continue
+			}
+			buckets, existe := a[path]
+			if !existe {
+				buckets = make([]acumuladorAgregacion, numBuckets)
+				a[path] = buckets
+			}
+			switch v := valor.(type) {
+			case float64:
+				buckets[bucketIdx].agregar(v)
+			case int64:
+				buckets[bucketIdx].agregar(float64(v))
+			}
+		}
+	}
+}
+
+// seriesOrdenadas retorna los paths de las series ordenados alfabéticamente
+func (a acumuladosSeries) seriesOrdenadas() []string {
+	series := make([]string, 0, len(a))
+	for path := range a {
+		series = append(series, path)
+	}
+	sort.Strings(series)
+	return series
+}
+
+// construirAgregacion arma el resultado de ConsultarAgregacion desde acumuladores de un bucket
+func construirAgregacion(acumulados acumuladosSeries, agregaciones []tipos.TipoAgregacion, nodosNoDisponibles []string) tipos.ResultadoAgregacion {
+	series := acumulados.seriesOrdenadas()
+	valores := make([][]float64, len(agregaciones))
+	for agIdx, agregacion := range agregaciones {
+		valores[agIdx] = make([]float64, len(series))
+		for serieIdx, path := range series {
+			valores[agIdx][serieIdx] = acumulados[path][0].calcular(agregacion)
+		}
+	}
+
+	return tipos.ResultadoAgregacion{
+		Series:             series,
+		Agregaciones:       agregaciones,
+		Valores:            valores, // [agregacion][serie]
+		NodosNoDisponibles: nodosNoDisponibles,
+	}
+}
+
+// construirAgregacionTemporal arma el resultado de ConsultarAgregacionTemporal desde acumuladores por bucket
+func construirAgregacionTemporal(acumulados acumuladosSeries, buckets []int64, agregaciones []tipos.TipoAgregacion, nodosNoDisponibles []string) tipos.ResultadoAgregacionTemporal {
+	series := acumulados.seriesOrdenadas()
+	valores := make([][][]float64, len(agregaciones))
+	for agIdx, agregacion := range agregaciones {
+		valores[agIdx] = make([][]float64, len(buckets))
+		for b := range buckets {
+			valores[agIdx][b] = make([]float64, len(series))
+			for s, path := range series {
+				valores[agIdx][b][s] = acumulados[path][b].calcular(agregacion)
+			}
+		}
+	}
+
+	return tipos.ResultadoAgregacionTemporal{
+		Series:             series,
+		Tiempos:            buckets,
+		Agregaciones:       agregaciones,
+		Valores:            valores, // [agregacion][bucket][serie]
+		NodosNoDisponibles: nodosNoDisponibles,
+	}
+}
+
+// ============================================================================
+// CACHE LRU
+// ============================================================================
+
+// entradaCacheResultado es el resultado cacheado de una consulta normalizada
+type entradaCacheResultado struct {
+	clave              string
+	series             string           // Identidad de las series resueltas al calcular la entrada
+	corte              int64            // Fin (inclusive) de la porción histórica
+	historico          acumuladosSeries // Tramo [inicio, corte]: inmutable
+	cola               acumuladosSeries // Tramo (corte, fin]: válido hasta venceCola
+	nodosNoDisponibles []string
+	venceCola          time.Time
+}
+
+// acumulados retorna la combinación de la porción histórica y la cola
+func (e *entradaCacheResultado) acumulados() acumuladosSeries {
+	combinados := e.historico.copiar()
+	combinados.combinar(e.cola)
+	return combinados
+}
+
+// cacheResultados es una cache LRU de resultados de agregación
+type cacheResultados struct {
+	mu          sync.Mutex
+	maxEntradas int
+	ttl         time.Duration
+	lru         *list.List               // Frente = usado más recientemente
+	indice      map[string]*list.Element // clave -> entrada
+	enCurso     map[string]chan struct{} // Consultas en cálculo, para no repetirlas en paralelo
+
+	aciertos  int64
+	parciales int64
+	fallos    int64
+}
+
+// nuevaCacheResultados crea la cache según las opciones. Retorna nil si está deshabilitada.
+func nuevaCacheResultados(opts OpcionesCacheResultados) *cacheResultados {
+	if opts.MaxEntradas <= 0 {
+		return nil
+	}
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = ttlCacheResultadosPorDefecto
+	}
+	return &cacheResultados{
+		maxEntradas: opts.MaxEntradas,
+		ttl:         ttl,
+		lru:         list.New(),
+		indice:      make(map[string]*list.Element),
+		enCurso:     make(map[string]chan struct{}),
+	}
+}
+
+// obtener retorna la entrada de una clave (nil si no existe).
+// Las entradas no se modifican una vez guardadas: se reemplazan completas.
+func (c *cacheResultados) obtener(clave string) *entradaCacheResultado {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, existe := c.indice[clave]
+	if !existe {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*entradaCacheResultado)
+}
+
+// guardar incorpora o reemplaza una entrada, desalojando las menos usadas
+func (c *cacheResultados) guardar(entrada *entradaCacheResultado) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, existe := c.indice[entrada.clave]; existe {
+		c.lru.Remove(elem)
+	}
+	c.indice[entrada.clave] = c.lru.PushFront(entrada)
+
+	for c.lru.Len() > c.maxEntradas {
+		ultimo := c.lru.Back()
+		c.lru.Remove(ultimo)
+		delete(c.indice, ultimo.Value.(*entradaCacheResultado).clave)
+	}
+}
+
+// quitar elimina la entrada de una clave
+func (c *cacheResultados) quitar(clave string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, existe := c.indice[clave]; existe {
+		c.lru.Remove(elem)
+		delete(c.indice, clave)
+	}
+}
+
+// iniciarCalculo registra el cálculo de una clave. Si otro cálculo de la misma clave
+// está en curso retorna su canal (se cierra al terminar) y propio = false.
+func (c *cacheResultados) iniciarCalculo(clave string) (espera chan struct{}, propio bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if espera, existe := c.enCurso[clave]; existe {
+		return espera, false
+	}
+	espera = make(chan struct{})
+	c.enCurso[clave] = espera
+	return espera, true
+}
+
+// terminarCalculo libera a los que esperan el cálculo de una clave
+func (c *cacheResultados) terminarCalculo(clave string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if espera, existe := c.enCurso[clave]; existe {
+		close(espera)
+		delete(c.enCurso, clave)
+	}
+}
+
+// registrar actualiza los contadores según el tipo de resolución
+func (c *cacheResultados) registrar(acierto, parcial bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case acierto:
+		c.aciertos++
+	case parcial:
+		c.parciales++
+	default:
+		c.fallos++
+	}
+}
+
+// estadisticas retorna una copia de los contadores
+func (c *cacheResultados) estadisticas() EstadisticasCacheResultados {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return EstadisticasCacheResultados{
+		Aciertos:  c.aciertos,
+		Parciales: c.parciales,
+		Fallos:    c.fallos,
+		Entradas:  c.lru.Len(),
+	}
+}
+
+// ============================================================================
+// CONSULTA DE ACUMULADOS
+// ============================================================================
+
+// consultarAcumulados resuelve una consulta de agregación como acumuladores por serie y
+// bucket (intervalo 0 = un único bucket) usando la cache de resultados: reutiliza la porción
+// histórica cacheada y solo consulta el tramo posterior. Requiere m.cacheResultados != nil.
+// Retorna además los nodos no disponibles durante la última consulta a los edges.
+//
+// La clave usa el rango tal como llega: las consultas solo comparten la entrada si repiten
+// el rango exacto. Con intervalo, las consultas relativas alineadas (ver AlinearRangoBuckets)
+// la comparten mientras no cambie el bucket abierto.
+func (m *ManagerDespachador) consultarAcumulados(nombreSerie string, inicio, fin, intervalo int64, numBuckets int) (acumuladosSeries, []string, error) {
+	seriesEncontradas, err := m.buscarSeriesPorPath(nombreSerie)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := m.cacheResultados
+	clave := fmt.Sprintf("%s|%d|%d|%d", strings.TrimSpace(nombreSerie), inicio, fin, intervalo)
+	identidad := identidadSeries(seriesEncontradas)
+
+	for {
+		entrada := c.obtener(clave)
+		if entrada != nil && entrada.series != identidad {
+			// Cambió el conjunto de series (alta, baja o reemplazo): la entrada no es válida
+			c.quitar(clave)
+			entrada = nil
+		}
+		if entrada != nil && (entrada.corte >= fin || time.Now().Before(entrada.venceCola)) {
+			c.registrar(true, false)
+			return entrada.acumulados(), entrada.nodosNoDisponibles, nil
+		}
+
+		espera, propio := c.iniciarCalculo(clave)
+		if !propio {
+			<-espera // Otra consulta idéntica está calculando el resultado
+			continue
+		}
+		// Diferido para liberar a los que esperan aunque el cálculo entre en pánico
+		defer c.terminarCalculo(clave)
+		return m.recalcularEntrada(c, clave, identidad, entrada, seriesEncontradas, nombreSerie, inicio, fin, intervalo, numBuckets)
+	}
+}
+
+// recalcularEntrada consulta el tramo no cubierto por la porción histórica de la entrada
+// (o el rango completo si no hay entrada) y guarda el resultado en la cache.
+// Solo se incorporan a la porción histórica los datos ya migrados a S3 y leídos sin errores.
+func (m *ManagerDespachador) recalcularEntrada(c *cacheResultados, clave, identidad string, anterior *entradaCacheResultado,
+	seriesEncontradas []serieConNodo, nombreSerie string, inicio, fin, intervalo int64, numBuckets int) (acumuladosSeries, []string, error) {
+
+	historico := make(acumuladosSeries)
+	corteAnterior := inicio - 1
+	if anterior != nil {
+		historico = anterior.historico.copiar()
+		corteAnterior = anterior.corte
+	}
+	desde := corteAnterior + 1
+
+	corte := min(m.limiteInmutable(seriesEncontradas), fin)
+	if corte < corteAnterior {
+		corte = corteAnterior
+	}
+
+	resultado, completo, err := m.consultarRango(nombreSerie, desde, fin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !completo {
+		corte = corteAnterior // Resultado parcial de S3: no se amplía la porción histórica
+	}
+
+	historico.acumular(resultado, desde, corte, inicio, intervalo, numBuckets)
+	cola := make(acumuladosSeries)
+	cola.acumular(resultado, corte+1, fin, inicio, intervalo, numBuckets)
+
+	entrada := &entradaCacheResultado{
+		clave:              clave,
+		series:             identidad,
+		corte:              corte,
+		historico:          historico,
+		cola:               cola,
+		nodosNoDisponibles: resultado.NodosNoDisponibles,
+		venceCola:          time.Now().Add(c.ttl),
+	}
+	c.guardar(entrada)
+	c.registrar(false, anterior != nil)
+
+	return entrada.acumulados(), entrada.nodosNoDisponibles, nil
+}
+
+// limiteInmutable retorna el último instante cuyos datos ya fueron migrados a S3 en todas
+// las series: el mínimo entre series del TiempoFin del último bloque de su manifiesto.
+// Retorna math.MinInt64 si alguna serie no tiene manifiesto.
+//
+// Supone que no llegan mediciones con tiempo anterior a ese límite: el edge migra los
+// bloques en orden de tiempo, pero una medición tardía queda en un bloque local que se
+// migra después. Esa medición no se refleja en la porción histórica de las entradas ya
+// cacheadas, que no se revalida, hasta que la entrada sale de la cache (LRU) o cambia el
+// conjunto de series. Si las mediciones tardías son habituales conviene deshabilitar la
+// cache de resultados.
+func (m *ManagerDespachador) limiteInmutable(seriesEncontradas []serieConNodo) int64 {
+	limites := make(chan int64, len(seriesEncontradas))
+	for _, sn := range seriesEncontradas {
+		go func(sn serieConNodo) {
+			manifiesto, err := tipos.LeerManifiesto(context.TODO(), m.almacenamiento, sn.nodo.NodoID, sn.serie.SerieId)
+			if err != nil || len(manifiesto.Bloques) == 0 {
+				limites <- math.MinInt64
+				return
+			}
+			limite := int64(math.MinInt64)
+			for _, bloque := range manifiesto.Bloques {
+				limite = max(limite, bloque.TiempoFin)
+			}
+			limites <- limite
+		}(sn)
+	}
+
+	limite := int64(math.MaxInt64)
+	for range seriesEncontradas {
+		limite = min(limite, <-limites)
+	}
+	return limite
+}
+
+// identidadSeries genera una representación canónica del conjunto de series resueltas
+func identidadSeries(seriesEncontradas []serieConNodo) string {
+	partes := make([]string, len(seriesEncontradas))
+	for i, sn := range seriesEncontradas {
+		partes[i] = fmt.Sprintf("%s/%d/%s", sn.nodo.NodoID, sn.serie.SerieId, sn.path)
+	}
+	sort.Strings(partes)
+	return strings.Join(partes, "\n")
+}
@@ -0,0 +1,367 @@
+package despachador
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// COMANDOS A LOS NODOS
+// Modificación remota de las reglas y series de un nodo. El comando se envía a la
+// API REST del nodo si está accesible y no tiene comandos encolados; si no, se
+// encola en S3 (comandos/{nodoID}/) y el nodo lo aplica con su próximo latido, en
+// orden de creación. El nodo aplica cada ID una sola vez, por lo que un comando
+// encolado tras un timeout de la entrega directa no se reaplica. Los comandos
+// encolados se firman con el secreto del nodo (ver tipos.FirmarComando). En ambos casos
+// el nodo valida el comando y refleja el cambio en su registro. Los comandos
+// aplicados o rechazados quedan en resultados_comandos/{nodoID}/ para consultar
+// su estado.
+// ============================================================================
+
+// timeoutComando es la espera máxima de la entrega directa de un comando
+const timeoutComando = 10 * time.Second
+
+var (
+	// ErrNodoNoEncontrado indica que el nodo destino no está registrado
+	ErrNodoNoEncontrado = errors.New("nodo no encontrado")
+	// ErrComandoNoEncontrado indica que el comando no está encolado ni tiene resultado
+	ErrComandoNoEncontrado = errors.New("comando no encontrado")
+
+	// errComandosEncolados indica que el comando se encoló detrás de los pendientes del nodo
+	errComandosEncolados = errors.New("el nodo tiene comandos encolados pendientes")
+	// errSinSecretoComando indica que no hay secreto para firmar el comando a encolar
+	errSinSecretoComando = errors.New("no hay secreto de autenticación para firmar el comando encolado del nodo")
+)
+
+// ErrorRechazoEdge indica que el nodo recibió el comando y lo rechazó (regla o serie
+// inválida, inexistente o duplicada). A diferencia de un error de conexión, no se reintenta.
+type ErrorRechazoEdge struct {
+	Status  int    // Status HTTP de la respuesta del nodo (4xx)
+	Mensaje string // Motivo informado por el nodo
+}
+
+func (e *ErrorRechazoEdge) Error() string {
+	return fmt.Sprintf("el nodo rechazó el comando: %s", e.Mensaje)
+}
+
+// EnviarComando envía un comando a su nodo y retorna el comando completo (con ID y
+// momento de creación) junto con su resultado. Si el nodo está desconectado, tiene el
+// circuito abierto, tiene comandos encolados o falla la conexión, el comando se encola
+// en S3 y el resultado queda pendiente con el motivo. Si el nodo lo rechaza retorna
+// *ErrorRechazoEdge.
+func (m *ManagerDespachador) EnviarComando(ctx context.Context, comando tipos.Comando) (tipos.RegistroComando, error) {
+	if err := comando.Validar(); err != nil {
+		return tipos.RegistroComando{}, err
+	}
+	m.mu.RLock()
+	registrado, existe := m.nodos[comando.NodoID]
+	var nodo tipos.Nodo
+	if existe {
+		nodo = *registrado
+	}
+	m.mu.RUnlock()
+	if !existe {
+		return tipos.RegistroComando{}, fmt.Errorf("%w: %s", ErrNodoNoEncontrado, comando.NodoID)
+	}
+
+	ahora := time.Now()
+	if comando.Creado == 0 {
+		comando.Creado = ahora.UnixNano()
+	}
+	if comando.ID == "" {
+		id, err := tipos.NuevoIDComando(ahora)
+		if err != nil {
+			return tipos.RegistroComando{}, err
+		}
+		comando.ID = id
+	}
+	registro := registroPendiente(comando)
+
+	// Entrega directa
+	switch {
+	case m.EstadoConexionNodo(nodo) == ConexionDesconectada:
+		registro.Resultado.Motivo = errNodoDesconectado.Error()
+	case !m.salud.permitir(nodo.NodoID):
+		registro.Resultado.Motivo = errNodoNoDisponible.Error()
+	case m.hayComandosEncolados(ctx, nodo.NodoID):
+		// Entregarlo directamente lo aplicaría antes que los encolados, creados antes
+		registro.Resultado.Motivo = errComandosEncolados.Error()
+	default:
+		ctxEnvio, cancelar := context.WithTimeout(ctx, timeoutComando)
+		comienzo := time.Now()
+		resultado, err := m.clienteEdge.EnviarComando(ctxEnvio, nodo.NodoID, nodo.URLBase(), comando)
+		cancelar()
+
+		var rechazo *ErrorRechazoEdge
+		switch {
+		case err == nil:
+			m.salud.registrarExito(nodo.NodoID, time.Since(comienzo))
+			registro.Resultado = *resultado
+			registro.Resultado.ID = comando.ID
+			registro.Resultado.Tipo = comando.Tipo
+			registro.Resultado.Estado = tipos.ComandoAplicado
+			if registro.Resultado.Aplicado == 0 {
+				registro.Resultado.Aplicado = time.Now().UnixNano()
+			}
+			m.aplicarComandoEnRegistro(comando, registro.Resultado)
+			m.guardarResultadoComando(ctx, registro)
+			return registro, nil
+		case errors.As(err, &rechazo):
+			m.salud.registrarExito(nodo.NodoID, time.Since(comienzo)) // El nodo respondió
+			registro.Resultado.Estado = tipos.ComandoRechazado
+			registro.Resultado.Motivo = rechazo.Mensaje
+			registro.Resultado.Aplicado = time.Now().UnixNano()
+			m.guardarResultadoComando(ctx, registro)
+			return tipos.RegistroComando{}, err
+		default:
+			m.salud.registrarFallo(nodo.NodoID, err)
+			registro.Resultado.Motivo = err.Error()
+		}
+	}
+
+	// Encolado para el próximo latido del nodo
+	if err := m.encolarComando(ctx, comando); err != nil {
+		return tipos.RegistroComando{}, err
+	}
+	log.Printf("Comando %s (%s) encolado para el nodo %s: %s", comando.ID, comando.Tipo, comando.NodoID, registro.Resultado.Motivo)
+	return registro, nil
+}
+
+// hayComandosEncolados indica si la cola S3 del nodo tiene comandos sin aplicar. Si
+// la cola no puede listarse se asume que sí, para no adelantar el comando.
+func (m *ManagerDespachador) hayComandosEncolados(ctx context.Context, nodoID string) bool {
+	hay := false
+	err := m.almacenamiento.Recorrer(ctx, tipos.GenerarPrefijoS3Comandos(nodoID), "", func(tipos.ObjetoAlmacenado) bool {
+		hay = true
+		return false
+	})
+	if err != nil {
+		log.Printf("Error listando comandos encolados del nodo %s: %v", nodoID, err)
+		return true
+	}
+	return hay
+}
+
+// encolarComando firma el comando con el secreto de su nodo y lo guarda en la cola S3
+// del nodo. Sin secreto no se encola: el nodo descarta los comandos sin firma.
+func (m *ManagerDespachador) encolarComando(ctx context.Context, comando tipos.Comando) error {
+	secreto := m.autenticacion.secreto(comando.NodoID)
+	if secreto == nil {
+		return fmt.Errorf("%w: %s", errSinSecretoComando, comando.NodoID)
+	}
+	encolado, err := tipos.FirmarComando(comando, secreto)
+	if err != nil {
+		return err
+	}
+	datos, err := json.Marshal(encolado)
+	if err != nil {
+		return fmt.Errorf("error serializando comando: %v", err)
+	}
+	_, err = m.almacenamiento.Guardar(ctx, tipos.GenerarClaveS3Comando(comando.NodoID, comando.ID), datos,
+		tipos.OpcionesGuardar{TipoContenido: "application/json"})
+	if err != nil {
+		return fmt.Errorf("error encolando comando en S3: %v", err)
+	}
+	return nil
+}
+
+// guardarResultadoComando guarda en S3 el resultado de un comando entregado
+// directamente. El nodo ya lo aplicó (o rechazó), por lo que un error solo se registra.
+func (m *ManagerDespachador) guardarResultadoComando(ctx context.Context, registro tipos.RegistroComando) {
+	comando := registro.Comando
+	datos, err := json.Marshal(registro)
+	if err == nil {
+		_, err = m.almacenamiento.Guardar(ctx, tipos.GenerarClaveS3ResultadoComando(comando.NodoID, comando.ID), datos,
+			tipos.OpcionesGuardar{TipoContenido: "application/json"})
+	}
+	if err != nil {
+		log.Printf("Error guardando resultado del comando %s: %v", comando.ID, err)
+	}
+}
+
+// aplicarComandoEnRegistro refleja un comando aplicado en el registro en memoria del
+// nodo, sin esperar a que el nodo vuelva a registrarse en S3 y se sincronice. Los
+// cambios de series se informan como eventos, igual que en la sincronización.
+func (m *ManagerDespachador) aplicarComandoEnRegistro(comando tipos.Comando, resultado tipos.ResultadoComando) {
+	// Serializa con la sincronización para no alterar el orden de los eventos
+	m.sincronizacion.mu.Lock()
+	defer m.sincronizacion.mu.Unlock()
+
+	m.mu.Lock()
+	nodo, existe := m.nodos[comando.NodoID]
+	if !existe {
+		m.mu.Unlock()
+		return
+	}
+	id := comando.Objetivo()
+
+	// Se reemplazan el slice y el mapa para no modificar las copias ya entregadas
+	if !comando.EsComandoSerie() {
+		reglas := make([]tipos.Regla, 0, len(nodo.Reglas)+1)
+		for _, r := range nodo.Reglas {
+			if r.ID != id {
+				reglas = append(reglas, r)
+			}
+		}
+		if resultado.Regla != nil {
+			reglas = append(reglas, *resultado.Regla)
+		}
+		nodo.Reglas = reglas
+		m.mu.Unlock()
+		return
+	}
+
+	series := make(map[string]tipos.Serie, len(nodo.Series)+1)
+	for path, serie := range nodo.Series {
+		if path != id {
+			series[path] = serie
+		}
+	}
+	if resultado.Serie != nil {
+		series[resultado.Serie.Path] = *resultado.Serie
+	}
+	nodo.Series = series
+	evento := EventoNodo{Tipo: EventoSeriesCambiadas, NodoID: nodo.NodoID, Nodo: *nodo}
+	m.mu.Unlock()
+
+	m.notificarEventos([]EventoNodo{evento})
+}
+
+// ObtenerComando retorna el estado de un comando: su resultado si el nodo ya lo
+// aplicó o rechazó, o pendiente si sigue en la cola
+func (m *ManagerDespachador) ObtenerComando(ctx context.Context, nodoID, id string) (tipos.RegistroComando, error) {
+	var registro tipos.RegistroComando
+	err := m.leerObjetoJSON(ctx, tipos.GenerarClaveS3ResultadoComando(nodoID, id), &registro)
+	if err == nil {
+		return registro, nil
+	}
+	if !tipos.EsObjetoInexistente(err) {
+		return registro, fmt.Errorf("error obteniendo resultado del comando %s: %v", id, err)
+	}
+
+	comando, err := m.leerComandoEncolado(ctx, tipos.GenerarClaveS3Comando(nodoID, id))
+	if tipos.EsObjetoInexistente(err) {
+		return registro, fmt.Errorf("%w: %s", ErrComandoNoEncontrado, id)
+	}
+	if err != nil {
+		return registro, fmt.Errorf("error obteniendo comando %s: %v", id, err)
+	}
+	return registroPendiente(comando), nil
+}
+
+// ListarComandos retorna los comandos pendientes y los resultados de los comandos de
+// un nodo, ordenados por creación
+func (m *ManagerDespachador) ListarComandos(ctx context.Context, nodoID string) ([]tipos.RegistroComando, error) {
+	porID := make(map[string]tipos.RegistroComando)
+
+	resultados, err := tipos.ListarObjetos(ctx, m.almacenamiento, tipos.GenerarPrefijoS3ResultadosComandos(nodoID))
+	if err != nil {
+		return nil, fmt.Errorf("error listando resultados de comandos: %v", err)
+	}
+	for _, obj := range resultados {
+		var registro tipos.RegistroComando
+		if err := m.leerObjetoJSON(ctx, obj.Clave, &registro); err != nil {
+			log.Printf("Error obteniendo resultado de comando %s: %v", obj.Clave, err)
+			continue
+		}
+		porID[registro.Comando.ID] = registro
+	}
+
+	pendientes, err := tipos.ListarObjetos(ctx, m.almacenamiento, tipos.GenerarPrefijoS3Comandos(nodoID))
+	if err != nil {
+		return nil, fmt.Errorf("error listando comandos pendientes: %v", err)
+	}
+	for _, obj := range pendientes {
+		comando, err := m.leerComandoEncolado(ctx, obj.Clave)
+		if err != nil {
+			log.Printf("Error obteniendo comando %s: %v", obj.Clave, err)
+			continue
+		}
+		// Un comando procesado puede seguir en la cola hasta que el nodo lo elimine
+		if _, procesado := porID[comando.ID]; !procesado {
+			porID[comando.ID] = registroPendiente(comando)
+		}
+	}
+
+	registros := make([]tipos.RegistroComando, 0, len(porID))
+	for _, registro := range porID {
+		registros = append(registros, registro)
+	}
+	sort.Slice(registros, func(i, j int) bool {
+		return registros[i].Comando.ID < registros[j].Comando.ID
+	})
+	return registros, nil
+}
+
+// leerComandoEncolado lee un comando de la cola S3 sin verificar su firma (la verifica el nodo)
+func (m *ManagerDespachador) leerComandoEncolado(ctx context.Context, clave string) (tipos.Comando, error) {
+	var encolado tipos.ComandoEncolado
+	var comando tipos.Comando
+	if err := m.leerObjetoJSON(ctx, clave, &encolado); err != nil {
+		return comando, err
+	}
+	if err := json.Unmarshal(encolado.Comando, &comando); err != nil {
+		return comando, fmt.Errorf("error deserializando comando: %v", err)
+	}
+	return comando, nil
+}
+
+// registroPendiente retorna el registro de un comando que sigue en la cola
+func registroPendiente(comando tipos.Comando) tipos.RegistroComando {
+	return tipos.RegistroComando{
+		Comando:   comando,
+		Resultado: tipos.ResultadoComando{ID: comando.ID, Tipo: comando.Tipo, Estado: tipos.ComandoPendiente},
+	}
+}
+
+// EnviarComando implementa clienteEdge
+func (c *clienteEdgeHTTP) EnviarComando(ctx context.Context, nodoID string, direccion string, comando tipos.Comando) (*tipos.ResultadoComando, error) {
+	cuerpo, err := json.Marshal(comando)
+	if err != nil {
+		return nil, fmt.Errorf("error serializando comando: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/api/comandos", direccion)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(cuerpo))
+	if err != nil {
+		return nil, fmt.Errorf("error creando request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if err := c.firmar(httpReq, cuerpo, nodoID); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.clientePara(nodoID).Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error en request HTTP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respuestaBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo respuesta: %v", err)
+	}
+	// 401 indica un secreto mal configurado, no un rechazo del comando
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusUnauthorized {
+		return nil, &ErrorRechazoEdge{Status: resp.StatusCode, Mensaje: string(bytes.TrimSpace(respuestaBytes))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error del edge (status %d): %s", resp.StatusCode, string(respuestaBytes))
+	}
+
+	var resultado tipos.ResultadoComando
+	if err := json.Unmarshal(respuestaBytes, &resultado); err != nil {
+		return nil, fmt.Errorf("error deserializando respuesta: %v", err)
+	}
+	return &resultado, nil
+}
@@ -0,0 +1,124 @@
+package despachador
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ResultadoComparacion contiene el resultado de una consulta de comparación entre períodos.
+// Ambos períodos tienen la misma cantidad de buckets; el bucket i de Actual se corresponde
+// con el bucket i de Anterior, y TiemposRelativos[i] es su desplazamiento desde el inicio del período.
+type ResultadoComparacion struct {
+	Actual           tipos.ResultadoAgregacionTemporal // Período consultado
+	Anterior         tipos.ResultadoAgregacionTemporal // Mismo período desplazado hacia atrás
+	Desplazamiento   time.Duration                     // Desplazamiento aplicado al período anterior
+	TiemposRelativos []int64                           // Offset de cada bucket desde el inicio del período (nanosegundos)
+}
+
+// ConsultarComparacionPeriodos ejecuta la misma agregación temporal sobre [tiempoInicio, tiempoFin]
+// y sobre el período desplazado [tiempoInicio-desplazamiento, tiempoFin-desplazamiento],
+// retornando ambos resultados alineados por tiempo relativo y por serie.
+// Ejemplo: desplazamiento de 7*24h compara esta semana con la semana anterior.
+//
+// Cada período se resuelve con ConsultarAgregacionTemporal, por lo que usa la cache de
+// resultados y la de bloques de S3. El desplazamiento debe ser múltiplo del intervalo para
+// que los buckets de ambos períodos se correspondan.
+func (m *ManagerDespachador) ConsultarComparacionPeriodos(
+	nombreSerie string,
+	tiempoInicio, tiempoFin time.Time,
+	agregaciones []tipos.TipoAgregacion,
+	intervalo time.Duration,
+	desplazamiento time.Duration,
+) (ResultadoComparacion, error) {
+	if len(agregaciones) == 0 {
+		return ResultadoComparacion{}, fmt.Errorf("debe especificar al menos una agregación")
+	}
+	if intervalo <= 0 {
+		return ResultadoComparacion{}, fmt.Errorf("intervalo debe ser mayor a cero")
+	}
+	if desplazamiento <= 0 {
+		return ResultadoComparacion{}, fmt.Errorf("desplazamiento debe ser mayor a cero")
+	}
+	if desplazamiento%intervalo != 0 {
+		return ResultadoComparacion{}, fmt.Errorf("desplazamiento debe ser múltiplo del intervalo")
+	}
+	if !tiempoFin.After(tiempoInicio) {
+		return ResultadoComparacion{}, fmt.Errorf("tiempo fin debe ser posterior a tiempo inicio")
+	}
+
+	// Los períodos se consultan en paralelo
+	var anterior tipos.ResultadoAgregacionTemporal
+	var errAnterior error
+	hecho := make(chan struct{})
+	go func() {
+		defer close(hecho)
+		anterior, errAnterior = m.agregacionTemporal(nombreSerie, tiempoInicio.Add(-desplazamiento), tiempoFin.Add(-desplazamiento), agregaciones, intervalo)
+	}()
+
+	actual, err := m.agregacionTemporal(nombreSerie, tiempoInicio, tiempoFin, agregaciones, intervalo)
+	<-hecho
+	if err != nil {
+		return ResultadoComparacion{}, err
+	}
+	if errAnterior != nil {
+		return ResultadoComparacion{}, fmt.Errorf("error consultando período anterior: %v", errAnterior)
+	}
+
+	// Una misma lista de series en ambos períodos: la serie i de Actual es la serie i de Anterior
+	series := unirSeries(actual.Series, anterior.Series)
+	actual = alinearSeries(actual, series)
+	anterior = alinearSeries(anterior, series)
+
+	relativos := make([]int64, len(actual.Tiempos))
+	for i, t := range actual.Tiempos {
+		relativos[i] = t - actual.Tiempos[0]
+	}
+
+	return ResultadoComparacion{
+		Actual:           actual,
+		Anterior:         anterior,
+		Desplazamiento:   desplazamiento,
+		TiemposRelativos: relativos,
+	}, nil
+}
+
+// unirSeries retorna la unión ordenada de dos listas de series ordenadas
+func unirSeries(a, b []string) []string {
+	union := make([]string, 0, len(a)+len(b))
+	union = append(union, a...)
+	union = append(union, b...)
+	sort.Strings(union)
+	return slices.Compact(union)
+}
+
+// alinearSeries reordena las columnas de un resultado según series. Las series sin
+// datos en el resultado se completan con math.NaN().
+func alinearSeries(resultado tipos.ResultadoAgregacionTemporal, series []string) tipos.ResultadoAgregacionTemporal {
+	columnas := make(map[string]int, len(resultado.Series))
+	for i, serie := range resultado.Series {
+		columnas[serie] = i
+	}
+
+	valores := make([][][]float64, len(resultado.Valores))
+	for agIdx, buckets := range resultado.Valores {
+		valores[agIdx] = make([][]float64, len(buckets))
+		for b, fila := range buckets {
+			valores[agIdx][b] = make([]float64, len(series))
+			for s, serie := range series {
+				valores[agIdx][b][s] = math.NaN()
+				if col, existe := columnas[serie]; existe {
+					valores[agIdx][b][s] = fila[col]
+				}
+			}
+		}
+	}
+
+	resultado.Series = series
+	resultado.Valores = valores
+	return resultado
+}
@@ -3,18 +3,19 @@ package despachador
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net/http"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/cbiale/sensorwave/compresor"
 	"github.com/cbiale/sensorwave/tipos"
 )
@@ -22,16 +23,74 @@ import (
 type ManagerDespachador struct {
 	nodos       map[string]*tipos.Nodo
 	mu          sync.RWMutex
-	s3          tipos.ClienteS3
-	config      tipos.ConfiguracionS3
 	done        chan struct{}
 	clienteEdge clienteEdge
+	cache       *cacheBloques // nil = cache de bloques deshabilitada
+
+	almacenamiento tipos.Almacenamiento // Registros de nodos, latidos, comandos y bloques migrados
+
+	cacheResultados *cacheResultados // nil = cache de resultados deshabilitada
+	salud           *monitorSalud    // nil = sin seguimiento de salud ni cortocircuito
+	latidos         OpcionesLatidos
+
+	sincronizacion          estadoSincronizacion  // Registros y latidos descargados en la última sincronización
+	intervaloSincronizacion time.Duration         // 0 = 30s
+	alCambiarNodos          func(EventoNodo)      // nil = sin notificación de cambios
+	confianza               *listaConfianza       // nil = se admiten todos los registros
+	autorizacion            *autorizador          // nil = API REST sin autenticación
+	tuneles                 *registroTuneles      // Túneles abiertos por los nodos detrás de NAT
+	autenticacion           OpcionesAutenticacion // Secretos con que se firman los comandos encolados
 }
 
 // Opciones configura la creación de un ManagerDespachador.
-// El despachador SIEMPRE requiere S3 para coordinar nodos.
+// El despachador SIEMPRE requiere S3 (o un directorio compartido) para coordinar nodos.
 type Opciones struct {
-	ConfigS3 tipos.ConfiguracionS3 // Siempre requerido
+	ConfigS3 tipos.ConfiguracionS3 // Requerido salvo que se indique Almacenamiento
+
+	// Almacenamiento, si no es nil, se usa en lugar de crearlo con ConfigS3. Permite
+	// otros backends además de S3 y el directorio local (ConfigS3.Directorio).
+	Almacenamiento tipos.Almacenamiento
+
+	// CacheBloques configura la cache de bloques descargados de S3.
+	// El valor cero habilita una cache en memoria de 64 MiB sin nivel en disco.
+	CacheBloques OpcionesCacheBloques
+
+	// CacheResultados configura la cache de resultados de ConsultarAgregacion y
+	// ConsultarAgregacionTemporal. El valor cero la deshabilita.
+	CacheResultados OpcionesCacheResultados
+
+	// SaludNodos configura el cortocircuito de los nodos edge que no responden
+	SaludNodos OpcionesSaludNodos
+
+	// Latidos configura los umbrales de inactividad de los nodos y la eliminación
+	// de los registros de nodos desconectados
+	Latidos OpcionesLatidos
+
+	// IntervaloSincronizacion es el intervalo entre sincronizaciones del registro
+	// de nodos con S3 (default: 30s)
+	IntervaloSincronizacion time.Duration
+
+	// AlCambiarNodos, si no es nil, se invoca por cada nodo agregado, eliminado o
+	// con series modificadas. Se llama desde la gorutina de sincronización (o desde
+	// EnviarComando al aplicarse un comando de series), nunca en paralelo, y no
+	// debe bloquear.
+	AlCambiarNodos func(EventoNodo)
+
+	// Firmas configura la verificación de los registros firmados por los nodos.
+	// El valor cero admite todos los registros (firmados o no) sin inscripción previa.
+	Firmas OpcionesFirmas
+
+	// Autenticacion configura los secretos con los que se firman las solicitudes a los
+	// nodos edge y los comandos encolados en S3. Debe coincidir con SecretoAutenticacion
+	// de cada edge: sin secreto los comandos a un nodo inaccesible no pueden encolarse.
+	Autenticacion OpcionesAutenticacion
+
+	// TLS configura la verificación de los nodos edge que sirven HTTPS
+	TLS OpcionesTLS
+
+	// Autorizacion configura las claves API, los JWT y los roles de la API REST.
+	// El valor cero deja la API abierta.
+	Autorizacion OpcionesAutorizacion
 }
 
 // opcionesInternas extiende Opciones con campos para testing.
@@ -56,44 +115,96 @@ type clienteEdge interface {
 
 	// ConsultarAgregacionTemporal consulta múltiples agregaciones agrupadas por intervalos (downsampling)
 	ConsultarAgregacionTemporal(ctx context.Context, nodoID string, direccion string, req tipos.SolicitudConsultaAgregacionTemporal) (*tipos.RespuestaConsultaAgregacionTemporal, error)
+
+	// EnviarComando envía un comando de configuración al nodo (ver EnviarComando del manager)
+	EnviarComando(ctx context.Context, nodoID string, direccion string, comando tipos.Comando) (*tipos.ResultadoComando, error)
+}
+
+// OpcionesAutenticacion configura la firma de las solicitudes a los nodos edge.
+// Cada solicitud se firma con HMAC-SHA256 usando el secreto compartido con el nodo.
+type OpcionesAutenticacion struct {
+	// Secreto es el secreto compartido con los nodos sin secreto propio
+	Secreto string
+
+	// SecretosPorNodo asigna secretos propios a nodos: nodoID → secreto
+	SecretosPorNodo map[string]string
+}
+
+// secreto retorna el secreto compartido con un nodo (nil = solicitudes sin firmar)
+func (o OpcionesAutenticacion) secreto(nodoID string) []byte {
+	if secreto, existe := o.SecretosPorNodo[nodoID]; existe && secreto != "" {
+		return []byte(secreto)
+	}
+	if o.Secreto != "" {
+		return []byte(o.Secreto)
+	}
+	return nil
 }
 
 // clienteEdgeHTTP implementa clienteEdge usando HTTP directo
 type clienteEdgeHTTP struct {
-	httpClient *http.Client
+	httpClient    *http.Client
+	autenticacion OpcionesAutenticacion
+
+	huellaNodo   func(nodoID string) string       // Huella del certificado publicada por el nodo (nil = ninguna)
+	clienteTunel func(nodoID string) *http.Client // Cliente por el túnel del nodo (nil = conexión directa)
+	muFijados  sync.Mutex
+	fijados    map[string]*http.Client // Clientes fijados a una huella de certificado
 }
 
-// nuevoClienteEdgeHTTP crea un nuevo cliente HTTP para comunicación con edges
-func nuevoClienteEdgeHTTP() *clienteEdgeHTTP {
+// nuevoClienteEdgeHTTP crea un nuevo cliente HTTP para comunicación con edges.
+// raices son las CA con las que se verifican los nodos https sin huella (nil = sistema).
+func nuevoClienteEdgeHTTP(autenticacion OpcionesAutenticacion, raices *x509.CertPool) *clienteEdgeHTTP {
+	transporte := http.DefaultTransport.(*http.Transport).Clone()
+	transporte.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    raices,
+	}
 	return &clienteEdgeHTTP{
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   10 * time.Second,
+			Transport: transporte,
 		},
+		autenticacion: autenticacion,
+	}
+}
+
+// firmar agrega a la solicitud la autenticación con el secreto del nodo.
+// Sin secreto configurado la solicitud se envía sin firmar.
+func (c *clienteEdgeHTTP) firmar(req *http.Request, cuerpo []byte, nodoID string) error {
+	secreto := c.autenticacion.secreto(nodoID)
+	if secreto == nil {
+		return nil
+	}
+	if err := tipos.FirmarSolicitud(req, cuerpo, nodoID, secreto); err != nil {
+		return fmt.Errorf("error firmando solicitud: %v", err)
 	}
+	return nil
 }
 
 // ConsultarRango implementa clienteEdge
-func (c *clienteEdgeHTTP) ConsultarRango(ctx context.Context, cliente string, direccion string, req tipos.SolicitudConsultaRango) (*tipos.RespuestaConsultaRango, error) {
+func (c *clienteEdgeHTTP) ConsultarRango(ctx context.Context, nodoID string, direccion string, req tipos.SolicitudConsultaRango) (*tipos.RespuestaConsultaRango, error) {
 	// Serializar solicitud con Gob
 	solicitudBytes, err := tipos.SerializarGob(req)
 	if err != nil {
 		return nil, fmt.Errorf("error serializando solicitud: %v", err)
 	}
 
-	// Construir URL (la direccion ya incluye http://host)
+	// Construir URL (la direccion ya incluye el esquema y el host)
 	url := fmt.Sprintf("%s/api/consulta/rango", direccion)
 
-	fmt.Println("solicitud a: " + url)
 	// Crear request con contexto
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(solicitudBytes))
 	if err != nil {
 		return nil, fmt.Errorf("error creando request: %v", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/octet-stream")
-	httpReq.Header.Set("Authorization", "Bearer "+cliente) // Usa el token
+	if err := c.firmar(httpReq, solicitudBytes, nodoID); err != nil {
+		return nil, err
+	}
 
 	// Ejecutar request
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.clientePara(nodoID).Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("error en request HTTP: %v", err)
 	}
@@ -130,18 +241,18 @@ func (c *clienteEdgeHTTP) ConsultarUltimoPunto(ctx context.Context, nodoID strin
 	// Construir URL
 	url := fmt.Sprintf("%s/api/consulta/ultimo", direccion)
 
-	fmt.Println("solicitud a: " + url)
-
 	// Crear request con contexto
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(solicitudBytes))
 	if err != nil {
 		return nil, fmt.Errorf("error creando request: %v", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/octet-stream")
-	httpReq.Header.Set("Authorization", "Bearer "+nodoID) // Usa el token
+	if err := c.firmar(httpReq, solicitudBytes, nodoID); err != nil {
+		return nil, err
+	}
 
 	// Ejecutar request
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.clientePara(nodoID).Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("error en request HTTP: %v", err)
 	}
@@ -184,10 +295,12 @@ func (c *clienteEdgeHTTP) ConsultarAgregacion(ctx context.Context, nodoID string
 		return nil, fmt.Errorf("error creando request: %v", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/octet-stream")
-	httpReq.Header.Set("Authorization", "Bearer "+nodoID) // Usa el token
+	if err := c.firmar(httpReq, solicitudBytes, nodoID); err != nil {
+		return nil, err
+	}
 
 	// Ejecutar request
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.clientePara(nodoID).Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("error en request HTTP: %v", err)
 	}
@@ -230,10 +343,12 @@ func (c *clienteEdgeHTTP) ConsultarAgregacionTemporal(ctx context.Context, nodoI
 		return nil, fmt.Errorf("error creando request: %v", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/octet-stream")
-	httpReq.Header.Set("Authorization", "Bearer "+nodoID) // Usa el token
+	if err := c.firmar(httpReq, solicitudBytes, nodoID); err != nil {
+		return nil, err
+	}
 
 	// Ejecutar request
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.clientePara(nodoID).Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("error en request HTTP: %v", err)
 	}
@@ -260,7 +375,7 @@ func (c *clienteEdgeHTTP) ConsultarAgregacionTemporal(ctx context.Context, nodoI
 }
 
 // Crear inicializa y retorna un nuevo ManagerDespachador.
-// El despachador SIEMPRE requiere una configuración de S3 válida para coordinar nodos.
+// El despachador SIEMPRE requiere un almacenamiento válido para coordinar nodos.
 func Crear(opts Opciones) (*ManagerDespachador, error) {
 	return crearConOpciones(opcionesInternas{Opciones: opts})
 }
@@ -268,63 +383,97 @@ func Crear(opts Opciones) (*ManagerDespachador, error) {
 // crearConOpciones es la función interna que permite inyectar dependencias para testing.
 // No se exporta para evitar uso en producción.
 func crearConOpciones(opts opcionesInternas) (*ManagerDespachador, error) {
-	cfg := opts.ConfigS3
-
-	// Usar cliente S3 inyectado o crear uno nuevo
-	var s3Client tipos.ClienteS3
-	if opts.clienteS3 != nil {
-		s3Client = opts.clienteS3
-	} else {
-		// Crear cliente S3 usando la función centralizada
+	// Usar el almacenamiento o el cliente S3 inyectado, o crear uno nuevo
+	almacenamiento := opts.Almacenamiento
+	if almacenamiento == nil && opts.clienteS3 != nil {
+		almacenamiento = tipos.NuevoAlmacenamientoS3(opts.clienteS3, opts.ConfigS3.Bucket)
+	}
+	if almacenamiento == nil {
+		// Crear el almacenamiento usando la función centralizada
 		var err error
-		s3Client, err = tipos.CrearClienteS3(cfg)
+		almacenamiento, err = tipos.CrearAlmacenamiento(opts.ConfigS3)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	// Verificar que el bucket existe, si no, intentar crearlo
-	ctx := context.TODO()
-	_, err := s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
-		Bucket: aws.String(cfg.Bucket),
-	})
-	if err != nil {
-		log.Printf("El bucket %s no existe, intentando crearlo...", cfg.Bucket)
-		_, err = s3Client.CreateBucket(ctx, &s3.CreateBucketInput{
-			Bucket: aws.String(cfg.Bucket),
-		})
-		if err != nil {
-			return nil, fmt.Errorf("error al crear bucket: %w", err)
-		}
-		log.Printf("Bucket %s creado exitosamente", cfg.Bucket)
+	// Verificar que el bucket (o el directorio) existe, si no, intentar crearlo
+	if err := almacenamiento.Preparar(context.TODO()); err != nil {
+		return nil, err
 	}
 
 	// Usar cliente Edge inyectado o crear uno HTTP real
 	var edgeClient clienteEdge
+	var clienteHTTP *clienteEdgeHTTP
 	if opts.clienteEdge != nil {
 		edgeClient = opts.clienteEdge
 	} else {
-		edgeClient = nuevoClienteEdgeHTTP()
+		raices, err := opts.TLS.cargarRaices()
+		if err != nil {
+			return nil, err
+		}
+		clienteHTTP = nuevoClienteEdgeHTTP(opts.Autenticacion, raices)
+		edgeClient = clienteHTTP
+	}
+
+	cache, err := nuevaCacheBloques(opts.CacheBloques)
+	if err != nil {
+		return nil, err
+	}
+
+	confianza, err := nuevaListaConfianza(opts.Firmas)
+	if err != nil {
+		return nil, err
 	}
 
 	// Crear ManagerDespachador
 	manager := &ManagerDespachador{
-		s3:          s3Client,
-		config:      cfg,
 		nodos:       make(map[string]*tipos.Nodo),
 		done:        make(chan struct{}),
 		clienteEdge: edgeClient,
+		cache:       cache,
+
+		almacenamiento:  almacenamiento,
+		cacheResultados: nuevaCacheResultados(opts.CacheResultados),
+		salud:           nuevoMonitorSalud(opts.SaludNodos),
+		latidos:         opts.Latidos,
+
+		intervaloSincronizacion: opts.IntervaloSincronizacion,
+		alCambiarNodos:          opts.AlCambiarNodos,
+		confianza:               confianza,
+		autorizacion:            nuevoAutorizador(opts.Autorizacion),
+		tuneles:                 nuevoRegistroTuneles(opts.Autenticacion),
+		autenticacion:           opts.Autenticacion,
+	}
+
+	// El cliente HTTP verifica los certificados autofirmados con la huella del registro
+	// y llega a los nodos detrás de NAT por su túnel
+	if clienteHTTP != nil {
+		clienteHTTP.huellaNodo = manager.huellaNodo
+		clienteHTTP.clienteTunel = manager.clienteTunel
 	}
 
 	// Cargar nodos iniciales desde S3
 	if err := manager.cargarNodosDesdeS3(); err != nil {
 		log.Printf("Advertencia: no se pudieron cargar nodos iniciales: %v", err)
 	}
+	if manager.autorizacion != nil {
+		if err := manager.sincronizarClavesAPI(context.TODO()); err != nil {
+			log.Printf("Advertencia: no se pudieron cargar las claves API: %v", err)
+		}
+	} else {
+		log.Printf("Advertencia: API REST sin autenticación (Autorizacion.Habilitada = false)")
+	}
 
 	// Iniciar gorutina que sincroniza periódicamente los nodos
 	go manager.monitorearNodos()
 
-	log.Printf("Conectado a S3 en %s (bucket: %s)", cfg.Endpoint, cfg.Bucket)
+	switch a := almacenamiento.(type) {
+	case *tipos.AlmacenamientoLocal:
+		log.Printf("Usando almacenamiento local en %s", a.Directorio())
+	case *tipos.AlmacenamientoS3:
+		log.Printf("Conectado a S3 en %s (bucket: %s)", opts.ConfigS3.Endpoint, a.Bucket())
+	}
 	log.Printf("Despachador iniciado")
 	return manager, nil
 }
@@ -334,10 +483,16 @@ func (m *ManagerDespachador) Cerrar() error {
 	log.Printf("Cerrando despachador...")
 	// Señalizar cierre
 	close(m.done)
+	m.tuneles.cerrar()
 	log.Printf("Despachador cerrado exitosamente")
 	return nil
 }
 
+// ObtenerSaludNodo retorna la disponibilidad observada de un nodo edge
+func (m *ManagerDespachador) ObtenerSaludNodo(nodoID string) SaludNodo {
+	return m.salud.obtener(nodoID)
+}
+
 // ListarNodos retorna una lista de nodos registrados
 func (m *ManagerDespachador) ListarNodos() []tipos.Nodo {
 	m.mu.RLock()
@@ -407,9 +562,11 @@ func (m *ManagerDespachador) ObtenerSerie(path string) *SerieInfo {
 
 // ObtenerEstadisticas retorna estadísticas generales del despachador
 type EstadisticasDespachador struct {
-	NumNodos  int
-	NumSeries int
-	NumReglas int
+	NumNodos     int
+	NumSeries    int
+	NumReglas    int
+	CacheBloques    EstadisticasCacheBloques    // Vacío si la cache está deshabilitada
+	CacheResultados EstadisticasCacheResultados // Vacío si la cache está deshabilitada
 }
 
 func (m *ManagerDespachador) ObtenerEstadisticas() EstadisticasDespachador {
@@ -423,11 +580,18 @@ func (m *ManagerDespachador) ObtenerEstadisticas() EstadisticasDespachador {
 		numReglas += len(nodo.Reglas)
 	}
 
-	return EstadisticasDespachador{
+	estadisticas := EstadisticasDespachador{
 		NumNodos:  len(m.nodos),
 		NumSeries: numSeries,
 		NumReglas: numReglas,
 	}
+	if m.cache != nil {
+		estadisticas.CacheBloques = m.cache.estadisticas()
+	}
+	if m.cacheResultados != nil {
+		estadisticas.CacheResultados = m.cacheResultados.estadisticas()
+	}
+	return estadisticas
 }
 // ReglaInfo contiene información de una regla incluyendo el nodo al que pertenece
 type ReglaInfo struct {
@@ -501,7 +665,11 @@ func (m *ManagerDespachador) ListarReglasPorNodo(nodoID string) []tipos.Regla {
 
 // monitorearNodos verifica periódicamente el estado de los nodos
 func (m *ManagerDespachador) monitorearNodos() {
-	ticker := time.NewTicker(30 * time.Second)
+	intervalo := m.intervaloSincronizacion
+	if intervalo <= 0 {
+		intervalo = intervaloSincronizacionPorDefecto
+	}
+	ticker := time.NewTicker(intervalo)
 	defer ticker.Stop()
 
 	for {
@@ -512,68 +680,70 @@ func (m *ManagerDespachador) monitorearNodos() {
 			if err := m.cargarNodosDesdeS3(); err != nil {
 				log.Printf("Error al cargar nodos desde S3: %v", err)
 			}
+			if err := m.sincronizarClavesAPI(context.TODO()); err != nil {
+				log.Printf("Error al cargar claves API desde S3: %v", err)
+			}
 		}
 	}
 }
 
-// cargarNodosDesdeS3 sincroniza la lista de nodos con S3
+// cargarNodosDesdeS3 sincroniza la lista de nodos con S3.
+// Solo descarga los registros y latidos modificados y bloquea m.mu únicamente para
+// reemplazar el mapa de nodos.
 func (m *ManagerDespachador) cargarNodosDesdeS3() error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.sincronizacion.mu.Lock()
+	defer m.sincronizacion.mu.Unlock()
 
 	ctx := context.TODO()
 
-	// Listar todos los objetos en el bucket con prefijo "nodos/"
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(m.config.Bucket),
-		Prefix: aws.String("nodos/"),
-	}
-
-	result, err := m.s3.ListObjectsV2(ctx, input)
+	// Listar todos los objetos en el bucket con prefijo "nodos/" (paginado)
+	objetos, err := tipos.ListarObjetos(ctx, m.almacenamiento, "nodos/")
 	if err != nil {
 		return fmt.Errorf("error listando nodos desde S3: %v", err)
 	}
 
-	// Actualizar la lista de nodos en memoria
-	nuevosNodos := make(map[string]*tipos.Nodo)
+	registros, descargas := sincronizarObjetos(ctx, m, objetos, m.sincronizacion.registros, "nodo")
+	m.sincronizacion.registros = registros
 
-	for _, obj := range result.Contents {
-		// Obtener el objeto completo
-		getInput := &s3.GetObjectInput{
-			Bucket: aws.String(m.config.Bucket),
-			Key:    obj.Key,
-		}
+	// Los latidos son opcionales: sin ellos se usa la última conexión del registro
+	latidos, err := m.cargarLatidos(ctx)
+	if err != nil {
+		log.Printf("Advertencia: %v", err)
+	}
 
-		getOutput, err := m.s3.GetObject(ctx, getInput)
-		if err != nil {
-			log.Printf("Error obteniendo nodo %s: %v", *obj.Key, err)
+	nuevosNodos := make(map[string]*tipos.Nodo, len(registros))
+	rechazados := make(map[string]RegistroRechazado)
+	for _, registro := range registros {
+		nodo := registro.valor.nodo
+		if err := m.confianza.admitir(registro.valor); err != nil {
+			rechazados[nodo.NodoID] = nuevoRechazo(nodo, err)
 			continue
 		}
-
-		// Leer el contenido
-		data, err := io.ReadAll(getOutput.Body)
-		getOutput.Body.Close()
-		if err != nil {
-			log.Printf("Error leyendo nodo %s: %v", *obj.Key, err)
-			continue
+		if latido, existe := latidos[nodo.NodoID]; existe {
+			aplicarLatido(&nodo, latido)
 		}
-
-		// Deserializar el nodo
-		var nodo tipos.Nodo
-		if err := json.Unmarshal(data, &nodo); err != nil {
-			log.Printf("Error deserializando nodo %s: %v", *obj.Key, err)
-			continue
-		}
-
 		nuevosNodos[nodo.NodoID] = &nodo
 	}
 
+	m.confianza.actualizarRechazados(rechazados)
+	m.expirarNodos(ctx, nuevosNodos)
+
 	// Reemplazar la lista de nodos
+	m.mu.Lock()
+	eventos := compararNodos(m.nodos, nuevosNodos)
 	m.nodos = nuevosNodos
+	m.mu.Unlock()
 
-	if len(nuevosNodos) > 0 {
-		log.Printf("Cargados %d nodos desde S3", len(nuevosNodos))
+	registrados := make(map[string]bool, len(nuevosNodos))
+	for nodoID := range nuevosNodos {
+		registrados[nodoID] = true
 	}
+	m.salud.olvidar(registrados)
+
+	if descargas > 0 {
+		log.Printf("Sincronizados %d nodos desde S3 (%d registros descargados)", len(nuevosNodos), descargas)
+	}
+	m.notificarEventos(eventos)
 
 	return nil
 }
@@ -604,13 +774,23 @@ func (m *ManagerDespachador) consultarPuntoEdge(nodo tipos.Nodo, nombreSerie str
 		solicitud.TiempoFin = &t
 	}
 
+	if m.EstadoConexionNodo(nodo) == ConexionDesconectada {
+		return tipos.ResultadoConsultaPunto{}, errNodoDesconectado
+	}
+	if !m.salud.permitir(nodo.NodoID) {
+		return tipos.ResultadoConsultaPunto{}, errNodoNoDisponible
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	respuesta, err := m.clienteEdge.ConsultarUltimoPunto(ctx, nodo.NodoID, nodo.Direccion, solicitud)
+	comienzo := time.Now()
+	respuesta, err := m.clienteEdge.ConsultarUltimoPunto(ctx, nodo.NodoID, nodo.URLBase(), solicitud)
 	if err != nil {
+		m.salud.registrarFallo(nodo.NodoID, err)
 		return tipos.ResultadoConsultaPunto{}, err
 	}
+	m.salud.registrarExito(nodo.NodoID, time.Since(comienzo))
 
 	if respuesta.Error != "" {
 		return tipos.ResultadoConsultaPunto{}, fmt.Errorf("error del edge: %s", respuesta.Error)
@@ -619,95 +799,160 @@ func (m *ManagerDespachador) consultarPuntoEdge(nodo tipos.Nodo, nombreSerie str
 	return respuesta.Resultado, nil
 }
 
-// descargarYDescomprimirBloque descarga un bloque de S3 y lo descomprime
-func (m *ManagerDespachador) descargarYDescomprimirBloque(clave string, serie tipos.Serie) ([]tipos.Medicion, error) {
-	ctx := context.TODO()
-	getOutput, err := m.s3.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(m.config.Bucket),
-		Key:    aws.String(clave),
-	})
+// descargarYDescomprimirBloque descarga un bloque de S3 y lo descomprime.
+// Si la cache de bloques está habilitada, los bloques con ETag conocido se sirven
+// desde la cache; cuando el ETag del listado no está disponible se revalida el bloque
+// cacheado con una descarga condicional (If-None-Match).
+func (m *ManagerDespachador) descargarYDescomprimirBloque(bloque tipos.BloqueManifiesto, serie tipos.Serie) ([]tipos.Medicion, error) {
+	clave := bloque.Clave
+	etag := bloque.ETag
+
+	if m.cache != nil && etag != "" {
+		if mediciones, ok := m.cache.obtener(clave, etag, serie); ok {
+			return mediciones, nil
+		}
+	}
+
+	etagCacheado := ""
+	if m.cache != nil && etag == "" {
+		etagCacheado = m.cache.etagConocido(clave)
+	}
+
+	datosComprimidos, etagDescargado, err := m.almacenamiento.Leer(context.TODO(), clave, etagCacheado)
 	if err != nil {
+		if etagCacheado != "" && errors.Is(err, tipos.ErrNoModificado) {
+			if mediciones, ok := m.cache.obtener(clave, etagCacheado, serie); ok {
+				m.cache.registrarRevalidacion()
+				return mediciones, nil
+			}
+			// La entrada se desalojó entre la consulta del ETag y la respuesta
+			return m.descargarYDescomprimirBloque(tipos.BloqueManifiesto{Clave: clave}, serie)
+		}
 		return nil, fmt.Errorf("error descargando bloque %s: %v", clave, err)
 	}
 
-	datosComprimidos, err := io.ReadAll(getOutput.Body)
-	getOutput.Body.Close()
+	mediciones, err := descomprimirBloqueSerie(datosComprimidos, serie)
 	if err != nil {
-		return nil, fmt.Errorf("error leyendo bloque %s: %v", clave, err)
+		return nil, fmt.Errorf("error descomprimiendo bloque %s: %v", clave, err)
 	}
 
-	mediciones, err := compresor.DescomprimirBloqueSerie(
-		datosComprimidos,
+	if m.cache != nil {
+		m.cache.registrarFallo()
+		if etagDescargado != "" {
+			etag = etagDescargado
+		}
+		m.cache.guardar(clave, etag, datosComprimidos, mediciones)
+	}
+
+	return mediciones, nil
+}
+
+// descomprimirBloqueSerie descomprime un bloque según la configuración de compresión de la serie
+func descomprimirBloqueSerie(datos []byte, serie tipos.Serie) ([]tipos.Medicion, error) {
+	return compresor.DescomprimirBloqueSerie(
+		datos,
 		serie.TipoDatos,
 		serie.CompresionBytes,
 		serie.CompresionBloque,
 	)
-	if err != nil {
-		return nil, fmt.Errorf("error descomprimiendo bloque %s: %v", clave, err)
-	}
-
-	return mediciones, nil
 }
 
 // listarBloquesEnRango lista los bloques de S3 que intersectan con el rango de tiempo dado
-// Retorna las claves de los objetos S3 ordenadas por tiempo
-func (m *ManagerDespachador) listarBloquesEnRango(nodoID string, serieID int, inicio, fin int64) ([]string, error) {
+// Retorna los bloques (clave y ETag si se conoce) ordenados por tiempo
+//
+// Usa el manifiesto de la serie (una sola lectura); si no existe o no es legible
+// recurre al listado paginado de los bloques según la disposición de claves del nodo.
+func (m *ManagerDespachador) listarBloquesEnRango(nodoID string, serieID int, inicio, fin int64, disposicion tipos.DisposicionClaves) ([]tipos.BloqueManifiesto, error) {
 	ctx := context.TODO()
 
-	// Prefijo para buscar bloques: <nodoID>/<serieID>_
-	prefijo := tipos.GenerarPrefijoS3Serie(nodoID, serieID)
-
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(m.config.Bucket),
-		Prefix: aws.String(prefijo),
+	manifiesto, err := tipos.LeerManifiesto(ctx, m.almacenamiento, nodoID, serieID)
+	if err == nil {
+		return manifiesto.BloquesEnRango(inicio, fin), nil
 	}
 
-	result, err := m.s3.ListObjectsV2(ctx, input)
-	if err != nil {
-		return nil, fmt.Errorf("error listando bloques desde S3: %v", err)
+	// Prefijo para buscar bloques: <nodoID>/<serieID>_ o <nodoID>/<serieID>/
+	prefijo := disposicion.GenerarPrefijoSerie(nodoID, serieID)
+	desde := ""
+
+	// En la disposición particionada cada bloque está en la partición del día de su tiempoFin:
+	// los bloques que intersectan con el rango están en la partición del día de inicio o en
+	// posteriores, y el recorrido termina pasada la partición del día de fin
+	particionFin := ""
+	if disposicion.EsParticionada() {
+		particionInicio := tipos.GenerarPrefijoS3Particion(nodoID, serieID, inicio)
+		desde = strings.TrimSuffix(particionInicio, "/")
+		particionFin = tipos.GenerarPrefijoS3Particion(nodoID, serieID, fin)
 	}
 
-	var bloquesEnRango []string
+	var encontrados []tipos.BloqueManifiesto
 
-	for _, obj := range result.Contents {
+	err = m.almacenamiento.Recorrer(ctx, prefijo, desde, func(obj tipos.ObjetoAlmacenado) bool {
 		// Extraer tiempos del nombre del bloque usando función centralizada
-		// Formato: <nodoID>/<serieID>_<tiempoInicio>_<tiempoFin>
-		clave := *obj.Key
+		clave := obj.Clave
 		_, bloqueInicio, bloqueFin, err := tipos.ParsearClaveS3Datos(clave)
 		if err != nil {
-			continue // Ignorar bloques con formato inválido
+			return true // Ignorar bloques con formato inválido (incluye el manifiesto)
+		}
+
+		// Pasada la partición del día de fin, un bloque que comienza después del rango
+		// indica que los siguientes tampoco intersectan
+		if particionFin != "" && clave > particionFin && !strings.HasPrefix(clave, particionFin) && bloqueInicio > fin {
+			return false
 		}
 
 		// Verificar si el bloque intersecta con el rango solicitado
 		// Un bloque intersecta si: bloqueInicio <= fin AND bloqueFin >= inicio
 		if bloqueInicio <= fin && bloqueFin >= inicio {
-			bloquesEnRango = append(bloquesEnRango, clave)
+			encontrados = append(encontrados, tipos.BloqueManifiesto{
+				Clave:        clave,
+				TiempoInicio: bloqueInicio,
+				TiempoFin:    bloqueFin,
+				Tamaño:       obj.Tamaño,
+				ETag:         obj.ETag,
+			})
 		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listando bloques desde S3: %v", err)
 	}
 
-	// Ordenar bloques por tiempo de inicio (el nombre incluye el tiempo con padding)
-	sort.Strings(bloquesEnRango)
+	// Ordenar bloques por tiempo de inicio (en la disposición particionada el orden
+	// de las claves sigue al tiempo de fin)
+	sort.Slice(encontrados, func(i, j int) bool {
+		if encontrados[i].TiempoInicio != encontrados[j].TiempoInicio {
+			return encontrados[i].TiempoInicio < encontrados[j].TiempoInicio
+		}
+		return encontrados[i].Clave < encontrados[j].Clave
+	})
 
-	return bloquesEnRango, nil
+	return encontrados, nil
 }
 
 // consultarDatosS3 descarga y descomprime bloques de S3 en el rango especificado
 // Usa 10 workers para descargas paralelas, sin timeout por bloque para no perder datos
 func (m *ManagerDespachador) consultarDatosS3(nodo tipos.Nodo, serie tipos.Serie, inicio, fin int64) ([]tipos.Medicion, error) {
+	mediciones, _, err := m.consultarDatosS3ConFallos(nodo, serie, inicio, fin)
+	return mediciones, err
+}
+
+// consultarDatosS3ConFallos es consultarDatosS3 informando además la cantidad de bloques
+// que no pudieron descargarse (el resultado es parcial si es mayor a cero)
+func (m *ManagerDespachador) consultarDatosS3ConFallos(nodo tipos.Nodo, serie tipos.Serie, inicio, fin int64) ([]tipos.Medicion, int, error) {
 	// Listar bloques en el rango
-	bloques, err := m.listarBloquesEnRango(nodo.NodoID, serie.SerieId, inicio, fin)
+	bloques, err := m.listarBloquesEnRango(nodo.NodoID, serie.SerieId, inicio, fin, nodo.DisposicionClaves)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if len(bloques) == 0 {
-		return []tipos.Medicion{}, nil
+		return []tipos.Medicion{}, 0, nil
 	}
 
 	const numWorkers = 10
 
 	// Canal para distribuir trabajo
-	bloqueChan := make(chan string, len(bloques))
+	bloqueChan := make(chan tipos.BloqueManifiesto, len(bloques))
 	// Canal para recolectar resultados
 	resultadoChan := make(chan struct {
 		mediciones []tipos.Medicion
@@ -721,8 +966,8 @@ func (m *ManagerDespachador) consultarDatosS3(nodo tipos.Nodo, serie tipos.Serie
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			for clave := range bloqueChan {
-				mediciones, err := m.descargarYDescomprimirBloque(clave, serie)
+			for bloque := range bloqueChan {
+				mediciones, err := m.descargarYDescomprimirBloque(bloque, serie)
 				resultadoChan <- struct {
 					mediciones []tipos.Medicion
 					err        error
@@ -733,8 +978,8 @@ func (m *ManagerDespachador) consultarDatosS3(nodo tipos.Nodo, serie tipos.Serie
 
 	// Enviar bloques al canal de trabajo
 	go func() {
-		for _, clave := range bloques {
-			bloqueChan <- clave
+		for _, bloque := range bloques {
+			bloqueChan <- bloque
 		}
 		close(bloqueChan)
 	}()
@@ -764,14 +1009,15 @@ func (m *ManagerDespachador) consultarDatosS3(nodo tipos.Nodo, serie tipos.Serie
 
 	// Si todos los bloques fallaron, retornar error
 	if errores == len(bloques) {
-		return nil, fmt.Errorf("todos los bloques fallaron al descargar de S3")
+		return nil, errores, fmt.Errorf("todos los bloques fallaron al descargar de S3")
 	}
 
-	return todasMediciones, nil
+	return todasMediciones, errores, nil
 }
 
 // consultarEdgeConTimeout consulta datos al edge con un timeout específico
-// Retorna resultado vacío y nil si el edge no está disponible (timeout o error de conexión)
+// Retorna resultado vacío y error si el edge no está disponible (timeout, error de conexión,
+// nodo desconectado o circuito abierto); los llamadores lo informan en NodosNoDisponibles.
 func (m *ManagerDespachador) consultarEdgeConTimeout(nodo tipos.Nodo, serie string, inicio, fin int64, timeout time.Duration) (tipos.ResultadoConsultaRango, error) {
 	solicitud := tipos.SolicitudConsultaRango{
 		Serie:        serie,
@@ -779,15 +1025,24 @@ func (m *ManagerDespachador) consultarEdgeConTimeout(nodo tipos.Nodo, serie stri
 		TiempoFin:    fin,
 	}
 
+	// Un nodo desconectado o con el circuito abierto no se consulta: se evita esperar el timeout
+	if m.EstadoConexionNodo(nodo) == ConexionDesconectada {
+		return tipos.ResultadoConsultaRango{}, errNodoDesconectado
+	}
+	if !m.salud.permitir(nodo.NodoID) {
+		return tipos.ResultadoConsultaRango{}, errNodoNoDisponible
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	respuesta, err := m.clienteEdge.ConsultarRango(ctx, nodo.NodoID, nodo.Direccion, solicitud)
+	comienzo := time.Now()
+	respuesta, err := m.clienteEdge.ConsultarRango(ctx, nodo.NodoID, nodo.URLBase(), solicitud)
 	if err != nil {
-		// Timeout o error de conexión no es crítico, el edge puede estar offline
-		log.Printf("Error consultando edge %s (serie: %s): %v", nodo.NodoID, serie, err)
-		return tipos.ResultadoConsultaRango{}, nil
+		m.salud.registrarFallo(nodo.NodoID, err)
+		return tipos.ResultadoConsultaRango{}, fmt.Errorf("error consultando edge %s: %v", nodo.NodoID, err)
 	}
+	m.salud.registrarExito(nodo.NodoID, time.Since(comienzo))
 
 	if respuesta.Error != "" {
 		return tipos.ResultadoConsultaRango{}, fmt.Errorf("error del edge: %s", respuesta.Error)
@@ -922,19 +1177,25 @@ func (m *ManagerDespachador) combinarResultadosTabulares(resultados []tipos.Resu
 // Soporta wildcards en el path de la serie (ej: */temp, sensor_01/*).
 // Retorna resultado en formato tabular.
 func (m *ManagerDespachador) ConsultarRango(nombreSerie string, tiempoInicio, tiempoFin time.Time) (tipos.ResultadoConsultaRango, error) {
+	resultado, _, err := m.consultarRango(nombreSerie, tiempoInicio.UnixNano(), tiempoFin.UnixNano())
+	return resultado, err
+}
+
+// consultarRango implementa ConsultarRango con tiempos en nanosegundos.
+// completo indica que todos los bloques de S3 del rango se leyeron sin errores
+// (los errores de edge no lo afectan: se informan como nodos no disponibles).
+func (m *ManagerDespachador) consultarRango(nombreSerie string, inicio, fin int64) (resultado tipos.ResultadoConsultaRango, completo bool, err error) {
 	// Buscar todas las series que coincidan (path exacto o wildcard)
 	seriesEncontradas, err := m.buscarSeriesPorPath(nombreSerie)
 	if err != nil {
-		return tipos.ResultadoConsultaRango{}, err
+		return tipos.ResultadoConsultaRango{}, false, err
 	}
 
-	inicio := tiempoInicio.UnixNano()
-	fin := tiempoFin.UnixNano()
-
 	// Canal para recoger resultados de todas las consultas
 	type resultadoSerie struct {
 		resultado tipos.ResultadoConsultaRango
 		errS3     error
+		fallosS3  int
 		errEdge   error
 		path      string
 		nodoID    string
@@ -947,9 +1208,10 @@ func (m *ManagerDespachador) ConsultarRango(nombreSerie string, tiempoInicio, ti
 			var datosS3 []tipos.Medicion
 			var datosEdge tipos.ResultadoConsultaRango
 			var errS3, errEdge error
+			var fallosS3 int
 
 			// Consultar S3
-			datosS3, errS3 = m.consultarDatosS3(sn.nodo, sn.serie, inicio, fin)
+			datosS3, fallosS3, errS3 = m.consultarDatosS3ConFallos(sn.nodo, sn.serie, inicio, fin)
 
 			// Consultar edge
 			datosEdge, errEdge = m.consultarEdgeConTimeout(sn.nodo, sn.path, inicio, fin, 5*time.Second)
@@ -957,6 +1219,7 @@ func (m *ManagerDespachador) ConsultarRango(nombreSerie string, tiempoInicio, ti
 			resultados <- resultadoSerie{
 				resultado: m.combinarResultadosTabular(datosS3, datosEdge, sn.path),
 				errS3:     errS3,
+				fallosS3:  fallosS3,
 				errEdge:   errEdge,
 				path:      sn.path,
 				nodoID:    sn.nodo.NodoID,
@@ -968,6 +1231,7 @@ func (m *ManagerDespachador) ConsultarRango(nombreSerie string, tiempoInicio, ti
 	var todosResultados []tipos.ResultadoConsultaRango
 	var erroresS3 []string
 	nodosNoDisponibles := make(map[string]struct{}) // Usar mapa para evitar duplicados
+	completo = true
 
 	for i := 0; i < len(seriesEncontradas); i++ {
 		res := <-resultados
@@ -976,6 +1240,9 @@ func (m *ManagerDespachador) ConsultarRango(nombreSerie string, tiempoInicio, ti
 		if res.errS3 != nil {
 			erroresS3 = append(erroresS3, fmt.Sprintf("%s: %v", res.path, res.errS3))
 		}
+		if res.errS3 != nil || res.fallosS3 > 0 {
+			completo = false
+		}
 
 		// Los errores de edge se registran como nodos no disponibles
 		if res.errEdge != nil {
@@ -991,11 +1258,11 @@ func (m *ManagerDespachador) ConsultarRango(nombreSerie string, tiempoInicio, ti
 
 	// Si hubo errores de S3 en todas las series, reportar
 	if len(erroresS3) == len(seriesEncontradas) {
-		return tipos.ResultadoConsultaRango{}, fmt.Errorf("error consultando S3: %v", erroresS3)
+		return tipos.ResultadoConsultaRango{}, false, fmt.Errorf("error consultando S3: %v", erroresS3)
 	}
 
 	// Combinar todos los resultados en formato tabular final
-	resultado := m.combinarResultadosTabulares(todosResultados)
+	resultado = m.combinarResultadosTabulares(todosResultados)
 
 	// Agregar nodos no disponibles al resultado
 	for nodoID := range nodosNoDisponibles {
@@ -1003,7 +1270,7 @@ func (m *ManagerDespachador) ConsultarRango(nombreSerie string, tiempoInicio, ti
 	}
 	sort.Strings(resultado.NodosNoDisponibles)
 
-	return resultado, nil
+	return resultado, completo, nil
 }
 
 // ConsultarUltimoPunto busca el último punto de cada serie combinando S3 y edge.
@@ -1069,7 +1336,7 @@ func (m *ManagerDespachador) ConsultarUltimoPunto(nombreSerie string, tiempoInic
 
 			// Si el edge no responde o no tiene datos, buscar en S3
 			if !encontrado {
-				bloques, err := m.listarBloquesEnRango(sn.nodo.NodoID, sn.serie.SerieId, inicioNano, finNano)
+				bloques, err := m.listarBloquesEnRango(sn.nodo.NodoID, sn.serie.SerieId, inicioNano, finNano, sn.nodo.DisposicionClaves)
 				if err == nil && len(bloques) > 0 {
 					ultimoBloque := bloques[len(bloques)-1]
 					mediciones, err := m.descargarYDescomprimirBloque(ultimoBloque, sn.serie)
@@ -1270,6 +1537,7 @@ func (m *ManagerDespachador) buscarSeriesPorPath(path string) ([]serieConNodo, e
 // Soporta tipos de agregación: promedio, maximo, minimo, suma, count.
 // Soporta wildcards en el path de la serie (ej: */temp, sensor_01/*).
 // Retorna una matriz donde Valores[agregacion][serie] contiene el valor agregado.
+// Con la cache de resultados habilitada solo se vuelve a consultar el tramo no migrado a S3.
 func (m *ManagerDespachador) ConsultarAgregacion(
 	nombreSerie string,
 	tiempoInicio, tiempoFin time.Time,
@@ -1279,6 +1547,17 @@ func (m *ManagerDespachador) ConsultarAgregacion(
 		return tipos.ResultadoAgregacion{}, fmt.Errorf("debe especificar al menos una agregación")
 	}
 
+	if m.cacheResultados != nil {
+		acumulados, nodosNoDisponibles, err := m.consultarAcumulados(nombreSerie, tiempoInicio.UnixNano(), tiempoFin.UnixNano(), 0, 1)
+		if err != nil {
+			return tipos.ResultadoAgregacion{}, err
+		}
+		if len(acumulados) == 0 {
+			return tipos.ResultadoAgregacion{}, fmt.Errorf("no se encontraron datos para %s en el rango especificado", nombreSerie)
+		}
+		return construirAgregacion(acumulados, agregaciones, nodosNoDisponibles), nil
+	}
+
 	// Usar ConsultarRango para obtener datos combinados
 	resultado, err := m.ConsultarRango(nombreSerie, tiempoInicio, tiempoFin)
 	if err != nil {
@@ -1340,11 +1619,33 @@ func (m *ManagerDespachador) ConsultarAgregacion(
 // Soporta wildcards en el path de la serie (ej: */temp, sensor_01/*).
 // Retorna una matriz donde Valores[agregacion][bucket][serie] contiene el valor agregado.
 // Los valores faltantes (bucket sin datos para una serie) se representan como math.NaN().
+// Los buckets comienzan en tiempoInicio, como en el edge. Para que consultas relativas
+// (now-1h) repetidas dentro de un mismo intervalo compartan la entrada de la cache, el
+// rango puede alinearse antes con AlinearRangoBuckets.
+// Con la cache de resultados habilitada solo se vuelve a consultar el tramo no migrado a S3.
 func (m *ManagerDespachador) ConsultarAgregacionTemporal(
 	nombreSerie string,
 	tiempoInicio, tiempoFin time.Time,
 	agregaciones []tipos.TipoAgregacion,
 	intervalo time.Duration,
+) (tipos.ResultadoAgregacionTemporal, error) {
+	resultado, err := m.agregacionTemporal(nombreSerie, tiempoInicio, tiempoFin, agregaciones, intervalo)
+	if err != nil {
+		return tipos.ResultadoAgregacionTemporal{}, err
+	}
+	if len(resultado.Series) == 0 {
+		return tipos.ResultadoAgregacionTemporal{}, fmt.Errorf("no se encontraron datos para la serie %s en el rango especificado", nombreSerie)
+	}
+	return resultado, nil
+}
+
+// agregacionTemporal resuelve ConsultarAgregacionTemporal sin tratar como error la falta
+// de datos: en ese caso el resultado tiene los buckets y ninguna serie.
+func (m *ManagerDespachador) agregacionTemporal(
+	nombreSerie string,
+	tiempoInicio, tiempoFin time.Time,
+	agregaciones []tipos.TipoAgregacion,
+	intervalo time.Duration,
 ) (tipos.ResultadoAgregacionTemporal, error) {
 	if len(agregaciones) == 0 {
 		return tipos.ResultadoAgregacionTemporal{}, fmt.Errorf("debe especificar al menos una agregación")
@@ -1354,16 +1655,37 @@ func (m *ManagerDespachador) ConsultarAgregacionTemporal(
 		return tipos.ResultadoAgregacionTemporal{}, fmt.Errorf("intervalo debe ser mayor a cero")
 	}
 
+	if m.cacheResultados != nil {
+		inicio, fin := tiempoInicio.UnixNano(), tiempoFin.UnixNano()
+		buckets := generarBuckets(inicio, fin, intervalo.Nanoseconds())
+		acumulados, nodosNoDisponibles, err := m.consultarAcumulados(nombreSerie, inicio, fin, intervalo.Nanoseconds(), len(buckets))
+		if err != nil {
+			return tipos.ResultadoAgregacionTemporal{}, err
+		}
+		return construirAgregacionTemporal(acumulados, buckets, agregaciones, nodosNoDisponibles), nil
+	}
+
 	// Usar ConsultarRango para obtener datos combinados
 	resultado, err := m.ConsultarRango(nombreSerie, tiempoInicio, tiempoFin)
 	if err != nil {
 		return tipos.ResultadoAgregacionTemporal{}, err
 	}
-
-	if len(resultado.Series) == 0 || len(resultado.Tiempos) == 0 {
-		return tipos.ResultadoAgregacionTemporal{}, fmt.Errorf("no se encontraron datos para la serie %s en el rango especificado", nombreSerie)
+	if len(resultado.Tiempos) == 0 {
+		resultado.Series = nil // Sin mediciones en el rango
 	}
 
+	return agregarEnBuckets(resultado, tiempoInicio, tiempoFin, agregaciones, intervalo), nil
+}
+
+// agregarEnBuckets agrupa un resultado tabular en buckets de tamaño intervalo dentro de
+// [tiempoInicio, tiempoFin) y calcula las agregaciones por bucket y serie.
+// Los buckets sin datos se representan como math.NaN().
+func agregarEnBuckets(
+	resultado tipos.ResultadoConsultaRango,
+	tiempoInicio, tiempoFin time.Time,
+	agregaciones []tipos.TipoAgregacion,
+	intervalo time.Duration,
+) tipos.ResultadoAgregacionTemporal {
 	// Generar buckets temporales
 	buckets := generarBuckets(tiempoInicio.UnixNano(), tiempoFin.UnixNano(), intervalo.Nanoseconds())
 	numBuckets := len(buckets)
@@ -1382,8 +1704,12 @@ func (m *ManagerDespachador) ConsultarAgregacionTemporal(
 	// Distribuir valores en acumuladores
 	intervaloNano := intervalo.Nanoseconds()
 	tiempoInicioNano := tiempoInicio.UnixNano()
+	tiempoFinNano := tiempoFin.UnixNano()
 
 	for filaIdx, tiempo := range resultado.Tiempos {
+		if tiempo > tiempoFinNano {
+			continue // Fuera del rango: un nodo puede retornar puntos posteriores
+		}
 		bucketIdx := calcularBucketIdx(tiempo, tiempoInicioNano, intervaloNano, numBuckets)
 		if bucketIdx < 0 || bucketIdx >= numBuckets {
 			continue
@@ -1435,7 +1761,45 @@ func (m *ManagerDespachador) ConsultarAgregacionTemporal(
 		Agregaciones:       agregaciones,
 		Valores:            valores, // [agregacion][bucket][serie]
 		NodosNoDisponibles: resultado.NodosNoDisponibles,
-	}, nil
+	}
+}
+
+// ConsultarRangoConVentana ejecuta ConsultarRango y aplica la función de ventana indicada
+// sobre el resultado combinado (S3 + edge) de cada serie.
+// La ventana se aplica en el despachador y no en los nodos, para que los puntos
+// migrados a S3 participen del cálculo.
+func (m *ManagerDespachador) ConsultarRangoConVentana(nombreSerie string, tiempoInicio, tiempoFin time.Time, ventana tipos.FuncionVentana) (tipos.ResultadoConsultaRango, error) {
+	if err := ventana.Validar(); err != nil {
+		return tipos.ResultadoConsultaRango{}, err
+	}
+
+	resultado, err := m.ConsultarRango(nombreSerie, tiempoInicio, tiempoFin)
+	if err != nil {
+		return tipos.ResultadoConsultaRango{}, err
+	}
+
+	return tipos.AplicarVentanaRango(resultado, ventana)
+}
+
+// ConsultarAgregacionTemporalConVentana ejecuta ConsultarAgregacionTemporal y aplica la función
+// de ventana sobre los buckets resultantes, para cada agregación y serie.
+func (m *ManagerDespachador) ConsultarAgregacionTemporalConVentana(
+	nombreSerie string,
+	tiempoInicio, tiempoFin time.Time,
+	agregaciones []tipos.TipoAgregacion,
+	intervalo time.Duration,
+	ventana tipos.FuncionVentana,
+) (tipos.ResultadoAgregacionTemporal, error) {
+	if err := ventana.Validar(); err != nil {
+		return tipos.ResultadoAgregacionTemporal{}, err
+	}
+
+	resultado, err := m.ConsultarAgregacionTemporal(nombreSerie, tiempoInicio, tiempoFin, agregaciones, intervalo)
+	if err != nil {
+		return tipos.ResultadoAgregacionTemporal{}, err
+	}
+
+	return tipos.AplicarVentanaAgregacionTemporal(resultado, ventana)
 }
 
 // generarBuckets genera los timestamps de inicio de cada bucket temporal
@@ -1447,6 +1811,32 @@ func generarBuckets(tiempoInicio, tiempoFin, intervalo int64) []int64 {
 	return buckets
 }
 
+// AlinearRangoBuckets extiende [tiempoInicio, tiempoFin] a múltiplos de intervalo, para
+// consultar ConsultarAgregacionTemporal con buckets alineados. El resultado incluye los
+// buckets completos que se superponen con el rango, con datos fuera de él.
+func AlinearRangoBuckets(tiempoInicio, tiempoFin time.Time, intervalo time.Duration) (time.Time, time.Time) {
+	if intervalo <= 0 {
+		return tiempoInicio, tiempoFin
+	}
+	inicio, fin := alinearBuckets(tiempoInicio.UnixNano(), tiempoFin.UnixNano(), intervalo.Nanoseconds())
+	return time.Unix(0, inicio), time.Unix(0, fin)
+}
+
+// alinearBuckets extiende [tiempoInicio, tiempoFin] a múltiplos de intervalo: retorna el
+// inicio del bucket que contiene tiempoInicio y el fin del bucket que contiene tiempoFin
+// (tiempoFin si ya es un múltiplo)
+func alinearBuckets(tiempoInicio, tiempoFin, intervalo int64) (int64, int64) {
+	inicio := tiempoInicio - tiempoInicio%intervalo
+	if tiempoInicio%intervalo < 0 {
+		inicio -= intervalo
+	}
+	fin := tiempoFin - tiempoFin%intervalo
+	if tiempoFin%intervalo > 0 {
+		fin += intervalo
+	}
+	return inicio, fin
+}
+
 // calcularBucketIdx calcula el índice del bucket para un timestamp dado
 func calcularBucketIdx(tiempo, tiempoInicio, intervalo int64, numBuckets int) int {
 	if tiempo < tiempoInicio {
@@ -1,23 +1,39 @@
 package despachador
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/cbiale/sensorwave/compresor"
 	"github.com/cbiale/sensorwave/tipos"
+	"github.com/cbiale/sensorwave/tipos/s3fake"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
 )
 
 // ============================================================================
@@ -31,9 +47,18 @@ type mockClienteEdge struct {
 	respuestaAgregacion         *tipos.RespuestaConsultaAgregacion
 	respuestaAgregacionTemporal *tipos.RespuestaConsultaAgregacionTemporal
 	err                         error
+
+	// Comandos: resultadoComando nil responde solo con el ID del comando
+	resultadoComando *tipos.ResultadoComando
+	errComando       error
+	comandos         []tipos.Comando
+
+	// Para verificar llamadas
+	llamadasRango atomic.Int32
 }
 
 func (m *mockClienteEdge) ConsultarRango(ctx context.Context, cliente string, direccion string, req tipos.SolicitudConsultaRango) (*tipos.RespuestaConsultaRango, error) {
+	m.llamadasRango.Add(1)
 	if m.err != nil {
 		return nil, m.err
 	}
@@ -61,6 +86,17 @@ func (m *mockClienteEdge) ConsultarAgregacionTemporal(ctx context.Context, clien
 	return m.respuestaAgregacionTemporal, nil
 }
 
+func (m *mockClienteEdge) EnviarComando(ctx context.Context, cliente string, direccion string, comando tipos.Comando) (*tipos.ResultadoComando, error) {
+	m.comandos = append(m.comandos, comando)
+	if m.errComando != nil {
+		return nil, m.errComando
+	}
+	if m.resultadoComando != nil {
+		return m.resultadoComando, nil
+	}
+	return &tipos.ResultadoComando{ID: comando.ID}, nil
+}
+
 // crearRespuestaRangoTabular es un helper para crear respuestas en formato tabular
 // a partir de una lista de mediciones y el path de la serie
 func crearRespuestaRangoTabular(seriePath string, mediciones []tipos.Medicion) *tipos.RespuestaConsultaRango {
@@ -105,74 +141,77 @@ func crearRespuestaPuntoVacia() *tipos.RespuestaConsultaPunto {
 }
 
 // ============================================================================
-// MOCK DE CLIENTE S3 PARA TESTS
+// S3 EN MEMORIA Y MANAGER PARA TESTS
 // ============================================================================
 
-// mockClienteS3 implementa tipos.ClienteS3 para testing
-type mockClienteS3 struct {
-	// Respuestas configurables
-	listObjectsOutput  *s3.ListObjectsV2Output
-	getObjectOutput    *s3.GetObjectOutput
-	getObjectData      []byte
-	putObjectOutput    *s3.PutObjectOutput
-	deleteObjectOutput *s3.DeleteObjectOutput
-	headBucketOutput   *s3.HeadBucketOutput
-	createBucketOutput *s3.CreateBucketOutput
-
-	// Errores configurables
-	listObjectsErr  error
-	getObjectErr    error
-	putObjectErr    error
-	deleteObjectErr error
-	headBucketErr   error
-	createBucketErr error
-}
+// bucketTest es el bucket del cliente S3 en memoria de los tests
+const bucketTest = "test-bucket"
 
-func (m *mockClienteS3) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
-	if m.headBucketErr != nil {
-		return nil, m.headBucketErr
+// nuevoS3Test crea un cliente S3 en memoria con el bucket de prueba y las claves
+// indicadas (con contenido vacío)
+func nuevoS3Test(t *testing.T, claves ...string) *s3fake.Cliente {
+	t.Helper()
+	cliente := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucketTest}})
+	for _, clave := range claves {
+		guardarObjetoTest(t, cliente, clave, nil)
 	}
-	return m.headBucketOutput, nil
+	return cliente
 }
 
-func (m *mockClienteS3) CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
-	if m.createBucketErr != nil {
-		return nil, m.createBucketErr
-	}
-	return m.createBucketOutput, nil
+// guardarObjetoTest guarda un objeto en el bucket de prueba y retorna su ETag
+func guardarObjetoTest(t *testing.T, cliente *s3fake.Cliente, clave string, datos []byte) string {
+	t.Helper()
+	salida, err := cliente.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucketTest),
+		Key:    aws.String(clave),
+		Body:   bytes.NewReader(datos),
+	})
+	require.NoError(t, err)
+	return aws.ToString(salida.ETag)
 }
 
-func (m *mockClienteS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
-	if m.listObjectsErr != nil {
-		return nil, m.listObjectsErr
-	}
-	return m.listObjectsOutput, nil
+// objetoTest retorna el contenido de un objeto del bucket de prueba
+func objetoTest(t *testing.T, cliente *s3fake.Cliente, clave string) []byte {
+	t.Helper()
+	datos, existe := cliente.Objeto(bucketTest, clave)
+	require.True(t, existe, "No existe el objeto %s", clave)
+	return datos
 }
 
-func (m *mockClienteS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
-	if m.getObjectErr != nil {
-		return nil, m.getObjectErr
-	}
-	if m.getObjectData != nil {
-		return &s3.GetObjectOutput{
-			Body: io.NopCloser(bytes.NewReader(m.getObjectData)),
-		}, nil
-	}
-	return m.getObjectOutput, nil
+// fallarS3Test hace fallar las operaciones indicadas con assert.AnError
+func fallarS3Test(cliente *s3fake.Cliente, ops ...s3fake.Operacion) {
+	cliente.Fallar(s3fake.Fallas{
+		Operaciones: ops,
+		Interceptar: func(s3fake.Operacion, string, string) error { return assert.AnError },
+	})
 }
 
-func (m *mockClienteS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
-	if m.putObjectErr != nil {
-		return nil, m.putObjectErr
-	}
-	return m.putObjectOutput, nil
-}
+// crearManagerTest crea un manager con los nodos dados sobre el cliente S3 en memoria
+// y un mock de edge, sin sincronizar con S3 ni seguir la salud de los nodos. Las
+// caches, las firmas, la autenticación y la autorización se configuran con opts como
+// en Crear.
+func crearManagerTest(t *testing.T, cliente *s3fake.Cliente, opts Opciones, nodos ...*tipos.Nodo) (*ManagerDespachador, *mockClienteEdge) {
+	t.Helper()
+	cache, err := nuevaCacheBloques(opts.CacheBloques)
+	require.NoError(t, err)
+	confianza, err := nuevaListaConfianza(opts.Firmas)
+	require.NoError(t, err)
 
-func (m *mockClienteS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
-	if m.deleteObjectErr != nil {
-		return nil, m.deleteObjectErr
+	mapaNodos := make(map[string]*tipos.Nodo)
+	for _, nodo := range nodos {
+		mapaNodos[nodo.NodoID] = nodo
 	}
-	return m.deleteObjectOutput, nil
+	mockEdge := &mockClienteEdge{}
+	return &ManagerDespachador{
+		nodos:           mapaNodos,
+		clienteEdge:     mockEdge,
+		cache:           cache,
+		almacenamiento:  tipos.NuevoAlmacenamientoS3(cliente, bucketTest),
+		cacheResultados: nuevaCacheResultados(opts.CacheResultados),
+		confianza:       confianza,
+		autorizacion:    nuevoAutorizador(opts.Autorizacion),
+		autenticacion:   opts.Autenticacion,
+	}, mockEdge
 }
 
 // ============================================================================
@@ -723,7 +762,7 @@ func TestConsultarEdgeConTimeout_SinDatos(t *testing.T) {
 	t.Log("consultarEdgeConTimeout retorna resultado vacío cuando no hay datos")
 }
 
-// TestConsultarEdgeConTimeout_ErrorConexion verifica que error de conexion retorna resultado vacío
+// TestConsultarEdgeConTimeout_ErrorConexion verifica que error de conexion retorna resultado vacío y error
 func TestConsultarEdgeConTimeout_ErrorConexion(t *testing.T) {
 	mockEdge := &mockClienteEdge{
 		err: assert.AnError,
@@ -739,13 +778,123 @@ func TestConsultarEdgeConTimeout_ErrorConexion(t *testing.T) {
 		PuertoHTTP:  "8080",
 	}
 
-	// Error de conexion retorna resultado vacío sin error (el edge puede estar offline)
+	// Error de conexion retorna resultado vacío y error para informar el nodo como no disponible
 	resultado, err := m.consultarEdgeConTimeout(nodo, "/sensores/temp", 1000, 3000, 5*time.Second)
 
-	assert.Nil(t, err)
+	assert.Error(t, err)
 	assert.Empty(t, resultado.Tiempos)
 	assert.Empty(t, resultado.Series)
-	t.Log("consultarEdgeConTimeout retorna resultado vacío cuando hay error de conexion")
+	t.Log("consultarEdgeConTimeout retorna resultado vacío y error cuando hay error de conexion")
+}
+
+// TestConsultarRango_NodoCaidoEnNodosNoDisponibles verifica que un edge sin conexión se informa como no disponible
+func TestConsultarRango_NodoCaidoEnNodosNoDisponibles(t *testing.T) {
+	m := &ManagerDespachador{
+		nodos: map[string]*tipos.Nodo{
+			"nodo1": {NodoID: "nodo1", Series: map[string]tipos.Serie{"/sensores/temp": {SerieId: 1, Path: "/sensores/temp"}}},
+		},
+		clienteEdge:    &mockClienteEdge{err: assert.AnError},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(nuevoS3Test(t), "test-bucket"),
+	}
+
+	resultado, err := m.ConsultarRango("/sensores/temp", time.Unix(0, 0), time.Unix(0, 1000))
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nodo1"}, resultado.NodosNoDisponibles)
+	t.Log("Un edge sin conexión aparece en NodosNoDisponibles")
+}
+
+// TestSaludNodos_CircuitoAbiertoNoConsultaEdge verifica que tras el umbral de fallos el nodo no se consulta
+func TestSaludNodos_CircuitoAbiertoNoConsultaEdge(t *testing.T) {
+	mockEdge := &mockClienteEdge{err: assert.AnError}
+	m := &ManagerDespachador{
+		clienteEdge: mockEdge,
+		salud:       nuevoMonitorSalud(OpcionesSaludNodos{UmbralFallos: 2, EsperaReintento: time.Hour}),
+	}
+	nodo := tipos.Nodo{NodoID: "nodo1"}
+
+	for i := 0; i < 5; i++ {
+		_, err := m.consultarEdgeConTimeout(nodo, "/sensores/temp", 1000, 3000, time.Second)
+		assert.Error(t, err)
+	}
+
+	assert.Equal(t, int32(2), mockEdge.llamadasRango.Load(), "Con el circuito abierto no se contacta al edge")
+	salud := m.ObtenerSaludNodo("nodo1")
+	assert.Equal(t, CircuitoAbierto, salud.Estado)
+	assert.Equal(t, 2, salud.FallosConsecutivos)
+	assert.NotZero(t, salud.UltimoFallo)
+	assert.Contains(t, salud.UltimoError, assert.AnError.Error())
+
+	_, err := m.consultarPuntoEdge(nodo, "/sensores/temp", nil, nil, time.Second)
+	assert.ErrorIs(t, err, errNodoNoDisponible)
+	t.Log("El circuito abierto evita consultar al nodo caído")
+}
+
+// TestSaludNodos_ConsultaDePruebaCierraCircuito verifica la recuperación del nodo tras la espera
+func TestSaludNodos_ConsultaDePruebaCierraCircuito(t *testing.T) {
+	mockEdge := &mockClienteEdge{err: assert.AnError}
+	m := &ManagerDespachador{
+		clienteEdge: mockEdge,
+		salud:       nuevoMonitorSalud(OpcionesSaludNodos{UmbralFallos: 1, EsperaReintento: time.Millisecond}),
+	}
+	nodo := tipos.Nodo{NodoID: "nodo1"}
+
+	_, err := m.consultarEdgeConTimeout(nodo, "/sensores/temp", 1000, 3000, time.Second)
+	require.Error(t, err)
+	assert.Equal(t, CircuitoAbierto, m.ObtenerSaludNodo("nodo1").Estado)
+
+	// El nodo vuelve: pasada la espera, la consulta de prueba cierra el circuito
+	time.Sleep(5 * time.Millisecond)
+	mockEdge.err = nil
+	mockEdge.respuestaRango = crearRespuestaRangoTabular("/sensores/temp", []tipos.Medicion{{Tiempo: 2000, Valor: 1.0}})
+
+	resultado, err := m.consultarEdgeConTimeout(nodo, "/sensores/temp", 1000, 3000, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, resultado.Tiempos, 1)
+
+	salud := m.ObtenerSaludNodo("nodo1")
+	assert.Equal(t, CircuitoCerrado, salud.Estado)
+	assert.Equal(t, 0, salud.FallosConsecutivos)
+	assert.NotZero(t, salud.UltimoExito)
+	t.Log("Una consulta de prueba exitosa cierra el circuito")
+}
+
+// TestSaludNodos_SemiabiertoPermiteUnaPrueba verifica que solo se permite una consulta de prueba a la vez
+func TestSaludNodos_SemiabiertoPermiteUnaPrueba(t *testing.T) {
+	s := nuevoMonitorSalud(OpcionesSaludNodos{UmbralFallos: 1, EsperaReintento: time.Millisecond})
+	s.registrarFallo("nodo1", assert.AnError)
+	time.Sleep(5 * time.Millisecond)
+
+	assert.True(t, s.permitir("nodo1"), "Primera consulta de prueba")
+	assert.False(t, s.permitir("nodo1"), "Prueba en curso")
+
+	// La prueba falla: el circuito vuelve a abrirse
+	s.registrarFallo("nodo1", assert.AnError)
+	assert.Equal(t, CircuitoAbierto, s.obtener("nodo1").Estado)
+	assert.False(t, s.permitir("nodo1"))
+	t.Log("El estado semiabierto permite una única consulta de prueba")
+}
+
+// TestHandlerListarNodos_IncluyeSalud verifica que el listado de nodos expone su salud
+func TestHandlerListarNodos_IncluyeSalud(t *testing.T) {
+	m := &ManagerDespachador{
+		nodos: map[string]*tipos.Nodo{
+			"nodo1": {NodoID: "nodo1", Direccion: "http://nodo1:8080"},
+		},
+		salud: nuevoMonitorSalud(OpcionesSaludNodos{}),
+	}
+	m.salud.registrarExito("nodo1", 20*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	HandlerListarNodos(m)(w, httptest.NewRequest(http.MethodGet, "/api/nodos", nil))
+
+	var respuesta []NodoResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respuesta))
+	require.Len(t, respuesta, 1)
+	assert.Equal(t, "nodo1", respuesta[0].NodoID)
+	assert.Equal(t, CircuitoCerrado, respuesta[0].Salud.Estado)
+	assert.InDelta(t, 20.0, respuesta[0].Salud.LatenciaMs, 0.001)
+	t.Log("HandlerListarNodos incluye la salud de cada nodo")
 }
 
 // TestConsultarEdgeConTimeout_ErrorDelEdge verifica manejo de error reportado por el edge
@@ -779,18 +928,13 @@ func TestConsultarEdgeConTimeout_ErrorDelEdge(t *testing.T) {
 
 // TestListarBloquesEnRango_SinBloques verifica respuesta cuando no hay bloques
 func TestListarBloquesEnRango_SinBloques(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
-	bloques, err := m.listarBloquesEnRango("nodo1", 1, 1000, 5000)
+	bloques, err := m.listarBloquesEnRango("nodo1", 1, 1000, 5000, tipos.DisposicionPlana)
 
 	assert.NoError(t, err)
 	assert.Empty(t, bloques)
@@ -799,23 +943,18 @@ func TestListarBloquesEnRango_SinBloques(t *testing.T) {
 
 // TestListarBloquesEnRango_ConBloques verifica listado de bloques
 func TestListarBloquesEnRango_ConBloques(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodo1/0000000001_00000000000000001000_00000000000000002000")},
-				{Key: aws.String("nodo1/0000000001_00000000000000002000_00000000000000003000")},
-				{Key: aws.String("nodo1/0000000001_00000000000000003000_00000000000000004000")},
-			},
-		},
-	}
+	mockS3 := nuevoS3Test(t,
+		"nodo1/0000000001_00000000000000001000_00000000000000002000",
+		"nodo1/0000000001_00000000000000002000_00000000000000003000",
+		"nodo1/0000000001_00000000000000003000_00000000000000004000",
+	)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	// Rango que intersecta con los primeros dos bloques
-	bloques, err := m.listarBloquesEnRango("nodo1", 1, 1500, 2500)
+	bloques, err := m.listarBloquesEnRango("nodo1", 1, 1500, 2500, tipos.DisposicionPlana)
 
 	assert.NoError(t, err)
 	assert.Len(t, bloques, 2)
@@ -824,23 +963,18 @@ func TestListarBloquesEnRango_ConBloques(t *testing.T) {
 
 // TestListarBloquesEnRango_TodosLosBloques verifica cuando el rango cubre todos los bloques
 func TestListarBloquesEnRango_TodosLosBloques(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodo1/0000000001_00000000000000001000_00000000000000002000")},
-				{Key: aws.String("nodo1/0000000001_00000000000000002000_00000000000000003000")},
-				{Key: aws.String("nodo1/0000000001_00000000000000003000_00000000000000004000")},
-			},
-		},
-	}
+	mockS3 := nuevoS3Test(t,
+		"nodo1/0000000001_00000000000000001000_00000000000000002000",
+		"nodo1/0000000001_00000000000000002000_00000000000000003000",
+		"nodo1/0000000001_00000000000000003000_00000000000000004000",
+	)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	// Rango amplio que cubre todos los bloques
-	bloques, err := m.listarBloquesEnRango("nodo1", 1, 0, 10000)
+	bloques, err := m.listarBloquesEnRango("nodo1", 1, 0, 10000, tipos.DisposicionPlana)
 
 	assert.NoError(t, err)
 	assert.Len(t, bloques, 3)
@@ -849,22 +983,17 @@ func TestListarBloquesEnRango_TodosLosBloques(t *testing.T) {
 
 // TestListarBloquesEnRango_NingunBloqueEnRango verifica cuando ningun bloque intersecta
 func TestListarBloquesEnRango_NingunBloqueEnRango(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodo1/0000000001_00000000000000001000_00000000000000002000")},
-				{Key: aws.String("nodo1/0000000001_00000000000000002000_00000000000000003000")},
-			},
-		},
-	}
+	mockS3 := nuevoS3Test(t,
+		"nodo1/0000000001_00000000000000001000_00000000000000002000",
+		"nodo1/0000000001_00000000000000002000_00000000000000003000",
+	)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	// Rango que no intersecta con ningun bloque
-	bloques, err := m.listarBloquesEnRango("nodo1", 1, 5000, 6000)
+	bloques, err := m.listarBloquesEnRango("nodo1", 1, 5000, 6000, tipos.DisposicionPlana)
 
 	assert.NoError(t, err)
 	assert.Empty(t, bloques)
@@ -873,16 +1002,14 @@ func TestListarBloquesEnRango_NingunBloqueEnRango(t *testing.T) {
 
 // TestListarBloquesEnRango_ErrorS3 verifica manejo de error de S3
 func TestListarBloquesEnRango_ErrorS3(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsErr: assert.AnError,
-	}
+	mockS3 := nuevoS3Test(t)
+	fallarS3Test(mockS3, s3fake.OperacionListObjectsV2)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
-	_, err := m.listarBloquesEnRango("nodo1", 1, 1000, 5000)
+	_, err := m.listarBloquesEnRango("nodo1", 1, 1000, 5000, tipos.DisposicionPlana)
 
 	assert.Error(t, err)
 	t.Log("listarBloquesEnRango retorna error cuando S3 falla")
@@ -890,23 +1017,18 @@ func TestListarBloquesEnRango_ErrorS3(t *testing.T) {
 
 // TestListarBloquesEnRango_FormatoIncorrecto verifica que ignora bloques mal formateados
 func TestListarBloquesEnRango_FormatoIncorrecto(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodo1/0000000001_00000000000000001000_00000000000000002000")}, // Correcto
-				{Key: aws.String("nodo1/0000000001_bloque_invalido")},                           // Incorrecto
-				{Key: aws.String("nodo1/0000000001")},                                           // Sin tiempos
-				{Key: aws.String("nodo1/0000000001_00000000000000003000_00000000000000004000")}, // Correcto
-			},
-		},
-	}
+	mockS3 := nuevoS3Test(t,
+		"nodo1/0000000001_00000000000000001000_00000000000000002000", // Correcto
+		"nodo1/0000000001_bloque_invalido",                           // Incorrecto
+		"nodo1/0000000001",                                           // Sin tiempos
+		"nodo1/0000000001_00000000000000003000_00000000000000004000", // Correcto
+	)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
-	bloques, err := m.listarBloquesEnRango("nodo1", 1, 0, 10000)
+	bloques, err := m.listarBloquesEnRango("nodo1", 1, 0, 10000, tipos.DisposicionPlana)
 
 	assert.NoError(t, err)
 	assert.Len(t, bloques, 2) // Solo los bloques con formato correcto
@@ -915,47 +1037,555 @@ func TestListarBloquesEnRango_FormatoIncorrecto(t *testing.T) {
 
 // TestListarBloquesEnRango_OrdenPorTiempo verifica que los bloques estan ordenados
 func TestListarBloquesEnRango_OrdenPorTiempo(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodo1/0000000001_00000000000000003000_00000000000000004000")},
-				{Key: aws.String("nodo1/0000000001_00000000000000001000_00000000000000002000")},
-				{Key: aws.String("nodo1/0000000001_00000000000000002000_00000000000000003000")},
-			},
-		},
-	}
+	mockS3 := nuevoS3Test(t,
+		"nodo1/0000000001_00000000000000003000_00000000000000004000",
+		"nodo1/0000000001_00000000000000001000_00000000000000002000",
+		"nodo1/0000000001_00000000000000002000_00000000000000003000",
+	)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
-	bloques, err := m.listarBloquesEnRango("nodo1", 1, 0, 10000)
+	bloques, err := m.listarBloquesEnRango("nodo1", 1, 0, 10000, tipos.DisposicionPlana)
 
 	assert.NoError(t, err)
 	assert.Len(t, bloques, 3)
 	// Verificar orden ascendente (los nombres tienen padding, asi que sort.Strings funciona)
-	assert.Contains(t, bloques[0], "00000000000000001000")
-	assert.Contains(t, bloques[1], "00000000000000002000")
-	assert.Contains(t, bloques[2], "00000000000000003000")
+	assert.Contains(t, bloques[0].Clave, "00000000000000001000")
+	assert.Contains(t, bloques[1].Clave, "00000000000000002000")
+	assert.Contains(t, bloques[2].Clave, "00000000000000003000")
 	t.Log("listarBloquesEnRango retorna bloques ordenados por tiempo")
 }
 
+// TestListarBloquesEnRango_Paginado verifica que no se pierden bloques con más de 1000 objetos
+func TestListarBloquesEnRango_Paginado(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	for i := int64(0); i < 2500; i++ {
+		guardarObjetoTest(t, mockS3, tipos.GenerarClaveS3Datos("nodo1", 1, i*1000, i*1000+999), []byte{})
+	}
+
+	m := &ManagerDespachador{
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+	}
+
+	// Sin manifiesto se recurre al listado completo
+	bloques, err := m.listarBloquesEnRango("nodo1", 1, 2000*1000, 3000*1000, tipos.DisposicionPlana)
+
+	require.NoError(t, err)
+	assert.Len(t, bloques, 500)
+	assert.Equal(t, 3, mockS3.Llamadas(s3fake.OperacionListObjectsV2))
+	t.Log("listarBloquesEnRango sigue los tokens de continuación de S3")
+}
+
+// TestListarBloquesEnRango_UsaManifiesto verifica que con manifiesto no se lista la serie
+func TestListarBloquesEnRango_UsaManifiesto(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	manifiesto := &tipos.ManifiestoSerie{NodoID: "nodo1", SerieId: 1, Path: "sensor/temp"}
+	for _, inicio := range []int64{1000, 2000, 3000} {
+		clave := tipos.GenerarClaveS3Datos("nodo1", 1, inicio, inicio+999)
+		guardarObjetoTest(t, mockS3, clave, []byte{})
+		manifiesto.AgregarBloques(tipos.BloqueManifiesto{Clave: clave, TiempoInicio: inicio, TiempoFin: inicio + 999})
+	}
+	require.NoError(t, tipos.GuardarManifiesto(context.Background(), tipos.NuevoAlmacenamientoS3(mockS3, bucketTest), manifiesto))
+
+	m := &ManagerDespachador{
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+	}
+
+	bloques, err := m.listarBloquesEnRango("nodo1", 1, 1500, 2500, tipos.DisposicionPlana)
+
+	require.NoError(t, err)
+	require.Len(t, bloques, 2)
+	assert.Contains(t, bloques[0].Clave, "00000000000000001000")
+	assert.Contains(t, bloques[1].Clave, "00000000000000002000")
+	assert.Equal(t, 0, mockS3.Llamadas(s3fake.OperacionListObjectsV2))
+	t.Log("listarBloquesEnRango ubica los bloques con una sola lectura del manifiesto")
+}
+
+// TestListarBloquesEnRango_Particionado verifica el listado por particiones diarias sin manifiesto
+func TestListarBloquesEnRango_Particionado(t *testing.T) {
+	mockS3 := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucketTest}, TamañoPagina: 2})
+	dia := func(d, h int) int64 { return time.Date(2024, 3, d, h, 0, 0, 0, time.UTC).UnixNano() }
+
+	// Bloques de varios días; uno comienza antes del rango y termina dentro
+	bloques := [][2]int64{
+		{dia(10, 0), dia(10, 12)},
+		{dia(11, 20), dia(12, 2)},
+		{dia(12, 3), dia(12, 20)},
+		{dia(13, 1), dia(13, 5)},
+		{dia(14, 1), dia(14, 5)},
+	}
+	for _, b := range bloques {
+		guardarObjetoTest(t, mockS3, tipos.GenerarClaveS3DatosParticionada("nodo1", 1, b[0], b[1]), []byte{})
+	}
+
+	m := &ManagerDespachador{
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+	}
+
+	encontrados, err := m.listarBloquesEnRango("nodo1", 1, dia(12, 0), dia(13, 2), tipos.DisposicionParticionada)
+
+	require.NoError(t, err)
+	require.Len(t, encontrados, 3)
+	for i, b := range bloques[1:4] {
+		assert.Equal(t, tipos.GenerarClaveS3DatosParticionada("nodo1", 1, b[0], b[1]), encontrados[i].Clave)
+	}
+	t.Log("listarBloquesEnRango recorre solo las particiones del rango en la disposición particionada")
+}
+
+// TestCargarNodosDesdeS3_Paginado verifica que se cargan flotas de más de 1000 nodos
+func TestCargarNodosDesdeS3_Paginado(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	for i := 0; i < 1500; i++ {
+		nodo := tipos.Nodo{NodoID: fmt.Sprintf("nodo-%04d", i)}
+		datos, err := json.Marshal(nodo)
+		require.NoError(t, err)
+		guardarObjetoTest(t, mockS3, "nodos/"+nodo.NodoID+".json", datos)
+	}
+
+	m := &ManagerDespachador{
+		nodos:          make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+	}
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Len(t, m.nodos, 1500)
+	t.Log("cargarNodosDesdeS3 carga todos los nodos siguiendo la paginación")
+}
+
+// ============================================================================
+// TESTS DE LATIDOS Y ESTADO DE CONEXIÓN
+// ============================================================================
+
+// guardarJSONTest serializa un valor en el bucket de prueba
+func guardarJSONTest(t *testing.T, mockS3 *s3fake.Cliente, clave string, valor interface{}) {
+	t.Helper()
+	datos, err := json.Marshal(valor)
+	require.NoError(t, err)
+	guardarObjetoTest(t, mockS3, clave, datos)
+}
+
+// TestCargarNodosDesdeS3_AplicaLatidos verifica que el latido actualiza la última conexión y la versión
+func TestCargarNodosDesdeS3_AplicaLatidos(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	registro := time.Now().Add(-time.Hour).UnixNano()
+	latido := time.Now().UnixNano()
+	guardarJSONTest(t, mockS3, "nodos/nodo1.json", tipos.Nodo{NodoID: "nodo1", UltimaConexion: registro, Version: "v1.0.0"})
+	guardarJSONTest(t, mockS3, tipos.GenerarClaveS3Latido("nodo1"), tipos.Latido{NodoID: "nodo1", Momento: latido, Version: "v1.1.0"})
+	guardarJSONTest(t, mockS3, "nodos/nodo2.json", tipos.Nodo{NodoID: "nodo2", UltimaConexion: registro})
+
+	m := &ManagerDespachador{
+		nodos:          make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+	}
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	require.Len(t, m.nodos, 2)
+	assert.Equal(t, latido, m.nodos["nodo1"].UltimaConexion)
+	assert.Equal(t, "v1.1.0", m.nodos["nodo1"].Version)
+	assert.Equal(t, ConexionEnLinea, m.EstadoConexionNodo(*m.nodos["nodo1"]))
+	assert.Equal(t, registro, m.nodos["nodo2"].UltimaConexion)
+	assert.Equal(t, ConexionDesconectada, m.EstadoConexionNodo(*m.nodos["nodo2"]))
+	t.Log("cargarNodosDesdeS3 toma la conexión más reciente entre registro y latido")
+}
+
+// TestEstadoConexionNodo_Umbrales verifica la clasificación según la antigüedad del último latido
+func TestEstadoConexionNodo_Umbrales(t *testing.T) {
+	m := &ManagerDespachador{latidos: OpcionesLatidos{UmbralInactivo: time.Minute, UmbralDesconectado: 5 * time.Minute}}
+	hace := func(d time.Duration) tipos.Nodo {
+		return tipos.Nodo{NodoID: "nodo1", UltimaConexion: time.Now().Add(-d).UnixNano()}
+	}
+
+	assert.Equal(t, ConexionDesconocida, m.EstadoConexionNodo(tipos.Nodo{NodoID: "nodo1"}))
+	assert.Equal(t, ConexionEnLinea, m.EstadoConexionNodo(hace(10*time.Second)))
+	assert.Equal(t, ConexionInactiva, m.EstadoConexionNodo(hace(2*time.Minute)))
+	assert.Equal(t, ConexionDesconectada, m.EstadoConexionNodo(hace(6*time.Minute)))
+
+	// Valores por defecto: 2m inactivo, 10m desconectado
+	m.latidos = OpcionesLatidos{}
+	assert.Equal(t, ConexionEnLinea, m.EstadoConexionNodo(hace(time.Minute)))
+	assert.Equal(t, ConexionInactiva, m.EstadoConexionNodo(hace(5*time.Minute)))
+	assert.Equal(t, ConexionDesconectada, m.EstadoConexionNodo(hace(11*time.Minute)))
+	t.Log("EstadoConexionNodo clasifica los nodos según los umbrales configurados")
+}
+
+// TestCargarNodosDesdeS3_EliminaNodosExpirados verifica la eliminación de registros de nodos muertos
+func TestCargarNodosDesdeS3_EliminaNodosExpirados(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	antiguo := time.Now().Add(-48 * time.Hour).UnixNano()
+	guardarJSONTest(t, mockS3, "nodos/muerto.json", tipos.Nodo{NodoID: "muerto", UltimaConexion: antiguo})
+	guardarJSONTest(t, mockS3, tipos.GenerarClaveS3Latido("muerto"), tipos.Latido{NodoID: "muerto", Momento: antiguo})
+	guardarJSONTest(t, mockS3, "nodos/vivo.json", tipos.Nodo{NodoID: "vivo", UltimaConexion: time.Now().UnixNano()})
+	guardarJSONTest(t, mockS3, "nodos/legado.json", tipos.Nodo{NodoID: "legado"})
+
+	m := &ManagerDespachador{
+		nodos:          make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+		latidos:        OpcionesLatidos{EliminarTras: 24 * time.Hour},
+	}
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Len(t, m.nodos, 2)
+	assert.NotContains(t, m.nodos, "muerto")
+	assert.Contains(t, m.nodos, "legado", "Los nodos sin última conexión no se eliminan")
+	assert.NotContains(t, mockS3.Claves(bucketTest, ""), "nodos/muerto.json")
+	assert.NotContains(t, mockS3.Claves(bucketTest, ""), tipos.GenerarClaveS3Latido("muerto"))
+	assert.Contains(t, mockS3.Claves(bucketTest, ""), "nodos/vivo.json")
+	t.Log("cargarNodosDesdeS3 elimina los registros de nodos sin conexión desde EliminarTras")
+}
+
+// TestConsultarEdge_NodoDesconectadoNoSeConsulta verifica que no se contacta a nodos sin latidos recientes
+func TestConsultarEdge_NodoDesconectadoNoSeConsulta(t *testing.T) {
+	mockEdge := &mockClienteEdge{}
+	m := &ManagerDespachador{clienteEdge: mockEdge}
+	nodo := tipos.Nodo{NodoID: "nodo1", UltimaConexion: time.Now().Add(-time.Hour).UnixNano()}
+
+	_, err := m.consultarEdgeConTimeout(nodo, "/sensores/temp", 1000, 3000, time.Second)
+	assert.ErrorIs(t, err, errNodoDesconectado)
+	_, err = m.consultarPuntoEdge(nodo, "/sensores/temp", nil, nil, time.Second)
+	assert.ErrorIs(t, err, errNodoDesconectado)
+	assert.Equal(t, int32(0), mockEdge.llamadasRango.Load())
+	t.Log("Los nodos desconectados se informan como no disponibles sin consultarlos")
+}
+
+// TestHandlerListarNodos_IncluyeConexion verifica que el listado de nodos expone el estado de conexión
+func TestHandlerListarNodos_IncluyeConexion(t *testing.T) {
+	m := &ManagerDespachador{
+		nodos: map[string]*tipos.Nodo{
+			"nodo1": {NodoID: "nodo1", UltimaConexion: time.Now().UnixNano(), Version: "v1.0.0"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	HandlerListarNodos(m)(w, httptest.NewRequest(http.MethodGet, "/api/nodos", nil))
+
+	var respuesta []NodoResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respuesta))
+	require.Len(t, respuesta, 1)
+	assert.Equal(t, ConexionEnLinea, respuesta[0].Conexion)
+	assert.Equal(t, "v1.0.0", respuesta[0].Version)
+	t.Log("HandlerListarNodos incluye el estado de conexión de cada nodo")
+}
+
+// ============================================================================
+// TESTS DE SINCRONIZACIÓN INCREMENTAL DE NODOS
+// ============================================================================
+
+// TestCargarNodosDesdeS3_SoloDescargaModificados verifica que solo se descargan los objetos con ETag nuevo
+func TestCargarNodosDesdeS3_SoloDescargaModificados(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	for _, nodoID := range []string{"nodo1", "nodo2", "nodo3"} {
+		guardarJSONTest(t, mockS3, "nodos/"+nodoID+".json", tipos.Nodo{NodoID: nodoID})
+		guardarJSONTest(t, mockS3, tipos.GenerarClaveS3Latido(nodoID), tipos.Latido{NodoID: nodoID, Momento: time.Now().UnixNano()})
+	}
+
+	m := &ManagerDespachador{
+		nodos:          make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+	}
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	require.NoError(t, m.cargarNodosDesdeS3())
+	for _, nodoID := range []string{"nodo1", "nodo2", "nodo3"} {
+		assert.Equal(t, 1, mockS3.LlamadasClave(s3fake.OperacionGetObject, "nodos/"+nodoID+".json"), "Registro sin cambios de %s", nodoID)
+		assert.Equal(t, 1, mockS3.LlamadasClave(s3fake.OperacionGetObject, tipos.GenerarClaveS3Latido(nodoID)), "Latido sin cambios de %s", nodoID)
+	}
+
+	// Solo nodo2 cambia su registro y su latido
+	momento := time.Now().UnixNano()
+	guardarJSONTest(t, mockS3, "nodos/nodo2.json", tipos.Nodo{NodoID: "nodo2", Direccion: "http://nodo2:8080"})
+	guardarJSONTest(t, mockS3, tipos.GenerarClaveS3Latido("nodo2"), tipos.Latido{NodoID: "nodo2", Momento: momento})
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Equal(t, 1, mockS3.LlamadasClave(s3fake.OperacionGetObject, "nodos/nodo1.json"))
+	assert.Equal(t, 2, mockS3.LlamadasClave(s3fake.OperacionGetObject, "nodos/nodo2.json"))
+	assert.Equal(t, 2, mockS3.LlamadasClave(s3fake.OperacionGetObject, tipos.GenerarClaveS3Latido("nodo2")))
+	assert.Equal(t, "http://nodo2:8080", m.nodos["nodo2"].Direccion)
+	assert.Equal(t, momento, m.nodos["nodo2"].UltimaConexion)
+	assert.Len(t, m.nodos, 3)
+	t.Log("cargarNodosDesdeS3 descarga solo los registros y latidos modificados")
+}
+
+// TestCargarNodosDesdeS3_EmiteEventos verifica los eventos de nodos agregados, eliminados y con series cambiadas
+func TestCargarNodosDesdeS3_EmiteEventos(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	serie := tipos.Serie{SerieId: 1, Path: "sensor/temp"}
+	guardarJSONTest(t, mockS3, "nodos/nodo1.json", tipos.Nodo{NodoID: "nodo1", Series: map[string]tipos.Serie{"sensor/temp": serie}})
+	guardarJSONTest(t, mockS3, "nodos/nodo2.json", tipos.Nodo{NodoID: "nodo2"})
+
+	var eventos []EventoNodo
+	m := &ManagerDespachador{
+		nodos:          make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+		alCambiarNodos: func(e EventoNodo) { eventos = append(eventos, e) },
+	}
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	require.Len(t, eventos, 2)
+	assert.Equal(t, EventoNodo{Tipo: EventoNodoAgregado, NodoID: "nodo1", Nodo: *m.nodos["nodo1"]}, eventos[0])
+	assert.Equal(t, EventoNodoAgregado, eventos[1].Tipo)
+	assert.Equal(t, "nodo2", eventos[1].NodoID)
+
+	// Un cambio que no afecta a las series no genera eventos
+	eventos = nil
+	guardarJSONTest(t, mockS3, "nodos/nodo1.json", tipos.Nodo{NodoID: "nodo1", Direccion: "http://otra:8080", Series: map[string]tipos.Serie{"sensor/temp": serie}})
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Empty(t, eventos)
+
+	// nodo1 agrega una serie, nodo2 desaparece y nodo3 se registra
+	serie2 := tipos.Serie{SerieId: 2, Path: "sensor/hum"}
+	guardarJSONTest(t, mockS3, "nodos/nodo1.json", tipos.Nodo{NodoID: "nodo1", Series: map[string]tipos.Serie{"sensor/temp": serie, "sensor/hum": serie2}})
+	_, err := mockS3.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: aws.String(bucketTest), Key: aws.String("nodos/nodo2.json")})
+	require.NoError(t, err)
+	guardarJSONTest(t, mockS3, "nodos/nodo3.json", tipos.Nodo{NodoID: "nodo3"})
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	require.Len(t, eventos, 3)
+	assert.Equal(t, EventoSeriesCambiadas, eventos[0].Tipo)
+	assert.Len(t, eventos[0].Nodo.Series, 2)
+	assert.Equal(t, EventoNodoEliminado, eventos[1].Tipo)
+	assert.Equal(t, "nodo2", eventos[1].NodoID)
+	assert.Equal(t, EventoNodoAgregado, eventos[2].Tipo)
+	assert.Equal(t, "nodo3", eventos[2].NodoID)
+	t.Log("cargarNodosDesdeS3 informa los cambios del registro de nodos")
+}
+
+// TestCargarNodosDesdeS3_NoBloqueaConsultas verifica que las consultas no esperan a S3 durante la sincronización
+func TestCargarNodosDesdeS3_NoBloqueaConsultas(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	guardarJSONTest(t, mockS3, "nodos/nodo1.json", tipos.Nodo{NodoID: "nodo1"})
+
+	// Retener los listados de S3 hasta que se cierra liberar
+	listando := make(chan struct{}, 1)
+	liberar := make(chan struct{})
+	mockS3.Fallar(s3fake.Fallas{
+		Operaciones: []s3fake.Operacion{s3fake.OperacionListObjectsV2},
+		Interceptar: func(s3fake.Operacion, string, string) error {
+			select {
+			case listando <- struct{}{}:
+			default:
+			}
+			<-liberar
+			return nil
+		},
+	})
+
+	m := &ManagerDespachador{
+		nodos:          map[string]*tipos.Nodo{"nodo0": {NodoID: "nodo0"}},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+	}
+
+	errSync := make(chan error, 1)
+	go func() { errSync <- m.cargarNodosDesdeS3() }()
+	<-listando
+
+	listados := make(chan []tipos.Nodo, 1)
+	go func() { listados <- m.ListarNodos() }()
+	select {
+	case nodos := <-listados:
+		require.Len(t, nodos, 1)
+		assert.Equal(t, "nodo0", nodos[0].NodoID)
+	case <-time.After(time.Second):
+		t.Fatal("ListarNodos quedó bloqueado durante la sincronización")
+	}
+
+	close(liberar)
+	require.NoError(t, <-errSync)
+	assert.Contains(t, m.nodos, "nodo1")
+	assert.NotContains(t, m.nodos, "nodo0")
+	t.Log("La E/S con S3 se realiza sin bloquear el mapa de nodos")
+}
+
+// TestCrear_IntervaloSincronizacion verifica que la sincronización periódica usa el intervalo configurado
+func TestCrear_IntervaloSincronizacion(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	agregados := make(chan string, 10)
+
+	manager, err := crearConOpciones(opcionesInternas{
+		Opciones: Opciones{
+			ConfigS3:                tipos.ConfiguracionS3{Bucket: "test-bucket"},
+			IntervaloSincronizacion: 10 * time.Millisecond,
+			AlCambiarNodos: func(e EventoNodo) {
+				if e.Tipo == EventoNodoAgregado {
+					agregados <- e.NodoID
+				}
+			},
+		},
+		clienteS3:   mockS3,
+		clienteEdge: &mockClienteEdge{},
+	})
+	require.NoError(t, err)
+	defer manager.Cerrar()
+
+	guardarJSONTest(t, mockS3, "nodos/nodo1.json", tipos.Nodo{NodoID: "nodo1"})
+	select {
+	case nodoID := <-agregados:
+		assert.Equal(t, "nodo1", nodoID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("El nodo no se sincronizó con el intervalo configurado")
+	}
+	t.Log("La sincronización periódica respeta IntervaloSincronizacion")
+}
+
+// ============================================================================
+// TESTS DE REGISTROS FIRMADOS
+// ============================================================================
+
+// guardarRegistroFirmadoTest guarda en el mock el registro de un nodo firmado con la clave dada
+func guardarRegistroFirmadoTest(t *testing.T, mockS3 *s3fake.Cliente, nodoID, direccion string, clave ed25519.PrivateKey) {
+	t.Helper()
+	registro, err := json.Marshal(tipos.Nodo{
+		NodoID:       nodoID,
+		Direccion:    direccion,
+		ClavePublica: tipos.CodificarClavePublica(clave.Public().(ed25519.PublicKey)),
+	})
+	require.NoError(t, err)
+	firmado, err := tipos.FirmarRegistroNodo(registro, clave)
+	require.NoError(t, err)
+
+	guardarObjetoTest(t, mockS3, "nodos/"+nodoID+".json", firmado)
+}
+
+// clavePublicaTest retorna la clave pública codificada de una clave privada
+func clavePublicaTest(clave ed25519.PrivateKey) string {
+	return tipos.CodificarClavePublica(clave.Public().(ed25519.PublicKey))
+}
+
+// TestFirmas_RegistroFalsificadoRechazado verifica que no se puede suplantar a un nodo inscripto
+func TestFirmas_RegistroFalsificadoRechazado(t *testing.T) {
+	_, legitima, _ := ed25519.GenerateKey(nil)
+	_, atacante, _ := ed25519.GenerateKey(nil)
+
+	mockS3 := nuevoS3Test(t)
+	guardarRegistroFirmadoTest(t, mockS3, "nodo1", "http://nodo1:8080", legitima)
+	guardarRegistroFirmadoTest(t, mockS3, "nodo2", "http://atacante:8080", atacante)
+	guardarJSONTest(t, mockS3, "nodos/nodo3.json", tipos.Nodo{NodoID: "nodo3", Direccion: "http://atacante:8080"})
+
+	m, _ := crearManagerTest(t, mockS3, Opciones{Firmas: OpcionesFirmas{ClavesConfiables: map[string]string{
+		"nodo1": clavePublicaTest(legitima),
+		"nodo2": clavePublicaTest(legitima),
+		"nodo3": clavePublicaTest(legitima),
+	}}})
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Len(t, m.nodos, 1)
+	assert.Equal(t, "http://nodo1:8080", m.nodos["nodo1"].Direccion)
+
+	rechazados := m.ListarRegistrosRechazados()
+	require.Len(t, rechazados, 2)
+	assert.Equal(t, "nodo2", rechazados[0].NodoID)
+	assert.Contains(t, rechazados[0].Motivo, "no coincide")
+	assert.Equal(t, clavePublicaTest(atacante), rechazados[0].ClavePublica)
+	assert.Equal(t, "nodo3", rechazados[1].NodoID)
+	assert.Contains(t, rechazados[1].Motivo, "sin firma")
+	t.Log("Los registros de nodos inscriptos sin la clave inscripta se rechazan")
+}
+
+// TestFirmas_RegistroAlteradoRechazado verifica que se rechaza un registro firmado y luego modificado
+func TestFirmas_RegistroAlteradoRechazado(t *testing.T) {
+	_, clave, _ := ed25519.GenerateKey(nil)
+	mockS3 := nuevoS3Test(t)
+	guardarRegistroFirmadoTest(t, mockS3, "nodo1", "http://nodo1:8080", clave)
+	guardarObjetoTest(t, mockS3, "nodos/nodo1.json", bytes.Replace(objetoTest(t, mockS3, "nodos/nodo1.json"), []byte("nodo1:8080"), []byte("otro1:8080"), 1))
+
+	m, _ := crearManagerTest(t, mockS3, Opciones{})
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Empty(t, m.nodos)
+	require.Len(t, m.ListarRegistrosRechazados(), 1)
+	assert.Contains(t, m.ListarRegistrosRechazados()[0].Motivo, "firma inválida")
+	t.Log("Los registros con firma inválida se rechazan aunque el nodo no esté inscripto")
+}
+
+// TestFirmas_RequerirFirmaEInscripcion verifica la inscripción de un nodo pendiente
+func TestFirmas_RequerirFirmaEInscripcion(t *testing.T) {
+	_, clave, _ := ed25519.GenerateKey(nil)
+	mockS3 := nuevoS3Test(t)
+	guardarRegistroFirmadoTest(t, mockS3, "nodo1", "http://nodo1:8080", clave)
+	guardarJSONTest(t, mockS3, "nodos/nodo2.json", tipos.Nodo{NodoID: "nodo2"})
+
+	m, _ := crearManagerTest(t, mockS3, Opciones{Firmas: OpcionesFirmas{RequerirFirma: true}})
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Empty(t, m.nodos)
+
+	w := httptest.NewRecorder()
+	HandlerStatus(m)(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	var status StatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Len(t, status.RegistrosRechazados, 2)
+	assert.Equal(t, "nodo no inscripto", status.RegistrosRechazados[0].Motivo)
+	assert.Equal(t, "registro sin firma", status.RegistrosRechazados[1].Motivo)
+
+	// Sin clave en el body se aprueba la clave presentada por el registro rechazado
+	solicitud := httptest.NewRequest(http.MethodPost, "/api/nodos/nodo1/inscripcion", nil)
+	solicitud.SetPathValue("nodoID", "nodo1")
+	w = httptest.NewRecorder()
+	HandlerInscribirNodo(m)(w, solicitud)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	assert.Contains(t, m.nodos, "nodo1")
+	require.Len(t, m.ListarRegistrosRechazados(), 1)
+	assert.Equal(t, "nodo2", m.ListarRegistrosRechazados()[0].NodoID)
+
+	// Un nodo sin registro firmado no se puede inscribir sin clave
+	assert.Error(t, m.InscribirNodo("nodo2", ""))
+
+	require.NoError(t, m.RevocarNodo("nodo1"))
+	assert.NotContains(t, m.nodos, "nodo1")
+	t.Log("Con RequerirFirma solo se cargan los nodos inscriptos")
+}
+
+// TestFirmas_InscripcionAlPrimerUsoPersistida verifica la inscripción automática y su persistencia
+func TestFirmas_InscripcionAlPrimerUsoPersistida(t *testing.T) {
+	_, legitima, _ := ed25519.GenerateKey(nil)
+	_, atacante, _ := ed25519.GenerateKey(nil)
+	archivo := filepath.Join(t.TempDir(), "confianza.json")
+
+	mockS3 := nuevoS3Test(t)
+	guardarRegistroFirmadoTest(t, mockS3, "nodo1", "http://nodo1:8080", legitima)
+	opts := Opciones{Firmas: OpcionesFirmas{ArchivoConfianza: archivo, InscribirAlPrimerUso: true}}
+
+	m, _ := crearManagerTest(t, mockS3, opts)
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Contains(t, m.nodos, "nodo1")
+
+	// Tras reiniciar, la clave inscripta se carga del archivo y el reemplazo se rechaza
+	guardarRegistroFirmadoTest(t, mockS3, "nodo1", "http://atacante:8080", atacante)
+	m, _ = crearManagerTest(t, mockS3, opts)
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Empty(t, m.nodos)
+	require.Len(t, m.ListarRegistrosRechazados(), 1)
+	assert.Contains(t, m.ListarRegistrosRechazados()[0].Motivo, "no coincide")
+	t.Log("La inscripción al primer uso persiste en el archivo de confianza")
+}
+
+// TestFirmas_SinConfiguracionAdmiteTodos verifica la compatibilidad con nodos sin firma
+func TestFirmas_SinConfiguracionAdmiteTodos(t *testing.T) {
+	_, clave, _ := ed25519.GenerateKey(nil)
+	mockS3 := nuevoS3Test(t)
+	guardarRegistroFirmadoTest(t, mockS3, "nodo1", "http://nodo1:8080", clave)
+	guardarJSONTest(t, mockS3, "nodos/nodo2.json", tipos.Nodo{NodoID: "nodo2"})
+
+	m, _ := crearManagerTest(t, mockS3, Opciones{})
+
+	require.NoError(t, m.cargarNodosDesdeS3())
+	assert.Len(t, m.nodos, 2)
+	assert.Empty(t, m.ListarRegistrosRechazados())
+	t.Log("Sin configuración se admiten registros firmados y sin firmar")
+}
+
 // ============================================================================
 // TESTS DE CONSULTAR DATOS S3
 // ============================================================================
 
 // TestConsultarDatosS3_SinBloques verifica respuesta cuando no hay bloques
 func TestConsultarDatosS3_SinBloques(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	nodo := tipos.Nodo{NodoID: "nodo1"}
@@ -970,13 +1600,11 @@ func TestConsultarDatosS3_SinBloques(t *testing.T) {
 
 // TestConsultarDatosS3_ErrorListando verifica manejo de error al listar
 func TestConsultarDatosS3_ErrorListando(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsErr: assert.AnError,
-	}
+	mockS3 := nuevoS3Test(t)
+	fallarS3Test(mockS3, s3fake.OperacionListObjectsV2)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	nodo := tipos.Nodo{NodoID: "nodo1"}
@@ -1017,11 +1645,7 @@ func TestConsultarRango_SoloEdge(t *testing.T) {
 		},
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -1034,9 +1658,8 @@ func TestConsultarRango_SoloEdge(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -1056,11 +1679,7 @@ func TestConsultarRango_EdgeOffline(t *testing.T) {
 		err: assert.AnError, // Simular edge offline
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -1073,9 +1692,8 @@ func TestConsultarRango_EdgeOffline(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -1097,9 +1715,8 @@ func TestConsultarRango_ErrorS3(t *testing.T) {
 		},
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsErr: assert.AnError,
-	}
+	mockS3 := nuevoS3Test(t)
+	fallarS3Test(mockS3, s3fake.OperacionListObjectsV2)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -1112,9 +1729,8 @@ func TestConsultarRango_ErrorS3(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -1181,11 +1797,7 @@ func TestConsultarUltimoPunto_EdgeOffline_SinDatosS3(t *testing.T) {
 		err: assert.AnError, // Edge offline
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -1198,9 +1810,8 @@ func TestConsultarUltimoPunto_EdgeOffline_SinDatosS3(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	_, err := m.ConsultarUltimoPunto("/sensores/temp", nil, nil)
@@ -1270,6 +1881,551 @@ func crearBloqueComprimidoTest(t *testing.T, mediciones []tipos.Medicion, tipoDa
 // TESTS DE CLIENTE EDGE HTTP (httptest)
 // ============================================================================
 
+// servidorEdgeAutenticadoTest simula un edge que verifica la firma de las solicitudes
+func servidorEdgeAutenticadoTest(t *testing.T, nodoID, secreto string) *httptest.Server {
+	t.Helper()
+	verificador := tipos.NuevoVerificadorSolicitudes(nodoID, []byte(secreto), 0)
+	respuestaBytes, err := tipos.SerializarGob(tipos.RespuestaConsultaRango{})
+	require.NoError(t, err)
+
+	servidor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cuerpo, _ := io.ReadAll(r.Body)
+		if err := verificador.Verificar(r, cuerpo); err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+		w.Write(respuestaBytes)
+	}))
+	t.Cleanup(servidor.Close)
+	return servidor
+}
+
+// TestAutenticacionEdge_SolicitudFirmada verifica que el cliente firma las solicitudes con el secreto del nodo
+func TestAutenticacionEdge_SolicitudFirmada(t *testing.T) {
+	servidor := servidorEdgeAutenticadoTest(t, "nodo1", "secreto-nodo1")
+	solicitud := tipos.SolicitudConsultaRango{Serie: "/sensores/temp", TiempoInicio: 1000, TiempoFin: 2000}
+
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{
+		Secreto:         "secreto-general",
+		SecretosPorNodo: map[string]string{"nodo1": "secreto-nodo1"},
+	}, nil)
+	_, err := cliente.ConsultarRango(context.Background(), "nodo1", servidor.URL, solicitud)
+	assert.NoError(t, err)
+
+	// Con el secreto general el nodo rechaza la solicitud
+	cliente = nuevoClienteEdgeHTTP(OpcionesAutenticacion{Secreto: "secreto-general"}, nil)
+	_, err = cliente.ConsultarRango(context.Background(), "nodo1", servidor.URL, solicitud)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status 401")
+
+	// Sin secreto la solicitud no se firma
+	cliente = nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
+	_, err = cliente.ConsultarRango(context.Background(), "nodo1", servidor.URL, solicitud)
+	assert.Error(t, err)
+	t.Log("clienteEdgeHTTP firma las solicitudes con el secreto de cada nodo")
+}
+
+// TestAutenticacionEdge_TodasLasConsultas verifica la firma en todos los tipos de consulta
+func TestAutenticacionEdge_TodasLasConsultas(t *testing.T) {
+	verificador := tipos.NuevoVerificadorSolicitudes("nodo1", []byte("secreto"), 0)
+	var rechazadas atomic.Int32
+	servidor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cuerpo, _ := io.ReadAll(r.Body)
+		if err := verificador.Verificar(r, cuerpo); err != nil {
+			rechazadas.Add(1)
+		}
+		http.Error(w, "sin datos", http.StatusNotFound)
+	}))
+	defer servidor.Close()
+
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{Secreto: "secreto"}, nil)
+	ctx := context.Background()
+	cliente.ConsultarRango(ctx, "nodo1", servidor.URL, tipos.SolicitudConsultaRango{})
+	cliente.ConsultarUltimoPunto(ctx, "nodo1", servidor.URL, tipos.SolicitudConsultaPunto{})
+	cliente.ConsultarAgregacion(ctx, "nodo1", servidor.URL, tipos.SolicitudConsultaAgregacion{})
+	cliente.ConsultarAgregacionTemporal(ctx, "nodo1", servidor.URL, tipos.SolicitudConsultaAgregacionTemporal{})
+
+	assert.Equal(t, int32(0), rechazadas.Load())
+	t.Log("Todas las consultas al edge se envían firmadas")
+}
+
+// ============================================================================
+// TESTS DE TLS HACIA LOS EDGES
+// ============================================================================
+
+// servidorEdgeTLSTest crea un edge HTTPS con certificado local que responde consultas de rango
+func servidorEdgeTLSTest(t *testing.T) *httptest.Server {
+	t.Helper()
+	respuesta, err := tipos.SerializarGob(tipos.RespuestaConsultaRango{
+		Resultado: tipos.ResultadoConsultaRango{Series: []string{"/sensores/temp"}, Tiempos: []int64{1000}, Valores: [][]interface{}{{1.0}}},
+	})
+	require.NoError(t, err)
+	servidor := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(respuesta)
+	}))
+	t.Cleanup(servidor.Close)
+	return servidor
+}
+
+// TestTLSEdge_HuellaFijada verifica que el certificado autofirmado se acepta solo con la huella registrada
+func TestTLSEdge_HuellaFijada(t *testing.T) {
+	servidor := servidorEdgeTLSTest(t)
+	huella := tipos.HuellaCertificado(servidor.Certificate().Raw)
+	solicitud := tipos.SolicitudConsultaRango{Serie: "/sensores/temp"}
+
+	huellas := map[string]string{"nodo1": huella, "nodo2": strings.Repeat("0", len(huella))}
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
+	cliente.huellaNodo = func(nodoID string) string { return huellas[nodoID] }
+
+	respuesta, err := cliente.ConsultarRango(context.Background(), "nodo1", servidor.URL, solicitud)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1000}, respuesta.Resultado.Tiempos)
+
+	// Huella distinta a la del certificado presentado
+	_, err = cliente.ConsultarRango(context.Background(), "nodo2", servidor.URL, solicitud)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "huella del certificado")
+
+	// Sin huella el certificado autofirmado no es de confianza
+	_, err = cliente.ConsultarRango(context.Background(), "nodo3", servidor.URL, solicitud)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+
+	// El cliente fijado conserva la configuración del transporte general
+	general := cliente.httpClient.Transport.(*http.Transport)
+	fijado := cliente.clientePara("nodo1").Transport.(*http.Transport)
+	assert.Equal(t, cliente.httpClient.Timeout, cliente.clientePara("nodo1").Timeout)
+	assert.Equal(t, general.TLSHandshakeTimeout, fijado.TLSHandshakeTimeout)
+	assert.Equal(t, general.IdleConnTimeout, fijado.IdleConnTimeout)
+	assert.Equal(t, general.MaxIdleConns, fijado.MaxIdleConns)
+	assert.NotNil(t, fijado.DialContext)
+	assert.NotSame(t, general.TLSClientConfig, fijado.TLSClientConfig)
+	t.Log("El cliente solo acepta certificados autofirmados con la huella registrada")
+}
+
+// TestTLSEdge_ArchivoCA verifica la verificación de certificados contra una CA propia
+func TestTLSEdge_ArchivoCA(t *testing.T) {
+	servidor := servidorEdgeTLSTest(t)
+
+	archivoCA := filepath.Join(t.TempDir(), "ca.pem")
+	certificadoPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: servidor.Certificate().Raw})
+	require.NoError(t, os.WriteFile(archivoCA, certificadoPEM, 0600))
+
+	raices, err := OpcionesTLS{ArchivoCA: archivoCA}.cargarRaices()
+	require.NoError(t, err)
+
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, raices)
+	_, err = cliente.ConsultarRango(context.Background(), "nodo1", servidor.URL, tipos.SolicitudConsultaRango{})
+	assert.NoError(t, err)
+
+	// Archivo sin certificados
+	vacio := filepath.Join(t.TempDir(), "vacio.pem")
+	require.NoError(t, os.WriteFile(vacio, []byte("sin certificados"), 0600))
+	_, err = OpcionesTLS{ArchivoCA: vacio}.cargarRaices()
+	assert.Error(t, err)
+	t.Log("El cliente verifica los nodos contra la CA de ArchivoCA")
+}
+
+// TestTLSEdge_HuellaDesdeRegistro verifica que el despachador usa el esquema y la huella del registro del nodo
+func TestTLSEdge_HuellaDesdeRegistro(t *testing.T) {
+	servidor := servidorEdgeTLSTest(t)
+	mockS3 := nuevoS3Test(t)
+	guardarJSONTest(t, mockS3, "nodos/nodo1.json", tipos.Nodo{
+		NodoID:            "nodo1",
+		Direccion:         strings.TrimPrefix(servidor.URL, "https://"),
+		Esquema:           tipos.EsquemaHTTPS,
+		HuellaCertificado: tipos.HuellaCertificado(servidor.Certificate().Raw),
+	})
+
+	manager, err := crearConOpciones(opcionesInternas{
+		Opciones:  Opciones{ConfigS3: tipos.ConfiguracionS3{Bucket: "test-bucket"}},
+		clienteS3: mockS3,
+	})
+	require.NoError(t, err)
+	defer manager.Cerrar()
+
+	nodo := manager.ListarNodos()[0]
+	assert.Equal(t, servidor.URL, nodo.URLBase())
+	resultado, err := manager.consultarEdgeConTimeout(nodo, "/sensores/temp", 0, 2000, 5*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1000}, resultado.Tiempos)
+	t.Log("El despachador consulta por HTTPS a los nodos con la huella publicada en su registro")
+}
+
+// ============================================================================
+// TESTS DE AUTORIZACIÓN
+// ============================================================================
+
+// opcionesAutorizacionTest habilita la autorización con la clave maestra "clave-maestra"
+// y firma los comandos encolados con el secreto "secreto"
+var opcionesAutorizacionTest = Opciones{
+	Autorizacion:  OpcionesAutorizacion{Habilitada: true, ClaveMaestra: "clave-maestra"},
+	Autenticacion: OpcionesAutenticacion{Secreto: "secreto"},
+}
+
+// nodosZonasTest retorna dos nodos: nodo1 (zona norte, serie planta1/temp) y
+// nodo2 (zona sur, serie planta2/temp), cada uno con una regla sobre su serie
+func nodosZonasTest() []*tipos.Nodo {
+	regla := func(id, path string) tipos.Regla {
+		return tipos.Regla{ID: id, Activa: true, Condiciones: []tipos.Condicion{{Path: path}}}
+	}
+	return []*tipos.Nodo{
+		{
+			NodoID: "nodo1",
+			Tags:   map[string]string{"zona": "norte"},
+			Series: map[string]tipos.Serie{"planta1/temp": {Path: "planta1/temp"}},
+			Reglas: []tipos.Regla{regla("regla1", "planta1/temp")},
+		},
+		{
+			NodoID: "nodo2",
+			Tags:   map[string]string{"zona": "sur"},
+			Series: map[string]tipos.Serie{"planta2/temp": {Path: "planta2/temp"}},
+			Reglas: []tipos.Regla{regla("regla2", "planta2/temp")},
+		},
+	}
+}
+
+// solicitudConTokenTest ejecuta el handler con el token dado ("" = sin credencial)
+func solicitudConTokenTest(handler http.HandlerFunc, metodo, ruta, token string, cuerpo string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(metodo, ruta, strings.NewReader(cuerpo))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// TestAutorizacion_Roles verifica la autenticación y el rol mínimo de cada handler
+func TestAutorizacion_Roles(t *testing.T) {
+	m, _ := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	_, tokenLector, err := m.CrearClaveAPI(ClaveAPI{Nombre: "tablero", Rol: RolLector})
+	require.NoError(t, err)
+
+	listarNodos := RequerirRol(m, RolLector, HandlerListarNodos(m))
+	listarClaves := RequerirRol(m, RolAdministrador, HandlerListarClavesAPI(m))
+
+	w := solicitudConTokenTest(listarNodos, http.MethodGet, "/api/nodos", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+
+	w = solicitudConTokenTest(listarNodos, http.MethodGet, "/api/nodos", tokenLector+"x", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = solicitudConTokenTest(listarNodos, http.MethodGet, "/api/nodos", tokenLector, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = solicitudConTokenTest(listarClaves, http.MethodGet, "/api/claves", tokenLector, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = solicitudConTokenTest(listarClaves, http.MethodGet, "/api/claves", "clave-maestra", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var claves []ClaveAPIResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &claves))
+	require.Len(t, claves, 1)
+	assert.Equal(t, "tablero", claves[0].Nombre)
+	assert.Empty(t, claves[0].Token, "El token no se vuelve a informar")
+	assert.NotContains(t, w.Body.String(), "hash_secreto")
+	t.Log("RequerirRol exige credenciales válidas con el rol mínimo del handler")
+}
+
+// TestAutorizacion_RestriccionesSeriesYNodos verifica que las restricciones se aplican a series, consultas y reglas
+func TestAutorizacion_RestriccionesSeriesYNodos(t *testing.T) {
+	m, _ := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	_, porSerie, err := m.CrearClaveAPI(ClaveAPI{Rol: RolLector, Series: []string{"planta1/*"}})
+	require.NoError(t, err)
+	_, porTags, err := m.CrearClaveAPI(ClaveAPI{Rol: RolLector, TagsNodo: map[string]string{"zona": "norte"}})
+	require.NoError(t, err)
+
+	for _, token := range []string{porSerie, porTags} {
+		// Listado de series
+		w := solicitudConTokenTest(RequerirRol(m, RolLector, HandlerListarSeries(m)), http.MethodGet, "/api/series", token, "")
+		var series []SerieResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &series))
+		require.Len(t, series, 1)
+		assert.Equal(t, "planta1/temp", series[0].Path)
+
+		// Consultas: una serie fuera de la restricción o un patrón que la incluye se rechazan
+		consultar := RequerirRol(m, RolLector, HandlerConsultarRango(m))
+		for _, serie := range []string{"planta2/temp", "*/temp"} {
+			cuerpo := fmt.Sprintf(`{"serie": %q, "tiempo_inicio": 0, "tiempo_fin": 1000}`, serie)
+			w = solicitudConTokenTest(consultar, http.MethodPost, "/api/consulta/rango", token, cuerpo)
+			assert.Equal(t, http.StatusForbidden, w.Code, serie)
+		}
+
+		// Reglas
+		w = solicitudConTokenTest(RequerirRol(m, RolLector, HandlerListarReglas(m)), http.MethodGet, "/api/reglas", token, "")
+		var reglas []ReglaResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reglas))
+		require.Len(t, reglas, 1)
+		assert.Equal(t, "regla1", reglas[0].ID)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/reglas/regla2", nil)
+		req.SetPathValue("id", "regla2")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w = httptest.NewRecorder()
+		RequerirRol(m, RolLector, HandlerObtenerRegla(m))(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+
+	// La restricción por tags también filtra los nodos y sus reglas
+	w := solicitudConTokenTest(RequerirRol(m, RolLector, HandlerListarNodos(m)), http.MethodGet, "/api/nodos", porTags, "")
+	var nodos []NodoResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &nodos))
+	require.Len(t, nodos, 1)
+	assert.Equal(t, "nodo1", nodos[0].NodoID)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/nodos/nodo2/reglas", nil)
+	req.SetPathValue("nodoID", "nodo2")
+	req.Header.Set("Authorization", "Bearer "+porTags)
+	w = httptest.NewRecorder()
+	RequerirRol(m, RolLector, HandlerListarReglasPorNodo(m))(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	t.Log("Las restricciones de series y tags de nodo se aplican a series, consultas y reglas")
+}
+
+// TestAutorizacion_ClavesEnS3 verifica que las claves se comparten entre despachadores por S3
+func TestAutorizacion_ClavesEnS3(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	m1, _ := crearManagerTest(t, mockS3, opcionesAutorizacionTest, nodosZonasTest()...)
+	m2, _ := crearManagerTest(t, mockS3, opcionesAutorizacionTest, nodosZonasTest()...)
+
+	clave, token, err := m1.CrearClaveAPI(ClaveAPI{Nombre: "operaciones", Rol: RolOperador})
+	require.NoError(t, err)
+	assert.Contains(t, mockS3.Claves(bucketTest, ""), "claves_api/"+clave.ID+".json")
+	assert.NotContains(t, string(objetoTest(t, mockS3, "claves_api/"+clave.ID+".json")), strings.TrimPrefix(token, "swk_"+clave.ID+"_"),
+		"S3 solo guarda el hash del secreto")
+
+	listar := RequerirRol(m2, RolOperador, HandlerListarNodos(m2))
+	assert.Equal(t, http.StatusUnauthorized, solicitudConTokenTest(listar, http.MethodGet, "/api/nodos", token, "").Code)
+
+	require.NoError(t, m2.sincronizarClavesAPI(context.Background()))
+	assert.Equal(t, http.StatusOK, solicitudConTokenTest(listar, http.MethodGet, "/api/nodos", token, "").Code)
+
+	require.NoError(t, m1.RevocarClaveAPI(clave.ID))
+	require.NoError(t, m2.sincronizarClavesAPI(context.Background()))
+	assert.Equal(t, http.StatusUnauthorized, solicitudConTokenTest(listar, http.MethodGet, "/api/nodos", token, "").Code)
+	t.Log("Las claves API se guardan en S3 y se sincronizan entre despachadores")
+}
+
+// jwtTest firma un JWT HS256 con los reclamos dados
+func jwtTest(t *testing.T, alg string, reclamos interface{}, secreto string) string {
+	t.Helper()
+	encabezado, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+	cuerpo, err := json.Marshal(reclamos)
+	require.NoError(t, err)
+	contenido := base64.RawURLEncoding.EncodeToString(encabezado) + "." + base64.RawURLEncoding.EncodeToString(cuerpo)
+	mac := hmac.New(sha256.New, []byte(secreto))
+	mac.Write([]byte(contenido))
+	return contenido + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TestAutorizacion_JWT verifica la autenticación con JWT HS256
+func TestAutorizacion_JWT(t *testing.T) {
+	ahora := time.Now()
+	vigente := map[string]interface{}{"sub": "grafana", "rol": "lector", "series": []string{"planta1/*"}, "exp": ahora.Add(time.Hour).Unix()}
+
+	permisos, err := verificarJWT(jwtTest(t, "HS256", vigente, "secreto"), []byte("secreto"), ahora)
+	require.NoError(t, err)
+	assert.Equal(t, "grafana", permisos.Sujeto)
+	assert.Equal(t, RolLector, permisos.Rol)
+	assert.Equal(t, []string{"planta1/*"}, permisos.Series)
+
+	casos := map[string]string{
+		"otro secreto": jwtTest(t, "HS256", vigente, "otro"),
+		"alg none":     jwtTest(t, "none", vigente, "secreto"),
+		"expirado":     jwtTest(t, "HS256", map[string]interface{}{"rol": "lector", "exp": ahora.Add(-time.Minute).Unix()}, "secreto"),
+		"sin exp":      jwtTest(t, "HS256", map[string]interface{}{"rol": "lector"}, "secreto"),
+		"rol inválido": jwtTest(t, "HS256", map[string]interface{}{"rol": "root", "exp": ahora.Add(time.Hour).Unix()}, "secreto"),
+	}
+	for nombre, token := range casos {
+		_, err := verificarJWT(token, []byte("secreto"), ahora)
+		assert.Error(t, err, nombre)
+	}
+
+	m, _ := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	m.autorizacion.opts.SecretoJWT = "secreto"
+	listar := RequerirRol(m, RolLector, HandlerListarSeries(m))
+	w := solicitudConTokenTest(listar, http.MethodGet, "/api/series", jwtTest(t, "HS256", vigente, "secreto"), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var series []SerieResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &series))
+	assert.Len(t, series, 1)
+	t.Log("Los JWT HS256 se verifican y sus reclamos restringen el acceso")
+}
+
+// TestAutorizacion_Deshabilitada verifica que sin autorización la API queda abierta
+func TestAutorizacion_Deshabilitada(t *testing.T) {
+	m, _ := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	m.autorizacion = nil
+
+	w := solicitudConTokenTest(RequerirRol(m, RolAdministrador, HandlerListarSeries(m)), http.MethodGet, "/api/series", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var series []SerieResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &series))
+	assert.Len(t, series, 2)
+
+	_, _, err := m.CrearClaveAPI(ClaveAPI{Rol: RolLector})
+	assert.Error(t, err)
+	t.Log("Sin autorización habilitada RequerirRol no exige credenciales")
+}
+
+// ============================================================================
+// TESTS DEL SERVIDOR HTTP
+// ============================================================================
+
+// patronOpenAPI convierte un patrón de http.ServeMux en método y ruta OpenAPI
+// ("GET /api/series/{path...}" → "get", "/api/series/{path}")
+func patronOpenAPI(patron string) (string, string) {
+	metodo, camino, _ := strings.Cut(patron, " ")
+	return strings.ToLower(metodo), strings.ReplaceAll(camino, "...}", "}")
+}
+
+// TestServidor_RutasCoincidenConOpenAPI verifica que la especificación documenta exactamente las rutas registradas
+func TestServidor_RutasCoincidenConOpenAPI(t *testing.T) {
+	var especificacion struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(especificacionOpenAPI, &especificacion))
+
+	documentadas := 0
+	for _, operaciones := range especificacion.Paths {
+		for metodo := range operaciones {
+			if metodo != "parameters" {
+				documentadas++
+			}
+		}
+	}
+	for _, r := range rutasAPI {
+		metodo, camino := patronOpenAPI(r.patron)
+		assert.Contains(t, especificacion.Paths[camino], metodo, r.patron)
+	}
+	assert.Equal(t, len(rutasAPI), documentadas)
+	t.Log("openapi.json documenta todas las rutas de rutasAPI")
+}
+
+// TestServidor_Rutas verifica el ruteo, incluido el path con barras de HandlerObtenerSerie
+func TestServidor_Rutas(t *testing.T) {
+	m, _ := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	m.autorizacion = nil
+	router := NuevoServidor(m, OpcionesServidor{SinRegistroSolicitudes: true}).Handler()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/series/planta1/temp", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var serie SerieResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &serie))
+	assert.Equal(t, "planta1/temp", serie.Path)
+	assert.Equal(t, "nodo1", serie.NodoID)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/nodos/nodo2/reglas", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "regla2")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/series", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	t.Log("NuevoServidor registra las rutas de la API REST")
+}
+
+// TestServidor_Autorizacion verifica que el router aplica el rol de cada ruta
+func TestServidor_Autorizacion(t *testing.T) {
+	m, _ := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	_, tokenLector, err := m.CrearClaveAPI(ClaveAPI{Rol: RolLector})
+	require.NoError(t, err)
+	router := NuevoServidor(m, OpcionesServidor{SinRegistroSolicitudes: true}).Handler()
+
+	solicitud := func(metodo, ruta, token string) int {
+		req := httptest.NewRequest(metodo, ruta, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, solicitud(http.MethodGet, "/api/nodos", ""))
+	assert.Equal(t, http.StatusOK, solicitud(http.MethodGet, "/api/nodos", tokenLector))
+	assert.Equal(t, http.StatusForbidden, solicitud(http.MethodGet, "/api/claves", tokenLector))
+	assert.Equal(t, http.StatusForbidden, solicitud(http.MethodDelete, "/api/nodos/nodo1/inscripcion", tokenLector))
+	assert.Equal(t, http.StatusOK, solicitud(http.MethodGet, "/api/claves", "clave-maestra"))
+	assert.Equal(t, http.StatusOK, solicitud(http.MethodGet, "/api/openapi.json", ""))
+	t.Log("El router exige el rol de cada ruta y deja pública la especificación")
+}
+
+// TestServidor_Middlewares verifica la recuperación de pánicos, el límite del cuerpo y CORS
+func TestServidor_Middlewares(t *testing.T) {
+	// Pánico en un handler
+	w := httptest.NewRecorder()
+	recuperarPanicos(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("falla")
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// Límite del cuerpo: declarado y sin declarar
+	m, _ := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	m.autorizacion = nil
+	router := NuevoServidor(m, OpcionesServidor{TamañoMaximoCuerpo: 16, SinRegistroSolicitudes: true}).Handler()
+	cuerpo := `{"serie": "planta1/temp", "tiempo_inicio": 0, "tiempo_fin": 1000}`
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/consulta/rango", strings.NewReader(cuerpo)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/consulta/rango", strings.NewReader(cuerpo))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// CORS: verificación previa de un origen permitido y solicitud de uno no permitido
+	router = NuevoServidor(m, OpcionesServidor{OrigenesCORS: []string{"https://tablero.ejemplo"}, SinRegistroSolicitudes: true}).Handler()
+	req = httptest.NewRequest(http.MethodOptions, "/api/consulta/rango", nil)
+	req.Header.Set("Origin", "https://tablero.ejemplo")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://tablero.ejemplo", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+
+	req = httptest.NewRequest(http.MethodGet, "/api/nodos", nil)
+	req.Header.Set("Origin", "https://otro.ejemplo")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	t.Log("Los middlewares recuperan pánicos, limitan el cuerpo y aplican CORS")
+}
+
+// TestServidor_CierreOrdenado verifica que Cerrar termina Iniciar sin error
+func TestServidor_CierreOrdenado(t *testing.T) {
+	servidor := NuevoServidor(&ManagerDespachador{}, OpcionesServidor{Direccion: "127.0.0.1:0"})
+	resultado := make(chan error, 1)
+	go func() { resultado <- servidor.Iniciar() }()
+
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, servidor.Cerrar(ctx))
+
+	select {
+	case err := <-resultado:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Iniciar no terminó tras Cerrar")
+	}
+	t.Log("Cerrar detiene el servidor de forma ordenada")
+}
+
 // TestClienteEdgeHTTP_ConsultarRango_Exitoso verifica consulta exitosa via HTTP
 func TestClienteEdgeHTTP_ConsultarRango_Exitoso(t *testing.T) {
 	// Crear respuesta esperada
@@ -1298,7 +2454,7 @@ func TestClienteEdgeHTTP_ConsultarRango_Exitoso(t *testing.T) {
 	defer servidor.Close()
 
 	// Crear cliente y hacer consulta
-	cliente := nuevoClienteEdgeHTTP()
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
 
 	// Extraer host:port del servidor de test
 	direccion := strings.TrimPrefix(servidor.URL, "http://")
@@ -1327,7 +2483,7 @@ func TestClienteEdgeHTTP_ConsultarRango_ErrorHTTP(t *testing.T) {
 	}))
 	defer servidor.Close()
 
-	cliente := nuevoClienteEdgeHTTP()
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
 	direccion := strings.TrimPrefix(servidor.URL, "http://")
 
 	solicitud := tipos.SolicitudConsultaRango{
@@ -1345,7 +2501,7 @@ func TestClienteEdgeHTTP_ConsultarRango_ErrorHTTP(t *testing.T) {
 
 // TestClienteEdgeHTTP_ConsultarRango_ErrorConexion verifica manejo de error de conexion
 func TestClienteEdgeHTTP_ConsultarRango_ErrorConexion(t *testing.T) {
-	cliente := nuevoClienteEdgeHTTP()
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
 
 	// Usar direccion invalida
 	solicitud := tipos.SolicitudConsultaRango{
@@ -1370,7 +2526,7 @@ func TestClienteEdgeHTTP_ConsultarRango_ErrorDeserializacion(t *testing.T) {
 	}))
 	defer servidor.Close()
 
-	cliente := nuevoClienteEdgeHTTP()
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
 	direccion := strings.TrimPrefix(servidor.URL, "http://")
 
 	solicitud := tipos.SolicitudConsultaRango{
@@ -1409,7 +2565,7 @@ func TestClienteEdgeHTTP_ConsultarUltimoPunto_Exitoso(t *testing.T) {
 	}))
 	defer servidor.Close()
 
-	cliente := nuevoClienteEdgeHTTP()
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
 	direccion := strings.TrimPrefix(servidor.URL, "http://")
 
 	solicitud := tipos.SolicitudConsultaPunto{
@@ -1434,7 +2590,7 @@ func TestClienteEdgeHTTP_ConsultarUltimoPunto_ErrorHTTP(t *testing.T) {
 	}))
 	defer servidor.Close()
 
-	cliente := nuevoClienteEdgeHTTP()
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
 	direccion := strings.TrimPrefix(servidor.URL, "http://")
 
 	solicitud := tipos.SolicitudConsultaPunto{Serie: "/sensores/noexiste"}
@@ -1447,7 +2603,7 @@ func TestClienteEdgeHTTP_ConsultarUltimoPunto_ErrorHTTP(t *testing.T) {
 
 // TestClienteEdgeHTTP_ConsultarUltimoPunto_ErrorConexion verifica error de conexion
 func TestClienteEdgeHTTP_ConsultarUltimoPunto_ErrorConexion(t *testing.T) {
-	cliente := nuevoClienteEdgeHTTP()
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
 
 	solicitud := tipos.SolicitudConsultaPunto{Serie: "/sensores/temp"}
 	_, err := cliente.ConsultarUltimoPunto(context.Background(), "1", "localhost:99999", solicitud)
@@ -1473,13 +2629,11 @@ func TestDescargarYDescomprimirBloque_Exitoso(t *testing.T) {
 	// Crear bloque comprimido
 	bloqueComprimido := crearBloqueComprimidoTest(t, mediciones, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna)
 
-	mockS3 := &mockClienteS3{
-		getObjectData: bloqueComprimido,
-	}
+	mockS3 := nuevoS3Test(t)
+	guardarObjetoTest(t, mockS3, "nodo1/data/0000000001/bloque", bloqueComprimido)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	serie := tipos.Serie{
@@ -1489,7 +2643,7 @@ func TestDescargarYDescomprimirBloque_Exitoso(t *testing.T) {
 		CompresionBloque: tipos.Ninguna,
 	}
 
-	resultado, err := m.descargarYDescomprimirBloque("nodo1/data/0000000001/bloque", serie)
+	resultado, err := m.descargarYDescomprimirBloque(tipos.BloqueManifiesto{Clave: "nodo1/data/0000000001/bloque"}, serie)
 
 	assert.NoError(t, err)
 	assert.Len(t, resultado, 3)
@@ -1500,13 +2654,11 @@ func TestDescargarYDescomprimirBloque_Exitoso(t *testing.T) {
 
 // TestDescargarYDescomprimirBloque_ErrorDescarga verifica manejo de error al descargar
 func TestDescargarYDescomprimirBloque_ErrorDescarga(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		getObjectErr: assert.AnError,
-	}
+	mockS3 := nuevoS3Test(t, "nodo1/data/0000000001/bloque")
+	fallarS3Test(mockS3, s3fake.OperacionGetObject)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	serie := tipos.Serie{
@@ -1516,7 +2668,7 @@ func TestDescargarYDescomprimirBloque_ErrorDescarga(t *testing.T) {
 		CompresionBloque: tipos.Ninguna,
 	}
 
-	_, err := m.descargarYDescomprimirBloque("nodo1/data/0000000001/bloque", serie)
+	_, err := m.descargarYDescomprimirBloque(tipos.BloqueManifiesto{Clave: "nodo1/data/0000000001/bloque"}, serie)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "descargando")
@@ -1525,13 +2677,11 @@ func TestDescargarYDescomprimirBloque_ErrorDescarga(t *testing.T) {
 
 // TestDescargarYDescomprimirBloque_ErrorDescompresion verifica manejo de datos invalidos
 func TestDescargarYDescomprimirBloque_ErrorDescompresion(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		getObjectData: []byte("datos invalidos no comprimidos"),
-	}
+	mockS3 := nuevoS3Test(t)
+	guardarObjetoTest(t, mockS3, "nodo1/data/0000000001/bloque", []byte("datos invalidos no comprimidos"))
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	serie := tipos.Serie{
@@ -1541,13 +2691,383 @@ func TestDescargarYDescomprimirBloque_ErrorDescompresion(t *testing.T) {
 		CompresionBloque: tipos.LZ4, // Espera LZ4 pero recibe datos invalidos
 	}
 
-	_, err := m.descargarYDescomprimirBloque("nodo1/data/0000000001/bloque", serie)
+	_, err := m.descargarYDescomprimirBloque(tipos.BloqueManifiesto{Clave: "nodo1/data/0000000001/bloque"}, serie)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "descomprimiendo")
 	t.Log("descargarYDescomprimirBloque maneja errores de descompresion")
 }
 
+// ============================================================================
+// TESTS DE CACHE DE BLOQUES
+// ============================================================================
+
+// serieCacheTest es la serie usada por los tests de cache
+var serieCacheTest = tipos.Serie{
+	SerieId:          1,
+	TipoDatos:        tipos.Integer,
+	CompresionBytes:  tipos.DeltaDelta,
+	CompresionBloque: tipos.Ninguna,
+}
+
+// TestCacheBloques_AciertoEnMemoria verifica que un bloque repetido no se descarga de nuevo
+func TestCacheBloques_AciertoEnMemoria(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	clave := tipos.GenerarClaveS3Datos("nodo1", 1, 1000, 3000)
+	guardarObjetoTest(t, mockS3, clave, crearBloqueComprimidoTest(t, []tipos.Medicion{
+		{Tiempo: 1000, Valor: int64(10)},
+		{Tiempo: 3000, Valor: int64(30)},
+	}, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna))
+
+	m, _ := crearManagerTest(t, mockS3, Opciones{})
+	nodo := tipos.Nodo{NodoID: "nodo1"}
+
+	for i := 0; i < 3; i++ {
+		mediciones, err := m.consultarDatosS3(nodo, serieCacheTest, 0, 5000)
+		require.NoError(t, err)
+		assert.Len(t, mediciones, 2)
+	}
+
+	assert.Equal(t, 1, mockS3.LlamadasClave(s3fake.OperacionGetObject, clave), "El bloque debe descargarse una sola vez")
+
+	stats := m.ObtenerEstadisticas().CacheBloques
+	assert.Equal(t, int64(2), stats.AciertosMemoria)
+	assert.Equal(t, int64(1), stats.Fallos)
+	assert.Equal(t, 1, stats.EntradasMemoria)
+	assert.Greater(t, stats.BytesMemoria, int64(0))
+	assert.InDelta(t, 2.0/3.0, stats.TasaAciertos, 0.001)
+	t.Log("La cache sirve desde memoria los bloques ya descargados")
+}
+
+// TestCacheBloques_InvalidaPorETag verifica que un bloque reescrito en S3 se descarga de nuevo
+func TestCacheBloques_InvalidaPorETag(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	clave := tipos.GenerarClaveS3Datos("nodo1", 1, 1000, 3000)
+	guardarObjetoTest(t, mockS3, clave, crearBloqueComprimidoTest(t, []tipos.Medicion{
+		{Tiempo: 1000, Valor: int64(10)},
+	}, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna))
+
+	m, _ := crearManagerTest(t, mockS3, Opciones{})
+	nodo := tipos.Nodo{NodoID: "nodo1"}
+
+	_, err := m.consultarDatosS3(nodo, serieCacheTest, 0, 5000)
+	require.NoError(t, err)
+
+	// Reescribir el bloque con otro contenido (nuevo ETag)
+	guardarObjetoTest(t, mockS3, clave, crearBloqueComprimidoTest(t, []tipos.Medicion{
+		{Tiempo: 1000, Valor: int64(10)},
+		{Tiempo: 2000, Valor: int64(20)},
+	}, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna))
+
+	mediciones, err := m.consultarDatosS3(nodo, serieCacheTest, 0, 5000)
+	require.NoError(t, err)
+	assert.Len(t, mediciones, 2, "Debe leerse el contenido nuevo")
+	assert.Equal(t, 2, mockS3.LlamadasClave(s3fake.OperacionGetObject, clave))
+	assert.Equal(t, 1, m.ObtenerEstadisticas().CacheBloques.EntradasMemoria, "La versión anterior debe reemplazarse")
+	t.Log("La cache invalida los bloques cuyo ETag cambió")
+}
+
+// TestCacheBloques_RevalidacionSinETag verifica la descarga condicional cuando el ETag no se conoce
+func TestCacheBloques_RevalidacionSinETag(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	clave := tipos.GenerarClaveS3Datos("nodo1", 1, 1000, 3000)
+	guardarObjetoTest(t, mockS3, clave, crearBloqueComprimidoTest(t, []tipos.Medicion{
+		{Tiempo: 1000, Valor: int64(10)},
+	}, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna))
+
+	m, _ := crearManagerTest(t, mockS3, Opciones{})
+	bloque := tipos.BloqueManifiesto{Clave: clave} // Manifiesto sin ETag
+
+	_, err := m.descargarYDescomprimirBloque(bloque, serieCacheTest)
+	require.NoError(t, err)
+	mediciones, err := m.descargarYDescomprimirBloque(bloque, serieCacheTest)
+	require.NoError(t, err)
+
+	assert.Len(t, mediciones, 1)
+	stats := m.ObtenerEstadisticas().CacheBloques
+	assert.Equal(t, int64(1), stats.Revalidaciones, "La segunda lectura debe resolverse con 304")
+	assert.Equal(t, int64(1), stats.Fallos)
+	t.Log("Los bloques sin ETag conocido se revalidan con If-None-Match")
+}
+
+// TestCacheBloques_NivelDisco verifica el desalojo a disco y la promoción a memoria
+func TestCacheBloques_NivelDisco(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	var bloques []tipos.BloqueManifiesto
+	for i := int64(0); i < 3; i++ {
+		clave := tipos.GenerarClaveS3Datos("nodo1", 1, i*1000, i*1000+500)
+		etag := guardarObjetoTest(t, mockS3, clave, crearBloqueComprimidoTest(t, []tipos.Medicion{
+			{Tiempo: i * 1000, Valor: i},
+			{Tiempo: i*1000 + 500, Valor: i + 1},
+		}, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna))
+		bloques = append(bloques, tipos.BloqueManifiesto{Clave: clave, ETag: etag})
+	}
+
+	directorio := t.TempDir()
+	// Memoria para un único bloque: cada descarga desaloja la anterior a disco
+	tamañoBloque := int64(len(objetoTest(t, mockS3, bloques[0].Clave))) + 2*tamañoEstimadoMedicion
+	m, _ := crearManagerTest(t, mockS3, Opciones{CacheBloques: OpcionesCacheBloques{
+		TamañoMemoria: tamañoBloque,
+		Directorio:    directorio,
+	}})
+
+	for _, bloque := range bloques {
+		_, err := m.descargarYDescomprimirBloque(bloque, serieCacheTest)
+		require.NoError(t, err)
+	}
+
+	stats := m.ObtenerEstadisticas().CacheBloques
+	assert.Equal(t, 1, stats.EntradasMemoria)
+	assert.Equal(t, 2, stats.EntradasDisco)
+	archivos, _ := filepath.Glob(filepath.Join(directorio, "*"+extensionBloqueCache))
+	assert.Len(t, archivos, 2)
+
+	// El primer bloque se sirve desde disco sin acceder a S3
+	mediciones, err := m.descargarYDescomprimirBloque(bloques[0], serieCacheTest)
+	require.NoError(t, err)
+	assert.Len(t, mediciones, 2)
+	assert.Equal(t, int64(0), mediciones[0].Valor)
+	assert.Equal(t, 1, mockS3.LlamadasClave(s3fake.OperacionGetObject, bloques[0].Clave))
+	assert.Equal(t, int64(1), m.ObtenerEstadisticas().CacheBloques.AciertosDisco)
+	t.Log("La cache desaloja a disco y promueve a memoria los bloques leídos")
+}
+
+// TestCacheBloques_DesalojoConcurrente verifica que el desalojo a disco, que escribe fuera
+// del lock, mantiene consistentes el índice y los archivos con consultas concurrentes
+func TestCacheBloques_DesalojoConcurrente(t *testing.T) {
+	comprimido := crearBloqueComprimidoTest(t, []tipos.Medicion{
+		{Tiempo: 1000, Valor: int64(10)},
+		{Tiempo: 2000, Valor: int64(20)},
+	}, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna)
+	mediciones, err := descomprimirBloqueSerie(comprimido, serieCacheTest)
+	require.NoError(t, err)
+
+	directorio := t.TempDir()
+	tamañoBloque := int64(len(comprimido)) + 2*tamañoEstimadoMedicion
+	cache, err := nuevaCacheBloques(OpcionesCacheBloques{
+		TamañoMemoria: 2 * tamañoBloque,
+		Directorio:    directorio,
+		TamañoDisco:   4 * int64(len(comprimido)),
+	})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				clave := fmt.Sprintf("bloque-%d", (g+i)%10)
+				if _, ok := cache.obtener(clave, "etag", serieCacheTest); !ok {
+					cache.guardar(clave, "etag", comprimido, mediciones)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	stats := cache.estadisticas()
+	assert.LessOrEqual(t, stats.BytesMemoria, 2*tamañoBloque)
+	assert.LessOrEqual(t, stats.BytesDisco, 4*int64(len(comprimido)))
+	archivos, _ := filepath.Glob(filepath.Join(directorio, "*"+extensionBloqueCache))
+	assert.LessOrEqual(t, len(archivos), stats.EntradasDisco, "sin archivos huérfanos ni temporales")
+	t.Log("El desalojo a disco concurrente mantiene el índice y los archivos consistentes")
+}
+
+// TestCacheBloques_Deshabilitada verifica que un tamaño negativo deshabilita la cache
+func TestCacheBloques_Deshabilitada(t *testing.T) {
+	cache, err := nuevaCacheBloques(OpcionesCacheBloques{TamañoMemoria: -1})
+	require.NoError(t, err)
+	assert.Nil(t, cache)
+
+	mockS3 := nuevoS3Test(t)
+	m := &ManagerDespachador{almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket")}
+	req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	w := httptest.NewRecorder()
+	HandlerStatus(m)(w, req)
+	assert.NotContains(t, w.Body.String(), "cache_bloques")
+	t.Log("Un tamaño negativo deshabilita la cache de bloques")
+}
+
+// ============================================================================
+// TESTS DE CACHE DE RESULTADOS
+// ============================================================================
+
+// prepararCacheResultadosTest crea un manager con un bloque migrado a S3 (tiempos 1000 a 3000,
+// con manifiesto) y un edge que retorna la cola reciente (tiempos 4000 y 5000)
+func prepararCacheResultadosTest(t *testing.T, opts OpcionesCacheResultados) (*ManagerDespachador, *s3fake.Cliente, *mockClienteEdge) {
+	mockS3 := nuevoS3Test(t)
+	medicionesS3 := []tipos.Medicion{
+		{Tiempo: 1000, Valor: int64(10)},
+		{Tiempo: 2000, Valor: int64(20)},
+		{Tiempo: 3000, Valor: int64(30)},
+	}
+	clave := tipos.GenerarClaveS3Datos("nodo1", 1, 1000, 3000)
+	guardarObjetoTest(t, mockS3, clave, crearBloqueComprimidoTest(t, medicionesS3, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna))
+	manifiesto := &tipos.ManifiestoSerie{NodoID: "nodo1", SerieId: 1, Path: "/sensores/temp"}
+	manifiesto.AgregarBloques(tipos.NuevoBloqueManifiesto(clave, 1000, 3000, int64(len(objetoTest(t, mockS3, clave))), medicionesS3))
+	require.NoError(t, tipos.GuardarManifiesto(context.Background(), tipos.NuevoAlmacenamientoS3(mockS3, bucketTest), manifiesto))
+
+	serie := serieCacheTest
+	serie.Path = "/sensores/temp"
+	m, mockEdge := crearManagerTest(t, mockS3, Opciones{
+		CacheBloques:    OpcionesCacheBloques{TamañoMemoria: -1}, // Cada consulta histórica descarga el bloque
+		CacheResultados: opts,
+	}, &tipos.Nodo{NodoID: "nodo1", Series: map[string]tipos.Serie{"/sensores/temp": serie}})
+	mockEdge.respuestaRango = crearRespuestaRangoTabular("/sensores/temp", []tipos.Medicion{
+		{Tiempo: 4000, Valor: int64(40)},
+		{Tiempo: 5000, Valor: int64(50)},
+	})
+	return m, mockS3, mockEdge
+}
+
+// TestCacheResultados_AciertoDentroDelTTL verifica que una consulta repetida no consulta edge ni S3
+func TestCacheResultados_AciertoDentroDelTTL(t *testing.T) {
+	m, mockS3, mockEdge := prepararCacheResultadosTest(t, OpcionesCacheResultados{MaxEntradas: 10, TTL: time.Minute})
+	agregaciones := []tipos.TipoAgregacion{tipos.AgregacionPromedio, tipos.AgregacionCount}
+
+	for i := 0; i < 3; i++ {
+		resultado, err := m.ConsultarAgregacion("/sensores/temp", time.Unix(0, 0), time.Unix(0, 6000), agregaciones)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/sensores/temp"}, resultado.Series)
+		assert.Equal(t, 30.0, resultado.Valores[0][0]) // (10+20+30+40+50)/5
+		assert.Equal(t, 5.0, resultado.Valores[1][0])
+	}
+
+	assert.Equal(t, int32(1), mockEdge.llamadasRango.Load())
+	assert.Equal(t, 1, mockS3.LlamadasClave(s3fake.OperacionGetObject, tipos.GenerarClaveS3Datos("nodo1", 1, 1000, 3000)))
+	stats := m.ObtenerEstadisticas().CacheResultados
+	assert.Equal(t, int64(2), stats.Aciertos)
+	assert.Equal(t, int64(1), stats.Fallos)
+	assert.Equal(t, 1, stats.Entradas)
+	t.Log("La cache de resultados sirve consultas idénticas dentro del TTL")
+}
+
+// TestCacheResultados_SoloConsultaCola verifica que vencido el TTL solo se consulta la cola reciente
+func TestCacheResultados_SoloConsultaCola(t *testing.T) {
+	m, mockS3, mockEdge := prepararCacheResultadosTest(t, OpcionesCacheResultados{MaxEntradas: 10, TTL: time.Nanosecond})
+	agregaciones := []tipos.TipoAgregacion{tipos.AgregacionMinimo, tipos.AgregacionMaximo, tipos.AgregacionSuma}
+
+	primero, err := m.ConsultarAgregacion("/sensores/temp", time.Unix(0, 0), time.Unix(0, 6000), agregaciones)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	segundo, err := m.ConsultarAgregacion("/sensores/temp", time.Unix(0, 0), time.Unix(0, 6000), agregaciones)
+	require.NoError(t, err)
+
+	assert.Equal(t, primero, segundo)
+	assert.Equal(t, [][]float64{{10}, {50}, {150}}, segundo.Valores)
+	assert.Equal(t, int32(2), mockEdge.llamadasRango.Load(), "La cola se consulta de nuevo al edge")
+	assert.Equal(t, 1, mockS3.LlamadasClave(s3fake.OperacionGetObject, tipos.GenerarClaveS3Datos("nodo1", 1, 1000, 3000)), "El bloque histórico no se descarga de nuevo")
+	stats := m.ObtenerEstadisticas().CacheResultados
+	assert.Equal(t, int64(1), stats.Parciales)
+	assert.Equal(t, int64(1), stats.Fallos)
+	t.Log("Vencido el TTL solo se vuelve a consultar el tramo no migrado")
+}
+
+// TestCacheResultados_TemporalIgualSinCache verifica que la agregación temporal cacheada coincide con la directa
+func TestCacheResultados_TemporalIgualSinCache(t *testing.T) {
+	m, _, _ := prepararCacheResultadosTest(t, OpcionesCacheResultados{MaxEntradas: 10, TTL: time.Nanosecond})
+	sinCache, _, _ := prepararCacheResultadosTest(t, OpcionesCacheResultados{})
+	require.Nil(t, sinCache.cacheResultados)
+	agregaciones := []tipos.TipoAgregacion{tipos.AgregacionPromedio, tipos.AgregacionCount}
+
+	esperado, err := sinCache.ConsultarAgregacionTemporal("/sensores/temp", time.Unix(0, 1000), time.Unix(0, 5000), agregaciones, 2000)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		resultado, err := m.ConsultarAgregacionTemporal("/sensores/temp", time.Unix(0, 1000), time.Unix(0, 5000), agregaciones, 2000)
+		require.NoError(t, err)
+		assert.Equal(t, esperado, resultado)
+	}
+	assert.Equal(t, int64(1), m.ObtenerEstadisticas().CacheResultados.Parciales)
+	t.Log("La agregación temporal cacheada coincide con la calculada sin cache")
+}
+
+// TestCacheResultados_RangoRelativoAlineado verifica que consultas con distinto rango dentro
+// de los mismos buckets (como now-1h repetida) comparten la entrada de la cache
+func TestCacheResultados_RangoRelativoAlineado(t *testing.T) {
+	m, _, mockEdge := prepararCacheResultadosTest(t, OpcionesCacheResultados{MaxEntradas: 10, TTL: time.Minute})
+	agregaciones := []tipos.TipoAgregacion{tipos.AgregacionCount}
+
+	inicio, fin := AlinearRangoBuckets(time.Unix(0, 500), time.Unix(0, 4500), 2000)
+	primero, err := m.ConsultarAgregacionTemporal("/sensores/temp", inicio, fin, agregaciones, 2000)
+	require.NoError(t, err)
+	inicio, fin = AlinearRangoBuckets(time.Unix(0, 900), time.Unix(0, 4900), 2000)
+	segundo, err := m.ConsultarAgregacionTemporal("/sensores/temp", inicio, fin, agregaciones, 2000)
+	require.NoError(t, err)
+
+	assert.Equal(t, primero, segundo)
+	assert.Equal(t, []int64{0, 2000, 4000}, segundo.Tiempos)
+	assert.Equal(t, [][][]float64{{{1}, {2}, {2}}}, segundo.Valores)
+	assert.Equal(t, int32(1), mockEdge.llamadasRango.Load())
+	assert.Equal(t, int64(1), m.ObtenerEstadisticas().CacheResultados.Aciertos)
+
+	inicioNano, finNano := alinearBuckets(-500, 4000, 2000)
+	assert.Equal(t, int64(-2000), inicioNano)
+	assert.Equal(t, int64(4000), finNano, "un fin alineado no agrega un bucket")
+	t.Log("Los rangos alineados a los buckets reutilizan la cache en las consultas relativas")
+}
+
+// TestAgregacionTemporal_InicioNoAlineado verifica que sin alinear el rango los buckets
+// comienzan en tiempoInicio y no incluyen datos fuera del rango, con y sin cache
+func TestAgregacionTemporal_InicioNoAlineado(t *testing.T) {
+	agregaciones := []tipos.TipoAgregacion{tipos.AgregacionCount}
+	for _, opts := range []OpcionesCacheResultados{{}, {MaxEntradas: 10, TTL: time.Minute}} {
+		m, _, _ := prepararCacheResultadosTest(t, opts)
+		require.Equal(t, opts.MaxEntradas > 0, m.cacheResultados != nil)
+
+		// Datos en 1000..5000: 1000 y 5000 quedan fuera del rango
+		resultado, err := m.ConsultarAgregacionTemporal("/sensores/temp", time.Unix(0, 1500), time.Unix(0, 4500), agregaciones, 2000)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1500, 3500}, resultado.Tiempos, "cache: %v", opts.MaxEntradas > 0)
+		assert.Equal(t, [][][]float64{{{2}, {1}}}, resultado.Valores, "cache: %v", opts.MaxEntradas > 0)
+	}
+	t.Log("Sin alinear, los buckets comienzan en el inicio pedido como en el edge")
+}
+
+// TestCacheResultados_InvalidaAlCambiarSeries verifica que un cambio en las series resueltas descarta la entrada
+func TestCacheResultados_InvalidaAlCambiarSeries(t *testing.T) {
+	m, _, _ := prepararCacheResultadosTest(t, OpcionesCacheResultados{MaxEntradas: 10, TTL: time.Minute})
+	agregaciones := []tipos.TipoAgregacion{tipos.AgregacionCount}
+
+	_, err := m.ConsultarAgregacion("/sensores/*", time.Unix(0, 0), time.Unix(0, 6000), agregaciones)
+	require.NoError(t, err)
+
+	// Registrar una nueva serie que coincide con el patrón
+	m.mu.Lock()
+	m.nodos["nodo1"].Series["/sensores/hum"] = tipos.Serie{SerieId: 2, Path: "/sensores/hum"}
+	m.mu.Unlock()
+
+	_, err = m.ConsultarAgregacion("/sensores/*", time.Unix(0, 0), time.Unix(0, 6000), agregaciones)
+	require.NoError(t, err)
+
+	stats := m.ObtenerEstadisticas().CacheResultados
+	assert.Equal(t, int64(0), stats.Aciertos)
+	assert.Equal(t, int64(2), stats.Fallos)
+	t.Log("La entrada se descarta cuando cambia el conjunto de series")
+}
+
+// TestCacheResultados_NoCacheaHistoricoParcial verifica que un bloque de S3 con error no queda en la porción histórica
+func TestCacheResultados_NoCacheaHistoricoParcial(t *testing.T) {
+	m, mockS3, _ := prepararCacheResultadosTest(t, OpcionesCacheResultados{MaxEntradas: 10, TTL: time.Nanosecond})
+
+	// Agregar al manifiesto un bloque inexistente en el bucket
+	manifiesto, err := tipos.LeerManifiesto(context.Background(), tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"), "nodo1", 1)
+	require.NoError(t, err)
+	manifiesto.AgregarBloques(tipos.BloqueManifiesto{Clave: tipos.GenerarClaveS3Datos("nodo1", 1, 3500, 3600), TiempoInicio: 3500, TiempoFin: 3600})
+	require.NoError(t, tipos.GuardarManifiesto(context.Background(), tipos.NuevoAlmacenamientoS3(mockS3, bucketTest), manifiesto))
+
+	agregaciones := []tipos.TipoAgregacion{tipos.AgregacionCount}
+	for i := 0; i < 2; i++ {
+		_, err := m.ConsultarAgregacion("/sensores/temp", time.Unix(0, 0), time.Unix(0, 6000), agregaciones)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, 2, mockS3.LlamadasClave(s3fake.OperacionGetObject, tipos.GenerarClaveS3Datos("nodo1", 1, 1000, 3000)), "Sin porción histórica se consulta S3 de nuevo")
+	t.Log("Los resultados parciales de S3 no se cachean como históricos")
+}
+
 // ============================================================================
 // TESTS DE CONSULTAR DATOS S3 CON BLOQUES VALIDOS
 // ============================================================================
@@ -1564,18 +3084,11 @@ func TestConsultarDatosS3_ConBloquesValidos(t *testing.T) {
 
 	bloqueComprimido := crearBloqueComprimidoTest(t, mediciones, tipos.Integer, tipos.DeltaDelta, tipos.Ninguna)
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodo1/0000000001_00000000000000001000_00000000000000004000")},
-			},
-		},
-		getObjectData: bloqueComprimido,
-	}
+	mockS3 := nuevoS3Test(t)
+	guardarObjetoTest(t, mockS3, "nodo1/0000000001_00000000000000001000_00000000000000004000", bloqueComprimido)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	nodo := tipos.Nodo{NodoID: "nodo1"}
@@ -1615,14 +3128,8 @@ func TestConsultarUltimoPunto_DesdeS3(t *testing.T) {
 		respuestaPunto: crearRespuestaPuntoVacia(),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodo1/0000000001_00000000000000001000_00000000000000003000")},
-			},
-		},
-		getObjectData: bloqueComprimido,
-	}
+	mockS3 := nuevoS3Test(t)
+	guardarObjetoTest(t, mockS3, "nodo1/0000000001_00000000000000001000_00000000000000003000", bloqueComprimido)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -1641,9 +3148,8 @@ func TestConsultarUltimoPunto_DesdeS3(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	resultado, err := m.ConsultarUltimoPunto("/sensores/temp", nil, nil)
@@ -1663,7 +3169,7 @@ func TestConsultarUltimoPunto_DesdeS3(t *testing.T) {
 
 // TestNuevoClienteEdgeHTTP verifica creacion del cliente
 func TestNuevoClienteEdgeHTTP(t *testing.T) {
-	cliente := nuevoClienteEdgeHTTP()
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{}, nil)
 
 	assert.NotNil(t, cliente)
 	assert.NotNil(t, cliente.httpClient)
@@ -1677,16 +3183,11 @@ func TestNuevoClienteEdgeHTTP(t *testing.T) {
 
 // TestCargarNodosDesdeS3_SinNodos verifica carga cuando no hay nodos
 func TestCargarNodosDesdeS3_SinNodos(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
-		nodos:  make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+		nodos:          make(map[string]*tipos.Nodo),
 	}
 
 	err := m.cargarNodosDesdeS3()
@@ -1709,19 +3210,12 @@ func TestCargarNodosDesdeS3_ConNodos(t *testing.T) {
 	}
 	nodoJSON, _ := json.Marshal(nodo)
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodos/nodo-test.json")},
-			},
-		},
-		getObjectData: nodoJSON,
-	}
+	mockS3 := nuevoS3Test(t)
+	guardarObjetoTest(t, mockS3, "nodos/nodo-test.json", nodoJSON)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
-		nodos:  make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+		nodos:          make(map[string]*tipos.Nodo),
 	}
 
 	err := m.cargarNodosDesdeS3()
@@ -1735,14 +3229,12 @@ func TestCargarNodosDesdeS3_ConNodos(t *testing.T) {
 
 // TestCargarNodosDesdeS3_ErrorListando verifica manejo de error al listar
 func TestCargarNodosDesdeS3_ErrorListando(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsErr: assert.AnError,
-	}
+	mockS3 := nuevoS3Test(t)
+	fallarS3Test(mockS3, s3fake.OperacionListObjectsV2)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
-		nodos:  make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+		nodos:          make(map[string]*tipos.Nodo),
 	}
 
 	err := m.cargarNodosDesdeS3()
@@ -1754,19 +3246,12 @@ func TestCargarNodosDesdeS3_ErrorListando(t *testing.T) {
 
 // TestCargarNodosDesdeS3_ErrorGetObject verifica que continua si falla un GetObject
 func TestCargarNodosDesdeS3_ErrorGetObject(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodos/nodo-test.json")},
-			},
-		},
-		getObjectErr: assert.AnError,
-	}
+	mockS3 := nuevoS3Test(t, "nodos/nodo-test.json")
+	fallarS3Test(mockS3, s3fake.OperacionGetObject)
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
-		nodos:  make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+		nodos:          make(map[string]*tipos.Nodo),
 	}
 
 	err := m.cargarNodosDesdeS3()
@@ -1779,19 +3264,12 @@ func TestCargarNodosDesdeS3_ErrorGetObject(t *testing.T) {
 
 // TestCargarNodosDesdeS3_JSONInvalido verifica que continua con JSON invalido
 func TestCargarNodosDesdeS3_JSONInvalido(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodos/nodo-invalido.json")},
-			},
-		},
-		getObjectData: []byte("esto no es JSON valido"),
-	}
+	mockS3 := nuevoS3Test(t)
+	guardarObjetoTest(t, mockS3, "nodos/nodo-invalido.json", []byte("esto no es JSON valido"))
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
-		nodos:  make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+		nodos:          make(map[string]*tipos.Nodo),
 	}
 
 	err := m.cargarNodosDesdeS3()
@@ -1804,34 +3282,24 @@ func TestCargarNodosDesdeS3_JSONInvalido(t *testing.T) {
 
 // TestCargarNodosDesdeS3_MultiplesNodos verifica carga de multiples nodos
 func TestCargarNodosDesdeS3_MultiplesNodos(t *testing.T) {
-	// Para este test necesitamos un mock mas sofisticado que retorne
-	// diferentes datos segun la clave solicitada
-	nodo1 := tipos.Nodo{NodoID: "nodo1", Direccion: "192.168.1.1"}
-	nodo1JSON, _ := json.Marshal(nodo1)
-
-	// Usamos un contador para alternar respuestas
-	callCount := 0
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodos/nodo1.json")},
-			},
-		},
+	mockS3 := nuevoS3Test(t)
+	for _, nodo := range []tipos.Nodo{
+		{NodoID: "nodo1", Direccion: "192.168.1.1"},
+		{NodoID: "nodo2", Direccion: "192.168.1.2"},
+	} {
+		guardarJSONTest(t, mockS3, "nodos/"+nodo.NodoID+".json", nodo)
 	}
-	// Configurar datos del primer nodo
-	mockS3.getObjectData = nodo1JSON
 
 	m := &ManagerDespachador{
-		s3:     mockS3,
-		config: tipos.ConfiguracionS3{Bucket: "test-bucket"},
-		nodos:  make(map[string]*tipos.Nodo),
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
+		nodos:          make(map[string]*tipos.Nodo),
 	}
 
 	err := m.cargarNodosDesdeS3()
 
 	assert.NoError(t, err)
-	assert.Len(t, m.nodos, 1)
-	_ = callCount // evitar warning
+	assert.Len(t, m.nodos, 2)
+	assert.Equal(t, "192.168.1.2", m.nodos["nodo2"].Direccion)
 	t.Log("cargarNodosDesdeS3 carga multiples nodos")
 }
 
@@ -1841,12 +3309,7 @@ func TestCargarNodosDesdeS3_MultiplesNodos(t *testing.T) {
 
 // TestCrear_ConClienteS3Inyectado verifica creacion con cliente S3 mock
 func TestCrear_ConClienteS3Inyectado(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		headBucketOutput: &s3.HeadBucketOutput{},
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	mockEdge := &mockClienteEdge{}
 
@@ -1867,7 +3330,7 @@ func TestCrear_ConClienteS3Inyectado(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.NotNil(t, manager)
-	assert.NotNil(t, manager.s3)
+	assert.NotNil(t, manager.almacenamiento)
 	assert.NotNil(t, manager.clienteEdge)
 
 	// Cerrar para limpiar goroutine
@@ -1877,13 +3340,7 @@ func TestCrear_ConClienteS3Inyectado(t *testing.T) {
 
 // TestCrear_BucketNoExiste_SeCreaNuevo verifica creacion de bucket
 func TestCrear_BucketNoExiste_SeCreaNuevo(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		headBucketErr:      assert.AnError, // Bucket no existe
-		createBucketOutput: &s3.CreateBucketOutput{},
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := s3fake.Nuevo(s3fake.Opciones{}) // Bucket no existe
 
 	opts := opcionesInternas{
 		Opciones: Opciones{
@@ -1902,6 +3359,7 @@ func TestCrear_BucketNoExiste_SeCreaNuevo(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.NotNil(t, manager)
+	assert.Equal(t, 1, mockS3.Llamadas(s3fake.OperacionCreateBucket))
 
 	manager.Cerrar()
 	t.Log("Crear crea bucket si no existe")
@@ -1909,10 +3367,8 @@ func TestCrear_BucketNoExiste_SeCreaNuevo(t *testing.T) {
 
 // TestCrear_ErrorCreandoBucket verifica error al crear bucket
 func TestCrear_ErrorCreandoBucket(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		headBucketErr:   assert.AnError, // Bucket no existe
-		createBucketErr: assert.AnError, // Error al crearlo
-	}
+	mockS3 := s3fake.Nuevo(s3fake.Opciones{}) // Bucket no existe
+	fallarS3Test(mockS3, s3fake.OperacionCreateBucket)
 
 	opts := opcionesInternas{
 		Opciones: Opciones{
@@ -1963,15 +3419,8 @@ func TestCrear_ConNodosExistentes(t *testing.T) {
 	}
 	nodoJSON, _ := json.Marshal(nodo)
 
-	mockS3 := &mockClienteS3{
-		headBucketOutput: &s3.HeadBucketOutput{},
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: aws.String("nodos/nodo-existente.json")},
-			},
-		},
-		getObjectData: nodoJSON,
-	}
+	mockS3 := nuevoS3Test(t)
+	guardarObjetoTest(t, mockS3, "nodos/nodo-existente.json", nodoJSON)
 
 	opts := opcionesInternas{
 		Opciones: Opciones{
@@ -1999,12 +3448,7 @@ func TestCrear_ConNodosExistentes(t *testing.T) {
 
 // TestCrear_SinClienteEdge_UsaHTTP verifica que crea cliente HTTP por defecto
 func TestCrear_SinClienteEdge_UsaHTTP(t *testing.T) {
-	mockS3 := &mockClienteS3{
-		headBucketOutput: &s3.HeadBucketOutput{},
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	opts := opcionesInternas{
 		Opciones: Opciones{
@@ -2061,11 +3505,7 @@ func TestConsultarAgregacion_Promedio(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", medicionesEdge),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2078,9 +3518,8 @@ func TestConsultarAgregacion_Promedio(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -2107,11 +3546,7 @@ func TestConsultarAgregacion_Maximo(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", medicionesEdge),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2124,9 +3559,8 @@ func TestConsultarAgregacion_Maximo(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -2152,11 +3586,7 @@ func TestConsultarAgregacion_Minimo(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", medicionesEdge),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2169,9 +3599,8 @@ func TestConsultarAgregacion_Minimo(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -2197,11 +3626,7 @@ func TestConsultarAgregacion_Suma(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", medicionesEdge),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2214,9 +3639,8 @@ func TestConsultarAgregacion_Suma(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -2242,11 +3666,7 @@ func TestConsultarAgregacion_Count(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", medicionesEdge),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2259,9 +3679,8 @@ func TestConsultarAgregacion_Count(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -2281,11 +3700,7 @@ func TestConsultarAgregacion_SinDatos(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", []tipos.Medicion{}),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2298,9 +3713,8 @@ func TestConsultarAgregacion_SinDatos(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -2325,11 +3739,7 @@ func TestConsultarAgregacion_ConInt64(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", medicionesEdge),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2342,9 +3752,8 @@ func TestConsultarAgregacion_ConInt64(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -2414,11 +3823,7 @@ func TestConsultarAgregacionTemporal_MultipleBuckets(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", medicionesEdge),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2431,9 +3836,8 @@ func TestConsultarAgregacionTemporal_MultipleBuckets(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 0)
@@ -2464,11 +3868,7 @@ func TestConsultarAgregacionTemporal_SinDatos(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", []tipos.Medicion{}),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2481,9 +3881,8 @@ func TestConsultarAgregacionTemporal_SinDatos(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 0)
@@ -2509,11 +3908,7 @@ func TestConsultarAgregacionTemporal_OrdenCronologico(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", medicionesEdge),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2526,9 +3921,8 @@ func TestConsultarAgregacionTemporal_OrdenCronologico(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 0)
@@ -2750,11 +4144,7 @@ func TestConsultarAgregacion_Wildcard_MultiplesSeries(t *testing.T) {
 		},
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2768,9 +4158,8 @@ func TestConsultarAgregacion_Wildcard_MultiplesSeries(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 500)
@@ -2827,11 +4216,7 @@ func TestConsultarAgregacion_Wildcard_Suma(t *testing.T) {
 		},
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2846,9 +4231,8 @@ func TestConsultarAgregacion_Wildcard_Suma(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 0)
@@ -2879,11 +4263,7 @@ func TestConsultarAgregacion_Wildcard_Count(t *testing.T) {
 		},
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2897,9 +4277,8 @@ func TestConsultarAgregacion_Wildcard_Count(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 0)
@@ -2931,11 +4310,7 @@ func TestConsultarAgregacionTemporal_Wildcard(t *testing.T) {
 		},
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -2949,9 +4324,8 @@ func TestConsultarAgregacionTemporal_Wildcard(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 0)
@@ -3007,11 +4381,7 @@ func TestConsultarAgregacionTemporal_Wildcard_MultipleBuckets(t *testing.T) {
 		},
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -3025,9 +4395,8 @@ func TestConsultarAgregacionTemporal_Wildcard_MultipleBuckets(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	inicio := time.Unix(0, 0)
@@ -3071,11 +4440,7 @@ func TestConsultarAgregacion_MultiplesAgregaciones_MinMax(t *testing.T) {
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", mediciones),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -3088,9 +4453,8 @@ func TestConsultarAgregacion_MultiplesAgregaciones_MinMax(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	resultado, err := m.ConsultarAgregacion(
@@ -3125,11 +4489,7 @@ func TestConsultarAgregacion_MultiplesAgregaciones_TodasLasAgregaciones(t *testi
 		respuestaRango: crearRespuestaRangoTabular("/sensores/temp", mediciones),
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -3142,9 +4502,8 @@ func TestConsultarAgregacion_MultiplesAgregaciones_TodasLasAgregaciones(t *testi
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	resultado, err := m.ConsultarAgregacion(
@@ -3219,11 +4578,7 @@ func TestConsultarAgregacion_MultiplesAgregaciones_Wildcard(t *testing.T) {
 		},
 	}
 
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{},
-		},
-	}
+	mockS3 := nuevoS3Test(t)
 
 	m := &ManagerDespachador{
 		nodos: map[string]*tipos.Nodo{
@@ -3237,9 +4592,8 @@ func TestConsultarAgregacion_MultiplesAgregaciones_Wildcard(t *testing.T) {
 				},
 			},
 		},
-		clienteEdge: mockEdge,
-		s3:          mockS3,
-		config:      tipos.ConfiguracionS3{Bucket: "test-bucket"},
+		clienteEdge:    mockEdge,
+		almacenamiento: tipos.NuevoAlmacenamientoS3(mockS3, "test-bucket"),
 	}
 
 	resultado, err := m.ConsultarAgregacion(
@@ -3262,3 +4616,750 @@ func TestConsultarAgregacion_MultiplesAgregaciones_Wildcard(t *testing.T) {
 
 	t.Log("ConsultarAgregacion con múltiples agregaciones y wildcard funciona correctamente")
 }
+
+// ============================================================================
+// TESTS DE FUNCIONES DE VENTANA
+// ============================================================================
+
+// crearManagerVentanaTest crea un manager con un nodo que responde valores 10, 20, 40
+func crearManagerVentanaTest(t *testing.T) *ManagerDespachador {
+	m, mockEdge := crearManagerTest(t, nuevoS3Test(t), Opciones{}, &tipos.Nodo{
+		NodoID:     "nodo1",
+		Direccion:  "192.168.1.100",
+		PuertoHTTP: "8080",
+		Series: map[string]tipos.Serie{
+			"/sensores/temp": {SerieId: 1, Path: "/sensores/temp"},
+		},
+	})
+	mockEdge.respuestaRango = &tipos.RespuestaConsultaRango{
+		Resultado: tipos.ResultadoConsultaRango{
+			Series:  []string{"/sensores/temp"},
+			Tiempos: []int64{1000, 2000, 3000},
+			Valores: [][]interface{}{{10.0}, {20.0}, {40.0}},
+		},
+	}
+	return m
+}
+
+// TestConsultarRangoConVentana_MediaMovil verifica la media móvil sobre el resultado combinado
+func TestConsultarRangoConVentana_MediaMovil(t *testing.T) {
+	m := crearManagerVentanaTest(t)
+
+	resultado, err := m.ConsultarRangoConVentana("/sensores/temp", time.Unix(0, 500), time.Unix(0, 4000),
+		tipos.FuncionVentana{Tipo: tipos.VentanaMediaMovil, Puntos: 2})
+
+	require.NoError(t, err)
+	require.Len(t, resultado.Tiempos, 3)
+	assert.Nil(t, resultado.Valores[0][0])
+	assert.Equal(t, 15.0, resultado.Valores[1][0])
+	assert.Equal(t, 30.0, resultado.Valores[2][0])
+	t.Log("ConsultarRangoConVentana aplica la media móvil correctamente")
+}
+
+// TestConsultarAgregacionTemporalConVentana_EWMA verifica EWMA sobre buckets
+func TestConsultarAgregacionTemporalConVentana_EWMA(t *testing.T) {
+	m := crearManagerVentanaTest(t)
+
+	resultado, err := m.ConsultarAgregacionTemporalConVentana("/sensores/temp", time.Unix(0, 1000), time.Unix(0, 4000),
+		[]tipos.TipoAgregacion{tipos.AgregacionPromedio}, 1000*time.Nanosecond,
+		tipos.FuncionVentana{Tipo: tipos.VentanaEWMA, Alfa: 0.5})
+
+	require.NoError(t, err)
+	require.Len(t, resultado.Tiempos, 3)
+	assert.Equal(t, 10.0, resultado.Valores[0][0][0])
+	assert.Equal(t, 15.0, resultado.Valores[0][1][0])
+	assert.Equal(t, 27.5, resultado.Valores[0][2][0])
+	t.Log("ConsultarAgregacionTemporalConVentana aplica EWMA correctamente")
+}
+
+// TestHandlerConsultarRango_VentanaInvalida verifica que el handler rechaza ventanas inválidas
+func TestHandlerConsultarRango_VentanaInvalida(t *testing.T) {
+	m := crearManagerVentanaTest(t)
+
+	body := `{"serie": "/sensores/temp", "tiempo_inicio": 500, "tiempo_fin": 4000, "ventana": {"tipo": "ewma"}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/consulta/rango", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	HandlerConsultarRango(m)(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	t.Log("HandlerConsultarRango rechaza funciones de ventana inválidas")
+}
+
+// ============================================================================
+// TESTS DE COMPARACIÓN ENTRE PERÍODOS
+// ============================================================================
+
+// TestConsultarComparacionPeriodos_Solapados verifica la agregación de períodos solapados
+func TestConsultarComparacionPeriodos_Solapados(t *testing.T) {
+	m := crearManagerVentanaTest(t)
+
+	// Datos: 1000→10, 2000→20, 3000→40
+	resultado, err := m.ConsultarComparacionPeriodos("/sensores/temp",
+		time.Unix(0, 2000), time.Unix(0, 4000),
+		[]tipos.TipoAgregacion{tipos.AgregacionPromedio}, 1000*time.Nanosecond, 1000*time.Nanosecond)
+
+	require.NoError(t, err)
+	assert.Equal(t, []int64{0, 1000}, resultado.TiemposRelativos)
+
+	// Actual: [2000,3000)=20, [3000,4000]=40
+	assert.Equal(t, []int64{2000, 3000}, resultado.Actual.Tiempos)
+	assert.Equal(t, 20.0, resultado.Actual.Valores[0][0][0])
+	assert.Equal(t, 40.0, resultado.Actual.Valores[0][1][0])
+
+	// Anterior: [1000,2000)=10, [2000,3000]=(20+40)/2
+	assert.Equal(t, []int64{1000, 2000}, resultado.Anterior.Tiempos)
+	assert.Equal(t, 10.0, resultado.Anterior.Valores[0][0][0])
+	assert.Equal(t, 30.0, resultado.Anterior.Valores[0][1][0])
+	t.Log("ConsultarComparacionPeriodos agrega cada período sobre sus buckets")
+}
+
+// TestConsultarComparacionPeriodos_Disjuntos verifica períodos sin solapamiento y sin datos previos
+func TestConsultarComparacionPeriodos_Disjuntos(t *testing.T) {
+	m := crearManagerVentanaTest(t)
+	mockEdge := m.clienteEdge.(*mockClienteEdge)
+
+	resultado, err := m.ConsultarComparacionPeriodos("/sensores/temp",
+		time.Unix(0, 1000), time.Unix(0, 3000),
+		[]tipos.TipoAgregacion{tipos.AgregacionMaximo}, 1000*time.Nanosecond, 10000*time.Nanosecond)
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), mockEdge.llamadasRango.Load())
+	assert.Len(t, resultado.Actual.Tiempos, 2)
+	assert.Len(t, resultado.Anterior.Tiempos, 2)
+	assert.Equal(t, []string{"/sensores/temp"}, resultado.Actual.Series)
+	assert.Equal(t, resultado.Actual.Series, resultado.Anterior.Series, "misma lista de series en ambos períodos")
+	for _, valor := range resultado.Anterior.Valores[0] {
+		assert.True(t, math.IsNaN(valor[0]), "el período anterior no tiene datos")
+	}
+	assert.Equal(t, 10000*time.Nanosecond, resultado.Desplazamiento)
+	t.Log("ConsultarComparacionPeriodos completa con NaN las series sin datos en un período")
+}
+
+// TestConsultarComparacionPeriodos_UsaCacheResultados verifica que la comparación reutiliza
+// la cache de resultados de la agregación temporal
+func TestConsultarComparacionPeriodos_UsaCacheResultados(t *testing.T) {
+	m, _, mockEdge := prepararCacheResultadosTest(t, OpcionesCacheResultados{MaxEntradas: 10, TTL: time.Minute})
+	agregaciones := []tipos.TipoAgregacion{tipos.AgregacionCount}
+
+	_, err := m.ConsultarAgregacionTemporal("/sensores/temp", time.Unix(0, 4000), time.Unix(0, 6000), agregaciones, 2000)
+	require.NoError(t, err)
+	llamadas := mockEdge.llamadasRango.Load()
+
+	for i := 0; i < 2; i++ {
+		resultado, err := m.ConsultarComparacionPeriodos("/sensores/temp", time.Unix(0, 4000), time.Unix(0, 6000),
+			agregaciones, 2000, 2000)
+		require.NoError(t, err)
+		assert.Equal(t, [][][]float64{{{2}}}, resultado.Actual.Valores)
+		assert.Equal(t, [][][]float64{{{3}}}, resultado.Anterior.Valores) // 2000, 3000 y 4000 (fin inclusive)
+	}
+
+	assert.Equal(t, llamadas+1, mockEdge.llamadasRango.Load(), "solo el período anterior se consulta una vez")
+	assert.Equal(t, int64(3), m.ObtenerEstadisticas().CacheResultados.Aciertos)
+	t.Log("ConsultarComparacionPeriodos reutiliza la cache de resultados")
+}
+
+// TestConsultarComparacionPeriodos_Validaciones verifica los parámetros requeridos
+func TestConsultarComparacionPeriodos_Validaciones(t *testing.T) {
+	m := crearManagerVentanaTest(t)
+	aggs := []tipos.TipoAgregacion{tipos.AgregacionPromedio}
+
+	_, err := m.ConsultarComparacionPeriodos("/sensores/temp", time.Unix(0, 1000), time.Unix(0, 3000), aggs, time.Second, 0)
+	assert.Error(t, err)
+
+	_, err = m.ConsultarComparacionPeriodos("/sensores/temp", time.Unix(0, 3000), time.Unix(0, 1000), aggs, time.Second, time.Hour)
+	assert.Error(t, err)
+
+	_, err = m.ConsultarComparacionPeriodos("/sensores/temp", time.Unix(0, 1000), time.Unix(0, 3000), nil, time.Second, time.Hour)
+	assert.Error(t, err)
+
+	_, err = m.ConsultarComparacionPeriodos("/sensores/temp", time.Unix(0, 1000), time.Unix(0, 3000), aggs, time.Hour, 90*time.Minute)
+	assert.Error(t, err, "desplazamiento no múltiplo del intervalo")
+	t.Log("ConsultarComparacionPeriodos valida sus parámetros")
+}
+
+// ============================================================================
+// TESTS DE TIEMPOS LEGIBLES EN LA API REST
+// ============================================================================
+
+// TestHandlerConsultarAgregacionTemporal_TiemposLegibles verifica RFC3339 e intervalos legibles
+func TestHandlerConsultarAgregacionTemporal_TiemposLegibles(t *testing.T) {
+	m := crearManagerVentanaTest(t)
+
+	// Datos en 1000, 2000 y 3000 ns desde epoch: un único bucket de 1 minuto
+	body := `{"serie": "/sensores/temp", "tiempo_inicio": "1970-01-01T00:00:00Z", "tiempo_fin": "1970-01-01T00:01:00Z", "agregaciones": ["maximo"], "intervalo": "1m"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/consulta/agregacion-temporal", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	HandlerConsultarAgregacionTemporal(m)(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var respuesta ConsultaAgregacionTemporalResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &respuesta))
+	require.Len(t, respuesta.Tiempos, 1)
+	assert.Equal(t, FloatNulo(40.0), respuesta.Valores[0][0][0])
+	t.Log("HandlerConsultarAgregacionTemporal acepta RFC3339 e intervalos legibles")
+}
+
+// TestHandlerConsultarRango_TiempoInvalido verifica el rechazo de expresiones de tiempo inválidas
+func TestHandlerConsultarRango_TiempoInvalido(t *testing.T) {
+	m := crearManagerVentanaTest(t)
+
+	body := `{"serie": "/sensores/temp", "tiempo_inicio": "ayer", "tiempo_fin": "now"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/consulta/rango", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	HandlerConsultarRango(m)(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	t.Log("HandlerConsultarRango rechaza tiempos inválidos")
+}
+
+// ============================================================================
+// TESTS DE COMANDOS A LOS NODOS
+// ============================================================================
+
+// reglaComandoTest es una regla válida para los comandos de prueba
+func reglaComandoTest(id string) tipos.Regla {
+	return tipos.Regla{
+		ID:          id,
+		Nombre:      "Temperatura alta",
+		Activa:      true,
+		Condiciones: []tipos.Condicion{{Path: "planta1/temp", VentanaT: "5m", Operador: ">", Valor: 30.0}},
+		Acciones:    []tipos.Accion{{Tipo: "log", Destino: "alertas"}},
+	}
+}
+
+// comandosEncoladosTest retorna los comandos encolados en S3 para un nodo, verificando
+// que estén firmados con el secreto de opcionesAutorizacionTest
+func comandosEncoladosTest(t *testing.T, mockS3 *s3fake.Cliente, nodoID string) []tipos.Comando {
+	t.Helper()
+	var comandos []tipos.Comando
+	for _, clave := range mockS3.Claves(bucketTest, tipos.GenerarPrefijoS3Comandos(nodoID)) {
+		var encolado tipos.ComandoEncolado
+		require.NoError(t, json.Unmarshal(objetoTest(t, mockS3, clave), &encolado))
+		comando, err := encolado.Verificar(nodoID, []byte("secreto"))
+		require.NoError(t, err)
+		assert.Equal(t, tipos.GenerarClaveS3Comando(nodoID, comando.ID), clave)
+		comandos = append(comandos, comando)
+	}
+	return comandos
+}
+
+// TestEnviarComando_Directo verifica la entrega directa y su reflejo en el registro
+func TestEnviarComando_Directo(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	m, mockEdge := crearManagerTest(t, mockS3, opcionesAutorizacionTest, nodosZonasTest()...)
+	regla := reglaComandoTest("regla3")
+	mockEdge.resultadoComando = &tipos.ResultadoComando{Regla: &regla}
+
+	envio, err := m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoCrearRegla, Regla: &regla})
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoAplicado, envio.Resultado.Estado)
+	assert.NotEmpty(t, envio.Comando.ID)
+	assert.NotZero(t, envio.Comando.Creado)
+	require.Len(t, mockEdge.comandos, 1)
+	assert.Equal(t, envio.Comando.ID, mockEdge.comandos[0].ID)
+	assert.Empty(t, comandosEncoladosTest(t, mockS3, "nodo1"))
+
+	// El registro en memoria refleja la regla sin esperar la sincronización
+	reglas := m.ListarReglasPorNodo("nodo1")
+	require.Len(t, reglas, 2)
+	_, nodoID, existe := m.ObtenerRegla("regla3")
+	assert.True(t, existe)
+	assert.Equal(t, "nodo1", nodoID)
+
+	// Eliminar quita la regla del registro
+	mockEdge.resultadoComando = nil
+	envio, err = m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoEliminarRegla, ReglaID: "regla1"})
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoAplicado, envio.Resultado.Estado)
+	_, _, existe = m.ObtenerRegla("regla1")
+	assert.False(t, existe)
+	t.Log("Los comandos a nodos accesibles se aplican directamente y se reflejan en el registro")
+}
+
+// TestEnviarComando_Encolado verifica que los comandos a nodos inaccesibles se encolan en S3
+func TestEnviarComando_Encolado(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	m, mockEdge := crearManagerTest(t, mockS3, opcionesAutorizacionTest, nodosZonasTest()...)
+	mockEdge.errComando = errors.New("connection refused")
+
+	envio, err := m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoDeshabilitarRegla, ReglaID: "regla1"})
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoPendiente, envio.Resultado.Estado)
+	assert.Contains(t, envio.Resultado.Motivo, "connection refused")
+	encolados := comandosEncoladosTest(t, mockS3, "nodo1")
+	require.Len(t, encolados, 1)
+	assert.Equal(t, envio.Comando, encolados[0])
+
+	// La regla no cambia en el registro hasta que el nodo aplique el comando
+	regla, _, _ := m.ObtenerRegla("regla1")
+	assert.True(t, regla.Activa)
+
+	// Un nodo desconectado no se contacta
+	m.nodos["nodo2"].UltimaConexion = time.Now().Add(-time.Hour).UnixNano()
+	envio, err = m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo2", Tipo: tipos.ComandoEliminarRegla, ReglaID: "regla2"})
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoPendiente, envio.Resultado.Estado)
+	assert.Len(t, mockEdge.comandos, 1)
+	assert.Len(t, comandosEncoladosTest(t, mockS3, "nodo2"), 1)
+	t.Log("Los comandos a nodos inaccesibles quedan pendientes en la cola S3 del nodo")
+}
+
+// TestEnviarComando_SinSecretoNoEncola verifica que sin secreto para firmarlo un comando
+// a un nodo inaccesible no se encola: el nodo lo descartaría
+func TestEnviarComando_SinSecretoNoEncola(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	opts := opcionesAutorizacionTest
+	opts.Autenticacion = OpcionesAutenticacion{SecretosPorNodo: map[string]string{"nodo2": "secreto"}}
+	m, mockEdge := crearManagerTest(t, mockS3, opts, nodosZonasTest()...)
+	mockEdge.errComando = errors.New("connection refused")
+
+	_, err := m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoDeshabilitarRegla, ReglaID: "regla1"})
+	require.ErrorIs(t, err, errSinSecretoComando)
+	assert.Empty(t, comandosEncoladosTest(t, mockS3, "nodo1"))
+
+	_, err = m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo2", Tipo: tipos.ComandoDeshabilitarRegla, ReglaID: "regla2"})
+	require.NoError(t, err)
+	assert.Len(t, comandosEncoladosTest(t, mockS3, "nodo2"), 1)
+	t.Log("Los comandos se encolan solo firmados con el secreto del nodo")
+}
+
+// TestEnviarComando_RespetaOrdenCola verifica que con comandos encolados los nuevos se
+// encolan detrás aunque el nodo vuelva a estar accesible
+func TestEnviarComando_RespetaOrdenCola(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	m, mockEdge := crearManagerTest(t, mockS3, opcionesAutorizacionTest, nodosZonasTest()...)
+	mockEdge.errComando = errors.New("timeout")
+
+	primero, err := m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoEliminarRegla, ReglaID: "regla1"})
+	require.NoError(t, err)
+	require.Equal(t, tipos.ComandoPendiente, primero.Resultado.Estado)
+
+	// El nodo responde de nuevo, pero el comando encolado debe aplicarse antes
+	mockEdge.errComando = nil
+	regla := reglaComandoTest("regla1")
+	segundo, err := m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoCrearRegla, Regla: &regla})
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoPendiente, segundo.Resultado.Estado)
+	assert.Contains(t, segundo.Resultado.Motivo, "comandos encolados")
+	assert.Len(t, mockEdge.comandos, 1, "solo se intentó la entrega directa del primero")
+
+	encolados := comandosEncoladosTest(t, mockS3, "nodo1")
+	require.Len(t, encolados, 2)
+	sort.Slice(encolados, func(i, j int) bool { return encolados[i].ID < encolados[j].ID })
+	assert.Equal(t, primero.Comando.ID, encolados[0].ID)
+	assert.Equal(t, segundo.Comando.ID, encolados[1].ID)
+
+	// Con la cola vacía vuelve la entrega directa
+	for _, comando := range encolados {
+		_, err := mockS3.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+			Bucket: aws.String(bucketTest),
+			Key:    aws.String(tipos.GenerarClaveS3Comando("nodo1", comando.ID)),
+		})
+		require.NoError(t, err)
+	}
+	tercero, err := m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoDeshabilitarRegla, ReglaID: "regla1"})
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoAplicado, tercero.Resultado.Estado)
+	t.Log("Los comandos nuevos no se adelantan a los encolados del nodo")
+}
+
+// TestEnviarComando_Errores verifica los rechazos del nodo y las validaciones del despachador
+func TestEnviarComando_Errores(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	m, mockEdge := crearManagerTest(t, mockS3, opcionesAutorizacionTest, nodosZonasTest()...)
+	regla := reglaComandoTest("regla1")
+
+	mockEdge.errComando = &ErrorRechazoEdge{Status: http.StatusConflict, Mensaje: "la regla ya existe: regla1"}
+	_, err := m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoCrearRegla, Regla: &regla})
+	var rechazo *ErrorRechazoEdge
+	require.ErrorAs(t, err, &rechazo)
+	assert.Equal(t, http.StatusConflict, rechazo.Status)
+	assert.Empty(t, comandosEncoladosTest(t, mockS3, "nodo1"))
+
+	_, err = m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo9", Tipo: tipos.ComandoEliminarRegla, ReglaID: "x"})
+	assert.ErrorIs(t, err, ErrNodoNoEncontrado)
+
+	_, err = m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoCrearRegla})
+	assert.Error(t, err)
+	assert.Len(t, mockEdge.comandos, 1)
+	t.Log("Los rechazos del nodo no se encolan y los comandos inválidos no se envían")
+}
+
+// TestHandlersComandosRegla verifica las rutas de modificación de reglas
+func TestHandlersComandosRegla(t *testing.T) {
+	m, mockEdge := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	_, tokenLector, err := m.CrearClaveAPI(ClaveAPI{Rol: RolLector})
+	require.NoError(t, err)
+	_, tokenOperadorSur, err := m.CrearClaveAPI(ClaveAPI{Rol: RolOperador, TagsNodo: map[string]string{"zona": "sur"}})
+	require.NoError(t, err)
+	router := NuevoServidor(m, OpcionesServidor{SinRegistroSolicitudes: true}).Handler()
+
+	solicitud := func(metodo, ruta, token, cuerpo string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(metodo, ruta, strings.NewReader(cuerpo))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	cuerpo, err := json.Marshal(reglaComandoTest("regla3"))
+	require.NoError(t, err)
+
+	// Crear: 201 con la regla que informa el nodo
+	regla := reglaComandoTest("regla3")
+	mockEdge.resultadoComando = &tipos.ResultadoComando{Regla: &regla}
+	w := solicitud(http.MethodPost, "/api/nodos/nodo1/reglas", "clave-maestra", string(cuerpo))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var respuesta ComandoResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respuesta))
+	assert.Equal(t, tipos.ComandoAplicado, respuesta.Estado)
+	assert.Equal(t, tipos.ComandoCrearRegla, respuesta.Tipo)
+	require.NotNil(t, respuesta.Regla)
+	assert.Equal(t, "nodo1", respuesta.Regla.NodoID)
+
+	// Roles y restricciones de la credencial
+	assert.Equal(t, http.StatusForbidden, solicitud(http.MethodPost, "/api/nodos/nodo1/reglas", tokenLector, string(cuerpo)).Code)
+	assert.Equal(t, http.StatusForbidden, solicitud(http.MethodPost, "/api/nodos/nodo1/reglas/regla1/habilitar", tokenOperadorSur, "").Code)
+
+	// Validaciones antes de contactar al nodo
+	enviados := len(mockEdge.comandos)
+	assert.Equal(t, http.StatusBadRequest, solicitud(http.MethodPut, "/api/nodos/nodo1/reglas/otra", "clave-maestra", string(cuerpo)).Code)
+	assert.Equal(t, http.StatusBadRequest, solicitud(http.MethodPost, "/api/nodos/nodo1/reglas", "clave-maestra", `{"id": "x"}`).Code)
+	assert.Equal(t, http.StatusNotFound, solicitud(http.MethodDelete, "/api/nodos/nodo9/reglas/x", "clave-maestra", "").Code)
+	assert.Equal(t, enviados, len(mockEdge.comandos))
+
+	// Rechazo del nodo: se responde con su status
+	mockEdge.errComando = &ErrorRechazoEdge{Status: http.StatusNotFound, Mensaje: "regla no encontrada: x"}
+	w = solicitud(http.MethodDelete, "/api/nodos/nodo1/reglas/x", "clave-maestra", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "regla no encontrada")
+
+	// Nodo inaccesible: 202 con el comando pendiente
+	mockEdge.errComando = errors.New("timeout")
+	w = solicitud(http.MethodPost, "/api/nodos/nodo2/reglas/regla2/deshabilitar", tokenOperadorSur, "")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respuesta))
+	assert.Equal(t, tipos.ComandoPendiente, respuesta.Estado)
+	assert.Equal(t, tipos.ComandoDeshabilitarRegla, respuesta.Tipo)
+	t.Log("Las rutas de reglas por nodo exigen rol operador y responden según la entrega del comando")
+}
+
+// TestClienteEdge_EnviarComando verifica la entrega HTTP firmada y la clasificación de errores
+func TestClienteEdge_EnviarComando(t *testing.T) {
+	var recibido tipos.Comando
+	servidor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/comandos", r.URL.Path)
+		assert.NotEmpty(t, r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&recibido))
+		switch recibido.ReglaID {
+		case "inexistente":
+			http.Error(w, "regla no encontrada: inexistente", http.StatusNotFound)
+		case "falla":
+			http.Error(w, "error de disco", http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(tipos.ResultadoComando{ID: recibido.ID, Regla: &tipos.Regla{ID: recibido.ReglaID}})
+		}
+	}))
+	defer servidor.Close()
+
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{Secreto: "secreto"}, nil)
+	comando := tipos.Comando{ID: "c1", NodoID: "nodo1", Tipo: tipos.ComandoHabilitarRegla, ReglaID: "regla1"}
+	resultado, err := cliente.EnviarComando(context.Background(), "nodo1", servidor.URL, comando)
+	require.NoError(t, err)
+	assert.Equal(t, comando, recibido)
+	assert.Equal(t, "regla1", resultado.Regla.ID)
+
+	comando.ReglaID = "inexistente"
+	_, err = cliente.EnviarComando(context.Background(), "nodo1", servidor.URL, comando)
+	var rechazo *ErrorRechazoEdge
+	require.ErrorAs(t, err, &rechazo)
+	assert.Equal(t, http.StatusNotFound, rechazo.Status)
+
+	comando.ReglaID = "falla"
+	_, err = cliente.EnviarComando(context.Background(), "nodo1", servidor.URL, comando)
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &rechazo))
+	t.Log("clienteEdgeHTTP distingue los rechazos del nodo de sus fallas")
+}
+
+// TestEnviarComando_Series verifica los comandos de series y el guardado de su resultado
+func TestEnviarComando_Series(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	m, mockEdge := crearManagerTest(t, mockS3, opcionesAutorizacionTest, nodosZonasTest()...)
+	var eventos []EventoNodo
+	m.alCambiarNodos = func(e EventoNodo) { eventos = append(eventos, e) }
+
+	serie := tipos.Serie{Path: "planta1/hum", TipoDatos: tipos.Real, Tags: map[string]string{"unidad": "%"}}
+	mockEdge.resultadoComando = &tipos.ResultadoComando{Serie: &serie}
+	registro, err := m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoCrearSerie, Serie: &serie})
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoAplicado, registro.Resultado.Estado)
+	assert.NotZero(t, registro.Resultado.Aplicado)
+
+	// El registro y los eventos reflejan la serie nueva
+	info := m.ObtenerSerie("planta1/hum")
+	require.NotNil(t, info)
+	assert.Equal(t, "nodo1", info.NodoID)
+	require.Len(t, eventos, 1)
+	assert.Equal(t, EventoSeriesCambiadas, eventos[0].Tipo)
+	assert.Contains(t, eventos[0].Nodo.Series, "planta1/hum")
+
+	// El resultado queda guardado en S3
+	guardado, err := m.ObtenerComando(context.Background(), "nodo1", registro.Comando.ID)
+	require.NoError(t, err)
+	assert.Equal(t, registro.Comando, guardado.Comando)
+	assert.Equal(t, tipos.ComandoAplicado, guardado.Resultado.Estado)
+
+	mockEdge.resultadoComando = &tipos.ResultadoComando{}
+	_, err = m.EnviarComando(context.Background(), tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoEliminarSerie, Path: "planta1/temp"})
+	require.NoError(t, err)
+	assert.Nil(t, m.ObtenerSerie("planta1/temp"))
+	assert.Len(t, eventos, 2)
+
+	// Los rechazos también se registran
+	mockEdge.errComando = &ErrorRechazoEdge{Status: http.StatusConflict, Mensaje: "la serie ya existe: planta1/hum"}
+	_, err = m.EnviarComando(context.Background(), tipos.Comando{ID: "c-rechazado", NodoID: "nodo1", Tipo: tipos.ComandoCrearSerie, Serie: &serie})
+	require.Error(t, err)
+	rechazado, err := m.ObtenerComando(context.Background(), "nodo1", "c-rechazado")
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoRechazado, rechazado.Resultado.Estado)
+	assert.Contains(t, rechazado.Resultado.Motivo, "ya existe")
+	assert.Empty(t, comandosEncoladosTest(t, mockS3, "nodo1"))
+	t.Log("Los comandos de series se reflejan en el registro y dejan su resultado en S3")
+}
+
+// TestListarComandos verifica el estado de los comandos pendientes, aplicados y rechazados
+func TestListarComandos(t *testing.T) {
+	mockS3 := nuevoS3Test(t)
+	m, mockEdge := crearManagerTest(t, mockS3, opcionesAutorizacionTest, nodosZonasTest()...)
+	ctx := context.Background()
+
+	mockEdge.errComando = errors.New("connection refused")
+	pendiente, err := m.EnviarComando(ctx, tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoEliminarSerie, Path: "planta1/temp"})
+	require.NoError(t, err)
+	registro, err := m.ObtenerComando(ctx, "nodo1", pendiente.Comando.ID)
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoPendiente, registro.Resultado.Estado)
+	assert.Equal(t, "planta1/temp", registro.Comando.Objetivo())
+
+	// El nodo procesó el comando pero aún no lo eliminó de la cola: prevalece el resultado
+	procesado := tipos.RegistroComando{
+		Comando:   pendiente.Comando,
+		Resultado: tipos.ResultadoComando{ID: pendiente.Comando.ID, Tipo: pendiente.Comando.Tipo, Estado: tipos.ComandoAplicado, Aplicado: 1},
+	}
+	datos, err := json.Marshal(procesado)
+	require.NoError(t, err)
+	guardarObjetoTest(t, mockS3, tipos.GenerarClaveS3ResultadoComando("nodo1", pendiente.Comando.ID), datos)
+
+	mockEdge.errComando = nil
+	mockEdge.resultadoComando = &tipos.ResultadoComando{}
+	aplicado, err := m.EnviarComando(ctx, tipos.Comando{NodoID: "nodo1", Tipo: tipos.ComandoDeshabilitarRegla, ReglaID: "regla1"})
+	require.NoError(t, err)
+	mockEdge.errComando = errors.New("connection refused")
+	otro, err := m.EnviarComando(ctx, tipos.Comando{NodoID: "nodo2", Tipo: tipos.ComandoEliminarRegla, ReglaID: "regla2"})
+	require.NoError(t, err)
+
+	registros, err := m.ListarComandos(ctx, "nodo1")
+	require.NoError(t, err)
+	require.Len(t, registros, 2, "solo los comandos del nodo")
+	assert.Equal(t, pendiente.Comando.ID, registros[0].Comando.ID, "ordenados por creación")
+	assert.Equal(t, tipos.ComandoAplicado, registros[0].Resultado.Estado)
+	assert.Equal(t, aplicado.Comando.ID, registros[1].Comando.ID)
+
+	_, err = m.ObtenerComando(ctx, "nodo1", otro.Comando.ID)
+	assert.ErrorIs(t, err, ErrComandoNoEncontrado)
+	t.Log("ListarComandos y ObtenerComando informan el estado de cada comando")
+}
+
+// TestHandlersComandosSerie verifica las rutas de series por nodo y de estado de comandos
+func TestHandlersComandosSerie(t *testing.T) {
+	m, mockEdge := crearManagerTest(t, nuevoS3Test(t), opcionesAutorizacionTest, nodosZonasTest()...)
+	_, tokenPlanta2, err := m.CrearClaveAPI(ClaveAPI{Rol: RolOperador, Series: []string{"planta2/*"}})
+	require.NoError(t, err)
+	router := NuevoServidor(m, OpcionesServidor{SinRegistroSolicitudes: true}).Handler()
+
+	solicitud := func(metodo, ruta, token, cuerpo string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(metodo, ruta, strings.NewReader(cuerpo))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Crear: 201 con la serie que informa el nodo
+	serie := tipos.Serie{Path: "planta1/hum", TipoDatos: tipos.Real, TamañoBloque: 100}
+	mockEdge.resultadoComando = &tipos.ResultadoComando{Serie: &serie}
+	w := solicitud(http.MethodPost, "/api/nodos/nodo1/series", "clave-maestra", `{"path": "planta1/hum", "tipo_datos": "Real"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var respuesta ComandoResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respuesta))
+	assert.Equal(t, tipos.ComandoCrearSerie, respuesta.Tipo)
+	assert.Equal(t, "planta1/hum", respuesta.Objetivo)
+	require.NotNil(t, respuesta.Serie)
+	assert.Equal(t, "Real", respuesta.Serie.TipoDatos)
+	require.Len(t, mockEdge.comandos, 1)
+	assert.Equal(t, tipos.Real, mockEdge.comandos[0].Serie.TipoDatos)
+
+	// Path con barras en la ruta
+	w = solicitud(http.MethodPut, "/api/nodos/nodo1/series/planta1/hum", "clave-maestra", `{"tags": {"unidad": "%"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "planta1/hum", mockEdge.comandos[1].Serie.Path)
+	assert.Equal(t, http.StatusBadRequest, solicitud(http.MethodPut, "/api/nodos/nodo1/series/planta1/hum", "clave-maestra", `{"path": "otra"}`).Code)
+
+	// Restricción de series de la credencial
+	assert.Equal(t, http.StatusForbidden, solicitud(http.MethodDelete, "/api/nodos/nodo1/series/planta1/temp", tokenPlanta2, "").Code)
+	mockEdge.errComando = errors.New("timeout")
+	w = solicitud(http.MethodDelete, "/api/nodos/nodo2/series/planta2/temp", tokenPlanta2, "")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respuesta))
+	pendienteID := respuesta.ID
+
+	// Estado de los comandos
+	w = solicitud(http.MethodGet, "/api/nodos/nodo1/comandos", "clave-maestra", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var lista []ComandoResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &lista))
+	require.Len(t, lista, 2)
+	assert.Equal(t, tipos.ComandoAplicado, lista[1].Estado)
+
+	w = solicitud(http.MethodGet, "/api/nodos/nodo1/comandos", tokenPlanta2, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &lista))
+	assert.Empty(t, lista, "los comandos sobre series no permitidas se ocultan")
+
+	w = solicitud(http.MethodGet, "/api/nodos/nodo2/comandos/"+pendienteID, tokenPlanta2, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respuesta))
+	assert.Equal(t, tipos.ComandoPendiente, respuesta.Estado)
+	assert.Equal(t, http.StatusNotFound, solicitud(http.MethodGet, "/api/nodos/nodo2/comandos/inexistente", "clave-maestra", "").Code)
+	t.Log("Las rutas de series por nodo envían comandos y su estado se consulta por nodo")
+}
+
+// ============================================================================
+// TESTS DE TÚNELES INVERSOS
+// ============================================================================
+
+// abrirTunelTest abre el túnel de un nodo contra el servidor del despachador como lo
+// hace el edge y sirve handler por él. Retorna la respuesta a la apertura.
+func abrirTunelTest(t *testing.T, servidor *httptest.Server, nodoID, secreto string, handler http.Handler) *http.Response {
+	t.Helper()
+	conexion, err := net.Dial("tcp", servidor.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conexion.Close() })
+
+	req, err := http.NewRequest(http.MethodGet, servidor.URL+tipos.RutaTunel, nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", tipos.ProtocoloTunel)
+	req.Header.Set(tipos.EncabezadoNodo, nodoID)
+	require.NoError(t, tipos.FirmarSolicitud(req, nil, nodoID, []byte(secreto)))
+	require.NoError(t, req.Write(conexion))
+
+	lector := bufio.NewReader(conexion)
+	resp, err := http.ReadResponse(lector, req)
+	require.NoError(t, err)
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		go (&http2.Server{}).ServeConn(tipos.ConexionConLector(conexion, lector), &http2.ServeConnOpts{Handler: handler})
+	}
+	return resp
+}
+
+// TestTunel_ConsultaPorTunel verifica la apertura del túnel y que las consultas a un
+// nodo accesible por túnel viajan multiplexadas por esa conexión
+func TestTunel_ConsultaPorTunel(t *testing.T) {
+	m := &ManagerDespachador{
+		nodos:   map[string]*tipos.Nodo{"nodo1": {NodoID: "nodo1", Tunel: true}},
+		tuneles: nuevoRegistroTuneles(OpcionesAutenticacion{Secreto: "secreto"}),
+	}
+	t.Cleanup(m.tuneles.cerrar)
+	// Con el registro de solicitudes: el Hijack atraviesa los middlewares
+	servidor := httptest.NewServer(NuevoServidor(m, OpcionesServidor{}).Handler())
+	t.Cleanup(servidor.Close)
+
+	cliente := nuevoClienteEdgeHTTP(OpcionesAutenticacion{Secreto: "secreto"}, nil)
+	cliente.clienteTunel = m.clienteTunel
+	direccion := m.nodos["nodo1"].URLBase()
+	solicitud := tipos.SolicitudConsultaPunto{Serie: "planta1/temp"}
+
+	// Sin túnel abierto la consulta falla sin intentar la conexión directa
+	_, err := cliente.ConsultarUltimoPunto(context.Background(), "nodo1", direccion, solicitud)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no tiene un túnel abierto")
+
+	// Aperturas rechazadas
+	resp, err := http.Get(servidor.URL + tipos.RutaTunel)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, abrirTunelTest(t, servidor, "nodo1", "otro-secreto", nil).StatusCode)
+	assert.Equal(t, http.StatusForbidden, abrirTunelTest(t, servidor, "nodo9", "secreto", nil).StatusCode, "nodo no registrado")
+
+	// El nodo verifica la firma de las solicitudes que llegan por el túnel
+	verificador := tipos.NuevoVerificadorSolicitudes("nodo1", []byte("secreto"), 0)
+	respuesta, err := tipos.SerializarGob(tipos.RespuestaConsultaPunto{
+		Resultado: tipos.ResultadoConsultaPunto{Series: []string{"planta1/temp"}, Tiempos: []int64{1000}, Valores: []interface{}{21.5}},
+	})
+	require.NoError(t, err)
+	var atendidas atomic.Int32
+	edge := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cuerpo, _ := io.ReadAll(r.Body)
+		if err := verificador.Verificar(r, cuerpo); err != nil {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+		atendidas.Add(1)
+		w.Write(respuesta)
+	})
+	require.Equal(t, http.StatusSwitchingProtocols, abrirTunelTest(t, servidor, "nodo1", "secreto", edge).StatusCode)
+	require.Eventually(t, func() bool { return m.TunelConectado("nodo1") }, time.Second, 10*time.Millisecond)
+
+	// Consultas concurrentes por la misma conexión
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			obtenida, err := cliente.ConsultarUltimoPunto(context.Background(), "nodo1", direccion, solicitud)
+			if assert.NoError(t, err) {
+				assert.Equal(t, []int64{1000}, obtenida.Resultado.Tiempos)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 10, atendidas.Load())
+
+	// El listado de nodos informa el túnel
+	resp, err = http.Get(servidor.URL + "/api/nodos")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var nodos []NodoResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&nodos))
+	require.Len(t, nodos, 1)
+	assert.True(t, nodos[0].Tunel)
+	assert.True(t, nodos[0].TunelConectado)
+
+	// Al cerrar el despachador se cortan los túneles
+	m.tuneles.cerrar()
+	assert.False(t, m.TunelConectado("nodo1"))
+	_, err = cliente.ConsultarUltimoPunto(context.Background(), "nodo1", direccion, solicitud)
+	assert.ErrorContains(t, err, "no tiene un túnel abierto")
+	t.Log("Las consultas a los nodos con túnel se multiplexan por la conexión abierta por el nodo")
+}
+
+// TestTunel_SinSecretoRechazado verifica que sin secreto configurado no se aceptan
+// túneles: cualquiera podría abrir uno con el ID de otro nodo y recibir sus consultas
+func TestTunel_SinSecretoRechazado(t *testing.T) {
+	m := &ManagerDespachador{
+		nodos:   map[string]*tipos.Nodo{"nodo1": {NodoID: "nodo1", Tunel: true}},
+		tuneles: nuevoRegistroTuneles(OpcionesAutenticacion{SecretosPorNodo: map[string]string{"nodo2": "secreto"}}),
+	}
+	t.Cleanup(m.tuneles.cerrar)
+	servidor := httptest.NewServer(NuevoServidor(m, OpcionesServidor{}).Handler())
+	t.Cleanup(servidor.Close)
+
+	assert.Equal(t, http.StatusUnauthorized, abrirTunelTest(t, servidor, "nodo1", "cualquiera", nil).StatusCode)
+	assert.False(t, m.TunelConectado("nodo1"))
+	t.Log("Los túneles de nodos sin secreto configurado se rechazan")
+}
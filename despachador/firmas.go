@@ -0,0 +1,288 @@
+package despachador
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// VERIFICACIÓN DE REGISTROS FIRMADOS
+// Los nodos edge firman su registro con una clave Ed25519. El despachador solo
+// admite el registro de un nodo inscripto si está firmado con la clave
+// inscripta; así, quien pueda escribir en el bucket no puede redirigir las
+// consultas de un nodo a otra dirección. Los registros rechazados no se
+// cargan y se informan en el estado del despachador.
+// ============================================================================
+
+// OpcionesFirmas configura la verificación de los registros de nodos
+type OpcionesFirmas struct {
+	// ClavesConfiables inscribe nodos de antemano: nodoID → clave pública Ed25519 (base64)
+	ClavesConfiables map[string]string
+
+	// ArchivoConfianza es el archivo JSON donde se guardan los nodos inscriptos. Si existe,
+	// se carga al iniciar y se combina con ClavesConfiables. "" = las inscripciones no persisten.
+	ArchivoConfianza string
+
+	// InscribirAlPrimerUso inscribe la clave del primer registro con firma válida de un
+	// nodo no inscripto.
+	InscribirAlPrimerUso bool
+
+	// RequerirFirma rechaza los registros de nodos no inscriptos. false = se admiten, lo
+	// que permite migrar una flota sin nodos firmados.
+	RequerirFirma bool
+}
+
+// RegistroRechazado describe un registro de nodo que no superó la verificación
+type RegistroRechazado struct {
+	NodoID       string `json:"nodo_id"`
+	ClavePublica string `json:"clave_publica,omitempty"` // Clave pública presentada por el registro
+	Motivo       string `json:"motivo"`
+	Detectado    int64  `json:"detectado"` // Primera detección del rechazo (Unix nanosegundos)
+}
+
+// registroNodo es el registro de un nodo descargado de S3 con el resultado de
+// verificar su firma, que se calcula una sola vez por versión del objeto
+type registroNodo struct {
+	nodo     tipos.Nodo
+	errFirma error // nil = firma válida
+}
+
+// UnmarshalJSON deserializa el registro y verifica su firma sobre los bytes originales
+func (r *registroNodo) UnmarshalJSON(datos []byte) error {
+	if err := json.Unmarshal(datos, &r.nodo); err != nil {
+		return err
+	}
+	r.errFirma = tipos.VerificarRegistroNodo(datos)
+	return nil
+}
+
+// listaConfianza mantiene los nodos inscriptos y los registros rechazados.
+// Una lista nil admite todos los registros.
+type listaConfianza struct {
+	mu         sync.Mutex
+	opts       OpcionesFirmas
+	claves     map[string]string            // nodoID → clave pública inscripta
+	rechazados map[string]RegistroRechazado // Por nodo, según la última sincronización
+}
+
+// nuevaListaConfianza crea la lista con las claves configuradas y las del archivo de confianza
+func nuevaListaConfianza(opts OpcionesFirmas) (*listaConfianza, error) {
+	l := &listaConfianza{
+		opts:       opts,
+		claves:     make(map[string]string),
+		rechazados: make(map[string]RegistroRechazado),
+	}
+
+	if opts.ArchivoConfianza != "" {
+		datos, err := os.ReadFile(opts.ArchivoConfianza)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("error leyendo archivo de confianza: %v", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(datos, &l.claves); err != nil {
+				return nil, fmt.Errorf("error deserializando archivo de confianza: %v", err)
+			}
+		}
+	}
+
+	for nodoID, clave := range opts.ClavesConfiables {
+		if _, err := tipos.DecodificarClavePublica(clave); err != nil {
+			return nil, fmt.Errorf("clave del nodo %s: %v", nodoID, err)
+		}
+		l.claves[nodoID] = clave
+	}
+	return l, nil
+}
+
+// admitir decide si se carga el registro de un nodo. Retorna el motivo del rechazo.
+func (l *listaConfianza) admitir(registro registroNodo) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	nodo := registro.nodo
+	firmado := registro.errFirma == nil
+	if !firmado && !errors.Is(registro.errFirma, tipos.ErrRegistroSinFirma) {
+		return registro.errFirma
+	}
+
+	inscripta, inscripto := l.claves[nodo.NodoID]
+	switch {
+	case inscripto && !firmado:
+		return errors.New("registro sin firma de un nodo inscripto")
+	case inscripto && nodo.ClavePublica != inscripta:
+		return errors.New("la clave pública no coincide con la inscripta")
+	case inscripto:
+		return nil
+	case firmado && l.opts.InscribirAlPrimerUso:
+		l.claves[nodo.NodoID] = nodo.ClavePublica
+		if err := l.guardar(); err != nil {
+			log.Printf("Error guardando inscripción del nodo %s: %v", nodo.NodoID, err)
+		}
+		log.Printf("Nodo %s inscripto al primer uso", nodo.NodoID)
+		return nil
+	case l.opts.RequerirFirma && firmado:
+		return errors.New("nodo no inscripto")
+	case l.opts.RequerirFirma:
+		return errors.New("registro sin firma")
+	default:
+		return nil
+	}
+}
+
+// actualizarRechazados reemplaza los rechazos con los de la última sincronización,
+// conservando el momento de detección de los que persisten
+func (l *listaConfianza) actualizarRechazados(rechazados map[string]RegistroRechazado) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for nodoID, rechazo := range rechazados {
+		anterior, existe := l.rechazados[nodoID]
+		if existe && anterior.Motivo == rechazo.Motivo && anterior.ClavePublica == rechazo.ClavePublica {
+			rechazo.Detectado = anterior.Detectado
+		} else {
+			log.Printf("Registro del nodo %s rechazado: %s", nodoID, rechazo.Motivo)
+		}
+		rechazados[nodoID] = rechazo
+	}
+	l.rechazados = rechazados
+}
+
+// listarRechazados retorna los registros rechazados ordenados por nodo
+func (l *listaConfianza) listarRechazados() []RegistroRechazado {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rechazados := make([]RegistroRechazado, 0, len(l.rechazados))
+	for _, rechazo := range l.rechazados {
+		rechazados = append(rechazados, rechazo)
+	}
+	sort.Slice(rechazados, func(i, j int) bool {
+		return rechazados[i].NodoID < rechazados[j].NodoID
+	})
+	return rechazados
+}
+
+// inscribir registra la clave pública de un nodo. Sin clave, se inscribe la presentada
+// por su registro rechazado.
+func (l *listaConfianza) inscribir(nodoID, clavePublica string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if clavePublica == "" {
+		rechazo, existe := l.rechazados[nodoID]
+		if !existe || rechazo.ClavePublica == "" {
+			return fmt.Errorf("el nodo %s no tiene un registro firmado pendiente de inscripción", nodoID)
+		}
+		clavePublica = rechazo.ClavePublica
+	}
+	if _, err := tipos.DecodificarClavePublica(clavePublica); err != nil {
+		return err
+	}
+
+	l.claves[nodoID] = clavePublica
+	return l.guardar()
+}
+
+// revocar elimina la inscripción de un nodo
+func (l *listaConfianza) revocar(nodoID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, existe := l.claves[nodoID]; !existe {
+		return fmt.Errorf("el nodo %s no está inscripto", nodoID)
+	}
+	delete(l.claves, nodoID)
+	return l.guardar()
+}
+
+// guardar escribe los nodos inscriptos en el archivo de confianza (requiere l.mu)
+func (l *listaConfianza) guardar() error {
+	if l.opts.ArchivoConfianza == "" {
+		return nil
+	}
+
+	datos, err := json.MarshalIndent(l.claves, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializando archivo de confianza: %v", err)
+	}
+
+	// Escribir en un archivo temporal y renombrar para no dejar el archivo a medias
+	temporal := filepath.Join(filepath.Dir(l.opts.ArchivoConfianza), "."+filepath.Base(l.opts.ArchivoConfianza)+".tmp")
+	if err := os.WriteFile(temporal, datos, 0600); err != nil {
+		return fmt.Errorf("error escribiendo archivo de confianza: %v", err)
+	}
+	if err := os.Rename(temporal, l.opts.ArchivoConfianza); err != nil {
+		return fmt.Errorf("error escribiendo archivo de confianza: %v", err)
+	}
+	return nil
+}
+
+// ============================================================================
+// API DE INSCRIPCIÓN
+// ============================================================================
+
+// InscribirNodo inscribe la clave pública de un nodo y vuelve a sincronizar el registro.
+// Si clavePublica es "", se inscribe la clave del registro firmado rechazado del nodo.
+func (m *ManagerDespachador) InscribirNodo(nodoID, clavePublica string) error {
+	if m.confianza == nil {
+		return errors.New("verificación de firmas no configurada")
+	}
+	if err := m.confianza.inscribir(nodoID, clavePublica); err != nil {
+		return err
+	}
+	log.Printf("Nodo %s inscripto", nodoID)
+
+	if err := m.cargarNodosDesdeS3(); err != nil {
+		log.Printf("Error al cargar nodos desde S3: %v", err)
+	}
+	return nil
+}
+
+// RevocarNodo elimina la inscripción de un nodo y vuelve a sincronizar el registro
+func (m *ManagerDespachador) RevocarNodo(nodoID string) error {
+	if m.confianza == nil {
+		return errors.New("verificación de firmas no configurada")
+	}
+	if err := m.confianza.revocar(nodoID); err != nil {
+		return err
+	}
+	log.Printf("Inscripción del nodo %s revocada", nodoID)
+
+	if err := m.cargarNodosDesdeS3(); err != nil {
+		log.Printf("Error al cargar nodos desde S3: %v", err)
+	}
+	return nil
+}
+
+// ListarRegistrosRechazados retorna los registros de nodos rechazados en la última sincronización
+func (m *ManagerDespachador) ListarRegistrosRechazados() []RegistroRechazado {
+	return m.confianza.listarRechazados()
+}
+
+// nuevoRechazo crea el rechazo del registro de un nodo
+func nuevoRechazo(nodo tipos.Nodo, motivo error) RegistroRechazado {
+	return RegistroRechazado{
+		NodoID:       nodo.NodoID,
+		ClavePublica: nodo.ClavePublica,
+		Motivo:       motivo.Error(),
+		Detectado:    time.Now().UnixNano(),
+	}
+}
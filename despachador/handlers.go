@@ -2,6 +2,7 @@ package despachador
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -27,15 +28,80 @@ func HandlerStatus(manager *ManagerDespachador) http.HandlerFunc {
 			NumNodos:  stats.NumNodos,
 			NumSeries: stats.NumSeries,
 		}
+		if manager.cache != nil {
+			respuesta.CacheBloques = &stats.CacheBloques
+		}
+		if manager.cacheResultados != nil {
+			respuesta.CacheResultados = &stats.CacheResultados
+		}
+		respuesta.RegistrosRechazados = manager.ListarRegistrosRechazados()
 		EnviarJSON(w, respuesta)
 	}
 }
 
-// HandlerListarNodos lista todos los nodos registrados
+// HandlerListarNodos lista todos los nodos registrados con su salud y estado de conexión
 func HandlerListarNodos(manager *ManagerDespachador) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		nodos := manager.ListarNodos()
-		EnviarJSON(w, nodos)
+		permisos := permisosDe(r)
+		respuesta := make([]NodoResponse, 0)
+		for _, nodo := range manager.ListarNodos() {
+			if !permisos.PermiteNodo(nodo) {
+				continue
+			}
+			respuesta = append(respuesta, NodoResponse{
+				Nodo:     nodo,
+				Salud:    manager.ObtenerSaludNodo(nodo.NodoID),
+				Conexion: manager.EstadoConexionNodo(nodo),
+
+				TunelConectado: manager.TunelConectado(nodo.NodoID),
+			})
+		}
+		EnviarJSON(w, respuesta)
+	}
+}
+
+// HandlerInscribirNodo inscribe la clave pública de un nodo
+// Path param: /api/nodos/{nodoID}/inscripcion
+// Body (opcional): {"clave_publica": "..."}; sin clave se aprueba la del registro rechazado
+func HandlerInscribirNodo(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nodoID := r.PathValue("nodoID")
+		if nodoID == "" {
+			EnviarError(w, http.StatusBadRequest, "nodoID requerido")
+			return
+		}
+
+		var req InscripcionRequest
+		if r.ContentLength != 0 {
+			if err := LeerJSON(r, &req); err != nil {
+				EnviarError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		if err := manager.InscribirNodo(nodoID, req.ClavePublica); err != nil {
+			EnviarError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandlerRevocarNodo elimina la inscripción de un nodo
+// Path param: /api/nodos/{nodoID}/inscripcion
+func HandlerRevocarNodo(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nodoID := r.PathValue("nodoID")
+		if nodoID == "" {
+			EnviarError(w, http.StatusBadRequest, "nodoID requerido")
+			return
+		}
+
+		if err := manager.RevocarNodo(nodoID); err != nil {
+			EnviarError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
@@ -50,10 +116,13 @@ func HandlerListarSeries(manager *ManagerDespachador) http.HandlerFunc {
 
 		series := manager.ListarSeries(patron)
 
-		// Convertir a formato JSON
-		respuesta := make([]SerieResponse, len(series))
-		for i, si := range series {
-			respuesta[i] = serieToResponse(si)
+		// Convertir a formato JSON (solo las series permitidas)
+		permisos := permisosDe(r)
+		respuesta := make([]SerieResponse, 0, len(series))
+		for _, si := range series {
+			if permisos.PermiteSerie(si.Path) && permisos.PermiteNodo(manager.nodoPorID(si.NodoID)) {
+				respuesta = append(respuesta, serieToResponse(si))
+			}
 		}
 
 		EnviarJSON(w, respuesta)
@@ -75,6 +144,10 @@ func HandlerObtenerSerie(manager *ManagerDespachador) http.HandlerFunc {
 			EnviarError(w, http.StatusNotFound, fmt.Sprintf("serie '%s' no encontrada", path))
 			return
 		}
+		if err := manager.autorizarSerie(permisosDe(r), path); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
 
 		EnviarJSON(w, serieToResponse(*serie))
 	}
@@ -82,7 +155,7 @@ func HandlerObtenerSerie(manager *ManagerDespachador) http.HandlerFunc {
 
 // HandlerConsultarRango consulta datos de una serie en un rango de tiempo
 // POST /api/consulta/rango
-// Body: {"serie": "...", "tiempo_inicio": nanos, "tiempo_fin": nanos}
+// Body: {"serie": "...", "tiempo_inicio": t, "tiempo_fin": t, "ventana": {...} (opc)}
 func HandlerConsultarRango(manager *ManagerDespachador) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req ConsultaRangoRequest
@@ -95,11 +168,25 @@ func HandlerConsultarRango(manager *ManagerDespachador) http.HandlerFunc {
 			EnviarError(w, http.StatusBadRequest, "serie requerida")
 			return
 		}
+		if err := manager.autorizarSerie(permisosDe(r), req.Serie); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
 
-		tiempoInicio := time.Unix(0, req.TiempoInicio)
-		tiempoFin := time.Unix(0, req.TiempoFin)
+		tiempoInicio := req.TiempoInicio.Tiempo()
+		tiempoFin := req.TiempoFin.Tiempo()
 
-		resultado, err := manager.ConsultarRango(req.Serie, tiempoInicio, tiempoFin)
+		var resultado tipos.ResultadoConsultaRango
+		var err error
+		if req.Ventana != nil {
+			if err := req.Ventana.Validar(); err != nil {
+				EnviarError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			resultado, err = manager.ConsultarRangoConVentana(req.Serie, tiempoInicio, tiempoFin, *req.Ventana)
+		} else {
+			resultado, err = manager.ConsultarRango(req.Serie, tiempoInicio, tiempoFin)
+		}
 		if err != nil {
 			EnviarError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -118,7 +205,7 @@ func HandlerConsultarRango(manager *ManagerDespachador) http.HandlerFunc {
 
 // HandlerConsultarUltimo consulta el último punto de una serie
 // POST /api/consulta/ultimo
-// Body: {"serie": "...", "tiempo_inicio": nanos (opc), "tiempo_fin": nanos (opc)}
+// Body: {"serie": "...", "tiempo_inicio": t (opc), "tiempo_fin": t (opc)}
 func HandlerConsultarUltimo(manager *ManagerDespachador) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req ConsultaUltimoRequest
@@ -131,14 +218,18 @@ func HandlerConsultarUltimo(manager *ManagerDespachador) http.HandlerFunc {
 			EnviarError(w, http.StatusBadRequest, "serie requerida")
 			return
 		}
+		if err := manager.autorizarSerie(permisosDe(r), req.Serie); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
 
 		var tiempoInicio, tiempoFin *time.Time
 		if req.TiempoInicio != nil {
-			t := time.Unix(0, *req.TiempoInicio)
+			t := req.TiempoInicio.Tiempo()
 			tiempoInicio = &t
 		}
 		if req.TiempoFin != nil {
-			t := time.Unix(0, *req.TiempoFin)
+			t := req.TiempoFin.Tiempo()
 			tiempoFin = &t
 		}
 
@@ -161,7 +252,7 @@ func HandlerConsultarUltimo(manager *ManagerDespachador) http.HandlerFunc {
 
 // HandlerConsultarAgregacion consulta agregaciones de una serie
 // POST /api/consulta/agregacion
-// Body: {"serie": "...", "tiempo_inicio": nanos, "tiempo_fin": nanos, "agregaciones": ["promedio", "maximo"]}
+// Body: {"serie": "...", "tiempo_inicio": t, "tiempo_fin": t, "agregaciones": ["promedio", "maximo"]}
 func HandlerConsultarAgregacion(manager *ManagerDespachador) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req ConsultaAgregacionRequest
@@ -174,6 +265,10 @@ func HandlerConsultarAgregacion(manager *ManagerDespachador) http.HandlerFunc {
 			EnviarError(w, http.StatusBadRequest, "serie requerida")
 			return
 		}
+		if err := manager.autorizarSerie(permisosDe(r), req.Serie); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
 		if len(req.Agregaciones) == 0 {
 			EnviarError(w, http.StatusBadRequest, "debe especificar al menos una agregación")
 			return
@@ -185,8 +280,8 @@ func HandlerConsultarAgregacion(manager *ManagerDespachador) http.HandlerFunc {
 			agregaciones[i] = tipos.TipoAgregacion(a)
 		}
 
-		tiempoInicio := time.Unix(0, req.TiempoInicio)
-		tiempoFin := time.Unix(0, req.TiempoFin)
+		tiempoInicio := req.TiempoInicio.Tiempo()
+		tiempoFin := req.TiempoFin.Tiempo()
 
 		resultado, err := manager.ConsultarAgregacion(req.Serie, tiempoInicio, tiempoFin, agregaciones)
 		if err != nil {
@@ -213,7 +308,7 @@ func HandlerConsultarAgregacion(manager *ManagerDespachador) http.HandlerFunc {
 
 // HandlerConsultarAgregacionTemporal consulta agregaciones temporales (downsampling)
 // POST /api/consulta/agregacion-temporal
-// Body: {"serie": "...", "tiempo_inicio": nanos, "tiempo_fin": nanos, "agregaciones": [...], "intervalo": nanos}
+// Body: {"serie": "...", "tiempo_inicio": t, "tiempo_fin": t, "agregaciones": [...], "intervalo": d, "ventana": {...} (opc), "alinear": b (opc)}
 func HandlerConsultarAgregacionTemporal(manager *ManagerDespachador) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req ConsultaAgregacionTemporalRequest
@@ -226,6 +321,10 @@ func HandlerConsultarAgregacionTemporal(manager *ManagerDespachador) http.Handle
 			EnviarError(w, http.StatusBadRequest, "serie requerida")
 			return
 		}
+		if err := manager.autorizarSerie(permisosDe(r), req.Serie); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
 		if len(req.Agregaciones) == 0 {
 			EnviarError(w, http.StatusBadRequest, "debe especificar al menos una agregación")
 			return
@@ -241,43 +340,90 @@ func HandlerConsultarAgregacionTemporal(manager *ManagerDespachador) http.Handle
 			agregaciones[i] = tipos.TipoAgregacion(a)
 		}
 
-		tiempoInicio := time.Unix(0, req.TiempoInicio)
-		tiempoFin := time.Unix(0, req.TiempoFin)
-		intervalo := time.Duration(req.Intervalo)
+		tiempoInicio := req.TiempoInicio.Tiempo()
+		tiempoFin := req.TiempoFin.Tiempo()
+		intervalo := req.Intervalo.Duration()
+		if req.Alinear {
+			tiempoInicio, tiempoFin = AlinearRangoBuckets(tiempoInicio, tiempoFin, intervalo)
+		}
 
-		resultado, err := manager.ConsultarAgregacionTemporal(req.Serie, tiempoInicio, tiempoFin, agregaciones, intervalo)
+		var resultado tipos.ResultadoAgregacionTemporal
+		var err error
+		if req.Ventana != nil {
+			if err := req.Ventana.Validar(); err != nil {
+				EnviarError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			resultado, err = manager.ConsultarAgregacionTemporalConVentana(req.Serie, tiempoInicio, tiempoFin, agregaciones, intervalo, *req.Ventana)
+		} else {
+			resultado, err = manager.ConsultarAgregacionTemporal(req.Serie, tiempoInicio, tiempoFin, agregaciones, intervalo)
+		}
 		if err != nil {
 			EnviarError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		// Convertir TipoAgregacion a strings
-		agregacionesStr := make([]string, len(resultado.Agregaciones))
-		for i, a := range resultado.Agregaciones {
-			agregacionesStr[i] = string(a)
+		EnviarJSON(w, agregacionTemporalToResponse(resultado))
+	}
+}
+
+// HandlerConsultarComparacion compara la agregación temporal de un período con el mismo
+// período desplazado hacia atrás (ej: esta semana vs la semana anterior)
+// POST /api/consulta/comparacion
+// Body: {"serie": "...", "tiempo_inicio": t, "tiempo_fin": t, "agregaciones": [...], "intervalo": d, "desplazamiento": d}
+func HandlerConsultarComparacion(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ConsultaComparacionRequest
+		if err := LeerJSON(r, &req); err != nil {
+			EnviarError(w, http.StatusBadRequest, err.Error())
+			return
 		}
 
-		// Convertir [][][]float64 a [][][]FloatNulo para serializar NaN como null en JSON
-		valoresFloatNulo := make([][][]FloatNulo, len(resultado.Valores))
-		for i, agregacion := range resultado.Valores {
-			valoresFloatNulo[i] = make([][]FloatNulo, len(agregacion))
-			for j, bucket := range agregacion {
-				valoresFloatNulo[i][j] = make([]FloatNulo, len(bucket))
-				for k, valor := range bucket {
-					valoresFloatNulo[i][j][k] = FloatNulo(valor)
-				}
-			}
+		if req.Serie == "" {
+			EnviarError(w, http.StatusBadRequest, "serie requerida")
+			return
+		}
+		if err := manager.autorizarSerie(permisosDe(r), req.Serie); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if len(req.Agregaciones) == 0 {
+			EnviarError(w, http.StatusBadRequest, "debe especificar al menos una agregación")
+			return
+		}
+		if req.Intervalo <= 0 {
+			EnviarError(w, http.StatusBadRequest, "intervalo debe ser mayor a cero")
+			return
+		}
+		if req.Desplazamiento <= 0 {
+			EnviarError(w, http.StatusBadRequest, "desplazamiento debe ser mayor a cero")
+			return
 		}
 
-		respuesta := ConsultaAgregacionTemporalResponse{
-			Series:             resultado.Series,
-			Tiempos:            resultado.Tiempos,
-			Agregaciones:       agregacionesStr,
-			Valores:            valoresFloatNulo,
-			NodosNoDisponibles: resultado.NodosNoDisponibles,
+		agregaciones := make([]tipos.TipoAgregacion, len(req.Agregaciones))
+		for i, a := range req.Agregaciones {
+			agregaciones[i] = tipos.TipoAgregacion(a)
 		}
 
-		EnviarJSON(w, respuesta)
+		resultado, err := manager.ConsultarComparacionPeriodos(
+			req.Serie,
+			req.TiempoInicio.Tiempo(),
+			req.TiempoFin.Tiempo(),
+			agregaciones,
+			req.Intervalo.Duration(),
+			req.Desplazamiento.Duration(),
+		)
+		if err != nil {
+			EnviarError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		EnviarJSON(w, ConsultaComparacionResponse{
+			Actual:           agregacionTemporalToResponse(resultado.Actual),
+			Anterior:         agregacionTemporalToResponse(resultado.Anterior),
+			Desplazamiento:   resultado.Desplazamiento.Nanoseconds(),
+			TiemposRelativos: resultado.TiemposRelativos,
+		})
 	}
 }
 
@@ -306,10 +452,14 @@ func HandlerListarReglas(manager *ManagerDespachador) http.HandlerFunc {
 
 		reglas := manager.ListarReglas(nodoFiltro, soloActivas)
 
-		// Convertir a formato de respuesta
-		respuesta := make([]ReglaResponse, len(reglas))
-		for i, reglaInfo := range reglas {
-			respuesta[i] = ReglaResponse{
+		// Convertir a formato de respuesta (solo las reglas permitidas)
+		permisos := permisosDe(r)
+		respuesta := make([]ReglaResponse, 0, len(reglas))
+		for _, reglaInfo := range reglas {
+			if !permisos.PermiteRegla(reglaInfo.Regla, manager.nodoPorID(reglaInfo.NodoID)) {
+				continue
+			}
+			respuesta = append(respuesta, ReglaResponse{
 				ID:          reglaInfo.ID,
 				Nombre:      reglaInfo.Nombre,
 				Activa:      reglaInfo.Activa,
@@ -317,7 +467,7 @@ func HandlerListarReglas(manager *ManagerDespachador) http.HandlerFunc {
 				NodoID:      reglaInfo.NodoID,
 				Condiciones: reglaInfo.Condiciones,
 				Acciones:    reglaInfo.Acciones,
-			}
+			})
 		}
 
 		EnviarJSON(w, respuesta)
@@ -339,6 +489,10 @@ func HandlerObtenerRegla(manager *ManagerDespachador) http.HandlerFunc {
 			EnviarError(w, http.StatusNotFound, fmt.Sprintf("regla '%s' no encontrada", id))
 			return
 		}
+		if !permisosDe(r).PermiteRegla(*regla, manager.nodoPorID(nodoID)) {
+			EnviarError(w, http.StatusForbidden, fmt.Sprintf("%v a la regla '%s'", ErrAccesoDenegado, id))
+			return
+		}
 
 		respuesta := ReglaResponse{
 			ID:          regla.ID,
@@ -364,11 +518,21 @@ func HandlerListarReglasPorNodo(manager *ManagerDespachador) http.HandlerFunc {
 			return
 		}
 
+		permisos := permisosDe(r)
+		if err := manager.autorizarNodo(permisos, nodoID); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
 		reglas := manager.ListarReglasPorNodo(nodoID)
+		nodo := manager.nodoPorID(nodoID)
 
-		respuesta := make([]ReglaResponse, len(reglas))
-		for i, regla := range reglas {
-			respuesta[i] = ReglaResponse{
+		respuesta := make([]ReglaResponse, 0, len(reglas))
+		for _, regla := range reglas {
+			if !permisos.PermiteRegla(regla, nodo) {
+				continue
+			}
+			respuesta = append(respuesta, ReglaResponse{
 				ID:          regla.ID,
 				Nombre:      regla.Nombre,
 				Activa:      regla.Activa,
@@ -376,13 +540,297 @@ func HandlerListarReglasPorNodo(manager *ManagerDespachador) http.HandlerFunc {
 				NodoID:      nodoID,
 				Condiciones: regla.Condiciones,
 				Acciones:    regla.Acciones,
+			})
+		}
+
+		EnviarJSON(w, respuesta)
+	}
+}
+
+// HandlerCrearRegla crea una regla en un nodo
+// POST /api/nodos/{nodoID}/reglas
+// Body: tipos.Regla
+func HandlerCrearRegla(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var regla tipos.Regla
+		if err := LeerJSON(r, &regla); err != nil {
+			EnviarError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		enviarComando(manager, w, r, tipos.Comando{Tipo: tipos.ComandoCrearRegla, Regla: &regla})
+	}
+}
+
+// HandlerActualizarRegla reemplaza una regla de un nodo conservando su estado activo
+// PUT /api/nodos/{nodoID}/reglas/{id}
+// Body: tipos.Regla (el id del body, si se indica, debe coincidir con el de la ruta)
+func HandlerActualizarRegla(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var regla tipos.Regla
+		if err := LeerJSON(r, &regla); err != nil {
+			EnviarError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		id := r.PathValue("id")
+		if regla.ID != "" && regla.ID != id {
+			EnviarError(w, http.StatusBadRequest, fmt.Sprintf("el id del body (%s) no coincide con el de la ruta (%s)", regla.ID, id))
+			return
+		}
+		regla.ID = id
+		enviarComando(manager, w, r, tipos.Comando{Tipo: tipos.ComandoActualizarRegla, Regla: &regla})
+	}
+}
+
+// HandlerEliminarRegla elimina una regla de un nodo
+// DELETE /api/nodos/{nodoID}/reglas/{id}
+func HandlerEliminarRegla(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enviarComando(manager, w, r, tipos.Comando{Tipo: tipos.ComandoEliminarRegla, ReglaID: r.PathValue("id")})
+	}
+}
+
+// HandlerHabilitarRegla activa (activa=true) o desactiva una regla de un nodo
+// POST /api/nodos/{nodoID}/reglas/{id}/habilitar
+// POST /api/nodos/{nodoID}/reglas/{id}/deshabilitar
+func HandlerHabilitarRegla(activa bool) func(*ManagerDespachador) http.HandlerFunc {
+	tipo := tipos.ComandoHabilitarRegla
+	if !activa {
+		tipo = tipos.ComandoDeshabilitarRegla
+	}
+	return func(manager *ManagerDespachador) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			enviarComando(manager, w, r, tipos.Comando{Tipo: tipo, ReglaID: r.PathValue("id")})
+		}
+	}
+}
+
+// HandlerCrearSerie crea una serie en un nodo
+// POST /api/nodos/{nodoID}/series
+// Body: tipos.Serie (compresiones y tamaño de bloque opcionales)
+func HandlerCrearSerie(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var serie tipos.Serie
+		if err := LeerJSON(r, &serie); err != nil {
+			EnviarError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		enviarComando(manager, w, r, tipos.Comando{Tipo: tipos.ComandoCrearSerie, Serie: &serie})
+	}
+}
+
+// HandlerActualizarSerie reemplaza los tags y el tiempo de almacenamiento de una serie
+// PUT /api/nodos/{nodoID}/series/{path...}
+// Body: tipos.Serie (los campos de formato pueden omitirse pero no cambiar)
+func HandlerActualizarSerie(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var serie tipos.Serie
+		if err := LeerJSON(r, &serie); err != nil {
+			EnviarError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		path := r.PathValue("path")
+		if serie.Path != "" && serie.Path != path {
+			EnviarError(w, http.StatusBadRequest, fmt.Sprintf("el path del body (%s) no coincide con el de la ruta (%s)", serie.Path, path))
+			return
+		}
+		serie.Path = path
+		enviarComando(manager, w, r, tipos.Comando{Tipo: tipos.ComandoActualizarSerie, Serie: &serie})
+	}
+}
+
+// HandlerEliminarSerie elimina una serie de un nodo y sus datos locales
+// DELETE /api/nodos/{nodoID}/series/{path...}
+func HandlerEliminarSerie(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enviarComando(manager, w, r, tipos.Comando{Tipo: tipos.ComandoEliminarSerie, Path: r.PathValue("path")})
+	}
+}
+
+// enviarComando verifica los permisos sobre el nodo y el objetivo del comando, lo
+// envía y responde 200 (201 al crear) si el nodo lo aplicó o 202 si quedó encolado
+func enviarComando(manager *ManagerDespachador, w http.ResponseWriter, r *http.Request, comando tipos.Comando) {
+	comando.NodoID = r.PathValue("nodoID")
+	if err := comando.Validar(); err != nil {
+		EnviarError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	permisos := permisosDe(r)
+	if err := manager.autorizarNodo(permisos, comando.NodoID); err != nil {
+		EnviarError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if !permiteComando(permisos, manager.nodoPorID(comando.NodoID), comando) {
+		EnviarError(w, http.StatusForbidden, fmt.Sprintf("%v a '%s'", ErrAccesoDenegado, comando.Objetivo()))
+		return
+	}
+
+	registro, err := manager.EnviarComando(r.Context(), comando)
+	var rechazo *ErrorRechazoEdge
+	switch {
+	case errors.As(err, &rechazo):
+		EnviarError(w, rechazo.Status, rechazo.Mensaje)
+		return
+	case errors.Is(err, ErrNodoNoEncontrado):
+		EnviarError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		EnviarError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	codigo := http.StatusOK
+	switch {
+	case registro.Resultado.Estado == tipos.ComandoPendiente:
+		codigo = http.StatusAccepted
+	case comando.Tipo == tipos.ComandoCrearRegla, comando.Tipo == tipos.ComandoCrearSerie:
+		codigo = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(codigo)
+	EnviarJSON(w, comandoToResponse(registro))
+}
+
+// permiteComando indica si los permisos alcanzan al objetivo del comando: la serie,
+// o la regla nueva y la registrada con el mismo ID
+func permiteComando(permisos *Permisos, nodo tipos.Nodo, comando tipos.Comando) bool {
+	if !permisos.PermiteNodo(nodo) {
+		return false
+	}
+	if comando.EsComandoSerie() {
+		return permisos.PermiteSerie(comando.Objetivo())
+	}
+	if comando.Regla != nil && !permisos.PermiteRegla(*comando.Regla, nodo) {
+		return false
+	}
+	for _, registrada := range nodo.Reglas {
+		if registrada.ID == comando.Objetivo() && !permisos.PermiteRegla(registrada, nodo) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandlerListarComandos lista los comandos pendientes, aplicados y rechazados de un nodo
+// GET /api/nodos/{nodoID}/comandos
+func HandlerListarComandos(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nodoID := r.PathValue("nodoID")
+		permisos := permisosDe(r)
+		if err := manager.autorizarNodo(permisos, nodoID); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		registros, err := manager.ListarComandos(r.Context(), nodoID)
+		if err != nil {
+			EnviarError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		nodo := manager.nodoPorID(nodoID)
+		respuesta := make([]ComandoResponse, 0, len(registros))
+		for _, registro := range registros {
+			if permiteComando(permisos, nodo, registro.Comando) {
+				respuesta = append(respuesta, comandoToResponse(registro))
 			}
 		}
+		EnviarJSON(w, respuesta)
+	}
+}
 
+// HandlerObtenerComando retorna el estado de un comando
+// GET /api/nodos/{nodoID}/comandos/{id}
+func HandlerObtenerComando(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nodoID := r.PathValue("nodoID")
+		permisos := permisosDe(r)
+		if err := manager.autorizarNodo(permisos, nodoID); err != nil {
+			EnviarError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		registro, err := manager.ObtenerComando(r.Context(), nodoID, r.PathValue("id"))
+		switch {
+		case errors.Is(err, ErrComandoNoEncontrado):
+			EnviarError(w, http.StatusNotFound, err.Error())
+			return
+		case err != nil:
+			EnviarError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !permiteComando(permisos, manager.nodoPorID(nodoID), registro.Comando) {
+			EnviarError(w, http.StatusForbidden, fmt.Sprintf("%v a '%s'", ErrAccesoDenegado, registro.Comando.Objetivo()))
+			return
+		}
+		EnviarJSON(w, comandoToResponse(registro))
+	}
+}
+
+// ============================================================================
+// HANDLERS DE CLAVES API
+// ============================================================================
+
+// HandlerCrearClaveAPI crea una clave API. El token solo se informa en esta respuesta.
+// POST /api/claves
+// Body: {"nombre": "...", "rol": "lector", "series": ["planta1/*"], "tags_nodo": {"zona": "norte"}, "expira": t (opc)}
+func HandlerCrearClaveAPI(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ClaveAPIRequest
+		if err := LeerJSON(r, &req); err != nil {
+			EnviarError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		clave, token, err := manager.CrearClaveAPI(ClaveAPI{
+			Nombre:   req.Nombre,
+			Rol:      req.Rol,
+			Series:   req.Series,
+			TagsNodo: req.TagsNodo,
+			Expira:   req.Expira,
+		})
+		if err != nil {
+			EnviarError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		respuesta := claveAPIToResponse(clave)
+		respuesta.Token = token
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
 		EnviarJSON(w, respuesta)
 	}
 }
 
+// HandlerListarClavesAPI lista las claves API (sin sus secretos)
+// GET /api/claves
+func HandlerListarClavesAPI(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claves := manager.ListarClavesAPI()
+		respuesta := make([]ClaveAPIResponse, len(claves))
+		for i, clave := range claves {
+			respuesta[i] = claveAPIToResponse(clave)
+		}
+		EnviarJSON(w, respuesta)
+	}
+}
+
+// HandlerRevocarClaveAPI elimina una clave API
+// Path param: /api/claves/{id}
+func HandlerRevocarClaveAPI(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if id == "" {
+			EnviarError(w, http.StatusBadRequest, "id de clave requerido")
+			return
+		}
+
+		if err := manager.RevocarClaveAPI(id); err != nil {
+			EnviarError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 
 // ============================================================================
 // HELPERS EXPORTADOS - Utilidades HTTP reutilizables
@@ -430,3 +878,76 @@ func serieToResponse(si SerieInfo) SerieResponse {
 		CompresionBloque:     string(si.CompresionBloque),
 	}
 }
+
+// comandoToResponse convierte un registro de comando a ComandoResponse
+func comandoToResponse(registro tipos.RegistroComando) ComandoResponse {
+	comando, resultado := registro.Comando, registro.Resultado
+	respuesta := ComandoResponse{
+		ID:       comando.ID,
+		NodoID:   comando.NodoID,
+		Tipo:     comando.Tipo,
+		Objetivo: comando.Objetivo(),
+		Estado:   resultado.Estado,
+		Creado:   comando.Creado,
+		Aplicado: resultado.Aplicado,
+		Motivo:   resultado.Motivo,
+	}
+	if regla := resultado.Regla; regla != nil {
+		respuesta.Regla = &ReglaResponse{
+			ID:          regla.ID,
+			Nombre:      regla.Nombre,
+			Activa:      regla.Activa,
+			Logica:      regla.Logica,
+			NodoID:      comando.NodoID,
+			Condiciones: regla.Condiciones,
+			Acciones:    regla.Acciones,
+		}
+	}
+	if resultado.Serie != nil {
+		serie := serieToResponse(SerieInfo{Serie: *resultado.Serie, NodoID: comando.NodoID})
+		respuesta.Serie = &serie
+	}
+	return respuesta
+}
+
+// claveAPIToResponse convierte ClaveAPI a ClaveAPIResponse (sin el hash del secreto)
+func claveAPIToResponse(clave ClaveAPI) ClaveAPIResponse {
+	return ClaveAPIResponse{
+		ID:       clave.ID,
+		Nombre:   clave.Nombre,
+		Rol:      clave.Rol,
+		Series:   clave.Series,
+		TagsNodo: clave.TagsNodo,
+		Creada:   clave.Creada,
+		Expira:   clave.Expira,
+	}
+}
+
+// agregacionTemporalToResponse convierte un resultado de agregación temporal a su respuesta JSON
+func agregacionTemporalToResponse(resultado tipos.ResultadoAgregacionTemporal) ConsultaAgregacionTemporalResponse {
+	// Convertir TipoAgregacion a strings
+	agregacionesStr := make([]string, len(resultado.Agregaciones))
+	for i, a := range resultado.Agregaciones {
+		agregacionesStr[i] = string(a)
+	}
+
+	// Convertir [][][]float64 a [][][]FloatNulo para serializar NaN como null en JSON
+	valoresFloatNulo := make([][][]FloatNulo, len(resultado.Valores))
+	for i, agregacion := range resultado.Valores {
+		valoresFloatNulo[i] = make([][]FloatNulo, len(agregacion))
+		for j, bucket := range agregacion {
+			valoresFloatNulo[i][j] = make([]FloatNulo, len(bucket))
+			for k, valor := range bucket {
+				valoresFloatNulo[i][j][k] = FloatNulo(valor)
+			}
+		}
+	}
+
+	return ConsultaAgregacionTemporalResponse{
+		Series:             resultado.Series,
+		Tiempos:            resultado.Tiempos,
+		Agregaciones:       agregacionesStr,
+		Valores:            valoresFloatNulo,
+		NodosNoDisponibles: resultado.NodosNoDisponibles,
+	}
+}
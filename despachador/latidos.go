@@ -0,0 +1,144 @@
+package despachador
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// LATIDOS Y ESTADO DE CONEXIÓN DE NODOS
+// Los nodos edge actualizan periódicamente latidos/{nodo}.json. El despachador
+// toma el momento más reciente entre el registro y el latido como última
+// conexión y clasifica al nodo según su antigüedad. Los nodos desconectados no
+// se consultan y, si se configura, sus registros se eliminan de S3.
+// ============================================================================
+
+const (
+	// Antigüedad por defecto a partir de la cual un nodo se considera inactivo
+	umbralInactivoPorDefecto = 2 * time.Minute
+	// Antigüedad por defecto a partir de la cual un nodo se considera desconectado
+	umbralDesconectadoPorDefecto = 10 * time.Minute
+)
+
+// errNodoDesconectado indica que el nodo no envía latidos y no se lo consultó
+var errNodoDesconectado = errors.New("nodo desconectado (sin latidos recientes)")
+
+// EstadoConexion es el estado de un nodo según su última conexión
+type EstadoConexion string
+
+const (
+	ConexionEnLinea      EstadoConexion = "en_linea"     // Latido reciente
+	ConexionInactiva     EstadoConexion = "inactivo"     // Sin latidos desde UmbralInactivo
+	ConexionDesconectada EstadoConexion = "desconectado" // Sin latidos desde UmbralDesconectado: no se consulta
+	ConexionDesconocida  EstadoConexion = "desconocido"  // El nodo no informa su última conexión
+)
+
+// OpcionesLatidos configura la clasificación de los nodos según sus latidos
+type OpcionesLatidos struct {
+	// UmbralInactivo es la antigüedad del último latido que marca al nodo como inactivo. 0 = 2m.
+	UmbralInactivo time.Duration
+
+	// UmbralDesconectado es la antigüedad del último latido que marca al nodo como
+	// desconectado. 0 = 10m.
+	UmbralDesconectado time.Duration
+
+	// EliminarTras es la antigüedad del último latido a partir de la cual el registro
+	// del nodo se elimina de S3. 0 = nunca.
+	EliminarTras time.Duration
+}
+
+// umbrales retorna los umbrales de inactividad y desconexión con sus valores por defecto
+func (o OpcionesLatidos) umbrales() (inactivo, desconectado time.Duration) {
+	inactivo = o.UmbralInactivo
+	if inactivo <= 0 {
+		inactivo = umbralInactivoPorDefecto
+	}
+	desconectado = o.UmbralDesconectado
+	if desconectado <= 0 {
+		desconectado = umbralDesconectadoPorDefecto
+	}
+	return inactivo, max(desconectado, inactivo)
+}
+
+// EstadoConexionNodo clasifica un nodo según la antigüedad de su última conexión
+func (m *ManagerDespachador) EstadoConexionNodo(nodo tipos.Nodo) EstadoConexion {
+	if nodo.UltimaConexion == 0 {
+		return ConexionDesconocida
+	}
+
+	inactivo, desconectado := m.latidos.umbrales()
+	antiguedad := time.Since(time.Unix(0, nodo.UltimaConexion))
+	switch {
+	case antiguedad >= desconectado:
+		return ConexionDesconectada
+	case antiguedad >= inactivo:
+		return ConexionInactiva
+	default:
+		return ConexionEnLinea
+	}
+}
+
+// cargarLatidos lee los latidos publicados por los nodos, indexados por nodo. Solo se
+// descargan los latidos que cambiaron desde la sincronización anterior; los que no se
+// pueden leer se ignoran y el nodo conserva la última conexión de su registro.
+// Requiere m.sincronizacion.mu.
+func (m *ManagerDespachador) cargarLatidos(ctx context.Context) (map[string]tipos.Latido, error) {
+	objetos, err := tipos.ListarObjetos(ctx, m.almacenamiento, tipos.PrefijoS3Latidos)
+	if err != nil {
+		return nil, fmt.Errorf("error listando latidos desde S3: %v", err)
+	}
+
+	m.sincronizacion.latidos, _ = sincronizarObjetos(ctx, m, objetos, m.sincronizacion.latidos, "latido")
+
+	latidos := make(map[string]tipos.Latido, len(m.sincronizacion.latidos))
+	for _, obj := range m.sincronizacion.latidos {
+		latidos[obj.valor.NodoID] = obj.valor
+	}
+	return latidos, nil
+}
+
+// aplicarLatido actualiza la última conexión y la versión del nodo con su latido
+func aplicarLatido(nodo *tipos.Nodo, latido tipos.Latido) {
+	nodo.UltimaConexion = max(nodo.UltimaConexion, latido.Momento)
+	if latido.Version != "" {
+		nodo.Version = latido.Version
+	}
+}
+
+// eliminarRegistroNodo borra de S3 el registro y el latido de un nodo
+func (m *ManagerDespachador) eliminarRegistroNodo(ctx context.Context, nodoID string) error {
+	claves := []string{fmt.Sprintf("nodos/%s.json", nodoID), tipos.GenerarClaveS3Latido(nodoID)}
+	for _, clave := range claves {
+		if err := m.almacenamiento.Eliminar(ctx, clave); err != nil {
+			return fmt.Errorf("error eliminando %s: %v", clave, err)
+		}
+	}
+	return nil
+}
+
+// expirarNodos elimina de S3 y del mapa los nodos sin conexión desde EliminarTras.
+// Los nodos que no informan su última conexión nunca se eliminan.
+func (m *ManagerDespachador) expirarNodos(ctx context.Context, nodos map[string]*tipos.Nodo) {
+	if m.latidos.EliminarTras <= 0 {
+		return
+	}
+
+	limite := time.Now().Add(-m.latidos.EliminarTras).UnixNano()
+	for nodoID, nodo := range nodos {
+		if nodo.UltimaConexion == 0 || nodo.UltimaConexion > limite {
+			continue
+		}
+		if err := m.eliminarRegistroNodo(ctx, nodoID); err != nil {
+			log.Printf("Error eliminando registro del nodo %s: %v", nodoID, err)
+			continue
+		}
+		delete(nodos, nodoID)
+		log.Printf("Registro del nodo %s eliminado (última conexión: %s)",
+			nodoID, time.Unix(0, nodo.UltimaConexion).Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,189 @@
+package despachador
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// SALUD DE NODOS Y CORTOCIRCUITO
+// Registra el resultado de cada consulta a los nodos edge. Tras varios fallos
+// consecutivos el circuito del nodo se abre y las consultas no lo contactan
+// (se informa como no disponible sin esperar el timeout). Pasada la espera, una
+// única consulta de prueba decide si el circuito se cierra o vuelve a abrirse.
+// ============================================================================
+
+const (
+	// Fallos consecutivos por defecto para abrir el circuito de un nodo
+	umbralFallosPorDefecto = 3
+	// Espera por defecto antes de volver a probar un nodo con el circuito abierto
+	esperaReintentoPorDefecto = 30 * time.Second
+	// Peso de la última muestra en la latencia promedio (media móvil exponencial)
+	pesoLatencia = 0.2
+)
+
+// errNodoNoDisponible indica que el circuito del nodo está abierto y no se lo consultó
+var errNodoNoDisponible = errors.New("nodo no disponible (circuito abierto)")
+
+// EstadoCircuito es el estado del cortocircuito de un nodo
+type EstadoCircuito string
+
+const (
+	CircuitoCerrado     EstadoCircuito = "cerrado"     // El nodo responde: se consulta normalmente
+	CircuitoAbierto     EstadoCircuito = "abierto"     // El nodo no responde: no se consulta
+	CircuitoSemiabierto EstadoCircuito = "semiabierto" // Consulta de prueba en curso
+)
+
+// OpcionesSaludNodos configura el cortocircuito de los nodos edge
+type OpcionesSaludNodos struct {
+	// UmbralFallos es la cantidad de fallos consecutivos que abre el circuito. 0 = 3.
+	UmbralFallos int
+
+	// EsperaReintento es el tiempo con el circuito abierto antes de volver a probar el nodo. 0 = 30s.
+	EsperaReintento time.Duration
+}
+
+// SaludNodo describe la disponibilidad observada de un nodo edge
+type SaludNodo struct {
+	Estado             EstadoCircuito `json:"estado"`
+	UltimoExito        int64          `json:"ultimo_exito,omitempty"` // Unix nanosegundos (0 = nunca)
+	UltimoFallo        int64          `json:"ultimo_fallo,omitempty"` // Unix nanosegundos (0 = nunca)
+	UltimoError        string         `json:"ultimo_error,omitempty"`
+	FallosConsecutivos int            `json:"fallos_consecutivos"`
+	LatenciaMs         float64        `json:"latencia_ms"` // Latencia promedio de las consultas exitosas
+}
+
+// estadoSaludNodo es el estado interno de un nodo
+type estadoSaludNodo struct {
+	SaludNodo
+	reintento time.Time // Momento a partir del cual se permite la consulta de prueba
+}
+
+// monitorSalud mantiene la salud de los nodos edge.
+// Un monitor nil permite todas las consultas y no registra resultados.
+type monitorSalud struct {
+	mu              sync.Mutex
+	umbralFallos    int
+	esperaReintento time.Duration
+	nodos           map[string]*estadoSaludNodo
+}
+
+// nuevoMonitorSalud crea el monitor según las opciones
+func nuevoMonitorSalud(opts OpcionesSaludNodos) *monitorSalud {
+	umbral := opts.UmbralFallos
+	if umbral <= 0 {
+		umbral = umbralFallosPorDefecto
+	}
+	espera := opts.EsperaReintento
+	if espera <= 0 {
+		espera = esperaReintentoPorDefecto
+	}
+	return &monitorSalud{
+		umbralFallos:    umbral,
+		esperaReintento: espera,
+		nodos:           make(map[string]*estadoSaludNodo),
+	}
+}
+
+// estado retorna el estado de un nodo, creándolo si no existe (requiere s.mu)
+func (s *monitorSalud) estado(nodoID string) *estadoSaludNodo {
+	e, existe := s.nodos[nodoID]
+	if !existe {
+		e = &estadoSaludNodo{SaludNodo: SaludNodo{Estado: CircuitoCerrado}}
+		s.nodos[nodoID] = e
+	}
+	return e
+}
+
+// permitir indica si se puede consultar al nodo. Con el circuito abierto y la espera
+// cumplida, permite una única consulta de prueba y pasa a semiabierto.
+func (s *monitorSalud) permitir(nodoID string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.estado(nodoID)
+	switch e.Estado {
+	case CircuitoAbierto:
+		if time.Now().Before(e.reintento) {
+			return false
+		}
+		e.Estado = CircuitoSemiabierto
+		return true
+	case CircuitoSemiabierto:
+		return false // Solo una consulta de prueba a la vez
+	default:
+		return true
+	}
+}
+
+// registrarExito registra una respuesta del nodo y cierra su circuito
+func (s *monitorSalud) registrarExito(nodoID string, latencia time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.estado(nodoID)
+	ms := float64(latencia) / float64(time.Millisecond)
+	if e.UltimoExito == 0 {
+		e.LatenciaMs = ms
+	} else {
+		e.LatenciaMs = pesoLatencia*ms + (1-pesoLatencia)*e.LatenciaMs
+	}
+	e.UltimoExito = time.Now().UnixNano()
+	e.FallosConsecutivos = 0
+	e.Estado = CircuitoCerrado
+}
+
+// registrarFallo registra un error de conexión o timeout. Abre el circuito al alcanzar
+// el umbral de fallos o si falla la consulta de prueba.
+func (s *monitorSalud) registrarFallo(nodoID string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.estado(nodoID)
+	e.UltimoFallo = time.Now().UnixNano()
+	e.UltimoError = err.Error()
+	e.FallosConsecutivos++
+	if e.Estado == CircuitoSemiabierto || e.FallosConsecutivos >= s.umbralFallos {
+		e.Estado = CircuitoAbierto
+		e.reintento = time.Now().Add(s.esperaReintento)
+	}
+}
+
+// obtener retorna la salud de un nodo (circuito cerrado si nunca se lo consultó)
+func (s *monitorSalud) obtener(nodoID string) SaludNodo {
+	if s == nil {
+		return SaludNodo{Estado: CircuitoCerrado}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, existe := s.nodos[nodoID]; existe {
+		return e.SaludNodo
+	}
+	return SaludNodo{Estado: CircuitoCerrado}
+}
+
+// olvidar descarta los nodos que ya no están registrados
+func (s *monitorSalud) olvidar(registrados map[string]bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for nodoID := range s.nodos {
+		if !registrados[nodoID] {
+			delete(s.nodos, nodoID)
+		}
+	}
+}
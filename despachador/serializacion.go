@@ -1,5 +1,7 @@
 package despachador
 
+import "github.com/cbiale/sensorwave/tipos"
+
 // ============================================================================
 // TIPOS DE RESPUESTA DE LA API REST
 // Estos structs son usados por los handlers para serializar respuestas JSON
@@ -7,8 +9,62 @@ package despachador
 
 // StatusResponse respuesta del endpoint /api/status
 type StatusResponse struct {
-	NumNodos  int `json:"num_nodos"`
-	NumSeries int `json:"num_series"`
+	NumNodos        int                          `json:"num_nodos"`
+	NumSeries       int                          `json:"num_series"`
+	CacheBloques    *EstadisticasCacheBloques    `json:"cache_bloques,omitempty"`
+	CacheResultados *EstadisticasCacheResultados `json:"cache_resultados,omitempty"`
+
+	RegistrosRechazados []RegistroRechazado `json:"registros_rechazados,omitempty"`
+}
+
+// InscripcionRequest request para inscribir la clave pública de un nodo.
+// Sin clave se inscribe la presentada por el registro rechazado del nodo.
+type InscripcionRequest struct {
+	ClavePublica string `json:"clave_publica,omitempty"`
+}
+
+// ClaveAPIRequest request para crear una clave API
+type ClaveAPIRequest struct {
+	Nombre   string            `json:"nombre"`
+	Rol      Rol               `json:"rol"`
+	Series   []string          `json:"series,omitempty"`    // Patrones de series permitidos (vacío = todas)
+	TagsNodo map[string]string `json:"tags_nodo,omitempty"` // Tags requeridos en el nodo (vacío = todos)
+	Expira   int64             `json:"expira,omitempty"`    // Unix nanosegundos (0 = no expira)
+}
+
+// ClaveAPIResponse respuesta con una clave API. Token solo se informa al crearla.
+type ClaveAPIResponse struct {
+	ID       string            `json:"id"`
+	Nombre   string            `json:"nombre"`
+	Rol      Rol               `json:"rol"`
+	Series   []string          `json:"series,omitempty"`
+	TagsNodo map[string]string `json:"tags_nodo,omitempty"`
+	Creada   int64             `json:"creada"`
+	Expira   int64             `json:"expira,omitempty"`
+	Token    string            `json:"token,omitempty"`
+}
+
+// NodoResponse respuesta con la información de un nodo, su salud y su estado de conexión
+type NodoResponse struct {
+	tipos.Nodo
+	Salud          SaludNodo      `json:"salud"`
+	Conexion       EstadoConexion `json:"conexion"`
+	TunelConectado bool           `json:"tunel_conectado,omitempty"` // El nodo tiene un túnel abierto
+}
+
+// ComandoResponse respuesta con el estado de un comando enviado a un nodo.
+// Con estado "pendiente" el comando está encolado hasta el próximo latido del nodo.
+type ComandoResponse struct {
+	ID       string              `json:"id"`
+	NodoID   string              `json:"nodo_id"`
+	Tipo     tipos.TipoComando   `json:"tipo"`
+	Objetivo string              `json:"objetivo"` // ID de la regla o path de la serie
+	Estado   tipos.EstadoComando `json:"estado"`
+	Creado   int64               `json:"creado"`             // Unix nanosegundos
+	Aplicado int64               `json:"aplicado,omitempty"` // Unix nanosegundos
+	Regla    *ReglaResponse      `json:"regla,omitempty"`    // Regla resultante si se aplicó
+	Serie    *SerieResponse      `json:"serie,omitempty"`    // Serie resultante si se aplicó
+	Motivo   string              `json:"motivo,omitempty"`   // Por qué quedó pendiente o se rechazó
 }
 
 // SerieResponse respuesta con información de serie para JSON
@@ -25,9 +81,10 @@ type SerieResponse struct {
 
 // ConsultaRangoRequest solicitud de consulta por rango
 type ConsultaRangoRequest struct {
-	Serie        string `json:"serie"`
-	TiempoInicio int64  `json:"tiempo_inicio"` // Unix nanosegundos
-	TiempoFin    int64  `json:"tiempo_fin"`    // Unix nanosegundos
+	Serie        string                `json:"serie"`
+	TiempoInicio tipos.MarcaTiempo     `json:"tiempo_inicio"`     // Unix nanosegundos, RFC3339 o relativo (now-1h)
+	TiempoFin    tipos.MarcaTiempo     `json:"tiempo_fin"`        // Unix nanosegundos, RFC3339 o relativo (now-1h)
+	Ventana      *tipos.FuncionVentana `json:"ventana,omitempty"` // Función de ventana opcional
 }
 
 // ConsultaRangoResponse respuesta de consulta por rango
@@ -40,9 +97,9 @@ type ConsultaRangoResponse struct {
 
 // ConsultaUltimoRequest solicitud de consulta de último punto
 type ConsultaUltimoRequest struct {
-	Serie        string `json:"serie"`
-	TiempoInicio *int64 `json:"tiempo_inicio,omitempty"` // Unix nanosegundos, opcional
-	TiempoFin    *int64 `json:"tiempo_fin,omitempty"`    // Unix nanosegundos, opcional
+	Serie        string             `json:"serie"`
+	TiempoInicio *tipos.MarcaTiempo `json:"tiempo_inicio,omitempty"` // Unix nanosegundos, RFC3339 o relativo, opcional
+	TiempoFin    *tipos.MarcaTiempo `json:"tiempo_fin,omitempty"`    // Unix nanosegundos, RFC3339 o relativo, opcional
 }
 
 // ConsultaUltimoResponse respuesta de consulta de último punto
@@ -55,10 +112,10 @@ type ConsultaUltimoResponse struct {
 
 // ConsultaAgregacionRequest solicitud de consulta de agregación
 type ConsultaAgregacionRequest struct {
-	Serie        string   `json:"serie"`
-	TiempoInicio int64    `json:"tiempo_inicio"` // Unix nanosegundos
-	TiempoFin    int64    `json:"tiempo_fin"`    // Unix nanosegundos
-	Agregaciones []string `json:"agregaciones"`  // "promedio", "maximo", "minimo", "suma", "count"
+	Serie        string            `json:"serie"`
+	TiempoInicio tipos.MarcaTiempo `json:"tiempo_inicio"` // Unix nanosegundos, RFC3339 o relativo (now-1h)
+	TiempoFin    tipos.MarcaTiempo `json:"tiempo_fin"`    // Unix nanosegundos, RFC3339 o relativo (now-1h)
+	Agregaciones []string          `json:"agregaciones"`  // "promedio", "maximo", "minimo", "suma", "count"
 }
 
 // ConsultaAgregacionResponse respuesta de consulta de agregación
@@ -71,11 +128,14 @@ type ConsultaAgregacionResponse struct {
 
 // ConsultaAgregacionTemporalRequest solicitud de consulta de agregación temporal
 type ConsultaAgregacionTemporalRequest struct {
-	Serie        string   `json:"serie"`
-	TiempoInicio int64    `json:"tiempo_inicio"` // Unix nanosegundos
-	TiempoFin    int64    `json:"tiempo_fin"`    // Unix nanosegundos
-	Agregaciones []string `json:"agregaciones"`  // "promedio", "maximo", "minimo", "suma", "count"
-	Intervalo    int64    `json:"intervalo"`     // Duration en nanosegundos
+	Serie        string            `json:"serie"`
+	TiempoInicio tipos.MarcaTiempo `json:"tiempo_inicio"` // Unix nanosegundos, RFC3339 o relativo (now-1h)
+	TiempoFin    tipos.MarcaTiempo `json:"tiempo_fin"`    // Unix nanosegundos, RFC3339 o relativo (now-1h)
+	Agregaciones []string          `json:"agregaciones"`  // "promedio", "maximo", "minimo", "suma", "count"
+	Intervalo    tipos.Duracion    `json:"intervalo"`     // Nanosegundos o duración legible ("15m")
+
+	Ventana *tipos.FuncionVentana `json:"ventana,omitempty"` // Función de ventana opcional
+	Alinear bool                  `json:"alinear,omitempty"` // Alinea los buckets a múltiplos del intervalo (ver AlinearRangoBuckets)
 }
 
 // ConsultaAgregacionTemporalResponse respuesta de consulta de agregación temporal
@@ -86,3 +146,22 @@ type ConsultaAgregacionTemporalResponse struct {
 	Valores            [][][]FloatNulo `json:"valores"` // [agregacion][bucket][serie]
 	NodosNoDisponibles []string        `json:"nodos_no_disponibles,omitempty"`
 }
+
+// ConsultaComparacionRequest solicitud de comparación entre un período y el mismo período desplazado
+type ConsultaComparacionRequest struct {
+	Serie          string            `json:"serie"`
+	TiempoInicio   tipos.MarcaTiempo `json:"tiempo_inicio"`  // Unix nanosegundos, RFC3339 o relativo (now-1h)
+	TiempoFin      tipos.MarcaTiempo `json:"tiempo_fin"`     // Unix nanosegundos, RFC3339 o relativo (now-1h)
+	Agregaciones   []string          `json:"agregaciones"`   // "promedio", "maximo", "minimo", "suma", "count"
+	Intervalo      tipos.Duracion    `json:"intervalo"`      // Nanosegundos o duración legible ("15m")
+	Desplazamiento tipos.Duracion    `json:"desplazamiento"` // Desplazamiento hacia atrás, nanosegundos o legible ("7d")
+}
+
+// ConsultaComparacionResponse respuesta de comparación entre períodos.
+// Los buckets de ambos períodos están alineados por índice (tiempo relativo).
+type ConsultaComparacionResponse struct {
+	Actual           ConsultaAgregacionTemporalResponse `json:"actual"`
+	Anterior         ConsultaAgregacionTemporalResponse `json:"anterior"`
+	Desplazamiento   int64                              `json:"desplazamiento"`    // Duration en nanosegundos
+	TiemposRelativos []int64                            `json:"tiempos_relativos"` // Offset de cada bucket desde el inicio
+}
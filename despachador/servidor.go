@@ -0,0 +1,282 @@
+package despachador
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// SERVIDOR HTTP DEL DESPACHADOR
+// NuevoServidor registra todos los handlers de la API REST con sus rutas (patrones
+// de Go 1.22), el rol mínimo de cada una y los middlewares comunes: registro de
+// solicitudes, recuperación de pánicos, CORS y límite del cuerpo. La
+// especificación OpenAPI se sirve en /api/openapi.json.
+// ============================================================================
+
+//go:embed openapi.json
+var especificacionOpenAPI []byte
+
+// OpcionesServidor configura el servidor HTTP del despachador
+type OpcionesServidor struct {
+	Direccion string // Dirección de escucha (default: ":8080")
+
+	// OrigenesCORS son los orígenes a los que se permite acceder desde un navegador.
+	// "*" permite cualquiera; vacío = sin encabezados CORS.
+	OrigenesCORS []string
+
+	TamañoMaximoCuerpo int64 // Tamaño máximo del cuerpo de las solicitudes en bytes (default: 1 MiB)
+
+	TimeoutLectura     time.Duration // Lectura de la solicitud completa (default: 15s)
+	TimeoutEscritura   time.Duration // Escritura de la respuesta (default: 60s, las consultas pueden ser largas)
+	TimeoutInactividad time.Duration // Conexiones keep-alive inactivas (default: 120s)
+
+	SinRegistroSolicitudes bool // Deshabilita el log de cada solicitud
+}
+
+// aplicarDefaults completa las opciones no configuradas
+func (o *OpcionesServidor) aplicarDefaults() {
+	if o.Direccion == "" {
+		o.Direccion = ":8080"
+	}
+	if o.TamañoMaximoCuerpo <= 0 {
+		o.TamañoMaximoCuerpo = 1 << 20
+	}
+	if o.TimeoutLectura <= 0 {
+		o.TimeoutLectura = 15 * time.Second
+	}
+	if o.TimeoutEscritura <= 0 {
+		o.TimeoutEscritura = 60 * time.Second
+	}
+	if o.TimeoutInactividad <= 0 {
+		o.TimeoutInactividad = 120 * time.Second
+	}
+}
+
+// Servidor es el servidor HTTP de la API REST del despachador
+type Servidor struct {
+	servidor *http.Server
+}
+
+// ruta asocia un patrón con su handler y el rol mínimo requerido ("" = pública)
+type ruta struct {
+	patron  string // Patrón de http.ServeMux: "MÉTODO /ruta/{parametro}"
+	rol     Rol
+	handler func(*ManagerDespachador) http.HandlerFunc
+}
+
+// rutasAPI es la tabla de rutas de la API REST. Debe coincidir con openapi.json.
+var rutasAPI = []ruta{
+	{"GET /api/status", RolLector, HandlerStatus},
+	{"GET /api/nodos", RolLector, HandlerListarNodos},
+	{"GET /api/nodos/{nodoID}/reglas", RolLector, HandlerListarReglasPorNodo},
+	{"POST /api/nodos/{nodoID}/reglas", RolOperador, HandlerCrearRegla},
+	{"PUT /api/nodos/{nodoID}/reglas/{id}", RolOperador, HandlerActualizarRegla},
+	{"DELETE /api/nodos/{nodoID}/reglas/{id}", RolOperador, HandlerEliminarRegla},
+	{"POST /api/nodos/{nodoID}/reglas/{id}/habilitar", RolOperador, HandlerHabilitarRegla(true)},
+	{"POST /api/nodos/{nodoID}/reglas/{id}/deshabilitar", RolOperador, HandlerHabilitarRegla(false)},
+	{"POST /api/nodos/{nodoID}/series", RolOperador, HandlerCrearSerie},
+	{"PUT /api/nodos/{nodoID}/series/{path...}", RolOperador, HandlerActualizarSerie},
+	{"DELETE /api/nodos/{nodoID}/series/{path...}", RolOperador, HandlerEliminarSerie},
+	{"GET /api/nodos/{nodoID}/comandos", RolLector, HandlerListarComandos},
+	{"GET /api/nodos/{nodoID}/comandos/{id}", RolLector, HandlerObtenerComando},
+	{"POST /api/nodos/{nodoID}/inscripcion", RolAdministrador, HandlerInscribirNodo},
+	{"DELETE /api/nodos/{nodoID}/inscripcion", RolAdministrador, HandlerRevocarNodo},
+
+	{"GET /api/series", RolLector, HandlerListarSeries},
+	{"GET /api/series/{path...}", RolLector, HandlerObtenerSerie},
+
+	{"POST /api/consulta/rango", RolLector, HandlerConsultarRango},
+	{"POST /api/consulta/ultimo", RolLector, HandlerConsultarUltimo},
+	{"POST /api/consulta/agregacion", RolLector, HandlerConsultarAgregacion},
+	{"POST /api/consulta/agregacion-temporal", RolLector, HandlerConsultarAgregacionTemporal},
+	{"POST /api/consulta/comparacion", RolLector, HandlerConsultarComparacion},
+
+	{"GET /api/reglas", RolLector, HandlerListarReglas},
+	{"GET /api/reglas/{id}", RolLector, HandlerObtenerRegla},
+
+	{"GET /api/claves", RolAdministrador, HandlerListarClavesAPI},
+	{"POST /api/claves", RolAdministrador, HandlerCrearClaveAPI},
+	{"DELETE /api/claves/{id}", RolAdministrador, HandlerRevocarClaveAPI},
+
+	{"GET /api/tunel", "", HandlerTunel}, // Autenticada con la firma del nodo
+
+	{"GET /api/openapi.json", "", func(*ManagerDespachador) http.HandlerFunc { return handlerOpenAPI }},
+}
+
+// NuevoServidor crea el servidor HTTP con todas las rutas de la API REST.
+// El servidor no escucha hasta llamar a Iniciar.
+func NuevoServidor(manager *ManagerDespachador, opts OpcionesServidor) *Servidor {
+	opts.aplicarDefaults()
+
+	return &Servidor{
+		servidor: &http.Server{
+			Addr:              opts.Direccion,
+			Handler:           nuevoRouter(manager, opts),
+			ReadHeaderTimeout: opts.TimeoutLectura,
+			ReadTimeout:       opts.TimeoutLectura,
+			WriteTimeout:      opts.TimeoutEscritura,
+			IdleTimeout:       opts.TimeoutInactividad,
+		},
+	}
+}
+
+// nuevoRouter registra las rutas y aplica los middlewares
+func nuevoRouter(manager *ManagerDespachador, opts OpcionesServidor) http.Handler {
+	mux := http.NewServeMux()
+	for _, r := range rutasAPI {
+		handler := r.handler(manager)
+		if r.rol != "" {
+			handler = RequerirRol(manager, r.rol, handler)
+		}
+		mux.Handle(r.patron, handler)
+	}
+
+	var handler http.Handler = mux
+	handler = limitarCuerpo(opts.TamañoMaximoCuerpo)(handler)
+	handler = cors(opts.OrigenesCORS)(handler)
+	handler = recuperarPanicos(handler)
+	if !opts.SinRegistroSolicitudes {
+		handler = registrarSolicitudes(handler)
+	}
+	return handler
+}
+
+// Handler retorna el handler con las rutas y los middlewares, para montarlo en otro servidor
+func (s *Servidor) Handler() http.Handler {
+	return s.servidor.Handler
+}
+
+// Iniciar escucha y atiende solicitudes hasta que se llama a Cerrar.
+// Retorna nil si el servidor se cerró con Cerrar.
+func (s *Servidor) Iniciar() error {
+	log.Printf("Servidor HTTP del despachador escuchando en %s", s.servidor.Addr)
+	if err := s.servidor.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error en servidor HTTP: %v", err)
+	}
+	return nil
+}
+
+// Cerrar deja de aceptar conexiones y espera a que terminen las solicitudes en curso
+// hasta que se cancele ctx
+func (s *Servidor) Cerrar(ctx context.Context) error {
+	log.Printf("Cerrando servidor HTTP del despachador...")
+	return s.servidor.Shutdown(ctx)
+}
+
+// handlerOpenAPI sirve la especificación OpenAPI de la API REST
+func handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(especificacionOpenAPI)
+}
+
+// ============================================================================
+// MIDDLEWARES
+// ============================================================================
+
+// respuestaRegistrada captura el código de estado de la respuesta
+type respuestaRegistrada struct {
+	http.ResponseWriter
+	codigo int
+}
+
+func (r *respuestaRegistrada) WriteHeader(codigo int) {
+	if r.codigo == 0 {
+		r.codigo = codigo
+	}
+	r.ResponseWriter.WriteHeader(codigo)
+}
+
+func (r *respuestaRegistrada) Write(datos []byte) (int, error) {
+	if r.codigo == 0 {
+		r.codigo = http.StatusOK
+	}
+	return r.ResponseWriter.Write(datos)
+}
+
+// Unwrap permite a http.ResponseController llegar a la respuesta original (Hijack del túnel)
+func (r *respuestaRegistrada) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// registrarSolicitudes registra el método, la ruta, el código y la duración de cada solicitud
+func registrarSolicitudes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		comienzo := time.Now()
+		respuesta := &respuestaRegistrada{ResponseWriter: w}
+		next.ServeHTTP(respuesta, r)
+		if respuesta.codigo == 0 {
+			respuesta.codigo = http.StatusOK
+		}
+		log.Printf("%s %s %d %v", r.Method, r.URL.Path, respuesta.codigo, time.Since(comienzo))
+	})
+}
+
+// recuperarPanicos responde 500 si un handler entra en pánico, en lugar de cortar la conexión
+func recuperarPanicos(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				log.Printf("Pánico atendiendo %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+				EnviarError(w, http.StatusInternalServerError, "error interno")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors agrega los encabezados CORS para los orígenes permitidos y responde las
+// solicitudes de verificación previa (OPTIONS)
+func cors(origenes []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(origenes) == 0 {
+			return next
+		}
+		cualquiera := slices.Contains(origenes, "*")
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origen := r.Header.Get("Origin")
+			if origen == "" || (!cualquiera && !slices.Contains(origenes, origen)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origen)
+			w.Header().Add("Vary", "Origin")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitarCuerpo rechaza con 413 los cuerpos declarados mayores al máximo y corta la
+// lectura de los que lo superan sin declararlo
+func limitarCuerpo(maximo int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maximo {
+				EnviarError(w, http.StatusRequestEntityTooLarge,
+					"el cuerpo supera el máximo de "+strconv.FormatInt(maximo, 10)+" bytes")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maximo)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package despachador
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// SINCRONIZACIÓN INCREMENTAL DEL REGISTRO DE NODOS
+// Cada sincronización lista nodos/ y latidos/ y descarga solo los objetos cuyo
+// ETag (o LastModified) cambió desde la sincronización anterior. Toda la E/S con
+// S3 se hace sin tomar m.mu: el mapa de nodos solo se bloquea para reemplazarlo,
+// de modo que las consultas no esperan a S3. Los cambios detectados se informan
+// como eventos.
+// ============================================================================
+
+// Intervalo por defecto entre sincronizaciones del registro de nodos
+const intervaloSincronizacionPorDefecto = 30 * time.Second
+
+// TipoEventoNodo identifica un cambio en el registro de nodos
+type TipoEventoNodo string
+
+const (
+	EventoNodoAgregado    TipoEventoNodo = "nodo_agregado"    // Nodo nuevo en el registro
+	EventoNodoEliminado   TipoEventoNodo = "nodo_eliminado"   // Nodo que dejó de estar registrado
+	EventoSeriesCambiadas TipoEventoNodo = "series_cambiadas" // El nodo agregó, quitó o modificó series
+)
+
+// EventoNodo describe un cambio detectado al sincronizar el registro de nodos
+type EventoNodo struct {
+	Tipo   TipoEventoNodo
+	NodoID string
+	Nodo   tipos.Nodo // Estado nuevo del nodo (el último conocido si fue eliminado)
+}
+
+// objetoSincronizado es un objeto del registro ya descargado junto con su versión
+type objetoSincronizado[T any] struct {
+	version string
+	valor   T
+}
+
+// estadoSincronizacion guarda los objetos descargados en la última sincronización.
+// El valor cero es válido.
+type estadoSincronizacion struct {
+	mu        sync.Mutex // Serializa las sincronizaciones
+	registros map[string]objetoSincronizado[registroNodo]
+	latidos   map[string]objetoSincronizado[tipos.Latido]
+}
+
+// versionObjeto identifica el contenido de un objeto listado: su ETag o, si el
+// backend no lo informa, su fecha de modificación y tamaño. "" = desconocida (se
+// descarga siempre).
+func versionObjeto(obj tipos.ObjetoAlmacenado) string {
+	if obj.ETag != "" {
+		return obj.ETag
+	}
+	if obj.Modificado != 0 {
+		return fmt.Sprintf("%d-%d", obj.Modificado, obj.Tamaño)
+	}
+	return ""
+}
+
+// sincronizarObjetos actualiza los objetos cacheados con el listado de S3: reutiliza los
+// que no cambiaron, descarga los nuevos o modificados y descarta los que ya no existen.
+// Si falla la descarga de un objeto modificado se conserva su versión anterior.
+// Retorna los objetos vigentes y la cantidad de descargas.
+func sincronizarObjetos[T any](ctx context.Context, m *ManagerDespachador, objetos []tipos.ObjetoAlmacenado,
+	anteriores map[string]objetoSincronizado[T], descripcion string) (map[string]objetoSincronizado[T], int) {
+
+	vigentes := make(map[string]objetoSincronizado[T], len(objetos))
+	descargas := 0
+	for _, obj := range objetos {
+		clave := obj.Clave
+		version := versionObjeto(obj)
+
+		anterior, existe := anteriores[clave]
+		if existe && version != "" && anterior.version == version {
+			vigentes[clave] = anterior
+			continue
+		}
+
+		var valor T
+		descargas++
+		if err := m.leerObjetoJSON(ctx, clave, &valor); err != nil {
+			log.Printf("Error obteniendo %s %s: %v", descripcion, clave, err)
+			if existe {
+				vigentes[clave] = anterior
+			}
+			continue
+		}
+		vigentes[clave] = objetoSincronizado[T]{version: version, valor: valor}
+	}
+	return vigentes, descargas
+}
+
+// leerObjetoJSON descarga un objeto del almacenamiento y lo deserializa en destino
+func (m *ManagerDespachador) leerObjetoJSON(ctx context.Context, clave string, destino interface{}) error {
+	data, _, err := m.almacenamiento.Leer(ctx, clave, "")
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, destino); err != nil {
+		return fmt.Errorf("error deserializando: %v", err)
+	}
+	return nil
+}
+
+// compararNodos retorna los eventos que llevan del registro anterior al nuevo,
+// ordenados por nodo
+func compararNodos(anteriores, nuevos map[string]*tipos.Nodo) []EventoNodo {
+	var eventos []EventoNodo
+	for nodoID, nodo := range nuevos {
+		anterior, existe := anteriores[nodoID]
+		switch {
+		case !existe:
+			eventos = append(eventos, EventoNodo{Tipo: EventoNodoAgregado, NodoID: nodoID, Nodo: *nodo})
+		case !seriesIguales(anterior.Series, nodo.Series):
+			eventos = append(eventos, EventoNodo{Tipo: EventoSeriesCambiadas, NodoID: nodoID, Nodo: *nodo})
+		}
+	}
+	for nodoID, nodo := range anteriores {
+		if _, existe := nuevos[nodoID]; !existe {
+			eventos = append(eventos, EventoNodo{Tipo: EventoNodoEliminado, NodoID: nodoID, Nodo: *nodo})
+		}
+	}
+
+	sort.Slice(eventos, func(i, j int) bool {
+		if eventos[i].NodoID != eventos[j].NodoID {
+			return eventos[i].NodoID < eventos[j].NodoID
+		}
+		return eventos[i].Tipo < eventos[j].Tipo
+	})
+	return eventos
+}
+
+// seriesIguales compara las series de dos versiones de un nodo
+func seriesIguales(a, b map[string]tipos.Serie) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, serie := range a {
+		otra, existe := b[path]
+		if !existe || !reflect.DeepEqual(serie, otra) {
+			return false
+		}
+	}
+	return true
+}
+
+// notificarEventos registra y publica los cambios del registro de nodos
+func (m *ManagerDespachador) notificarEventos(eventos []EventoNodo) {
+	for _, evento := range eventos {
+		log.Printf("Registro de nodos: %s %s", evento.Tipo, evento.NodoID)
+		if m.alCambiarNodos != nil {
+			m.alCambiarNodos(evento)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package despachador
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// TLS HACIA LOS NODOS EDGE
+// Los nodos con esquema https se verifican de dos formas:
+//   - Con certificado autofirmado: el registro del nodo (firmado) publica la huella
+//     SHA-256 del certificado y la conexión se acepta solo si coincide.
+//   - Sin huella: verificación estándar contra las raíces del sistema o la CA
+//     configurada en OpcionesTLS.ArchivoCA.
+// ============================================================================
+
+// OpcionesTLS configura la verificación de los certificados de los nodos edge
+type OpcionesTLS struct {
+	// ArchivoCA es un archivo PEM con los certificados de CA que firman los
+	// certificados de los nodos. "" = raíces del sistema.
+	ArchivoCA string
+}
+
+// cargarRaices carga los certificados de ArchivoCA (nil = raíces del sistema)
+func (o OpcionesTLS) cargarRaices() (*x509.CertPool, error) {
+	if o.ArchivoCA == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(o.ArchivoCA)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo ArchivoCA: %v", err)
+	}
+	raices := x509.NewCertPool()
+	if !raices.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("ArchivoCA %s no contiene certificados PEM", o.ArchivoCA)
+	}
+	return raices, nil
+}
+
+// huellaNodo retorna la huella del certificado publicada por un nodo ("" = sin huella)
+func (m *ManagerDespachador) huellaNodo(nodoID string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if nodo, existe := m.nodos[nodoID]; existe {
+		return nodo.HuellaCertificado
+	}
+	return ""
+}
+
+// clientePara retorna el cliente HTTP para un nodo: el de su túnel si lo usa, uno
+// fijado a la huella de su certificado si la publicó, o el cliente general
+func (c *clienteEdgeHTTP) clientePara(nodoID string) *http.Client {
+	if c.clienteTunel != nil {
+		if cliente := c.clienteTunel(nodoID); cliente != nil {
+			return cliente
+		}
+	}
+	if c.huellaNodo == nil {
+		return c.httpClient
+	}
+	huella := c.huellaNodo(nodoID)
+	if huella == "" {
+		return c.httpClient
+	}
+
+	c.muFijados.Lock()
+	defer c.muFijados.Unlock()
+	if cliente, existe := c.fijados[huella]; existe {
+		return cliente
+	}
+	if c.fijados == nil {
+		c.fijados = make(map[string]*http.Client)
+	}
+	// Mismos tiempos de espera y límites de conexiones que el cliente general
+	base, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transporte := base.Clone()
+	transporte.TLSClientConfig = tipos.ConfiguracionTLSFijada(huella)
+	cliente := &http.Client{
+		Timeout:   c.httpClient.Timeout,
+		Transport: transporte,
+	}
+	c.fijados[huella] = cliente
+	return cliente
+}
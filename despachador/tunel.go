@@ -0,0 +1,239 @@
+package despachador
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// TÚNELES INVERSOS DE LOS NODOS EDGE
+// Los nodos detrás de NAT abren una conexión a tipos.RutaTunel firmada con el
+// secreto de OpcionesAutenticacion; se rechazan las aperturas sin firma válida o
+// de nodos no registrados. El despachador la toma (Hijack), responde
+// 101 y la usa como cliente HTTP/2: las consultas y comandos al nodo viajan
+// como streams multiplexados sobre esa única conexión. Los nodos con Tunel en
+// su registro se consultan solo por su túnel.
+// ============================================================================
+
+const (
+	// timeoutSolicitudTunel es el timeout de cada solicitud por un túnel, igual
+	// al de las solicitudes directas
+	timeoutSolicitudTunel = 10 * time.Second
+
+	// Sin tráfico durante intervaloPingTunel se verifica la conexión con un PING,
+	// que se da por perdida si no hay respuesta en timeoutPingTunel
+	intervaloPingTunel = 30 * time.Second
+	timeoutPingTunel   = 15 * time.Second
+)
+
+// tunel es la conexión abierta por un nodo y el cliente que la usa
+type tunel struct {
+	conexion *http2.ClientConn
+	cliente  *http.Client
+}
+
+// registroTuneles guarda el túnel abierto por cada nodo
+type registroTuneles struct {
+	autenticacion OpcionesAutenticacion
+	transporte    *http2.Transport
+
+	mu            sync.Mutex
+	tuneles       map[string]*tunel                        // nodoID → túnel abierto
+	verificadores map[string]*tipos.VerificadorSolicitudes // nodoID → verificador de la apertura
+}
+
+// nuevoRegistroTuneles crea el registro que verifica las aperturas con los secretos indicados
+func nuevoRegistroTuneles(autenticacion OpcionesAutenticacion) *registroTuneles {
+	return &registroTuneles{
+		autenticacion: autenticacion,
+		transporte: &http2.Transport{
+			ReadIdleTimeout: intervaloPingTunel,
+			PingTimeout:     timeoutPingTunel,
+		},
+		tuneles:       make(map[string]*tunel),
+		verificadores: make(map[string]*tipos.VerificadorSolicitudes),
+	}
+}
+
+// errTunelSinSecreto indica que no hay secreto con el que verificar la apertura del túnel
+var errTunelSinSecreto = errors.New("sin secreto configurado para el nodo: los túneles requieren autenticación")
+
+// verificar comprueba la firma de la solicitud de apertura del nodo. A diferencia de
+// las solicitudes al nodo, sin secreto configurado se rechaza: el túnel reemplaza al
+// anterior del nodo, por lo que una apertura sin firma permitiría suplantarlo.
+func (t *registroTuneles) verificar(r *http.Request, nodoID string) error {
+	secreto := t.autenticacion.secreto(nodoID)
+	if secreto == nil {
+		return errTunelSinSecreto
+	}
+
+	t.mu.Lock()
+	verificador, existe := t.verificadores[nodoID]
+	if !existe {
+		// Un verificador por nodo para recordar los nonces entre aperturas
+		verificador = tipos.NuevoVerificadorSolicitudes(nodoID, secreto, 0)
+		t.verificadores[nodoID] = verificador
+	}
+	t.mu.Unlock()
+
+	return verificador.Verificar(r, nil)
+}
+
+// abrir completa el Upgrade sobre la conexión tomada del servidor HTTP y registra
+// el túnel del nodo, reemplazando el anterior si lo había
+func (t *registroTuneles) abrir(nodoID string, conexion net.Conn, buffer *bufio.ReadWriter) error {
+	// El servidor HTTP deja fijados sus timeouts en la conexión tomada
+	conexion.SetDeadline(time.Time{})
+
+	fmt.Fprintf(buffer, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", tipos.ProtocoloTunel)
+	if err := buffer.Flush(); err != nil {
+		return fmt.Errorf("error respondiendo el Upgrade: %v", err)
+	}
+
+	clienteConexion, err := t.transporte.NewClientConn(tipos.ConexionConLector(conexion, buffer.Reader))
+	if err != nil {
+		return fmt.Errorf("error iniciando HTTP/2 sobre el túnel: %v", err)
+	}
+
+	t.mu.Lock()
+	anterior := t.tuneles[nodoID]
+	t.tuneles[nodoID] = &tunel{
+		conexion: clienteConexion,
+		cliente:  &http.Client{Transport: clienteConexion, Timeout: timeoutSolicitudTunel},
+	}
+	t.mu.Unlock()
+
+	if anterior != nil {
+		anterior.conexion.Close()
+	}
+	return nil
+}
+
+// cliente retorna el cliente HTTP por el túnel del nodo (nil = sin túnel abierto).
+// Los túneles cortados se descartan.
+func (t *registroTuneles) cliente(nodoID string) *http.Client {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	abierto, existe := t.tuneles[nodoID]
+	if !existe {
+		return nil
+	}
+	if !abierto.conexion.CanTakeNewRequest() {
+		delete(t.tuneles, nodoID)
+		abierto.conexion.Close()
+		return nil
+	}
+	return abierto.cliente
+}
+
+// cerrar corta todos los túneles abiertos
+func (t *registroTuneles) cerrar() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for nodoID, abierto := range t.tuneles {
+		abierto.conexion.Close()
+		delete(t.tuneles, nodoID)
+	}
+}
+
+// sinTunel responde con error a las solicitudes a un nodo accesible solo por túnel
+// que no tiene uno abierto, en lugar de intentar una conexión directa
+type sinTunel string
+
+func (nodoID sinTunel) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, fmt.Errorf("el nodo %s no tiene un túnel abierto", string(nodoID))
+}
+
+// clienteTunel retorna el cliente HTTP con el que se llega a un nodo por su túnel.
+// nil = el nodo no tiene túnel y se consulta directamente.
+func (m *ManagerDespachador) clienteTunel(nodoID string) *http.Client {
+	if cliente := m.tuneles.cliente(nodoID); cliente != nil {
+		return cliente
+	}
+
+	m.mu.RLock()
+	nodo, existe := m.nodos[nodoID]
+	m.mu.RUnlock()
+	if existe && nodo.Tunel {
+		return &http.Client{Transport: sinTunel(nodoID)}
+	}
+	return nil
+}
+
+// TunelConectado indica si el nodo tiene un túnel abierto con el despachador
+func (m *ManagerDespachador) TunelConectado(nodoID string) bool {
+	return m.tuneles.cliente(nodoID) != nil
+}
+
+// HandlerTunel maneja GET /api/tunel: un nodo edge registrado abre su túnel inverso.
+// La solicitud se autentica con la firma del nodo, no con los roles de la API.
+func HandlerTunel(manager *ManagerDespachador) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), tipos.ProtocoloTunel) {
+			w.Header().Set("Upgrade", tipos.ProtocoloTunel)
+			EnviarError(w, http.StatusUpgradeRequired, "se requiere Upgrade: "+tipos.ProtocoloTunel)
+			return
+		}
+		nodoID := r.Header.Get(tipos.EncabezadoNodo)
+		if nodoID == "" {
+			EnviarError(w, http.StatusBadRequest, "falta el encabezado "+tipos.EncabezadoNodo)
+			return
+		}
+		if manager.tuneles == nil {
+			EnviarError(w, http.StatusServiceUnavailable, "el despachador no admite túneles")
+			return
+		}
+		if err := manager.tuneles.verificar(r, nodoID); err != nil {
+			log.Printf("Túnel rechazado para el nodo %s desde %s: %v", nodoID, r.RemoteAddr, err)
+			EnviarError(w, http.StatusUnauthorized, "no autorizado")
+			return
+		}
+		manager.mu.RLock()
+		_, registrado := manager.nodos[nodoID]
+		manager.mu.RUnlock()
+		if !registrado {
+			log.Printf("Túnel rechazado para el nodo %s desde %s: nodo no registrado", nodoID, r.RemoteAddr)
+			EnviarError(w, http.StatusForbidden, "nodo no registrado")
+			return
+		}
+
+		// Descartar el cuerpo (vacío) antes de tomar la conexión
+		io.Copy(io.Discard, r.Body)
+
+		conexion, buffer, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			log.Printf("Error tomando la conexión del túnel del nodo %s: %v", nodoID, err)
+			EnviarError(w, http.StatusInternalServerError, "el servidor no admite túneles")
+			return
+		}
+		if err := manager.tuneles.abrir(nodoID, conexion, buffer); err != nil {
+			log.Printf("Error abriendo el túnel del nodo %s: %v", nodoID, err)
+			conexion.Close()
+			return
+		}
+		log.Printf("Túnel abierto por el nodo %s desde %s", nodoID, r.RemoteAddr)
+	}
+}
@@ -0,0 +1,213 @@
+package edge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// API DE ADMINISTRACIÓN DEL NODO
+// Endpoints JSON para herramientas de operación (swctl): series, reglas y
+// migración. Comparten la autenticación HMAC de las consultas, por lo que solo
+// puede usarlos quien conoce el secreto del nodo.
+// ============================================================================
+
+// registrarHandlersAdministracion agrega los endpoints de administración al mux
+func (me *ManagerEdge) registrarHandlersAdministracion(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/series", me.handleListarSeries)
+	mux.HandleFunc("POST /api/series", me.handleCrearSerie)
+	mux.HandleFunc("PUT /api/series/{path...}", me.handleActualizarSerie)
+	mux.HandleFunc("DELETE /api/series/{path...}", me.handleEliminarSerie)
+	mux.HandleFunc("GET /api/reglas", me.handleListarReglas)
+	mux.HandleFunc("POST /api/reglas/{id}/habilitar", me.handleHabilitarRegla(true))
+	mux.HandleFunc("POST /api/reglas/{id}/deshabilitar", me.handleHabilitarRegla(false))
+	mux.HandleFunc("GET /api/migracion", me.handleEstadoMigracion)
+	mux.HandleFunc("POST /api/migracion", me.handleMigrar)
+	mux.HandleFunc("POST /api/comandos", me.handleComando)
+}
+
+// handleListarSeries retorna las series del nodo ordenadas por path
+func (me *ManagerEdge) handleListarSeries(w http.ResponseWriter, r *http.Request) {
+	me.cache.mu.RLock()
+	series := make([]tipos.Serie, 0, len(me.cache.datos))
+	for _, serie := range me.cache.datos {
+		series = append(series, serie)
+	}
+	me.cache.mu.RUnlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Path < series[j].Path
+	})
+	enviarRespuesta(w, true, series)
+}
+
+// handleCrearSerie crea una serie. Los campos de compresión y el tamaño de bloque
+// son opcionales (mismos defaults que el aprovisionamiento).
+func (me *ManagerEdge) handleCrearSerie(w http.ResponseWriter, r *http.Request) {
+	cuerpo, err := io.ReadAll(r.Body)
+	if err != nil {
+		enviarRespuestaError(w, "Error leyendo body: "+err.Error())
+		return
+	}
+	var serie tipos.Serie
+	if err := json.Unmarshal(cuerpo, &serie); err != nil {
+		enviarRespuestaError(w, "Error deserializando serie: "+err.Error())
+		return
+	}
+	serie = serieConDefaults(serie)
+
+	if _, err := me.ObtenerSeries(serie.Path); err == nil {
+		http.Error(w, "la serie ya existe: "+serie.Path, http.StatusConflict)
+		return
+	}
+	if err := me.CrearSerie(serie); err != nil {
+		enviarRespuestaError(w, err.Error())
+		return
+	}
+
+	creada, err := me.ObtenerSeries(serie.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(creada)
+}
+
+// handleActualizarSerie reemplaza los tags y el tiempo de almacenamiento de una serie.
+// Los campos que definen el formato de los bloques pueden omitirse pero no cambiar.
+func (me *ManagerEdge) handleActualizarSerie(w http.ResponseWriter, r *http.Request) {
+	cuerpo, err := io.ReadAll(r.Body)
+	if err != nil {
+		enviarRespuestaError(w, "Error leyendo body: "+err.Error())
+		return
+	}
+	var serie tipos.Serie
+	if err := json.Unmarshal(cuerpo, &serie); err != nil {
+		enviarRespuestaError(w, "Error deserializando serie: "+err.Error())
+		return
+	}
+	path := r.PathValue("path")
+	if serie.Path != "" && serie.Path != path {
+		enviarRespuestaError(w, fmt.Sprintf("el path del body (%s) no coincide con el de la ruta (%s)", serie.Path, path))
+		return
+	}
+	serie.Path = path
+
+	actual, err := me.ObtenerSeries(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	serie = serieConFormatoDe(serie, actual)
+	if err := validarCambioSerie(actual, serie); err != nil {
+		enviarRespuestaError(w, err.Error())
+		return
+	}
+	if err := me.ActualizarSerie(serie); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	actualizada, err := me.ObtenerSeries(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	enviarRespuesta(w, true, actualizada)
+}
+
+// handleEliminarSerie elimina una serie y sus datos locales
+func (me *ManagerEdge) handleEliminarSerie(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	if _, err := me.ObtenerSeries(path); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := me.EliminarSerie(path); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListarReglas retorna las reglas del nodo ordenadas por ID
+func (me *ManagerEdge) handleListarReglas(w http.ResponseWriter, r *http.Request) {
+	reglas := make([]tipos.Regla, 0)
+	for _, regla := range me.MotorReglas.ListarReglas() {
+		reglas = append(reglas, reglaATipos(regla))
+	}
+	sort.Slice(reglas, func(i, j int) bool {
+		return reglas[i].ID < reglas[j].ID
+	})
+	enviarRespuesta(w, true, reglas)
+}
+
+// handleHabilitarRegla activa o desactiva una regla
+func (me *ManagerEdge) handleHabilitarRegla(activa bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if _, err := me.MotorReglas.ObtenerRegla(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := me.MotorReglas.HabilitarRegla(id, activa); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		regla, err := me.MotorReglas.ObtenerRegla(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		enviarRespuesta(w, true, reglaATipos(regla))
+	}
+}
+
+// handleMigrar inicia en segundo plano la migración por tiempo de almacenamiento y
+// responde 202 con el estado de la migración. El progreso y el informe se consultan con
+// GET /api/migracion: la migración no depende de la conexión del cliente, que puede
+// cortarse o agotar el timeout del túnel sin interrumpirla. Los bloques que no pudieron
+// migrarse figuran en el informe y se reintentan en la próxima ejecución.
+func (me *ManagerEdge) handleMigrar(w http.ResponseWriter, r *http.Request) {
+	if me.almacenamiento == nil {
+		http.Error(w, "S3 no está configurado", http.StatusConflict)
+		return
+	}
+	if !me.solicitarMigracion() {
+		http.Error(w, "ya hay una migración solicitada en curso", http.StatusConflict)
+		return
+	}
+	log.Printf("Migración solicitada desde %s", r.RemoteAddr)
+
+	estado, err := me.EstadoMigracion()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respuesta, err := json.Marshal(estado)
+	if err != nil {
+		http.Error(w, "Error serializando respuesta", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(respuesta)
+}
+
+// handleEstadoMigracion retorna el progreso de la migración en curso y el informe de la última
+func (me *ManagerEdge) handleEstadoMigracion(w http.ResponseWriter, r *http.Request) {
+	estado, err := me.EstadoMigracion()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	enviarRespuesta(w, true, estado)
+}
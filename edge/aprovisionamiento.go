@@ -0,0 +1,320 @@
+package edge
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// APROVISIONAMIENTO DECLARATIVO
+// Un manifiesto (YAML o JSON) declara las series y reglas que debe tener el nodo.
+// Aprovisionar lo compara con lo almacenado en PebbleDB y aplica las creaciones,
+// actualizaciones y (opcionalmente) eliminaciones necesarias. Aplicar dos veces el
+// mismo manifiesto no produce cambios.
+// ============================================================================
+
+// ManifiestoAprovisionamiento declara las series y reglas de un nodo
+type ManifiestoAprovisionamiento struct {
+	Series []tipos.Serie `json:"series"`
+	Reglas []tipos.Regla `json:"reglas"`
+}
+
+// manifiestoArchivo es el formato del archivo: igual al manifiesto, pero el tiempo de
+// almacenamiento de las series admite duraciones legibles ("7d")
+type manifiestoArchivo struct {
+	Series []struct {
+		tipos.Serie
+		TiempoAlmacenamiento tipos.Duracion `json:"tiempo_almacenamiento"`
+	} `json:"series"`
+	Reglas []tipos.Regla `json:"reglas"`
+}
+
+// DecodificarManifiesto decodifica un manifiesto YAML o JSON. Las claves que no
+// corresponden a series o reglas se ignoran, por lo que el manifiesto puede
+// formar parte de un archivo de configuración mayor.
+func DecodificarManifiesto(datos []byte) (ManifiestoAprovisionamiento, error) {
+	var archivo manifiestoArchivo
+	if err := tipos.DecodificarYAML(datos, &archivo); err != nil {
+		return ManifiestoAprovisionamiento{}, fmt.Errorf("error decodificando manifiesto: %v", err)
+	}
+
+	manifiesto := ManifiestoAprovisionamiento{Reglas: archivo.Reglas}
+	for _, s := range archivo.Series {
+		serie := s.Serie
+		serie.TiempoAlmacenamiento = int64(s.TiempoAlmacenamiento)
+		manifiesto.Series = append(manifiesto.Series, serie)
+	}
+	return manifiesto, nil
+}
+
+// CargarManifiesto lee y decodifica un archivo de manifiesto
+func CargarManifiesto(ruta string) (ManifiestoAprovisionamiento, error) {
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return ManifiestoAprovisionamiento{}, fmt.Errorf("error leyendo manifiesto: %v", err)
+	}
+	return DecodificarManifiesto(datos)
+}
+
+// OpcionesAprovisionamiento configura la aplicación de un manifiesto
+type OpcionesAprovisionamiento struct {
+	// EliminarAusentes elimina las series y reglas almacenadas que no figuran en el
+	// manifiesto. Eliminar una serie borra sus datos locales.
+	EliminarAusentes bool
+
+	// Simular calcula el plan sin aplicarlo
+	Simular bool
+}
+
+// OperacionAprovisionamiento es la operación de un cambio del plan
+type OperacionAprovisionamiento string
+
+const (
+	OperacionCrear      OperacionAprovisionamiento = "crear"
+	OperacionActualizar OperacionAprovisionamiento = "actualizar"
+	OperacionEliminar   OperacionAprovisionamiento = "eliminar"
+)
+
+// CambioAprovisionamiento es un cambio sobre una serie o una regla
+type CambioAprovisionamiento struct {
+	Operacion OperacionAprovisionamiento
+	Objeto    string   // "serie" o "regla"
+	ID        string   // Path de la serie o ID de la regla
+	Campos    []string // Campos modificados (solo actualizaciones)
+
+	serie tipos.Serie
+	regla *Regla
+}
+
+// String describe el cambio en una línea
+func (c CambioAprovisionamiento) String() string {
+	simbolo := map[OperacionAprovisionamiento]string{
+		OperacionCrear:      "+",
+		OperacionActualizar: "~",
+		OperacionEliminar:   "-",
+	}[c.Operacion]
+	linea := fmt.Sprintf("%s %s %s", simbolo, c.Objeto, c.ID)
+	if len(c.Campos) > 0 {
+		linea += " (" + strings.Join(c.Campos, ", ") + ")"
+	}
+	return linea
+}
+
+// PlanAprovisionamiento es la lista ordenada de cambios que aplica un manifiesto:
+// series creadas o actualizadas, reglas creadas o actualizadas, reglas eliminadas
+// y series eliminadas
+type PlanAprovisionamiento struct {
+	Cambios []CambioAprovisionamiento
+}
+
+// Vacio indica si el nodo ya coincide con el manifiesto
+func (p PlanAprovisionamiento) Vacio() bool {
+	return len(p.Cambios) == 0
+}
+
+// String describe el plan, un cambio por línea
+func (p PlanAprovisionamiento) String() string {
+	if p.Vacio() {
+		return "sin cambios"
+	}
+	lineas := make([]string, len(p.Cambios))
+	for i, cambio := range p.Cambios {
+		lineas[i] = cambio.String()
+	}
+	return strings.Join(lineas, "\n")
+}
+
+// Aprovisionar compara el manifiesto con las series y reglas del nodo y aplica los
+// cambios. Con opts.Simular solo retorna el plan. Si un cambio falla se detiene y
+// retorna el plan con el error; los cambios aplicados hasta entonces se mantienen y
+// volver a aprovisionar completa el resto.
+//
+// Las reglas aprovisionadas quedan activas. En las series solo pueden actualizarse
+// Tags y TiempoAlmacenamiento; los demás cambios producen un error antes de aplicar
+// nada.
+func (me *ManagerEdge) Aprovisionar(manifiesto ManifiestoAprovisionamiento, opts OpcionesAprovisionamiento) (PlanAprovisionamiento, error) {
+	plan, err := me.planificarAprovisionamiento(manifiesto, opts)
+	if err != nil {
+		return PlanAprovisionamiento{}, err
+	}
+	if opts.Simular || plan.Vacio() {
+		return plan, nil
+	}
+
+	for _, cambio := range plan.Cambios {
+		if err := me.aplicarCambio(cambio); err != nil {
+			return plan, fmt.Errorf("error al %s %s %s: %v", cambio.Operacion, cambio.Objeto, cambio.ID, err)
+		}
+	}
+	log.Printf("Aprovisionamiento aplicado: %d cambios", len(plan.Cambios))
+	return plan, nil
+}
+
+// planificarAprovisionamiento valida el manifiesto y calcula los cambios
+func (me *ManagerEdge) planificarAprovisionamiento(manifiesto ManifiestoAprovisionamiento, opts OpcionesAprovisionamiento) (PlanAprovisionamiento, error) {
+	var plan PlanAprovisionamiento
+
+	// Series creadas o actualizadas
+	me.cache.mu.RLock()
+	actuales := maps.Clone(me.cache.datos)
+	me.cache.mu.RUnlock()
+
+	declaradas := make(map[string]bool, len(manifiesto.Series))
+	for _, serie := range manifiesto.Series {
+		serie = serieConDefaults(serie)
+		if declaradas[serie.Path] {
+			return PlanAprovisionamiento{}, fmt.Errorf("serie %s declarada más de una vez", serie.Path)
+		}
+		declaradas[serie.Path] = true
+		if err := validarSerie(serie); err != nil {
+			return PlanAprovisionamiento{}, fmt.Errorf("serie %s: %v", serie.Path, err)
+		}
+
+		actual, existe := actuales[serie.Path]
+		if !existe {
+			plan.Cambios = append(plan.Cambios, CambioAprovisionamiento{
+				Operacion: OperacionCrear, Objeto: "serie", ID: serie.Path, serie: serie,
+			})
+			continue
+		}
+		if err := validarCambioSerie(actual, serie); err != nil {
+			return PlanAprovisionamiento{}, err
+		}
+		var campos []string
+		if !maps.Equal(actual.Tags, serie.Tags) {
+			campos = append(campos, "tags")
+		}
+		if actual.TiempoAlmacenamiento != serie.TiempoAlmacenamiento {
+			campos = append(campos, "tiempo_almacenamiento")
+		}
+		if len(campos) > 0 {
+			plan.Cambios = append(plan.Cambios, CambioAprovisionamiento{
+				Operacion: OperacionActualizar, Objeto: "serie", ID: serie.Path, Campos: campos, serie: serie,
+			})
+		}
+	}
+
+	// Reglas creadas o actualizadas
+	reglasActuales := me.MotorReglas.ListarReglas()
+	reglasDeclaradas := make(map[string]bool, len(manifiesto.Reglas))
+	for _, declarada := range manifiesto.Reglas {
+		if reglasDeclaradas[declarada.ID] {
+			return PlanAprovisionamiento{}, fmt.Errorf("regla %s declarada más de una vez", declarada.ID)
+		}
+		reglasDeclaradas[declarada.ID] = true
+
+		regla, err := reglaDesdeTipos(declarada)
+		if err != nil {
+			return PlanAprovisionamiento{}, fmt.Errorf("regla %s: %v", declarada.ID, err)
+		}
+		regla.Activa = true
+		if err := me.MotorReglas.validarRegla(regla); err != nil {
+			return PlanAprovisionamiento{}, fmt.Errorf("regla %s inválida: %v", declarada.ID, err)
+		}
+
+		actual, existe := reglasActuales[regla.ID]
+		if !existe {
+			plan.Cambios = append(plan.Cambios, CambioAprovisionamiento{
+				Operacion: OperacionCrear, Objeto: "regla", ID: regla.ID, regla: regla,
+			})
+			continue
+		}
+		if campos := camposReglaModificados(actual, regla); len(campos) > 0 {
+			plan.Cambios = append(plan.Cambios, CambioAprovisionamiento{
+				Operacion: OperacionActualizar, Objeto: "regla", ID: regla.ID, Campos: campos, regla: regla,
+			})
+		}
+	}
+
+	if !opts.EliminarAusentes {
+		return plan, nil
+	}
+
+	// Reglas y series eliminadas (las reglas primero, pueden referirse a las series)
+	for _, id := range slices.Sorted(maps.Keys(reglasActuales)) {
+		if !reglasDeclaradas[id] {
+			plan.Cambios = append(plan.Cambios, CambioAprovisionamiento{
+				Operacion: OperacionEliminar, Objeto: "regla", ID: id,
+			})
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(actuales)) {
+		if !declaradas[path] {
+			plan.Cambios = append(plan.Cambios, CambioAprovisionamiento{
+				Operacion: OperacionEliminar, Objeto: "serie", ID: path,
+			})
+		}
+	}
+
+	return plan, nil
+}
+
+// aplicarCambio aplica un cambio del plan
+func (me *ManagerEdge) aplicarCambio(cambio CambioAprovisionamiento) error {
+	switch {
+	case cambio.Objeto == "serie" && cambio.Operacion == OperacionCrear:
+		return me.CrearSerie(cambio.serie)
+	case cambio.Objeto == "serie" && cambio.Operacion == OperacionActualizar:
+		return me.ActualizarSerie(cambio.serie)
+	case cambio.Objeto == "serie" && cambio.Operacion == OperacionEliminar:
+		return me.EliminarSerie(cambio.ID)
+	case cambio.Objeto == "regla" && cambio.Operacion == OperacionCrear:
+		return me.AgregarRegla(cambio.regla)
+	case cambio.Objeto == "regla" && cambio.Operacion == OperacionActualizar:
+		return me.ActualizarRegla(cambio.regla)
+	case cambio.Objeto == "regla" && cambio.Operacion == OperacionEliminar:
+		return me.EliminarRegla(cambio.ID)
+	}
+	return fmt.Errorf("cambio desconocido: %s", cambio)
+}
+
+// serieConDefaults completa los campos opcionales de una serie declarada
+func serieConDefaults(serie tipos.Serie) tipos.Serie {
+	if serie.CompresionBloque == "" {
+		serie.CompresionBloque = tipos.Ninguna
+	}
+	if serie.CompresionBytes == "" {
+		serie.CompresionBytes = tipos.SinCompresion
+	}
+	if serie.TamañoBloque == 0 {
+		serie.TamañoBloque = 100
+	}
+	return serie
+}
+
+// camposReglaModificados compara dos reglas en su forma serializable (la ventana
+// temporal normalizada, sin estado de evaluación) y retorna los campos que difieren
+func camposReglaModificados(actual, nueva *Regla) []string {
+	a, n := reglaATipos(actual), reglaATipos(nueva)
+	var campos []string
+	if a.Nombre != n.Nombre {
+		campos = append(campos, "nombre")
+	}
+	if a.Activa != n.Activa {
+		campos = append(campos, "activa")
+	}
+	if a.Logica != n.Logica {
+		campos = append(campos, "logica")
+	}
+	if !reflect.DeepEqual(a.Condiciones, n.Condiciones) {
+		campos = append(campos, "condiciones")
+	}
+	if !accionesIguales(a.Acciones, n.Acciones) {
+		campos = append(campos, "acciones")
+	}
+	return campos
+}
+
+// accionesIguales compara acciones considerando iguales los Params nil y vacíos
+func accionesIguales(a, b []tipos.Accion) bool {
+	return slices.EqualFunc(a, b, func(x, y tipos.Accion) bool {
+		return x.Tipo == y.Tipo && x.Destino == y.Destino && maps.Equal(x.Params, y.Params)
+	})
+}
@@ -0,0 +1,448 @@
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+	"github.com/cockroachdb/pebble"
+)
+
+// ============================================================================
+// COMANDOS DEL DESPACHADOR
+// El despachador envía los comandos a POST /api/comandos cuando el nodo está
+// accesible y, si no, los encola en S3 (comandos/{nodoID}/) firmados con el secreto
+// del nodo. La cola se revisa con cada latido y se aplica en orden de creación; los
+// comandos sin firma válida o vencidos se descartan sin aplicarse. El resultado de
+// cada comando encolado se guarda en resultados_comandos/{nodoID}/. Cada comando se aplica una
+// sola vez por ID: el nodo guarda su resultado en PebbleDB y lo retorna si el
+// comando se repite (el despachador no recibió la respuesta directa y lo encoló).
+// Los resultados se eliminan con los latidos al superar retencionResultadosComandos.
+// ============================================================================
+
+var (
+	// errComandoInvalido indica un comando que el nodo nunca podrá aplicar
+	errComandoInvalido = errors.New("comando inválido")
+	// errReglaNoEncontrada indica que la regla del comando no existe en el nodo
+	errReglaNoEncontrada = errors.New("regla no encontrada")
+	// errReglaExistente indica que la regla a crear ya existe en el nodo
+	errReglaExistente = errors.New("la regla ya existe")
+	// errSerieNoEncontrada indica que la serie del comando no existe en el nodo
+	errSerieNoEncontrada = errors.New("serie no encontrada")
+	// errSerieExistente indica que la serie a crear ya existe en el nodo
+	errSerieExistente = errors.New("la serie ya existe")
+)
+
+// AplicarComando aplica un comando del despachador y retorna su resultado con la
+// regla o serie resultante
+func (me *ManagerEdge) AplicarComando(comando tipos.Comando) (tipos.ResultadoComando, error) {
+	resultado := tipos.ResultadoComando{ID: comando.ID, Tipo: comando.Tipo}
+	if err := comando.Validar(); err != nil {
+		return resultado, fmt.Errorf("%w: %v", errComandoInvalido, err)
+	}
+	if comando.NodoID != me.nodoID {
+		return resultado, fmt.Errorf("%w: destinado al nodo %s", errComandoInvalido, comando.NodoID)
+	}
+
+	var err error
+	if comando.EsComandoSerie() {
+		resultado.Serie, err = me.aplicarComandoSerie(comando)
+	} else {
+		resultado.Regla, err = me.aplicarComandoRegla(comando)
+	}
+	if err != nil {
+		return resultado, err
+	}
+	resultado.Estado = tipos.ComandoAplicado
+	resultado.Aplicado = time.Now().UnixNano()
+	return resultado, nil
+}
+
+// aplicarComandoRegla aplica un comando sobre las reglas. Las reglas se validan con
+// MotorReglas.validarRegla (operadores, agregaciones y acciones); las creadas quedan
+// activas y las actualizadas conservan su estado activo.
+func (me *ManagerEdge) aplicarComandoRegla(comando tipos.Comando) (*tipos.Regla, error) {
+	var reglaID string
+	switch comando.Tipo {
+	case tipos.ComandoCrearRegla, tipos.ComandoActualizarRegla:
+		regla, err := reglaDesdeTipos(*comando.Regla)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errComandoInvalido, err)
+		}
+		if err := me.MotorReglas.validarRegla(regla); err != nil {
+			return nil, fmt.Errorf("%w: regla inválida: %v", errComandoInvalido, err)
+		}
+
+		actual, errObtener := me.MotorReglas.ObtenerRegla(regla.ID)
+		if comando.Tipo == tipos.ComandoCrearRegla {
+			if errObtener == nil {
+				return nil, fmt.Errorf("%w: %s", errReglaExistente, regla.ID)
+			}
+			err = me.MotorReglas.AgregarRegla(regla)
+		} else {
+			if errObtener != nil {
+				return nil, fmt.Errorf("%w: %s", errReglaNoEncontrada, regla.ID)
+			}
+			regla.Activa = actual.Activa
+			err = me.MotorReglas.ActualizarRegla(regla)
+		}
+		if err != nil {
+			return nil, err
+		}
+		reglaID = regla.ID
+
+	case tipos.ComandoEliminarRegla:
+		if _, err := me.MotorReglas.ObtenerRegla(comando.ReglaID); err != nil {
+			return nil, fmt.Errorf("%w: %s", errReglaNoEncontrada, comando.ReglaID)
+		}
+		return nil, me.MotorReglas.EliminarRegla(comando.ReglaID)
+
+	case tipos.ComandoHabilitarRegla, tipos.ComandoDeshabilitarRegla:
+		if _, err := me.MotorReglas.ObtenerRegla(comando.ReglaID); err != nil {
+			return nil, fmt.Errorf("%w: %s", errReglaNoEncontrada, comando.ReglaID)
+		}
+		activa := comando.Tipo == tipos.ComandoHabilitarRegla
+		if err := me.MotorReglas.HabilitarRegla(comando.ReglaID, activa); err != nil {
+			return nil, err
+		}
+		reglaID = comando.ReglaID
+	}
+
+	regla, err := me.MotorReglas.ObtenerRegla(reglaID)
+	if err != nil {
+		return nil, err
+	}
+	r := reglaATipos(regla)
+	return &r, nil
+}
+
+// aplicarComandoSerie aplica un comando sobre las series. Al crear se aplican los
+// defaults del aprovisionamiento; al actualizar, los campos que definen el formato de
+// los bloques pueden omitirse pero no cambiar.
+func (me *ManagerEdge) aplicarComandoSerie(comando tipos.Comando) (*tipos.Serie, error) {
+	path := comando.Path
+	switch comando.Tipo {
+	case tipos.ComandoCrearSerie:
+		serie := serieConDefaults(*comando.Serie)
+		if err := validarSerie(serie); err != nil {
+			return nil, fmt.Errorf("%w: %v", errComandoInvalido, err)
+		}
+		if _, err := me.ObtenerSeries(serie.Path); err == nil {
+			return nil, fmt.Errorf("%w: %s", errSerieExistente, serie.Path)
+		}
+		if err := me.CrearSerie(serie); err != nil {
+			return nil, err
+		}
+		path = serie.Path
+
+	case tipos.ComandoActualizarSerie:
+		serie := *comando.Serie
+		actual, err := me.ObtenerSeries(serie.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errSerieNoEncontrada, serie.Path)
+		}
+		serie = serieConFormatoDe(serie, actual)
+		if err := validarCambioSerie(actual, serie); err != nil {
+			return nil, fmt.Errorf("%w: %v", errComandoInvalido, err)
+		}
+		if err := me.ActualizarSerie(serie); err != nil {
+			return nil, err
+		}
+		path = serie.Path
+
+	case tipos.ComandoEliminarSerie:
+		if _, err := me.ObtenerSeries(path); err != nil {
+			return nil, fmt.Errorf("%w: %s", errSerieNoEncontrada, path)
+		}
+		return nil, me.EliminarSerie(path)
+	}
+
+	serie, err := me.ObtenerSeries(path)
+	if err != nil {
+		return nil, err
+	}
+	return &serie, nil
+}
+
+// serieConFormatoDe completa los campos de formato omitidos de una serie con los de
+// la serie actual
+func serieConFormatoDe(serie, actual tipos.Serie) tipos.Serie {
+	if serie.TipoDatos == tipos.Desconocido {
+		serie.TipoDatos = actual.TipoDatos
+	}
+	if serie.CompresionBloque == "" {
+		serie.CompresionBloque = actual.CompresionBloque
+	}
+	if serie.CompresionBytes == "" {
+		serie.CompresionBytes = actual.CompresionBytes
+	}
+	if serie.TamañoBloque == 0 {
+		serie.TamañoBloque = actual.TamañoBloque
+	}
+	return serie
+}
+
+// comandoDefinitivo indica si el error de un comando se repetiría al reintentarlo
+func comandoDefinitivo(err error) bool {
+	return errors.Is(err, errComandoInvalido) ||
+		errors.Is(err, errReglaNoEncontrada) || errors.Is(err, errReglaExistente) ||
+		errors.Is(err, errSerieNoEncontrada) || errors.Is(err, errSerieExistente)
+}
+
+// resultadoComandoLocal es el resultado de un comando aplicado o rechazado, guardado
+// en PebbleDB para responder lo mismo si el comando se repite
+type resultadoComandoLocal struct {
+	Resultado tipos.ResultadoComando
+	Status    int // Status HTTP de la respuesta directa (200 o el del rechazo)
+}
+
+// generarClaveResultadoComando genera la clave del resultado de un comando en PebbleDB
+func generarClaveResultadoComando(id string) []byte {
+	return []byte("resultados_comandos/" + id)
+}
+
+// retencionResultadosComandos es el tiempo que se conservan en PebbleDB los resultados de
+// los comandos. Supera la vigencia de los comandos encolados para que un comando repetido
+// mientras su firma es válida se siga reconociendo y no se reaplique.
+const retencionResultadosComandos = 2 * tipos.VigenciaComandoEncolado
+
+// podarResultadosComandos elimina de PebbleDB los resultados de comandos aplicados o
+// rechazados antes de la retención y retorna cuántos eliminó. Se ejecuta con cada latido.
+func (me *ManagerEdge) podarResultadosComandos(ahora time.Time) (int, error) {
+	me.muComandos.Lock()
+	defer me.muComandos.Unlock()
+
+	limite := ahora.Add(-retencionResultadosComandos).UnixNano()
+	iter, err := me.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte("resultados_comandos/"),
+		UpperBound: []byte("resultados_comandos0"), // Rango que incluye todas las claves
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error al crear iterador de resultados de comandos: %v", err)
+	}
+	defer iter.Close()
+
+	lote := me.db.NewBatch()
+	defer lote.Close()
+	eliminados := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		var local resultadoComandoLocal
+		if err := json.Unmarshal(iter.Value(), &local); err != nil {
+			// Se conserva: sin el resultado el comando podría reaplicarse
+			log.Printf("Advertencia: resultado de comando ilegible en %s: %v", iter.Key(), err)
+			continue
+		}
+		if local.Resultado.Aplicado >= limite {
+			continue
+		}
+		if err := lote.Delete(iter.Key(), nil); err != nil {
+			return 0, fmt.Errorf("error al borrar resultado de comando: %v", err)
+		}
+		eliminados++
+	}
+	if eliminados == 0 {
+		return 0, nil
+	}
+	if err := lote.Commit(pebble.Sync); err != nil {
+		return 0, fmt.Errorf("error al borrar resultados de comandos: %v", err)
+	}
+	return eliminados, nil
+}
+
+// statusRechazoComando retorna el status HTTP con que se informa el rechazo de un comando
+func statusRechazoComando(err error) int {
+	switch {
+	case errors.Is(err, errReglaNoEncontrada), errors.Is(err, errSerieNoEncontrada):
+		return http.StatusNotFound
+	case errors.Is(err, errReglaExistente), errors.Is(err, errSerieExistente):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// aplicarComandoUnaVez aplica el comando si su ID no fue aplicado ni rechazado antes;
+// si lo fue retorna el resultado guardado sin reaplicarlo. Un error indica una falla
+// transitoria: el comando no se aplicó y puede reintentarse.
+func (me *ManagerEdge) aplicarComandoUnaVez(comando tipos.Comando) (resultadoComandoLocal, error) {
+	me.muComandos.Lock()
+	defer me.muComandos.Unlock()
+
+	var local resultadoComandoLocal
+	if comando.ID != "" {
+		datos, closer, err := me.db.Get(generarClaveResultadoComando(comando.ID))
+		if err == nil {
+			err = json.Unmarshal(datos, &local)
+			closer.Close()
+			if err != nil {
+				return local, fmt.Errorf("error deserializando resultado del comando %s: %v", comando.ID, err)
+			}
+			log.Printf("Comando %s (%s) repetido: se retorna el resultado anterior", comando.ID, comando.Tipo)
+			return local, nil
+		}
+		if err != pebble.ErrNotFound {
+			return local, fmt.Errorf("error leyendo resultado del comando %s: %v", comando.ID, err)
+		}
+	}
+
+	resultado, err := me.AplicarComando(comando)
+	local = resultadoComandoLocal{Resultado: resultado, Status: http.StatusOK}
+	if err != nil {
+		if !comandoDefinitivo(err) {
+			return local, err
+		}
+		local.Resultado.Estado = tipos.ComandoRechazado
+		local.Resultado.Motivo = err.Error()
+		local.Resultado.Aplicado = time.Now().UnixNano()
+		local.Status = statusRechazoComando(err)
+	}
+
+	// Sin ID no puede reconocerse una repetición (AplicarComando ya lo rechazó)
+	if comando.ID != "" {
+		datos, err := json.Marshal(local)
+		if err == nil {
+			err = me.db.Set(generarClaveResultadoComando(comando.ID), datos, pebble.Sync)
+		}
+		if err != nil {
+			// El comando ya se aplicó: solo se pierde la detección de repeticiones
+			log.Printf("Advertencia: error guardando resultado del comando %s: %v", comando.ID, err)
+		}
+	}
+	return local, nil
+}
+
+// handleComando aplica un comando recibido del despachador. Si el comando ya se
+// aplicó o rechazó responde lo mismo que la primera vez.
+func (me *ManagerEdge) handleComando(w http.ResponseWriter, r *http.Request) {
+	cuerpo, err := io.ReadAll(r.Body)
+	if err != nil {
+		enviarRespuestaError(w, "Error leyendo body: "+err.Error())
+		return
+	}
+	var comando tipos.Comando
+	if err := json.Unmarshal(cuerpo, &comando); err != nil {
+		enviarRespuestaError(w, "Error deserializando comando: "+err.Error())
+		return
+	}
+
+	local, err := me.aplicarComandoUnaVez(comando)
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case local.Status != http.StatusOK:
+		log.Printf("Comando %s (%s) rechazado: %s", comando.ID, comando.Tipo, local.Resultado.Motivo)
+		http.Error(w, local.Resultado.Motivo, local.Status)
+	default:
+		log.Printf("Comando %s (%s) aplicado", comando.ID, comando.Tipo)
+		enviarRespuesta(w, true, local.Resultado)
+	}
+}
+
+// ProcesarComandosPendientes aplica en orden los comandos encolados en S3 para el
+// nodo, guarda el resultado de cada uno y los elimina de la cola. Los comandos que
+// fallan por un error definitivo (regla o serie inválida, inexistente o duplicada)
+// quedan rechazados; ante otro error el procesamiento se detiene para reintentar en
+// el próximo latido sin alterar el orden. Los comandos sin firma válida del
+// despachador se eliminan de la cola sin aplicarse (ver leerComando): ningún comando
+// encolado, en particular eliminar_serie, que borra los datos locales y en S3, se
+// aplica sin firma. Retorna la cantidad de comandos procesados.
+func (me *ManagerEdge) ProcesarComandosPendientes() (int, error) {
+	if me.almacenamiento == nil {
+		return 0, fmt.Errorf("S3 no está configurado")
+	}
+
+	ctx := context.TODO()
+	objetos, err := tipos.ListarObjetos(ctx, me.almacenamiento, tipos.GenerarPrefijoS3Comandos(me.nodoID))
+	if err != nil {
+		return 0, fmt.Errorf("error listando comandos pendientes: %v", err)
+	}
+	sort.Slice(objetos, func(i, j int) bool {
+		return objetos[i].Clave < objetos[j].Clave
+	})
+
+	procesados := 0
+	for _, objeto := range objetos {
+		clave := objeto.Clave
+		comando, err := me.leerComando(ctx, clave)
+		if err != nil {
+			return procesados, err
+		}
+		if comando != nil {
+			if err := me.procesarComando(ctx, *comando); err != nil {
+				return procesados, err
+			}
+		}
+
+		if err := me.almacenamiento.Eliminar(ctx, clave); err != nil {
+			return procesados, fmt.Errorf("error eliminando comando %s: %v", clave, err)
+		}
+		procesados++
+	}
+	return procesados, nil
+}
+
+// procesarComando aplica un comando encolado y guarda su resultado. Si el resultado ya
+// existe (el comando se aplicó pero no llegó a eliminarse de la cola) no se reaplica.
+func (me *ManagerEdge) procesarComando(ctx context.Context, comando tipos.Comando) error {
+	claveResultado := tipos.GenerarClaveS3ResultadoComando(me.nodoID, comando.ID)
+	_, _, err := me.almacenamiento.Leer(ctx, claveResultado, "")
+	if err == nil {
+		log.Printf("Comando %s (%s) ya procesado", comando.ID, comando.Tipo)
+		return nil
+	}
+	if !tipos.EsObjetoInexistente(err) {
+		return fmt.Errorf("error verificando resultado del comando %s: %v", comando.ID, err)
+	}
+
+	// Si el comando ya llegó por la entrega directa se guarda el resultado de entonces
+	local, err := me.aplicarComandoUnaVez(comando)
+	if err != nil {
+		return fmt.Errorf("error aplicando comando %s: %v", comando.ID, err)
+	}
+	if local.Resultado.Estado == tipos.ComandoRechazado {
+		log.Printf("Comando %s (%s) rechazado: %s", comando.ID, comando.Tipo, local.Resultado.Motivo)
+	} else {
+		log.Printf("Comando pendiente %s (%s) aplicado", comando.ID, comando.Tipo)
+	}
+
+	datos, err := json.Marshal(tipos.RegistroComando{Comando: comando, Resultado: local.Resultado})
+	if err != nil {
+		return fmt.Errorf("error serializando resultado del comando %s: %v", comando.ID, err)
+	}
+	if _, err := me.almacenamiento.Guardar(ctx, claveResultado, datos, tipos.OpcionesGuardar{TipoContenido: "application/json"}); err != nil {
+		return fmt.Errorf("error guardando resultado del comando %s: %v", comando.ID, err)
+	}
+	return nil
+}
+
+// leerComando descarga un comando encolado y verifica su firma con el secreto del
+// nodo. Retorna nil si el objeto no contiene un comando con ID firmado y vigente: se
+// descarta sin aplicarlo y sin resultado.
+func (me *ManagerEdge) leerComando(ctx context.Context, clave string) (*tipos.Comando, error) {
+	datos, _, err := me.almacenamiento.Leer(ctx, clave, "")
+	if err != nil {
+		return nil, fmt.Errorf("error descargando comando %s: %v", clave, err)
+	}
+	var encolado tipos.ComandoEncolado
+	if err := json.Unmarshal(datos, &encolado); err != nil {
+		log.Printf("Comando %s descartado: %v", clave, err)
+		return nil, nil
+	}
+	comando, err := encolado.Verificar(me.nodoID, me.secreto)
+	if err != nil {
+		log.Printf("Comando %s rechazado: %v", clave, err)
+		return nil, nil
+	}
+	if comando.ID == "" {
+		log.Printf("Comando %s descartado: sin ID", clave)
+		return nil, nil
+	}
+	return &comando, nil
+}
@@ -9,10 +9,9 @@ import (
 	"log"
 	"net/http"
 	"sort"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/cbiale/sensorwave/tipos"
 )
 
@@ -23,7 +22,7 @@ import (
 // - Se modifica una regla (en AgregarRegla, ActualizarRegla, EliminarRegla)
 func (me *ManagerEdge) RegistrarEnS3() error {
 	// Verificar que S3 esté configurado
-	if clienteS3 == nil {
+	if me.almacenamiento == nil {
 		return fmt.Errorf("S3 no está configurado")
 	}
 
@@ -39,37 +38,7 @@ func (me *ManagerEdge) RegistrarEnS3() error {
 	reglasMap := me.MotorReglas.ListarReglas()
 	var reglas []tipos.Regla
 	for _, regla := range reglasMap {
-		// Convertir condiciones
-		var condiciones []tipos.Condicion
-		for _, c := range regla.Condiciones {
-			condiciones = append(condiciones, tipos.Condicion{
-				Path:          c.Path,
-				VentanaT:      c.VentanaT.String(),
-				Agregacion:    string(c.Agregacion),
-				Operador:      string(c.Operador),
-				Valor:         c.Valor,
-				AgregarSeries: c.AgregarSeries,
-			})
-		}
-
-		// Convertir acciones
-		var acciones []tipos.Accion
-		for _, a := range regla.Acciones {
-			acciones = append(acciones, tipos.Accion{
-				Tipo:    a.Tipo,
-				Destino: a.Destino,
-				Params:  a.Params,
-			})
-		}
-
-		reglas = append(reglas, tipos.Regla{
-			ID:          regla.ID,
-			Nombre:      regla.Nombre,
-			Activa:      regla.Activa,
-			Logica:      string(regla.Logica),
-			Condiciones: condiciones,
-			Acciones:    acciones,
-		})
+		reglas = append(reglas, reglaATipos(regla))
 	}
 
 	// Ordenar reglas por ID para consistencia
@@ -79,17 +48,31 @@ func (me *ManagerEdge) RegistrarEnS3() error {
 
 	// Crear estructura de registro del nodo
 	registro := struct {
-		NodoID    string                 `json:"nodo_id"`
-		Direccion string                 `json:"direccion"`
-		Series    map[string]tipos.Serie `json:"series"`
-		Tags      map[string]string      `json:"tags,omitempty"`
-		Reglas    []tipos.Regla          `json:"reglas,omitempty"`
+		NodoID            string                  `json:"nodo_id"`
+		Direccion         string                  `json:"direccion"`
+		Series            map[string]tipos.Serie  `json:"series"`
+		Tags              map[string]string       `json:"tags,omitempty"`
+		Reglas            []tipos.Regla           `json:"reglas,omitempty"`
+		DisposicionClaves tipos.DisposicionClaves `json:"disposicion_claves,omitempty"`
+		UltimaConexion    int64                   `json:"ultima_conexion"`
+		Version           string                  `json:"version,omitempty"`
+		ClavePublica      string                  `json:"clave_publica,omitempty"`
+		Esquema           string                  `json:"esquema,omitempty"`
+		HuellaCertificado string                  `json:"huella_certificado,omitempty"`
+		Tunel             bool                    `json:"tunel,omitempty"`
 	}{
-		NodoID:    me.nodoID,
-		Direccion: me.direccion,
-		Series:    series,
-		Tags:      me.tags,
-		Reglas:    reglas,
+		NodoID:            me.nodoID,
+		Direccion:         me.direccion,
+		Series:            series,
+		Tags:              me.tags,
+		Reglas:            reglas,
+		DisposicionClaves: me.disposicionClaves,
+		UltimaConexion:    time.Now().UnixNano(),
+		Version:           tipos.Version,
+		ClavePublica:      me.ClavePublica(),
+		Esquema:           me.esquemaHTTP(),
+		HuellaCertificado: me.huellaTLS,
+		Tunel:             me.urlTunel != "",
 	}
 
 	// Serializar a JSON
@@ -98,17 +81,20 @@ func (me *ManagerEdge) RegistrarEnS3() error {
 		return fmt.Errorf("error al serializar registro de nodo: %v", err)
 	}
 
+	// Firmar el registro para que el despachador pueda verificar su origen
+	if me.claveFirma != nil {
+		registroJSON, err = tipos.FirmarRegistroNodo(registroJSON, me.claveFirma)
+		if err != nil {
+			return fmt.Errorf("error al firmar registro de nodo: %v", err)
+		}
+	}
+
 	// Subir a S3 como objeto
 	// Formato de la clave: nodos/<nodoID>.json
 	nombreArchivo := fmt.Sprintf("nodos/%s.json", me.nodoID)
 
 	ctx := context.TODO()
-	_, err = clienteS3.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(configuracionS3.Bucket),
-		Key:         aws.String(nombreArchivo),
-		Body:        bytes.NewReader(registroJSON),
-		ContentType: aws.String("application/json"),
-	})
+	_, err = me.almacenamiento.Guardar(ctx, nombreArchivo, registroJSON, tipos.OpcionesGuardar{TipoContenido: "application/json"})
 	if err != nil {
 		return fmt.Errorf("error al registrar nodo en S3: %v", err)
 	}
@@ -117,33 +103,124 @@ func (me *ManagerEdge) RegistrarEnS3() error {
 	return nil
 }
 
+// ============================================================================
+// LATIDOS
+// ============================================================================
+
+// EnviarLatido actualiza el objeto de latido del nodo en S3 (latidos/<nodoID>.json),
+// que el despachador usa para determinar si el nodo sigue activo
+func (me *ManagerEdge) EnviarLatido() error {
+	if me.almacenamiento == nil {
+		return fmt.Errorf("S3 no está configurado")
+	}
+
+	latidoJSON, err := json.Marshal(tipos.Latido{
+		NodoID:  me.nodoID,
+		Momento: time.Now().UnixNano(),
+		Version: tipos.Version,
+	})
+	if err != nil {
+		return fmt.Errorf("error al serializar latido: %v", err)
+	}
+
+	_, err = me.almacenamiento.Guardar(context.TODO(), tipos.GenerarClaveS3Latido(me.nodoID), latidoJSON,
+		tipos.OpcionesGuardar{TipoContenido: "application/json"})
+	if err != nil {
+		return fmt.Errorf("error al enviar latido a S3: %v", err)
+	}
+
+	return nil
+}
+
+// IniciarLatidos inicia un goroutine que envía un latido inmediatamente y luego
+// periódicamente según el intervalo especificado. Con cada latido se aplican los
+// comandos que el despachador encoló para el nodo y se eliminan los resultados de
+// comandos que superaron la retención.
+func (me *ManagerEdge) IniciarLatidos(intervalo time.Duration) {
+	me.latidos.Add(1)
+	go func() {
+		defer me.latidos.Done()
+
+		if err := me.EnviarLatido(); err != nil {
+			log.Printf("Advertencia: error enviando latido: %v", err)
+		}
+		me.aplicarComandosPendientes()
+
+		ticker := time.NewTicker(intervalo)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-me.done:
+				log.Printf("Deteniendo latidos")
+				return
+			case <-ticker.C:
+				if me.almacenamiento == nil {
+					continue // S3 no configurado, saltar
+				}
+
+				if err := me.EnviarLatido(); err != nil {
+					log.Printf("Advertencia: error enviando latido: %v", err)
+				}
+				me.aplicarComandosPendientes()
+			}
+		}
+	}()
+
+	log.Printf("Latidos iniciados (intervalo: %v)", intervalo)
+}
+
+// aplicarComandosPendientes procesa la cola de comandos del nodo y poda los resultados
+// de comandos vencidos, registrando los errores
+func (me *ManagerEdge) aplicarComandosPendientes() {
+	if _, err := me.ProcesarComandosPendientes(); err != nil {
+		log.Printf("Advertencia: %v", err)
+	}
+	if eliminados, err := me.podarResultadosComandos(time.Now()); err != nil {
+		log.Printf("Advertencia: %v", err)
+	} else if eliminados > 0 {
+		log.Printf("Eliminados %d resultados de comandos vencidos", eliminados)
+	}
+}
+
 // ============================================================================
 // SERVIDOR HTTP REST PARA COMUNICACIÓN CON DESPACHADORES
 // ============================================================================
 
-// authMiddleware es un middleware que verifica el token de autorización
-func authMiddleware(nodoID string) func(http.Handler) http.Handler {
+// tamañoMaximoSolicitud limita el cuerpo que se lee para verificar una solicitud
+const tamañoMaximoSolicitud = 10 << 20
+
+// authMiddleware es un middleware que verifica la firma HMAC de las solicitudes del
+// despachador. Un verificador nil deja pasar todas las solicitudes.
+func authMiddleware(verificador *tipos.VerificadorSolicitudes) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
+		if verificador == nil {
+			return next
+		}
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token := r.Header.Get("Authorization")
-			expected := "Bearer " + nodoID
-
-			fmt.Println("Token recibido:", token)
-			fmt.Println("Token esperado:", expected)
+			cuerpo, err := io.ReadAll(http.MaxBytesReader(w, r.Body, tamañoMaximoSolicitud))
+			if err != nil {
+				http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
 
-			if token != expected {
+			if err := verificador.Verificar(r, cuerpo); err != nil {
+				log.Printf("Solicitud rechazada desde %s: %v", r.RemoteAddr, err)
 				http.Error(w, "No autorizado", http.StatusUnauthorized)
 				return
 			}
+
+			// Restaurar el cuerpo para el handler
+			r.Body = io.NopCloser(bytes.NewReader(cuerpo))
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// iniciarServidorHTTP inicia el servidor HTTP con los endpoints REST para consultas
-func (me *ManagerEdge) iniciarServidorHTTP() chan struct{} {
-	listo := make(chan struct{})
-
+// handlerAPI construye el handler de la API REST con la verificación de las solicitudes.
+// Lo sirven tanto el servidor HTTP como el túnel hacia el despachador.
+func (me *ManagerEdge) handlerAPI() http.Handler {
 	mux := http.NewServeMux()
 
 	// Registrar handlers REST para consultas del despachador
@@ -151,19 +228,36 @@ func (me *ManagerEdge) iniciarServidorHTTP() chan struct{} {
 	mux.HandleFunc("/api/consulta/ultimo", me.handleConsultaUltimo)
 	mux.HandleFunc("/api/consulta/agregacion", me.handleConsultaAgregacion)
 	mux.HandleFunc("/api/consulta/agregacion-temporal", me.handleConsultaAgregacionTemporal)
+	me.registrarHandlersAdministracion(mux)
+
+	return authMiddleware(me.verificador)(mux)
+}
+
+// iniciarServidorHTTP inicia el servidor HTTP con los endpoints REST para consultas
+func (me *ManagerEdge) iniciarServidorHTTP(handler http.Handler) chan struct{} {
+	listo := make(chan struct{})
 
 	log.Println("Iniciando servidor HTTP para", me.nodoID, "en puerto", me.puertoHTTP)
 	server := &http.Server{
 		Addr:         "0.0.0.0:" + me.puertoHTTP,
-		Handler:      authMiddleware(me.nodoID)(mux),
+		Handler:      handler,
+		TLSConfig:    me.configuracionTLS(),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	me.servidorHTTP = server
 
 	go func() {
-		log.Printf("Edge %s: servidor HTTP iniciando en puerto %s", me.nodoID, me.puertoHTTP)
+		log.Printf("Edge %s: servidor %s iniciando en puerto %s", me.nodoID, me.esquemaHTTP(), me.puertoHTTP)
 		close(listo)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			// El certificado se toma de TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Printf("Error en servidor HTTP: %v", err)
 		}
 	}()
@@ -178,18 +272,11 @@ func (me *ManagerEdge) handleConsultaRango(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Leer body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		enviarRespuestaError(w, "Error leyendo body: "+err.Error())
-		return
-	}
-	defer r.Body.Close()
-
-	// Deserializar solicitud con Gob
+	// Deserializar solicitud (Gob o JSON)
 	var solicitud tipos.SolicitudConsultaRango
-	if err := tipos.DeserializarGob(body, &solicitud); err != nil {
-		enviarRespuestaError(w, "Error deserializando solicitud: "+err.Error())
+	esJSON, err := leerSolicitud(r, &solicitud)
+	if err != nil {
+		enviarRespuestaError(w, err.Error())
 		return
 	}
 
@@ -197,7 +284,12 @@ func (me *ManagerEdge) handleConsultaRango(w http.ResponseWriter, r *http.Reques
 	tiempoInicio := time.Unix(0, solicitud.TiempoInicio)
 	tiempoFin := time.Unix(0, solicitud.TiempoFin)
 
-	resultado, err := me.ConsultarRango(solicitud.Serie, tiempoInicio, tiempoFin)
+	var resultado tipos.ResultadoConsultaRango
+	if solicitud.Ventana != nil {
+		resultado, err = me.ConsultarRangoConVentana(solicitud.Serie, tiempoInicio, tiempoFin, *solicitud.Ventana)
+	} else {
+		resultado, err = me.ConsultarRango(solicitud.Serie, tiempoInicio, tiempoFin)
+	}
 
 	// Construir respuesta
 	respuesta := tipos.RespuestaConsultaRango{
@@ -207,8 +299,8 @@ func (me *ManagerEdge) handleConsultaRango(w http.ResponseWriter, r *http.Reques
 		respuesta.Error = err.Error()
 	}
 
-	// Serializar y enviar respuesta
-	enviarRespuestaGob(w, respuesta)
+	// Serializar y enviar respuesta en el mismo formato de la solicitud
+	enviarRespuesta(w, esJSON, respuesta)
 }
 
 // handleConsultaUltimo maneja consultas del último punto via REST
@@ -218,18 +310,11 @@ func (me *ManagerEdge) handleConsultaUltimo(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Leer body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		enviarRespuestaError(w, "Error leyendo body: "+err.Error())
-		return
-	}
-	defer r.Body.Close()
-
-	// Deserializar solicitud con Gob
+	// Deserializar solicitud (Gob o JSON)
 	var solicitud tipos.SolicitudConsultaPunto
-	if err := tipos.DeserializarGob(body, &solicitud); err != nil {
-		enviarRespuestaError(w, "Error deserializando solicitud: "+err.Error())
+	esJSON, err := leerSolicitud(r, &solicitud)
+	if err != nil {
+		enviarRespuestaError(w, err.Error())
 		return
 	}
 
@@ -255,8 +340,50 @@ func (me *ManagerEdge) handleConsultaUltimo(w http.ResponseWriter, r *http.Reque
 		respuesta.Error = err.Error()
 	}
 
-	// Serializar y enviar respuesta
-	enviarRespuestaGob(w, respuesta)
+	// Serializar y enviar respuesta en el mismo formato de la solicitud
+	enviarRespuesta(w, esJSON, respuesta)
+}
+
+// leerSolicitud lee el body y lo deserializa en solicitud.
+// Por defecto usa Gob (formato del despachador); si Content-Type es application/json
+// usa JSON, que admite tiempos RFC3339 o relativos (now-1h) e intervalos legibles ("15m").
+// Retorna true si la solicitud fue JSON.
+func leerSolicitud(r *http.Request, solicitud interface{}) (bool, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false, fmt.Errorf("Error leyendo body: %v", err)
+	}
+	defer r.Body.Close()
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(body, solicitud); err != nil {
+			return true, fmt.Errorf("Error deserializando solicitud: %v", err)
+		}
+		return true, nil
+	}
+
+	if err := tipos.DeserializarGob(body, solicitud); err != nil {
+		return false, fmt.Errorf("Error deserializando solicitud: %v", err)
+	}
+	return false, nil
+}
+
+// enviarRespuesta envía la respuesta en JSON o Gob según el formato de la solicitud
+func enviarRespuesta(w http.ResponseWriter, esJSON bool, respuesta interface{}) {
+	if !esJSON {
+		enviarRespuestaGob(w, respuesta)
+		return
+	}
+
+	respuestaBytes, err := json.Marshal(respuesta)
+	if err != nil {
+		http.Error(w, "Error serializando respuesta", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respuestaBytes)
 }
 
 // enviarRespuestaGob serializa y envía una respuesta usando Gob
@@ -285,18 +412,11 @@ func (me *ManagerEdge) handleConsultaAgregacion(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Leer body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		enviarRespuestaError(w, "Error leyendo body: "+err.Error())
-		return
-	}
-	defer r.Body.Close()
-
-	// Deserializar solicitud con Gob
+	// Deserializar solicitud (Gob o JSON)
 	var solicitud tipos.SolicitudConsultaAgregacion
-	if err := tipos.DeserializarGob(body, &solicitud); err != nil {
-		enviarRespuestaError(w, "Error deserializando solicitud: "+err.Error())
+	esJSON, err := leerSolicitud(r, &solicitud)
+	if err != nil {
+		enviarRespuestaError(w, err.Error())
 		return
 	}
 
@@ -314,8 +434,8 @@ func (me *ManagerEdge) handleConsultaAgregacion(w http.ResponseWriter, r *http.R
 		respuesta.Error = err.Error()
 	}
 
-	// Serializar y enviar respuesta
-	enviarRespuestaGob(w, respuesta)
+	// Serializar y enviar respuesta en el mismo formato de la solicitud
+	enviarRespuesta(w, esJSON, respuesta)
 }
 
 // handleConsultaAgregacionTemporal maneja consultas de downsampling via REST
@@ -325,18 +445,11 @@ func (me *ManagerEdge) handleConsultaAgregacionTemporal(w http.ResponseWriter, r
 		return
 	}
 
-	// Leer body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		enviarRespuestaError(w, "Error leyendo body: "+err.Error())
-		return
-	}
-	defer r.Body.Close()
-
-	// Deserializar solicitud con Gob
+	// Deserializar solicitud (Gob o JSON)
 	var solicitud tipos.SolicitudConsultaAgregacionTemporal
-	if err := tipos.DeserializarGob(body, &solicitud); err != nil {
-		enviarRespuestaError(w, "Error deserializando solicitud: "+err.Error())
+	esJSON, err := leerSolicitud(r, &solicitud)
+	if err != nil {
+		enviarRespuestaError(w, err.Error())
 		return
 	}
 
@@ -345,7 +458,12 @@ func (me *ManagerEdge) handleConsultaAgregacionTemporal(w http.ResponseWriter, r
 	tiempoFin := time.Unix(0, solicitud.TiempoFin)
 	intervalo := time.Duration(solicitud.Intervalo)
 
-	resultado, err := me.ConsultarAgregacionTemporal(solicitud.Serie, tiempoInicio, tiempoFin, solicitud.Agregaciones, intervalo)
+	var resultado tipos.ResultadoAgregacionTemporal
+	if solicitud.Ventana != nil {
+		resultado, err = me.ConsultarAgregacionTemporalConVentana(solicitud.Serie, tiempoInicio, tiempoFin, solicitud.Agregaciones, intervalo, *solicitud.Ventana)
+	} else {
+		resultado, err = me.ConsultarAgregacionTemporal(solicitud.Serie, tiempoInicio, tiempoFin, solicitud.Agregaciones, intervalo)
+	}
 
 	// Construir respuesta
 	respuesta := tipos.RespuestaConsultaAgregacionTemporal{
@@ -355,6 +473,6 @@ func (me *ManagerEdge) handleConsultaAgregacionTemporal(w http.ResponseWriter, r
 		respuesta.Error = err.Error()
 	}
 
-	// Serializar y enviar respuesta
-	enviarRespuestaGob(w, respuesta)
+	// Serializar y enviar respuesta en el mismo formato de la solicitud
+	enviarRespuesta(w, esJSON, respuesta)
 }
@@ -559,6 +559,43 @@ func (me *ManagerEdge) ConsultarAgregacionTemporal(
 	}, nil
 }
 
+// ConsultarRangoConVentana ejecuta ConsultarRango y aplica la función de ventana indicada
+// (media móvil, EWMA, suma acumulada o diferencia) a cada serie del resultado.
+// Solo es válido para series numéricas.
+func (me *ManagerEdge) ConsultarRangoConVentana(path string, tiempoInicio, tiempoFin time.Time, ventana tipos.FuncionVentana) (tipos.ResultadoConsultaRango, error) {
+	if err := ventana.Validar(); err != nil {
+		return tipos.ResultadoConsultaRango{}, err
+	}
+
+	resultado, err := me.ConsultarRango(path, tiempoInicio, tiempoFin)
+	if err != nil {
+		return tipos.ResultadoConsultaRango{}, err
+	}
+
+	return tipos.AplicarVentanaRango(resultado, ventana)
+}
+
+// ConsultarAgregacionTemporalConVentana ejecuta ConsultarAgregacionTemporal y aplica la función
+// de ventana sobre los buckets resultantes, para cada agregación y serie.
+func (me *ManagerEdge) ConsultarAgregacionTemporalConVentana(
+	path string,
+	tiempoInicio, tiempoFin time.Time,
+	agregaciones []tipos.TipoAgregacion,
+	intervalo time.Duration,
+	ventana tipos.FuncionVentana,
+) (tipos.ResultadoAgregacionTemporal, error) {
+	if err := ventana.Validar(); err != nil {
+		return tipos.ResultadoAgregacionTemporal{}, err
+	}
+
+	resultado, err := me.ConsultarAgregacionTemporal(path, tiempoInicio, tiempoFin, agregaciones, intervalo)
+	if err != nil {
+		return tipos.ResultadoAgregacionTemporal{}, err
+	}
+
+	return tipos.AplicarVentanaAgregacionTemporal(resultado, ventana)
+}
+
 // generarBuckets genera los timestamps de inicio de cada bucket temporal
 func generarBuckets(tiempoInicio, tiempoFin, intervalo int64) []int64 {
 	var buckets []int64
@@ -1,9 +1,14 @@
 package edge
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -15,19 +20,45 @@ import (
 )
 
 type ManagerEdge struct {
-	nodoID        string            // ID único del nodo edge
-	direccion     string            // dirección pública para uso de API REST
-	puertoHTTP    string            // Puerto HTTP para la API REST
-	tags          map[string]string // Metadatos libres del nodo (nombre, ubicación, etc.)
-	db            *pebble.DB        // Base de datos Pebble local
-	cache         *Cache            // Cache en memoria de configuraciones de series
-	buffers       sync.Map          // Map de buffers de series en memoria
-	mu            sync.RWMutex      // Mutex para proteger el contador
-	contador      int               // Contador para generar IDs únicos de series
-	MotorReglas   *MotorReglas      // Motor de reglas integrado
-	tamañoBuffer  int               // Tamaño del buffer de canales (default 1000)
-	timeoutBuffer int64             // Timeout para inserción en nanosegundos (default 100ms)
-	done          chan struct{}     // Canal para señalizar cierre del manager
+	nodoID        string             // ID único del nodo edge
+	direccion     string             // dirección pública para uso de API REST
+	puertoHTTP    string             // Puerto HTTP para la API REST
+	tags          map[string]string  // Metadatos libres del nodo (nombre, ubicación, etc.)
+	db            *pebble.DB         // Base de datos Pebble local
+	cache         *Cache             // Cache en memoria de configuraciones de series
+	buffers       sync.Map           // Map de buffers de series en memoria
+	mu            sync.RWMutex       // Mutex para proteger el contador
+	contador      int                // Contador para generar IDs únicos de series
+	MotorReglas   *MotorReglas       // Motor de reglas integrado
+	tamañoBuffer  int                // Tamaño del buffer de canales (default 1000)
+	timeoutBuffer int64              // Timeout para inserción en nanosegundos (default 100ms)
+	done          chan struct{}      // Canal para señalizar cierre del manager
+	muManifiestos sync.Mutex         // Serializa la actualización de manifiestos en S3
+	latidos       sync.WaitGroup     // Gorutina de latidos, que también aplica los comandos pendientes
+	muComandos    sync.Mutex         // Serializa la aplicación de comandos (directos y encolados)
+	claveFirma    ed25519.PrivateKey // Clave para firmar el registro en S3 (nil = sin firma)
+
+	almacenamiento    tipos.Almacenamiento    // Almacenamiento en la nube (nil = modo local)
+	disposicionClaves tipos.DisposicionClaves // Disposición de las claves de los bloques migrados
+
+	muMigracion         sync.Mutex        // Serializa las ejecuciones de la migración
+	muInformesMigracion sync.Mutex        // Protege migracionEnCurso, ultimaMigracion y migracionSolicitada
+	migracionEnCurso    *InformeMigracion // Progreso de la migración en ejecución (nil = ninguna)
+	ultimaMigracion     *InformeMigracion // Informe de la última migración terminada (nil = ninguna)
+	migracionSolicitada bool              // Migración solicitada por la API sin terminar
+	migraciones         sync.WaitGroup    // Migraciones solicitadas por la API en segundo plano
+
+	verificador    *tipos.VerificadorSolicitudes // Autenticación de la API HTTP (nil = sin autenticación)
+	certificadoTLS *tls.Certificate              // Certificado de la API HTTP (nil = HTTP plano)
+	huellaTLS      string                        // Huella SHA-256 del certificado autofirmado ("" = verificado por CA)
+	servidorHTTP   *http.Server                  // Servidor de la API HTTP (nil = sin API)
+
+	urlTunel         string         // URL del despachador para el túnel inverso ("" = sin túnel)
+	secreto          []byte         // Secreto compartido con el despachador (nil = sin autenticación)
+	muTunel          sync.Mutex     // Protege conexionTunel
+	conexionTunel    net.Conn       // Conexión del túnel establecida (nil = desconectado)
+	tunelTerminado   chan struct{}  // Se cierra al terminar la gorutina del túnel
+	solicitudesTunel sync.WaitGroup // Solicitudes en curso recibidas por el túnel
 }
 
 type Cache struct {
@@ -47,13 +78,44 @@ type SerieBuffer struct {
 // Opciones configura la creación de un ManagerEdge.
 // ConfigS3 es opcional (nil = modo desconectado sin sincronización con nube).
 type Opciones struct {
-	NombreDB      string                 // Nombre de la base de datos Pebble (requerido)
-	Direccion     string                 // Dirección pública para API REST (se debe pasar, SensorWave es agnóstico en cuanto a que se usa)
-	PuertoHTTP    string                 // Puerto HTTP para API REST (requerido solo si ConfigS3 != nil)
-	ConfigS3      *tipos.ConfiguracionS3 // nil = modo local sin nube (debe ser explícito si se usa)
-	TamañoBuffer  int                    // Tamaño del canal de buffer por serie (default: 1000)
-	TimeoutBuffer int64                  // Timeout en nanosegundos para inserción (default: 100ms)
-	Tags          map[string]string      // Metadatos libres del nodo (nombre, ubicación, etc.)
+	NombreDB       string                 // Nombre de la base de datos Pebble (requerido)
+	Direccion      string                 // Dirección pública para API REST (se debe pasar, SensorWave es agnóstico en cuanto a que se usa)
+	PuertoHTTP     string                 // Puerto HTTP para API REST (requerido si ConfigS3 != nil y no hay URLTunel)
+	ConfigS3       *tipos.ConfiguracionS3 // nil = modo local sin nube (debe ser explícito si se usa)
+	ClienteS3      tipos.ClienteS3        // Cliente S3 a usar en lugar de crearlo con ConfigS3 (nil = crearlo)
+	Almacenamiento tipos.Almacenamiento   // Backend a usar en lugar de crearlo con ConfigS3, que solo aporta la disposición de claves (nil = crearlo)
+	TamañoBuffer   int                    // Tamaño del canal de buffer por serie (default: 1000)
+	TimeoutBuffer  int64                  // Timeout en nanosegundos para inserción (default: 100ms)
+	Tags           map[string]string      // Metadatos libres del nodo (nombre, ubicación, etc.)
+
+	// IntervaloLatido es el intervalo de actualización del latido del nodo en S3 (default: 30s).
+	// Solo aplica si ConfigS3 != nil.
+	IntervaloLatido time.Duration
+
+	// URLTunel es la URL base del despachador ("https://despachador:8080") a la que el
+	// nodo abre un túnel inverso para nodos detrás de NAT que no aceptan conexiones
+	// entrantes: el despachador consulta al nodo por esa conexión. Solo aplica si
+	// ConfigS3 != nil; con URLTunel, PuertoHTTP y Direccion son opcionales.
+	URLTunel string
+
+	// SecretoAutenticacion es el secreto compartido con el despachador para verificar
+	// las solicitudes firmadas (HMAC-SHA256) y los comandos encolados en S3, y firmar la
+	// apertura del túnel. Requerido con URLTunel, y con PuertoHTTP salvo que
+	// SinAutenticacion sea true. Sin secreto los comandos encolados se descartan.
+	SecretoAutenticacion string
+
+	// SinAutenticacion deshabilita la verificación de las solicitudes (solo para desarrollo)
+	SinAutenticacion bool
+
+	// ArchivoCertificadoTLS y ArchivoClaveTLS son los archivos PEM del certificado con el
+	// que la API HTTP sirve HTTPS. Deben indicarse ambos o ninguno.
+	ArchivoCertificadoTLS string
+	ArchivoClaveTLS       string
+
+	// TLSAutofirmado sirve HTTPS con un certificado autofirmado generado por el nodo.
+	// Su huella se publica en el registro para que el despachador la verifique.
+	// Se ignora si se indican archivos de certificado.
+	TLSAutofirmado bool
 }
 
 // Crear inicializa el ManagerEdge con las opciones especificadas.
@@ -66,28 +128,57 @@ func Crear(opts Opciones) (*ManagerEdge, error) {
 		return &ManagerEdge{}, fmt.Errorf("NombreDB es requerido")
 	}
 
-	// Validar opts.Dirección pública no sea vacía
-	if opts.Direccion == "" {
+	// Validar opts.Dirección pública no sea vacía (con túnel el despachador no la usa)
+	if opts.Direccion == "" && opts.URLTunel == "" {
 		return &ManagerEdge{}, fmt.Errorf("Dirección es requerida")
 	}
 
+	// Un cliente S3 inyectado usa el bucket y la disposición de claves de ConfigS3
+	if opts.ClienteS3 != nil && opts.ConfigS3 == nil {
+		return &ManagerEdge{}, fmt.Errorf("ClienteS3 requiere ConfigS3 (bucket y disposición de claves)")
+	}
+	if opts.Almacenamiento != nil && opts.ConfigS3 == nil {
+		return &ManagerEdge{}, fmt.Errorf("Almacenamiento requiere ConfigS3 (disposición de claves)")
+	}
+
+	// Validar archivos TLS
+	if (opts.ArchivoCertificadoTLS == "") != (opts.ArchivoClaveTLS == "") {
+		return &ManagerEdge{}, fmt.Errorf("ArchivoCertificadoTLS y ArchivoClaveTLS deben especificarse juntos")
+	}
+
 	// Validar puerto HTTP según configuración de S3
 	var puertoHTTP string
 	var err error
 	if opts.ConfigS3 != nil {
-		// Con S3: puerto HTTP es requerido
-		if opts.PuertoHTTP == "" {
+		// Con S3: puerto HTTP es requerido, salvo que el nodo se conecte por túnel
+		if opts.PuertoHTTP == "" && opts.URLTunel == "" {
 			return &ManagerEdge{}, fmt.Errorf("PuertoHTTP es requerido cuando ConfigS3 está configurado")
 		}
-		puertoHTTP, err = validarPuertoHTTP(opts.PuertoHTTP)
-		if err != nil {
-			return &ManagerEdge{}, err
+		if opts.SecretoAutenticacion == "" && !opts.SinAutenticacion {
+			return &ManagerEdge{}, fmt.Errorf("SecretoAutenticacion es requerido cuando PuertoHTTP o URLTunel están configurados")
+		}
+		if opts.URLTunel != "" && opts.SecretoAutenticacion == "" {
+			return &ManagerEdge{}, fmt.Errorf("URLTunel requiere SecretoAutenticacion (el despachador rechaza túneles sin firma)")
+		}
+		if opts.PuertoHTTP != "" {
+			puertoHTTP, err = validarPuertoHTTP(opts.PuertoHTTP)
+			if err != nil {
+				return &ManagerEdge{}, err
+			}
+		}
+		if opts.URLTunel != "" {
+			if err := validarURLTunel(opts.URLTunel); err != nil {
+				return &ManagerEdge{}, err
+			}
 		}
 	} else {
-		// Sin S3: puerto HTTP no debe especificarse
+		// Sin S3: puerto HTTP ni túnel deben especificarse
 		if opts.PuertoHTTP != "" {
 			return &ManagerEdge{}, fmt.Errorf("PuertoHTTP no debe especificarse sin ConfigS3 (no tiene sentido exponer HTTP sin registro en nube)")
 		}
+		if opts.URLTunel != "" {
+			return &ManagerEdge{}, fmt.Errorf("URLTunel no debe especificarse sin ConfigS3 (el despachador descubre los nodos por su registro en nube)")
+		}
 	}
 
 	// Aplicar defaults para buffer
@@ -112,6 +203,7 @@ func Crear(opts Opciones) (*ManagerEdge, error) {
 		db:            db,
 		direccion:     opts.Direccion,
 		puertoHTTP:    puertoHTTP,
+		urlTunel:      opts.URLTunel,
 		tags:          opts.Tags,
 		cache:         &Cache{datos: make(map[string]tipos.Serie)},
 		done:          make(chan struct{}),
@@ -140,6 +232,18 @@ func Crear(opts Opciones) (*ManagerEdge, error) {
 		log.Printf("NodoID cargado desde DB: %s", manager.nodoID)
 	}
 
+	// Cargar o generar la clave de firma del registro del nodo
+	manager.claveFirma, err = cargarClaveFirma(db)
+	if err != nil {
+		return &ManagerEdge{}, err
+	}
+	log.Printf("Clave pública del nodo: %s", manager.ClavePublica())
+
+	// Cargar el certificado TLS de la API HTTP (antes del registro, que publica el esquema)
+	if err := manager.configurarTLS(opts); err != nil {
+		return &ManagerEdge{}, err
+	}
+
 	// Cargar o actualizar tags del nodo
 	// Opción A: Si se especifican tags en opciones, sobrescriben los guardados
 	if opts.Tags != nil {
@@ -193,11 +297,19 @@ func Crear(opts Opciones) (*ManagerEdge, error) {
 	if opts.ConfigS3 != nil {
 		// Aplicar defaults y validar
 		opts.ConfigS3.AplicarDefaults()
-		if err := opts.ConfigS3.Validar(); err != nil {
+		if err := validarConfiguracionS3(opts); err != nil {
 			return &ManagerEdge{}, fmt.Errorf("configuración S3 inválida: %w", err)
 		}
 
-		err = manager.ConfigurarS3(*opts.ConfigS3)
+		switch {
+		case opts.Almacenamiento != nil:
+			err = manager.usarAlmacenamiento(opts.Almacenamiento, opts.ConfigS3.DisposicionClaves)
+		case opts.ClienteS3 != nil:
+			almacenamiento := tipos.NuevoAlmacenamientoS3(opts.ClienteS3, opts.ConfigS3.Bucket)
+			err = manager.usarAlmacenamiento(almacenamiento, opts.ConfigS3.DisposicionClaves)
+		default:
+			err = manager.ConfigurarS3(*opts.ConfigS3)
+		}
 		if err != nil {
 			log.Printf("Advertencia: error al configurar S3: %v", err)
 			log.Printf("El nodo funcionará en modo local")
@@ -214,19 +326,42 @@ func Crear(opts Opciones) (*ManagerEdge, error) {
 		return &ManagerEdge{}, fmt.Errorf("error al cargar reglas: %v", err)
 	}
 
+	// El secreto verifica la API HTTP y los comandos encolados, que los latidos
+	// comienzan a aplicar: debe estar antes de iniciarlos
+	if (puertoHTTP != "" || manager.urlTunel != "") && !opts.SinAutenticacion {
+		manager.secreto = []byte(opts.SecretoAutenticacion)
+		manager.verificador = tipos.NuevoVerificadorSolicitudes(manager.nodoID, manager.secreto, 0)
+	}
+
 	// Si S3 está configurado y se pudo conectar, registrar el nodo (incluye reglas)
-	if clienteS3 != nil {
+	if manager.almacenamiento != nil {
 		if err := manager.RegistrarEnS3(); err != nil {
 			log.Printf("Advertencia: error registrando nodo en S3: %v", err)
 		}
 		// Iniciar limpieza automática de S3 (eliminaciones pendientes)
 		manager.IniciarLimpiezaS3Automatica()
+
+		// Iniciar latidos para que el despachador sepa que el nodo está activo
+		intervaloLatido := opts.IntervaloLatido
+		if intervaloLatido <= 0 {
+			intervaloLatido = 30 * time.Second // Default: 30 segundos
+		}
+		manager.IniciarLatidos(intervaloLatido)
 	}
 
-	// Iniciar servidor HTTP solo si hay puerto configurado (modo conectado con S3)
-	if puertoHTTP != "" {
-		listoHTTP := manager.iniciarServidorHTTP()
-		<-listoHTTP
+	// Iniciar servidor HTTP y túnel solo si están configurados (modo conectado con S3)
+	if puertoHTTP != "" || manager.urlTunel != "" {
+		if opts.SinAutenticacion {
+			log.Printf("Advertencia: API HTTP sin autenticación (SinAutenticacion)")
+		}
+		handler := manager.handlerAPI()
+		if puertoHTTP != "" {
+			listoHTTP := manager.iniciarServidorHTTP(handler)
+			<-listoHTTP
+		}
+		if manager.urlTunel != "" {
+			manager.iniciarTunel(handler)
+		}
 	}
 
 	return manager, nil
@@ -237,6 +372,15 @@ func (me *ManagerEdge) ObtenerNodoID() string {
 	return me.nodoID
 }
 
+// ClavePublica retorna la clave pública Ed25519 con la que el nodo firma su registro
+// (base64), necesaria para inscribirlo en el despachador. "" si el nodo no firma.
+func (me *ManagerEdge) ClavePublica() string {
+	if me.claveFirma == nil {
+		return ""
+	}
+	return tipos.CodificarClavePublica(me.claveFirma.Public().(ed25519.PublicKey))
+}
+
 // ObtenerTags retorna los tags del nodo edge
 func (me *ManagerEdge) ObtenerTags() map[string]string {
 	return me.tags
@@ -268,7 +412,7 @@ func (me *ManagerEdge) ActualizarTags(tags map[string]string) error {
 	}
 
 	// Actualizar registro en S3 si está configurado
-	if clienteS3 != nil {
+	if me.almacenamiento != nil {
 		if err := me.RegistrarEnS3(); err != nil {
 			log.Printf("Advertencia: error actualizando tags en S3: %v", err)
 		}
@@ -337,6 +481,24 @@ func (me *ManagerEdge) Cerrar() {
 	// Señalar a todos los goroutines que deben terminar
 	close(me.done)
 
+	// Detener la API HTTP esperando las consultas en curso, antes de cerrar PebbleDB
+	if me.servidorHTTP != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := me.servidorHTTP.Shutdown(ctx); err != nil {
+			log.Printf("Error cerrando servidor HTTP: %v", err)
+		}
+		cancel()
+	}
+
+	// Cortar el túnel y esperar las solicitudes recibidas por él
+	me.cerrarTunel()
+
+	// Esperar a la gorutina de latidos: puede estar aplicando un comando sobre PebbleDB
+	me.latidos.Wait()
+
+	// Esperar a la migración solicitada por la API, cancelada al cerrar done
+	me.migraciones.Wait()
+
 	// Cerrar todos los buffers individuales
 	me.buffers.Range(func(key, value interface{}) bool {
 		buffer := value.(*SerieBuffer)
@@ -350,51 +512,8 @@ func (me *ManagerEdge) Cerrar() {
 
 // CrearSerie crea una nueva serie si no existe. Si ya existe, no hace nada.
 func (me *ManagerEdge) CrearSerie(config tipos.Serie) error {
-	// Validar campos obligatorios
-	if config.Path == "" {
-		return fmt.Errorf("el path de la serie no puede estar vacío")
-	}
-
-	// Validar que el path sea correcto
-	if !esPathValido(config.Path) {
-		return fmt.Errorf("el path de la serie tiene un formato inválido: %s", config.Path)
-	}
-
-	// Validar TipoDatos
-	tiposValidos := []tipos.TipoDatos{tipos.Boolean, tipos.Integer, tipos.Real, tipos.Text}
-	tipoDatosValido := false
-	for _, tipo := range tiposValidos {
-		if config.TipoDatos == tipo {
-			tipoDatosValido = true
-			break
-		}
-	}
-	if !tipoDatosValido {
-		return fmt.Errorf("tipo de datos inválido: %s, debe ser Boolean, Integer, Real o Text", config.TipoDatos)
-	}
-
-	// Validar TamañoBloque
-	// se encuentra en el rango de (0, 10000]
-	if config.TamañoBloque <= 0 || config.TamañoBloque > 10000 {
-		return fmt.Errorf("el tamaño del bloque debe ubicarse en el rango de(0, 10000], recibido: %d", config.TamañoBloque)
-	}
-
-	// Validar CompresionBloque
-	compresionBloqueValida := false
-	for _, compresion := range []tipos.TipoCompresionBloque{
-		tipos.Ninguna, tipos.LZ4, tipos.ZSTD, tipos.Snappy, tipos.Gzip} {
-		if config.CompresionBloque == compresion {
-			compresionBloqueValida = true
-			break
-		}
-	}
-	if !compresionBloqueValida {
-		return fmt.Errorf("tipo de compresión de bloque inválido: %s", config.CompresionBloque)
-	}
-
-	// Validar CompresionBytes - usar validación automática del sistema de tipos
-	if err := config.TipoDatos.ValidarCompresion(config.CompresionBytes); err != nil {
-		return fmt.Errorf("error en compresión de bytes: %v", err)
+	if err := validarSerie(config); err != nil {
+		return err
 	}
 
 	// Generar clave única basada en Path
@@ -468,7 +587,7 @@ func (me *ManagerEdge) CrearSerie(config tipos.Serie) error {
 	go me.manejarBuffer(buffer)
 
 	// Registrar nodo actualizado en S3 si está configurado
-	if clienteS3 != nil {
+	if me.almacenamiento != nil {
 		err = me.RegistrarEnS3()
 		if err != nil {
 			log.Printf("Error registrando serie nueva en S3: %v", err)
@@ -478,6 +597,119 @@ func (me *ManagerEdge) CrearSerie(config tipos.Serie) error {
 	return nil
 }
 
+// validarSerie verifica los campos de la configuración de una serie
+func validarSerie(config tipos.Serie) error {
+	// Validar campos obligatorios
+	if config.Path == "" {
+		return fmt.Errorf("el path de la serie no puede estar vacío")
+	}
+
+	// Validar que el path sea correcto
+	if !esPathValido(config.Path) {
+		return fmt.Errorf("el path de la serie tiene un formato inválido: %s", config.Path)
+	}
+
+	// Validar TipoDatos
+	tiposValidos := []tipos.TipoDatos{tipos.Boolean, tipos.Integer, tipos.Real, tipos.Text}
+	tipoDatosValido := false
+	for _, tipo := range tiposValidos {
+		if config.TipoDatos == tipo {
+			tipoDatosValido = true
+			break
+		}
+	}
+	if !tipoDatosValido {
+		return fmt.Errorf("tipo de datos inválido: %s, debe ser Boolean, Integer, Real o Text", config.TipoDatos)
+	}
+
+	// Validar TamañoBloque
+	// se encuentra en el rango de (0, 10000]
+	if config.TamañoBloque <= 0 || config.TamañoBloque > 10000 {
+		return fmt.Errorf("el tamaño del bloque debe ubicarse en el rango de(0, 10000], recibido: %d", config.TamañoBloque)
+	}
+
+	// Validar CompresionBloque
+	compresionBloqueValida := false
+	for _, compresion := range []tipos.TipoCompresionBloque{
+		tipos.Ninguna, tipos.LZ4, tipos.ZSTD, tipos.Snappy, tipos.Gzip} {
+		if config.CompresionBloque == compresion {
+			compresionBloqueValida = true
+			break
+		}
+	}
+	if !compresionBloqueValida {
+		return fmt.Errorf("tipo de compresión de bloque inválido: %s", config.CompresionBloque)
+	}
+
+	// Validar CompresionBytes - usar validación automática del sistema de tipos
+	if err := config.TipoDatos.ValidarCompresion(config.CompresionBytes); err != nil {
+		return fmt.Errorf("error en compresión de bytes: %v", err)
+	}
+
+	return nil
+}
+
+// ActualizarSerie actualiza los Tags y el TiempoAlmacenamiento de una serie existente.
+// El tipo de datos, las compresiones y el tamaño de bloque determinan cómo se leen los
+// bloques ya almacenados, por lo que no pueden cambiar.
+func (me *ManagerEdge) ActualizarSerie(config tipos.Serie) error {
+	me.cache.mu.Lock()
+	actual, existe := me.cache.datos[config.Path]
+	if !existe {
+		me.cache.mu.Unlock()
+		return fmt.Errorf("serie no encontrada: %s", config.Path)
+	}
+	if err := validarCambioSerie(actual, config); err != nil {
+		me.cache.mu.Unlock()
+		return err
+	}
+
+	actual.Tags = config.Tags
+	if actual.Tags == nil {
+		actual.Tags = make(map[string]string)
+	}
+	actual.TiempoAlmacenamiento = config.TiempoAlmacenamiento
+
+	serieBytes, err := tipos.SerializarGob(actual)
+	if err != nil {
+		me.cache.mu.Unlock()
+		return fmt.Errorf("error al serializar serie: %v", err)
+	}
+	if err := me.db.Set([]byte("series/"+config.Path), serieBytes, pebble.Sync); err != nil {
+		me.cache.mu.Unlock()
+		return fmt.Errorf("error al guardar serie: %v", err)
+	}
+	me.cache.datos[config.Path] = actual
+	me.cache.mu.Unlock()
+
+	log.Printf("Serie actualizada: %s", config.Path)
+
+	// Registrar nodo actualizado en S3 si está configurado
+	if me.almacenamiento != nil {
+		if err := me.RegistrarEnS3(); err != nil {
+			log.Printf("Error registrando serie actualizada en S3: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// validarCambioSerie verifica que la nueva configuración no modifique los campos
+// que definen el formato de los bloques almacenados
+func validarCambioSerie(actual, nueva tipos.Serie) error {
+	switch {
+	case nueva.TipoDatos != actual.TipoDatos:
+		return fmt.Errorf("serie %s: el tipo de datos no puede cambiar (%s → %s)", actual.Path, actual.TipoDatos, nueva.TipoDatos)
+	case nueva.CompresionBloque != actual.CompresionBloque:
+		return fmt.Errorf("serie %s: la compresión de bloque no puede cambiar (%s → %s)", actual.Path, actual.CompresionBloque, nueva.CompresionBloque)
+	case nueva.CompresionBytes != actual.CompresionBytes:
+		return fmt.Errorf("serie %s: la compresión de bytes no puede cambiar (%s → %s)", actual.Path, actual.CompresionBytes, nueva.CompresionBytes)
+	case nueva.TamañoBloque != actual.TamañoBloque:
+		return fmt.Errorf("serie %s: el tamaño de bloque no puede cambiar (%d → %d)", actual.Path, actual.TamañoBloque, nueva.TamañoBloque)
+	}
+	return nil
+}
+
 // manejarBuffer maneja la inserción y almacenamiento de datos en el buffer de una serie
 func (me *ManagerEdge) manejarBuffer(buffer *SerieBuffer) {
 	for {
@@ -745,7 +977,7 @@ func (me *ManagerEdge) EliminarSerie(path string) error {
 
 	// 1. Si S3 está configurado, guardar eliminación pendiente ANTES de eliminar localmente
 	// Esto garantiza que si el sistema falla, la eliminación de S3 se reintentará
-	if clienteS3 != nil {
+	if me.almacenamiento != nil {
 		if err := me.guardarEliminacionPendiente(serieId, path); err != nil {
 			log.Printf("Advertencia: error guardando eliminación pendiente: %v", err)
 			// Continuamos con la eliminación local de todas formas
@@ -789,12 +1021,17 @@ func (me *ManagerEdge) EliminarSerie(path string) error {
 	}
 	iter.Close()
 
-	// Eliminar bloques de datos
+	// Eliminar bloques de datos y su estado de migración
 	for _, clave := range clavesAEliminar {
 		if err := me.db.Delete(clave, pebble.Sync); err != nil {
 			log.Printf("Advertencia: error al eliminar bloque %s: %v", string(clave), err)
 		}
 	}
+	prefijoMigracion := []byte(fmt.Sprintf("migracion/%010d/", serieId))
+	finMigracion := []byte(fmt.Sprintf("migracion/%010d0", serieId)) // Siguiente serie
+	if err := me.db.DeleteRange(prefijoMigracion, finMigracion, pebble.Sync); err != nil {
+		log.Printf("Advertencia: error al eliminar estado de migración de la serie %d: %v", serieId, err)
+	}
 
 	// 4. Eliminar metadatos de la serie
 	claveSerie := []byte("series/" + path)
@@ -3,22 +3,32 @@ package edge
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/cbiale/sensorwave/compresor"
 	"github.com/cbiale/sensorwave/tipos"
+	"github.com/cbiale/sensorwave/tipos/s3fake"
 	"github.com/cockroachdb/pebble"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
 )
 
 // ============================================================================
@@ -242,6 +252,170 @@ func TestCrear_SinS3ConPuerto_Error(t *testing.T) {
 	t.Log("✓ Crear retorna error cuando hay puerto HTTP pero no S3")
 }
 
+// TestCrear_ConPuertoSinSecreto_Error verifica que la API HTTP requiere autenticación
+func TestCrear_ConPuertoSinSecreto_Error(t *testing.T) {
+	tempDir := t.TempDir()
+
+	_, err := Crear(Opciones{
+		NombreDB:   tempDir + "/test_secreto.db",
+		Direccion:  "http://127.0.0.1:8080",
+		PuertoHTTP: "8080",
+		ConfigS3: &tipos.ConfiguracionS3{
+			Endpoint: "http://localhost:9000",
+			Bucket:   "test",
+		},
+	})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "SecretoAutenticacion es requerido")
+	t.Log("✓ Crear retorna error cuando hay puerto HTTP sin secreto de autenticación")
+}
+
+// TestCrear_ClienteS3SinConfigS3_Error verifica que un cliente inyectado requiere ConfigS3
+func TestCrear_ClienteS3SinConfigS3_Error(t *testing.T) {
+	_, err := Crear(Opciones{
+		NombreDB:  t.TempDir() + "/test_cliente.db",
+		Direccion: "127.0.0.1",
+		ClienteS3: s3fake.Nuevo(s3fake.Opciones{}),
+	})
+	assert.ErrorContains(t, err, "ClienteS3 requiere ConfigS3")
+	t.Log("Crear retorna error cuando hay ClienteS3 sin ConfigS3")
+}
+
+// TestCrear_ClienteS3Inyectado verifica que cada manager usa su propio cliente S3:
+// dos nodos en el mismo proceso se registran cada uno en su almacenamiento
+func TestCrear_ClienteS3Inyectado(t *testing.T) {
+	crearNodo := func(bucket string) (*ManagerEdge, *s3fake.Cliente) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		_, puerto, _ := net.SplitHostPort(listener.Addr().String())
+		listener.Close()
+
+		cliente := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucket}})
+		manager, err := Crear(Opciones{
+			NombreDB:         t.TempDir() + "/" + bucket + ".db",
+			Direccion:        "127.0.0.1",
+			PuertoHTTP:       puerto,
+			SinAutenticacion: true,
+			// Sin endpoint ni credenciales: los aporta el cliente inyectado
+			ConfigS3:  &tipos.ConfiguracionS3{Bucket: bucket},
+			ClienteS3: cliente,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { manager.Cerrar() })
+		return manager, cliente
+	}
+
+	nodoA, clienteA := crearNodo("bucket-a")
+	nodoB, clienteB := crearNodo("bucket-b")
+
+	almacenamientoA := nodoA.almacenamiento.(*tipos.AlmacenamientoS3)
+	almacenamientoB := nodoB.almacenamiento.(*tipos.AlmacenamientoS3)
+	assert.Same(t, clienteA, almacenamientoA.Cliente())
+	assert.Same(t, clienteB, almacenamientoB.Cliente())
+	assert.Equal(t, "bucket-a", almacenamientoA.Bucket())
+	assert.Equal(t, "bucket-b", almacenamientoB.Bucket())
+
+	// Cada nodo quedó registrado solo en su cliente
+	contieneNodo := func(cliente *s3fake.Cliente, bucket, nodoID string) bool {
+		for _, clave := range cliente.Claves(bucket, "") {
+			if strings.Contains(clave, nodoID) {
+				return true
+			}
+		}
+		return false
+	}
+	assert.True(t, contieneNodo(clienteA, "bucket-a", nodoA.nodoID))
+	assert.False(t, contieneNodo(clienteA, "bucket-a", nodoB.nodoID))
+	assert.True(t, contieneNodo(clienteB, "bucket-b", nodoB.nodoID))
+	assert.False(t, contieneNodo(clienteB, "bucket-b", nodoA.nodoID))
+	t.Log("Crear usa el cliente S3 inyectado de cada manager sin compartirlo")
+}
+
+// TestCrear_AlmacenamientoSinConfigS3_Error verifica que el almacenamiento inyectado requiere ConfigS3
+func TestCrear_AlmacenamientoSinConfigS3_Error(t *testing.T) {
+	almacenamiento, err := tipos.NuevoAlmacenamientoLocal(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = Crear(Opciones{
+		NombreDB:       t.TempDir() + "/test_almacenamiento.db",
+		Direccion:      "127.0.0.1",
+		Almacenamiento: almacenamiento,
+	})
+	assert.ErrorContains(t, err, "Almacenamiento requiere ConfigS3")
+	t.Log("Crear rechaza Almacenamiento sin ConfigS3")
+}
+
+// TestCrear_AlmacenamientoLocal verifica que con Directorio el nodo se registra como
+// archivo del directorio, sin endpoint ni credenciales
+func TestCrear_AlmacenamientoLocal(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, puerto, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	directorio := t.TempDir()
+	manager, err := Crear(Opciones{
+		NombreDB:         t.TempDir() + "/test.db",
+		Direccion:        "127.0.0.1",
+		PuertoHTTP:       puerto,
+		SinAutenticacion: true,
+		ConfigS3:         &tipos.ConfiguracionS3{Directorio: directorio},
+	})
+	require.NoError(t, err)
+	defer manager.Cerrar()
+
+	local, ok := manager.almacenamiento.(*tipos.AlmacenamientoLocal)
+	require.True(t, ok, "se esperaba AlmacenamientoLocal, obtenido %T", manager.almacenamiento)
+	assert.Equal(t, directorio, local.Directorio())
+
+	datos, err := os.ReadFile(filepath.Join(directorio, "nodos", manager.nodoID+".json"))
+	require.NoError(t, err)
+	var nodo tipos.Nodo
+	require.NoError(t, json.Unmarshal(datos, &nodo))
+	assert.Equal(t, manager.nodoID, nodo.NodoID)
+	t.Log("Crear registra el nodo en el directorio local")
+}
+
+// TestCerrar_EsperaComandosPendientes verifica que Cerrar espera a que la gorutina de
+// latidos termine de aplicar los comandos encolados antes de cerrar PebbleDB
+func TestCerrar_EsperaComandosPendientes(t *testing.T) {
+	cliente := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucketTest}})
+	almacenamiento := tipos.NuevoAlmacenamientoS3(cliente, bucketTest)
+	nombreDB := t.TempDir() + "/test.db"
+
+	// El nodoID se conoce al crear la base: el comando se encola antes de iniciar los latidos
+	manager, err := Crear(Opciones{NombreDB: nombreDB, Direccion: "127.0.0.1"})
+	require.NoError(t, err)
+	nodoID := manager.nodoID
+	manager.Cerrar()
+
+	comando := tipos.Comando{ID: "00000000000000000001-a", NodoID: nodoID, Tipo: tipos.ComandoCrearSerie,
+		Serie: &tipos.Serie{Path: "sala/temp", TipoDatos: tipos.Real, CompresionBytes: tipos.SinCompresion,
+			CompresionBloque: tipos.Ninguna, TamañoBloque: 10}}
+	encolarComandoTest(t, cliente, comando)
+
+	// S3 lento: la gorutina de latidos sigue aplicando el comando cuando se cierra el nodo
+	cliente.Fallar(s3fake.Fallas{Latencia: 20 * time.Millisecond})
+	manager, err = Crear(Opciones{
+		NombreDB:             nombreDB,
+		Direccion:            "127.0.0.1",
+		URLTunel:             "http://127.0.0.1:1",
+		SecretoAutenticacion: secretoTest,
+		ConfigS3:             &tipos.ConfiguracionS3{},
+		Almacenamiento:       almacenamiento,
+	})
+	require.NoError(t, err)
+	manager.Cerrar()
+
+	// Al retornar Cerrar el comando ya fue aplicado y su resultado guardado
+	_, existe := cliente.Objeto("test-bucket", tipos.GenerarClaveS3ResultadoComando(nodoID, comando.ID))
+	assert.True(t, existe, "Cerrar debe esperar a la gorutina de latidos")
+	assert.Empty(t, cliente.Claves("test-bucket", tipos.GenerarPrefijoS3Comandos(nodoID)))
+
+	t.Log("Cerrar espera a los comandos pendientes antes de cerrar PebbleDB")
+}
+
 // ============================================================================
 // TESTS DE SERIES.GO
 // ============================================================================
@@ -894,78 +1068,69 @@ func crearManagerEdgeParaTest(t *testing.T) *ManagerEdge {
 }
 
 // ============================================================================
-// MOCK DE CLIENTE S3 PARA TESTS
+// HELPER: MANAGER CON S3 EN MEMORIA Y MANIFIESTO DE PRUEBA
 // ============================================================================
 
-// mockClienteS3 implementa tipos.ClienteS3 para testing
-type mockClienteS3 struct {
-	headBucketOutput   *s3.HeadBucketOutput
-	createBucketOutput *s3.CreateBucketOutput
-	listObjectsOutput  *s3.ListObjectsV2Output
-	getObjectOutput    *s3.GetObjectOutput
-	getObjectData      []byte
-	putObjectOutput    *s3.PutObjectOutput
-	deleteObjectOutput *s3.DeleteObjectOutput
+// bucketTest es el bucket del cliente S3 en memoria de los tests
+const bucketTest = "test-bucket"
 
-	headBucketErr   error
-	createBucketErr error
-	listObjectsErr  error
-	getObjectErr    error
-	putObjectErr    error
-	deleteObjectErr error
-
-	// Para verificar llamadas
-	putObjectCalls    int
-	deleteObjectCalls int
-}
+// secretoTest es el secreto compartido con el despachador de los managers con S3
+const secretoTest = "secreto-test"
 
-func (m *mockClienteS3) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
-	if m.headBucketErr != nil {
-		return nil, m.headBucketErr
-	}
-	return m.headBucketOutput, nil
+// opcionesManagerTest indica qué agrega crearManagerTest al manager mínimo
+type opcionesManagerTest struct {
+	S3           bool // Almacenamiento sobre un cliente S3 en memoria
+	TamañoPagina int  // Objetos por página de los listados S3 (0 = valor por defecto)
+	Manifiesto   bool // Aprovisionado con manifiestoTest
 }
 
-func (m *mockClienteS3) CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
-	if m.createBucketErr != nil {
-		return nil, m.createBucketErr
+// crearManagerTest crea un manager mínimo como crearManagerEdgeParaTest, aprovisionado
+// y con almacenamiento S3 en memoria según opts. Sin S3 el cliente retornado es nil.
+func crearManagerTest(t *testing.T, opts opcionesManagerTest) (*ManagerEdge, *s3fake.Cliente) {
+	manager := crearManagerEdgeParaTest(t)
+	if opts.Manifiesto {
+		manifiesto, err := DecodificarManifiesto([]byte(manifiestoTest))
+		require.NoError(t, err)
+		_, err = manager.Aprovisionar(manifiesto, OpcionesAprovisionamiento{})
+		require.NoError(t, err)
 	}
-	return m.createBucketOutput, nil
-}
 
-func (m *mockClienteS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
-	if m.listObjectsErr != nil {
-		return nil, m.listObjectsErr
+	var cliente *s3fake.Cliente
+	if opts.S3 {
+		cliente = s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucketTest}, TamañoPagina: opts.TamañoPagina})
+		manager.almacenamiento = tipos.NuevoAlmacenamientoS3(cliente, bucketTest)
+		manager.secreto = []byte(secretoTest) // Verifica los comandos encolados
 	}
-	return m.listObjectsOutput, nil
+	return manager, cliente
 }
 
-func (m *mockClienteS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
-	if m.getObjectErr != nil {
-		return nil, m.getObjectErr
-	}
-	if m.getObjectData != nil {
-		return &s3.GetObjectOutput{
-			Body: io.NopCloser(bytes.NewReader(m.getObjectData)),
-		}, nil
-	}
-	return m.getObjectOutput, nil
+// guardarObjetoTest guarda un objeto directamente en el bucket de prueba
+func guardarObjetoTest(t *testing.T, cliente *s3fake.Cliente, clave string, datos []byte) {
+	t.Helper()
+	_, err := cliente.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucketTest),
+		Key:    aws.String(clave),
+		Body:   bytes.NewReader(datos),
+	})
+	require.NoError(t, err)
 }
 
-func (m *mockClienteS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
-	m.putObjectCalls++
-	if m.putObjectErr != nil {
-		return nil, m.putObjectErr
-	}
-	return m.putObjectOutput, nil
+// encolarComandoTest encola el comando en S3 firmado con secretoTest, como el despachador
+func encolarComandoTest(t *testing.T, cliente *s3fake.Cliente, comando tipos.Comando) {
+	t.Helper()
+	encolado, err := tipos.FirmarComando(comando, []byte(secretoTest))
+	require.NoError(t, err)
+	datos, err := json.Marshal(encolado)
+	require.NoError(t, err)
+	guardarObjetoTest(t, cliente, tipos.GenerarClaveS3Comando(comando.NodoID, comando.ID), datos)
 }
 
-func (m *mockClienteS3) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
-	m.deleteObjectCalls++
-	if m.deleteObjectErr != nil {
-		return nil, m.deleteObjectErr
-	}
-	return m.deleteObjectOutput, nil
+// objetoTest retorna el contenido de un objeto del bucket de prueba, que debe existir
+func objetoTest(t *testing.T, cliente *s3fake.Cliente, clave string) []byte {
+	t.Helper()
+	datos, existe := cliente.Objeto(bucketTest, clave)
+	require.True(t, existe, "objeto %s", clave)
+	return datos
 }
 
 // ============================================================================
@@ -1668,6 +1833,30 @@ func TestHandleConsultaRango_Exitoso(t *testing.T) {
 	t.Log("handleConsultaRango procesa solicitud exitosamente")
 }
 
+// TestHandleConsultaAgregacionTemporal_JSON verifica solicitudes JSON con tiempos relativos
+// e intervalos legibles, y que la respuesta se envía en JSON
+func TestHandleConsultaAgregacionTemporal_JSON(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	prepararSerieVentanaTest(t, manager)
+
+	body := `{"serie": "sensor/temp", "tiempo_inicio": "now-1h", "tiempo_fin": "now", "agregaciones": ["maximo"], "intervalo": "2h"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/consulta/agregacion-temporal", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	manager.handleConsultaAgregacionTemporal(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var respuesta tipos.RespuestaConsultaAgregacionTemporal
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respuesta))
+	assert.Empty(t, respuesta.Error)
+	require.Len(t, respuesta.Resultado.Tiempos, 1)
+	assert.Equal(t, 40.0, respuesta.Resultado.Valores[0][0][0])
+	t.Log("handleConsultaAgregacionTemporal acepta JSON con tiempos relativos")
+}
+
 // TestHandleConsultaUltimo_Exitoso verifica consulta de último punto
 func TestHandleConsultaUltimo_Exitoso(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
@@ -1758,11 +1947,6 @@ func TestEnviarRespuestaError(t *testing.T) {
 func TestRegistrarEnS3_S3NoConfigurado(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
 
-	// Asegurar que clienteS3 es nil
-	clienteOriginal := clienteS3
-	clienteS3 = nil
-	defer func() { clienteS3 = clienteOriginal }()
-
 	err := manager.RegistrarEnS3()
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no está configurado")
@@ -1771,21 +1955,7 @@ func TestRegistrarEnS3_S3NoConfigurado(t *testing.T) {
 
 // TestRegistrarEnS3_Exitoso verifica registro exitoso
 func TestRegistrarEnS3_Exitoso(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
-
-	// Configurar mock
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
-
-	mockS3 := &mockClienteS3{
-		putObjectOutput: &s3.PutObjectOutput{},
-	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
 
 	// Agregar series al cache
 	manager.cache.mu.Lock()
@@ -1794,42 +1964,193 @@ func TestRegistrarEnS3_Exitoso(t *testing.T) {
 
 	err := manager.RegistrarEnS3()
 	assert.NoError(t, err)
-	assert.Equal(t, 1, mockS3.putObjectCalls)
+	assert.Equal(t, 1, cliente.Llamadas(s3fake.OperacionPutObject))
 	t.Log("RegistrarEnS3 registra nodo exitosamente")
 }
 
 // TestRegistrarEnS3_ErrorPutObject verifica manejo de error en PutObject
 func TestRegistrarEnS3_ErrorPutObject(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+	cliente.Fallar(s3fake.Fallas{TasaErrores: 1, Operaciones: []s3fake.Operacion{s3fake.OperacionPutObject}})
+
+	err := manager.RegistrarEnS3()
+	assert.Error(t, err)
+	t.Log("RegistrarEnS3 maneja error de PutObject")
+}
+
+// TestEnviarLatido_Exitoso verifica el objeto de latido y los datos de conexión del registro
+func TestEnviarLatido_Exitoso(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+
+	antes := time.Now().UnixNano()
+	require.NoError(t, manager.EnviarLatido())
+
+	var latido tipos.Latido
+	require.NoError(t, json.Unmarshal(objetoTest(t, cliente, tipos.GenerarClaveS3Latido(manager.nodoID)), &latido))
+	assert.Equal(t, manager.nodoID, latido.NodoID)
+	assert.GreaterOrEqual(t, latido.Momento, antes)
+	assert.Equal(t, tipos.Version, latido.Version)
+
+	require.NoError(t, manager.RegistrarEnS3())
+	var nodo tipos.Nodo
+	require.NoError(t, json.Unmarshal(objetoTest(t, cliente, "nodos/"+manager.nodoID+".json"), &nodo))
+	assert.GreaterOrEqual(t, nodo.UltimaConexion, antes)
+	assert.Equal(t, tipos.Version, nodo.Version)
+	t.Log("EnviarLatido actualiza el latido y el registro incluye la última conexión")
+}
+
+// TestRegistrarEnS3_RegistroFirmado verifica que el registro se firma con la clave del nodo
+func TestRegistrarEnS3_RegistroFirmado(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
+	clave, err := cargarClaveFirma(manager.db)
+	require.NoError(t, err)
+	manager.claveFirma = clave
 
-	// Configurar mock con error
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
+	cliente := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucketTest}})
+	manager.almacenamiento = tipos.NuevoAlmacenamientoS3(cliente, bucketTest)
 
-	mockS3 := &mockClienteS3{
-		putObjectErr: assert.AnError,
-	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
+	require.NoError(t, manager.RegistrarEnS3())
+	registro := objetoTest(t, cliente, "nodos/"+manager.nodoID+".json")
+	require.NoError(t, tipos.VerificarRegistroNodo(registro))
 
-	err := manager.RegistrarEnS3()
+	var nodo tipos.Nodo
+	require.NoError(t, json.Unmarshal(registro, &nodo))
+	assert.Equal(t, manager.ClavePublica(), nodo.ClavePublica)
+	assert.NotEmpty(t, nodo.Firma)
+	t.Log("RegistrarEnS3 firma el registro con la clave del nodo")
+}
+
+// TestCargarClaveFirma_Persistente verifica que la clave de firma se conserva entre reinicios
+func TestCargarClaveFirma_Persistente(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+
+	primera, err := cargarClaveFirma(manager.db)
+	require.NoError(t, err)
+	segunda, err := cargarClaveFirma(manager.db)
+	require.NoError(t, err)
+
+	assert.True(t, primera.Equal(segunda))
+	t.Log("cargarClaveFirma reutiliza la clave guardada en meta/clave_firma")
+}
+
+// TestCargarCertificadoAutofirmado_Persistente verifica que el certificado autofirmado se conserva entre reinicios
+func TestCargarCertificadoAutofirmado_Persistente(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+
+	primero, err := cargarCertificadoAutofirmado(manager.db, "127.0.0.1")
+	require.NoError(t, err)
+	segundo, err := cargarCertificadoAutofirmado(manager.db, "otro-host")
+	require.NoError(t, err)
+	assert.Equal(t, primero.Certificate[0], segundo.Certificate[0])
+
+	certificado, err := x509.ParseCertificate(primero.Certificate[0])
+	require.NoError(t, err)
+	assert.NoError(t, certificado.VerifyHostname("127.0.0.1"))
+	t.Log("cargarCertificadoAutofirmado reutiliza el certificado guardado en meta/tls_certificado")
+}
+
+// TestConfigurarTLS_Autofirmado verifica el servidor HTTPS autofirmado y la huella publicada en el registro
+func TestConfigurarTLS_Autofirmado(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	manager.direccion = "http://127.0.0.1:8080"
+	require.NoError(t, manager.configurarTLS(Opciones{TLSAutofirmado: true}))
+	require.NotEmpty(t, manager.huellaTLS)
+
+	// El servidor presenta el certificado cuya huella se publica
+	servidor := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	servidor.TLS = manager.configuracionTLS()
+	servidor.StartTLS()
+	defer servidor.Close()
+
+	conexion, err := tls.Dial("tcp", servidor.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	presentado := conexion.ConnectionState().PeerCertificates[0]
+	conexion.Close()
+	assert.Equal(t, manager.huellaTLS, tipos.HuellaCertificado(presentado.Raw))
+
+	cliente := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucketTest}})
+	manager.almacenamiento = tipos.NuevoAlmacenamientoS3(cliente, bucketTest)
+
+	require.NoError(t, manager.RegistrarEnS3())
+	var nodo tipos.Nodo
+	require.NoError(t, json.Unmarshal(objetoTest(t, cliente, "nodos/"+manager.nodoID+".json"), &nodo))
+	assert.Equal(t, tipos.EsquemaHTTPS, nodo.Esquema)
+	assert.Equal(t, manager.huellaTLS, nodo.HuellaCertificado)
+	assert.Equal(t, "https://127.0.0.1:8080", nodo.URLBase())
+	t.Log("El servidor HTTPS autofirmado presenta el certificado cuya huella publica el registro")
+}
+
+// TestCrear_ArchivosTLSIncompletos verifica que certificado y clave TLS se indiquen juntos
+func TestCrear_ArchivosTLSIncompletos(t *testing.T) {
+	_, err := Crear(Opciones{
+		NombreDB:              t.TempDir(),
+		Direccion:             "http://127.0.0.1:8080",
+		ArchivoCertificadoTLS: "certificado.pem",
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "deben especificarse juntos")
+	t.Log("Crear rechaza un certificado TLS sin su clave")
+}
+
+// TestAuthMiddleware_SolicitudFirmada verifica que se aceptan solo solicitudes firmadas
+func TestAuthMiddleware_SolicitudFirmada(t *testing.T) {
+	var cuerpoRecibido []byte
+	handler := authMiddleware(tipos.NuevoVerificadorSolicitudes("nodo-01", []byte("secreto"), 0))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cuerpoRecibido, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		}))
+
+	cuerpo := []byte("consulta")
+	firmada := httptest.NewRequest(http.MethodPost, "/api/consulta/rango", bytes.NewReader(cuerpo))
+	require.NoError(t, tipos.FirmarSolicitud(firmada, cuerpo, "nodo-01", []byte("secreto")))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, firmada)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cuerpo, cuerpoRecibido, "El handler recibe el cuerpo original")
+
+	// Repetir la misma solicitud firmada
+	repetida := httptest.NewRequest(http.MethodPost, "/api/consulta/rango", bytes.NewReader(cuerpo))
+	repetida.Header = firmada.Header.Clone()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, repetida)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// El token anterior (nodoID en texto plano) ya no autentica
+	bearer := httptest.NewRequest(http.MethodPost, "/api/consulta/rango", bytes.NewReader(cuerpo))
+	bearer.Header.Set("Authorization", "Bearer nodo-01")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, bearer)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	t.Log("authMiddleware acepta solicitudes firmadas y rechaza repeticiones y tokens en texto plano")
+}
+
+// TestAuthMiddleware_SinVerificador verifica el modo sin autenticación
+func TestAuthMiddleware_SinVerificador(t *testing.T) {
+	handler := authMiddleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/consulta/rango", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	t.Log("authMiddleware sin verificador deja pasar las solicitudes")
+}
+
+// TestEnviarLatido_S3NoConfigurado verifica error cuando S3 no está configurado
+func TestEnviarLatido_S3NoConfigurado(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+
+	err := manager.EnviarLatido()
 	assert.Error(t, err)
-	t.Log("RegistrarEnS3 maneja error de PutObject")
+	assert.Contains(t, err.Error(), "no está configurado")
+	t.Log("EnviarLatido retorna error cuando S3 no está configurado")
 }
 
 // TestMigrarAS3_S3NoConfigurado verifica error sin configuración
 func TestMigrarAS3_S3NoConfigurado(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
 
-	// Asegurar que clienteS3 es nil y no hay variables de entorno
-	clienteOriginal := clienteS3
-	clienteS3 = nil
-	defer func() { clienteS3 = clienteOriginal }()
-
 	err := manager.MigrarAS3()
 	assert.Error(t, err)
 	t.Log("MigrarAS3 retorna error cuando S3 no está configurado")
@@ -1839,10 +2160,6 @@ func TestMigrarAS3_S3NoConfigurado(t *testing.T) {
 func TestMigrarPorTiempoAlmacenamiento_S3NoConfigurado(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
 
-	clienteOriginal := clienteS3
-	clienteS3 = nil
-	defer func() { clienteS3 = clienteOriginal }()
-
 	err := manager.MigrarPorTiempoAlmacenamiento()
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no está configurado")
@@ -1851,14 +2168,7 @@ func TestMigrarPorTiempoAlmacenamiento_S3NoConfigurado(t *testing.T) {
 
 // TestMigrarPorTiempoAlmacenamiento_SinSeriesConTiempo verifica cuando no hay series con tiempo
 func TestMigrarPorTiempoAlmacenamiento_SinSeriesConTiempo(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
-
-	// Configurar mock
-	clienteOriginal := clienteS3
-	defer func() { clienteS3 = clienteOriginal }()
-
-	mockS3 := &mockClienteS3{}
-	clienteS3 = mockS3
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
 
 	// Agregar serie SIN TiempoAlmacenamiento
 	manager.cache.mu.Lock()
@@ -1870,27 +2180,13 @@ func TestMigrarPorTiempoAlmacenamiento_SinSeriesConTiempo(t *testing.T) {
 
 	err := manager.MigrarPorTiempoAlmacenamiento()
 	assert.NoError(t, err)
-	assert.Equal(t, 0, mockS3.putObjectCalls) // No debe migrar nada
+	assert.Equal(t, 0, cliente.Llamadas(s3fake.OperacionPutObject)) // No debe migrar nada
 	t.Log("MigrarPorTiempoAlmacenamiento no hace nada si no hay series con tiempo")
 }
 
 // TestMigrarPorTiempoAlmacenamiento_MigraBloquesAntiguos verifica migración de bloques antiguos
 func TestMigrarPorTiempoAlmacenamiento_MigraBloquesAntiguos(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
-
-	// Configurar mock
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
-
-	mockS3 := &mockClienteS3{
-		putObjectOutput: &s3.PutObjectOutput{},
-	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
 
 	// Serie con tiempo de almacenamiento de 1 hora
 	serie := tipos.Serie{
@@ -1917,19 +2213,15 @@ func TestMigrarPorTiempoAlmacenamiento_MigraBloquesAntiguos(t *testing.T) {
 
 	err := manager.MigrarPorTiempoAlmacenamiento()
 	assert.NoError(t, err)
-	assert.Equal(t, 1, mockS3.putObjectCalls)
+	// Un PutObject para el bloque y otro para el manifiesto de la serie
+	assert.Equal(t, 2, cliente.Llamadas(s3fake.OperacionPutObject))
 	t.Log("MigrarPorTiempoAlmacenamiento migra bloques antiguos correctamente")
 }
 
-// ============================================================================
-// TESTS DE CONSULTAS DE AGREGACIÓN (consultas.go)
-// ============================================================================
-
-// TestConsultarAgregacion_SerieExacta_Promedio verifica agregación AVG sobre una serie
-func TestConsultarAgregacion_SerieExacta_Promedio(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
+// TestMigrarAS3_ActualizaManifiesto verifica que la migración mantiene el manifiesto de la serie
+func TestMigrarAS3_ActualizaManifiesto(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true, TamañoPagina: 1})
 
-	// Crear serie
 	serie := tipos.Serie{
 		SerieId:          1,
 		Path:             "sensor/temp",
@@ -1942,110 +2234,464 @@ func TestConsultarAgregacion_SerieExacta_Promedio(t *testing.T) {
 	manager.cache.datos["sensor/temp"] = serie
 	manager.cache.mu.Unlock()
 
-	// Crear mediciones: 10, 20, 30 → promedio = 20
-	ahora := time.Now().UnixNano()
-	mediciones := []tipos.Medicion{
-		{Tiempo: ahora - 3000, Valor: float64(10.0)},
-		{Tiempo: ahora - 2000, Valor: float64(20.0)},
-		{Tiempo: ahora - 1000, Valor: float64(30.0)},
+	// Bloque ya migrado por una versión anterior (sin manifiesto)
+	claveExistente := tipos.GenerarClaveS3Datos(manager.nodoID, 1, 100, 200)
+	guardarObjetoTest(t, cliente, claveExistente, []byte("bloque previo"))
+
+	// Dos bloques locales nuevos
+	for _, inicio := range []int64{1000, 2000} {
+		mediciones := []tipos.Medicion{
+			{Tiempo: inicio, Valor: float64(inicio) / 100},
+			{Tiempo: inicio + 500, Valor: float64(inicio)/100 + 5},
+		}
+		bloque := crearBloqueComprimidoTest(t, serie, mediciones)
+		require.NoError(t, manager.db.Set(generarClaveDatos(1, inicio, inicio+500), bloque, pebble.Sync))
 	}
 
-	// Guardar bloque
-	bloque := crearBloqueComprimidoTest(t, serie, mediciones)
-	clave := generarClaveDatos(serie.SerieId, ahora-3000, ahora-1000)
-	err := manager.db.Set(clave, bloque, pebble.Sync)
-	require.NoError(t, err)
+	require.NoError(t, manager.MigrarAS3())
 
-	// Consultar promedio
-	resultado, err := manager.ConsultarAgregacion(
-		"sensor/temp",
-		time.Unix(0, ahora-5000),
-		time.Unix(0, ahora),
-		[]tipos.TipoAgregacion{tipos.AgregacionPromedio},
-	)
+	manifiesto, err := tipos.LeerManifiesto(context.Background(), manager.almacenamiento, manager.nodoID, 1)
 	require.NoError(t, err)
-	require.Len(t, resultado.Series, 1)
-	assert.Equal(t, "sensor/temp", resultado.Series[0])
-	require.Len(t, resultado.Agregaciones, 1)
-	assert.Equal(t, tipos.AgregacionPromedio, resultado.Agregaciones[0])
-	assert.Equal(t, 20.0, resultado.Valores[0][0]) // [agregacion][serie]
-	t.Log("ConsultarAgregacion calcula promedio correctamente")
+	assert.Equal(t, "sensor/temp", manifiesto.Path)
+	require.Len(t, manifiesto.Bloques, 3)
+	assert.Equal(t, claveExistente, manifiesto.Bloques[0].Clave)
+
+	nuevo := manifiesto.Bloques[1]
+	assert.Equal(t, int64(1000), nuevo.TiempoInicio)
+	assert.Equal(t, int64(1500), nuevo.TiempoFin)
+	assert.Equal(t, 2, nuevo.Mediciones)
+	require.NotNil(t, nuevo.Minimo)
+	require.NotNil(t, nuevo.Maximo)
+	assert.Equal(t, 10.0, *nuevo.Minimo)
+	assert.Equal(t, 15.0, *nuevo.Maximo)
+
+	// Los bloques locales se eliminan una vez referenciados por el manifiesto
+	iter, err := manager.db.NewIter(&pebble.IterOptions{LowerBound: []byte("data/"), UpperBound: []byte("data0")})
+	require.NoError(t, err)
+	assert.False(t, iter.First())
+	iter.Close()
+
+	t.Log("MigrarAS3 actualiza el manifiesto con rangos y estadísticas de cada bloque")
 }
 
-// TestConsultarAgregacion_SerieExacta_MinMax verifica MIN y MAX
-func TestConsultarAgregacion_SerieExacta_MinMax(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
+// TestMigrarAS3_DisposicionParticionada verifica las claves particionadas y su anuncio en el registro
+func TestMigrarAS3_DisposicionParticionada(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+	manager.disposicionClaves = tipos.DisposicionParticionada
 
-	serie := tipos.Serie{
-		SerieId:          1,
-		Path:             "sensor/temp",
-		TipoDatos:        tipos.Real,
-		TamañoBloque:     100,
-		CompresionBloque: tipos.Ninguna,
-		CompresionBytes:  tipos.SinCompresion,
-	}
-	manager.cache.mu.Lock()
-	manager.cache.datos["sensor/temp"] = serie
-	manager.cache.mu.Unlock()
+	inicio := time.Date(2024, 3, 14, 10, 0, 0, 0, time.UTC).UnixNano()
+	fin := time.Date(2024, 3, 14, 11, 0, 0, 0, time.UTC).UnixNano()
+	require.NoError(t, manager.db.Set(generarClaveDatos(1, inicio, fin), []byte("bloque"), pebble.Sync))
 
-	ahora := time.Now().UnixNano()
-	mediciones := []tipos.Medicion{
-		{Tiempo: ahora - 3000, Valor: float64(15.0)},
-		{Tiempo: ahora - 2000, Valor: float64(5.0)},  // MIN
-		{Tiempo: ahora - 1000, Valor: float64(25.0)}, // MAX
-	}
+	require.NoError(t, manager.MigrarAS3())
 
-	bloque := crearBloqueComprimidoTest(t, serie, mediciones)
-	clave := generarClaveDatos(serie.SerieId, ahora-3000, ahora-1000)
-	manager.db.Set(clave, bloque, pebble.Sync)
+	clave := fmt.Sprintf("%s/0000000001/2024/03/14/0000000001_%020d_%020d", manager.nodoID, inicio, fin)
+	assert.Contains(t, cliente.Claves(bucketTest, ""), clave)
 
-	// MIN
-	minResult, err := manager.ConsultarAgregacion(
-		"sensor/temp",
-		time.Unix(0, ahora-5000),
-		time.Unix(0, ahora),
-		[]tipos.TipoAgregacion{tipos.AgregacionMinimo},
-	)
-	require.NoError(t, err)
-	require.Len(t, minResult.Series, 1)
-	assert.Equal(t, 5.0, minResult.Valores[0][0]) // [agregacion][serie]
+	require.NoError(t, manager.RegistrarEnS3())
+	var nodo tipos.Nodo
+	require.NoError(t, json.Unmarshal(objetoTest(t, cliente, "nodos/"+manager.nodoID+".json"), &nodo))
+	assert.Equal(t, tipos.DisposicionParticionada, nodo.DisposicionClaves)
 
-	// MAX
-	maxResult, err := manager.ConsultarAgregacion(
-		"sensor/temp",
-		time.Unix(0, ahora-5000),
-		time.Unix(0, ahora),
-		[]tipos.TipoAgregacion{tipos.AgregacionMaximo},
-	)
-	require.NoError(t, err)
-	require.Len(t, maxResult.Series, 1)
-	assert.Equal(t, 25.0, maxResult.Valores[0][0]) // [agregacion][serie]
+	t.Log("MigrarAS3 usa la disposición particionada configurada y el nodo la anuncia")
+}
 
-	t.Log("ConsultarAgregacion calcula MIN y MAX correctamente")
+// TestMigrarAS3_ErrorManifiestoConservaBloques verifica que sin manifiesto actualizado
+// los bloques no se eliminan localmente
+func TestMigrarAS3_ErrorManifiestoConservaBloques(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+	cliente.Fallar(s3fake.Fallas{Interceptar: func(op s3fake.Operacion, bucket, clave string) error {
+		if op == s3fake.OperacionPutObject && strings.HasSuffix(clave, "manifiesto.json") {
+			return fmt.Errorf("error simulado subiendo manifiesto")
+		}
+		return nil
+	}})
+
+	clave := generarClaveDatos(1, 1000, 2000)
+	require.NoError(t, manager.db.Set(clave, []byte("bloque"), pebble.Sync))
+
+	err := manager.MigrarAS3()
+	assert.Error(t, err)
+
+	_, closer, err := manager.db.Get(clave)
+	require.NoError(t, err, "el bloque debe conservarse localmente")
+	closer.Close()
+
+	t.Log("MigrarAS3 conserva los bloques locales si no puede actualizar el manifiesto")
 }
 
-// TestConsultarAgregacion_SerieExacta_SumaCount verifica SUM y COUNT
-func TestConsultarAgregacion_SerieExacta_SumaCount(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
+// TestMigrarBloquesSerie_ErrorManifiestoConservaErrores verifica que si falla el manifiesto
+// se informan los bloques subidos y también los errores de los bloques que fallaron
+func TestMigrarBloquesSerie_ErrorManifiestoConservaErrores(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+	claveFallida := tipos.GenerarClaveS3Datos(manager.nodoID, 1, 3000, 4000)
+	cliente.Fallar(s3fake.Fallas{Interceptar: func(op s3fake.Operacion, bucket, clave string) error {
+		if op == s3fake.OperacionPutObject && strings.HasSuffix(clave, "manifiesto.json") {
+			return fmt.Errorf("error simulado subiendo manifiesto")
+		}
+		if op == s3fake.OperacionPutObject && clave == claveFallida {
+			return fmt.Errorf("error simulado subiendo bloque")
+		}
+		return nil
+	}})
 
-	serie := tipos.Serie{
-		SerieId:          1,
-		Path:             "sensor/temp",
-		TipoDatos:        tipos.Real,
-		TamañoBloque:     100,
-		CompresionBloque: tipos.Ninguna,
-		CompresionBytes:  tipos.SinCompresion,
+	bloques := []bloqueLocal{
+		{clave: generarClaveDatos(1, 1000, 2000), valor: []byte("bloque 1"), tiempoInicio: 1000, tiempoFin: 2000},
+		{clave: generarClaveDatos(1, 3000, 4000), valor: []byte("bloque 2"), tiempoInicio: 3000, tiempoFin: 4000},
 	}
-	manager.cache.mu.Lock()
-	manager.cache.datos["sensor/temp"] = serie
-	manager.cache.mu.Unlock()
+	informe := &InformeMigracion{}
+	migrados, err := manager.migrarBloquesSerie(context.Background(), 1, nil, bloques, informe)
 
-	ahora := time.Now().UnixNano()
-	mediciones := []tipos.Medicion{
-		{Tiempo: ahora - 3000, Valor: float64(10.0)},
-		{Tiempo: ahora - 2000, Valor: float64(20.0)},
-		{Tiempo: ahora - 1000, Valor: float64(30.0)},
-	}
+	require.Error(t, err)
+	assert.Equal(t, 1, migrados, "el bloque subido y verificado se informa")
+	assert.Contains(t, err.Error(), "manifiesto")
+	assert.Contains(t, err.Error(), "y 1 errores más", "se conserva el error del bloque fallido")
+	t.Log("migrarBloquesSerie informa los bloques subidos y todos los errores si falla el manifiesto")
+}
+
+// TestMigrarAS3_FallaTransitoriaS3 verifica con el cliente S3 en memoria que una
+// migración interrumpida por errores de S3 se completa al reintentar
+func TestMigrarAS3_FallaTransitoriaS3(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+
+	clave := generarClaveDatos(1, 1000, 2000)
+	require.NoError(t, manager.db.Set(clave, []byte("bloque"), pebble.Sync))
+
+	// S3 rechaza todas las escrituras
+	cliente.Fallar(s3fake.Fallas{TasaErrores: 1, Operaciones: []s3fake.Operacion{s3fake.OperacionPutObject}})
+	assert.Error(t, manager.MigrarAS3())
+	assert.Empty(t, cliente.Claves("test-bucket", manager.nodoID+"/"))
+	_, closer, err := manager.db.Get(clave)
+	require.NoError(t, err, "el bloque debe conservarse localmente")
+	closer.Close()
+
+	// Al recuperarse S3 el reintento sube el bloque y su manifiesto
+	cliente.Fallar(s3fake.Fallas{})
+	require.NoError(t, manager.MigrarAS3())
+	datos, existe := cliente.Objeto("test-bucket", tipos.GenerarClaveS3Datos(manager.nodoID, 1, 1000, 2000))
+	require.True(t, existe)
+	assert.Equal(t, "bloque", string(datos))
+	_, _, err = manager.db.Get(clave)
+	assert.ErrorIs(t, err, pebble.ErrNotFound)
+
+	t.Log("MigrarAS3 completa la migración al reintentar tras errores de S3")
+}
+
+// ============================================================================
+// TESTS DE ESTADO DE LA MIGRACIÓN (migracion_estado.go)
+// ============================================================================
+
+// ultimaMigracionTest retorna el informe de la última migración del manager
+func ultimaMigracionTest(t *testing.T, manager *ManagerEdge) EstadoMigracion {
+	estado, err := manager.EstadoMigracion()
+	require.NoError(t, err)
+	require.NotNil(t, estado.Ultima)
+	assert.Nil(t, estado.EnCurso)
+	return estado
+}
+
+// TestMigrarAS3_ReanudaBloqueSubido verifica que un bloque subido antes de una caída no
+// se vuelve a subir: se verifica, se agrega al manifiesto y se elimina localmente
+func TestMigrarAS3_ReanudaBloqueSubido(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+	ctx := context.Background()
+
+	clave := generarClaveDatos(1, 1000, 2000)
+	require.NoError(t, manager.db.Set(clave, []byte("bloque"), pebble.Sync))
+
+	// Estado dejado por una migración interrumpida tras la subida
+	claveS3 := tipos.GenerarClaveS3Datos(manager.nodoID, 1, 1000, 2000)
+	etag, err := manager.almacenamiento.Guardar(ctx, claveS3, []byte("bloque"), tipos.OpcionesGuardar{})
+	require.NoError(t, err)
+	suma := md5.Sum([]byte("bloque"))
+	require.NoError(t, manager.guardarRegistroMigracion(clave, &RegistroMigracion{
+		Clave: claveS3, MD5: hex.EncodeToString(suma[:]), ETag: etag, Estado: BloqueSubido,
+	}))
+
+	estado, err := manager.EstadoMigracion()
+	require.NoError(t, err)
+	assert.Equal(t, 1, estado.Bloques[BloqueSubido])
+
+	subidasPrevias := cliente.Llamadas(s3fake.OperacionPutObject)
+	require.NoError(t, manager.MigrarAS3())
+	assert.Equal(t, subidasPrevias+1, cliente.Llamadas(s3fake.OperacionPutObject), "solo se sube el manifiesto")
+
+	manifiesto, err := tipos.LeerManifiesto(ctx, manager.almacenamiento, manager.nodoID, 1)
+	require.NoError(t, err)
+	require.Len(t, manifiesto.Bloques, 1)
+	assert.Equal(t, etag, manifiesto.Bloques[0].ETag)
+
+	_, _, err = manager.db.Get(clave)
+	assert.ErrorIs(t, err, pebble.ErrNotFound)
+
+	estado = ultimaMigracionTest(t, manager)
+	assert.Empty(t, estado.Bloques, "el registro se elimina con el bloque local")
+	assert.Equal(t, "completa", estado.Ultima.Tipo)
+	assert.Equal(t, 1, estado.Ultima.Migrados)
+	assert.Equal(t, 1, estado.Ultima.Reanudados)
+	assert.Zero(t, estado.Ultima.Bytes)
+	assert.NotZero(t, estado.Ultima.Fin)
+
+	t.Log("MigrarAS3 retoma un bloque subido sin volver a subirlo")
+}
+
+// TestMigrarAS3_ObjetoExistenteIgual verifica que un bloque ya presente en S3 con el mismo
+// contenido (subida sin registro previo a una caída) se migra sin sobrescribirlo
+func TestMigrarAS3_ObjetoExistenteIgual(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+
+	clave := generarClaveDatos(1, 1000, 2000)
+	require.NoError(t, manager.db.Set(clave, []byte("bloque"), pebble.Sync))
+	claveS3 := tipos.GenerarClaveS3Datos(manager.nodoID, 1, 1000, 2000)
+	_, err := manager.almacenamiento.Guardar(context.Background(), claveS3, []byte("bloque"), tipos.OpcionesGuardar{})
+	require.NoError(t, err)
+
+	require.NoError(t, manager.MigrarAS3())
+
+	datos, existe := cliente.Objeto("test-bucket", claveS3)
+	require.True(t, existe)
+	assert.Equal(t, "bloque", string(datos))
+	_, _, err = manager.db.Get(clave)
+	assert.ErrorIs(t, err, pebble.ErrNotFound)
+
+	estado := ultimaMigracionTest(t, manager)
+	assert.Equal(t, 1, estado.Ultima.Migrados)
+	assert.Equal(t, 1, estado.Ultima.Existentes)
+	assert.Zero(t, estado.Ultima.Bytes)
+
+	t.Log("MigrarAS3 verifica y adopta el objeto existente con el mismo contenido")
+}
+
+// TestMigrarAS3_ConflictoConservaBloque verifica que un objeto existente con otro contenido
+// no se sobrescribe y el bloque local se conserva con su estado de migración
+func TestMigrarAS3_ConflictoConservaBloque(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+
+	clave := generarClaveDatos(1, 1000, 2000)
+	require.NoError(t, manager.db.Set(clave, []byte("bloque"), pebble.Sync))
+	claveS3 := tipos.GenerarClaveS3Datos(manager.nodoID, 1, 1000, 2000)
+	_, err := manager.almacenamiento.Guardar(context.Background(), claveS3, []byte("otro"), tipos.OpcionesGuardar{})
+	require.NoError(t, err)
+
+	err = manager.MigrarAS3()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), claveS3)
+
+	datos, _ := cliente.Objeto("test-bucket", claveS3)
+	assert.Equal(t, "otro", string(datos), "el objeto existente no se sobrescribe")
+	_, closer, err := manager.db.Get(clave)
+	require.NoError(t, err, "el bloque debe conservarse localmente")
+	closer.Close()
+
+	registro, existe, err := manager.leerRegistroMigracion(clave)
+	require.NoError(t, err)
+	require.True(t, existe)
+	assert.Equal(t, BloquePendiente, registro.Estado)
+	assert.Equal(t, 1, registro.Intentos)
+	assert.NotEmpty(t, registro.UltimoError)
+
+	estado := ultimaMigracionTest(t, manager)
+	assert.Equal(t, 1, estado.Bloques[BloquePendiente])
+	assert.Equal(t, 1, estado.Ultima.Conflictos)
+	assert.Zero(t, estado.Ultima.Migrados)
+	require.Len(t, estado.Ultima.Errores, 1)
+
+	t.Log("MigrarAS3 no sobrescribe objetos con otro contenido y conserva el bloque")
+}
+
+// TestMigrarAS3_BloqueReescritoReiniciaEstado verifica que si el bloque local cambió desde
+// el último intento su estado de migración se descarta y se vuelve a subir
+func TestMigrarAS3_BloqueReescritoReiniciaEstado(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+
+	clave := generarClaveDatos(1, 1000, 2000)
+	require.NoError(t, manager.db.Set(clave, []byte("bloque nuevo"), pebble.Sync))
+	require.NoError(t, manager.guardarRegistroMigracion(clave, &RegistroMigracion{
+		Clave:  tipos.GenerarClaveS3Datos(manager.nodoID, 1, 1000, 2000),
+		MD5:    "00000000000000000000000000000000",
+		Estado: BloqueVerificado,
+	}))
+
+	require.NoError(t, manager.MigrarAS3())
+
+	datos, existe := cliente.Objeto("test-bucket", tipos.GenerarClaveS3Datos(manager.nodoID, 1, 1000, 2000))
+	require.True(t, existe)
+	assert.Equal(t, "bloque nuevo", string(datos))
+
+	estado := ultimaMigracionTest(t, manager)
+	assert.Zero(t, estado.Ultima.Reanudados)
+	assert.Equal(t, int64(len("bloque nuevo")), estado.Ultima.Bytes)
+
+	t.Log("MigrarAS3 descarta el estado de un bloque reescrito localmente")
+}
+
+// TestMigrarAS3_ContinuaTrasError verifica que el error de una serie no detiene la
+// migración de las demás
+func TestMigrarAS3_ContinuaTrasError(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+
+	require.NoError(t, manager.db.Set(generarClaveDatos(1, 1000, 2000), []byte("bloque 1"), pebble.Sync))
+	require.NoError(t, manager.db.Set(generarClaveDatos(2, 1000, 2000), []byte("bloque 2"), pebble.Sync))
+
+	// Falla la subida de los bloques de la serie 1
+	claveFallida := tipos.GenerarClaveS3Datos(manager.nodoID, 1, 1000, 2000)
+	cliente.Fallar(s3fake.Fallas{Interceptar: func(op s3fake.Operacion, bucket, clave string) error {
+		if op == s3fake.OperacionPutObject && clave == claveFallida {
+			return s3fake.ErrorS3(http.StatusServiceUnavailable, "SlowDown", "error simulado")
+		}
+		return nil
+	}})
+
+	assert.Error(t, manager.MigrarAS3())
+
+	_, existe := cliente.Objeto("test-bucket", tipos.GenerarClaveS3Datos(manager.nodoID, 2, 1000, 2000))
+	assert.True(t, existe, "la serie 2 se migra aunque falle la serie 1")
+	_, closer, err := manager.db.Get(generarClaveDatos(1, 1000, 2000))
+	require.NoError(t, err)
+	closer.Close()
+
+	estado := ultimaMigracionTest(t, manager)
+	assert.Equal(t, 2, estado.Ultima.Bloques)
+	assert.Equal(t, 2, estado.Ultima.Procesados)
+	assert.Equal(t, 1, estado.Ultima.Migrados)
+	assert.Equal(t, 1, estado.Ultima.Fallidos)
+	assert.Equal(t, 1, estado.Bloques[BloquePendiente])
+
+	// Al recuperarse S3 el bloque pendiente se migra
+	cliente.Fallar(s3fake.Fallas{})
+	require.NoError(t, manager.MigrarAS3())
+	estado = ultimaMigracionTest(t, manager)
+	assert.Equal(t, 1, estado.Ultima.Migrados)
+	assert.Empty(t, estado.Bloques)
+
+	t.Log("MigrarAS3 continúa con las demás series ante un error")
+}
+
+// ============================================================================
+// TESTS DE CONSULTAS DE AGREGACIÓN (consultas.go)
+// ============================================================================
+
+// TestConsultarAgregacion_SerieExacta_Promedio verifica agregación AVG sobre una serie
+func TestConsultarAgregacion_SerieExacta_Promedio(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+
+	// Crear serie
+	serie := tipos.Serie{
+		SerieId:          1,
+		Path:             "sensor/temp",
+		TipoDatos:        tipos.Real,
+		TamañoBloque:     100,
+		CompresionBloque: tipos.Ninguna,
+		CompresionBytes:  tipos.SinCompresion,
+	}
+	manager.cache.mu.Lock()
+	manager.cache.datos["sensor/temp"] = serie
+	manager.cache.mu.Unlock()
+
+	// Crear mediciones: 10, 20, 30 → promedio = 20
+	ahora := time.Now().UnixNano()
+	mediciones := []tipos.Medicion{
+		{Tiempo: ahora - 3000, Valor: float64(10.0)},
+		{Tiempo: ahora - 2000, Valor: float64(20.0)},
+		{Tiempo: ahora - 1000, Valor: float64(30.0)},
+	}
+
+	// Guardar bloque
+	bloque := crearBloqueComprimidoTest(t, serie, mediciones)
+	clave := generarClaveDatos(serie.SerieId, ahora-3000, ahora-1000)
+	err := manager.db.Set(clave, bloque, pebble.Sync)
+	require.NoError(t, err)
+
+	// Consultar promedio
+	resultado, err := manager.ConsultarAgregacion(
+		"sensor/temp",
+		time.Unix(0, ahora-5000),
+		time.Unix(0, ahora),
+		[]tipos.TipoAgregacion{tipos.AgregacionPromedio},
+	)
+	require.NoError(t, err)
+	require.Len(t, resultado.Series, 1)
+	assert.Equal(t, "sensor/temp", resultado.Series[0])
+	require.Len(t, resultado.Agregaciones, 1)
+	assert.Equal(t, tipos.AgregacionPromedio, resultado.Agregaciones[0])
+	assert.Equal(t, 20.0, resultado.Valores[0][0]) // [agregacion][serie]
+	t.Log("ConsultarAgregacion calcula promedio correctamente")
+}
+
+// TestConsultarAgregacion_SerieExacta_MinMax verifica MIN y MAX
+func TestConsultarAgregacion_SerieExacta_MinMax(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+
+	serie := tipos.Serie{
+		SerieId:          1,
+		Path:             "sensor/temp",
+		TipoDatos:        tipos.Real,
+		TamañoBloque:     100,
+		CompresionBloque: tipos.Ninguna,
+		CompresionBytes:  tipos.SinCompresion,
+	}
+	manager.cache.mu.Lock()
+	manager.cache.datos["sensor/temp"] = serie
+	manager.cache.mu.Unlock()
+
+	ahora := time.Now().UnixNano()
+	mediciones := []tipos.Medicion{
+		{Tiempo: ahora - 3000, Valor: float64(15.0)},
+		{Tiempo: ahora - 2000, Valor: float64(5.0)},  // MIN
+		{Tiempo: ahora - 1000, Valor: float64(25.0)}, // MAX
+	}
+
+	bloque := crearBloqueComprimidoTest(t, serie, mediciones)
+	clave := generarClaveDatos(serie.SerieId, ahora-3000, ahora-1000)
+	manager.db.Set(clave, bloque, pebble.Sync)
+
+	// MIN
+	minResult, err := manager.ConsultarAgregacion(
+		"sensor/temp",
+		time.Unix(0, ahora-5000),
+		time.Unix(0, ahora),
+		[]tipos.TipoAgregacion{tipos.AgregacionMinimo},
+	)
+	require.NoError(t, err)
+	require.Len(t, minResult.Series, 1)
+	assert.Equal(t, 5.0, minResult.Valores[0][0]) // [agregacion][serie]
+
+	// MAX
+	maxResult, err := manager.ConsultarAgregacion(
+		"sensor/temp",
+		time.Unix(0, ahora-5000),
+		time.Unix(0, ahora),
+		[]tipos.TipoAgregacion{tipos.AgregacionMaximo},
+	)
+	require.NoError(t, err)
+	require.Len(t, maxResult.Series, 1)
+	assert.Equal(t, 25.0, maxResult.Valores[0][0]) // [agregacion][serie]
+
+	t.Log("ConsultarAgregacion calcula MIN y MAX correctamente")
+}
+
+// TestConsultarAgregacion_SerieExacta_SumaCount verifica SUM y COUNT
+func TestConsultarAgregacion_SerieExacta_SumaCount(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+
+	serie := tipos.Serie{
+		SerieId:          1,
+		Path:             "sensor/temp",
+		TipoDatos:        tipos.Real,
+		TamañoBloque:     100,
+		CompresionBloque: tipos.Ninguna,
+		CompresionBytes:  tipos.SinCompresion,
+	}
+	manager.cache.mu.Lock()
+	manager.cache.datos["sensor/temp"] = serie
+	manager.cache.mu.Unlock()
+
+	ahora := time.Now().UnixNano()
+	mediciones := []tipos.Medicion{
+		{Tiempo: ahora - 3000, Valor: float64(10.0)},
+		{Tiempo: ahora - 2000, Valor: float64(20.0)},
+		{Tiempo: ahora - 1000, Valor: float64(30.0)},
+	}
 
 	bloque := crearBloqueComprimidoTest(t, serie, mediciones)
 	clave := generarClaveDatos(serie.SerieId, ahora-3000, ahora-1000)
@@ -2363,6 +3009,124 @@ func TestResolverSeries_Patron(t *testing.T) {
 	t.Log("resolverSeries resuelve patrón wildcard correctamente")
 }
 
+// ============================================================================
+// TESTS DE FUNCIONES DE VENTANA
+// ============================================================================
+
+// prepararSerieVentanaTest crea una serie real con mediciones 10, 20, 40 (una por minuto)
+func prepararSerieVentanaTest(t *testing.T, manager *ManagerEdge) time.Time {
+	serie := tipos.Serie{
+		SerieId:          1,
+		Path:             "sensor/temp",
+		TipoDatos:        tipos.Real,
+		TamañoBloque:     100,
+		CompresionBloque: tipos.Ninguna,
+		CompresionBytes:  tipos.SinCompresion,
+	}
+	manager.cache.mu.Lock()
+	manager.cache.datos["sensor/temp"] = serie
+	manager.cache.mu.Unlock()
+
+	base := time.Now().Add(-10 * time.Minute)
+	mediciones := []tipos.Medicion{
+		{Tiempo: base.UnixNano(), Valor: float64(10.0)},
+		{Tiempo: base.Add(time.Minute).UnixNano(), Valor: float64(20.0)},
+		{Tiempo: base.Add(2 * time.Minute).UnixNano(), Valor: float64(40.0)},
+	}
+	bloque := crearBloqueComprimidoTest(t, serie, mediciones)
+	clave := generarClaveDatos(serie.SerieId, mediciones[0].Tiempo, mediciones[2].Tiempo)
+	require.NoError(t, manager.db.Set(clave, bloque, pebble.Sync))
+	return base
+}
+
+// TestConsultarRangoConVentana_Diferencia verifica la aplicación de ventana sobre ConsultarRango
+func TestConsultarRangoConVentana_Diferencia(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	base := prepararSerieVentanaTest(t, manager)
+
+	resultado, err := manager.ConsultarRangoConVentana("sensor/temp", base, time.Now(),
+		tipos.FuncionVentana{Tipo: tipos.VentanaDiferencia})
+	require.NoError(t, err)
+	require.Len(t, resultado.Tiempos, 3)
+	assert.Nil(t, resultado.Valores[0][0])
+	assert.Equal(t, 10.0, resultado.Valores[1][0])
+	assert.Equal(t, 20.0, resultado.Valores[2][0])
+
+	_, err = manager.ConsultarRangoConVentana("sensor/temp", base, time.Now(),
+		tipos.FuncionVentana{Tipo: tipos.VentanaEWMA})
+	assert.Error(t, err, "EWMA sin alfa debe fallar")
+	t.Log("ConsultarRangoConVentana aplica la ventana correctamente")
+}
+
+// TestConsultarAgregacionTemporalConVentana_SumaAcumulada verifica la ventana sobre buckets
+func TestConsultarAgregacionTemporalConVentana_SumaAcumulada(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	base := prepararSerieVentanaTest(t, manager)
+
+	resultado, err := manager.ConsultarAgregacionTemporalConVentana("sensor/temp", base, base.Add(3*time.Minute),
+		[]tipos.TipoAgregacion{tipos.AgregacionSuma}, time.Minute,
+		tipos.FuncionVentana{Tipo: tipos.VentanaSumaAcumulada})
+	require.NoError(t, err)
+	require.Len(t, resultado.Tiempos, 3)
+	assert.Equal(t, 10.0, resultado.Valores[0][0][0])
+	assert.Equal(t, 30.0, resultado.Valores[0][1][0])
+	assert.Equal(t, 70.0, resultado.Valores[0][2][0])
+	t.Log("ConsultarAgregacionTemporalConVentana acumula los buckets correctamente")
+}
+
+// TestEvaluarCondicion_ConVentana verifica el uso de funciones de ventana en condiciones de reglas
+func TestEvaluarCondicion_ConVentana(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	prepararSerieVentanaTest(t, manager)
+
+	// Media móvil de 2 puntos: último valor = (20 + 40) / 2 = 30
+	condicion := &Condicion{
+		Path:     "sensor/temp",
+		VentanaT: time.Hour,
+		Operador: OperadorIgual,
+		Valor:    30.0,
+		Ventana:  &tipos.FuncionVentana{Tipo: tipos.VentanaMediaMovil, Puntos: 2},
+	}
+	assert.True(t, manager.MotorReglas.evaluarCondicion(condicion, time.Now()))
+
+	// Máximo de las diferencias (10, 20) = 20
+	condicion = &Condicion{
+		Path:       "sensor/temp",
+		VentanaT:   time.Hour,
+		Agregacion: AgregacionMaximo,
+		Operador:   OperadorMayorIgual,
+		Valor:      20.0,
+		Ventana:    &tipos.FuncionVentana{Tipo: tipos.VentanaDiferencia},
+	}
+	assert.True(t, manager.MotorReglas.evaluarCondicion(condicion, time.Now()))
+
+	condicion.Valor = 25.0
+	assert.False(t, manager.MotorReglas.evaluarCondicion(condicion, time.Now()))
+	t.Log("Condiciones con función de ventana evaluadas correctamente")
+}
+
+// TestValidarCondicion_VentanaInvalida verifica la validación de funciones de ventana en condiciones
+func TestValidarCondicion_VentanaInvalida(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+
+	condicion := &Condicion{
+		Path:     "sensor/temp",
+		VentanaT: time.Minute,
+		Operador: OperadorMayor,
+		Valor:    10.0,
+		Ventana:  &tipos.FuncionVentana{Tipo: tipos.VentanaMediaMovil},
+	}
+	assert.Error(t, manager.MotorReglas.validarCondicion(condicion))
+
+	condicion.Ventana = &tipos.FuncionVentana{Tipo: tipos.VentanaEWMA, Alfa: 0.3}
+	assert.NoError(t, manager.MotorReglas.validarCondicion(condicion))
+
+	condicion.Operador = OperadorIgual
+	condicion.Valor = "alto"
+	assert.Error(t, manager.MotorReglas.validarCondicion(condicion), "ventana con valor string debe fallar")
+	t.Log("validarCondicion valida funciones de ventana")
+}
+
 // ============================================================================
 // TESTS DE MÚLTIPLES AGREGACIONES
 // ============================================================================
@@ -2817,21 +3581,7 @@ func TestEliminarSerie_EliminaMultiplesBloques(t *testing.T) {
 
 // TestEliminarSerie_ConS3 verifica que se registra eliminación pendiente para S3
 func TestEliminarSerie_ConS3(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
-
-	// Configurar mock de S3
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
-
-	mockS3 := &mockClienteS3{
-		putObjectOutput: &s3.PutObjectOutput{},
-	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
+	manager, _ := crearManagerTest(t, opcionesManagerTest{S3: true})
 
 	// Crear serie
 	err := manager.CrearSerie(tipos.Serie{
@@ -3036,22 +3786,7 @@ func TestActualizarEliminacionPendiente(t *testing.T) {
 
 // TestEliminarSerie_GuardaPendienteConS3 verifica que se guarda pendiente cuando hay S3
 func TestEliminarSerie_GuardaPendienteConS3(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
-
-	// Configurar mock de S3
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
-
-	mockS3 := &mockClienteS3{
-		putObjectOutput:   &s3.PutObjectOutput{},
-		listObjectsOutput: &s3.ListObjectsV2Output{}, // Sin objetos
-	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
+	manager, _ := crearManagerTest(t, opcionesManagerTest{S3: true})
 
 	// Crear serie
 	err := manager.CrearSerie(tipos.Serie{
@@ -3076,7 +3811,7 @@ func TestEliminarSerie_GuardaPendienteConS3(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Verificar que se guardó pendiente (o se procesó y eliminó si tuvo éxito)
-	// Como el mock retorna lista vacía, la eliminación es exitosa y el pendiente se elimina
+	// Como S3 no tiene objetos de la serie, la eliminación es exitosa y el pendiente se elimina
 	// Pero antes de procesar, el pendiente debió existir
 	// Verificamos que la serie fue eliminada localmente
 	manager.cache.mu.RLock()
@@ -3091,11 +3826,6 @@ func TestEliminarSerie_GuardaPendienteConS3(t *testing.T) {
 func TestEliminarSerie_SinS3NoPendiente(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
 
-	// Asegurar que no hay S3
-	clienteOriginal := clienteS3
-	clienteS3 = nil
-	defer func() { clienteS3 = clienteOriginal }()
-
 	// Crear serie
 	err := manager.CrearSerie(tipos.Serie{
 		Path:             "sensor/temp",
@@ -3122,10 +3852,6 @@ func TestEliminarSerie_SinS3NoPendiente(t *testing.T) {
 func TestEliminarSerieDeS3_SinS3(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
 
-	clienteOriginal := clienteS3
-	clienteS3 = nil
-	defer func() { clienteS3 = clienteOriginal }()
-
 	_, err := manager.eliminarSerieDeS3(123)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no está configurado")
@@ -3137,37 +3863,26 @@ func TestEliminarSerieDeS3_SinS3(t *testing.T) {
 func TestEliminarSerieDeS3_Exitoso(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
 
-	// Configurar mock con objetos a eliminar
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
-
+	// Objetos a eliminar en S3
 	// Simular 3 objetos en S3
-	key1 := aws.String("test-node/0000000001_00000000000000001000_00000000000000002000")
-	key2 := aws.String("test-node/0000000001_00000000000000002000_00000000000000003000")
-	key3 := aws.String("test-node/0000000001_00000000000000003000_00000000000000004000")
-
-	mockS3 := &mockClienteS3{
-		listObjectsOutput: &s3.ListObjectsV2Output{
-			Contents: []s3types.Object{
-				{Key: key1},
-				{Key: key2},
-				{Key: key3},
-			},
-		},
-		deleteObjectOutput: &s3.DeleteObjectOutput{},
+	key1 := aws.String(manager.nodoID + "/0000000001_00000000000000001000_00000000000000002000")
+	key2 := aws.String(manager.nodoID + "/0000000001_00000000000000002000_00000000000000003000")
+	key3 := aws.String(manager.nodoID + "/0000000001_00000000000000003000_00000000000000004000")
+
+	// Se listan ambas disposiciones de claves: S3 filtra por prefijo
+	cliente := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucketTest}, TamañoPagina: 2})
+	for _, key := range []*string{key1, key2, key3} {
+		guardarObjetoTest(t, cliente, *key, []byte{})
 	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
+	guardarObjetoTest(t, cliente, tipos.GenerarClaveS3DatosParticionada(manager.nodoID, 1, 4000, 5000), []byte{})
+	guardarObjetoTest(t, cliente, tipos.GenerarClaveS3Datos(manager.nodoID, 2, 1000, 2000), []byte{}) // Otra serie
+	manager.almacenamiento = tipos.NuevoAlmacenamientoS3(cliente, bucketTest)
 
 	// Eliminar serie de S3
 	eliminados, err := manager.eliminarSerieDeS3(1)
 	require.NoError(t, err)
-	assert.Equal(t, 3, eliminados)
-	assert.Equal(t, 3, mockS3.deleteObjectCalls)
+	assert.Equal(t, 4, eliminados)
+	assert.Len(t, cliente.Claves(bucketTest, ""), 1)
 
 	t.Log("eliminarSerieDeS3 elimina objetos correctamente")
 }
@@ -3176,10 +3891,6 @@ func TestEliminarSerieDeS3_Exitoso(t *testing.T) {
 func TestProcesarEliminacionesPendientes_SinS3(t *testing.T) {
 	manager := crearManagerEdgeParaTest(t)
 
-	clienteOriginal := clienteS3
-	clienteS3 = nil
-	defer func() { clienteS3 = clienteOriginal }()
-
 	// Guardar pendiente manualmente
 	pendiente := EliminacionPendiente{SerieId: 1, Path: "test", Timestamp: time.Now().UnixNano(), Intentos: 0}
 	datos, _ := tipos.SerializarGob(pendiente)
@@ -3198,24 +3909,7 @@ func TestProcesarEliminacionesPendientes_SinS3(t *testing.T) {
 
 // TestProcesarEliminacionesPendientes_Exitoso verifica procesamiento exitoso
 func TestProcesarEliminacionesPendientes_Exitoso(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
-
-	// Configurar mock
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
-
-	mockS3 := &mockClienteS3{
-		listObjectsOutput:  &s3.ListObjectsV2Output{}, // Sin objetos (ya migrados)
-		putObjectOutput:    &s3.PutObjectOutput{},     // Para RegistrarEnS3
-		deleteObjectOutput: &s3.DeleteObjectOutput{},
-	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
-
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
 	// Guardar pendiente
 	err := manager.guardarEliminacionPendiente(1, "sensor/temp")
 	require.NoError(t, err)
@@ -3229,29 +3923,15 @@ func TestProcesarEliminacionesPendientes_Exitoso(t *testing.T) {
 	assert.Empty(t, pendientes)
 
 	// Verificar que se actualizó el registro en S3
-	assert.GreaterOrEqual(t, mockS3.putObjectCalls, 1)
+	assert.GreaterOrEqual(t, cliente.Llamadas(s3fake.OperacionPutObject), 1)
 
 	t.Log("ProcesarEliminacionesPendientes procesa y elimina pendientes exitosamente")
 }
 
 // TestProcesarEliminacionesPendientes_FallaConexion verifica reintento
 func TestProcesarEliminacionesPendientes_FallaConexion(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
-
-	// Configurar mock con error
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
-
-	mockS3 := &mockClienteS3{
-		listObjectsErr: fmt.Errorf("conexión fallida"),
-	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
-
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+	cliente.Fallar(s3fake.Fallas{TasaErrores: 1, Operaciones: []s3fake.Operacion{s3fake.OperacionListObjectsV2}})
 	// Guardar pendiente
 	err := manager.guardarEliminacionPendiente(1, "sensor/temp")
 	require.NoError(t, err)
@@ -3270,24 +3950,7 @@ func TestProcesarEliminacionesPendientes_FallaConexion(t *testing.T) {
 
 // TestProcesarEliminacionesPendientes_MultiplesSeries verifica múltiples pendientes
 func TestProcesarEliminacionesPendientes_MultiplesSeries(t *testing.T) {
-	manager := crearManagerEdgeParaTest(t)
-
-	// Configurar mock
-	clienteOriginal := clienteS3
-	configOriginal := configuracionS3
-	defer func() {
-		clienteS3 = clienteOriginal
-		configuracionS3 = configOriginal
-	}()
-
-	mockS3 := &mockClienteS3{
-		listObjectsOutput:  &s3.ListObjectsV2Output{},
-		putObjectOutput:    &s3.PutObjectOutput{},
-		deleteObjectOutput: &s3.DeleteObjectOutput{},
-	}
-	clienteS3 = mockS3
-	configuracionS3 = tipos.ConfiguracionS3{Bucket: "test-bucket"}
-
+	manager, _ := crearManagerTest(t, opcionesManagerTest{S3: true})
 	// Guardar varias pendientes
 	for i := 1; i <= 5; i++ {
 		err := manager.guardarEliminacionPendiente(i, fmt.Sprintf("sensor/temp%d", i))
@@ -3319,3 +3982,894 @@ func TestGenerarClaveEliminacionPendiente(t *testing.T) {
 
 	t.Log("generarClaveEliminacionPendiente genera claves con formato correcto")
 }
+
+// ============================================================================
+// TESTS DE APROVISIONAMIENTO DECLARATIVO
+// ============================================================================
+
+const manifiestoTest = `
+series:
+  - path: invernadero/temperatura
+    tipo_datos: Real
+    compresion_bytes: Xor
+    tamaño_bloque: 50
+    tiempo_almacenamiento: 7d
+    tags:
+      unidad: celsius
+  - path: invernadero/ventilador
+    tipo_datos: Boolean
+reglas:
+  - id: temperatura_alta
+    nombre: Temperatura alta
+    condiciones:
+      - path: invernadero/temperatura
+        ventana_t: 5m
+        agregacion: promedio
+        operador: ">"
+        valor: 32.5
+    acciones:
+      - tipo: log
+        destino: alerta
+`
+
+// TestDecodificarManifiesto verifica el formato YAML del manifiesto
+func TestDecodificarManifiesto(t *testing.T) {
+	manifiesto, err := DecodificarManifiesto([]byte(manifiestoTest))
+	require.NoError(t, err)
+
+	require.Len(t, manifiesto.Series, 2)
+	assert.Equal(t, "invernadero/temperatura", manifiesto.Series[0].Path)
+	assert.Equal(t, tipos.Real, manifiesto.Series[0].TipoDatos)
+	assert.Equal(t, tipos.Xor, manifiesto.Series[0].CompresionBytes)
+	assert.Equal(t, 50, manifiesto.Series[0].TamañoBloque)
+	assert.Equal(t, int64(7*24*time.Hour), manifiesto.Series[0].TiempoAlmacenamiento)
+	assert.Equal(t, "celsius", manifiesto.Series[0].Tags["unidad"])
+
+	require.Len(t, manifiesto.Reglas, 1)
+	assert.Equal(t, "5m", manifiesto.Reglas[0].Condiciones[0].VentanaT)
+	assert.Equal(t, 32.5, manifiesto.Reglas[0].Condiciones[0].Valor)
+
+	// El tiempo de almacenamiento también admite nanosegundos
+	manifiesto, err = DecodificarManifiesto([]byte(`{"series": [{"path": "a/b", "tiempo_almacenamiento": 1000}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), manifiesto.Series[0].TiempoAlmacenamiento)
+
+	_, err = DecodificarManifiesto([]byte("series: {"))
+	assert.Error(t, err)
+
+	t.Log("DecodificarManifiesto acepta YAML, JSON y duraciones legibles")
+}
+
+// TestAprovisionar_Idempotente verifica que aplicar el mismo manifiesto dos veces no cambie nada
+func TestAprovisionar_Idempotente(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	manifiesto, err := DecodificarManifiesto([]byte(manifiestoTest))
+	require.NoError(t, err)
+
+	plan, err := manager.Aprovisionar(manifiesto, OpcionesAprovisionamiento{})
+	require.NoError(t, err)
+	assert.Equal(t, "+ serie invernadero/temperatura\n+ serie invernadero/ventilador\n+ regla temperatura_alta", plan.String())
+
+	serie, err := manager.ObtenerSeries("invernadero/ventilador")
+	require.NoError(t, err)
+	assert.Equal(t, tipos.Ninguna, serie.CompresionBloque, "default de compresión de bloque")
+	assert.Equal(t, tipos.SinCompresion, serie.CompresionBytes, "default de compresión de bytes")
+	assert.Equal(t, 100, serie.TamañoBloque, "default de tamaño de bloque")
+
+	regla, err := manager.MotorReglas.ObtenerRegla("temperatura_alta")
+	require.NoError(t, err)
+	assert.True(t, regla.Activa)
+	assert.Equal(t, 5*time.Minute, regla.Condiciones[0].VentanaT)
+	assert.Equal(t, LogicaAND, regla.Logica)
+
+	plan, err = manager.Aprovisionar(manifiesto, OpcionesAprovisionamiento{})
+	require.NoError(t, err)
+	assert.True(t, plan.Vacio(), "segunda aplicación sin cambios: %s", plan)
+
+	t.Log("Aprovisionar crea series y reglas y es idempotente")
+}
+
+// TestAprovisionar_ActualizarYEliminar verifica actualizaciones y eliminaciones
+func TestAprovisionar_ActualizarYEliminar(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	manifiesto, err := DecodificarManifiesto([]byte(manifiestoTest))
+	require.NoError(t, err)
+	_, err = manager.Aprovisionar(manifiesto, OpcionesAprovisionamiento{})
+	require.NoError(t, err)
+
+	// Cambiar tags y umbral; quitar el ventilador del manifiesto
+	manifiesto.Series[0].Tags = map[string]string{"unidad": "kelvin"}
+	manifiesto.Series = manifiesto.Series[:1]
+	manifiesto.Reglas[0].Condiciones[0].Valor = 35.0
+
+	// Sin EliminarAusentes la serie ausente se conserva
+	plan, err := manager.Aprovisionar(manifiesto, OpcionesAprovisionamiento{Simular: true})
+	require.NoError(t, err)
+	assert.Equal(t, "~ serie invernadero/temperatura (tags)\n~ regla temperatura_alta (condiciones)", plan.String())
+
+	// Simular no modifica el nodo
+	serie, _ := manager.ObtenerSeries("invernadero/temperatura")
+	assert.Equal(t, "celsius", serie.Tags["unidad"])
+
+	plan, err = manager.Aprovisionar(manifiesto, OpcionesAprovisionamiento{EliminarAusentes: true})
+	require.NoError(t, err)
+	require.Len(t, plan.Cambios, 3)
+	assert.Equal(t, "- serie invernadero/ventilador", plan.Cambios[2].String())
+
+	serie, _ = manager.ObtenerSeries("invernadero/temperatura")
+	assert.Equal(t, "kelvin", serie.Tags["unidad"])
+	_, err = manager.ObtenerSeries("invernadero/ventilador")
+	assert.Error(t, err, "la serie ausente fue eliminada")
+	regla, _ := manager.MotorReglas.ObtenerRegla("temperatura_alta")
+	assert.Equal(t, 35.0, regla.Condiciones[0].Valor)
+
+	// La actualización de la serie persiste en PebbleDB
+	valor, closer, err := manager.db.Get([]byte("series/invernadero/temperatura"))
+	require.NoError(t, err)
+	var persistida tipos.Serie
+	require.NoError(t, tipos.DeserializarGob(valor, &persistida))
+	closer.Close()
+	assert.Equal(t, "kelvin", persistida.Tags["unidad"])
+
+	// Un manifiesto vacío con EliminarAusentes elimina primero las reglas
+	plan, err = manager.Aprovisionar(ManifiestoAprovisionamiento{}, OpcionesAprovisionamiento{EliminarAusentes: true, Simular: true})
+	require.NoError(t, err)
+	assert.Equal(t, "- regla temperatura_alta\n- serie invernadero/temperatura", plan.String())
+
+	t.Log("Aprovisionar actualiza, simula y elimina ausentes en orden")
+}
+
+// TestAprovisionar_Errores verifica que los manifiestos inválidos no apliquen cambios
+func TestAprovisionar_Errores(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	manifiesto, err := DecodificarManifiesto([]byte(manifiestoTest))
+	require.NoError(t, err)
+	_, err = manager.Aprovisionar(manifiesto, OpcionesAprovisionamiento{})
+	require.NoError(t, err)
+
+	// Cambio de tipo de datos en una serie existente
+	incompatible := manifiesto
+	incompatible.Series = []tipos.Serie{manifiesto.Series[0]}
+	incompatible.Series[0].TipoDatos = tipos.Integer
+	incompatible.Series[0].CompresionBytes = tipos.DeltaDelta
+	_, err = manager.Aprovisionar(incompatible, OpcionesAprovisionamiento{})
+	assert.ErrorContains(t, err, "tipo de datos no puede cambiar")
+
+	// Serie duplicada
+	duplicado := ManifiestoAprovisionamiento{Series: []tipos.Serie{manifiesto.Series[1], manifiesto.Series[1]}}
+	_, err = manager.Aprovisionar(duplicado, OpcionesAprovisionamiento{})
+	assert.ErrorContains(t, err, "más de una vez")
+
+	// Regla inválida junto con una serie nueva: no se crea nada
+	invalida := ManifiestoAprovisionamiento{
+		Series: []tipos.Serie{{Path: "nueva/serie", TipoDatos: tipos.Real}},
+		Reglas: []tipos.Regla{{ID: "sin_acciones", Condiciones: manifiesto.Reglas[0].Condiciones}},
+	}
+	_, err = manager.Aprovisionar(invalida, OpcionesAprovisionamiento{})
+	assert.ErrorContains(t, err, "al menos una acción")
+	_, err = manager.ObtenerSeries("nueva/serie")
+	assert.Error(t, err, "la serie no debe crearse si el manifiesto es inválido")
+
+	// Ventana temporal ilegible
+	invalida.Reglas = []tipos.Regla{manifiesto.Reglas[0]}
+	invalida.Reglas[0].Condiciones = []tipos.Condicion{manifiesto.Reglas[0].Condiciones[0]}
+	invalida.Reglas[0].Condiciones[0].VentanaT = "pronto"
+	_, err = manager.Aprovisionar(invalida, OpcionesAprovisionamiento{})
+	assert.ErrorContains(t, err, "ventana_t inválida")
+
+	t.Log("Aprovisionar valida el manifiesto completo antes de aplicar")
+}
+
+// ============================================================================
+// TESTS DE LA API DE ADMINISTRACIÓN
+// ============================================================================
+
+// solicitudAdministracionTest ejecuta una solicitud contra los endpoints de administración
+func solicitudAdministracionTest(t *testing.T, manager *ManagerEdge, metodo, ruta, cuerpo string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	manager.registrarHandlersAdministracion(mux)
+	req := httptest.NewRequest(metodo, ruta, strings.NewReader(cuerpo))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestAdministracion_Series verifica la creación, el listado y la eliminación de series
+func TestAdministracion_Series(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+
+	rec := solicitudAdministracionTest(t, manager, "POST", "/api/series",
+		`{"path": "sala/temp", "tipo_datos": "Real", "compresion_bytes": "Xor", "tags": {"unidad": "C"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var creada tipos.Serie
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &creada))
+	assert.Equal(t, "sala/temp", creada.Path)
+	assert.NotZero(t, creada.SerieId)
+	assert.Equal(t, tipos.Ninguna, creada.CompresionBloque, "defaults aplicados")
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/series", `{"path": "sala/temp", "tipo_datos": "Real"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/series", `{"path": "sala/hum", "tipo_datos": "Complejo"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = solicitudAdministracionTest(t, manager, "GET", "/api/series", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var series []tipos.Serie
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &series))
+	require.Len(t, series, 1)
+	assert.Equal(t, "C", series[0].Tags["unidad"])
+
+	rec = solicitudAdministracionTest(t, manager, "PUT", "/api/series/sala/temp", `{"tags": {"unidad": "K"}, "tiempo_almacenamiento": 3600000000000}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var actualizada tipos.Serie
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &actualizada))
+	assert.Equal(t, "K", actualizada.Tags["unidad"])
+	assert.Equal(t, int64(time.Hour), actualizada.TiempoAlmacenamiento)
+	assert.Equal(t, tipos.Xor, actualizada.CompresionBytes, "el formato omitido se conserva")
+
+	rec = solicitudAdministracionTest(t, manager, "PUT", "/api/series/sala/temp", `{"tipo_datos": "Integer"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = solicitudAdministracionTest(t, manager, "PUT", "/api/series/sala/temp", `{"path": "sala/otra"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = solicitudAdministracionTest(t, manager, "PUT", "/api/series/sala/otra", `{}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = solicitudAdministracionTest(t, manager, "DELETE", "/api/series/sala/temp", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = solicitudAdministracionTest(t, manager, "DELETE", "/api/series/sala/temp", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	t.Log("La API de administración crea, lista y elimina series")
+}
+
+// TestAdministracion_Reglas verifica la habilitación de reglas y la migración sin S3
+func TestAdministracion_Reglas(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	manifiesto, err := DecodificarManifiesto([]byte(manifiestoTest))
+	require.NoError(t, err)
+	_, err = manager.Aprovisionar(manifiesto, OpcionesAprovisionamiento{})
+	require.NoError(t, err)
+
+	rec := solicitudAdministracionTest(t, manager, "POST", "/api/reglas/temperatura_alta/deshabilitar", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var regla tipos.Regla
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &regla))
+	assert.False(t, regla.Activa)
+	assert.Equal(t, 0, manager.ObtenerEstadoMotorReglas().ReglasActivas)
+
+	// El estado persiste en PebbleDB
+	valor, closer, err := manager.db.Get(generarClaveRegla("temperatura_alta"))
+	require.NoError(t, err)
+	persistida, err := deserializarRegla(valor)
+	closer.Close()
+	require.NoError(t, err)
+	assert.False(t, persistida.Activa)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/reglas/temperatura_alta/habilitar", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, manager.ObtenerEstadoMotorReglas().ReglasActivas)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/reglas/inexistente/habilitar", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = solicitudAdministracionTest(t, manager, "GET", "/api/reglas", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var reglas []tipos.Regla
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reglas))
+	require.Len(t, reglas, 1)
+	assert.True(t, reglas[0].Activa)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/migracion", "")
+	assert.Equal(t, http.StatusConflict, rec.Code, "sin S3 no hay migración")
+
+	t.Log("La API de administración habilita y deshabilita reglas")
+}
+
+// TestAdministracion_Migracion verifica que la migración solicitada se ejecuta en segundo
+// plano y que el estado informa su progreso y la última migración
+func TestAdministracion_Migracion(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true})
+
+	serie := tipos.Serie{
+		SerieId:              1,
+		Path:                 "sensor/temp",
+		TipoDatos:            tipos.Real,
+		TamañoBloque:         100,
+		CompresionBloque:     tipos.Ninguna,
+		CompresionBytes:      tipos.SinCompresion,
+		TiempoAlmacenamiento: int64(time.Hour),
+	}
+	manager.cache.mu.Lock()
+	manager.cache.datos["sensor/temp"] = serie
+	manager.cache.mu.Unlock()
+
+	tiempoAntiguo := time.Now().Add(-2 * time.Hour).UnixNano()
+	bloque := crearBloqueComprimidoTest(t, serie, []tipos.Medicion{{Tiempo: tiempoAntiguo, Valor: float64(20.0)}})
+	require.NoError(t, manager.db.Set(generarClaveDatos(1, tiempoAntiguo, tiempoAntiguo), bloque, pebble.Sync))
+
+	rec := solicitudAdministracionTest(t, manager, "GET", "/api/migracion", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var estado EstadoMigracion
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &estado))
+	assert.Nil(t, estado.Ultima)
+
+	// Retener la subida para observar la migración en curso
+	liberar := make(chan struct{})
+	cliente.Fallar(s3fake.Fallas{
+		Operaciones: []s3fake.Operacion{s3fake.OperacionPutObject},
+		Interceptar: func(op s3fake.Operacion, bucket, clave string) error {
+			<-liberar
+			return nil
+		},
+	})
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/migracion", "")
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &estado))
+	assert.True(t, estado.Solicitada)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/migracion", "")
+	assert.Equal(t, http.StatusConflict, rec.Code, "una migración solicitada a la vez")
+
+	close(liberar)
+	require.Eventually(t, func() bool {
+		rec := solicitudAdministracionTest(t, manager, "GET", "/api/migracion", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		estado = EstadoMigracion{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &estado))
+		return !estado.Solicitada
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, estado.EnCurso)
+	require.NotNil(t, estado.Ultima)
+	assert.Equal(t, "por_tiempo", estado.Ultima.Tipo)
+	assert.Equal(t, 1, estado.Ultima.Bloques)
+	assert.Equal(t, 1, estado.Ultima.Migrados)
+	assert.Equal(t, int64(len(bloque)), estado.Ultima.Bytes)
+
+	t.Log("La API de administración inicia la migración e informa su resultado")
+}
+
+// ============================================================================
+// TESTS DE COMANDOS DEL DESPACHADOR
+// ============================================================================
+
+// reglaComandoTest retorna una regla válida sobre las series del manifiesto de prueba
+func reglaComandoTest(id string, valor float64) *tipos.Regla {
+	return &tipos.Regla{
+		ID:          id,
+		Nombre:      "Regla " + id,
+		Condiciones: []tipos.Condicion{{Path: "invernadero/temperatura", VentanaT: "1m", Agregacion: "promedio", Operador: ">", Valor: valor}},
+		Acciones:    []tipos.Accion{{Tipo: "log", Destino: "alerta"}},
+	}
+}
+
+// TestAplicarComando verifica la aplicación de cada tipo de comando sobre las reglas
+func TestAplicarComando(t *testing.T) {
+	manager, _ := crearManagerTest(t, opcionesManagerTest{Manifiesto: true})
+	nodo := manager.nodoID
+
+	resultado, err := manager.AplicarComando(tipos.Comando{ID: "c1", NodoID: nodo, Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("humedad", 10)})
+	require.NoError(t, err)
+	assert.Equal(t, "c1", resultado.ID)
+	require.NotNil(t, resultado.Regla)
+	assert.True(t, resultado.Regla.Activa, "las reglas creadas quedan activas")
+
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoDeshabilitarRegla, ReglaID: "humedad"})
+	require.NoError(t, err)
+
+	resultado, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoActualizarRegla, Regla: reglaComandoTest("humedad", 20)})
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, resultado.Regla.Condiciones[0].Valor)
+	assert.False(t, resultado.Regla.Activa, "la actualización conserva el estado activo")
+
+	resultado, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoHabilitarRegla, ReglaID: "humedad"})
+	require.NoError(t, err)
+	assert.True(t, resultado.Regla.Activa)
+
+	resultado, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoEliminarRegla, ReglaID: "humedad"})
+	require.NoError(t, err)
+	assert.Nil(t, resultado.Regla)
+	_, err = manager.MotorReglas.ObtenerRegla("humedad")
+	assert.Error(t, err)
+
+	// Errores
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("temperatura_alta", 1)})
+	assert.ErrorIs(t, err, errReglaExistente)
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoActualizarRegla, Regla: reglaComandoTest("inexistente", 1)})
+	assert.ErrorIs(t, err, errReglaNoEncontrada)
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoEliminarRegla, ReglaID: "inexistente"})
+	assert.ErrorIs(t, err, errReglaNoEncontrada)
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: "otro-nodo", Tipo: tipos.ComandoEliminarRegla, ReglaID: "temperatura_alta"})
+	assert.ErrorIs(t, err, errComandoInvalido)
+
+	operadorInvalido := reglaComandoTest("operador", 1)
+	operadorInvalido.Condiciones[0].Operador = "~"
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoCrearRegla, Regla: operadorInvalido})
+	assert.ErrorIs(t, err, errComandoInvalido, "la regla se valida con el motor del nodo")
+
+	t.Log("AplicarComando crea, actualiza, habilita y elimina reglas")
+}
+
+// TestAplicarComando_Series verifica los comandos de creación, modificación y eliminación de series
+func TestAplicarComando_Series(t *testing.T) {
+	manager, _ := crearManagerTest(t, opcionesManagerTest{Manifiesto: true})
+	nodo := manager.nodoID
+
+	resultado, err := manager.AplicarComando(tipos.Comando{ID: "c1", NodoID: nodo, Tipo: tipos.ComandoCrearSerie,
+		Serie: &tipos.Serie{Path: "invernadero/humedad", TipoDatos: tipos.Real, Tags: map[string]string{"unidad": "%"}}})
+	require.NoError(t, err)
+	assert.Equal(t, tipos.ComandoAplicado, resultado.Estado)
+	assert.Equal(t, tipos.ComandoCrearSerie, resultado.Tipo)
+	require.NotNil(t, resultado.Serie)
+	assert.NotZero(t, resultado.Serie.SerieId)
+	assert.Equal(t, 100, resultado.Serie.TamañoBloque, "defaults del aprovisionamiento")
+
+	// Al actualizar, los campos de formato omitidos se conservan
+	resultado, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoActualizarSerie,
+		Serie: &tipos.Serie{Path: "invernadero/humedad", Tags: map[string]string{"unidad": "HR"}, TiempoAlmacenamiento: int64(time.Hour)}})
+	require.NoError(t, err)
+	assert.Equal(t, "HR", resultado.Serie.Tags["unidad"])
+	assert.Equal(t, int64(time.Hour), resultado.Serie.TiempoAlmacenamiento)
+	assert.Equal(t, tipos.Real, resultado.Serie.TipoDatos)
+
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoActualizarSerie,
+		Serie: &tipos.Serie{Path: "invernadero/humedad", TipoDatos: tipos.Integer}})
+	assert.ErrorIs(t, err, errComandoInvalido, "el tipo de datos no puede cambiar")
+
+	resultado, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoEliminarSerie, Path: "invernadero/humedad"})
+	require.NoError(t, err)
+	assert.Nil(t, resultado.Serie)
+	_, err = manager.ObtenerSeries("invernadero/humedad")
+	assert.Error(t, err)
+
+	// Errores
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoCrearSerie, Serie: &tipos.Serie{Path: "invernadero/temperatura", TipoDatos: tipos.Real}})
+	assert.ErrorIs(t, err, errSerieExistente)
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoCrearSerie, Serie: &tipos.Serie{Path: "invernadero/x", TipoDatos: tipos.Desconocido}})
+	assert.ErrorIs(t, err, errComandoInvalido)
+	_, err = manager.AplicarComando(tipos.Comando{NodoID: nodo, Tipo: tipos.ComandoEliminarSerie, Path: "invernadero/inexistente"})
+	assert.ErrorIs(t, err, errSerieNoEncontrada)
+	assert.True(t, comandoDefinitivo(err))
+
+	t.Log("AplicarComando crea, actualiza y elimina series")
+}
+
+// TestAdministracion_Comandos verifica los status de POST /api/comandos
+func TestAdministracion_Comandos(t *testing.T) {
+	manager, _ := crearManagerTest(t, opcionesManagerTest{Manifiesto: true})
+
+	cuerpo := func(c tipos.Comando) string {
+		c.NodoID = manager.nodoID
+		datos, err := json.Marshal(c)
+		require.NoError(t, err)
+		return string(datos)
+	}
+
+	rec := solicitudAdministracionTest(t, manager, "POST", "/api/comandos",
+		cuerpo(tipos.Comando{ID: "c1", Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("humedad", 10)}))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resultado tipos.ResultadoComando
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resultado))
+	assert.Equal(t, "c1", resultado.ID)
+	require.NotNil(t, resultado.Regla)
+	assert.Equal(t, "humedad", resultado.Regla.ID)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/comandos",
+		cuerpo(tipos.Comando{Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("humedad", 10)}))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/comandos",
+		cuerpo(tipos.Comando{Tipo: tipos.ComandoHabilitarRegla, ReglaID: "inexistente"}))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/comandos",
+		cuerpo(tipos.Comando{Tipo: "reiniciar"}))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/comandos", "{no es json")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/comandos",
+		cuerpo(tipos.Comando{Tipo: tipos.ComandoEliminarSerie, Path: "invernadero/inexistente"}))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	t.Log("POST /api/comandos distingue comandos aplicados, duplicados, inexistentes e inválidos")
+}
+
+// TestProcesarComandosPendientes verifica que la cola S3 se aplica en orden y se vacía
+func TestProcesarComandosPendientes(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true, TamañoPagina: 2, Manifiesto: true})
+
+	encolar := func(id string, c tipos.Comando) {
+		c.ID = id
+		c.NodoID = manager.nodoID
+		encolarComandoTest(t, cliente, c)
+	}
+	// El orden de los IDs define el orden de aplicación: crear, actualizar, deshabilitar
+	encolar("0003", tipos.Comando{Tipo: tipos.ComandoDeshabilitarRegla, ReglaID: "humedad"})
+	encolar("0001", tipos.Comando{Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("humedad", 10)})
+	encolar("0002", tipos.Comando{Tipo: tipos.ComandoActualizarRegla, Regla: reglaComandoTest("humedad", 25)})
+	encolar("0004", tipos.Comando{Tipo: tipos.ComandoEliminarRegla, ReglaID: "inexistente"})
+	guardarObjetoTest(t, cliente, tipos.GenerarClaveS3Comando(manager.nodoID, "0005"), []byte("{no es json"))
+	// Comandos de otro nodo no se tocan
+	guardarObjetoTest(t, cliente, tipos.GenerarClaveS3Comando("otro-nodo", "0001"), []byte("{}"))
+
+	procesados, err := manager.ProcesarComandosPendientes()
+	require.NoError(t, err)
+	assert.Equal(t, 5, procesados, "los comandos inválidos se descartan")
+
+	// Cada comando deja su resultado; el objeto inválido se descarta sin resultado
+	resultado := func(id string) tipos.RegistroComando {
+		datos := objetoTest(t, cliente, tipos.GenerarClaveS3ResultadoComando(manager.nodoID, id))
+		var registro tipos.RegistroComando
+		require.NoError(t, json.Unmarshal(datos, &registro))
+		return registro
+	}
+	assert.Equal(t, tipos.ComandoAplicado, resultado("0001").Resultado.Estado)
+	assert.Equal(t, tipos.ComandoCrearRegla, resultado("0001").Comando.Tipo)
+	assert.Equal(t, tipos.ComandoAplicado, resultado("0003").Resultado.Estado)
+	rechazado := resultado("0004")
+	assert.Equal(t, tipos.ComandoRechazado, rechazado.Resultado.Estado)
+	assert.Contains(t, rechazado.Resultado.Motivo, "regla no encontrada")
+	assert.NotContains(t, cliente.Claves(bucketTest, ""), tipos.GenerarClaveS3ResultadoComando(manager.nodoID, "0005"))
+
+	regla, err := manager.MotorReglas.ObtenerRegla("humedad")
+	require.NoError(t, err)
+	assert.Equal(t, 25.0, regla.Condiciones[0].Valor)
+	assert.False(t, regla.Activa)
+
+	for _, clave := range cliente.Claves(bucketTest, "") {
+		assert.False(t, strings.HasPrefix(clave, tipos.GenerarPrefijoS3Comandos(manager.nodoID)), "comando sin procesar: %s", clave)
+	}
+	assert.Contains(t, cliente.Claves(bucketTest, ""), tipos.GenerarClaveS3Comando("otro-nodo", "0001"))
+	assert.Contains(t, cliente.Claves(bucketTest, ""), "nodos/"+manager.nodoID+".json", "los cambios se reflejan en el registro del nodo")
+	procesados, err = manager.ProcesarComandosPendientes()
+	require.NoError(t, err)
+	assert.Zero(t, procesados)
+
+	// Un comando con resultado que sigue en la cola no se reaplica
+	encolar("0001", tipos.Comando{Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("humedad", 10)})
+	procesados, err = manager.ProcesarComandosPendientes()
+	require.NoError(t, err)
+	assert.Equal(t, 1, procesados)
+	assert.Equal(t, tipos.ComandoAplicado, resultado("0001").Resultado.Estado, "el resultado original se conserva")
+
+	t.Log("Los comandos pendientes se aplican en orden de creación y se eliminan de la cola")
+}
+
+// TestComandos_RepetidoNoSeReaplica verifica que un comando repetido por ID (entregado
+// directamente y luego encolado por un timeout del despachador) se aplica una sola vez
+func TestComandos_RepetidoNoSeReaplica(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true, Manifiesto: true})
+
+	comando := tipos.Comando{ID: "c1", NodoID: manager.nodoID, Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("humedad", 10)}
+	datos, err := json.Marshal(comando)
+	require.NoError(t, err)
+
+	rec := solicitudAdministracionTest(t, manager, "POST", "/api/comandos", string(datos))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var primero tipos.ResultadoComando
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &primero))
+
+	// Reenvío directo: misma respuesta en lugar de "la regla ya existe"
+	rec = solicitudAdministracionTest(t, manager, "POST", "/api/comandos", string(datos))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var repetido tipos.ResultadoComando
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &repetido))
+	assert.Equal(t, primero, repetido)
+
+	// El mismo comando encolado guarda el resultado de la entrega directa
+	encolarComandoTest(t, cliente, comando)
+	procesados, err := manager.ProcesarComandosPendientes()
+	require.NoError(t, err)
+	assert.Equal(t, 1, procesados)
+	guardado, existe := cliente.Objeto("test-bucket", tipos.GenerarClaveS3ResultadoComando(manager.nodoID, "c1"))
+	require.True(t, existe)
+	var registro tipos.RegistroComando
+	require.NoError(t, json.Unmarshal(guardado, &registro))
+	assert.Equal(t, tipos.ComandoAplicado, registro.Resultado.Estado)
+	assert.Equal(t, primero.Aplicado, registro.Resultado.Aplicado)
+
+	// Un rechazo también se repite igual
+	rechazo := tipos.Comando{ID: "c2", NodoID: manager.nodoID, Tipo: tipos.ComandoHabilitarRegla, ReglaID: "inexistente"}
+	datos, err = json.Marshal(rechazo)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		rec = solicitudAdministracionTest(t, manager, "POST", "/api/comandos", string(datos))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Contains(t, rec.Body.String(), "regla no encontrada")
+	}
+
+	t.Log("Los comandos repetidos retornan el resultado de la primera aplicación")
+}
+
+// TestComandos_PodaResultados verifica que con cada latido se eliminan los resultados de
+// comandos anteriores a la retención y se conservan los recientes
+func TestComandos_PodaResultados(t *testing.T) {
+	manager, _ := crearManagerTest(t, opcionesManagerTest{S3: true, Manifiesto: true})
+
+	comando := tipos.Comando{ID: "reciente", NodoID: manager.nodoID, Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("humedad", 10)}
+	datos, err := json.Marshal(comando)
+	require.NoError(t, err)
+	rec := solicitudAdministracionTest(t, manager, "POST", "/api/comandos", string(datos))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Resultado aplicado antes de la retención
+	antiguo, err := json.Marshal(resultadoComandoLocal{
+		Resultado: tipos.ResultadoComando{ID: "antiguo", Estado: tipos.ComandoAplicado,
+			Aplicado: time.Now().Add(-retencionResultadosComandos - time.Hour).UnixNano()},
+		Status: http.StatusOK,
+	})
+	require.NoError(t, err)
+	require.NoError(t, manager.db.Set(generarClaveResultadoComando("antiguo"), antiguo, pebble.Sync))
+
+	manager.aplicarComandosPendientes()
+
+	_, _, err = manager.db.Get(generarClaveResultadoComando("antiguo"))
+	assert.ErrorIs(t, err, pebble.ErrNotFound, "el resultado antiguo se elimina")
+	_, closer, err := manager.db.Get(generarClaveResultadoComando("reciente"))
+	require.NoError(t, err, "el resultado reciente se conserva")
+	closer.Close()
+
+	// Superada la retención también se elimina el reciente
+	eliminados, err := manager.podarResultadosComandos(time.Now().Add(retencionResultadosComandos + time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, eliminados)
+	_, _, err = manager.db.Get(generarClaveResultadoComando("reciente"))
+	assert.ErrorIs(t, err, pebble.ErrNotFound)
+	assert.Greater(t, retencionResultadosComandos, tipos.VigenciaComandoEncolado,
+		"los resultados se conservan mientras la firma del comando es válida")
+	t.Log("Los resultados de comandos se eliminan al superar la retención")
+}
+
+// TestProcesarComandosPendientes_SinFirmaNoSeAplican verifica que los comandos encolados
+// sin firma, con firma de otro secreto o vencidos se descartan sin aplicarse
+func TestProcesarComandosPendientes_SinFirmaNoSeAplican(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true, Manifiesto: true})
+
+	// Sin firma: el comando tal como lo escribiría quien tiene acceso al bucket
+	sinFirma := tipos.Comando{ID: "0001", NodoID: manager.nodoID, Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("intrusa", 10)}
+	comando, err := json.Marshal(sinFirma)
+	require.NoError(t, err)
+	datos, err := json.Marshal(tipos.ComandoEncolado{Comando: comando})
+	require.NoError(t, err)
+	guardarObjetoTest(t, cliente, tipos.GenerarClaveS3Comando(manager.nodoID, sinFirma.ID), datos)
+	// El comando sin sobre de firma tampoco se acepta
+	guardarObjetoTest(t, cliente, tipos.GenerarClaveS3Comando(manager.nodoID, "0002"), comando)
+
+	otroSecreto := tipos.Comando{ID: "0003", NodoID: manager.nodoID, Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("otra", 10)}
+	encolado, err := tipos.FirmarComando(otroSecreto, []byte("otro-secreto"))
+	require.NoError(t, err)
+	datos, err = json.Marshal(encolado)
+	require.NoError(t, err)
+	guardarObjetoTest(t, cliente, tipos.GenerarClaveS3Comando(manager.nodoID, otroSecreto.ID), datos)
+
+	procesados, err := manager.ProcesarComandosPendientes()
+	require.NoError(t, err)
+	assert.Equal(t, 3, procesados, "los comandos rechazados se eliminan de la cola")
+
+	for _, id := range []string{"intrusa", "otra"} {
+		_, err := manager.MotorReglas.ObtenerRegla(id)
+		assert.Error(t, err, "la regla %s no debe crearse", id)
+	}
+	assert.Empty(t, cliente.Claves(bucketTest, tipos.GenerarPrefijoS3ResultadosComandos(manager.nodoID)))
+	assert.Empty(t, cliente.Claves(bucketTest, tipos.GenerarPrefijoS3Comandos(manager.nodoID)))
+
+	// Sin secreto el nodo no puede verificar ningún comando
+	manager.secreto = nil
+	firmado := tipos.Comando{ID: "0004", NodoID: manager.nodoID, Tipo: tipos.ComandoCrearRegla, Regla: reglaComandoTest("firmada", 10)}
+	encolarComandoTest(t, cliente, firmado)
+	_, err = manager.ProcesarComandosPendientes()
+	require.NoError(t, err)
+	_, err = manager.MotorReglas.ObtenerRegla("firmada")
+	assert.Error(t, err)
+
+	t.Log("Los comandos encolados sin firma válida se descartan sin aplicarse")
+}
+
+// TestProcesarComandosPendientes_EliminarSerieRequiereFirma verifica que eliminar_serie
+// desde la cola no borra datos locales ni de S3 sin una firma válida para el nodo
+func TestProcesarComandosPendientes_EliminarSerieRequiereFirma(t *testing.T) {
+	manager, cliente := crearManagerTest(t, opcionesManagerTest{S3: true, Manifiesto: true})
+	serie, err := manager.ObtenerSeries("invernadero/temperatura")
+	require.NoError(t, err)
+	claveS3 := tipos.GenerarClaveS3Datos(manager.nodoID, serie.SerieId, 1000, 2000)
+	guardarObjetoTest(t, cliente, claveS3, []byte("bloque migrado"))
+
+	eliminar := func(id string) tipos.Comando {
+		return tipos.Comando{ID: id, NodoID: manager.nodoID, Tipo: tipos.ComandoEliminarSerie, Path: serie.Path}
+	}
+	encolar := func(comando tipos.Comando, encolado tipos.ComandoEncolado) {
+		datos, err := json.Marshal(encolado)
+		require.NoError(t, err)
+		guardarObjetoTest(t, cliente, tipos.GenerarClaveS3Comando(manager.nodoID, comando.ID), datos)
+	}
+
+	// Sin firma
+	sinFirma := eliminar("0001")
+	comando, err := json.Marshal(sinFirma)
+	require.NoError(t, err)
+	encolar(sinFirma, tipos.ComandoEncolado{Comando: comando})
+
+	// Firmado con otro secreto
+	otroSecreto := eliminar("0002")
+	encolado, err := tipos.FirmarComando(otroSecreto, []byte("otro-secreto"))
+	require.NoError(t, err)
+	encolar(otroSecreto, encolado)
+
+	// Firmado con el secreto del nodo para otro nodo y copiado a esta cola
+	otroNodo := eliminar("0003")
+	otroNodo.NodoID = "otro-nodo"
+	encolado, err = tipos.FirmarComando(otroNodo, []byte(secretoTest))
+	require.NoError(t, err)
+	encolar(otroNodo, encolado)
+
+	procesados, err := manager.ProcesarComandosPendientes()
+	require.NoError(t, err)
+	assert.Equal(t, 3, procesados)
+
+	_, err = manager.ObtenerSeries(serie.Path)
+	assert.NoError(t, err, "la serie no debe eliminarse")
+	_, existe := cliente.Objeto(bucketTest, claveS3)
+	assert.True(t, existe, "los bloques en S3 no deben eliminarse")
+	pendientes, err := manager.cargarEliminacionesPendientes()
+	require.NoError(t, err)
+	assert.Empty(t, pendientes)
+
+	// Firmado por el despachador se aplica
+	encolarComandoTest(t, cliente, eliminar("0004"))
+	_, err = manager.ProcesarComandosPendientes()
+	require.NoError(t, err)
+	_, err = manager.ObtenerSeries(serie.Path)
+	assert.Error(t, err)
+
+	t.Log("eliminar_serie encolado se aplica solo con la firma del despachador para el nodo")
+}
+
+// ============================================================================
+// TESTS DEL TÚNEL INVERSO
+// ============================================================================
+
+// despachadorTunelTest simula la ruta de túnel del despachador: verifica la apertura
+// firmada y entrega por el canal un cliente HTTP/2 por cada túnel abierto
+func despachadorTunelTest(t *testing.T, nodoID, secreto string) (*httptest.Server, chan *http2.ClientConn) {
+	t.Helper()
+	verificador := tipos.NuevoVerificadorSolicitudes(nodoID, []byte(secreto), 0)
+	conexiones := make(chan *http2.ClientConn, 4)
+
+	servidor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tipos.RutaTunel || r.Header.Get("Upgrade") != tipos.ProtocoloTunel ||
+			r.Header.Get(tipos.EncabezadoNodo) != nodoID {
+			http.Error(w, "solicitud inválida", http.StatusBadRequest)
+			return
+		}
+		if err := verificador.Verificar(r, nil); err != nil {
+			http.Error(w, "no autorizado", http.StatusUnauthorized)
+			return
+		}
+		conexion, buffer, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		buffer.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + tipos.ProtocoloTunel + "\r\n\r\n")
+		buffer.Flush()
+		cliente, err := (&http2.Transport{}).NewClientConn(tipos.ConexionConLector(conexion, buffer.Reader))
+		if err != nil {
+			conexion.Close()
+			return
+		}
+		conexiones <- cliente
+	}))
+	t.Cleanup(servidor.Close)
+	return servidor, conexiones
+}
+
+// esperarTunelTest espera el próximo túnel abierto por el nodo
+func esperarTunelTest(t *testing.T, conexiones chan *http2.ClientConn) *http2.ClientConn {
+	t.Helper()
+	select {
+	case cliente := <-conexiones:
+		t.Cleanup(func() { cliente.Close() })
+		return cliente
+	case <-time.After(5 * time.Second):
+		t.Fatal("el nodo no abrió el túnel")
+		return nil
+	}
+}
+
+// TestCrear_Tunel_Validaciones verifica las opciones del túnel inverso
+func TestCrear_Tunel_Validaciones(t *testing.T) {
+	configS3 := &tipos.ConfiguracionS3{Endpoint: "http://localhost:9000", Bucket: "test"}
+
+	_, err := Crear(Opciones{NombreDB: t.TempDir() + "/db", URLTunel: "http://despachador:8080"})
+	assert.ErrorContains(t, err, "URLTunel no debe especificarse sin ConfigS3")
+
+	_, err = Crear(Opciones{NombreDB: t.TempDir() + "/db", URLTunel: "ftp://despachador", ConfigS3: configS3, SecretoAutenticacion: "s"})
+	assert.ErrorContains(t, err, "URLTunel debe usar http o https")
+
+	_, err = Crear(Opciones{NombreDB: t.TempDir() + "/db", URLTunel: "https://", ConfigS3: configS3, SecretoAutenticacion: "s"})
+	assert.ErrorContains(t, err, "URLTunel no tiene host")
+
+	_, err = Crear(Opciones{NombreDB: t.TempDir() + "/db", URLTunel: "https://despachador", ConfigS3: configS3})
+	assert.ErrorContains(t, err, "SecretoAutenticacion es requerido")
+
+	_, err = Crear(Opciones{NombreDB: t.TempDir() + "/db", URLTunel: "https://despachador", ConfigS3: configS3, SinAutenticacion: true})
+	assert.ErrorContains(t, err, "URLTunel requiere SecretoAutenticacion")
+
+	t.Log("Crear valida la URL del túnel y exige S3 y secreto")
+}
+
+// TestTunel_SirveAPI verifica que el nodo sirve su API por el túnel, verifica las
+// solicitudes y reabre el túnel cuando se corta
+func TestTunel_SirveAPI(t *testing.T) {
+	manager := crearManagerEdgeParaTest(t)
+	require.NoError(t, manager.CrearSerie(tipos.Serie{
+		Path:             "tunel/temp",
+		TipoDatos:        tipos.Real,
+		TamañoBloque:     100,
+		CompresionBloque: tipos.Ninguna,
+		CompresionBytes:  tipos.SinCompresion,
+	}))
+
+	servidor, conexiones := despachadorTunelTest(t, manager.nodoID, "secreto")
+	manager.urlTunel = servidor.URL
+	manager.secreto = []byte("secreto")
+	manager.verificador = tipos.NuevoVerificadorSolicitudes(manager.nodoID, manager.secreto, 0)
+	manager.iniciarTunel(manager.handlerAPI())
+	t.Cleanup(func() {
+		close(manager.done)
+		manager.cerrarTunel()
+	})
+
+	cliente := esperarTunelTest(t, conexiones)
+	require.Eventually(t, manager.TunelConectado, time.Second, 10*time.Millisecond)
+
+	solicitud := func(cliente *http2.ClientConn, firmar bool) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, "http://"+manager.nodoID+"/api/series", nil)
+		require.NoError(t, err)
+		if firmar {
+			require.NoError(t, tipos.FirmarSolicitud(req, nil, manager.nodoID, []byte("secreto")))
+		}
+		resp, err := cliente.RoundTrip(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// Las solicitudes sin firma se rechazan igual que en el servidor HTTP
+	assert.Equal(t, http.StatusUnauthorized, solicitud(cliente, false).StatusCode)
+
+	// Solicitudes concurrentes multiplexadas en la misma conexión
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "http://"+manager.nodoID+"/api/series", nil)
+			tipos.FirmarSolicitud(req, nil, manager.nodoID, []byte("secreto"))
+			resp, err := cliente.RoundTrip(req)
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	resp := solicitud(cliente, true)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var series []tipos.Serie
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&series))
+	require.Len(t, series, 1)
+	assert.Equal(t, "tunel/temp", series[0].Path)
+
+	// Al cortarse la conexión el nodo reabre el túnel
+	cliente.Close()
+	cliente = esperarTunelTest(t, conexiones)
+	assert.Equal(t, http.StatusOK, solicitud(cliente, true).StatusCode)
+
+	t.Log("El nodo sirve su API por el túnel y lo reabre al cortarse")
+}
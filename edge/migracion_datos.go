@@ -1,7 +1,6 @@
 package edge
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -10,52 +9,67 @@ import (
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/cbiale/sensorwave/tipos"
 	"github.com/cockroachdb/pebble"
 )
 
-// clienteS3 es el cliente S3 para la migración
-// Usa la interfaz tipos.ClienteS3 para permitir inyección de mocks en tests
-var clienteS3 tipos.ClienteS3
-var configuracionS3 tipos.ConfiguracionS3
-
-// ConfigurarS3 configura la conexión a almacenamiento S3-compatible
+// ConfigurarS3 configura la conexión del nodo a almacenamiento S3-compatible, o al
+// directorio local si cfg.Directorio no es vacío
 func (me *ManagerEdge) ConfigurarS3(cfg tipos.ConfiguracionS3) error {
-	configuracionS3 = cfg
-
-	// Crear cliente S3 usando la función centralizada
-	cliente, err := tipos.CrearClienteS3(cfg)
+	// Crear el almacenamiento usando la función centralizada
+	almacenamiento, err := tipos.CrearAlmacenamiento(cfg)
 	if err != nil {
 		return err
 	}
-	clienteS3 = cliente
+	if err := me.usarAlmacenamiento(almacenamiento, cfg.DisposicionClaves); err != nil {
+		return err
+	}
 
-	// Verificar que el bucket existe, si no, intentar crearlo
-	ctx := context.TODO()
-	_, err = clienteS3.HeadBucket(ctx, &s3.HeadBucketInput{
-		Bucket: aws.String(cfg.Bucket),
-	})
-	if err != nil {
-		log.Printf("El bucket %s no existe, intentando crearlo...", cfg.Bucket)
-		_, err = clienteS3.CreateBucket(ctx, &s3.CreateBucketInput{
-			Bucket: aws.String(cfg.Bucket),
-		})
-		if err != nil {
-			return fmt.Errorf("error al crear bucket: %v", err)
+	if cfg.Directorio != "" {
+		log.Printf("Almacenamiento local configurado exitosamente (directorio: %s)", cfg.Directorio)
+	} else {
+		log.Printf("Conexión a S3 configurada exitosamente (endpoint: %s, bucket: %s)", cfg.Endpoint, cfg.Bucket)
+	}
+	return nil
+}
+
+// validarConfiguracionS3 valida la configuración de almacenamiento del nodo. Con un
+// cliente S3 inyectado solo se usan el bucket y la disposición de claves (el endpoint y
+// las credenciales son del cliente); con un Almacenamiento inyectado, solo la disposición.
+func validarConfiguracionS3(opts Opciones) error {
+	if opts.ConfigS3 == nil {
+		return nil
+	}
+	cfg := *opts.ConfigS3
+	switch {
+	case opts.Almacenamiento != nil:
+		return cfg.DisposicionClaves.Validar()
+	case opts.ClienteS3 != nil:
+		if cfg.Bucket == "" {
+			return fmt.Errorf("Bucket es requerido")
 		}
-		log.Printf("Bucket %s creado exitosamente", cfg.Bucket)
+		return cfg.DisposicionClaves.Validar()
+	default:
+		return cfg.Validar()
 	}
+}
 
-	log.Printf("Conexión a S3 configurada exitosamente (endpoint: %s, bucket: %s)", cfg.Endpoint, cfg.Bucket)
+// usarAlmacenamiento prepara el almacenamiento (crea el bucket o el directorio si no
+// existe) y lo asigna al nodo. Si falla, el nodo queda sin nube.
+func (me *ManagerEdge) usarAlmacenamiento(almacenamiento tipos.Almacenamiento, disposicion tipos.DisposicionClaves) error {
+	if err := almacenamiento.Preparar(context.TODO()); err != nil {
+		return err
+	}
+
+	me.almacenamiento = almacenamiento
+	me.disposicionClaves = disposicion
 	return nil
 }
 
 // MigrarAS3 migra todas las series y datos a almacenamiento S3 como archivos
 func (me *ManagerEdge) MigrarAS3() error {
 	// Verificar que S3 esté configurado
-	if clienteS3 == nil {
+	if me.almacenamiento == nil {
 		// Intentar configurar desde variables de entorno
 		cfg := tipos.ConfiguracionS3{
 			Endpoint:        os.Getenv("S3_ENDPOINT"),
@@ -63,6 +77,9 @@ func (me *ManagerEdge) MigrarAS3() error {
 			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
 			Bucket:          os.Getenv("S3_BUCKET"),
 			Region:          os.Getenv("S3_REGION"),
+			Directorio:      os.Getenv("S3_DIRECTORIO"),
+
+			DisposicionClaves: tipos.DisposicionClaves(os.Getenv("S3_DISPOSICION_CLAVES")),
 		}
 
 		if cfg.Endpoint == "" {
@@ -75,7 +92,7 @@ func (me *ManagerEdge) MigrarAS3() error {
 			cfg.Region = "us-east-1"
 		}
 
-		if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		if cfg.Directorio == "" && (cfg.AccessKeyID == "" || cfg.SecretAccessKey == "") {
 			return fmt.Errorf("S3 no está configurado. Use ConfigurarS3() o configure las variables de entorno")
 		}
 
@@ -85,8 +102,28 @@ func (me *ManagerEdge) MigrarAS3() error {
 		}
 	}
 
-	ctx := context.TODO()
-	contadorMigrados := 0
+	informe, err := me.migrarTodo(context.TODO())
+	log.Printf("Migración a S3 finalizada: %s", resumirInformeMigracion(informe))
+	return err
+}
+
+// migrarTodo migra todos los bloques locales. Un error en un bloque o en una serie no
+// detiene la migración del resto: se informa y el bloque se conserva localmente.
+func (me *ManagerEdge) migrarTodo(ctx context.Context) (*InformeMigracion, error) {
+	// Una migración a la vez: el estado de cada bloque avanza en un solo goroutine
+	me.muMigracion.Lock()
+	defer me.muMigracion.Unlock()
+
+	informe := me.iniciarInformeMigracion("completa")
+	defer me.finalizarInformeMigracion(informe)
+
+	// Configuraciones de series por ID para calcular estadísticas del manifiesto
+	me.cache.mu.RLock()
+	seriesPorId := make(map[int]tipos.Serie, len(me.cache.datos))
+	for _, serie := range me.cache.datos {
+		seriesPorId[serie.SerieId] = serie
+	}
+	me.cache.mu.RUnlock()
 
 	// Iterar sobre los datos en PebbleDB y migrar a S3
 	iter, err := me.db.NewIter(&pebble.IterOptions{
@@ -94,14 +131,35 @@ func (me *ManagerEdge) MigrarAS3() error {
 		UpperBound: []byte("data0"),
 	})
 	if err != nil {
-		return fmt.Errorf("error al crear iterador para migración: %v", err)
+		return informe, fmt.Errorf("error al crear iterador para migración: %v", err)
 	}
 	defer iter.Close()
 
+	// Las claves están ordenadas por serie: se migra en lotes de una misma serie
+	// para actualizar su manifiesto una vez por lote
+	const tamañoLote = 100
+	serieActual := -1
+	var lote []bloqueLocal
+	var errores []error
+
+	migrarLote := func() {
+		if len(lote) == 0 {
+			return
+		}
+		serie, existe := seriesPorId[serieActual]
+		var seriePtr *tipos.Serie
+		if existe {
+			seriePtr = &serie
+		}
+		if _, err := me.migrarBloquesSerie(ctx, serieActual, seriePtr, lote, informe); err != nil {
+			errores = append(errores, fmt.Errorf("error migrando serie %d a S3: %v", serieActual, err))
+		}
+		lote = nil
+		log.Printf("Migrados %d registros a S3...", informe.Migrados)
+	}
+
 	for iter.First(); iter.Valid(); iter.Next() {
-		// Obtener clave y valor
 		clave := string(iter.Key())
-		valor := iter.Value()
 
 		// Extraer serieId y tiempos de la clave local para generar clave S3
 		serieId, tiempoInicio, tiempoFin, err := parsearClaveLocalDatos(clave)
@@ -110,55 +168,53 @@ func (me *ManagerEdge) MigrarAS3() error {
 			continue
 		}
 
-		// Crear nombre de archivo en S3 con formato optimizado
-		// Formato: nodoID/{serieId}_{tiempoInicio}_{tiempoFin}
-		nombreArchivo := tipos.GenerarClaveS3Datos(me.nodoID, serieId, tiempoInicio, tiempoFin)
-
-		// Subir archivo a S3
-		_, err = clienteS3.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: aws.String(configuracionS3.Bucket),
-			Key:    aws.String(nombreArchivo),
-			Body:   bytes.NewReader(valor),
-		})
-		if err != nil {
-			return fmt.Errorf("error al subir dato a S3 (clave: %s): %v", clave, err)
-		}
-
-		contadorMigrados++
-
-		// Borrar la entrada de PebbleDB después de migrar
-		err = me.db.Delete(iter.Key(), pebble.Sync)
-		if err != nil {
-			return fmt.Errorf("error al borrar dato migrado de PebbleDB: %v", err)
+		if serieId != serieActual || len(lote) >= tamañoLote {
+			migrarLote()
+			serieActual = serieId
 		}
 
-		// Log cada 100 registros migrados
-		if contadorMigrados%100 == 0 {
-			log.Printf("Migrados %d registros a S3...", contadorMigrados)
-		}
+		lote = append(lote, bloqueLocal{
+			clave:        append([]byte(nil), iter.Key()...),
+			valor:        append([]byte(nil), iter.Value()...),
+			tiempoInicio: tiempoInicio,
+			tiempoFin:    tiempoFin,
+		})
+		informe.Bloques++
 	}
 
 	// Verificar errores del iterador
 	if err := iter.Error(); err != nil {
-		return fmt.Errorf("error durante la iteración para migración: %v", err)
+		errores = append(errores, fmt.Errorf("error durante la iteración para migración: %v", err))
 	}
 
-	log.Printf("Migración a S3 completada exitosamente. Total de registros migrados: %d", contadorMigrados)
-	return nil
+	migrarLote()
+
+	return informe, unirErroresMigracion(errores)
 }
 
 // MigrarPorTiempoAlmacenamiento migra bloques de datos que excedan el tiempo de almacenamiento configurado
-// para cada serie. Solo migra series que tengan TiempoAlmacenamiento > 0.
+// para cada serie. Solo migra series que tengan TiempoAlmacenamiento > 0. Los bloques que
+// no pueden migrarse se conservan localmente y se reintentan en la próxima ejecución.
 func (me *ManagerEdge) MigrarPorTiempoAlmacenamiento() error {
 	// Verificar que S3 esté configurado
-	if clienteS3 == nil {
+	if me.almacenamiento == nil {
 		return fmt.Errorf("S3 no está configurado. Use ConfigurarS3() primero")
 	}
 
-	ctx := context.TODO()
+	_, err := me.migrarPorTiempo(context.TODO())
+	return err
+}
+
+// migrarPorTiempo ejecuta la migración por tiempo de almacenamiento y retorna su informe.
+// El error resume los bloques que no pudieron migrarse.
+func (me *ManagerEdge) migrarPorTiempo(ctx context.Context) (*InformeMigracion, error) {
+	me.muMigracion.Lock()
+	defer me.muMigracion.Unlock()
+
+	informe := me.iniciarInformeMigracion("por_tiempo")
+	defer me.finalizarInformeMigracion(informe)
+
 	ahora := time.Now().UnixNano()
-	contadorMigrados := 0
-	contadorEliminados := 0
 
 	// Obtener todas las series del cache
 	me.cache.mu.RLock()
@@ -172,11 +228,13 @@ func (me *ManagerEdge) MigrarPorTiempoAlmacenamiento() error {
 
 	if len(seriesConTiempo) == 0 {
 		log.Printf("No hay series con tiempo de almacenamiento configurado")
-		return nil
+		return informe, nil
 	}
 
 	log.Printf("Iniciando migración por tiempo de almacenamiento para %d series", len(seriesConTiempo))
 
+	var errores []error
+
 	// Procesar cada serie con tiempo de almacenamiento configurado
 	for _, serie := range seriesConTiempo {
 		tiempoLimite := ahora - serie.TiempoAlmacenamiento
@@ -190,79 +248,163 @@ func (me *ManagerEdge) MigrarPorTiempoAlmacenamiento() error {
 		})
 		if err != nil {
 			log.Printf("Error creando iterador para serie %s: %v", serie.Path, err)
+			errores = append(errores, fmt.Errorf("error creando iterador para serie %s: %v", serie.Path, err))
 			continue
 		}
 
-		// Recolectar claves a migrar (no podemos modificar durante iteración)
-		clavesAMigrar := make([][]byte, 0)
-		valoresAMigrar := make([][]byte, 0)
+		// Recolectar bloques a migrar (no podemos modificar durante iteración)
+		var bloquesAMigrar []bloqueLocal
 
 		for iter.First(); iter.Valid(); iter.Next() {
 			clave := string(iter.Key())
 
-			// Parsear tiempoFin de la clave
-			tiempoFin, err := parsearTiempoFinDeClave(clave)
+			// Extraer tiempos de la clave local para generar clave S3
+			_, tiempoInicio, tiempoFin, err := parsearClaveLocalDatos(clave)
 			if err != nil {
 				continue
 			}
 
 			// Si el bloque es más antiguo que el límite, marcarlo para migración
 			if tiempoFin < tiempoLimite {
-				// Copiar clave y valor
-				claveBytes := make([]byte, len(iter.Key()))
-				copy(claveBytes, iter.Key())
-				valorBytes := make([]byte, len(iter.Value()))
-				copy(valorBytes, iter.Value())
-
-				clavesAMigrar = append(clavesAMigrar, claveBytes)
-				valoresAMigrar = append(valoresAMigrar, valorBytes)
+				bloquesAMigrar = append(bloquesAMigrar, bloqueLocal{
+					clave:        append([]byte(nil), iter.Key()...),
+					valor:        append([]byte(nil), iter.Value()...),
+					tiempoInicio: tiempoInicio,
+					tiempoFin:    tiempoFin,
+				})
 			}
 		}
 		iter.Close()
 
-		// Migrar bloques recolectados
-		for i, clave := range clavesAMigrar {
-			valor := valoresAMigrar[i]
+		if len(bloquesAMigrar) == 0 {
+			continue
+		}
+		informe.Bloques += len(bloquesAMigrar)
 
-			// Extraer serieId y tiempos de la clave local para generar clave S3
-			serieId, tiempoInicio, tiempoFin, err := parsearClaveLocalDatos(string(clave))
-			if err != nil {
-				log.Printf("Advertencia: clave con formato inválido %s: %v", string(clave), err)
-				continue
-			}
+		// Migrar bloques recolectados y actualizar el manifiesto de la serie
+		migrados, err := me.migrarBloquesSerie(ctx, serie.SerieId, &serie, bloquesAMigrar, informe)
+		if err != nil {
+			log.Printf("Error migrando serie '%s' a S3: %v", serie.Path, err)
+			errores = append(errores, fmt.Errorf("error migrando serie '%s' a S3: %v", serie.Path, err))
+		}
 
-			// Crear nombre de archivo en S3 con formato optimizado
-			nombreArchivo := tipos.GenerarClaveS3Datos(me.nodoID, serieId, tiempoInicio, tiempoFin)
+		if migrados > 0 {
+			log.Printf("Serie '%s': %d bloques migrados a S3", serie.Path, migrados)
+		}
+	}
 
-			// Subir a S3
-			_, err = clienteS3.PutObject(ctx, &s3.PutObjectInput{
-				Bucket: aws.String(configuracionS3.Bucket),
-				Key:    aws.String(nombreArchivo),
-				Body:   bytes.NewReader(valor),
-			})
-			if err != nil {
-				log.Printf("Error subiendo bloque a S3 (clave: %s): %v", string(clave), err)
-				continue
-			}
-			contadorMigrados++
+	log.Printf("Migración por tiempo completada: %s", resumirInformeMigracion(informe))
+	return informe, unirErroresMigracion(errores)
+}
 
-			// Eliminar de PebbleDB después de migrar exitosamente
-			err = me.db.Delete(clave, pebble.Sync)
+// bloqueLocal es un bloque de datos de PebbleDB pendiente de migrar a S3
+type bloqueLocal struct {
+	clave        []byte // Clave local: data/{serieId}/{tiempoInicio}_{tiempoFin}
+	valor        []byte // Bloque comprimido
+	tiempoInicio int64
+	tiempoFin    int64
+}
+
+// migrarBloquesSerie sube y verifica bloques de una serie, actualiza el manifiesto de la
+// serie y recién entonces los elimina de PebbleDB junto con su estado de migración. Si el
+// manifiesto no puede actualizarse los bloques se conservan localmente (verificados) y la
+// próxima ejecución solo reintenta el manifiesto, por lo que el despachador nunca deja de
+// ver datos por un manifiesto desactualizado. Un bloque que falla no detiene al resto.
+// serie puede ser nil si la configuración ya no está en cache (sin estadísticas).
+// Retorna la cantidad de bloques subidos y verificados, aunque luego falle el manifiesto
+// o el borrado local, y los errores de todos los bloques y pasos que fallaron.
+func (me *ManagerEdge) migrarBloquesSerie(ctx context.Context, serieId int, serie *tipos.Serie, bloques []bloqueLocal, informe *InformeMigracion) (int, error) {
+	// Evita que dos migraciones concurrentes pisen el manifiesto (lectura-modificación-escritura)
+	me.muManifiestos.Lock()
+	defer me.muManifiestos.Unlock()
+
+	var verificados []bloqueLocal
+	var entradas []tipos.BloqueManifiesto
+	var errores []error
+
+	for _, bloque := range bloques {
+		registro, err := me.migrarBloque(ctx, serieId, bloque, informe)
+		if err != nil {
+			informe.agregarError(1, err)
+			errores = append(errores, err)
+			me.publicarProgresoMigracion(informe)
+			continue
+		}
+
+		// Estadísticas del bloque para el manifiesto
+		var mediciones []tipos.Medicion
+		if serie != nil {
+			mediciones, err = me.descomprimirBloque(bloque.valor, *serie)
 			if err != nil {
-				log.Printf("Error eliminando bloque migrado de PebbleDB: %v", err)
-				continue
+				log.Printf("Advertencia: no se pudieron calcular estadísticas del bloque %s: %v", registro.Clave, err)
+				mediciones = nil
 			}
-			contadorEliminados++
 		}
+		entrada := tipos.NuevoBloqueManifiesto(
+			registro.Clave, bloque.tiempoInicio, bloque.tiempoFin, int64(len(bloque.valor)), mediciones)
+		entrada.ETag = registro.ETag // Permite al despachador validar su cache de bloques
+		entradas = append(entradas, entrada)
+		verificados = append(verificados, bloque)
+	}
+
+	if len(verificados) == 0 {
+		return 0, unirErroresMigracion(errores)
+	}
 
-		if len(clavesAMigrar) > 0 {
-			log.Printf("Serie '%s': %d bloques migrados a S3", serie.Path, len(clavesAMigrar))
+	// Actualizar manifiesto; si no existe o no es legible se reconstruye desde el listado
+	if err := me.agregarBloquesManifiesto(ctx, serieId, serie, entradas); err != nil {
+		informe.agregarError(len(verificados), err)
+		me.publicarProgresoMigracion(informe)
+		return len(verificados), unirErroresMigracion(append([]error{err}, errores...))
+	}
+
+	// Borrar las entradas de PebbleDB ya referenciadas por el manifiesto junto con su
+	// estado de migración, en un único lote para no dejar registros huérfanos
+	lote := me.db.NewBatch()
+	defer lote.Close()
+	for _, bloque := range verificados {
+		if err := lote.Delete(bloque.clave, nil); err != nil {
+			err = fmt.Errorf("error al borrar dato migrado de PebbleDB: %v", err)
+			return len(verificados), unirErroresMigracion(append([]error{err}, errores...))
 		}
+		if err := lote.Delete(generarClaveRegistroMigracion(bloque.clave), nil); err != nil {
+			err = fmt.Errorf("error al borrar estado de migración de PebbleDB: %v", err)
+			return len(verificados), unirErroresMigracion(append([]error{err}, errores...))
+		}
+	}
+	if err := lote.Commit(pebble.Sync); err != nil {
+		informe.agregarError(len(verificados), err)
+		me.publicarProgresoMigracion(informe)
+		err = fmt.Errorf("error al borrar datos migrados de PebbleDB: %v", err)
+		return len(verificados), unirErroresMigracion(append([]error{err}, errores...))
 	}
 
-	log.Printf("Migración por tiempo completada: %d bloques migrados, %d eliminados localmente",
-		contadorMigrados, contadorEliminados)
-	return nil
+	informe.Procesados += len(verificados)
+	informe.Migrados += len(verificados)
+	me.publicarProgresoMigracion(informe)
+
+	return len(verificados), unirErroresMigracion(errores)
+}
+
+// agregarBloquesManifiesto agrega las entradas al manifiesto de la serie y lo guarda
+func (me *ManagerEdge) agregarBloquesManifiesto(ctx context.Context, serieId int, serie *tipos.Serie, entradas []tipos.BloqueManifiesto) error {
+	manifiesto, err := tipos.LeerManifiesto(ctx, me.almacenamiento, me.nodoID, serieId)
+	if err != nil {
+		path := ""
+		if serie != nil {
+			path = serie.Path
+		}
+		manifiesto, err = tipos.ConstruirManifiestoDesdeListado(ctx, me.almacenamiento, me.nodoID, serieId, path)
+		if err != nil {
+			return fmt.Errorf("error reconstruyendo manifiesto: %v", err)
+		}
+	}
+	if serie != nil {
+		manifiesto.Path = serie.Path
+	}
+	manifiesto.AgregarBloques(entradas...)
+
+	return tipos.GuardarManifiesto(ctx, me.almacenamiento, manifiesto)
 }
 
 // parsearTiempoFinDeClave extrae el tiempoFin de una clave con formato "data/{serieId}/{tiempoInicio}_{tiempoFin}"
@@ -330,7 +472,7 @@ func (me *ManagerEdge) IniciarMigracionAutomatica(intervalo time.Duration) {
 				log.Printf("Deteniendo migración automática")
 				return
 			case <-ticker.C:
-				if clienteS3 == nil {
+				if me.almacenamiento == nil {
 					continue // S3 no configurado, saltar
 				}
 
@@ -435,52 +577,28 @@ func (me *ManagerEdge) eliminarPendienteCompletado(serieId int) error {
 // eliminarSerieDeS3 elimina todos los objetos de una serie en S3
 // Retorna el número de objetos eliminados y un error si falla
 func (me *ManagerEdge) eliminarSerieDeS3(serieId int) (int, error) {
-	if clienteS3 == nil {
+	if me.almacenamiento == nil {
 		return 0, fmt.Errorf("S3 no está configurado")
 	}
 
 	ctx := context.TODO()
-	prefijo := tipos.GenerarPrefijoS3Serie(me.nodoID, serieId)
 	objetosEliminados := 0
 
-	// Listar objetos con el prefijo de la serie
-	listInput := &s3.ListObjectsV2Input{
-		Bucket: aws.String(configuracionS3.Bucket),
-		Prefix: aws.String(prefijo),
-	}
-
-	resultado, err := clienteS3.ListObjectsV2(ctx, listInput)
-	if err != nil {
-		return 0, fmt.Errorf("error listando objetos en S3: %v", err)
-	}
-
-	// Eliminar cada objeto encontrado
-	for _, objeto := range resultado.Contents {
-		_, err := clienteS3.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(configuracionS3.Bucket),
-			Key:    objeto.Key,
-		})
-		if err != nil {
-			return objetosEliminados, fmt.Errorf("error eliminando objeto %s: %v", *objeto.Key, err)
-		}
-		objetosEliminados++
-	}
+	// Se recorren ambas disposiciones: el bucket puede contener bloques con claves
+	// planas y particionadas si la disposición cambió o se está reorganizando
+	for _, disposicion := range []tipos.DisposicionClaves{tipos.DisposicionPlana, tipos.DisposicionParticionada} {
+		prefijo := disposicion.GenerarPrefijoSerie(me.nodoID, serieId)
 
-	// Si hay más objetos (paginación), continuar
-	for resultado.IsTruncated != nil && *resultado.IsTruncated {
-		listInput.ContinuationToken = resultado.NextContinuationToken
-		resultado, err = clienteS3.ListObjectsV2(ctx, listInput)
+		// Listar objetos con el prefijo de la serie (bloques y manifiesto)
+		objetos, err := tipos.ListarObjetos(ctx, me.almacenamiento, prefijo)
 		if err != nil {
-			return objetosEliminados, fmt.Errorf("error listando más objetos en S3: %v", err)
+			return objetosEliminados, fmt.Errorf("error listando objetos en S3: %v", err)
 		}
 
-		for _, objeto := range resultado.Contents {
-			_, err := clienteS3.DeleteObject(ctx, &s3.DeleteObjectInput{
-				Bucket: aws.String(configuracionS3.Bucket),
-				Key:    objeto.Key,
-			})
-			if err != nil {
-				return objetosEliminados, fmt.Errorf("error eliminando objeto %s: %v", *objeto.Key, err)
+		// Eliminar cada objeto encontrado
+		for _, objeto := range objetos {
+			if err := me.almacenamiento.Eliminar(ctx, objeto.Clave); err != nil {
+				return objetosEliminados, fmt.Errorf("error eliminando objeto %s: %v", objeto.Clave, err)
 			}
 			objetosEliminados++
 		}
@@ -499,7 +617,7 @@ func (me *ManagerEdge) ProcesarEliminacionesPendientes() error {
 	default:
 	}
 
-	if clienteS3 == nil {
+	if me.almacenamiento == nil {
 		return nil // Sin S3, nada que procesar
 	}
 
@@ -565,7 +683,7 @@ func (me *ManagerEdge) IniciarLimpiezaS3Automatica() {
 				log.Printf("Deteniendo limpieza automática de S3")
 				return
 			case <-ticker.C:
-				if clienteS3 == nil {
+				if me.almacenamiento == nil {
 					continue // S3 no configurado, saltar
 				}
 
@@ -0,0 +1,363 @@
+package edge
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cbiale/sensorwave/tipos"
+	"github.com/cockroachdb/pebble"
+)
+
+// ============================================================================
+// ESTADO DE LA MIGRACIÓN DE BLOQUES
+// Cada bloque en migración tiene un registro en PebbleDB que avanza de pendiente
+// a subido y a verificado; el registro se elimina junto con el bloque local una
+// vez que el manifiesto lo referencia. Tras un reinicio la migración retoma cada
+// bloque desde su último estado: las subidas no sobrescriben objetos existentes y
+// se verifican con el MD5 del bloque, por lo que repetirlas no duplica ni
+// corrompe datos.
+// ============================================================================
+
+// EstadoBloque es la etapa de la migración en la que se encuentra un bloque
+type EstadoBloque string
+
+const (
+	BloquePendiente  EstadoBloque = "pendiente"  // Subida no confirmada
+	BloqueSubido     EstadoBloque = "subido"     // Subido, contenido sin verificar
+	BloqueVerificado EstadoBloque = "verificado" // Verificado, falta referenciarlo en el manifiesto
+)
+
+// maxErroresInforme limita los errores detallados en un informe de migración
+const maxErroresInforme = 20
+
+// errConflictoMigracion indica que la clave del bloque ya existe en el almacenamiento
+// con otro contenido. El objeto no se sobrescribe y el bloque se conserva localmente.
+var errConflictoMigracion = errors.New("la clave existe en el almacenamiento con otro contenido")
+
+// RegistroMigracion es el estado de la migración de un bloque, persistido en PebbleDB
+type RegistroMigracion struct {
+	Clave       string       // Clave del objeto en el almacenamiento
+	MD5         string       // MD5 del bloque local (hexadecimal)
+	ETag        string       // ETag del objeto subido
+	Estado      EstadoBloque // Etapa alcanzada
+	Intentos    int          // Subidas o verificaciones fallidas
+	UltimoError string       // Error del último intento fallido
+	Actualizado int64        // Última actualización (UnixNano)
+}
+
+// InformeMigracion resume una ejecución de la migración. Mientras la migración está
+// en curso refleja su progreso.
+type InformeMigracion struct {
+	Tipo       string   `json:"tipo"`              // "completa" (MigrarAS3) o "por_tiempo"
+	Inicio     int64    `json:"inicio"`            // Inicio de la ejecución (UnixNano)
+	Fin        int64    `json:"fin"`               // Fin de la ejecución (UnixNano, 0 = en curso)
+	Bloques    int      `json:"bloques"`           // Bloques a migrar encontrados hasta el momento
+	Procesados int      `json:"procesados"`        // Bloques migrados o con error
+	Migrados   int      `json:"migrados"`          // Verificados, en el manifiesto y eliminados localmente
+	Reanudados int      `json:"reanudados"`        // Retomados del estado alcanzado en una ejecución anterior
+	Existentes int      `json:"existentes"`        // Ya estaban en el almacenamiento con el mismo contenido
+	Conflictos int      `json:"conflictos"`        // La clave existe con otro contenido (no se sobrescribe)
+	Fallidos   int      `json:"fallidos"`          // Con error de subida, verificación o manifiesto
+	Bytes      int64    `json:"bytes"`             // Bytes subidos
+	Errores    []string `json:"errores,omitempty"` // Primeros errores de la ejecución
+}
+
+// EstadoMigracion es el progreso de la migración del nodo
+type EstadoMigracion struct {
+	Solicitada bool                 `json:"solicitada"`         // Migración solicitada por la API sin terminar
+	EnCurso    *InformeMigracion    `json:"en_curso,omitempty"` // Migración en ejecución (nil = ninguna)
+	Ultima     *InformeMigracion    `json:"ultima,omitempty"`   // Última migración terminada (nil = ninguna)
+	Bloques    map[EstadoBloque]int `json:"bloques"`            // Bloques con la migración iniciada y sin terminar
+}
+
+// EstadoMigracion retorna el progreso de la migración en curso, el informe de la
+// última terminada y los bloques con la migración sin terminar por estado
+func (me *ManagerEdge) EstadoMigracion() (EstadoMigracion, error) {
+	estado := EstadoMigracion{Bloques: make(map[EstadoBloque]int)}
+
+	me.muInformesMigracion.Lock()
+	estado.EnCurso = copiarInforme(me.migracionEnCurso)
+	estado.Ultima = copiarInforme(me.ultimaMigracion)
+	estado.Solicitada = me.migracionSolicitada
+	me.muInformesMigracion.Unlock()
+
+	iter, err := me.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte("migracion/"),
+		UpperBound: []byte("migracion0"),
+	})
+	if err != nil {
+		return estado, fmt.Errorf("error creando iterador de migración: %v", err)
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		var registro RegistroMigracion
+		if err := tipos.DeserializarGob(iter.Value(), &registro); err != nil {
+			log.Printf("Advertencia: error deserializando registro de migración %s: %v", string(iter.Key()), err)
+			continue
+		}
+		estado.Bloques[registro.Estado]++
+	}
+	if err := iter.Error(); err != nil {
+		return estado, fmt.Errorf("error iterando registros de migración: %v", err)
+	}
+	return estado, nil
+}
+
+// solicitarMigracion inicia en segundo plano la migración por tiempo de almacenamiento,
+// independiente de la solicitud HTTP que la pidió. Retorna false si ya hay una
+// migración solicitada sin terminar. Cerrar cancela la migración y espera a que
+// termine: lo migrado se conserva y la próxima ejecución retoma el resto.
+func (me *ManagerEdge) solicitarMigracion() bool {
+	me.muInformesMigracion.Lock()
+	defer me.muInformesMigracion.Unlock()
+	if me.migracionSolicitada {
+		return false
+	}
+	me.migracionSolicitada = true
+
+	me.migraciones.Add(1)
+	go func() {
+		defer me.migraciones.Done()
+
+		ctx, cancelar := context.WithCancel(context.Background())
+		defer cancelar()
+		go func() {
+			select {
+			case <-me.done:
+				cancelar()
+			case <-ctx.Done():
+			}
+		}()
+
+		if _, err := me.migrarPorTiempo(ctx); err != nil {
+			log.Printf("Migración solicitada terminada con errores: %v", err)
+		}
+
+		me.muInformesMigracion.Lock()
+		me.migracionSolicitada = false
+		me.muInformesMigracion.Unlock()
+	}()
+	return true
+}
+
+// iniciarInformeMigracion registra el comienzo de una ejecución de la migración
+func (me *ManagerEdge) iniciarInformeMigracion(tipo string) *InformeMigracion {
+	informe := &InformeMigracion{Tipo: tipo, Inicio: time.Now().UnixNano()}
+	me.publicarProgresoMigracion(informe)
+	return informe
+}
+
+// publicarProgresoMigracion publica una copia del informe de la migración en curso
+func (me *ManagerEdge) publicarProgresoMigracion(informe *InformeMigracion) {
+	me.muInformesMigracion.Lock()
+	defer me.muInformesMigracion.Unlock()
+	me.migracionEnCurso = copiarInforme(informe)
+}
+
+// finalizarInformeMigracion registra el fin de la ejecución y conserva su informe
+func (me *ManagerEdge) finalizarInformeMigracion(informe *InformeMigracion) {
+	informe.Fin = time.Now().UnixNano()
+
+	me.muInformesMigracion.Lock()
+	defer me.muInformesMigracion.Unlock()
+	me.migracionEnCurso = nil
+	me.ultimaMigracion = copiarInforme(informe)
+}
+
+// copiarInforme retorna una copia del informe que no comparte los errores
+func copiarInforme(informe *InformeMigracion) *InformeMigracion {
+	if informe == nil {
+		return nil
+	}
+	copia := *informe
+	copia.Errores = append([]string(nil), informe.Errores...)
+	return &copia
+}
+
+// agregarError cuenta los bloques que fallaron por err y guarda su detalle
+func (informe *InformeMigracion) agregarError(bloques int, err error) {
+	informe.Procesados += bloques
+	if errors.Is(err, errConflictoMigracion) {
+		informe.Conflictos += bloques
+	} else {
+		informe.Fallidos += bloques
+	}
+	if len(informe.Errores) < maxErroresInforme {
+		informe.Errores = append(informe.Errores, err.Error())
+	}
+}
+
+// resumirInformeMigracion describe el resultado de la migración en una línea para el log
+func resumirInformeMigracion(informe *InformeMigracion) string {
+	return fmt.Sprintf("%d de %d bloques migrados (%d reanudados, %d ya existentes, %d conflictos, %d fallidos, %d bytes subidos)",
+		informe.Migrados, informe.Bloques, informe.Reanudados, informe.Existentes,
+		informe.Conflictos, informe.Fallidos, informe.Bytes)
+}
+
+// unirErroresMigracion resume los errores de una migración en uno solo
+func unirErroresMigracion(errores []error) error {
+	switch len(errores) {
+	case 0:
+		return nil
+	case 1:
+		return errores[0]
+	default:
+		return fmt.Errorf("%v (y %d errores más)", errores[0], len(errores)-1)
+	}
+}
+
+// ============================================================================
+// REGISTROS EN PEBBLEDB
+// ============================================================================
+
+// generarClaveRegistroMigracion genera la clave del registro de migración de un
+// bloque local: migracion/{serieId}/{tiempoInicio}_{tiempoFin}
+func generarClaveRegistroMigracion(claveLocal []byte) []byte {
+	return append([]byte("migracion/"), bytes.TrimPrefix(claveLocal, []byte("data/"))...)
+}
+
+// leerRegistroMigracion lee el registro de migración del bloque local
+func (me *ManagerEdge) leerRegistroMigracion(claveLocal []byte) (RegistroMigracion, bool, error) {
+	var registro RegistroMigracion
+	datos, closer, err := me.db.Get(generarClaveRegistroMigracion(claveLocal))
+	if err == pebble.ErrNotFound {
+		return registro, false, nil
+	}
+	if err != nil {
+		return registro, false, fmt.Errorf("error leyendo registro de migración: %v", err)
+	}
+	defer closer.Close()
+
+	if err := tipos.DeserializarGob(datos, &registro); err != nil {
+		// Un registro ilegible se descarta: la migración del bloque empieza de nuevo
+		log.Printf("Advertencia: registro de migración de %s ilegible: %v", string(claveLocal), err)
+		return RegistroMigracion{}, false, nil
+	}
+	return registro, true, nil
+}
+
+// guardarRegistroMigracion guarda el registro de migración del bloque local
+func (me *ManagerEdge) guardarRegistroMigracion(claveLocal []byte, registro *RegistroMigracion) error {
+	registro.Actualizado = time.Now().UnixNano()
+	datos, err := tipos.SerializarGob(*registro)
+	if err != nil {
+		return fmt.Errorf("error serializando registro de migración: %v", err)
+	}
+	if err := me.db.Set(generarClaveRegistroMigracion(claveLocal), datos, pebble.Sync); err != nil {
+		return fmt.Errorf("error guardando registro de migración: %v", err)
+	}
+	return nil
+}
+
+// fallarRegistroMigracion registra el intento fallido en el registro del bloque y retorna err
+func (me *ManagerEdge) fallarRegistroMigracion(claveLocal []byte, registro *RegistroMigracion, err error) error {
+	registro.Intentos++
+	registro.UltimoError = err.Error()
+	if errGuardar := me.guardarRegistroMigracion(claveLocal, registro); errGuardar != nil {
+		log.Printf("Advertencia: %v", errGuardar)
+	}
+	return err
+}
+
+// ============================================================================
+// MIGRACIÓN DE UN BLOQUE
+// ============================================================================
+
+// migrarBloque sube y verifica el bloque retomando desde el estado de su registro.
+// Retorna el registro en estado verificado.
+func (me *ManagerEdge) migrarBloque(ctx context.Context, serieId int, bloque bloqueLocal, informe *InformeMigracion) (RegistroMigracion, error) {
+	suma := md5.Sum(bloque.valor)
+	sumaHex := hex.EncodeToString(suma[:])
+
+	registro, existe, err := me.leerRegistroMigracion(bloque.clave)
+	if err != nil {
+		return registro, err
+	}
+	if !existe || registro.MD5 != sumaHex {
+		// Bloque nuevo, o reescrito localmente desde el último intento
+		registro = RegistroMigracion{MD5: sumaHex, Estado: BloquePendiente}
+	} else if registro.Estado != BloquePendiente {
+		informe.Reanudados++
+	}
+
+	existente := false
+	if registro.Estado == BloquePendiente {
+		// La clave se calcula en cada intento por si cambió la disposición
+		registro.Clave = me.disposicionClaves.GenerarClaveDatos(me.nodoID, serieId, bloque.tiempoInicio, bloque.tiempoFin)
+		if err := me.guardarRegistroMigracion(bloque.clave, &registro); err != nil {
+			return registro, err
+		}
+
+		etag, err := me.almacenamiento.Guardar(ctx, registro.Clave, bloque.valor, tipos.OpcionesGuardar{
+			MD5:            suma[:],
+			SoloSiNoExiste: true,
+		})
+		switch {
+		case errors.Is(err, tipos.ErrObjetoExistente):
+			// Subido por un intento interrumpido (o por otra vía): se verifica el existente
+			existente = true
+		case err != nil:
+			return registro, me.fallarRegistroMigracion(bloque.clave, &registro,
+				fmt.Errorf("error al subir dato a S3 (clave: %s): %v", string(bloque.clave), err))
+		default:
+			informe.Bytes += int64(len(bloque.valor))
+		}
+
+		registro.Estado = BloqueSubido
+		registro.ETag = etag
+		if err := me.guardarRegistroMigracion(bloque.clave, &registro); err != nil {
+			return registro, err
+		}
+	}
+
+	if registro.Estado == BloqueSubido {
+		etag, err := me.verificarObjetoMigrado(ctx, registro.Clave, suma, registro.ETag)
+		if err != nil {
+			if errors.Is(err, errConflictoMigracion) || errors.Is(err, tipos.ErrObjetoInexistente) {
+				// Se vuelve a intentar la subida condicional: si la clave se libera, el
+				// bloque se sube; si no, se conserva localmente sin sobrescribir el objeto
+				registro.Estado = BloquePendiente
+			}
+			return registro, me.fallarRegistroMigracion(bloque.clave, &registro, err)
+		}
+		if existente {
+			informe.Existentes++
+		}
+
+		registro.Estado = BloqueVerificado
+		registro.ETag = etag
+		registro.UltimoError = ""
+		if err := me.guardarRegistroMigracion(bloque.clave, &registro); err != nil {
+			return registro, err
+		}
+	}
+
+	return registro, nil
+}
+
+// verificarObjetoMigrado comprueba que el objeto tenga el contenido del bloque. El ETag
+// de una subida simple es el MD5 del contenido, que el almacenamiento ya comprobó contra
+// el Content-MD5 enviado; si no lo es (objeto existente, cifrado con KMS o subido en
+// partes) se descarga el objeto y se compara su MD5. Retorna el ETag del objeto.
+func (me *ManagerEdge) verificarObjetoMigrado(ctx context.Context, clave string, suma [md5.Size]byte, etag string) (string, error) {
+	if strings.EqualFold(strings.Trim(etag, `"`), hex.EncodeToString(suma[:])) {
+		return etag, nil
+	}
+
+	datos, etagLeido, err := me.almacenamiento.Leer(ctx, clave, "")
+	if err != nil {
+		return "", fmt.Errorf("error verificando %s: %w", clave, err)
+	}
+	if md5.Sum(datos) != suma {
+		return "", fmt.Errorf("%w: %s", errConflictoMigracion, clave)
+	}
+	return etagLeido, nil
+}
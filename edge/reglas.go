@@ -71,6 +71,12 @@ type Condicion struct {
 	//   - false (default): Modo "any" - la condición es verdadera si ALGUNA serie cumple
 	//   - true: Modo "all" - primero agrega los valores de todas las series, luego evalúa
 	AgregarSeries bool
+
+	// Ventana especifica una función de ventana opcional (media móvil, EWMA, suma acumulada,
+	// diferencia) que se aplica a los datos de la ventana temporal antes de evaluar.
+	// Con Ventana, el valor de cada serie es el último valor transformado, o la Agregacion
+	// aplicada sobre los valores transformados si se especifica.
+	Ventana *tipos.FuncionVentana
 }
 
 type Accion struct {
@@ -259,6 +265,10 @@ func (mr *MotorReglas) evaluarCondicion(condicion *Condicion, timestamp time.Tim
 	// Mantenemos compatibilidad con "last" como string para indicar último valor
 	agregacionVacia := condicion.Agregacion == "" || condicion.Agregacion == "last"
 
+	if condicion.Ventana != nil {
+		return mr.evaluarCondicionConVentana(condicion, tiempoInicio, timestamp, agregacionVacia)
+	}
+
 	if agregacionVacia {
 		// Sin agregación (o "last") = obtener último valor en ventana
 		resultado, err := mr.manager.ConsultarUltimoPunto(condicion.Path, &tiempoInicio, &timestamp)
@@ -307,6 +317,64 @@ func (mr *MotorReglas) evaluarCondicion(condicion *Condicion, timestamp time.Tim
 	return false
 }
 
+// evaluarCondicionConVentana evalúa una condición cuyos datos se transforman con una función de ventana.
+// Usa ConsultarRangoConVentana y reduce cada serie a un único valor: el último valor transformado
+// o la agregación indicada sobre los valores transformados.
+func (mr *MotorReglas) evaluarCondicionConVentana(condicion *Condicion, tiempoInicio, timestamp time.Time, agregacionVacia bool) bool {
+	resultado, err := mr.manager.ConsultarRangoConVentana(condicion.Path, tiempoInicio, timestamp, *condicion.Ventana)
+	if err != nil || len(resultado.Series) == 0 {
+		return false
+	}
+
+	// Reducir cada serie (columna) a un valor
+	var valoresSeries []float64
+	for col := range resultado.Series {
+		var transformados []float64
+		for fila := range resultado.Tiempos {
+			if v, ok := resultado.Valores[fila][col].(float64); ok {
+				transformados = append(transformados, v)
+			}
+		}
+		if len(transformados) == 0 {
+			continue
+		}
+
+		if agregacionVacia {
+			valoresSeries = append(valoresSeries, transformados[len(transformados)-1])
+			continue
+		}
+		valor, err := CalcularAgregacionSimple(transformados, condicion.Agregacion)
+		if err != nil {
+			continue
+		}
+		valoresSeries = append(valoresSeries, valor)
+	}
+
+	if len(valoresSeries) == 0 {
+		return false
+	}
+
+	if condicion.AgregarSeries {
+		// Modo "all": sin agregación se usa el primer valor (igual que sin ventana)
+		if agregacionVacia {
+			return mr.aplicarOperador(valoresSeries[0], condicion.Operador, condicion.Valor)
+		}
+		valorFinal, err := CalcularAgregacionSimple(valoresSeries, condicion.Agregacion)
+		if err != nil {
+			return false
+		}
+		return mr.aplicarOperador(valorFinal, condicion.Operador, condicion.Valor)
+	}
+
+	// Modo "any": si alguna serie cumple
+	for _, valor := range valoresSeries {
+		if mr.aplicarOperador(valor, condicion.Operador, condicion.Valor) {
+			return true
+		}
+	}
+	return false
+}
+
 func (mr *MotorReglas) aplicarOperador(valor1 interface{}, operador TipoOperador, valor2 interface{}) bool {
 	const epsilon = 1e-9
 
@@ -584,6 +652,20 @@ func (mr *MotorReglas) validarCondicion(condicion *Condicion) error {
 		}
 	}
 
+	// VALIDACIÓN 9: Función de ventana válida y sobre series numéricas comparadas con números
+	if condicion.Ventana != nil {
+		if err := condicion.Ventana.Validar(); err != nil {
+			return fmt.Errorf("función de ventana inválida: %v", err)
+		}
+		switch condicion.Valor.(type) {
+		case bool, string:
+			return fmt.Errorf("función de ventana requiere un valor numérico (recibido: %T)", condicion.Valor)
+		}
+		if err := mr.validarAgregacionCompatible(condicion); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -716,6 +798,74 @@ func generarClaveRegla(id string) []byte {
 	return []byte("reglas/" + id)
 }
 
+// reglaATipos convierte una regla del motor a su versión serializable
+func reglaATipos(regla *Regla) tipos.Regla {
+	var condiciones []tipos.Condicion
+	for _, c := range regla.Condiciones {
+		condiciones = append(condiciones, tipos.Condicion{
+			Path:          c.Path,
+			VentanaT:      c.VentanaT.String(),
+			Agregacion:    string(c.Agregacion),
+			Operador:      string(c.Operador),
+			Valor:         c.Valor,
+			AgregarSeries: c.AgregarSeries,
+			Ventana:       c.Ventana,
+		})
+	}
+
+	var acciones []tipos.Accion
+	for _, a := range regla.Acciones {
+		acciones = append(acciones, tipos.Accion{
+			Tipo:    a.Tipo,
+			Destino: a.Destino,
+			Params:  a.Params,
+		})
+	}
+
+	return tipos.Regla{
+		ID:          regla.ID,
+		Nombre:      regla.Nombre,
+		Activa:      regla.Activa,
+		Logica:      string(regla.Logica),
+		Condiciones: condiciones,
+		Acciones:    acciones,
+	}
+}
+
+// reglaDesdeTipos convierte una regla serializable a una regla del motor.
+// VentanaT admite cualquier formato de tipos.ParsearDuracion ("5m", "1h30m", "1d").
+func reglaDesdeTipos(r tipos.Regla) (*Regla, error) {
+	regla := &Regla{
+		ID:     r.ID,
+		Nombre: r.Nombre,
+		Activa: r.Activa,
+		Logica: TipoLogica(r.Logica),
+	}
+	for i, c := range r.Condiciones {
+		ventana, err := tipos.ParsearDuracion(c.VentanaT)
+		if err != nil {
+			return nil, fmt.Errorf("condición %d: ventana_t inválida: %v", i, err)
+		}
+		regla.Condiciones = append(regla.Condiciones, Condicion{
+			Path:          c.Path,
+			VentanaT:      ventana,
+			Agregacion:    TipoAgregacion(c.Agregacion),
+			Operador:      TipoOperador(c.Operador),
+			Valor:         c.Valor,
+			AgregarSeries: c.AgregarSeries,
+			Ventana:       c.Ventana,
+		})
+	}
+	for _, a := range r.Acciones {
+		regla.Acciones = append(regla.Acciones, Accion{
+			Tipo:    a.Tipo,
+			Destino: a.Destino,
+			Params:  a.Params,
+		})
+	}
+	return regla, nil
+}
+
 func serializarRegla(regla *Regla) ([]byte, error) {
 	return tipos.SerializarGob(regla)
 }
@@ -784,7 +934,7 @@ func (mr *MotorReglas) AgregarRegla(regla *Regla) error {
 	log.Printf("Regla '%s' agregada exitosamente", regla.ID)
 
 	// Actualizar S3 (best-effort, igual que CrearSerie)
-	if mr.manager != nil && clienteS3 != nil {
+	if mr.manager != nil && mr.manager.almacenamiento != nil {
 		if err := mr.manager.RegistrarEnS3(); err != nil {
 			log.Printf("Error registrando regla nueva en S3: %v", err)
 			// No retornar error - la regla ya fue guardada localmente
@@ -810,7 +960,7 @@ func (mr *MotorReglas) EliminarRegla(id string) error {
 	log.Printf("Regla '%s' eliminada", id)
 
 	// Actualizar S3 (best-effort)
-	if mr.manager != nil && clienteS3 != nil {
+	if mr.manager != nil && mr.manager.almacenamiento != nil {
 		if err := mr.manager.RegistrarEnS3(); err != nil {
 			log.Printf("Error registrando eliminación de regla en S3: %v", err)
 		}
@@ -848,7 +998,7 @@ func (mr *MotorReglas) ActualizarRegla(regla *Regla) error {
 	log.Printf("Regla '%s' actualizada", regla.ID)
 
 	// Actualizar S3 (best-effort)
-	if mr.manager != nil && clienteS3 != nil {
+	if mr.manager != nil && mr.manager.almacenamiento != nil {
 		if err := mr.manager.RegistrarEnS3(); err != nil {
 			log.Printf("Error registrando regla actualizada en S3: %v", err)
 		}
@@ -856,3 +1006,15 @@ func (mr *MotorReglas) ActualizarRegla(regla *Regla) error {
 
 	return nil
 }
+
+// HabilitarRegla activa o desactiva una regla y persiste el cambio.
+// Las reglas inactivas se conservan pero no se evalúan.
+func (mr *MotorReglas) HabilitarRegla(id string, activa bool) error {
+	regla, err := mr.ObtenerRegla(id)
+	if err != nil {
+		return err
+	}
+	copia := *regla
+	copia.Activa = activa
+	return mr.ActualizarRegla(&copia)
+}
@@ -0,0 +1,170 @@
+package edge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// TLS DE LA API REST
+// El servidor HTTP del edge puede servir HTTPS con un certificado propio
+// (archivos PEM) o con uno autofirmado que se genera una vez y se guarda en la
+// base local, de modo que su huella no cambia entre reinicios. La huella del
+// certificado autofirmado se publica en el registro del nodo (firmado) y el
+// despachador la usa para verificar la conexión.
+// ============================================================================
+
+// Validez del certificado autofirmado
+const validezCertificadoAutofirmado = 10 * 365 * 24 * time.Hour
+
+// configurarTLS carga el certificado del servidor según las opciones.
+// Sin archivos ni TLSAutofirmado el servidor sirve HTTP plano.
+func (me *ManagerEdge) configurarTLS(opts Opciones) error {
+	switch {
+	case opts.ArchivoCertificadoTLS != "":
+		certificado, err := tls.LoadX509KeyPair(opts.ArchivoCertificadoTLS, opts.ArchivoClaveTLS)
+		if err != nil {
+			return fmt.Errorf("error al cargar certificado TLS: %v", err)
+		}
+		me.certificadoTLS = &certificado
+		log.Printf("TLS habilitado con certificado %s", opts.ArchivoCertificadoTLS)
+
+	case opts.TLSAutofirmado:
+		certificado, err := cargarCertificadoAutofirmado(me.db, hostDeDireccion(me.direccion))
+		if err != nil {
+			return err
+		}
+		me.certificadoTLS = &certificado
+		me.huellaTLS = tipos.HuellaCertificado(certificado.Certificate[0])
+		log.Printf("TLS habilitado con certificado autofirmado (huella SHA-256: %s)", me.huellaTLS)
+	}
+	return nil
+}
+
+// hostDeDireccion extrae el host de la dirección pública del nodo ("http://host:puerto" o "host:puerto")
+func hostDeDireccion(direccion string) string {
+	if !strings.Contains(direccion, "://") {
+		direccion = "//" + direccion
+	}
+	if u, err := url.Parse(direccion); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return direccion
+}
+
+// cargarCertificadoAutofirmado carga el certificado autofirmado desde meta/tls_certificado
+// y meta/tls_clave, o genera uno nuevo para el host y lo guarda
+func cargarCertificadoAutofirmado(db *pebble.DB, host string) (tls.Certificate, error) {
+	certificadoPEM, closerCert, err := db.Get([]byte("meta/tls_certificado"))
+	if err == nil {
+		defer closerCert.Close()
+		clavePEM, closerClave, err := db.Get([]byte("meta/tls_clave"))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("error al leer clave TLS: %v", err)
+		}
+		defer closerClave.Close()
+
+		certificado, err := tls.X509KeyPair(certificadoPEM, clavePEM)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("certificado TLS inválido en la base local: %v", err)
+		}
+		return certificado, nil
+	}
+	if err != pebble.ErrNotFound {
+		return tls.Certificate{}, fmt.Errorf("error al leer certificado TLS: %v", err)
+	}
+
+	certificadoPEM, clavePEM, err := generarCertificadoAutofirmado(host)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	lote := db.NewBatch()
+	lote.Set([]byte("meta/tls_certificado"), certificadoPEM, nil)
+	lote.Set([]byte("meta/tls_clave"), clavePEM, nil)
+	if err := lote.Commit(pebble.Sync); err != nil {
+		return tls.Certificate{}, fmt.Errorf("error al guardar certificado TLS: %v", err)
+	}
+	log.Printf("Nuevo certificado TLS autofirmado generado para %s", host)
+
+	return tls.X509KeyPair(certificadoPEM, clavePEM)
+}
+
+// generarCertificadoAutofirmado genera un certificado ECDSA P-256 autofirmado para el host.
+// Retorna el certificado y la clave en formato PEM.
+func generarCertificadoAutofirmado(host string) ([]byte, []byte, error) {
+	clave, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error al generar clave TLS: %v", err)
+	}
+
+	serie, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error al generar número de serie: %v", err)
+	}
+
+	ahora := time.Now()
+	plantilla := &x509.Certificate{
+		SerialNumber:          serie,
+		Subject:               pkix.Name{CommonName: host, Organization: []string{"SensorWave"}},
+		NotBefore:             ahora.Add(-time.Hour),
+		NotAfter:              ahora.Add(validezCertificadoAutofirmado),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		plantilla.IPAddresses = []net.IP{ip}
+	} else if host != "" {
+		plantilla.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, plantilla, plantilla, &clave.PublicKey, clave)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error al generar certificado TLS: %v", err)
+	}
+	claveDER, err := x509.MarshalECPrivateKey(clave)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error al serializar clave TLS: %v", err)
+	}
+
+	certificadoPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	clavePEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: claveDER})
+	return certificadoPEM, clavePEM, nil
+}
+
+// configuracionTLS retorna la configuración TLS del servidor HTTP (nil = HTTP plano)
+func (me *ManagerEdge) configuracionTLS() *tls.Config {
+	if me.certificadoTLS == nil {
+		return nil
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*me.certificadoTLS},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// esquemaHTTP retorna el esquema con el que se accede a la API REST del nodo
+func (me *ManagerEdge) esquemaHTTP() string {
+	if me.certificadoTLS != nil {
+		return tipos.EsquemaHTTPS
+	}
+	return "http"
+}
@@ -0,0 +1,244 @@
+package edge
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ============================================================================
+// TÚNEL INVERSO HACIA EL DESPACHADOR
+// Un nodo detrás de NAT no acepta conexiones entrantes: con URLTunel abre una
+// conexión saliente persistente al despachador (ver tipos.RutaTunel) y sirve
+// sobre ella la misma API REST que el servidor HTTP, con HTTP/2 para que el
+// despachador multiplexe las consultas. Si la conexión se corta se reabre con
+// espera exponencial hasta Cerrar.
+// ============================================================================
+
+const (
+	timeoutConexionTunel = 15 * time.Second // Conexión y respuesta del Upgrade
+	esperaMinimaTunel    = time.Second      // Espera inicial entre reconexiones
+	esperaMaximaTunel    = time.Minute      // Espera máxima entre reconexiones
+
+	// Sin tráfico durante intervaloPingTunel se verifica la conexión con un PING,
+	// que se da por perdida si no hay respuesta en timeoutPingTunel
+	intervaloPingTunel = 30 * time.Second
+	timeoutPingTunel   = 15 * time.Second
+)
+
+// validarURLTunel verifica que la URL del despachador sea http o https con host
+func validarURLTunel(urlTunel string) error {
+	destino, err := url.Parse(urlTunel)
+	if err != nil {
+		return fmt.Errorf("URLTunel inválida: %v", err)
+	}
+	if destino.Scheme != "http" && destino.Scheme != "https" {
+		return fmt.Errorf("URLTunel debe usar http o https: %s", urlTunel)
+	}
+	if destino.Host == "" {
+		return fmt.Errorf("URLTunel no tiene host: %s", urlTunel)
+	}
+	return nil
+}
+
+// iniciarTunel mantiene el túnel hacia el despachador en una gorutina hasta Cerrar
+func (me *ManagerEdge) iniciarTunel(handler http.Handler) {
+	me.tunelTerminado = make(chan struct{})
+
+	// Cerrar espera las solicitudes del túnel antes de cerrar PebbleDB
+	handlerTunel := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		me.solicitudesTunel.Add(1)
+		defer me.solicitudesTunel.Done()
+		handler.ServeHTTP(w, r)
+	})
+
+	// ctx cancela la conexión en curso al cerrar el manager
+	ctx, cancelar := context.WithCancel(context.Background())
+	go func() {
+		<-me.done
+		cancelar()
+	}()
+
+	go func() {
+		defer close(me.tunelTerminado)
+
+		espera := esperaMinimaTunel
+		for {
+			conectado, err := me.servirTunel(ctx, handlerTunel)
+			select {
+			case <-me.done:
+				return
+			default:
+			}
+
+			// Tras una conexión establecida se reintenta con la espera mínima
+			if conectado {
+				espera = esperaMinimaTunel
+				log.Printf("Edge %s: túnel cerrado, reconectando en %v", me.nodoID, espera)
+			} else {
+				log.Printf("Advertencia: error conectando túnel al despachador: %v (reintento en %v)", err, espera)
+			}
+
+			select {
+			case <-me.done:
+				return
+			case <-time.After(espera):
+			}
+			espera = min(espera*2, esperaMaximaTunel)
+		}
+	}()
+
+	log.Printf("Edge %s: túnel hacia %s iniciado", me.nodoID, me.urlTunel)
+}
+
+// servirTunel abre el túnel y sirve la API REST por él hasta que se corta.
+// conectado indica si la conexión llegó a establecerse.
+func (me *ManagerEdge) servirTunel(ctx context.Context, handler http.Handler) (conectado bool, err error) {
+	conexion, err := me.conectarTunel(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// Publicar la conexión para que Cerrar pueda cortarla
+	me.muTunel.Lock()
+	select {
+	case <-me.done:
+		me.muTunel.Unlock()
+		conexion.Close()
+		return true, nil
+	default:
+	}
+	me.conexionTunel = conexion
+	me.muTunel.Unlock()
+
+	log.Printf("Edge %s: túnel establecido con %s", me.nodoID, me.urlTunel)
+	servidor := &http2.Server{
+		ReadIdleTimeout: intervaloPingTunel,
+		PingTimeout:     timeoutPingTunel,
+	}
+	servidor.ServeConn(conexion, &http2.ServeConnOpts{Handler: handler})
+
+	me.muTunel.Lock()
+	me.conexionTunel = nil
+	me.muTunel.Unlock()
+	conexion.Close()
+	return true, nil
+}
+
+// conectarTunel abre la conexión al despachador y negocia el Upgrade a tipos.ProtocoloTunel
+func (me *ManagerEdge) conectarTunel(ctx context.Context) (net.Conn, error) {
+	destino, err := url.Parse(me.urlTunel)
+	if err != nil {
+		return nil, fmt.Errorf("URLTunel inválida: %v", err)
+	}
+	direccion := destino.Host
+	if destino.Port() == "" {
+		if destino.Scheme == "https" {
+			direccion = net.JoinHostPort(destino.Hostname(), "443")
+		} else {
+			direccion = net.JoinHostPort(destino.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: timeoutConexionTunel, KeepAlive: 30 * time.Second}
+	var conexion net.Conn
+	if destino.Scheme == "https" {
+		// HTTP/1.1 explícito: el Upgrade no existe en HTTP/2
+		dialerTLS := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: destino.Hostname(),
+			NextProtos: []string{"http/1.1"},
+		}}
+		conexion, err = dialerTLS.DialContext(ctx, "tcp", direccion)
+	} else {
+		conexion, err = dialer.DialContext(ctx, "tcp", direccion)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error conectando a %s: %v", direccion, err)
+	}
+
+	lector, err := me.negociarTunel(ctx, conexion, destino)
+	if err != nil {
+		conexion.Close()
+		return nil, err
+	}
+	return tipos.ConexionConLector(conexion, lector), nil
+}
+
+// negociarTunel envía la solicitud de Upgrade firmada y espera la respuesta 101.
+// Retorna el lector con los bytes que el despachador haya enviado tras la respuesta.
+func (me *ManagerEdge) negociarTunel(ctx context.Context, conexion net.Conn, destino *url.URL) (*bufio.Reader, error) {
+	conexion.SetDeadline(time.Now().Add(timeoutConexionTunel))
+	detener := context.AfterFunc(ctx, func() { conexion.SetDeadline(time.Now()) })
+	defer detener()
+
+	req, err := http.NewRequest(http.MethodGet, destino.JoinPath(tipos.RutaTunel).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creando solicitud de túnel: %v", err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", tipos.ProtocoloTunel)
+	req.Header.Set(tipos.EncabezadoNodo, me.nodoID)
+	if me.secreto != nil {
+		if err := tipos.FirmarSolicitud(req, nil, me.nodoID, me.secreto); err != nil {
+			return nil, err
+		}
+	}
+	if err := req.Write(conexion); err != nil {
+		return nil, fmt.Errorf("error enviando solicitud de túnel: %v", err)
+	}
+
+	lector := bufio.NewReader(conexion)
+	resp, err := http.ReadResponse(lector, req)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo respuesta de túnel: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		cuerpo, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("el despachador rechazó el túnel (status %d): %s", resp.StatusCode, strings.TrimSpace(string(cuerpo)))
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), tipos.ProtocoloTunel) {
+		return nil, fmt.Errorf("el despachador respondió con un protocolo distinto: %q", resp.Header.Get("Upgrade"))
+	}
+
+	// La conexión queda abierta indefinidamente: los PING de HTTP/2 detectan cortes
+	conexion.SetDeadline(time.Time{})
+	return lector, nil
+}
+
+// cerrarTunel corta el túnel, espera su gorutina y las solicitudes en curso.
+// No hace nada si el nodo no usa túnel.
+func (me *ManagerEdge) cerrarTunel() {
+	if me.tunelTerminado == nil {
+		return
+	}
+
+	me.muTunel.Lock()
+	if me.conexionTunel != nil {
+		me.conexionTunel.Close()
+	}
+	me.muTunel.Unlock()
+
+	<-me.tunelTerminado
+	me.solicitudesTunel.Wait()
+}
+
+// TunelConectado indica si el túnel hacia el despachador está establecido
+func (me *ManagerEdge) TunelConectado() bool {
+	me.muTunel.Lock()
+	defer me.muTunel.Unlock()
+	return me.conexionTunel != nil
+}
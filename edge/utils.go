@@ -1,16 +1,15 @@
 package edge
 
 import (
-	"bufio"
+	"crypto/ed25519"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/cockroachdb/pebble"
 	"github.com/google/uuid"
 
 	"github.com/cbiale/sensorwave/tipos"
@@ -42,7 +41,7 @@ func validarPuertoHTTP(puerto string) (string, error) {
 }
 
 // obtenerIPPrincipal obtiene la dirección IP principal del nodo
-// deprecado por usar cloudflared
+// deprecado: los nodos sin dirección pública usan el túnel inverso (Opciones.URLTunel)
 func obtenerIPPrincipal() (string, error) {
 	/*	viejo usando conexión UDP para determinar IP
 
@@ -68,7 +67,7 @@ func obtenerIPPrincipal() (string, error) {
 	       return ip, nil
 	*/
 	// retornar nil y error indicando que no se usa este método
-	return "", fmt.Errorf("obtenerIPPrincipal se ha deprecado en favor de cloudflared")
+	return "", fmt.Errorf("obtenerIPPrincipal se ha deprecado en favor del túnel inverso")
 }
 
 // generarNodoID genera un ID único para el nodo edge
@@ -81,6 +80,32 @@ func generarNodoID() string {
 	return fmt.Sprintf("edge-%s-%s", hostname, UUID)
 }
 
+// cargarClaveFirma carga la clave de firma del nodo desde meta/clave_firma o genera
+// una nueva. Se guarda la semilla Ed25519 de 32 bytes.
+func cargarClaveFirma(db *pebble.DB) (ed25519.PrivateKey, error) {
+	semilla, closer, err := db.Get([]byte("meta/clave_firma"))
+	if err == nil {
+		defer closer.Close()
+		if len(semilla) != ed25519.SeedSize {
+			return nil, fmt.Errorf("clave de firma inválida en meta/clave_firma")
+		}
+		return ed25519.NewKeyFromSeed(semilla), nil
+	}
+	if err != pebble.ErrNotFound {
+		return nil, fmt.Errorf("error al leer clave de firma: %v", err)
+	}
+
+	_, clave, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error al generar clave de firma: %v", err)
+	}
+	if err := db.Set([]byte("meta/clave_firma"), clave.Seed(), pebble.Sync); err != nil {
+		return nil, fmt.Errorf("error al guardar clave de firma: %v", err)
+	}
+	log.Printf("Nueva clave de firma generada")
+	return clave, nil
+}
+
 // generarClaveDatos genera una clave PebbleDB incluyendo el tipo de datos
 func generarClaveDatos(serieId int, tiempoInicio, tiempoFin int64) []byte {
 	key := fmt.Sprintf("data/%010d/%020d_%020d", serieId, tiempoInicio, tiempoFin)
@@ -136,50 +161,6 @@ func esCompatibleConTipo(valor interface{}, tipoDatos tipos.TipoDatos) bool {
 	return tipoValor == tipoDatos
 }
 
-// iniciarTunnelCloudflared inicia un tunnel de cloudflared y retorna la URL pública
-func iniciarTunnelCloudflared(puerto string) (string, *exec.Cmd, error) {
-	if _, err := strconv.Atoi(puerto); err != nil {
-		return "", nil, fmt.Errorf("puerto inválido: %s", puerto)
-	}
-
-	cmd := exec.Command("cloudflared", "tunnel", "--url", fmt.Sprintf("http://localhost:%s", puerto))
-
-	// Captura tanto stdout como stderr (cloudflared puede usar ambos)
-	output, err := cmd.StderrPipe()
-	if err != nil {
-		return "", nil, fmt.Errorf("error al crear pipe: %v", err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		return "", nil, fmt.Errorf("error al iniciar cloudflared: %v", err)
-	}
-
-	scanner := bufio.NewScanner(output)
-	urlPattern := regexp.MustCompile(`https://[a-zA-Z0-9-]+\.trycloudflare\.com`)
-
-	// Timeout de 30 segundos para encontrar la URL
-	timeout := time.After(30 * time.Second)
-	urlChan := make(chan string, 1)
-
-	go func() {
-		for scanner.Scan() {
-			line := scanner.Text()
-			if match := urlPattern.FindString(line); match != "" {
-				urlChan <- match
-				return
-			}
-		}
-	}()
-
-	select {
-	case url := <-urlChan:
-		return url, cmd, nil // Devuelve el comando para mantenerlo vivo
-	case <-timeout:
-		cmd.Process.Kill()
-		return "", nil, fmt.Errorf("timeout esperando URL de cloudflared")
-	}
-}
-
 // matchTags verifica si una serie tiene todos los tags especificados
 func matchTags(serieTags, filterTags map[string]string) bool {
 	if len(filterTags) == 0 {
@@ -0,0 +1,251 @@
+package tipos
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ============================================================================
+// ALMACENAMIENTO EN LA NUBE
+// Los nodos edge y el despachador se coordinan mediante objetos con clave:
+// registros de nodos, latidos, comandos, bloques de datos y manifiestos. El
+// Almacenamiento abstrae dónde se guardan: un bucket S3-compatible o un
+// directorio local (o montado por NFS) para despliegues pequeños y pruebas.
+// ============================================================================
+
+var (
+	// ErrObjetoInexistente indica que la clave solicitada no existe
+	ErrObjetoInexistente = errors.New("el objeto no existe")
+
+	// ErrNoModificado indica que el objeto conserva el ETag conocido por quien lo lee
+	ErrNoModificado = errors.New("el objeto no fue modificado")
+
+	// ErrObjetoExistente indica que una escritura con SoloSiNoExiste encontró la clave ocupada
+	ErrObjetoExistente = errors.New("el objeto ya existe")
+)
+
+// ObjetoAlmacenado describe un objeto del almacenamiento
+type ObjetoAlmacenado struct {
+	Clave      string
+	Tamaño     int64
+	ETag       string // Vacío si el almacenamiento no lo informa
+	Modificado int64  // Última modificación (Unix nanosegundos, 0 si no se informa)
+}
+
+// OpcionesGuardar configura la escritura de un objeto
+type OpcionesGuardar struct {
+	TipoContenido  string // Content-Type del objeto (vacío = binario)
+	MD5            []byte // MD5 del contenido: la escritura falla si no coincide con lo recibido (nil = sin verificar)
+	SoloSiNoExiste bool   // No reemplaza un objeto existente: retorna ErrObjetoExistente
+}
+
+// Almacenamiento define las operaciones sobre objetos que usan el edge y el despachador.
+// Las claves usan "/" como separador; los listados retornan las claves en orden
+// lexicográfico como ListObjectsV2.
+type Almacenamiento interface {
+	// Preparar verifica que el almacenamiento sea accesible, creándolo si no existe
+	Preparar(ctx context.Context) error
+
+	// Guardar escribe el objeto reemplazando el anterior (salvo con opts.SoloSiNoExiste)
+	// y retorna su ETag
+	Guardar(ctx context.Context, clave string, datos []byte, opts OpcionesGuardar) (string, error)
+
+	// Leer retorna el contenido y el ETag del objeto. Si etagConocido no es vacío y el
+	// objeto conserva ese ETag retorna ErrNoModificado sin el contenido.
+	// Retorna ErrObjetoInexistente si la clave no existe.
+	Leer(ctx context.Context, clave string, etagConocido string) ([]byte, string, error)
+
+	// Recorrer invoca fn en orden para cada objeto con el prefijo dado cuya clave es
+	// posterior a desde (vacío = desde el inicio), hasta que fn retorna false
+	Recorrer(ctx context.Context, prefijo, desde string, fn func(ObjetoAlmacenado) bool) error
+
+	// Eliminar borra el objeto. Eliminar una clave inexistente no es un error.
+	Eliminar(ctx context.Context, clave string) error
+}
+
+// CrearAlmacenamiento crea el almacenamiento de la configuración: el directorio local
+// si se indica Directorio, o el bucket S3 en caso contrario
+func CrearAlmacenamiento(cfg ConfiguracionS3) (Almacenamiento, error) {
+	if cfg.Directorio != "" {
+		return NuevoAlmacenamientoLocal(cfg.Directorio)
+	}
+	cliente, err := CrearClienteS3(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NuevoAlmacenamientoS3(cliente, cfg.Bucket), nil
+}
+
+// ListarObjetos lista todos los objetos con el prefijo dado
+func ListarObjetos(ctx context.Context, almacenamiento Almacenamiento, prefijo string) ([]ObjetoAlmacenado, error) {
+	var objetos []ObjetoAlmacenado
+	err := almacenamiento.Recorrer(ctx, prefijo, "", func(obj ObjetoAlmacenado) bool {
+		objetos = append(objetos, obj)
+		return true
+	})
+	return objetos, err
+}
+
+// ============================================================================
+// ALMACENAMIENTO S3
+// ============================================================================
+
+// AlmacenamientoS3 guarda los objetos en un bucket S3-compatible
+type AlmacenamientoS3 struct {
+	cliente ClienteS3
+	bucket  string
+}
+
+// NuevoAlmacenamientoS3 crea un almacenamiento sobre el bucket indicado
+func NuevoAlmacenamientoS3(cliente ClienteS3, bucket string) *AlmacenamientoS3 {
+	return &AlmacenamientoS3{cliente: cliente, bucket: bucket}
+}
+
+// Cliente retorna el cliente S3 subyacente
+func (a *AlmacenamientoS3) Cliente() ClienteS3 {
+	return a.cliente
+}
+
+// Bucket retorna el bucket de los objetos
+func (a *AlmacenamientoS3) Bucket() string {
+	return a.bucket
+}
+
+// Preparar verifica que el bucket exista y, si no, intenta crearlo
+func (a *AlmacenamientoS3) Preparar(ctx context.Context) error {
+	_, err := a.cliente.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(a.bucket),
+	})
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("El bucket %s no existe, intentando crearlo...", a.bucket)
+	_, err = a.cliente.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(a.bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("error al crear bucket: %w", err)
+	}
+	log.Printf("Bucket %s creado exitosamente", a.bucket)
+	return nil
+}
+
+// Guardar sube el objeto al bucket
+func (a *AlmacenamientoS3) Guardar(ctx context.Context, clave string, datos []byte, opts OpcionesGuardar) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(clave),
+		Body:   bytes.NewReader(datos),
+	}
+	if opts.TipoContenido != "" {
+		input.ContentType = aws.String(opts.TipoContenido)
+	}
+	if opts.MD5 != nil {
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(opts.MD5))
+	}
+	if opts.SoloSiNoExiste {
+		input.IfNoneMatch = aws.String("*")
+	}
+
+	salida, err := a.cliente.PutObject(ctx, input)
+	if err != nil {
+		if opts.SoloSiNoExiste && estadoHTTPS3(err) == http.StatusPreconditionFailed {
+			return "", fmt.Errorf("%w: %s", ErrObjetoExistente, clave)
+		}
+		return "", err
+	}
+	if salida == nil {
+		return "", nil
+	}
+	return aws.ToString(salida.ETag), nil
+}
+
+// Leer descarga el objeto del bucket. Con etagConocido la descarga es condicional
+// (If-None-Match) y S3 responde 304 sin contenido si el objeto no cambió.
+func (a *AlmacenamientoS3) Leer(ctx context.Context, clave string, etagConocido string) ([]byte, string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(clave),
+	}
+	if etagConocido != "" {
+		input.IfNoneMatch = aws.String(etagConocido)
+	}
+
+	salida, err := a.cliente.GetObject(ctx, input)
+	if err != nil {
+		if etagConocido != "" && estadoHTTPS3(err) == http.StatusNotModified {
+			return nil, "", ErrNoModificado
+		}
+		var noExiste *s3types.NoSuchKey
+		if errors.As(err, &noExiste) {
+			return nil, "", fmt.Errorf("%w: %s", ErrObjetoInexistente, clave)
+		}
+		return nil, "", err
+	}
+	if salida == nil || salida.Body == nil {
+		return nil, "", fmt.Errorf("objeto %s sin contenido", clave)
+	}
+	defer salida.Body.Close()
+
+	datos, err := io.ReadAll(salida.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return datos, aws.ToString(salida.ETag), nil
+}
+
+// Recorrer lista los objetos del bucket página por página
+func (a *AlmacenamientoS3) Recorrer(ctx context.Context, prefijo, desde string, fn func(ObjetoAlmacenado) bool) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(a.bucket),
+		Prefix: aws.String(prefijo),
+	}
+	if desde != "" {
+		input.StartAfter = aws.String(desde)
+	}
+	return RecorrerObjetosS3(ctx, a.cliente, input, func(obj s3types.Object) bool {
+		if obj.Key == nil {
+			return true
+		}
+		objeto := ObjetoAlmacenado{
+			Clave:  *obj.Key,
+			Tamaño: aws.ToInt64(obj.Size),
+			ETag:   aws.ToString(obj.ETag),
+		}
+		if obj.LastModified != nil {
+			objeto.Modificado = obj.LastModified.UnixNano()
+		}
+		return fn(objeto)
+	})
+}
+
+// Eliminar borra el objeto del bucket (S3 no informa error si no existe)
+func (a *AlmacenamientoS3) Eliminar(ctx context.Context, clave string) error {
+	_, err := a.cliente.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(a.bucket),
+		Key:    aws.String(clave),
+	})
+	return err
+}
+
+// estadoHTTPS3 retorna el código HTTP de la respuesta de S3 que produjo el error
+// (0 si no proviene de una respuesta). Los errores de respuesta del SDK lo exponen
+// mediante HTTPStatusCode; así se detectan 304 Not Modified y 412 Precondition Failed.
+func estadoHTTPS3(err error) int {
+	var errRespuesta interface{ HTTPStatusCode() int }
+	if errors.As(err, &errRespuesta) {
+		return errRespuesta.HTTPStatusCode()
+	}
+	return 0
+}
@@ -0,0 +1,251 @@
+package tipos
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// ALMACENAMIENTO EN DIRECTORIO LOCAL
+// Cada objeto es un archivo bajo el directorio raíz y cada segmento de la clave
+// un subdirectorio. Varios procesos pueden compartir el directorio (por ejemplo
+// montado por NFS): las escrituras usan un archivo temporal y un rename, por lo
+// que los lectores ven el objeto anterior o el nuevo, nunca uno a medio escribir.
+// ============================================================================
+
+// AlmacenamientoLocal guarda los objetos como archivos de un directorio
+type AlmacenamientoLocal struct {
+	raiz string
+}
+
+// NuevoAlmacenamientoLocal crea un almacenamiento sobre el directorio indicado
+func NuevoAlmacenamientoLocal(directorio string) (*AlmacenamientoLocal, error) {
+	if directorio == "" {
+		return nil, fmt.Errorf("Directorio es requerido")
+	}
+	raiz, err := filepath.Abs(directorio)
+	if err != nil {
+		return nil, fmt.Errorf("directorio inválido %s: %v", directorio, err)
+	}
+	return &AlmacenamientoLocal{raiz: raiz}, nil
+}
+
+// Directorio retorna el directorio raíz de los objetos
+func (a *AlmacenamientoLocal) Directorio() string {
+	return a.raiz
+}
+
+// Preparar crea el directorio raíz si no existe
+func (a *AlmacenamientoLocal) Preparar(ctx context.Context) error {
+	if err := os.MkdirAll(a.raiz, 0755); err != nil {
+		return fmt.Errorf("error creando directorio %s: %v", a.raiz, err)
+	}
+	return nil
+}
+
+// Guardar escribe el objeto en un archivo temporal y lo renombra a su clave. Con
+// opts.SoloSiNoExiste usa un enlace en lugar del rename, que falla si la clave existe.
+func (a *AlmacenamientoLocal) Guardar(ctx context.Context, clave string, datos []byte, opts OpcionesGuardar) (string, error) {
+	ruta, err := a.ruta(clave)
+	if err != nil {
+		return "", err
+	}
+	if opts.MD5 != nil {
+		if suma := md5.Sum(datos); !bytes.Equal(suma[:], opts.MD5) {
+			return "", fmt.Errorf("el MD5 de %s no coincide con el contenido", clave)
+		}
+	}
+	directorio := filepath.Dir(ruta)
+	if err := os.MkdirAll(directorio, 0755); err != nil {
+		return "", fmt.Errorf("error creando directorio de %s: %v", clave, err)
+	}
+
+	// El prefijo "." excluye el temporal de los listados (ver validarClaveLocal)
+	temporal, err := os.CreateTemp(directorio, "."+filepath.Base(ruta)+".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("error creando archivo de %s: %v", clave, err)
+	}
+	defer os.Remove(temporal.Name()) // No existe tras el rename
+
+	if _, err := temporal.Write(datos); err != nil {
+		temporal.Close()
+		return "", fmt.Errorf("error escribiendo %s: %v", clave, err)
+	}
+	if err := temporal.Sync(); err != nil {
+		temporal.Close()
+		return "", fmt.Errorf("error escribiendo %s: %v", clave, err)
+	}
+	if err := temporal.Close(); err != nil {
+		return "", fmt.Errorf("error escribiendo %s: %v", clave, err)
+	}
+	if err := os.Chmod(temporal.Name(), 0644); err != nil {
+		return "", fmt.Errorf("error escribiendo %s: %v", clave, err)
+	}
+	if opts.SoloSiNoExiste {
+		// El enlace es atómico y falla si la clave existe; el temporal se elimina al salir
+		if err := os.Link(temporal.Name(), ruta); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return "", fmt.Errorf("%w: %s", ErrObjetoExistente, clave)
+			}
+			return "", fmt.Errorf("error guardando %s: %v", clave, err)
+		}
+		return etagLocal(datos), nil
+	}
+	if err := os.Rename(temporal.Name(), ruta); err != nil {
+		return "", fmt.Errorf("error guardando %s: %v", clave, err)
+	}
+	return etagLocal(datos), nil
+}
+
+// Leer retorna el contenido del archivo del objeto
+func (a *AlmacenamientoLocal) Leer(ctx context.Context, clave string, etagConocido string) ([]byte, string, error) {
+	ruta, err := a.ruta(clave)
+	if err != nil {
+		return nil, "", err
+	}
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, "", fmt.Errorf("%w: %s", ErrObjetoInexistente, clave)
+		}
+		return nil, "", fmt.Errorf("error leyendo %s: %v", clave, err)
+	}
+
+	etag := etagLocal(datos)
+	if etagConocido != "" && etagConocido == etag {
+		return nil, "", ErrNoModificado
+	}
+	return datos, etag, nil
+}
+
+// Recorrer lista los archivos bajo el directorio del prefijo. El ETag de los objetos
+// listados queda vacío (calcularlo requiere leer cada archivo): los cambios se detectan
+// por la fecha de modificación y el tamaño.
+func (a *AlmacenamientoLocal) Recorrer(ctx context.Context, prefijo, desde string, fn func(ObjetoAlmacenado) bool) error {
+	// Solo se recorre el subdirectorio que contiene todas las claves con el prefijo
+	base := a.raiz
+	if i := strings.LastIndex(prefijo, "/"); i >= 0 {
+		base = filepath.Join(a.raiz, filepath.FromSlash(prefijo[:i]))
+	}
+
+	var objetos []ObjetoAlmacenado
+	err := filepath.WalkDir(base, func(ruta string, entrada fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // Prefijo sin objetos, o un archivo eliminado durante el recorrido
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if ruta == base {
+			return nil
+		}
+		if strings.HasPrefix(entrada.Name(), ".") {
+			if entrada.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil // Temporales de escrituras en curso
+		}
+
+		relativa, err := filepath.Rel(a.raiz, ruta)
+		if err != nil {
+			return err
+		}
+		clave := filepath.ToSlash(relativa)
+		if entrada.IsDir() {
+			// Un directorio con claves posibles es prefijo del prefijo buscado o lo contiene
+			if !strings.HasPrefix(clave+"/", prefijo) && !strings.HasPrefix(prefijo, clave+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entrada.Type().IsRegular() || !strings.HasPrefix(clave, prefijo) || clave <= desde {
+			return nil
+		}
+
+		info, err := entrada.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		objetos = append(objetos, ObjetoAlmacenado{Clave: clave, Tamaño: info.Size(), Modificado: info.ModTime().UnixNano()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listando %s: %v", prefijo, err)
+	}
+
+	// El orden de WalkDir es por directorio; las claves se ordenan completas como en S3
+	sort.Slice(objetos, func(i, j int) bool {
+		return objetos[i].Clave < objetos[j].Clave
+	})
+	for _, obj := range objetos {
+		if !fn(obj) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Eliminar borra el archivo del objeto y los directorios que quedan vacíos
+func (a *AlmacenamientoLocal) Eliminar(ctx context.Context, clave string) error {
+	ruta, err := a.ruta(clave)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(ruta); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error eliminando %s: %v", clave, err)
+	}
+
+	// Los directorios vacíos no se listan; eliminarlos evita acumularlos (por
+	// ejemplo, las particiones diarias). Remove falla si otro proceso escribió en ellos.
+	for directorio := filepath.Dir(ruta); directorio != a.raiz; directorio = filepath.Dir(directorio) {
+		if os.Remove(directorio) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// ruta retorna el archivo del objeto con la clave dada
+func (a *AlmacenamientoLocal) ruta(clave string) (string, error) {
+	if err := validarClaveLocal(clave); err != nil {
+		return "", err
+	}
+	return filepath.Join(a.raiz, filepath.FromSlash(clave)), nil
+}
+
+// validarClaveLocal verifica que la clave corresponda a un archivo dentro del directorio
+// raíz. Los segmentos que comienzan con "." están reservados para los temporales.
+func validarClaveLocal(clave string) error {
+	if clave == "" || path.IsAbs(clave) || strings.Contains(clave, "\\") {
+		return fmt.Errorf("clave inválida para almacenamiento local: %q", clave)
+	}
+	for _, segmento := range strings.Split(clave, "/") {
+		if segmento == "" || strings.HasPrefix(segmento, ".") {
+			return fmt.Errorf("clave inválida para almacenamiento local: %q", clave)
+		}
+	}
+	return nil
+}
+
+// etagLocal calcula el ETag de un objeto como S3 para objetos subidos en una sola
+// parte: el MD5 del contenido en hexadecimal y entre comillas
+func etagLocal(datos []byte) string {
+	suma := md5.Sum(datos)
+	return `"` + hex.EncodeToString(suma[:]) + `"`
+}
@@ -0,0 +1,94 @@
+package tipos_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/cbiale/sensorwave/tipos"
+	"github.com/cbiale/sensorwave/tipos/s3fake"
+)
+
+// Los tests contra S3 usan el paquete externo tipos_test para importar s3fake,
+// que a su vez importa tipos.
+
+// bucketTest es el bucket que se crea en el cliente S3 en memoria
+const bucketTest = "bucket"
+
+// nuevoS3FakeTest crea un cliente S3 en memoria con el bucket de prueba y el almacenamiento sobre él
+func nuevoS3FakeTest(tamañoPagina int) (*s3fake.Cliente, *tipos.AlmacenamientoS3) {
+	cliente := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{bucketTest}, TamañoPagina: tamañoPagina})
+	return cliente, tipos.NuevoAlmacenamientoS3(cliente, bucketTest)
+}
+
+// sembrarObjetoTest guarda un objeto directamente en el bucket de prueba
+func sembrarObjetoTest(t *testing.T, cliente *s3fake.Cliente, clave, datos string) {
+	t.Helper()
+	_, err := cliente.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucketTest),
+		Key:    aws.String(clave),
+		Body:   bytes.NewReader([]byte(datos)),
+	})
+	if err != nil {
+		t.Fatalf("Error guardando %s: %v", clave, err)
+	}
+}
+
+// ==================== Tests de ListarObjetosS3 ====================
+
+// TestListarObjetosS3_Paginacion verifica que se siguen los tokens de continuación
+func TestListarObjetosS3_Paginacion(t *testing.T) {
+	cliente, _ := nuevoS3FakeTest(2)
+	for i := 0; i < 5; i++ {
+		sembrarObjetoTest(t, cliente, fmt.Sprintf("nodos/nodo-%d.json", i), "{}")
+	}
+	sembrarObjetoTest(t, cliente, "otro/objeto", "{}")
+
+	objetos, err := tipos.ListarObjetosS3(context.Background(), cliente, bucketTest, "nodos/")
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if len(objetos) != 5 {
+		t.Errorf("Esperados 5 objetos, obtenidos %d", len(objetos))
+	}
+	if llamadas := cliente.Llamadas(s3fake.OperacionListObjectsV2); llamadas != 3 {
+		t.Errorf("Esperadas 3 páginas, obtenidas %d", llamadas)
+	}
+	t.Log("✓ ListarObjetosS3 recorre todas las páginas")
+}
+
+// ==================== Tests de AlmacenamientoS3 ====================
+
+// TestAlmacenamientoS3_LeerYRecorrer verifica la traducción de errores y el inicio del listado
+func TestAlmacenamientoS3_LeerYRecorrer(t *testing.T) {
+	ctx := context.Background()
+	_, almacenamiento := nuevoS3FakeTest(2)
+
+	for _, clave := range []string{"a", "b", "c", "d"} {
+		if _, err := almacenamiento.Guardar(ctx, clave, []byte(clave), tipos.OpcionesGuardar{}); err != nil {
+			t.Fatalf("Error guardando %s: %v", clave, err)
+		}
+	}
+
+	if _, _, err := almacenamiento.Leer(ctx, "z", ""); !errors.Is(err, tipos.ErrObjetoInexistente) {
+		t.Errorf("Se esperaba ErrObjetoInexistente, obtenido %v", err)
+	}
+
+	var vistas []string
+	err := almacenamiento.Recorrer(ctx, "", "a", func(obj tipos.ObjetoAlmacenado) bool {
+		vistas = append(vistas, obj.Clave)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Error recorriendo: %v", err)
+	}
+	if len(vistas) != 3 || vistas[0] != "b" || vistas[2] != "d" {
+		t.Errorf("Recorrido incorrecto: %v", vistas)
+	}
+	t.Log("✓ AlmacenamientoS3 traduce NoSuchKey y continúa el listado desde una clave")
+}
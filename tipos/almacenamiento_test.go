@@ -0,0 +1,248 @@
+package tipos
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ==================== Tests de AlmacenamientoLocal ====================
+
+// nuevoAlmacenamientoLocalTest crea un almacenamiento local preparado en un directorio temporal
+func nuevoAlmacenamientoLocalTest(t *testing.T) *AlmacenamientoLocal {
+	t.Helper()
+	almacenamiento, err := NuevoAlmacenamientoLocal(filepath.Join(t.TempDir(), "objetos"))
+	if err != nil {
+		t.Fatalf("Error creando almacenamiento: %v", err)
+	}
+	if err := almacenamiento.Preparar(context.Background()); err != nil {
+		t.Fatalf("Error preparando almacenamiento: %v", err)
+	}
+	return almacenamiento
+}
+
+// clavesListadas retorna las claves de los objetos listados en orden
+func clavesListadas(objetos []ObjetoAlmacenado) []string {
+	claves := make([]string, 0, len(objetos))
+	for _, obj := range objetos {
+		claves = append(claves, obj.Clave)
+	}
+	return claves
+}
+
+// TestAlmacenamientoLocal_GuardarLeer verifica el contenido, el ETag y la lectura condicional
+func TestAlmacenamientoLocal_GuardarLeer(t *testing.T) {
+	ctx := context.Background()
+	almacenamiento := nuevoAlmacenamientoLocalTest(t)
+
+	etag, err := almacenamiento.Guardar(ctx, "nodos/nodo-1.json", []byte(`{"a":1}`), OpcionesGuardar{TipoContenido: "application/json"})
+	if err != nil {
+		t.Fatalf("Error guardando: %v", err)
+	}
+	if etag == "" {
+		t.Error("Guardar debe retornar el ETag")
+	}
+
+	datos, etagLeido, err := almacenamiento.Leer(ctx, "nodos/nodo-1.json", "")
+	if err != nil {
+		t.Fatalf("Error leyendo: %v", err)
+	}
+	if string(datos) != `{"a":1}` || etagLeido != etag {
+		t.Errorf("Lectura incorrecta: %q (ETag %s, esperado %s)", datos, etagLeido, etag)
+	}
+
+	// Con el ETag conocido no se retorna el contenido
+	if _, _, err := almacenamiento.Leer(ctx, "nodos/nodo-1.json", etag); !errors.Is(err, ErrNoModificado) {
+		t.Errorf("Se esperaba ErrNoModificado, obtenido %v", err)
+	}
+
+	// Al reemplazar el objeto cambia el ETag
+	etagNuevo, err := almacenamiento.Guardar(ctx, "nodos/nodo-1.json", []byte(`{"a":2}`), OpcionesGuardar{})
+	if err != nil {
+		t.Fatalf("Error reemplazando: %v", err)
+	}
+	datos, _, err = almacenamiento.Leer(ctx, "nodos/nodo-1.json", etag)
+	if err != nil || string(datos) != `{"a":2}` || etagNuevo == etag {
+		t.Errorf("Reemplazo incorrecto: %q, %v", datos, err)
+	}
+
+	// Clave inexistente
+	_, _, err = almacenamiento.Leer(ctx, "nodos/otro.json", "")
+	if !errors.Is(err, ErrObjetoInexistente) || !EsObjetoInexistente(err) {
+		t.Errorf("Se esperaba ErrObjetoInexistente, obtenido %v", err)
+	}
+	t.Log("✓ AlmacenamientoLocal guarda, lee y detecta objetos sin cambios")
+}
+
+// TestAlmacenamientoLocal_EscrituraCondicional verifica SoloSiNoExiste y la verificación del MD5
+func TestAlmacenamientoLocal_EscrituraCondicional(t *testing.T) {
+	ctx := context.Background()
+	almacenamiento := nuevoAlmacenamientoLocalTest(t)
+	suma := md5.Sum([]byte("v1"))
+
+	etag, err := almacenamiento.Guardar(ctx, "n/bloque", []byte("v1"), OpcionesGuardar{MD5: suma[:], SoloSiNoExiste: true})
+	if err != nil {
+		t.Fatalf("Error guardando: %v", err)
+	}
+	if etag != `"`+hex.EncodeToString(suma[:])+`"` {
+		t.Errorf("El ETag debe ser el MD5 del contenido: %s", etag)
+	}
+
+	// La clave ocupada no se reemplaza
+	_, err = almacenamiento.Guardar(ctx, "n/bloque", []byte("v2"), OpcionesGuardar{SoloSiNoExiste: true})
+	if !errors.Is(err, ErrObjetoExistente) {
+		t.Errorf("Se esperaba ErrObjetoExistente, obtenido %v", err)
+	}
+
+	// Contenido que no coincide con el MD5 declarado
+	if _, err := almacenamiento.Guardar(ctx, "n/otro", []byte("corrupto"), OpcionesGuardar{MD5: suma[:]}); err == nil {
+		t.Error("Se esperaba error con MD5 incorrecto")
+	}
+
+	if datos, _, _ := almacenamiento.Leer(ctx, "n/bloque", ""); string(datos) != "v1" {
+		t.Errorf("El objeto no debe modificarse: %q", datos)
+	}
+	if objetos, _ := ListarObjetos(ctx, almacenamiento, ""); len(objetos) != 1 {
+		t.Errorf("Las escrituras rechazadas no deben dejar objetos ni temporales: %+v", objetos)
+	}
+	t.Log("✓ AlmacenamientoLocal no sobrescribe con SoloSiNoExiste y verifica el MD5")
+}
+
+// TestAlmacenamientoLocal_Recorrer verifica orden, prefijo, desde y la omisión de temporales
+func TestAlmacenamientoLocal_Recorrer(t *testing.T) {
+	ctx := context.Background()
+	almacenamiento := nuevoAlmacenamientoLocalTest(t)
+
+	// "d/a-b" precede a "d/a/x" en orden de claves aunque WalkDir visite antes "a"
+	for _, clave := range []string{"d/a/x", "d/a-b", "d/c", "nodos/nodo-1.json", "nodos/nodo-10.json", "nodos/nodo-2.json"} {
+		if _, err := almacenamiento.Guardar(ctx, clave, []byte(clave), OpcionesGuardar{}); err != nil {
+			t.Fatalf("Error guardando %s: %v", clave, err)
+		}
+	}
+	// Un temporal de una escritura en curso no es un objeto
+	temporal := filepath.Join(almacenamiento.Directorio(), "d", ".c.tmp-123")
+	if err := os.WriteFile(temporal, []byte("parcial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	objetos, err := ListarObjetos(ctx, almacenamiento, "d/")
+	if err != nil {
+		t.Fatalf("Error listando: %v", err)
+	}
+	if claves := clavesListadas(objetos); len(claves) != 3 || claves[0] != "d/a-b" || claves[1] != "d/a/x" || claves[2] != "d/c" {
+		t.Errorf("Listado incorrecto: %v", claves)
+	}
+	if objetos[2].Tamaño != 3 || objetos[2].Modificado == 0 {
+		t.Errorf("Metadatos incorrectos: %+v", objetos[2])
+	}
+
+	// Prefijo que no termina en "/"
+	objetos, err = ListarObjetos(ctx, almacenamiento, "nodos/nodo-1")
+	if err != nil {
+		t.Fatalf("Error listando: %v", err)
+	}
+	if claves := clavesListadas(objetos); len(claves) != 2 || claves[0] != "nodos/nodo-1.json" || claves[1] != "nodos/nodo-10.json" {
+		t.Errorf("Listado por prefijo incorrecto: %v", claves)
+	}
+
+	// Continuar desde una clave y detener el recorrido
+	var vistas []string
+	err = almacenamiento.Recorrer(ctx, "", "d/c", func(obj ObjetoAlmacenado) bool {
+		vistas = append(vistas, obj.Clave)
+		return len(vistas) < 2
+	})
+	if err != nil {
+		t.Fatalf("Error recorriendo: %v", err)
+	}
+	if len(vistas) != 2 || vistas[0] != "nodos/nodo-1.json" || vistas[1] != "nodos/nodo-10.json" {
+		t.Errorf("Recorrido incorrecto: %v", vistas)
+	}
+
+	// Prefijo sin objetos
+	objetos, err = ListarObjetos(ctx, almacenamiento, "inexistente/")
+	if err != nil || len(objetos) != 0 {
+		t.Errorf("Se esperaba un listado vacío: %v, %v", objetos, err)
+	}
+	t.Log("✓ AlmacenamientoLocal lista en orden de claves como S3")
+}
+
+// TestAlmacenamientoLocal_Eliminar verifica que se eliminan el objeto y los directorios vacíos
+func TestAlmacenamientoLocal_Eliminar(t *testing.T) {
+	ctx := context.Background()
+	almacenamiento := nuevoAlmacenamientoLocalTest(t)
+
+	for _, clave := range []string{"n/2024/03/bloque", "n/otro"} {
+		if _, err := almacenamiento.Guardar(ctx, clave, []byte("x"), OpcionesGuardar{}); err != nil {
+			t.Fatalf("Error guardando %s: %v", clave, err)
+		}
+	}
+
+	if err := almacenamiento.Eliminar(ctx, "n/2024/03/bloque"); err != nil {
+		t.Fatalf("Error eliminando: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(almacenamiento.Directorio(), "n", "2024")); !os.IsNotExist(err) {
+		t.Error("Los directorios vacíos deben eliminarse")
+	}
+	if _, _, err := almacenamiento.Leer(ctx, "n/otro", ""); err != nil {
+		t.Errorf("El resto de los objetos debe conservarse: %v", err)
+	}
+
+	// Eliminar una clave inexistente no es un error
+	if err := almacenamiento.Eliminar(ctx, "n/2024/03/bloque"); err != nil {
+		t.Errorf("No se esperaba error: %v", err)
+	}
+
+	// La raíz se conserva aunque quede vacía
+	if err := almacenamiento.Eliminar(ctx, "n/otro"); err != nil {
+		t.Fatalf("Error eliminando: %v", err)
+	}
+	if _, err := os.Stat(almacenamiento.Directorio()); err != nil {
+		t.Errorf("El directorio raíz debe conservarse: %v", err)
+	}
+	t.Log("✓ AlmacenamientoLocal elimina objetos y directorios vacíos")
+}
+
+// TestAlmacenamientoLocal_ClavesInvalidas verifica que las claves no escapan del directorio raíz
+func TestAlmacenamientoLocal_ClavesInvalidas(t *testing.T) {
+	ctx := context.Background()
+	almacenamiento := nuevoAlmacenamientoLocalTest(t)
+
+	casos := []string{"", "/absoluta", "../fuera", "a/../../fuera", "a//b", "a/", `a\b`, "a/.oculto"}
+	for _, clave := range casos {
+		if _, err := almacenamiento.Guardar(ctx, clave, []byte("x"), OpcionesGuardar{}); err == nil {
+			t.Errorf("Se esperaba error para clave %q", clave)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(almacenamiento.Directorio()), "fuera")); !os.IsNotExist(err) {
+		t.Error("No debe escribirse fuera del directorio raíz")
+	}
+	t.Log("✓ AlmacenamientoLocal rechaza claves inválidas")
+}
+
+// ==================== Tests de CrearAlmacenamiento ====================
+
+// TestCrearAlmacenamiento_Directorio verifica la validación y creación del almacenamiento local
+func TestCrearAlmacenamiento_Directorio(t *testing.T) {
+	cfg := ConfiguracionS3{Directorio: t.TempDir()}
+	if err := cfg.Validar(); err != nil {
+		t.Errorf("Con Directorio no se requieren endpoint ni credenciales: %v", err)
+	}
+
+	cfg.DisposicionClaves = "jerarquica"
+	if err := cfg.Validar(); err == nil {
+		t.Error("Se esperaba error con disposición desconocida")
+	}
+
+	almacenamiento, err := CrearAlmacenamiento(ConfiguracionS3{Directorio: cfg.Directorio})
+	if err != nil {
+		t.Fatalf("Error creando almacenamiento: %v", err)
+	}
+	if _, ok := almacenamiento.(*AlmacenamientoLocal); !ok {
+		t.Errorf("Se esperaba AlmacenamientoLocal, obtenido %T", almacenamiento)
+	}
+	t.Log("✓ CrearAlmacenamiento usa el directorio local si se configura")
+}
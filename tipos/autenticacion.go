@@ -0,0 +1,173 @@
+package tipos
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// AUTENTICACIÓN DE SOLICITUDES DESPACHADOR → EDGE
+// El despachador firma cada solicitud con HMAC-SHA256 usando un secreto
+// compartido con el nodo. La firma cubre el método, la ruta con su query, el
+// Content-Type, el nodo destino, el momento, un nonce y el hash del cuerpo.
+// El edge rechaza solicitudes fuera
+// de la ventana de tolerancia y nonces repetidos (protección contra repetición).
+// ============================================================================
+
+const (
+	// EsquemaAutorizacion es el esquema del encabezado Authorization
+	EsquemaAutorizacion = "SW-HMAC-SHA256"
+	// EncabezadoMomento lleva el momento de la solicitud (Unix nanosegundos)
+	EncabezadoMomento = "X-Sensorwave-Momento"
+	// EncabezadoNonce lleva un valor aleatorio único por solicitud
+	EncabezadoNonce = "X-Sensorwave-Nonce"
+
+	// ToleranciaMomentoPorDefecto es la diferencia máxima de reloj aceptada por defecto
+	ToleranciaMomentoPorDefecto = 5 * time.Minute
+
+	// Intervalo mínimo entre limpiezas de nonces vencidos
+	intervaloLimpiezaNonces = time.Minute
+)
+
+// ErrSolicitudNoAutenticada indica que la solicitud no tiene una firma válida
+var ErrSolicitudNoAutenticada = errors.New("solicitud no autenticada")
+
+// contenidoFirmaSolicitud construye el texto firmado de una solicitud
+func contenidoFirmaSolicitud(req *http.Request, nodoID string, momento int64, nonce string, cuerpo []byte) []byte {
+	hashCuerpo := sha256.Sum256(cuerpo)
+	return []byte(strings.Join([]string{
+		req.Method,
+		req.URL.Path,
+		req.URL.RawQuery,
+		req.Header.Get("Content-Type"),
+		nodoID,
+		strconv.FormatInt(momento, 10),
+		nonce,
+		hex.EncodeToString(hashCuerpo[:]),
+	}, "\n"))
+}
+
+// calcularFirmaSolicitud calcula el HMAC-SHA256 del contenido con el secreto
+func calcularFirmaSolicitud(secreto, contenido []byte) []byte {
+	mac := hmac.New(sha256.New, secreto)
+	mac.Write(contenido)
+	return mac.Sum(nil)
+}
+
+// FirmarSolicitud agrega a la solicitud los encabezados de autenticación para el nodo destino.
+// cuerpo debe ser exactamente el cuerpo que se envía, y el Content-Type (que se firma)
+// debe estar establecido antes de firmar.
+func FirmarSolicitud(req *http.Request, cuerpo []byte, nodoID string, secreto []byte) error {
+	momento, nonce, firma, err := nuevaFirmaSolicitud(req, cuerpo, nodoID, secreto)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(EncabezadoMomento, strconv.FormatInt(momento, 10))
+	req.Header.Set(EncabezadoNonce, nonce)
+	req.Header.Set("Authorization", EsquemaAutorizacion+" "+firma)
+	return nil
+}
+
+// nuevaFirmaSolicitud firma la solicitud con el momento actual y un nonce nuevo.
+// Retorna el momento, el nonce y la firma en base64.
+func nuevaFirmaSolicitud(req *http.Request, cuerpo []byte, nodoID string, secreto []byte) (int64, string, string, error) {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return 0, "", "", fmt.Errorf("error generando nonce: %v", err)
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	momento := time.Now().UnixNano()
+
+	contenido := contenidoFirmaSolicitud(req, nodoID, momento, nonce, cuerpo)
+	firma := base64.StdEncoding.EncodeToString(calcularFirmaSolicitud(secreto, contenido))
+	return momento, nonce, firma, nil
+}
+
+// VerificadorSolicitudes verifica las solicitudes firmadas dirigidas a un nodo
+type VerificadorSolicitudes struct {
+	nodoID     string
+	secreto    []byte
+	tolerancia time.Duration
+
+	mu             sync.Mutex
+	nonces         map[string]time.Time // Nonce → vencimiento
+	ultimaLimpieza time.Time
+}
+
+// NuevoVerificadorSolicitudes crea un verificador para el nodo con el secreto compartido.
+// tolerancia <= 0 usa ToleranciaMomentoPorDefecto.
+func NuevoVerificadorSolicitudes(nodoID string, secreto []byte, tolerancia time.Duration) *VerificadorSolicitudes {
+	if tolerancia <= 0 {
+		tolerancia = ToleranciaMomentoPorDefecto
+	}
+	return &VerificadorSolicitudes{
+		nodoID:     nodoID,
+		secreto:    secreto,
+		tolerancia: tolerancia,
+		nonces:     make(map[string]time.Time),
+	}
+}
+
+// Verificar comprueba la firma, el momento y el nonce de una solicitud con el cuerpo leído.
+// Los errores envuelven ErrSolicitudNoAutenticada.
+func (v *VerificadorSolicitudes) Verificar(r *http.Request, cuerpo []byte) error {
+	firma, ok := strings.CutPrefix(r.Header.Get("Authorization"), EsquemaAutorizacion+" ")
+	if !ok {
+		return fmt.Errorf("%w: falta la firma %s", ErrSolicitudNoAutenticada, EsquemaAutorizacion)
+	}
+	firmaBytes, err := base64.StdEncoding.DecodeString(firma)
+	if err != nil {
+		return fmt.Errorf("%w: firma mal formada", ErrSolicitudNoAutenticada)
+	}
+
+	momento, err := strconv.ParseInt(r.Header.Get(EncabezadoMomento), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: momento inválido", ErrSolicitudNoAutenticada)
+	}
+	nonce := r.Header.Get(EncabezadoNonce)
+	if nonce == "" {
+		return fmt.Errorf("%w: falta el nonce", ErrSolicitudNoAutenticada)
+	}
+
+	contenido := contenidoFirmaSolicitud(r, v.nodoID, momento, nonce, cuerpo)
+	if !hmac.Equal(firmaBytes, calcularFirmaSolicitud(v.secreto, contenido)) {
+		return fmt.Errorf("%w: firma inválida", ErrSolicitudNoAutenticada)
+	}
+
+	// La firma es válida: controlar la ventana de tiempo y la repetición
+	ahora := time.Now()
+	enviada := time.Unix(0, momento)
+	if ahora.Sub(enviada).Abs() > v.tolerancia {
+		return fmt.Errorf("%w: momento fuera de la tolerancia de %v", ErrSolicitudNoAutenticada, v.tolerancia)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if ahora.Sub(v.ultimaLimpieza) >= intervaloLimpiezaNonces {
+		for n, vencimiento := range v.nonces {
+			if ahora.After(vencimiento) {
+				delete(v.nonces, n)
+			}
+		}
+		v.ultimaLimpieza = ahora
+	}
+
+	if _, repetido := v.nonces[nonce]; repetido {
+		return fmt.Errorf("%w: nonce repetido", ErrSolicitudNoAutenticada)
+	}
+	// Pasada la tolerancia la solicitud se rechaza por el momento, no hace falta recordar el nonce
+	v.nonces[nonce] = enviada.Add(v.tolerancia)
+	return nil
+}
@@ -0,0 +1,111 @@
+package tipos
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// solicitudFirmadaTest crea una solicitud firmada para el nodo
+func solicitudFirmadaTest(t *testing.T, nodoID string, secreto, cuerpo []byte) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/consulta/rango?nodo=1", bytes.NewReader(cuerpo))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if err := FirmarSolicitud(req, cuerpo, nodoID, secreto); err != nil {
+		t.Fatalf("Error firmando: %v", err)
+	}
+	return req
+}
+
+// TestVerificarSolicitud_Valida verifica que una solicitud firmada se acepta
+func TestVerificarSolicitud_Valida(t *testing.T) {
+	verificador := NuevoVerificadorSolicitudes("nodo-01", []byte("secreto"), 0)
+	cuerpo := []byte("consulta")
+
+	if err := verificador.Verificar(solicitudFirmadaTest(t, "nodo-01", []byte("secreto"), cuerpo), cuerpo); err != nil {
+		t.Fatalf("La solicitud firmada debería aceptarse: %v", err)
+	}
+	t.Log("✓ Verificar acepta solicitudes firmadas con el secreto del nodo")
+}
+
+// TestVerificarSolicitud_Rechazos verifica los casos de rechazo
+func TestVerificarSolicitud_Rechazos(t *testing.T) {
+	cuerpo := []byte("consulta")
+	casos := []struct {
+		nombre    string
+		solicitud func() (*http.Request, []byte)
+	}{
+		{"sin firma", func() (*http.Request, []byte) {
+			req := httptest.NewRequest(http.MethodPost, "/api/consulta/rango", nil)
+			req.Header.Set("Authorization", "Bearer nodo-01")
+			return req, cuerpo
+		}},
+		{"otro secreto", func() (*http.Request, []byte) {
+			return solicitudFirmadaTest(t, "nodo-01", []byte("otro"), cuerpo), cuerpo
+		}},
+		{"otro nodo", func() (*http.Request, []byte) {
+			return solicitudFirmadaTest(t, "nodo-02", []byte("secreto"), cuerpo), cuerpo
+		}},
+		{"cuerpo alterado", func() (*http.Request, []byte) {
+			return solicitudFirmadaTest(t, "nodo-01", []byte("secreto"), cuerpo), []byte("alterado")
+		}},
+		{"query alterada", func() (*http.Request, []byte) {
+			req := solicitudFirmadaTest(t, "nodo-01", []byte("secreto"), cuerpo)
+			req.URL.RawQuery = "patron=*"
+			return req, cuerpo
+		}},
+		{"content-type alterado", func() (*http.Request, []byte) {
+			req := solicitudFirmadaTest(t, "nodo-01", []byte("secreto"), cuerpo)
+			req.Header.Set("Content-Type", "application/json")
+			return req, cuerpo
+		}},
+		{"momento alterado", func() (*http.Request, []byte) {
+			req := solicitudFirmadaTest(t, "nodo-01", []byte("secreto"), cuerpo)
+			req.Header.Set(EncabezadoMomento, strconv.FormatInt(time.Now().UnixNano(), 10))
+			return req, cuerpo
+		}},
+	}
+
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			verificador := NuevoVerificadorSolicitudes("nodo-01", []byte("secreto"), 0)
+			req, cuerpoRecibido := c.solicitud()
+			if err := verificador.Verificar(req, cuerpoRecibido); !errors.Is(err, ErrSolicitudNoAutenticada) {
+				t.Errorf("Se esperaba ErrSolicitudNoAutenticada, obtenido %v", err)
+			}
+		})
+	}
+	t.Log("✓ Verificar rechaza solicitudes sin firma válida")
+}
+
+// TestVerificarSolicitud_Repeticion verifica que no se acepta dos veces la misma solicitud
+func TestVerificarSolicitud_Repeticion(t *testing.T) {
+	verificador := NuevoVerificadorSolicitudes("nodo-01", []byte("secreto"), 0)
+	cuerpo := []byte("consulta")
+	req := solicitudFirmadaTest(t, "nodo-01", []byte("secreto"), cuerpo)
+
+	if err := verificador.Verificar(req, cuerpo); err != nil {
+		t.Fatalf("La primera solicitud debería aceptarse: %v", err)
+	}
+	if err := verificador.Verificar(req, cuerpo); !errors.Is(err, ErrSolicitudNoAutenticada) {
+		t.Errorf("La solicitud repetida debería rechazarse, obtenido %v", err)
+	}
+	t.Log("✓ Verificar rechaza nonces repetidos")
+}
+
+// TestVerificarSolicitud_FueraDeTolerancia verifica que se rechazan solicitudes viejas
+func TestVerificarSolicitud_FueraDeTolerancia(t *testing.T) {
+	verificador := NuevoVerificadorSolicitudes("nodo-01", []byte("secreto"), 10*time.Millisecond)
+	cuerpo := []byte("consulta")
+	req := solicitudFirmadaTest(t, "nodo-01", []byte("secreto"), cuerpo)
+
+	time.Sleep(20 * time.Millisecond)
+	if err := verificador.Verificar(req, cuerpo); !errors.Is(err, ErrSolicitudNoAutenticada) {
+		t.Errorf("La solicitud vencida debería rechazarse, obtenido %v", err)
+	}
+	t.Log("✓ Verificar rechaza solicitudes fuera de la tolerancia")
+}
@@ -0,0 +1,274 @@
+package tipos
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ============================================================================
+// COMANDOS DEL DESPACHADOR A LOS NODOS
+// El despachador modifica la configuración de un nodo enviándole comandos. Si el
+// nodo está accesible el comando se envía a su API REST; si no, queda en una
+// cola en S3 que el nodo revisa periódicamente y aplica en orden de creación.
+// El efecto se refleja en el registro del nodo (nodos/{nodoID}.json) y el
+// resultado de cada comando queda en S3 para consultar su estado.
+// ============================================================================
+
+// Prefijos de las colas de comandos y de sus resultados en el bucket
+const (
+	PrefijoS3Comandos           = "comandos/"
+	PrefijoS3ResultadosComandos = "resultados_comandos/"
+)
+
+// TipoComando identifica la operación de un comando
+type TipoComando string
+
+// Tipos de comando
+const (
+	ComandoCrearRegla        TipoComando = "crear_regla"
+	ComandoActualizarRegla   TipoComando = "actualizar_regla" // Conserva el estado activo de la regla
+	ComandoEliminarRegla     TipoComando = "eliminar_regla"
+	ComandoHabilitarRegla    TipoComando = "habilitar_regla"
+	ComandoDeshabilitarRegla TipoComando = "deshabilitar_regla"
+	ComandoCrearSerie        TipoComando = "crear_serie"
+	ComandoActualizarSerie   TipoComando = "actualizar_serie" // Solo cambian los tags y el tiempo de almacenamiento
+	ComandoEliminarSerie     TipoComando = "eliminar_serie"
+)
+
+// EstadoComando indica si un comando fue aplicado por su nodo
+type EstadoComando string
+
+const (
+	ComandoPendiente EstadoComando = "pendiente" // Encolado en S3 hasta que el nodo lo procese
+	ComandoAplicado  EstadoComando = "aplicado"  // El nodo aplicó el comando
+	ComandoRechazado EstadoComando = "rechazado" // El nodo no pudo aplicarlo (ver Motivo)
+)
+
+// Comando es una modificación de la configuración de un nodo pedida por el despachador
+type Comando struct {
+	ID      string      `json:"id"` // Ordenable por momento de creación (ver NuevoIDComando)
+	NodoID  string      `json:"nodo_id"`
+	Tipo    TipoComando `json:"tipo"`
+	Creado  int64       `json:"creado"`             // Unix nanosegundos
+	Regla   *Regla      `json:"regla,omitempty"`    // Crear y actualizar regla
+	ReglaID string      `json:"regla_id,omitempty"` // Eliminar, habilitar y deshabilitar regla
+	Serie   *Serie      `json:"serie,omitempty"`    // Crear y actualizar serie
+	Path    string      `json:"path,omitempty"`     // Eliminar serie
+}
+
+// ResultadoComando es el estado de un comando. El nodo lo retorna al aplicarlo.
+type ResultadoComando struct {
+	ID       string        `json:"id"`
+	Tipo     TipoComando   `json:"tipo"`
+	Estado   EstadoComando `json:"estado"`
+	Motivo   string        `json:"motivo,omitempty"`   // Por qué se rechazó o sigue pendiente
+	Aplicado int64         `json:"aplicado,omitempty"` // Momento en que se aplicó o rechazó (Unix nanosegundos)
+	Regla    *Regla        `json:"regla,omitempty"`    // Regla resultante (nil al eliminar)
+	Serie    *Serie        `json:"serie,omitempty"`    // Serie resultante (nil al eliminar)
+}
+
+// RegistroComando es un comando junto con su resultado. Los comandos aplicados o
+// rechazados se guardan en S3 (resultados_comandos/{nodoID}/{id}.json).
+type RegistroComando struct {
+	Comando   Comando          `json:"comando"`
+	Resultado ResultadoComando `json:"resultado"`
+}
+
+// NuevoIDComando genera un ID de comando que ordena lexicográficamente según el momento
+// de creación, con un sufijo aleatorio para distinguir comandos del mismo instante
+func NuevoIDComando(momento time.Time) (string, error) {
+	sufijo := make([]byte, 4)
+	if _, err := rand.Read(sufijo); err != nil {
+		return "", fmt.Errorf("error generando ID de comando: %v", err)
+	}
+	return fmt.Sprintf("%020d-%s", momento.UnixNano(), hex.EncodeToString(sufijo)), nil
+}
+
+// GenerarPrefijoS3Comandos genera el prefijo de la cola de comandos de un nodo.
+// Formato: comandos/{nodoID}/
+func GenerarPrefijoS3Comandos(nodoID string) string {
+	return fmt.Sprintf("%s%s/", PrefijoS3Comandos, nodoID)
+}
+
+// GenerarClaveS3Comando genera la clave de un comando encolado.
+// Formato: comandos/{nodoID}/{id}.json
+func GenerarClaveS3Comando(nodoID, id string) string {
+	return fmt.Sprintf("%s%s.json", GenerarPrefijoS3Comandos(nodoID), id)
+}
+
+// GenerarPrefijoS3ResultadosComandos genera el prefijo de los resultados de los comandos
+// de un nodo. Formato: resultados_comandos/{nodoID}/
+func GenerarPrefijoS3ResultadosComandos(nodoID string) string {
+	return fmt.Sprintf("%s%s/", PrefijoS3ResultadosComandos, nodoID)
+}
+
+// GenerarClaveS3ResultadoComando genera la clave del resultado de un comando.
+// Formato: resultados_comandos/{nodoID}/{id}.json
+func GenerarClaveS3ResultadoComando(nodoID, id string) string {
+	return fmt.Sprintf("%s%s.json", GenerarPrefijoS3ResultadosComandos(nodoID), id)
+}
+
+// Validar verifica que el comando tenga los campos que requiere su tipo. La regla
+// solo se valida en su forma; el nodo la valida con su motor de reglas al aplicarla.
+// Las series se validan en el nodo.
+func (c Comando) Validar() error {
+	if c.NodoID == "" {
+		return fmt.Errorf("nodo_id requerido")
+	}
+	switch c.Tipo {
+	case ComandoCrearRegla, ComandoActualizarRegla:
+		if c.Regla == nil {
+			return fmt.Errorf("%s requiere la regla", c.Tipo)
+		}
+		return c.Regla.validarForma()
+	case ComandoEliminarRegla, ComandoHabilitarRegla, ComandoDeshabilitarRegla:
+		if c.ReglaID == "" {
+			return fmt.Errorf("%s requiere regla_id", c.Tipo)
+		}
+		return nil
+	case ComandoCrearSerie, ComandoActualizarSerie:
+		if c.Serie == nil || c.Serie.Path == "" {
+			return fmt.Errorf("%s requiere la serie con su path", c.Tipo)
+		}
+		return nil
+	case ComandoEliminarSerie:
+		if c.Path == "" {
+			return fmt.Errorf("%s requiere path", c.Tipo)
+		}
+		return nil
+	default:
+		return fmt.Errorf("tipo de comando desconocido: %q", c.Tipo)
+	}
+}
+
+// Objetivo retorna el ID de la regla o el path de la serie sobre la que actúa el comando
+func (c Comando) Objetivo() string {
+	switch {
+	case c.Regla != nil:
+		return c.Regla.ID
+	case c.Serie != nil:
+		return c.Serie.Path
+	case c.ReglaID != "":
+		return c.ReglaID
+	}
+	return c.Path
+}
+
+// EsComandoSerie indica si el comando actúa sobre una serie
+func (c Comando) EsComandoSerie() bool {
+	return c.Tipo == ComandoCrearSerie || c.Tipo == ComandoActualizarSerie || c.Tipo == ComandoEliminarSerie
+}
+
+// ============================================================================
+// FIRMA DE LOS COMANDOS ENCOLADOS
+// Quien puede escribir en el bucket no debe poder modificar los nodos: cada comando
+// encolado se firma con el secreto compartido con su nodo, con los mismos campos que
+// FirmarSolicitud usa en la entrega directa (POST /api/comandos con el comando como
+// cuerpo). El nodo descarta sin aplicarlos los comandos sin firma, con firma
+// inválida o firmados hace más de VigenciaComandoEncolado. Un comando vigente
+// repetido se reconoce por su ID, como en la entrega directa.
+// ============================================================================
+
+// VigenciaComandoEncolado es el tiempo máximo que un comando firmado espera en la cola
+const VigenciaComandoEncolado = 7 * 24 * time.Hour
+
+// ErrComandoNoAutenticado indica un comando encolado sin firma válida o vencido
+var ErrComandoNoAutenticado = errors.New("comando no autenticado")
+
+// ComandoEncolado es el objeto de la cola S3 de un nodo: el comando serializado tal
+// como se firmó, junto con la firma
+type ComandoEncolado struct {
+	Comando json.RawMessage `json:"comando"`
+	Momento int64           `json:"momento"` // Momento de la firma (Unix nanosegundos)
+	Nonce   string          `json:"nonce"`
+	Firma   string          `json:"firma"` // HMAC-SHA256 en base64
+}
+
+// solicitudComando retorna la entrega directa equivalente, cuyo contenido se firma
+func solicitudComando() *http.Request {
+	req := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/api/comandos"}, Header: make(http.Header)}
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// FirmarComando serializa el comando y lo firma con el secreto de su nodo para encolarlo
+func FirmarComando(comando Comando, secreto []byte) (ComandoEncolado, error) {
+	datos, err := json.Marshal(comando)
+	if err != nil {
+		return ComandoEncolado{}, fmt.Errorf("error serializando comando: %v", err)
+	}
+	momento, nonce, firma, err := nuevaFirmaSolicitud(solicitudComando(), datos, comando.NodoID, secreto)
+	if err != nil {
+		return ComandoEncolado{}, err
+	}
+	return ComandoEncolado{Comando: datos, Momento: momento, Nonce: nonce, Firma: firma}, nil
+}
+
+// Verificar comprueba la firma del comando con el secreto del nodo y que no esté
+// vencido, y retorna el comando. Los errores envuelven ErrComandoNoAutenticado.
+func (e ComandoEncolado) Verificar(nodoID string, secreto []byte) (Comando, error) {
+	var comando Comando
+	if len(secreto) == 0 {
+		return comando, fmt.Errorf("%w: el nodo no tiene secreto para verificarlo", ErrComandoNoAutenticado)
+	}
+	if e.Firma == "" {
+		return comando, fmt.Errorf("%w: sin firma", ErrComandoNoAutenticado)
+	}
+	firma, err := base64.StdEncoding.DecodeString(e.Firma)
+	if err != nil {
+		return comando, fmt.Errorf("%w: firma mal formada", ErrComandoNoAutenticado)
+	}
+
+	contenido := contenidoFirmaSolicitud(solicitudComando(), nodoID, e.Momento, e.Nonce, e.Comando)
+	if !hmac.Equal(firma, calcularFirmaSolicitud(secreto, contenido)) {
+		return comando, fmt.Errorf("%w: firma inválida", ErrComandoNoAutenticado)
+	}
+
+	firmado := time.Unix(0, e.Momento)
+	if time.Since(firmado) > VigenciaComandoEncolado {
+		return comando, fmt.Errorf("%w: firmado hace más de %v", ErrComandoNoAutenticado, VigenciaComandoEncolado)
+	}
+	if time.Until(firmado) > ToleranciaMomentoPorDefecto {
+		return comando, fmt.Errorf("%w: momento de firma futuro", ErrComandoNoAutenticado)
+	}
+
+	if err := json.Unmarshal(e.Comando, &comando); err != nil {
+		return comando, fmt.Errorf("error deserializando comando: %v", err)
+	}
+	return comando, nil
+}
+
+// validarForma verifica los campos de una regla que no dependen del nodo
+func (r Regla) validarForma() error {
+	if r.ID == "" {
+		return fmt.Errorf("ID de regla no puede estar vacío")
+	}
+	if len(r.Condiciones) == 0 {
+		return fmt.Errorf("regla debe tener al menos una condición")
+	}
+	if len(r.Acciones) == 0 {
+		return fmt.Errorf("regla debe tener al menos una acción")
+	}
+	for i, c := range r.Condiciones {
+		if c.Path == "" {
+			return fmt.Errorf("condición %d: path vacío", i)
+		}
+		if _, err := ParsearDuracion(c.VentanaT); err != nil {
+			return fmt.Errorf("condición %d: ventana_t inválida: %v", i, err)
+		}
+	}
+	for i, a := range r.Acciones {
+		if a.Tipo == "" {
+			return fmt.Errorf("acción %d: tipo vacío", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package tipos
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNuevoIDComando_Ordenable verifica que los IDs ordenan según el momento de creación
+func TestNuevoIDComando_Ordenable(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	var ids []string
+	for _, d := range []time.Duration{0, time.Nanosecond, time.Millisecond, time.Hour, 400 * 24 * time.Hour} {
+		id, err := NuevoIDComando(base.Add(d))
+		if err != nil {
+			t.Fatalf("Error generando ID: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("Los IDs deberían ordenar según el momento de creación: %v", ids)
+	}
+
+	a, _ := NuevoIDComando(base)
+	b, _ := NuevoIDComando(base)
+	if a == b {
+		t.Errorf("Dos comandos del mismo instante no deberían compartir ID: %s", a)
+	}
+	if clave := GenerarClaveS3Comando("nodo-01", a); clave != "comandos/nodo-01/"+a+".json" {
+		t.Errorf("Clave inesperada: %s", clave)
+	}
+	t.Log("✓ Los IDs de comando ordenan por creación y no se repiten")
+}
+
+// TestComando_Validar verifica los campos requeridos por cada tipo de comando
+func TestComando_Validar(t *testing.T) {
+	regla := &Regla{
+		ID:          "alta",
+		Condiciones: []Condicion{{Path: "sensor/temp", VentanaT: "5m", Operador: ">", Valor: 30.0}},
+		Acciones:    []Accion{{Tipo: "log"}},
+	}
+
+	validos := []Comando{
+		{NodoID: "n1", Tipo: ComandoCrearRegla, Regla: regla},
+		{NodoID: "n1", Tipo: ComandoActualizarRegla, Regla: regla},
+		{NodoID: "n1", Tipo: ComandoEliminarRegla, ReglaID: "alta"},
+		{NodoID: "n1", Tipo: ComandoHabilitarRegla, ReglaID: "alta"},
+		{NodoID: "n1", Tipo: ComandoCrearSerie, Serie: &Serie{Path: "sensor/hum", TipoDatos: Real}},
+		{NodoID: "n1", Tipo: ComandoActualizarSerie, Serie: &Serie{Path: "sensor/hum"}},
+		{NodoID: "n1", Tipo: ComandoEliminarSerie, Path: "sensor/hum"},
+	}
+	for _, c := range validos {
+		if err := c.Validar(); err != nil {
+			t.Errorf("%s: error inesperado: %v", c.Tipo, err)
+		}
+	}
+
+	sinVentana := *regla
+	sinVentana.Condiciones = []Condicion{{Path: "sensor/temp", VentanaT: "cinco", Operador: ">", Valor: 30.0}}
+	invalidos := map[string]Comando{
+		"sin nodo":        {Tipo: ComandoEliminarRegla, ReglaID: "alta"},
+		"sin regla":       {NodoID: "n1", Tipo: ComandoCrearRegla},
+		"sin regla_id":    {NodoID: "n1", Tipo: ComandoDeshabilitarRegla},
+		"tipo":            {NodoID: "n1", Tipo: "reiniciar"},
+		"ventana":         {NodoID: "n1", Tipo: ComandoCrearRegla, Regla: &sinVentana},
+		"sin condiciones": {NodoID: "n1", Tipo: ComandoCrearRegla, Regla: &Regla{ID: "x", Acciones: regla.Acciones}},
+		"sin serie":       {NodoID: "n1", Tipo: ComandoCrearSerie},
+		"serie sin path":  {NodoID: "n1", Tipo: ComandoActualizarSerie, Serie: &Serie{TipoDatos: Real}},
+		"sin path":        {NodoID: "n1", Tipo: ComandoEliminarSerie},
+	}
+	for nombre, c := range invalidos {
+		err := c.Validar()
+		if err == nil {
+			t.Errorf("%s: se esperaba error", nombre)
+			continue
+		}
+		if nombre == "ventana" && !strings.Contains(err.Error(), "ventana_t") {
+			t.Errorf("%s: error inesperado: %v", nombre, err)
+		}
+	}
+	t.Log("✓ Comando.Validar exige los campos de cada tipo")
+}
+
+// TestComando_Objetivo verifica la regla o serie sobre la que actúa cada comando
+func TestComando_Objetivo(t *testing.T) {
+	casos := map[string]Comando{
+		"alta":       {Tipo: ComandoActualizarRegla, Regla: &Regla{ID: "alta"}},
+		"baja":       {Tipo: ComandoEliminarRegla, ReglaID: "baja"},
+		"sensor/hum": {Tipo: ComandoCrearSerie, Serie: &Serie{Path: "sensor/hum"}},
+		"sensor/tmp": {Tipo: ComandoEliminarSerie, Path: "sensor/tmp"},
+	}
+	for esperado, c := range casos {
+		if objetivo := c.Objetivo(); objetivo != esperado {
+			t.Errorf("%s: objetivo %q, se esperaba %q", c.Tipo, objetivo, esperado)
+		}
+		if c.EsComandoSerie() != strings.Contains(esperado, "/") {
+			t.Errorf("%s: EsComandoSerie inesperado", c.Tipo)
+		}
+	}
+	if clave := GenerarClaveS3ResultadoComando("nodo-01", "c1"); clave != "resultados_comandos/nodo-01/c1.json" {
+		t.Errorf("Clave de resultado inesperada: %s", clave)
+	}
+	t.Log("✓ Objetivo identifica la regla o serie de cada comando")
+}
+
+// TestComandoEncolado_Verificar verifica la firma y la vigencia de los comandos encolados
+func TestComandoEncolado_Verificar(t *testing.T) {
+	secreto := []byte("secreto-nodo")
+	comando := Comando{ID: "c1", NodoID: "n1", Tipo: ComandoEliminarSerie, Path: "sensor/temp"}
+	encolado, err := FirmarComando(comando, secreto)
+	if err != nil {
+		t.Fatalf("Error firmando comando: %v", err)
+	}
+
+	verificado, err := encolado.Verificar("n1", secreto)
+	if err != nil {
+		t.Fatalf("Error verificando comando firmado: %v", err)
+	}
+	if verificado.ID != "c1" || verificado.Path != "sensor/temp" {
+		t.Errorf("Comando verificado incorrecto: %+v", verificado)
+	}
+
+	alterado := encolado
+	alterado.Comando = []byte(`{"id":"c1","nodo_id":"n1","tipo":"eliminar_serie","path":"sensor/hum"}`)
+	sinFirma := encolado
+	sinFirma.Firma = ""
+	vencido := encolado
+	vencido.Momento = time.Now().Add(-VigenciaComandoEncolado - time.Hour).UnixNano()
+	contenido := contenidoFirmaSolicitud(solicitudComando(), "n1", vencido.Momento, vencido.Nonce, vencido.Comando)
+	vencido.Firma = base64.StdEncoding.EncodeToString(calcularFirmaSolicitud(secreto, contenido))
+
+	casos := []struct {
+		nombre   string
+		encolado ComandoEncolado
+		nodoID   string
+		secreto  []byte
+	}{
+		{"contenido alterado", alterado, "n1", secreto},
+		{"sin firma", sinFirma, "n1", secreto},
+		{"otro secreto", encolado, "n1", []byte("otro")},
+		{"otro nodo", encolado, "n2", secreto},
+		{"nodo sin secreto", encolado, "n1", nil},
+		{"vencido", vencido, "n1", secreto},
+	}
+	for _, c := range casos {
+		if _, err := c.encolado.Verificar(c.nodoID, c.secreto); !errors.Is(err, ErrComandoNoAutenticado) {
+			t.Errorf("%s: se esperaba ErrComandoNoAutenticado, obtenido %v", c.nombre, err)
+		}
+	}
+	t.Log("✓ Los comandos encolados se aceptan solo con firma válida y vigente")
+}
@@ -0,0 +1,106 @@
+package tipos
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ============================================================================
+// FIRMA DE REGISTROS DE NODOS
+// Cada nodo edge firma su registro (nodos/{id}.json) con una clave Ed25519
+// propia. La firma cubre el JSON del registro sin el campo "firma", con las
+// claves de primer nivel ordenadas: el contenido firmado se reconstruye a
+// partir de los bytes del objeto y no depende de cómo se deserialicen los
+// tipos del registro. El despachador verifica la firma y que la clave pública
+// sea la inscripta para el nodo.
+// ============================================================================
+
+// campoFirma es el campo del registro que contiene la firma
+const campoFirma = "firma"
+
+// ErrRegistroSinFirma indica que el registro del nodo no está firmado
+var ErrRegistroSinFirma = errors.New("registro sin firma")
+
+// CodificarClavePublica codifica una clave pública Ed25519 en base64
+func CodificarClavePublica(clave ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(clave)
+}
+
+// DecodificarClavePublica decodifica una clave pública Ed25519 en base64
+func DecodificarClavePublica(clave string) (ed25519.PublicKey, error) {
+	bytes, err := base64.StdEncoding.DecodeString(clave)
+	if err != nil {
+		return nil, fmt.Errorf("clave pública inválida: %v", err)
+	}
+	if len(bytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("clave pública inválida: se esperaban %d bytes, hay %d", ed25519.PublicKeySize, len(bytes))
+	}
+	return ed25519.PublicKey(bytes), nil
+}
+
+// contenidoFirmado retorna los campos del registro sin la firma y el contenido canónico a firmar
+func contenidoFirmado(registro []byte) (map[string]json.RawMessage, []byte, error) {
+	var campos map[string]json.RawMessage
+	if err := json.Unmarshal(registro, &campos); err != nil {
+		return nil, nil, fmt.Errorf("registro inválido: %v", err)
+	}
+	delete(campos, campoFirma)
+
+	// json.Marshal ordena las claves del mapa y compacta los valores
+	contenido, err := json.Marshal(campos)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error al serializar registro: %v", err)
+	}
+	return campos, contenido, nil
+}
+
+// FirmarRegistroNodo firma el JSON del registro de un nodo y retorna el registro con el
+// campo "firma" agregado. El registro debe incluir la clave pública del nodo.
+func FirmarRegistroNodo(registro []byte, clave ed25519.PrivateKey) ([]byte, error) {
+	campos, contenido, err := contenidoFirmado(registro)
+	if err != nil {
+		return nil, err
+	}
+
+	firma, err := json.Marshal(base64.StdEncoding.EncodeToString(ed25519.Sign(clave, contenido)))
+	if err != nil {
+		return nil, fmt.Errorf("error al serializar firma: %v", err)
+	}
+	campos[campoFirma] = firma
+
+	return json.Marshal(campos)
+}
+
+// VerificarRegistroNodo verifica la firma del JSON del registro de un nodo con la clave
+// pública incluida en el mismo registro. Retorna ErrRegistroSinFirma si no está firmado.
+// Que la clave pública corresponda al nodo lo decide quien verifica.
+func VerificarRegistroNodo(registro []byte) error {
+	var nodo Nodo
+	if err := json.Unmarshal(registro, &nodo); err != nil {
+		return fmt.Errorf("registro inválido: %v", err)
+	}
+	if nodo.Firma == "" {
+		return ErrRegistroSinFirma
+	}
+
+	clavePublica, err := DecodificarClavePublica(nodo.ClavePublica)
+	if err != nil {
+		return err
+	}
+	firma, err := base64.StdEncoding.DecodeString(nodo.Firma)
+	if err != nil {
+		return fmt.Errorf("firma inválida: %v", err)
+	}
+
+	_, contenido, err := contenidoFirmado(registro)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(clavePublica, contenido, firma) {
+		return errors.New("firma inválida: no corresponde al contenido del registro")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package tipos
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// registroFirmadoTest genera un registro firmado con una clave nueva
+func registroFirmadoTest(t *testing.T) ([]byte, ed25519.PublicKey) {
+	t.Helper()
+	publica, privada, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Error generando clave: %v", err)
+	}
+
+	registro, err := json.Marshal(Nodo{
+		NodoID:       "nodo-01",
+		Direccion:    "http://10.0.0.1:8080",
+		Series:       map[string]Serie{"sensor/temp": {SerieId: 1, Path: "sensor/temp"}},
+		ClavePublica: CodificarClavePublica(publica),
+	})
+	if err != nil {
+		t.Fatalf("Error serializando: %v", err)
+	}
+
+	firmado, err := FirmarRegistroNodo(registro, privada)
+	if err != nil {
+		t.Fatalf("Error firmando: %v", err)
+	}
+	return firmado, publica
+}
+
+// TestFirmarRegistroNodo_Verifica verifica que un registro firmado se valida
+func TestFirmarRegistroNodo_Verifica(t *testing.T) {
+	firmado, publica := registroFirmadoTest(t)
+
+	if err := VerificarRegistroNodo(firmado); err != nil {
+		t.Fatalf("El registro firmado debería verificarse: %v", err)
+	}
+
+	var nodo Nodo
+	if err := json.Unmarshal(firmado, &nodo); err != nil {
+		t.Fatalf("Error deserializando: %v", err)
+	}
+	if nodo.Firma == "" || nodo.ClavePublica != CodificarClavePublica(publica) {
+		t.Errorf("El registro debería incluir la firma y la clave pública")
+	}
+	t.Log("✓ FirmarRegistroNodo genera registros verificables")
+}
+
+// TestVerificarRegistroNodo_Modificado verifica que se rechaza un registro alterado
+func TestVerificarRegistroNodo_Modificado(t *testing.T) {
+	firmado, _ := registroFirmadoTest(t)
+	alterado := bytes.Replace(firmado, []byte("10.0.0.1"), []byte("10.6.6.6"), 1)
+
+	if err := VerificarRegistroNodo(alterado); err == nil {
+		t.Error("Un registro con la dirección alterada no debería verificarse")
+	}
+	t.Log("✓ VerificarRegistroNodo rechaza registros alterados")
+}
+
+// TestVerificarRegistroNodo_OtraClave verifica que no se puede reemplazar la clave pública
+func TestVerificarRegistroNodo_OtraClave(t *testing.T) {
+	firmado, _ := registroFirmadoTest(t)
+	otra, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Error generando clave: %v", err)
+	}
+
+	var campos map[string]json.RawMessage
+	if err := json.Unmarshal(firmado, &campos); err != nil {
+		t.Fatalf("Error deserializando: %v", err)
+	}
+	campos["clave_publica"], _ = json.Marshal(CodificarClavePublica(otra))
+	alterado, _ := json.Marshal(campos)
+
+	if err := VerificarRegistroNodo(alterado); err == nil {
+		t.Error("Un registro con otra clave pública no debería verificarse")
+	}
+	t.Log("✓ VerificarRegistroNodo rechaza registros con la clave pública reemplazada")
+}
+
+// TestVerificarRegistroNodo_SinFirma verifica el error de registros no firmados
+func TestVerificarRegistroNodo_SinFirma(t *testing.T) {
+	registro, _ := json.Marshal(Nodo{NodoID: "nodo-01"})
+
+	if err := VerificarRegistroNodo(registro); !errors.Is(err, ErrRegistroSinFirma) {
+		t.Errorf("Se esperaba ErrRegistroSinFirma, obtenido %v", err)
+	}
+	t.Log("✓ VerificarRegistroNodo informa los registros sin firma")
+}
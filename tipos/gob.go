@@ -11,9 +11,10 @@ import (
 
 // SolicitudConsultaRango representa una solicitud de consulta por rango de tiempo
 type SolicitudConsultaRango struct {
-	Serie        string
-	TiempoInicio int64 // Unix nanosegundos
-	TiempoFin    int64 // Unix nanosegundos
+	Serie        string          `json:"serie"`
+	TiempoInicio int64           `json:"tiempo_inicio"`     // Unix nanosegundos
+	TiempoFin    int64           `json:"tiempo_fin"`        // Unix nanosegundos
+	Ventana      *FuncionVentana `json:"ventana,omitempty"` // Opcional: función de ventana a aplicar sobre el resultado
 }
 
 // SolicitudConsultaPunto representa una solicitud de último punto
@@ -21,80 +22,81 @@ type SolicitudConsultaRango struct {
 //   - Si ambos son nil: retorna el último punto absoluto de cada serie
 //   - Si se especifican: retorna el último punto dentro del rango temporal
 type SolicitudConsultaPunto struct {
-	Serie        string
-	TiempoInicio *int64 // nil = sin límite inferior (Unix nanosegundos)
-	TiempoFin    *int64 // nil = sin límite superior (Unix nanosegundos)
+	Serie        string `json:"serie"`
+	TiempoInicio *int64 `json:"tiempo_inicio,omitempty"` // nil = sin límite inferior (Unix nanosegundos)
+	TiempoFin    *int64 `json:"tiempo_fin,omitempty"`    // nil = sin límite superior (Unix nanosegundos)
 }
 
 // ResultadoConsultaPunto representa el último punto de múltiples series en formato columnar.
 // Cada serie tiene su último punto (timestamp y valor).
 // Series sin datos son excluidas del resultado.
 type ResultadoConsultaPunto struct {
-	Series             []string      // Nombres de series ordenados alfabéticamente
-	Tiempos            []int64       // Timestamp del punto por serie (Unix nanosegundos)
-	Valores            []interface{} // Valor del punto por serie
-	NodosNoDisponibles []string      // IDs de nodos que no respondieron (solo en consultas globales)
+	Series             []string      `json:"series"`                         // Nombres de series ordenados alfabéticamente
+	Tiempos            []int64       `json:"tiempos"`                        // Timestamp del punto por serie (Unix nanosegundos)
+	Valores            []interface{} `json:"valores"`                        // Valor del punto por serie
+	NodosNoDisponibles []string      `json:"nodos_no_disponibles,omitempty"` // IDs de nodos que no respondieron (solo en consultas globales)
 }
 
 // ResultadoConsultaRango representa el resultado de una consulta de rango en formato tabular.
 // Cada serie temporal es una columna, los timestamps son las filas.
 // Valores faltantes se representan como nil.
 type ResultadoConsultaRango struct {
-	Series             []string        // Columnas: nombres de series ordenados alfabéticamente
-	Tiempos            []int64         // Filas: timestamps únicos ordenados ascendente (Unix nanosegundos)
-	Valores            [][]interface{} // Matriz [fila][columna], nil = valor faltante
-	NodosNoDisponibles []string        // IDs de nodos que no respondieron (solo en consultas globales)
+	Series             []string        `json:"series"`                         // Columnas: nombres de series ordenados alfabéticamente
+	Tiempos            []int64         `json:"tiempos"`                        // Filas: timestamps únicos ordenados ascendente (Unix nanosegundos)
+	Valores            [][]interface{} `json:"valores"`                        // Matriz [fila][columna], nil = valor faltante
+	NodosNoDisponibles []string        `json:"nodos_no_disponibles,omitempty"` // IDs de nodos que no respondieron (solo en consultas globales)
 }
 
 // RespuestaConsultaRango respuesta con resultado tabular de consulta por rango
 type RespuestaConsultaRango struct {
-	Resultado ResultadoConsultaRango
-	Error     string
+	Resultado ResultadoConsultaRango `json:"resultado"`
+	Error     string                 `json:"error,omitempty"`
 }
 
 // RespuestaConsultaPunto respuesta con resultado de consulta de último punto en formato columnar
 type RespuestaConsultaPunto struct {
-	Resultado ResultadoConsultaPunto
-	Error     string
+	Resultado ResultadoConsultaPunto `json:"resultado"`
+	Error     string                 `json:"error,omitempty"`
 }
 
 // SolicitudConsultaAgregacion representa una solicitud de agregación (soporta múltiples)
 type SolicitudConsultaAgregacion struct {
-	Serie        string
-	TiempoInicio int64            // Unix nanosegundos
-	TiempoFin    int64            // Unix nanosegundos
-	Agregaciones []TipoAgregacion // Lista de agregaciones a calcular
+	Serie        string           `json:"serie"`
+	TiempoInicio int64            `json:"tiempo_inicio"` // Unix nanosegundos
+	TiempoFin    int64            `json:"tiempo_fin"`    // Unix nanosegundos
+	Agregaciones []TipoAgregacion `json:"agregaciones"`  // Lista de agregaciones a calcular
 }
 
 // SolicitudConsultaAgregacionTemporal representa una solicitud de downsampling (soporta múltiples)
 type SolicitudConsultaAgregacionTemporal struct {
-	Serie        string
-	TiempoInicio int64            // Unix nanosegundos
-	TiempoFin    int64            // Unix nanosegundos
-	Agregaciones []TipoAgregacion // Lista de agregaciones a calcular
-	Intervalo    int64            // Duration en nanosegundos
+	Serie        string           `json:"serie"`
+	TiempoInicio int64            `json:"tiempo_inicio"`     // Unix nanosegundos
+	TiempoFin    int64            `json:"tiempo_fin"`        // Unix nanosegundos
+	Agregaciones []TipoAgregacion `json:"agregaciones"`      // Lista de agregaciones a calcular
+	Intervalo    int64            `json:"intervalo"`         // Duration en nanosegundos
+	Ventana      *FuncionVentana  `json:"ventana,omitempty"` // Opcional: función de ventana a aplicar sobre los buckets
 }
 
 // ResultadoAgregacion representa el resultado columnar de múltiples agregaciones.
 // Soporta múltiples agregaciones en una sola consulta.
 // Estructura de Valores: [agregacion][serie]
 type ResultadoAgregacion struct {
-	Series             []string         // Nombres de series ordenados alfabéticamente
-	Agregaciones       []TipoAgregacion // Lista ordenada de agregaciones calculadas
-	Valores            [][]float64      // Matriz [agregacion][serie]
-	NodosNoDisponibles []string         // IDs de nodos que no respondieron (solo en consultas globales)
+	Series             []string         `json:"series"`                         // Nombres de series ordenados alfabéticamente
+	Agregaciones       []TipoAgregacion `json:"agregaciones"`                   // Lista ordenada de agregaciones calculadas
+	Valores            [][]float64      `json:"valores"`                        // Matriz [agregacion][serie]
+	NodosNoDisponibles []string         `json:"nodos_no_disponibles,omitempty"` // IDs de nodos que no respondieron (solo en consultas globales)
 }
 
 // RespuestaConsultaAgregacion respuesta con resultado de agregación columnar
 type RespuestaConsultaAgregacion struct {
-	Resultado ResultadoAgregacion
-	Error     string
+	Resultado ResultadoAgregacion `json:"resultado"`
+	Error     string              `json:"error,omitempty"`
 }
 
 // RespuestaConsultaAgregacionTemporal respuesta con resultado de downsampling en formato matricial
 type RespuestaConsultaAgregacionTemporal struct {
-	Resultado ResultadoAgregacionTemporal
-	Error     string
+	Resultado ResultadoAgregacionTemporal `json:"resultado"`
+	Error     string                      `json:"error,omitempty"`
 }
 
 // ============================================================================
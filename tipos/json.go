@@ -0,0 +1,198 @@
+package tipos
+
+import "encoding/json"
+
+// ============================================================================
+// SERIALIZACIÓN JSON DE CONSULTAS
+// Las solicitudes aceptan los mismos formatos de tiempo que la API REST del
+// despachador (Unix nanosegundos, RFC3339, now-1h) y los resultados con NaN
+// se serializan usando FloatNulo.
+// ============================================================================
+
+// UnmarshalJSON deserializa una solicitud de rango aceptando tiempos legibles
+func (s *SolicitudConsultaRango) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Serie        string          `json:"serie"`
+		TiempoInicio MarcaTiempo     `json:"tiempo_inicio"`
+		TiempoFin    MarcaTiempo     `json:"tiempo_fin"`
+		Ventana      *FuncionVentana `json:"ventana"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*s = SolicitudConsultaRango{
+		Serie:        aux.Serie,
+		TiempoInicio: int64(aux.TiempoInicio),
+		TiempoFin:    int64(aux.TiempoFin),
+		Ventana:      aux.Ventana,
+	}
+	return nil
+}
+
+// UnmarshalJSON deserializa una solicitud de último punto aceptando tiempos legibles
+func (s *SolicitudConsultaPunto) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Serie        string       `json:"serie"`
+		TiempoInicio *MarcaTiempo `json:"tiempo_inicio"`
+		TiempoFin    *MarcaTiempo `json:"tiempo_fin"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*s = SolicitudConsultaPunto{Serie: aux.Serie}
+	if aux.TiempoInicio != nil {
+		t := int64(*aux.TiempoInicio)
+		s.TiempoInicio = &t
+	}
+	if aux.TiempoFin != nil {
+		t := int64(*aux.TiempoFin)
+		s.TiempoFin = &t
+	}
+	return nil
+}
+
+// UnmarshalJSON deserializa una solicitud de agregación aceptando tiempos legibles
+func (s *SolicitudConsultaAgregacion) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Serie        string           `json:"serie"`
+		TiempoInicio MarcaTiempo      `json:"tiempo_inicio"`
+		TiempoFin    MarcaTiempo      `json:"tiempo_fin"`
+		Agregaciones []TipoAgregacion `json:"agregaciones"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*s = SolicitudConsultaAgregacion{
+		Serie:        aux.Serie,
+		TiempoInicio: int64(aux.TiempoInicio),
+		TiempoFin:    int64(aux.TiempoFin),
+		Agregaciones: aux.Agregaciones,
+	}
+	return nil
+}
+
+// UnmarshalJSON deserializa una solicitud de agregación temporal aceptando tiempos
+// e intervalos legibles ("15m")
+func (s *SolicitudConsultaAgregacionTemporal) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Serie        string           `json:"serie"`
+		TiempoInicio MarcaTiempo      `json:"tiempo_inicio"`
+		TiempoFin    MarcaTiempo      `json:"tiempo_fin"`
+		Agregaciones []TipoAgregacion `json:"agregaciones"`
+		Intervalo    Duracion         `json:"intervalo"`
+		Ventana      *FuncionVentana  `json:"ventana"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*s = SolicitudConsultaAgregacionTemporal{
+		Serie:        aux.Serie,
+		TiempoInicio: int64(aux.TiempoInicio),
+		TiempoFin:    int64(aux.TiempoFin),
+		Agregaciones: aux.Agregaciones,
+		Intervalo:    int64(aux.Intervalo),
+		Ventana:      aux.Ventana,
+	}
+	return nil
+}
+
+// resultadoAgregacionJSON es la forma JSON de ResultadoAgregacion (NaN como null)
+type resultadoAgregacionJSON struct {
+	Series             []string         `json:"series"`
+	Agregaciones       []TipoAgregacion `json:"agregaciones"`
+	Valores            [][]FloatNulo    `json:"valores"`
+	NodosNoDisponibles []string         `json:"nodos_no_disponibles,omitempty"`
+}
+
+// MarshalJSON serializa el resultado representando NaN como null
+func (r ResultadoAgregacion) MarshalJSON() ([]byte, error) {
+	aux := resultadoAgregacionJSON{
+		Series:             r.Series,
+		Agregaciones:       r.Agregaciones,
+		Valores:            make([][]FloatNulo, len(r.Valores)),
+		NodosNoDisponibles: r.NodosNoDisponibles,
+	}
+	for i, fila := range r.Valores {
+		aux.Valores[i] = make([]FloatNulo, len(fila))
+		for j, v := range fila {
+			aux.Valores[i][j] = FloatNulo(v)
+		}
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON deserializa el resultado convirtiendo null en NaN
+func (r *ResultadoAgregacion) UnmarshalJSON(data []byte) error {
+	var aux resultadoAgregacionJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*r = ResultadoAgregacion{
+		Series:             aux.Series,
+		Agregaciones:       aux.Agregaciones,
+		Valores:            make([][]float64, len(aux.Valores)),
+		NodosNoDisponibles: aux.NodosNoDisponibles,
+	}
+	for i, fila := range aux.Valores {
+		r.Valores[i] = make([]float64, len(fila))
+		for j, v := range fila {
+			r.Valores[i][j] = float64(v)
+		}
+	}
+	return nil
+}
+
+// resultadoAgregacionTemporalJSON es la forma JSON de ResultadoAgregacionTemporal (NaN como null)
+type resultadoAgregacionTemporalJSON struct {
+	Series             []string         `json:"series"`
+	Tiempos            []int64          `json:"tiempos"`
+	Agregaciones       []TipoAgregacion `json:"agregaciones"`
+	Valores            [][][]FloatNulo  `json:"valores"`
+	NodosNoDisponibles []string         `json:"nodos_no_disponibles,omitempty"`
+}
+
+// MarshalJSON serializa el resultado representando NaN como null
+func (r ResultadoAgregacionTemporal) MarshalJSON() ([]byte, error) {
+	aux := resultadoAgregacionTemporalJSON{
+		Series:             r.Series,
+		Tiempos:            r.Tiempos,
+		Agregaciones:       r.Agregaciones,
+		Valores:            make([][][]FloatNulo, len(r.Valores)),
+		NodosNoDisponibles: r.NodosNoDisponibles,
+	}
+	for i, buckets := range r.Valores {
+		aux.Valores[i] = make([][]FloatNulo, len(buckets))
+		for j, bucket := range buckets {
+			aux.Valores[i][j] = make([]FloatNulo, len(bucket))
+			for k, v := range bucket {
+				aux.Valores[i][j][k] = FloatNulo(v)
+			}
+		}
+	}
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON deserializa el resultado convirtiendo null en NaN
+func (r *ResultadoAgregacionTemporal) UnmarshalJSON(data []byte) error {
+	var aux resultadoAgregacionTemporalJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*r = ResultadoAgregacionTemporal{
+		Series:             aux.Series,
+		Tiempos:            aux.Tiempos,
+		Agregaciones:       aux.Agregaciones,
+		Valores:            make([][][]float64, len(aux.Valores)),
+		NodosNoDisponibles: aux.NodosNoDisponibles,
+	}
+	for i, buckets := range aux.Valores {
+		r.Valores[i] = make([][]float64, len(buckets))
+		for j, bucket := range buckets {
+			r.Valores[i][j] = make([]float64, len(bucket))
+			for k, v := range bucket {
+				r.Valores[i][j][k] = float64(v)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package tipos
+
+import "fmt"
+
+// ============================================================================
+// LATIDOS DE NODOS
+// Cada nodo edge actualiza periódicamente un objeto liviano en S3 para que el
+// despachador distinga los nodos activos de los que dejaron de funcionar, sin
+// reescribir el registro completo del nodo.
+// ============================================================================
+
+// Version es la versión de SensorWave que anuncian los nodos.
+// Se puede fijar al compilar con:
+//
+//	-ldflags "-X github.com/cbiale/sensorwave/tipos.Version=v1.2.3"
+var Version = "dev"
+
+// PrefijoS3Latidos es el prefijo de los objetos de latido en el bucket
+const PrefijoS3Latidos = "latidos/"
+
+// Latido es el contenido del objeto de latido de un nodo
+type Latido struct {
+	NodoID  string `json:"nodo_id"`
+	Momento int64  `json:"momento"` // Unix nanosegundos
+	Version string `json:"version,omitempty"`
+}
+
+// GenerarClaveS3Latido genera la clave del objeto de latido de un nodo.
+// Formato: latidos/{nodoID}.json
+func GenerarClaveS3Latido(nodoID string) string {
+	return fmt.Sprintf("%s%s.json", PrefijoS3Latidos, nodoID)
+}
@@ -0,0 +1,33 @@
+package tipos
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestGenerarClaveS3Latido verifica el formato de la clave del latido
+func TestGenerarClaveS3Latido(t *testing.T) {
+	if clave := GenerarClaveS3Latido("nodo-01"); clave != "latidos/nodo-01.json" {
+		t.Errorf("Clave esperada latidos/nodo-01.json, obtenida %s", clave)
+	}
+	t.Log("✓ GenerarClaveS3Latido genera latidos/{nodo}.json")
+}
+
+// TestNodo_UltimaConexionOmitida verifica que los registros sin latido mantienen su formato
+func TestNodo_UltimaConexionOmitida(t *testing.T) {
+	data, err := json.Marshal(Nodo{NodoID: "nodo-01"})
+	if err != nil {
+		t.Fatalf("Error serializando: %v", err)
+	}
+
+	var campos map[string]interface{}
+	if err := json.Unmarshal(data, &campos); err != nil {
+		t.Fatalf("Error deserializando: %v", err)
+	}
+	for _, campo := range []string{"ultima_conexion", "version"} {
+		if _, existe := campos[campo]; existe {
+			t.Errorf("El campo %s debería omitirse cuando está vacío", campo)
+		}
+	}
+	t.Log("✓ ultima_conexion y version se omiten en registros sin latido")
+}
@@ -0,0 +1,187 @@
+package tipos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ============================================================================
+// MANIFIESTO DE SERIE EN S3
+// Índice por serie mantenido por el edge durante la migración. Permite al
+// despachador ubicar los bloques de un rango con una sola lectura en lugar
+// de listar todos los bloques de la serie.
+// ============================================================================
+
+// BloqueManifiesto describe un bloque de datos migrado a S3
+type BloqueManifiesto struct {
+	Clave        string   `json:"clave"`            // Clave del objeto en S3
+	TiempoInicio int64    `json:"tiempo_inicio"`    // Tiempo de la primera medición (Unix nanosegundos)
+	TiempoFin    int64    `json:"tiempo_fin"`       // Tiempo de la última medición (Unix nanosegundos)
+	Tamaño       int64    `json:"tamano"`           // Tamaño del objeto comprimido en bytes
+	Mediciones   int      `json:"mediciones"`       // Cantidad de mediciones (0 si se desconoce)
+	Minimo       *float64 `json:"minimo,omitempty"` // Valor mínimo (solo series numéricas)
+	Maximo       *float64 `json:"maximo,omitempty"` // Valor máximo (solo series numéricas)
+	ETag         string   `json:"etag,omitempty"`   // ETag del objeto en S3 (vacío si se desconoce)
+}
+
+// ManifiestoSerie es el índice de bloques de una serie en S3, ordenado por TiempoInicio
+type ManifiestoSerie struct {
+	NodoID      string             `json:"nodo_id"`
+	SerieId     int                `json:"serie_id"`
+	Path        string             `json:"path"`
+	Actualizado int64              `json:"actualizado"` // Última actualización (Unix nanosegundos)
+	Bloques     []BloqueManifiesto `json:"bloques"`
+}
+
+// GenerarClaveS3Manifiesto genera la clave del manifiesto de una serie.
+// Formato: {nodoID}/{serieId}_manifiesto.json
+// Es la misma para ambas disposiciones de claves y comparte el prefijo de la
+// disposición plana, por lo que se elimina junto con los bloques de la serie.
+func GenerarClaveS3Manifiesto(nodoID string, serieId int) string {
+	return fmt.Sprintf("%smanifiesto.json", GenerarPrefijoS3Serie(nodoID, serieId))
+}
+
+// NuevoBloqueManifiesto crea la entrada de un bloque calculando sus estadísticas.
+// Los mínimos y máximos solo se calculan para valores numéricos.
+func NuevoBloqueManifiesto(clave string, tiempoInicio, tiempoFin, tamaño int64, mediciones []Medicion) BloqueManifiesto {
+	bloque := BloqueManifiesto{
+		Clave:        clave,
+		TiempoInicio: tiempoInicio,
+		TiempoFin:    tiempoFin,
+		Tamaño:       tamaño,
+		Mediciones:   len(mediciones),
+	}
+
+	for _, m := range mediciones {
+		var v float64
+		switch valor := m.Valor.(type) {
+		case float64:
+			v = valor
+		case int64:
+			v = float64(valor)
+		default:
+			continue
+		}
+		if bloque.Minimo == nil || v < *bloque.Minimo {
+			minimo := v
+			bloque.Minimo = &minimo
+		}
+		if bloque.Maximo == nil || v > *bloque.Maximo {
+			maximo := v
+			bloque.Maximo = &maximo
+		}
+	}
+
+	return bloque
+}
+
+// AgregarBloques incorpora bloques al manifiesto reemplazando los de igual clave
+// y manteniendo el orden por TiempoInicio
+func (m *ManifiestoSerie) AgregarBloques(bloques ...BloqueManifiesto) {
+	indice := make(map[string]int, len(m.Bloques))
+	for i, b := range m.Bloques {
+		indice[b.Clave] = i
+	}
+	for _, b := range bloques {
+		if i, existe := indice[b.Clave]; existe {
+			m.Bloques[i] = b
+			continue
+		}
+		indice[b.Clave] = len(m.Bloques)
+		m.Bloques = append(m.Bloques, b)
+	}
+
+	sort.Slice(m.Bloques, func(i, j int) bool {
+		if m.Bloques[i].TiempoInicio != m.Bloques[j].TiempoInicio {
+			return m.Bloques[i].TiempoInicio < m.Bloques[j].TiempoInicio
+		}
+		return m.Bloques[i].Clave < m.Bloques[j].Clave
+	})
+}
+
+// BloquesEnRango retorna los bloques que intersectan con [inicio, fin], ordenados por tiempo
+func (m ManifiestoSerie) BloquesEnRango(inicio, fin int64) []BloqueManifiesto {
+	var resultado []BloqueManifiesto
+	for _, b := range m.Bloques {
+		if b.TiempoInicio > fin {
+			break // Ordenados por inicio: los siguientes tampoco intersectan
+		}
+		if b.TiempoFin >= inicio {
+			resultado = append(resultado, b)
+		}
+	}
+	return resultado
+}
+
+// ConstruirManifiestoDesdeListado reconstruye el manifiesto de una serie listando sus
+// bloques en ambas disposiciones de claves. Las estadísticas de los bloques quedan
+// vacías porque solo se dispone de la clave y el tamaño de cada objeto.
+func ConstruirManifiestoDesdeListado(ctx context.Context, almacenamiento Almacenamiento, nodoID string, serieId int, path string) (*ManifiestoSerie, error) {
+	var objetos []ObjetoAlmacenado
+	for _, disposicion := range []DisposicionClaves{DisposicionPlana, DisposicionParticionada} {
+		encontrados, err := ListarObjetos(ctx, almacenamiento, disposicion.GenerarPrefijoSerie(nodoID, serieId))
+		if err != nil {
+			return nil, fmt.Errorf("error listando bloques de la serie %d: %v", serieId, err)
+		}
+		objetos = append(objetos, encontrados...)
+	}
+
+	manifiesto := &ManifiestoSerie{NodoID: nodoID, SerieId: serieId, Path: path}
+	var bloques []BloqueManifiesto
+	for _, obj := range objetos {
+		_, inicio, fin, err := ParsearClaveS3Datos(obj.Clave)
+		if err != nil {
+			continue // El propio manifiesto u objetos ajenos
+		}
+		bloques = append(bloques, BloqueManifiesto{
+			Clave:        obj.Clave,
+			TiempoInicio: inicio,
+			TiempoFin:    fin,
+			Tamaño:       obj.Tamaño,
+			ETag:         obj.ETag,
+		})
+	}
+	manifiesto.AgregarBloques(bloques...)
+
+	return manifiesto, nil
+}
+
+// LeerManifiesto descarga y deserializa el manifiesto de una serie
+func LeerManifiesto(ctx context.Context, almacenamiento Almacenamiento, nodoID string, serieId int) (*ManifiestoSerie, error) {
+	clave := GenerarClaveS3Manifiesto(nodoID, serieId)
+	datos, _, err := almacenamiento.Leer(ctx, clave, "")
+	if err != nil {
+		return nil, fmt.Errorf("error descargando manifiesto %s: %v", clave, err)
+	}
+
+	var manifiesto ManifiestoSerie
+	if err := json.Unmarshal(datos, &manifiesto); err != nil {
+		return nil, fmt.Errorf("error deserializando manifiesto %s: %v", clave, err)
+	}
+	if manifiesto.NodoID != nodoID || manifiesto.SerieId != serieId {
+		return nil, fmt.Errorf("manifiesto %s no corresponde a la serie %d del nodo %s", clave, serieId, nodoID)
+	}
+
+	return &manifiesto, nil
+}
+
+// GuardarManifiesto serializa y sube el manifiesto de una serie
+func GuardarManifiesto(ctx context.Context, almacenamiento Almacenamiento, manifiesto *ManifiestoSerie) error {
+	manifiesto.Actualizado = time.Now().UnixNano()
+
+	datos, err := json.Marshal(manifiesto)
+	if err != nil {
+		return fmt.Errorf("error serializando manifiesto: %v", err)
+	}
+
+	clave := GenerarClaveS3Manifiesto(manifiesto.NodoID, manifiesto.SerieId)
+	_, err = almacenamiento.Guardar(ctx, clave, datos, OpcionesGuardar{TipoContenido: "application/json"})
+	if err != nil {
+		return fmt.Errorf("error subiendo manifiesto %s: %v", clave, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package tipos_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ==================== Tests de ManifiestoSerie ====================
+
+// TestNuevoBloqueManifiesto_Estadisticas verifica el cálculo de estadísticas del bloque
+func TestNuevoBloqueManifiesto_Estadisticas(t *testing.T) {
+	mediciones := []tipos.Medicion{
+		{Tiempo: 1000, Valor: float64(20.5)},
+		{Tiempo: 2000, Valor: float64(-3)},
+		{Tiempo: 3000, Valor: float64(42)},
+	}
+
+	bloque := tipos.NuevoBloqueManifiesto("n/clave", 1000, 3000, 128, mediciones)
+	if bloque.Mediciones != 3 || bloque.Tamaño != 128 {
+		t.Errorf("Campos incorrectos: %+v", bloque)
+	}
+	if bloque.Minimo == nil || *bloque.Minimo != -3 {
+		t.Errorf("Mínimo esperado -3, obtenido %v", bloque.Minimo)
+	}
+	if bloque.Maximo == nil || *bloque.Maximo != 42 {
+		t.Errorf("Máximo esperado 42, obtenido %v", bloque.Maximo)
+	}
+
+	// Series no numéricas: sin mínimo ni máximo
+	texto := tipos.NuevoBloqueManifiesto("n/clave", 1000, 1000, 10, []tipos.Medicion{{Tiempo: 1000, Valor: "on"}})
+	if texto.Minimo != nil || texto.Maximo != nil || texto.Mediciones != 1 {
+		t.Errorf("Estadísticas inesperadas para texto: %+v", texto)
+	}
+	t.Log("✓ NuevoBloqueManifiesto calcula mediciones, mínimo y máximo")
+}
+
+// TestManifiestoSerie_AgregarBloquesYRango verifica orden, reemplazo y búsqueda por rango
+func TestManifiestoSerie_AgregarBloquesYRango(t *testing.T) {
+	var m tipos.ManifiestoSerie
+	m.AgregarBloques(
+		tipos.BloqueManifiesto{Clave: "c", TiempoInicio: 3000, TiempoFin: 3999},
+		tipos.BloqueManifiesto{Clave: "a", TiempoInicio: 1000, TiempoFin: 1999},
+	)
+	m.AgregarBloques(
+		tipos.BloqueManifiesto{Clave: "b", TiempoInicio: 2000, TiempoFin: 2999},
+		tipos.BloqueManifiesto{Clave: "a", TiempoInicio: 1000, TiempoFin: 1999, Mediciones: 7},
+	)
+
+	if len(m.Bloques) != 3 {
+		t.Fatalf("Esperados 3 bloques, obtenidos %d", len(m.Bloques))
+	}
+	if m.Bloques[0].Clave != "a" || m.Bloques[1].Clave != "b" || m.Bloques[2].Clave != "c" {
+		t.Errorf("Orden incorrecto: %+v", m.Bloques)
+	}
+	if m.Bloques[0].Mediciones != 7 {
+		t.Error("El bloque con clave repetida debe reemplazarse")
+	}
+
+	enRango := m.BloquesEnRango(1500, 2500)
+	if len(enRango) != 2 || enRango[0].Clave != "a" || enRango[1].Clave != "b" {
+		t.Errorf("Bloques en rango incorrectos: %+v", enRango)
+	}
+	if len(m.BloquesEnRango(5000, 6000)) != 0 {
+		t.Error("No debería haber bloques fuera de rango")
+	}
+	t.Log("✓ ManifiestoSerie mantiene el orden y filtra por rango")
+}
+
+// TestManifiesto_GuardarLeerYReconstruir verifica el ciclo completo contra S3
+func TestManifiesto_GuardarLeerYReconstruir(t *testing.T) {
+	ctx := context.Background()
+	cliente, almacenamiento := nuevoS3FakeTest(1)
+
+	// Sin manifiesto: la lectura falla y se reconstruye desde el listado
+	sembrarObjetoTest(t, cliente, tipos.GenerarClaveS3Datos("nodo-1", 5, 1000, 2000), "bloque1")
+	sembrarObjetoTest(t, cliente, tipos.GenerarClaveS3Datos("nodo-1", 5, 3000, 4000), "bloque-2")
+	sembrarObjetoTest(t, cliente, tipos.GenerarClaveS3Datos("nodo-1", 6, 1000, 2000), "otra serie")
+
+	if _, err := tipos.LeerManifiesto(ctx, almacenamiento, "nodo-1", 5); err == nil {
+		t.Fatal("Se esperaba error sin manifiesto")
+	}
+
+	manifiesto, err := tipos.ConstruirManifiestoDesdeListado(ctx, almacenamiento, "nodo-1", 5, "sensor/temp")
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if len(manifiesto.Bloques) != 2 || manifiesto.Bloques[1].Tamaño != 8 {
+		t.Errorf("Manifiesto reconstruido incorrecto: %+v", manifiesto.Bloques)
+	}
+
+	if err := tipos.GuardarManifiesto(ctx, almacenamiento, manifiesto); err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+
+	leido, err := tipos.LeerManifiesto(ctx, almacenamiento, "nodo-1", 5)
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if leido.Path != "sensor/temp" || len(leido.Bloques) != 2 || leido.Actualizado == 0 {
+		t.Errorf("Manifiesto leído incorrecto: %+v", leido)
+	}
+
+	// El manifiesto no se confunde con un bloque al reconstruir
+	reconstruido, err := tipos.ConstruirManifiestoDesdeListado(ctx, almacenamiento, "nodo-1", 5, "sensor/temp")
+	if err != nil || len(reconstruido.Bloques) != 2 {
+		t.Errorf("El manifiesto no debe listarse como bloque: %+v, %v", reconstruido, err)
+	}
+	t.Log("✓ Manifiesto se guarda, se lee y se reconstruye desde el listado")
+}
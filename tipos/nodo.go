@@ -13,6 +13,40 @@ type Nodo struct {
 	Series     map[string]Serie  `json:"series"`           // Lista de series gestionadas por el nodo
 	Tags       map[string]string `json:"tags,omitempty"`   // Metadatos libres del nodo (nombre, ubicación, etc.)
 	Reglas     []Regla           `json:"reglas,omitempty"` // Lista de reglas del motor de reglas
+
+	// Disposición de las claves de los bloques migrados a S3 (vacío = plana)
+	DisposicionClaves DisposicionClaves `json:"disposicion_claves,omitempty"`
+
+	UltimaConexion int64  `json:"ultima_conexion,omitempty"` // Último registro o latido del nodo (Unix nanosegundos)
+	Version        string `json:"version,omitempty"`         // Versión de SensorWave del nodo
+
+	Esquema           string `json:"esquema,omitempty"`            // Esquema de la API REST: "http" (vacío) o "https"
+	HuellaCertificado string `json:"huella_certificado,omitempty"` // SHA-256 del certificado TLS autofirmado (hex)
+
+	// Tunel indica que el nodo no acepta conexiones entrantes: el despachador lo
+	// consulta por el túnel que el nodo abre hacia él (ver RutaTunel)
+	Tunel bool `json:"tunel,omitempty"`
+
+	ClavePublica string `json:"clave_publica,omitempty"` // Clave pública Ed25519 del nodo (base64)
+	Firma        string `json:"firma,omitempty"`         // Firma Ed25519 del registro (base64)
+}
+
+// URLBase retorna la URL base de la API REST del nodo según su esquema.
+// La dirección puede incluir el esquema ("http://host:puerto") o no ("host:puerto").
+// Un nodo accesible por túnel sin dirección usa su nodoID como host: la URL solo
+// identifica al nodo y la conexión la provee el túnel.
+func (n Nodo) URLBase() string {
+	if n.Tunel && n.Direccion == "" {
+		return "http://" + n.NodoID
+	}
+	esquema := n.Esquema
+	if esquema == "" {
+		esquema = "http"
+	}
+	if _, host, ok := strings.Cut(n.Direccion, "://"); ok {
+		return esquema + "://" + host
+	}
+	return esquema + "://" + n.Direccion
 }
 
 // Regla representa una regla del motor de reglas (versión serializable)
@@ -27,12 +61,13 @@ type Regla struct {
 
 // Condicion representa una condición de una regla
 type Condicion struct {
-	Path          string      `json:"path"`
-	VentanaT      string      `json:"ventana_t"`  // ej: "5m", "1h"
-	Agregacion    string      `json:"agregacion"` // "promedio", "maximo", etc.
-	Operador      string      `json:"operador"`   // ">=", "<", "==", etc.
-	Valor         interface{} `json:"valor"`      // número, string, bool
-	AgregarSeries bool        `json:"agregar_series"`
+	Path          string          `json:"path"`
+	VentanaT      string          `json:"ventana_t"`  // ej: "5m", "1h"
+	Agregacion    string          `json:"agregacion"` // "promedio", "maximo", etc.
+	Operador      string          `json:"operador"`   // ">=", "<", "==", etc.
+	Valor         interface{}     `json:"valor"`      // número, string, bool
+	AgregarSeries bool            `json:"agregar_series"`
+	Ventana       *FuncionVentana `json:"ventana,omitempty"` // Función de ventana opcional
 }
 
 // Accion representa una acción de una regla
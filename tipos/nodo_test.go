@@ -0,0 +1,28 @@
+package tipos
+
+import "testing"
+
+// TestNodo_URLBase verifica la URL base según el esquema y la dirección del nodo
+func TestNodo_URLBase(t *testing.T) {
+	casos := []struct {
+		nombre   string
+		nodo     Nodo
+		esperada string
+	}{
+		{"sin esquema", Nodo{Direccion: "10.0.0.1:8080"}, "http://10.0.0.1:8080"},
+		{"dirección con esquema", Nodo{Direccion: "http://10.0.0.1:8080"}, "http://10.0.0.1:8080"},
+		{"https sin esquema en la dirección", Nodo{Direccion: "10.0.0.1:8443", Esquema: EsquemaHTTPS}, "https://10.0.0.1:8443"},
+		{"https reemplaza el esquema de la dirección", Nodo{Direccion: "http://nodo.local:8443", Esquema: EsquemaHTTPS}, "https://nodo.local:8443"},
+		{"túnel sin dirección", Nodo{NodoID: "nodo_1", Tunel: true}, "http://nodo_1"},
+		{"túnel con dirección", Nodo{NodoID: "nodo_1", Direccion: "10.0.0.1:8080", Tunel: true}, "http://10.0.0.1:8080"},
+	}
+
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if url := c.nodo.URLBase(); url != c.esperada {
+				t.Errorf("URL esperada %s, obtenida %s", c.esperada, url)
+			}
+		})
+	}
+	t.Log("✓ URLBase construye la URL con el esquema del nodo")
+}
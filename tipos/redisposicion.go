@@ -0,0 +1,191 @@
+package tipos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// ============================================================================
+// REDISPOSICIÓN DE CLAVES
+// Reorganiza los bloques existentes del almacenamiento a otra disposición de claves.
+// ============================================================================
+
+// OpcionesRedisposicion configura una reorganización de claves
+type OpcionesRedisposicion struct {
+	NodoID  string            // Opcional: limita la reorganización a un nodo
+	Destino DisposicionClaves // Disposición final de los bloques
+	Simular bool              // Solo informa los cambios sin modificar el almacenamiento
+}
+
+// ResumenRedisposicion informa el resultado de una reorganización
+type ResumenRedisposicion struct {
+	Movidos     int // Bloques copiados a la nueva clave y eliminados de la anterior
+	Omitidos    int // Bloques que ya estaban en la disposición destino
+	Manifiestos int // Manifiestos de series actualizados
+	Errores     int // Bloques o series que no pudieron procesarse
+}
+
+// serieRedisposicion identifica una serie de un nodo
+type serieRedisposicion struct {
+	nodoID  string
+	serieId int
+}
+
+// RedisponerBloques mueve los bloques de datos del almacenamiento a la disposición destino.
+// Por cada serie: copia los bloques a sus nuevas claves, actualiza el manifiesto para
+// que apunte a ellas y recién entonces elimina las claves anteriores, de modo que las
+// consultas encuentran los datos durante todo el proceso.
+// Es idempotente: puede reejecutarse si se interrumpe. Conviene ejecutarla con la
+// migración de los nodos edge detenida para no competir por los manifiestos.
+func RedisponerBloques(ctx context.Context, almacenamiento Almacenamiento, opts OpcionesRedisposicion) (ResumenRedisposicion, error) {
+	var resumen ResumenRedisposicion
+
+	if err := opts.Destino.Validar(); err != nil {
+		return resumen, err
+	}
+	destino := opts.Destino
+	if destino == "" {
+		destino = DisposicionPlana
+	}
+
+	prefijo := ""
+	if opts.NodoID != "" {
+		prefijo = opts.NodoID + "/"
+	}
+
+	objetos, err := ListarObjetos(ctx, almacenamiento, prefijo)
+	if err != nil {
+		return resumen, fmt.Errorf("error listando bloques: %v", err)
+	}
+
+	// Agrupar por serie los bloques que deben moverse
+	pendientes := make(map[serieRedisposicion][]ClaveS3Datos)
+	clavesOriginales := make(map[ClaveS3Datos]string)
+	for _, obj := range objetos {
+		c, err := ParsearClaveS3DatosCompleta(obj.Clave)
+		if err != nil {
+			continue // Registros de nodos, manifiestos u objetos ajenos
+		}
+		if c.Disposicion == destino {
+			resumen.Omitidos++
+			continue
+		}
+		s := serieRedisposicion{nodoID: c.NodoID, serieId: c.SerieId}
+		pendientes[s] = append(pendientes[s], c)
+		clavesOriginales[c] = obj.Clave
+	}
+
+	// Orden determinístico para que los logs sean reproducibles
+	series := make([]serieRedisposicion, 0, len(pendientes))
+	for s := range pendientes {
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].nodoID != series[j].nodoID {
+			return series[i].nodoID < series[j].nodoID
+		}
+		return series[i].serieId < series[j].serieId
+	})
+
+	for _, s := range series {
+		bloques := pendientes[s]
+
+		if opts.Simular {
+			for _, c := range bloques {
+				log.Printf("[simulación] %s -> %s", clavesOriginales[c],
+					destino.GenerarClaveDatos(c.NodoID, c.SerieId, c.TiempoInicio, c.TiempoFin))
+			}
+			resumen.Movidos += len(bloques)
+			continue
+		}
+
+		movidos, err := redisponerSerie(ctx, almacenamiento, destino, s, bloques, clavesOriginales)
+		resumen.Movidos += movidos
+		if err != nil {
+			log.Printf("Error reorganizando serie %d del nodo %s: %v", s.serieId, s.nodoID, err)
+			resumen.Errores++
+			continue
+		}
+		resumen.Manifiestos++
+	}
+
+	return resumen, nil
+}
+
+// redisponerSerie mueve los bloques de una serie y actualiza su manifiesto.
+// Retorna la cantidad de bloques movidos (eliminados de la clave anterior).
+func redisponerSerie(ctx context.Context, almacenamiento Almacenamiento, destino DisposicionClaves,
+	s serieRedisposicion, bloques []ClaveS3Datos, clavesOriginales map[ClaveS3Datos]string) (int, error) {
+
+	// 1. Copiar cada bloque a su nueva clave
+	nuevasClaves := make(map[string]string, len(bloques))
+	nuevosETags := make(map[string]string, len(bloques))
+	for _, c := range bloques {
+		origen := clavesOriginales[c]
+		nueva := destino.GenerarClaveDatos(c.NodoID, c.SerieId, c.TiempoInicio, c.TiempoFin)
+		etag, err := copiarObjeto(ctx, almacenamiento, origen, nueva)
+		if err != nil {
+			return 0, err
+		}
+		nuevasClaves[origen] = nueva
+		nuevosETags[nueva] = etag
+	}
+
+	// 2. Actualizar el manifiesto (o construirlo desde el listado si no existe)
+	manifiesto, err := LeerManifiesto(ctx, almacenamiento, s.nodoID, s.serieId)
+	if err != nil {
+		manifiesto, err = ConstruirManifiestoDesdeListado(ctx, almacenamiento, s.nodoID, s.serieId, "")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// El listado puede incluir ambas copias de un bloque: se conserva una entrada por clave nueva
+	bloquesManifiesto := make([]BloqueManifiesto, 0, len(manifiesto.Bloques))
+	vistas := make(map[string]bool, len(manifiesto.Bloques))
+	for _, b := range manifiesto.Bloques {
+		if nueva, movido := nuevasClaves[b.Clave]; movido {
+			b.Clave = nueva
+			b.ETag = nuevosETags[nueva]
+		}
+		if vistas[b.Clave] {
+			continue
+		}
+		vistas[b.Clave] = true
+		bloquesManifiesto = append(bloquesManifiesto, b)
+	}
+	manifiesto.Bloques = nil
+	manifiesto.AgregarBloques(bloquesManifiesto...)
+
+	if err := GuardarManifiesto(ctx, almacenamiento, manifiesto); err != nil {
+		return 0, err
+	}
+
+	// 3. Eliminar las claves anteriores, ya sin referencias en el manifiesto
+	movidos := 0
+	for origen := range nuevasClaves {
+		if err := almacenamiento.Eliminar(ctx, origen); err != nil {
+			return movidos, fmt.Errorf("error eliminando %s: %v", origen, err)
+		}
+		movidos++
+	}
+
+	return movidos, nil
+}
+
+// copiarObjeto copia un objeto descargándolo y subiéndolo con la nueva clave.
+// Retorna el ETag de la copia (vacío si el almacenamiento no lo informa).
+func copiarObjeto(ctx context.Context, almacenamiento Almacenamiento, origen, destino string) (string, error) {
+	datos, _, err := almacenamiento.Leer(ctx, origen, "")
+	if err != nil {
+		return "", fmt.Errorf("error descargando %s: %v", origen, err)
+	}
+
+	etag, err := almacenamiento.Guardar(ctx, destino, datos, OpcionesGuardar{})
+	if err != nil {
+		return "", fmt.Errorf("error subiendo %s: %v", destino, err)
+	}
+	return etag, nil
+}
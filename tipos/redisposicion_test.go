@@ -0,0 +1,84 @@
+package tipos_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// ==================== Tests de RedisponerBloques ====================
+
+// TestRedisponerBloques_PlanaAParticionada verifica el movimiento de bloques y manifiestos
+func TestRedisponerBloques_PlanaAParticionada(t *testing.T) {
+	ctx := context.Background()
+	cliente, almacenamiento := nuevoS3FakeTest(2)
+
+	// Serie con manifiesto (estadísticas) y serie sin manifiesto
+	manifiesto := &tipos.ManifiestoSerie{NodoID: "nodo-1", SerieId: 1, Path: "sensor/temp"}
+	for _, inicio := range []int64{1000, 2000} {
+		clave := tipos.GenerarClaveS3Datos("nodo-1", 1, inicio, inicio+500)
+		sembrarObjetoTest(t, cliente, clave, "datos")
+		manifiesto.AgregarBloques(tipos.NuevoBloqueManifiesto(clave, inicio, inicio+500, 5,
+			[]tipos.Medicion{{Tiempo: inicio, Valor: float64(inicio)}}))
+	}
+	if err := tipos.GuardarManifiesto(ctx, almacenamiento, manifiesto); err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	sembrarObjetoTest(t, cliente, tipos.GenerarClaveS3Datos("nodo-1", 2, 3000, 4000), "otra")
+	sembrarObjetoTest(t, cliente, "nodos/nodo-1.json", "{}")
+
+	// Simulación: no modifica el bucket
+	resumen, err := tipos.RedisponerBloques(ctx, almacenamiento, tipos.OpcionesRedisposicion{Destino: tipos.DisposicionParticionada, Simular: true})
+	if err != nil || resumen.Movidos != 3 {
+		t.Fatalf("Simulación inesperada: %+v, %v", resumen, err)
+	}
+	if _, existe := cliente.Objeto(bucketTest, tipos.GenerarClaveS3Datos("nodo-1", 1, 1000, 1500)); !existe {
+		t.Fatal("La simulación no debe mover bloques")
+	}
+
+	resumen, err = tipos.RedisponerBloques(ctx, almacenamiento, tipos.OpcionesRedisposicion{Destino: tipos.DisposicionParticionada})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if resumen.Movidos != 3 || resumen.Manifiestos != 2 || resumen.Errores != 0 {
+		t.Errorf("Resumen inesperado: %+v", resumen)
+	}
+
+	for _, clave := range cliente.Claves(bucketTest, "") {
+		if c, err := tipos.ParsearClaveS3DatosCompleta(clave); err == nil && c.Disposicion != tipos.DisposicionParticionada {
+			t.Errorf("Bloque sin reorganizar: %s", clave)
+		}
+	}
+	if _, existe := cliente.Objeto(bucketTest, "nodos/nodo-1.json"); !existe {
+		t.Error("Los objetos que no son bloques no deben modificarse")
+	}
+
+	// El manifiesto apunta a las nuevas claves y conserva las estadísticas
+	leido, err := tipos.LeerManifiesto(ctx, almacenamiento, "nodo-1", 1)
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if len(leido.Bloques) != 2 || leido.Path != "sensor/temp" {
+		t.Fatalf("Manifiesto inesperado: %+v", leido)
+	}
+	for _, b := range leido.Bloques {
+		if !strings.HasPrefix(b.Clave, tipos.GenerarPrefijoS3SerieParticionada("nodo-1", 1)) || b.Maximo == nil {
+			t.Errorf("Bloque de manifiesto incorrecto: %+v", b)
+		}
+	}
+
+	// La serie sin manifiesto obtiene uno construido desde el listado
+	otro, err := tipos.LeerManifiesto(ctx, almacenamiento, "nodo-1", 2)
+	if err != nil || len(otro.Bloques) != 1 {
+		t.Errorf("Manifiesto construido inesperado: %+v, %v", otro, err)
+	}
+
+	// Reejecutar no tiene efecto
+	resumen, err = tipos.RedisponerBloques(ctx, almacenamiento, tipos.OpcionesRedisposicion{Destino: tipos.DisposicionParticionada})
+	if err != nil || resumen.Movidos != 0 || resumen.Omitidos != 3 {
+		t.Errorf("Reejecución inesperada: %+v, %v", resumen, err)
+	}
+	t.Log("✓ RedisponerBloques reorganiza bloques y actualiza manifiestos")
+}
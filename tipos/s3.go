@@ -2,18 +2,21 @@ package tipos
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // ConfiguracionS3 contiene la configuración para conectar con almacenamiento S3-compatible
-// (Garage, AWS S3, Cloudflare R2, MinIO, etc.).
+// (Garage, AWS S3, Cloudflare R2, MinIO, etc.) o, con Directorio, con un directorio local.
 // Esta estructura es compartida entre:
 // - edge: para migración de datos de series a la nube
 // - despachador: para registro y descubrimiento de nodos
@@ -23,10 +26,22 @@ type ConfiguracionS3 struct {
 	SecretAccessKey string // Secret Access Key de S3
 	Bucket          string // Nombre del bucket (edge: datos, despachador: nodos)
 	Region          string // Región (puede ser cualquier valor para implementaciones como Garage)
+
+	// DisposicionClaves define cómo se nombran los bloques migrados (edge).
+	// Opcional: vacío equivale a DisposicionPlana.
+	DisposicionClaves DisposicionClaves
+
+	// Directorio, si no es vacío, guarda los objetos en este directorio (local o montado
+	// por NFS y compartido por edge y despachador) en lugar de un servidor S3. Endpoint,
+	// credenciales, Bucket y Region no se usan.
+	Directorio string
 }
 
 // Validar verifica que todos los campos requeridos estén presentes
 func (cfg ConfiguracionS3) Validar() error {
+	if cfg.Directorio != "" {
+		return cfg.DisposicionClaves.Validar()
+	}
 	if cfg.Endpoint == "" {
 		return fmt.Errorf("Endpoint es requerido")
 	}
@@ -40,6 +55,9 @@ func (cfg ConfiguracionS3) Validar() error {
 		return fmt.Errorf("Bucket es requerido")
 	}
 	// Region es opcional, se aplica default "us-east-1" si está vacío
+	if err := cfg.DisposicionClaves.Validar(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -93,6 +111,105 @@ func CrearClienteS3(cfg ConfiguracionS3) (*s3.Client, error) {
 	}), nil
 }
 
+// ListarObjetosS3 lista todos los objetos con el prefijo dado siguiendo los tokens
+// de continuación. ListObjectsV2 retorna como máximo 1000 objetos por llamada,
+// por lo que una única llamada pierde objetos en prefijos grandes.
+func ListarObjetosS3(ctx context.Context, cliente ClienteS3, bucket, prefijo string) ([]s3types.Object, error) {
+	var objetos []s3types.Object
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefijo),
+	}
+	err := RecorrerObjetosS3(ctx, cliente, input, func(obj s3types.Object) bool {
+		objetos = append(objetos, obj)
+		return true
+	})
+	return objetos, err
+}
+
+// RecorrerObjetosS3 recorre en orden los objetos de un listado, página por página,
+// invocando fn para cada uno. El recorrido termina cuando fn retorna false o no quedan
+// páginas. El input se modifica con los tokens de continuación.
+func RecorrerObjetosS3(ctx context.Context, cliente ClienteS3, input *s3.ListObjectsV2Input, fn func(s3types.Object) bool) error {
+	for {
+		resultado, err := cliente.ListObjectsV2(ctx, input)
+		if err != nil {
+			return err
+		}
+		if resultado == nil {
+			return nil
+		}
+		for _, obj := range resultado.Contents {
+			if !fn(obj) {
+				return nil
+			}
+		}
+
+		// Sin token no es posible continuar aunque la respuesta indique truncamiento
+		if resultado.IsTruncated == nil || !*resultado.IsTruncated || resultado.NextContinuationToken == nil {
+			return nil
+		}
+		input.ContinuationToken = resultado.NextContinuationToken
+	}
+}
+
+// EsObjetoInexistente indica si el error de GetObject o de Almacenamiento.Leer se debe a
+// que la clave no existe
+func EsObjetoInexistente(err error) bool {
+	var noExiste *s3types.NoSuchKey
+	return errors.Is(err, ErrObjetoInexistente) || errors.As(err, &noExiste)
+}
+
+// ============================================================================
+// DISPOSICIÓN DE CLAVES DE DATOS
+// ============================================================================
+
+// DisposicionClaves define el formato de las claves de bloques de datos en S3
+type DisposicionClaves string
+
+const (
+	// DisposicionPlana: {nodoID}/{serieId}_{tiempoInicio}_{tiempoFin}
+	// Todos los bloques de una serie comparten un único prefijo.
+	DisposicionPlana DisposicionClaves = "plana"
+
+	// DisposicionParticionada: {nodoID}/{serieId}/{yyyy}/{mm}/{dd}/{serieId}_{tiempoInicio}_{tiempoFin}
+	// La fecha (UTC) es la de tiempoFin: un bloque que intersecta con [inicio, fin] está en la
+	// partición del día de inicio o en una posterior, por lo que los listados por rango
+	// comienzan en esa partición en lugar de recorrer toda la historia de la serie.
+	DisposicionParticionada DisposicionClaves = "particionada"
+)
+
+// Validar verifica que la disposición sea conocida (vacío equivale a plana)
+func (d DisposicionClaves) Validar() error {
+	switch d {
+	case "", DisposicionPlana, DisposicionParticionada:
+		return nil
+	default:
+		return fmt.Errorf("disposición de claves no soportada: %s (use: plana, particionada)", d)
+	}
+}
+
+// EsParticionada indica si la disposición usa particiones por fecha
+func (d DisposicionClaves) EsParticionada() bool {
+	return d == DisposicionParticionada
+}
+
+// GenerarClaveDatos genera la clave de un bloque según la disposición
+func (d DisposicionClaves) GenerarClaveDatos(nodoID string, serieId int, tiempoInicio, tiempoFin int64) string {
+	if d.EsParticionada() {
+		return GenerarClaveS3DatosParticionada(nodoID, serieId, tiempoInicio, tiempoFin)
+	}
+	return GenerarClaveS3Datos(nodoID, serieId, tiempoInicio, tiempoFin)
+}
+
+// GenerarPrefijoSerie genera el prefijo que agrupa los bloques de una serie según la disposición
+func (d DisposicionClaves) GenerarPrefijoSerie(nodoID string, serieId int) string {
+	if d.EsParticionada() {
+		return GenerarPrefijoS3SerieParticionada(nodoID, serieId)
+	}
+	return GenerarPrefijoS3Serie(nodoID, serieId)
+}
+
 // GenerarClaveS3Datos genera la clave para un bloque de datos en S3
 // Formato: {nodoID}/{serieId}_{tiempoInicio}_{tiempoFin}
 func GenerarClaveS3Datos(nodoID string, serieId int, tiempoInicio, tiempoFin int64) string {
@@ -105,18 +222,88 @@ func GenerarPrefijoS3Serie(nodoID string, serieId int) string {
 	return fmt.Sprintf("%s/%010d_", nodoID, serieId)
 }
 
-// ParsearClaveS3Datos extrae los componentes de una clave S3
+// GenerarClaveS3DatosParticionada genera la clave de un bloque particionada por fecha (UTC) de tiempoFin
+// Formato: {nodoID}/{serieId}/{yyyy}/{mm}/{dd}/{serieId}_{tiempoInicio}_{tiempoFin}
+func GenerarClaveS3DatosParticionada(nodoID string, serieId int, tiempoInicio, tiempoFin int64) string {
+	return fmt.Sprintf("%s%010d_%020d_%020d", GenerarPrefijoS3Particion(nodoID, serieId, tiempoFin), serieId, tiempoInicio, tiempoFin)
+}
+
+// GenerarPrefijoS3SerieParticionada genera el prefijo de una serie en la disposición particionada
+// Formato: {nodoID}/{serieId}/
+func GenerarPrefijoS3SerieParticionada(nodoID string, serieId int) string {
+	return fmt.Sprintf("%s/%010d/", nodoID, serieId)
+}
+
+// GenerarPrefijoS3Particion genera el prefijo de la partición diaria (UTC) que contiene el tiempo dado
+// Formato: {nodoID}/{serieId}/{yyyy}/{mm}/{dd}/
+func GenerarPrefijoS3Particion(nodoID string, serieId int, tiempo int64) string {
+	fecha := time.Unix(0, tiempo).UTC()
+	return fmt.Sprintf("%s%04d/%02d/%02d/", GenerarPrefijoS3SerieParticionada(nodoID, serieId),
+		fecha.Year(), int(fecha.Month()), fecha.Day())
+}
+
+// ClaveS3Datos contiene los componentes de una clave de bloque de datos en S3
+type ClaveS3Datos struct {
+	NodoID       string
+	SerieId      int
+	TiempoInicio int64
+	TiempoFin    int64
+	Disposicion  DisposicionClaves
+}
+
+// ParsearClaveS3Datos extrae los componentes de una clave S3 en cualquiera de las disposiciones
 // Entrada: {nodoID}/{serieId}_{tiempoInicio}_{tiempoFin}
+// o {nodoID}/{serieId}/{yyyy}/{mm}/{dd}/{serieId}_{tiempoInicio}_{tiempoFin}
 // Retorna serieId, tiempoInicio, tiempoFin o error si el formato es inválido
 func ParsearClaveS3Datos(clave string) (serieId int, tiempoInicio, tiempoFin int64, err error) {
+	c, err := ParsearClaveS3DatosCompleta(clave)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return c.SerieId, c.TiempoInicio, c.TiempoFin, nil
+}
+
+// ParsearClaveS3DatosCompleta extrae todos los componentes de una clave S3, incluyendo
+// el nodo y la disposición detectada
+func ParsearClaveS3DatosCompleta(clave string) (ClaveS3Datos, error) {
 	partes := strings.Split(clave, "/")
-	if len(partes) != 2 {
-		return 0, 0, 0, fmt.Errorf("formato de clave inválido: %s", clave)
+	var disposicion DisposicionClaves
+	switch len(partes) {
+	case 2:
+		disposicion = DisposicionPlana
+	case 6:
+		disposicion = DisposicionParticionada
+		for _, p := range partes[1:5] {
+			if _, err := strconv.Atoi(p); err != nil {
+				return ClaveS3Datos{}, fmt.Errorf("formato de partición inválido: %s", clave)
+			}
+		}
+	default:
+		return ClaveS3Datos{}, fmt.Errorf("formato de clave inválido: %s", clave)
 	}
 
-	componentes := strings.Split(partes[1], "_")
+	serieId, tiempoInicio, tiempoFin, err := parsearNombreBloqueS3(partes[len(partes)-1])
+	if err != nil {
+		return ClaveS3Datos{}, err
+	}
+	if disposicion.EsParticionada() && partes[1] != fmt.Sprintf("%010d", serieId) {
+		return ClaveS3Datos{}, fmt.Errorf("serie de la partición no coincide con el bloque: %s", clave)
+	}
+
+	return ClaveS3Datos{
+		NodoID:       partes[0],
+		SerieId:      serieId,
+		TiempoInicio: tiempoInicio,
+		TiempoFin:    tiempoFin,
+		Disposicion:  disposicion,
+	}, nil
+}
+
+// parsearNombreBloqueS3 extrae los componentes del nombre de un bloque: {serieId}_{tiempoInicio}_{tiempoFin}
+func parsearNombreBloqueS3(nombre string) (serieId int, tiempoInicio, tiempoFin int64, err error) {
+	componentes := strings.Split(nombre, "_")
 	if len(componentes) != 3 {
-		return 0, 0, 0, fmt.Errorf("formato de nombre inválido: %s", partes[1])
+		return 0, 0, 0, fmt.Errorf("formato de nombre inválido: %s", nombre)
 	}
 
 	serieId64, err := strconv.ParseInt(strings.TrimLeft(componentes[0], "0"), 10, 32)
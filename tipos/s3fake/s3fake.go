@@ -0,0 +1,591 @@
+// Package s3fake implementa tipos.ClienteS3 en memoria para probar de punta a punta
+// aplicaciones que usan nodos edge y el despachador sin un servidor S3.
+//
+// El cliente reproduce el comportamiento de S3 que usa SensorWave: buckets, listados
+// paginados con prefijo, delimitador y StartAfter, ETag, metadatos, lecturas y
+// escrituras condicionales (If-Match, If-None-Match), verificación de Content-MD5 y
+// los mismos tipos de error que el SDK. Permite además inyectar latencia y errores
+// para probar reintentos y tolerancia a fallas. Es seguro para uso concurrente.
+//
+//	cliente := s3fake.Nuevo(s3fake.Opciones{Buckets: []string{"datos"}})
+//	manager, err := edge.Crear(edge.Opciones{
+//		ConfigS3:  &tipos.ConfiguracionS3{Bucket: "datos"},
+//		ClienteS3: cliente,
+//		// ...
+//	})
+package s3fake
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// tamañoPaginaS3 es el máximo de objetos por página de ListObjectsV2 en S3
+const tamañoPaginaS3 = 1000
+
+// Operacion identifica una operación del cliente para la inyección de fallas
+type Operacion string
+
+const (
+	OperacionHeadBucket    Operacion = "HeadBucket"
+	OperacionCreateBucket  Operacion = "CreateBucket"
+	OperacionListObjectsV2 Operacion = "ListObjectsV2"
+	OperacionGetObject     Operacion = "GetObject"
+	OperacionHeadObject    Operacion = "HeadObject"
+	OperacionPutObject     Operacion = "PutObject"
+	OperacionDeleteObject  Operacion = "DeleteObject"
+)
+
+// Opciones configura el cliente en memoria
+type Opciones struct {
+	Buckets      []string         // Buckets existentes al crear el cliente
+	TamañoPagina int              // Máximo de objetos por página de los listados (0 = 1000, como S3)
+	Reloj        func() time.Time // Fecha de modificación de los objetos (nil = time.Now)
+	Semilla      int64            // Semilla de las fallas aleatorias (0 = aleatoria)
+}
+
+// Fallas configura la latencia y los errores inyectados en las operaciones
+type Fallas struct {
+	Latencia          time.Duration // Demora de cada operación
+	VariacionLatencia time.Duration // Demora adicional aleatoria entre 0 y este valor
+	TasaErrores       float64       // Probabilidad (0 a 1) de que la operación falle con 503 SlowDown
+	Operaciones       []Operacion   // Operaciones afectadas (vacío = todas)
+
+	// Interceptar permite fallas deterministas: si retorna un error la operación
+	// falla con él sin modificar el bucket. Se invoca tras la latencia.
+	Interceptar func(op Operacion, bucket, clave string) error
+}
+
+// afecta indica si las fallas se aplican a la operación
+func (f Fallas) afecta(op Operacion) bool {
+	return len(f.Operaciones) == 0 || slices.Contains(f.Operaciones, op)
+}
+
+// Cliente implementa tipos.ClienteS3 sobre buckets en memoria
+type Cliente struct {
+	tamañoPagina int
+	reloj        func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]map[string]*objeto // bucket → clave → objeto
+	fallas    Fallas
+	aleatorio *rand.Rand
+	llamadas  map[Operacion]int
+	porClave  map[Operacion]map[string]int
+}
+
+// objeto es el contenido y los metadatos de un objeto almacenado
+type objeto struct {
+	datos         []byte
+	etag          string
+	tipoContenido string
+	metadatos     map[string]string
+	modificado    time.Time
+}
+
+var _ tipos.ClienteS3 = (*Cliente)(nil)
+
+// Nuevo crea un cliente en memoria con los buckets indicados en las opciones
+func Nuevo(opts Opciones) *Cliente {
+	if opts.TamañoPagina <= 0 {
+		opts.TamañoPagina = tamañoPaginaS3
+	}
+	if opts.Reloj == nil {
+		opts.Reloj = time.Now
+	}
+	if opts.Semilla == 0 {
+		opts.Semilla = time.Now().UnixNano()
+	}
+
+	c := &Cliente{
+		tamañoPagina: opts.TamañoPagina,
+		reloj:        opts.Reloj,
+		buckets:      make(map[string]map[string]*objeto),
+		aleatorio:    rand.New(rand.NewSource(opts.Semilla)),
+		llamadas:     make(map[Operacion]int),
+		porClave:     make(map[Operacion]map[string]int),
+	}
+	for _, bucket := range opts.Buckets {
+		c.buckets[bucket] = make(map[string]*objeto)
+	}
+	return c
+}
+
+// ============================================================================
+// INSPECCIÓN Y FALLAS
+// ============================================================================
+
+// Fallar reemplaza las fallas inyectadas. Fallar(Fallas{}) las desactiva.
+func (c *Cliente) Fallar(fallas Fallas) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallas = fallas
+}
+
+// Llamadas retorna cuántas veces se invocó la operación, incluidas las que fallaron
+func (c *Cliente) Llamadas(op Operacion) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.llamadas[op]
+}
+
+// LlamadasClave retorna cuántas veces se invocó la operación sobre la clave (el
+// prefijo en ListObjectsV2), incluidas las que fallaron
+func (c *Cliente) LlamadasClave(op Operacion, clave string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.porClave[op][clave]
+}
+
+// Objeto retorna una copia del contenido del objeto
+func (c *Cliente) Objeto(bucket, clave string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, existe := c.buckets[bucket][clave]
+	if !existe {
+		return nil, false
+	}
+	return bytes.Clone(obj.datos), true
+}
+
+// Claves retorna las claves del bucket con el prefijo dado, en orden
+func (c *Cliente) Claves(bucket, prefijo string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clavesOrdenadas(bucket, prefijo)
+}
+
+// antes registra la llamada y aplica las fallas configuradas para la operación
+func (c *Cliente) antes(ctx context.Context, op Operacion, bucket, clave string) error {
+	c.mu.Lock()
+	c.llamadas[op]++
+	if c.porClave[op] == nil {
+		c.porClave[op] = make(map[string]int)
+	}
+	c.porClave[op][clave]++
+	fallas := c.fallas
+	var demora time.Duration
+	var fallar bool
+	if fallas.afecta(op) {
+		demora = fallas.Latencia
+		if fallas.VariacionLatencia > 0 {
+			demora += time.Duration(c.aleatorio.Int63n(int64(fallas.VariacionLatencia)))
+		}
+		fallar = fallas.TasaErrores > 0 && c.aleatorio.Float64() < fallas.TasaErrores
+	} else {
+		fallas.Interceptar = nil
+	}
+	c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if demora > 0 {
+		temporizador := time.NewTimer(demora)
+		defer temporizador.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-temporizador.C:
+		}
+	}
+
+	if fallas.Interceptar != nil {
+		if err := fallas.Interceptar(op, bucket, clave); err != nil {
+			return err
+		}
+	}
+	if fallar {
+		return ErrorS3(http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+	}
+	return nil
+}
+
+// ============================================================================
+// ERRORES
+// ============================================================================
+
+// ErrorRespuesta es el error de una operación junto con el estado HTTP de la
+// respuesta, como awshttp.ResponseError en el SDK. Envuelve el error de S3
+// (por ejemplo *s3types.NoSuchKey o smithy.APIError), accesible con errors.As.
+type ErrorRespuesta struct {
+	Estado int
+	Err    error
+}
+
+func (e *ErrorRespuesta) Error() string {
+	return fmt.Sprintf("respuesta S3 con estado %d: %v", e.Estado, e.Err)
+}
+
+func (e *ErrorRespuesta) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatusCode retorna el estado HTTP de la respuesta
+func (e *ErrorRespuesta) HTTPStatusCode() int {
+	return e.Estado
+}
+
+// ErrorS3 crea un error de S3 con el estado HTTP, el código y el mensaje dados,
+// por ejemplo para retornarlo desde Fallas.Interceptar
+func ErrorS3(estado int, codigo, mensaje string) error {
+	falla := smithy.FaultClient
+	if estado >= http.StatusInternalServerError {
+		falla = smithy.FaultServer
+	}
+	return &ErrorRespuesta{
+		Estado: estado,
+		Err:    &smithy.GenericAPIError{Code: codigo, Message: mensaje, Fault: falla},
+	}
+}
+
+func errorSinBucket(bucket string) error {
+	return &ErrorRespuesta{
+		Estado: http.StatusNotFound,
+		Err:    &s3types.NoSuchBucket{Message: aws.String("The specified bucket does not exist: " + bucket)},
+	}
+}
+
+func errorSinClave(clave string) error {
+	return &ErrorRespuesta{
+		Estado: http.StatusNotFound,
+		Err:    &s3types.NoSuchKey{Message: aws.String("The specified key does not exist: " + clave)},
+	}
+}
+
+func errorPrecondicion() error {
+	return ErrorS3(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+}
+
+// ============================================================================
+// BUCKETS
+// ============================================================================
+
+func (c *Cliente) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	bucket := aws.ToString(params.Bucket)
+	if err := c.antes(ctx, OperacionHeadBucket, bucket, ""); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, existe := c.buckets[bucket]; !existe {
+		return nil, &ErrorRespuesta{Estado: http.StatusNotFound, Err: &s3types.NotFound{}}
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (c *Cliente) CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+	bucket := aws.ToString(params.Bucket)
+	if err := c.antes(ctx, OperacionCreateBucket, bucket, ""); err != nil {
+		return nil, err
+	}
+	if bucket == "" {
+		return nil, ErrorS3(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, existe := c.buckets[bucket]; existe {
+		return nil, &ErrorRespuesta{Estado: http.StatusConflict, Err: &s3types.BucketAlreadyOwnedByYou{}}
+	}
+	c.buckets[bucket] = make(map[string]*objeto)
+	return &s3.CreateBucketOutput{Location: aws.String("/" + bucket)}, nil
+}
+
+// ============================================================================
+// OBJETOS
+// ============================================================================
+
+// ListObjectsV2 lista las claves en orden lexicográfico. El token de continuación
+// es la última entrada de la página, por lo que el listado sigue siendo coherente
+// si se agregan o eliminan objetos entre páginas.
+func (c *Cliente) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	bucket := aws.ToString(params.Bucket)
+	prefijo := aws.ToString(params.Prefix)
+	if err := c.antes(ctx, OperacionListObjectsV2, bucket, prefijo); err != nil {
+		return nil, err
+	}
+
+	desde := aws.ToString(params.StartAfter)
+	if params.ContinuationToken != nil {
+		token, err := base64.RawURLEncoding.DecodeString(*params.ContinuationToken)
+		if err != nil {
+			return nil, ErrorS3(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+		}
+		desde = max(desde, string(token))
+	}
+	delimitador := aws.ToString(params.Delimiter)
+	maximo := c.tamañoPagina
+	if params.MaxKeys != nil && *params.MaxKeys >= 0 {
+		maximo = min(maximo, int(*params.MaxKeys))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objetos, existe := c.buckets[bucket]
+	if !existe {
+		return nil, errorSinBucket(bucket)
+	}
+
+	salida := &s3.ListObjectsV2Output{
+		Name:              aws.String(bucket),
+		Prefix:            params.Prefix,
+		Delimiter:         params.Delimiter,
+		StartAfter:        params.StartAfter,
+		ContinuationToken: params.ContinuationToken,
+		MaxKeys:           aws.Int32(int32(maximo)),
+		IsTruncated:       aws.Bool(false),
+	}
+
+	var ultima string
+	entradas := 0
+	for _, clave := range c.clavesOrdenadas(bucket, prefijo) {
+		if clave <= desde {
+			continue
+		}
+
+		// Con delimitador, las claves con un segmento más se agrupan en un prefijo común
+		comun := ""
+		if delimitador != "" {
+			if i := strings.Index(clave[len(prefijo):], delimitador); i >= 0 {
+				comun = clave[:len(prefijo)+i+len(delimitador)]
+			}
+		}
+		if comun != "" && (comun == ultima || (strings.HasSuffix(desde, delimitador) && strings.HasPrefix(clave, desde))) {
+			continue // Prefijo común ya listado en esta página o en la anterior
+		}
+
+		if entradas == maximo {
+			salida.IsTruncated = aws.Bool(true)
+			salida.NextContinuationToken = aws.String(base64.RawURLEncoding.EncodeToString([]byte(ultima)))
+			break
+		}
+		entradas++
+		if comun != "" {
+			ultima = comun
+			salida.CommonPrefixes = append(salida.CommonPrefixes, s3types.CommonPrefix{Prefix: aws.String(comun)})
+			continue
+		}
+		ultima = clave
+		obj := objetos[clave]
+		salida.Contents = append(salida.Contents, s3types.Object{
+			Key:          aws.String(clave),
+			Size:         aws.Int64(int64(len(obj.datos))),
+			ETag:         aws.String(obj.etag),
+			LastModified: aws.Time(obj.modificado),
+			StorageClass: s3types.ObjectStorageClassStandard,
+		})
+	}
+	salida.KeyCount = aws.Int32(int32(entradas))
+	return salida, nil
+}
+
+func (c *Cliente) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	bucket, clave := aws.ToString(params.Bucket), aws.ToString(params.Key)
+	if err := c.antes(ctx, OperacionGetObject, bucket, clave); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, err := c.leer(bucket, clave, params.IfMatch, params.IfNoneMatch)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(bytes.Clone(obj.datos))),
+		ContentLength: aws.Int64(int64(len(obj.datos))),
+		ContentType:   aws.String(obj.tipoContenido),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.modificado),
+		Metadata:      copiarMetadatos(obj.metadatos),
+	}, nil
+}
+
+// HeadObject retorna los metadatos del objeto sin su contenido. No forma parte de
+// tipos.ClienteS3 pero permite verificar objetos como con *s3.Client.
+func (c *Cliente) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	bucket, clave := aws.ToString(params.Bucket), aws.ToString(params.Key)
+	if err := c.antes(ctx, OperacionHeadObject, bucket, clave); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, err := c.leer(bucket, clave, params.IfMatch, params.IfNoneMatch)
+	if err != nil {
+		var sinClave *s3types.NoSuchKey
+		if errors.As(err, &sinClave) {
+			// HEAD no tiene cuerpo con el código de error: el SDK retorna NotFound
+			return nil, &ErrorRespuesta{Estado: http.StatusNotFound, Err: &s3types.NotFound{}}
+		}
+		return nil, err
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(obj.datos))),
+		ContentType:   aws.String(obj.tipoContenido),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.modificado),
+		Metadata:      copiarMetadatos(obj.metadatos),
+	}, nil
+}
+
+// PutObject guarda el objeto. Con IfNoneMatch "*" solo lo crea si no existe y con
+// IfMatch solo lo reemplaza si conserva ese ETag; si no, retorna 412 PreconditionFailed.
+// Con ContentMD5 verifica el contenido recibido (400 BadDigest si no coincide).
+func (c *Cliente) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	bucket, clave := aws.ToString(params.Bucket), aws.ToString(params.Key)
+	if err := c.antes(ctx, OperacionPutObject, bucket, clave); err != nil {
+		return nil, err
+	}
+	if clave == "" {
+		return nil, ErrorS3(http.StatusBadRequest, "InvalidArgument", "Object key must not be empty")
+	}
+
+	var datos []byte
+	if params.Body != nil {
+		var err error
+		if datos, err = io.ReadAll(params.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	suma := md5.Sum(datos)
+	if params.ContentMD5 != nil {
+		esperado, err := base64.StdEncoding.DecodeString(*params.ContentMD5)
+		if err != nil || len(esperado) != md5.Size {
+			return nil, ErrorS3(http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified was invalid.")
+		}
+		if !bytes.Equal(esperado, suma[:]) {
+			return nil, ErrorS3(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objetos, existe := c.buckets[bucket]
+	if !existe {
+		return nil, errorSinBucket(bucket)
+	}
+
+	anterior, existe := objetos[clave]
+	if params.IfNoneMatch != nil && existe && (*params.IfNoneMatch == "*" || *params.IfNoneMatch == anterior.etag) {
+		return nil, errorPrecondicion()
+	}
+	if params.IfMatch != nil {
+		if !existe {
+			return nil, errorSinClave(clave)
+		}
+		if *params.IfMatch != anterior.etag {
+			return nil, errorPrecondicion()
+		}
+	}
+
+	nuevo := &objeto{
+		datos:         datos,
+		etag:          `"` + hex.EncodeToString(suma[:]) + `"`,
+		tipoContenido: aws.ToString(params.ContentType),
+		metadatos:     copiarMetadatos(params.Metadata),
+		modificado:    c.reloj().UTC(),
+	}
+	if nuevo.tipoContenido == "" {
+		nuevo.tipoContenido = "binary/octet-stream"
+	}
+	objetos[clave] = nuevo
+	return &s3.PutObjectOutput{ETag: aws.String(nuevo.etag), Size: aws.Int64(int64(len(datos)))}, nil
+}
+
+// DeleteObject elimina el objeto. Como en S3, eliminar una clave inexistente no es
+// un error; con IfMatch solo se elimina si el objeto conserva ese ETag.
+func (c *Cliente) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	bucket, clave := aws.ToString(params.Bucket), aws.ToString(params.Key)
+	if err := c.antes(ctx, OperacionDeleteObject, bucket, clave); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objetos, existe := c.buckets[bucket]
+	if !existe {
+		return nil, errorSinBucket(bucket)
+	}
+	if params.IfMatch != nil {
+		obj, existe := objetos[clave]
+		if !existe {
+			return nil, errorSinClave(clave)
+		}
+		if *params.IfMatch != obj.etag {
+			return nil, errorPrecondicion()
+		}
+	}
+	delete(objetos, clave)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// ============================================================================
+// AUXILIARES (requieren c.mu)
+// ============================================================================
+
+// leer busca el objeto y evalúa las condiciones If-Match e If-None-Match
+func (c *Cliente) leer(bucket, clave string, siCoincide, siNoCoincide *string) (*objeto, error) {
+	objetos, existe := c.buckets[bucket]
+	if !existe {
+		return nil, errorSinBucket(bucket)
+	}
+	obj, existe := objetos[clave]
+	if !existe {
+		return nil, errorSinClave(clave)
+	}
+	if siCoincide != nil && *siCoincide != obj.etag && *siCoincide != "*" {
+		return nil, errorPrecondicion()
+	}
+	if siNoCoincide != nil && (*siNoCoincide == obj.etag || *siNoCoincide == "*") {
+		return nil, ErrorS3(http.StatusNotModified, "NotModified", "Not Modified")
+	}
+	return obj, nil
+}
+
+// clavesOrdenadas retorna las claves del bucket con el prefijo dado, en orden
+func (c *Cliente) clavesOrdenadas(bucket, prefijo string) []string {
+	var claves []string
+	for clave := range c.buckets[bucket] {
+		if strings.HasPrefix(clave, prefijo) {
+			claves = append(claves, clave)
+		}
+	}
+	sort.Strings(claves)
+	return claves
+}
+
+// copiarMetadatos copia los metadatos de usuario; S3 guarda las claves en minúsculas
+func copiarMetadatos(metadatos map[string]string) map[string]string {
+	if len(metadatos) == 0 {
+		return nil
+	}
+	copia := make(map[string]string, len(metadatos))
+	for clave, valor := range metadatos {
+		copia[strings.ToLower(clave)] = valor
+	}
+	return copia
+}
@@ -0,0 +1,342 @@
+package s3fake
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/cbiale/sensorwave/tipos"
+)
+
+// codigoError retorna el código S3 y el estado HTTP del error
+func codigoError(err error) (string, int) {
+	var errorAPI smithy.APIError
+	var respuesta interface{ HTTPStatusCode() int }
+	codigo, estado := "", 0
+	if errors.As(err, &errorAPI) {
+		codigo = errorAPI.ErrorCode()
+	}
+	if errors.As(err, &respuesta) {
+		estado = respuesta.HTTPStatusCode()
+	}
+	return codigo, estado
+}
+
+// guardar sube un objeto al bucket y falla el test si hay error
+func guardar(t *testing.T, cliente *Cliente, bucket, clave string) {
+	t.Helper()
+	_, err := cliente.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(clave),
+		Body:   bytes.NewReader([]byte(clave)),
+	})
+	if err != nil {
+		t.Fatalf("Error guardando %s: %v", clave, err)
+	}
+}
+
+// ==================== Tests con tipos.Almacenamiento ====================
+
+// TestCliente_Almacenamiento verifica que el fake se comporta como S3 para AlmacenamientoS3
+func TestCliente_Almacenamiento(t *testing.T) {
+	ctx := context.Background()
+	cliente := Nuevo(Opciones{})
+	almacenamiento := tipos.NuevoAlmacenamientoS3(cliente, "datos")
+
+	// Preparar crea el bucket inexistente
+	if err := almacenamiento.Preparar(ctx); err != nil {
+		t.Fatalf("Error preparando: %v", err)
+	}
+	if cliente.Llamadas(OperacionCreateBucket) != 1 {
+		t.Error("Preparar debe crear el bucket")
+	}
+
+	etag, err := almacenamiento.Guardar(ctx, "nodos/n1.json", []byte(`{}`), tipos.OpcionesGuardar{TipoContenido: "application/json"})
+	if err != nil {
+		t.Fatalf("Error guardando: %v", err)
+	}
+	datos, etagLeido, err := almacenamiento.Leer(ctx, "nodos/n1.json", "")
+	if err != nil || string(datos) != `{}` || etagLeido != etag {
+		t.Errorf("Lectura incorrecta: %q %s %v", datos, etagLeido, err)
+	}
+
+	// Lectura condicional y clave inexistente con los errores del SDK
+	if _, _, err := almacenamiento.Leer(ctx, "nodos/n1.json", etag); !errors.Is(err, tipos.ErrNoModificado) {
+		t.Errorf("Se esperaba ErrNoModificado, obtenido %v", err)
+	}
+	if _, _, err := almacenamiento.Leer(ctx, "nodos/n2.json", ""); !tipos.EsObjetoInexistente(err) {
+		t.Errorf("Se esperaba objeto inexistente, obtenido %v", err)
+	}
+	if n := cliente.LlamadasClave(OperacionGetObject, "nodos/n1.json"); n != 2 {
+		t.Errorf("Se esperaban 2 lecturas de nodos/n1.json, obtenidas %d", n)
+	}
+
+	// Escritura condicional con verificación de contenido
+	suma := md5.Sum([]byte("bloque"))
+	opts := tipos.OpcionesGuardar{MD5: suma[:], SoloSiNoExiste: true}
+	if _, err := almacenamiento.Guardar(ctx, "n1/bloque", []byte("bloque"), opts); err != nil {
+		t.Fatalf("Error guardando: %v", err)
+	}
+	if _, err := almacenamiento.Guardar(ctx, "n1/bloque", []byte("bloque"), opts); !errors.Is(err, tipos.ErrObjetoExistente) {
+		t.Errorf("Se esperaba ErrObjetoExistente, obtenido %v", err)
+	}
+	if _, err := almacenamiento.Guardar(ctx, "n1/otro", []byte("corrupto"), tipos.OpcionesGuardar{MD5: suma[:]}); err == nil {
+		t.Error("Se esperaba error con MD5 incorrecto")
+	}
+
+	if err := almacenamiento.Eliminar(ctx, "nodos/n1.json"); err != nil {
+		t.Fatalf("Error eliminando: %v", err)
+	}
+	if _, existe := cliente.Objeto("datos", "nodos/n1.json"); existe {
+		t.Error("El objeto debe eliminarse")
+	}
+	t.Log("✓ El fake es intercambiable con un bucket S3 para tipos.AlmacenamientoS3")
+}
+
+// ==================== Tests de ListObjectsV2 ====================
+
+// TestCliente_ListObjectsV2_PaginasYDelimitador verifica páginas, prefijos comunes y StartAfter
+func TestCliente_ListObjectsV2_PaginasYDelimitador(t *testing.T) {
+	ctx := context.Background()
+	cliente := Nuevo(Opciones{Buckets: []string{"datos"}, TamañoPagina: 2})
+	for _, clave := range []string{"n/a/1", "n/a/2", "n/b/1", "n/c", "n/d", "otro/x"} {
+		guardar(t, cliente, "datos", clave)
+	}
+
+	// Con delimitador: n/a/ y n/b/ se agrupan; cada prefijo cuenta como una entrada
+	var claves, prefijos []string
+	var token *string
+	paginas := 0
+	for {
+		salida, err := cliente.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String("datos"),
+			Prefix:            aws.String("n/"),
+			Delimiter:         aws.String("/"),
+			ContinuationToken: token,
+		})
+		if err != nil {
+			t.Fatalf("Error listando: %v", err)
+		}
+		paginas++
+		for _, obj := range salida.Contents {
+			claves = append(claves, *obj.Key)
+		}
+		for _, prefijo := range salida.CommonPrefixes {
+			prefijos = append(prefijos, *prefijo.Prefix)
+		}
+		if !aws.ToBool(salida.IsTruncated) {
+			break
+		}
+		token = salida.NextContinuationToken
+	}
+	if paginas != 2 || fmt.Sprint(prefijos) != "[n/a/ n/b/]" || fmt.Sprint(claves) != "[n/c n/d]" {
+		t.Errorf("Listado incorrecto en %d páginas: prefijos %v, claves %v", paginas, prefijos, claves)
+	}
+
+	// StartAfter y MaxKeys
+	salida, err := cliente.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:     aws.String("datos"),
+		StartAfter: aws.String("n/b/1"),
+		MaxKeys:    aws.Int32(1),
+	})
+	if err != nil {
+		t.Fatalf("Error listando: %v", err)
+	}
+	if len(salida.Contents) != 1 || *salida.Contents[0].Key != "n/c" || !aws.ToBool(salida.IsTruncated) {
+		t.Errorf("StartAfter incorrecto: %+v", salida.Contents)
+	}
+
+	// Sin delimitador se listan todas las claves
+	todas, err := tipos.ListarObjetosS3(ctx, cliente, "datos", "")
+	if err != nil || len(todas) != 6 {
+		t.Errorf("Se esperaban 6 objetos: %d, %v", len(todas), err)
+	}
+
+	_, err = cliente.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("otro")})
+	var sinBucket *s3types.NoSuchBucket
+	if !errors.As(err, &sinBucket) {
+		t.Errorf("Se esperaba NoSuchBucket, obtenido %v", err)
+	}
+	t.Log("✓ ListObjectsV2 pagina con prefijo, delimitador y StartAfter")
+}
+
+// ==================== Tests de escrituras condicionales ====================
+
+// TestCliente_PutObject_Condiciones verifica If-None-Match, If-Match, Content-MD5 y metadatos
+func TestCliente_PutObject_Condiciones(t *testing.T) {
+	ctx := context.Background()
+	cliente := Nuevo(Opciones{Buckets: []string{"datos"}})
+	subir := func(datos string, configurar func(*s3.PutObjectInput)) (*s3.PutObjectOutput, error) {
+		input := &s3.PutObjectInput{
+			Bucket: aws.String("datos"),
+			Key:    aws.String("bloque"),
+			Body:   bytes.NewReader([]byte(datos)),
+		}
+		configurar(input)
+		return cliente.PutObject(ctx, input)
+	}
+
+	// Crear solo si no existe
+	primera, err := subir("v1", func(in *s3.PutObjectInput) {
+		in.IfNoneMatch = aws.String("*")
+		in.Metadata = map[string]string{"Nodo-ID": "n1"}
+	})
+	if err != nil {
+		t.Fatalf("Error en la primera escritura: %v", err)
+	}
+	_, err = subir("v2", func(in *s3.PutObjectInput) { in.IfNoneMatch = aws.String("*") })
+	if codigo, estado := codigoError(err); codigo != "PreconditionFailed" || estado != http.StatusPreconditionFailed {
+		t.Errorf("Se esperaba 412 PreconditionFailed, obtenido %v", err)
+	}
+
+	// Reemplazar solo si conserva el ETag
+	if _, err := subir("v2", func(in *s3.PutObjectInput) { in.IfMatch = primera.ETag }); err != nil {
+		t.Errorf("Error reemplazando con ETag vigente: %v", err)
+	}
+	if _, err := subir("v3", func(in *s3.PutObjectInput) { in.IfMatch = primera.ETag }); err == nil {
+		t.Error("Se esperaba error con ETag desactualizado")
+	}
+
+	// Content-MD5
+	suma := md5.Sum([]byte("v3"))
+	if _, err := subir("v3", func(in *s3.PutObjectInput) { in.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(suma[:])) }); err != nil {
+		t.Errorf("Error con Content-MD5 correcto: %v", err)
+	}
+	_, err = subir("corrupto", func(in *s3.PutObjectInput) { in.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(suma[:])) })
+	if codigo, _ := codigoError(err); codigo != "BadDigest" {
+		t.Errorf("Se esperaba BadDigest, obtenido %v", err)
+	}
+	if datos, _ := cliente.Objeto("datos", "bloque"); string(datos) != "v3" {
+		t.Errorf("Las escrituras rechazadas no deben modificar el objeto: %q", datos)
+	}
+
+	// Los metadatos de la primera escritura se reemplazaron; las claves se guardan en minúsculas
+	if _, err := subir("v4", func(in *s3.PutObjectInput) { in.Metadata = map[string]string{"Nodo-ID": "n2"} }); err != nil {
+		t.Fatal(err)
+	}
+	cabecera, err := cliente.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("datos"), Key: aws.String("bloque")})
+	if err != nil || cabecera.Metadata["nodo-id"] != "n2" || aws.ToInt64(cabecera.ContentLength) != 2 {
+		t.Errorf("Metadatos incorrectos: %+v, %v", cabecera, err)
+	}
+	_, err = cliente.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("datos"), Key: aws.String("otro")})
+	var noEncontrado *s3types.NotFound
+	if !errors.As(err, &noEncontrado) {
+		t.Errorf("Se esperaba NotFound, obtenido %v", err)
+	}
+	t.Log("✓ PutObject aplica escrituras condicionales y verifica Content-MD5")
+}
+
+// ==================== Tests de inyección de fallas ====================
+
+// TestCliente_Fallas verifica la tasa de errores, la latencia y las fallas deterministas
+func TestCliente_Fallas(t *testing.T) {
+	ctx := context.Background()
+	cliente := Nuevo(Opciones{Buckets: []string{"datos"}, Semilla: 1})
+	guardar(t, cliente, "datos", "a")
+
+	// Solo fallan las escrituras
+	cliente.Fallar(Fallas{TasaErrores: 1, Operaciones: []Operacion{OperacionPutObject}})
+	_, err := cliente.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("datos"), Key: aws.String("b")})
+	if codigo, estado := codigoError(err); codigo != "SlowDown" || estado != http.StatusServiceUnavailable {
+		t.Errorf("Se esperaba 503 SlowDown, obtenido %v", err)
+	}
+	if _, err := cliente.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("datos"), Key: aws.String("a")}); err != nil {
+		t.Errorf("GetObject no debe fallar: %v", err)
+	}
+
+	// Con una tasa parcial fallan aproximadamente esa proporción de operaciones
+	cliente.Fallar(Fallas{TasaErrores: 0.5})
+	fallidas := 0
+	for i := 0; i < 200; i++ {
+		if _, err := cliente.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("datos")}); err != nil {
+			fallidas++
+		}
+	}
+	if fallidas < 60 || fallidas > 140 {
+		t.Errorf("Se esperaban alrededor de 100 fallas, obtenidas %d", fallidas)
+	}
+
+	// La latencia respeta la cancelación del contexto
+	cliente.Fallar(Fallas{Latencia: time.Second})
+	ctxCorto, cancelar := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancelar()
+	inicio := time.Now()
+	_, err = cliente.GetObject(ctxCorto, &s3.GetObjectInput{Bucket: aws.String("datos"), Key: aws.String("a")})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(inicio) > 500*time.Millisecond {
+		t.Errorf("Se esperaba DeadlineExceeded inmediato, obtenido %v tras %v", err, time.Since(inicio))
+	}
+
+	// Falla determinista para una clave
+	errSimulado := ErrorS3(http.StatusInternalServerError, "InternalError", "simulado")
+	cliente.Fallar(Fallas{Interceptar: func(op Operacion, bucket, clave string) error {
+		if op == OperacionPutObject && clave == "manifiesto.json" {
+			return errSimulado
+		}
+		return nil
+	}})
+	guardar(t, cliente, "datos", "bloque")
+	_, err = cliente.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("datos"), Key: aws.String("manifiesto.json")})
+	if !errors.Is(err, errSimulado) {
+		t.Errorf("Se esperaba el error interceptado, obtenido %v", err)
+	}
+	if _, existe := cliente.Objeto("datos", "manifiesto.json"); existe {
+		t.Error("Una operación fallida no debe modificar el bucket")
+	}
+
+	cliente.Fallar(Fallas{})
+	guardar(t, cliente, "datos", "manifiesto.json")
+	t.Log("✓ Las fallas inyectadas afectan solo a las operaciones configuradas")
+}
+
+// TestCliente_Concurrencia verifica el uso desde varias gorutinas (ejecutar con -race)
+func TestCliente_Concurrencia(t *testing.T) {
+	ctx := context.Background()
+	cliente := Nuevo(Opciones{Buckets: []string{"datos"}, TamañoPagina: 7})
+	cliente.Fallar(Fallas{VariacionLatencia: time.Millisecond})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				clave := fmt.Sprintf("g%d/%03d", g, i)
+				_, err := cliente.PutObject(ctx, &s3.PutObjectInput{
+					Bucket: aws.String("datos"),
+					Key:    aws.String(clave),
+					Body:   bytes.NewReader([]byte(clave)),
+				})
+				if err != nil {
+					t.Errorf("Error guardando %s: %v", clave, err)
+				}
+				if _, err := cliente.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("datos"), Key: aws.String(clave)}); err != nil {
+					t.Errorf("Error leyendo %s: %v", clave, err)
+				}
+				if _, err := tipos.ListarObjetosS3(ctx, cliente, "datos", fmt.Sprintf("g%d/", g)); err != nil {
+					t.Errorf("Error listando: %v", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := len(cliente.Claves("datos", "")); n != 200 {
+		t.Errorf("Se esperaban 200 objetos, obtenidos %d", n)
+	}
+	if n := cliente.Llamadas(OperacionPutObject); n != 200 {
+		t.Errorf("Se esperaban 200 escrituras, obtenidas %d", n)
+	}
+	t.Log("✓ El fake admite operaciones concurrentes")
+}
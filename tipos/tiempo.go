@@ -0,0 +1,269 @@
+package tipos
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// PARSEO DE TIEMPOS Y DURACIONES
+// Formatos aceptados por las APIs REST del despachador y del edge
+// ============================================================================
+
+// unidadesDuracion define las unidades aceptadas en duraciones legibles.
+// Las unidades de varios caracteres van primero para que "ms" no se confunda con "m"
+var unidadesDuracion = []struct {
+	sufijo string
+	valor  time.Duration
+}{
+	{"ns", time.Nanosecond},
+	{"us", time.Microsecond},
+	{"µs", time.Microsecond},
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+}
+
+// ParsearDuracion interpreta una duración legible.
+// Formatos aceptados:
+//   - Entero: nanosegundos ("900000000000")
+//   - Duración con unidades: "15m", "1h30m", "500ms", "1d", "2w", "1d12h"
+//
+// Unidades: ns, us (µs), ms, s, m, h, d (24h), w (7d).
+func ParsearDuracion(expr string) (time.Duration, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return 0, fmt.Errorf("duración vacía")
+	}
+
+	if n, err := strconv.ParseInt(expr, 10, 64); err == nil {
+		return time.Duration(n), nil
+	}
+
+	resto := expr
+	negativo := false
+	if strings.HasPrefix(resto, "-") {
+		negativo = true
+		resto = resto[1:]
+	}
+
+	var total time.Duration
+	for resto != "" {
+		// Parte numérica (admite decimales: "1.5h")
+		i := 0
+		for i < len(resto) && (resto[i] >= '0' && resto[i] <= '9' || resto[i] == '.') {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("duración inválida: %q", expr)
+		}
+		numero, err := strconv.ParseFloat(resto[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("duración inválida: %q", expr)
+		}
+		resto = resto[i:]
+
+		// Unidad: las de dos caracteres se prueban primero ("ms" antes que "m")
+		unidad := time.Duration(0)
+		for _, u := range unidadesDuracion {
+			if strings.HasPrefix(resto, u.sufijo) {
+				unidad = u.valor
+				resto = resto[len(u.sufijo):]
+				break
+			}
+		}
+		if unidad == 0 {
+			return 0, fmt.Errorf("duración inválida: %q (unidad desconocida)", expr)
+		}
+		total += time.Duration(numero * float64(unidad))
+	}
+
+	if negativo {
+		total = -total
+	}
+	return total, nil
+}
+
+// ParsearTiempo interpreta una marca de tiempo absoluta o relativa a ahora.
+// Formatos aceptados:
+//   - Entero: Unix nanosegundos ("1700000000000000000")
+//   - RFC3339 / RFC3339Nano: "2024-01-15T10:30:00Z", "2024-01-15T10:30:00.5-03:00"
+//   - Relativo: "now", "now-1h", "now+30m", "now-7d", "now-1M", "now-1y"
+//   - Redondeo al inicio de una unidad: "now/d", "now-1d/d", "now/w", "now/M"
+//
+// En expresiones relativas se admiten las unidades de ParsearDuracion y además
+// M (meses) e y (años), que respetan el calendario. El redondeo usa la zona horaria de ahora.
+func ParsearTiempo(expr string, ahora time.Time) (time.Time, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return time.Time{}, fmt.Errorf("tiempo vacío")
+	}
+
+	if n, err := strconv.ParseInt(expr, 10, 64); err == nil {
+		return time.Unix(0, n), nil
+	}
+
+	if strings.HasPrefix(expr, "now") {
+		return parsearTiempoRelativo(expr, ahora)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("tiempo inválido: %q (use Unix nanosegundos, RFC3339 o now-1h)", expr)
+	}
+	return t, nil
+}
+
+// parsearTiempoRelativo interpreta expresiones "now[(+|-)duración]...[/unidad]"
+func parsearTiempoRelativo(expr string, ahora time.Time) (time.Time, error) {
+	resto := strings.TrimPrefix(expr, "now")
+	t := ahora
+
+	for resto != "" {
+		switch resto[0] {
+		case '+', '-':
+			signo := 1
+			if resto[0] == '-' {
+				signo = -1
+			}
+			resto = resto[1:]
+
+			// El desplazamiento llega hasta el próximo operador
+			fin := strings.IndexAny(resto, "+-/")
+			if fin < 0 {
+				fin = len(resto)
+			}
+			desplazamiento := resto[:fin]
+			resto = resto[fin:]
+
+			if n, ok := strings.CutSuffix(desplazamiento, "M"); ok {
+				meses, err := strconv.Atoi(n)
+				if err != nil {
+					return time.Time{}, fmt.Errorf("tiempo relativo inválido: %q", expr)
+				}
+				t = t.AddDate(0, signo*meses, 0)
+				continue
+			}
+			if n, ok := strings.CutSuffix(desplazamiento, "y"); ok {
+				anios, err := strconv.Atoi(n)
+				if err != nil {
+					return time.Time{}, fmt.Errorf("tiempo relativo inválido: %q", expr)
+				}
+				t = t.AddDate(signo*anios, 0, 0)
+				continue
+			}
+
+			d, err := ParsearDuracion(desplazamiento)
+			if err != nil || d < 0 {
+				return time.Time{}, fmt.Errorf("tiempo relativo inválido: %q", expr)
+			}
+			t = t.Add(time.Duration(signo) * d)
+
+		case '/':
+			unidad := resto[1:]
+			redondeado, err := redondearTiempo(t, unidad)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("tiempo relativo inválido: %q: %v", expr, err)
+			}
+			return redondeado, nil
+
+		default:
+			return time.Time{}, fmt.Errorf("tiempo relativo inválido: %q", expr)
+		}
+	}
+
+	return t, nil
+}
+
+// redondearTiempo trunca t al inicio de la unidad indicada (s, m, h, d, w, M, y).
+// La semana comienza el lunes.
+func redondearTiempo(t time.Time, unidad string) (time.Time, error) {
+	switch unidad {
+	case "s":
+		return t.Truncate(time.Second), nil
+	case "m":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case "h":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()), nil
+	case "d":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), nil
+	case "w":
+		diasDesdeLunes := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-diasDesdeLunes, 0, 0, 0, 0, t.Location()), nil
+	case "M":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()), nil
+	case "y":
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location()), nil
+	default:
+		return time.Time{}, fmt.Errorf("unidad de redondeo no soportada: %q (use s, m, h, d, w, M, y)", unidad)
+	}
+}
+
+// ============================================================================
+// TIPOS JSON
+// ============================================================================
+
+// MarcaTiempo es una marca de tiempo en Unix nanosegundos que en JSON acepta
+// un número (nanosegundos) o un string en cualquier formato de ParsearTiempo.
+// Las expresiones relativas se resuelven al momento de deserializar cada campo.
+type MarcaTiempo int64
+
+// Tiempo retorna la marca como time.Time
+func (m MarcaTiempo) Tiempo() time.Time {
+	return time.Unix(0, int64(m))
+}
+
+// UnmarshalJSON deserializa números (Unix nanosegundos) o strings (RFC3339, now-1h, ...)
+func (m *MarcaTiempo) UnmarshalJSON(data []byte) error {
+	var texto string
+	if err := json.Unmarshal(data, &texto); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("tiempo inválido: %s", string(data))
+		}
+		*m = MarcaTiempo(n)
+		return nil
+	}
+
+	t, err := ParsearTiempo(texto, time.Now())
+	if err != nil {
+		return err
+	}
+	*m = MarcaTiempo(t.UnixNano())
+	return nil
+}
+
+// Duracion es una duración en nanosegundos que en JSON acepta un número (nanosegundos)
+// o un string legible en cualquier formato de ParsearDuracion ("15m", "1d").
+type Duracion int64
+
+// Duration retorna la duración como time.Duration
+func (d Duracion) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// UnmarshalJSON deserializa números (nanosegundos) o strings ("15m", "1h30m", "7d")
+func (d *Duracion) UnmarshalJSON(data []byte) error {
+	var texto string
+	if err := json.Unmarshal(data, &texto); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("duración inválida: %s", string(data))
+		}
+		*d = Duracion(n)
+		return nil
+	}
+
+	duracion, err := ParsearDuracion(texto)
+	if err != nil {
+		return err
+	}
+	*d = Duracion(duracion)
+	return nil
+}
@@ -0,0 +1,183 @@
+package tipos
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// ==================== Tests de ParsearDuracion ====================
+
+// TestParsearDuracion_Formatos verifica los formatos de duración aceptados
+func TestParsearDuracion_Formatos(t *testing.T) {
+	casos := []struct {
+		expr     string
+		esperado time.Duration
+	}{
+		{"900", 900},
+		{"15m", 15 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"500ms", 500 * time.Millisecond},
+		{"1.5h", 90 * time.Minute},
+		{"1d", 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"10us", 10 * time.Microsecond},
+	}
+
+	for _, c := range casos {
+		t.Run(c.expr, func(t *testing.T) {
+			d, err := ParsearDuracion(c.expr)
+			if err != nil {
+				t.Fatalf("Error inesperado: %v", err)
+			}
+			if d != c.esperado {
+				t.Errorf("Esperado %v, obtenido %v", c.esperado, d)
+			}
+		})
+	}
+}
+
+// TestParsearDuracion_Invalida verifica el rechazo de duraciones inválidas
+func TestParsearDuracion_Invalida(t *testing.T) {
+	for _, expr := range []string{"", "abc", "15x", "m", "1h30"} {
+		if _, err := ParsearDuracion(expr); err == nil {
+			t.Errorf("Se esperaba error para %q", expr)
+		}
+	}
+}
+
+// ==================== Tests de ParsearTiempo ====================
+
+// TestParsearTiempo_Absoluto verifica nanosegundos y RFC3339
+func TestParsearTiempo_Absoluto(t *testing.T) {
+	ahora := time.Now()
+
+	tiempo, err := ParsearTiempo("1700000000000000000", ahora)
+	if err != nil || tiempo.UnixNano() != 1700000000000000000 {
+		t.Errorf("Nanosegundos mal interpretados: %v, %v", tiempo, err)
+	}
+
+	tiempo, err = ParsearTiempo("2024-01-15T10:30:00Z", ahora)
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if !tiempo.Equal(time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("RFC3339 mal interpretado: %v", tiempo)
+	}
+
+	tiempo, err = ParsearTiempo("2024-01-15T10:30:00.5-03:00", ahora)
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if !tiempo.Equal(time.Date(2024, 1, 15, 13, 30, 0, 500000000, time.UTC)) {
+		t.Errorf("RFC3339Nano con zona mal interpretado: %v", tiempo)
+	}
+}
+
+// TestParsearTiempo_Relativo verifica expresiones relativas y redondeo
+func TestParsearTiempo_Relativo(t *testing.T) {
+	ahora := time.Date(2024, 3, 14, 15, 45, 30, 0, time.UTC) // jueves
+
+	casos := []struct {
+		expr     string
+		esperado time.Time
+	}{
+		{"now", ahora},
+		{"now-1h", ahora.Add(-time.Hour)},
+		{"now+30m", ahora.Add(30 * time.Minute)},
+		{"now-7d", ahora.AddDate(0, 0, -7)},
+		{"now-1M", time.Date(2024, 2, 14, 15, 45, 30, 0, time.UTC)},
+		{"now-1y", time.Date(2023, 3, 14, 15, 45, 30, 0, time.UTC)},
+		{"now/d", time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"now-1d/d", time.Date(2024, 3, 13, 0, 0, 0, 0, time.UTC)},
+		{"now/h", time.Date(2024, 3, 14, 15, 0, 0, 0, time.UTC)},
+		{"now/w", time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"now/M", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"now-1h-30m", ahora.Add(-90 * time.Minute)},
+	}
+
+	for _, c := range casos {
+		t.Run(c.expr, func(t *testing.T) {
+			tiempo, err := ParsearTiempo(c.expr, ahora)
+			if err != nil {
+				t.Fatalf("Error inesperado: %v", err)
+			}
+			if !tiempo.Equal(c.esperado) {
+				t.Errorf("Esperado %v, obtenido %v", c.esperado, tiempo)
+			}
+		})
+	}
+}
+
+// TestParsearTiempo_Invalido verifica el rechazo de expresiones inválidas
+func TestParsearTiempo_Invalido(t *testing.T) {
+	for _, expr := range []string{"", "ayer", "now-", "now-1x", "now/q", "now*2", "2024-13-01T00:00:00Z"} {
+		if _, err := ParsearTiempo(expr, time.Now()); err == nil {
+			t.Errorf("Se esperaba error para %q", expr)
+		}
+	}
+}
+
+// ==================== Tests de tipos JSON ====================
+
+// TestMarcaTiempoYDuracion_UnmarshalJSON verifica la deserialización de números y strings
+func TestMarcaTiempoYDuracion_UnmarshalJSON(t *testing.T) {
+	var req struct {
+		Inicio    MarcaTiempo `json:"inicio"`
+		Fin       MarcaTiempo `json:"fin"`
+		Intervalo Duracion    `json:"intervalo"`
+		Paso      Duracion    `json:"paso"`
+	}
+
+	data := `{"inicio": 1000, "fin": "2024-01-15T10:30:00Z", "intervalo": "15m", "paso": 500}`
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+
+	if req.Inicio != 1000 {
+		t.Errorf("Inicio esperado 1000, obtenido %d", req.Inicio)
+	}
+	if !req.Fin.Tiempo().Equal(time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("Fin mal interpretado: %v", req.Fin.Tiempo())
+	}
+	if req.Intervalo.Duration() != 15*time.Minute {
+		t.Errorf("Intervalo esperado 15m, obtenido %v", req.Intervalo.Duration())
+	}
+	if req.Paso != 500 {
+		t.Errorf("Paso esperado 500, obtenido %d", req.Paso)
+	}
+
+	if err := json.Unmarshal([]byte(`{"inicio": "ayer"}`), &req); err == nil {
+		t.Error("Se esperaba error para tiempo inválido")
+	}
+	if err := json.Unmarshal([]byte(`{"intervalo": true}`), &req); err == nil {
+		t.Error("Se esperaba error para duración inválida")
+	}
+}
+
+// TestSolicitudConsultaAgregacionTemporal_UnmarshalJSON verifica la solicitud compartida con el edge
+func TestSolicitudConsultaAgregacionTemporal_UnmarshalJSON(t *testing.T) {
+	antes := time.Now()
+
+	var solicitud SolicitudConsultaAgregacionTemporal
+	data := `{"serie": "a/temp", "tiempo_inicio": "now-1h", "tiempo_fin": "now", "agregaciones": ["promedio"], "intervalo": "5m"}`
+	if err := json.Unmarshal([]byte(data), &solicitud); err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+
+	if solicitud.Serie != "a/temp" || len(solicitud.Agregaciones) != 1 {
+		t.Errorf("Campos mal deserializados: %+v", solicitud)
+	}
+	if solicitud.Intervalo != int64(5*time.Minute) {
+		t.Errorf("Intervalo esperado 5m, obtenido %d", solicitud.Intervalo)
+	}
+	// Cada campo resuelve "now" al deserializarse: se tolera una diferencia mínima
+	rango := time.Duration(solicitud.TiempoFin - solicitud.TiempoInicio)
+	if rango < time.Hour || rango > time.Hour+time.Second {
+		t.Errorf("Rango esperado de 1h, obtenido %v", rango)
+	}
+	if solicitud.TiempoFin < antes.UnixNano() {
+		t.Error("now debe resolverse al momento de deserializar")
+	}
+}
@@ -0,0 +1,40 @@
+package tipos
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+)
+
+// EsquemaHTTPS es el esquema de los nodos que sirven su API REST con TLS
+const EsquemaHTTPS = "https"
+
+// HuellaCertificado calcula la huella SHA-256 (hex) de un certificado en formato DER.
+// Los nodos con certificado autofirmado la publican en su registro y el despachador
+// la compara con el certificado presentado en cada conexión.
+func HuellaCertificado(der []byte) string {
+	huella := sha256.Sum256(der)
+	return hex.EncodeToString(huella[:])
+}
+
+// ConfiguracionTLSFijada acepta únicamente el certificado con la huella indicada.
+// La cadena y el nombre no se verifican: el certificado es autofirmado y la huella
+// proviene del registro firmado del nodo.
+func ConfiguracionTLSFijada(huella string) *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(estado tls.ConnectionState) error {
+			if len(estado.PeerCertificates) == 0 {
+				return fmt.Errorf("el nodo no presentó certificado")
+			}
+			obtenida := HuellaCertificado(estado.PeerCertificates[0].Raw)
+			if subtle.ConstantTimeCompare([]byte(obtenida), []byte(huella)) != 1 {
+				return fmt.Errorf("huella del certificado %s no coincide con la registrada %s", obtenida, huella)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,44 @@
+package tipos
+
+import (
+	"bufio"
+	"net"
+)
+
+// ============================================================================
+// TÚNEL INVERSO EDGE → DESPACHADOR
+// Los nodos detrás de NAT o en redes celulares no aceptan conexiones entrantes.
+// En ese caso el nodo abre una conexión saliente a RutaTunel del despachador con
+// una solicitud HTTP/1.1 firmada (ver FirmarSolicitud) que pide Upgrade a
+// ProtocoloTunel. Tras la respuesta 101 los roles se invierten: el nodo sirve su
+// API REST con HTTP/2 sobre la conexión y el despachador la usa como cliente,
+// multiplexando las consultas como streams.
+// ============================================================================
+
+const (
+	// RutaTunel es la ruta del despachador en la que los nodos abren el túnel
+	RutaTunel = "/api/tunel"
+	// ProtocoloTunel es el valor del encabezado Upgrade de la solicitud de túnel
+	ProtocoloTunel = "sensorwave-tunel"
+	// EncabezadoNodo identifica al nodo que abre el túnel
+	EncabezadoNodo = "X-Sensorwave-Nodo"
+)
+
+// conexionConLector es una conexión cuyas lecturas pasan primero por un lector con buffer
+type conexionConLector struct {
+	net.Conn
+	lector *bufio.Reader
+}
+
+func (c *conexionConLector) Read(p []byte) (int, error) {
+	return c.lector.Read(p)
+}
+
+// ConexionConLector retorna la conexión de un túnel recién establecido conservando
+// los bytes que el lector ya leyó durante el intercambio HTTP/1.1
+func ConexionConLector(conexion net.Conn, lector *bufio.Reader) net.Conn {
+	if lector == nil || lector.Buffered() == 0 {
+		return conexion
+	}
+	return &conexionConLector{Conn: conexion, lector: lector}
+}
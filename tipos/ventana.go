@@ -0,0 +1,230 @@
+package tipos
+
+import (
+	"fmt"
+	"math"
+)
+
+// TipoVentana define las funciones de ventana soportadas para suavizar o transformar series
+type TipoVentana string
+
+const (
+	VentanaMediaMovil    TipoVentana = "media_movil"    // Media móvil simple (por cantidad de puntos o por duración)
+	VentanaEWMA          TipoVentana = "ewma"           // Media móvil exponencial ponderada
+	VentanaSumaAcumulada TipoVentana = "suma_acumulada" // Suma acumulada desde el primer punto
+	VentanaDiferencia    TipoVentana = "diferencia"     // Diferencia entre puntos consecutivos
+)
+
+// FuncionVentana describe una transformación de ventana a aplicar sobre el resultado de una consulta.
+// Se aplica de forma independiente a cada serie (columna), ignorando los valores faltantes.
+//
+// Parámetros según el tipo:
+//   - media_movil: Puntos (cantidad de puntos) o Duracion, exactamente uno de los dos
+//   - ewma: Alfa en el rango (0, 1]
+//   - suma_acumulada, diferencia: sin parámetros
+type FuncionVentana struct {
+	Tipo     TipoVentana `json:"tipo"`
+	Puntos   int         `json:"puntos,omitempty"`   // Tamaño de la ventana en puntos (media_movil)
+	Duracion Duracion    `json:"duracion,omitempty"` // Tamaño de la ventana en nanosegundos (media_movil), admite "15m"
+	Alfa     float64     `json:"alfa,omitempty"`     // Factor de suavizado (ewma)
+}
+
+// Validar verifica que los parámetros sean coherentes con el tipo de ventana
+func (f FuncionVentana) Validar() error {
+	switch f.Tipo {
+	case VentanaMediaMovil:
+		if f.Puntos < 0 || f.Duracion < 0 {
+			return fmt.Errorf("media_movil: puntos y duración no pueden ser negativos")
+		}
+		if (f.Puntos > 0) == (f.Duracion > 0) {
+			return fmt.Errorf("media_movil: debe especificar puntos o duración (solo uno)")
+		}
+	case VentanaEWMA:
+		if f.Alfa <= 0 || f.Alfa > 1 {
+			return fmt.Errorf("ewma: alfa debe estar en el rango (0, 1], recibido: %v", f.Alfa)
+		}
+	case VentanaSumaAcumulada, VentanaDiferencia:
+		// Sin parámetros
+	default:
+		return fmt.Errorf("tipo de ventana no soportado: %s (use: media_movil, ewma, suma_acumulada, diferencia)", f.Tipo)
+	}
+	return nil
+}
+
+// AplicarVentana aplica la función de ventana a una serie de valores con sus tiempos asociados.
+// Los valores math.NaN() se consideran faltantes: no participan del cálculo y se conservan como NaN.
+// Las posiciones donde la ventana aún no está completa (media móvil por puntos) o no existe
+// un punto anterior (diferencia) resultan en math.NaN().
+func AplicarVentana(tiempos []int64, valores []float64, f FuncionVentana) ([]float64, error) {
+	if err := f.Validar(); err != nil {
+		return nil, err
+	}
+	if len(tiempos) != len(valores) {
+		return nil, fmt.Errorf("cantidad de tiempos (%d) y valores (%d) no coincide", len(tiempos), len(valores))
+	}
+
+	salida := make([]float64, len(valores))
+	for i := range salida {
+		salida[i] = math.NaN()
+	}
+
+	switch f.Tipo {
+	case VentanaMediaMovil:
+		// Índices de los puntos válidos dentro de la ventana actual
+		var ventana []int
+		suma := 0.0
+		for i, v := range valores {
+			if math.IsNaN(v) {
+				continue
+			}
+			ventana = append(ventana, i)
+			suma += v
+
+			if f.Puntos > 0 {
+				if len(ventana) > f.Puntos {
+					suma -= valores[ventana[0]]
+					ventana = ventana[1:]
+				}
+				if len(ventana) == f.Puntos {
+					salida[i] = suma / float64(f.Puntos)
+				}
+				continue
+			}
+
+			// Ventana por duración: (t - Duracion, t]
+			for len(ventana) > 0 && tiempos[ventana[0]] <= tiempos[i]-int64(f.Duracion) {
+				suma -= valores[ventana[0]]
+				ventana = ventana[1:]
+			}
+			salida[i] = suma / float64(len(ventana))
+		}
+
+	case VentanaEWMA:
+		anterior := math.NaN()
+		for i, v := range valores {
+			if math.IsNaN(v) {
+				continue
+			}
+			if math.IsNaN(anterior) {
+				anterior = v
+			} else {
+				anterior = f.Alfa*v + (1-f.Alfa)*anterior
+			}
+			salida[i] = anterior
+		}
+
+	case VentanaSumaAcumulada:
+		suma := 0.0
+		for i, v := range valores {
+			if math.IsNaN(v) {
+				continue
+			}
+			suma += v
+			salida[i] = suma
+		}
+
+	case VentanaDiferencia:
+		anterior := math.NaN()
+		for i, v := range valores {
+			if math.IsNaN(v) {
+				continue
+			}
+			if !math.IsNaN(anterior) {
+				salida[i] = v - anterior
+			}
+			anterior = v
+		}
+	}
+
+	return salida, nil
+}
+
+// AplicarVentanaRango aplica la función de ventana a cada serie de un resultado tabular de ConsultarRango.
+// Solo se admiten series numéricas (int64 o float64); los valores transformados son float64
+// y las celdas sin resultado quedan en nil.
+func AplicarVentanaRango(resultado ResultadoConsultaRango, f FuncionVentana) (ResultadoConsultaRango, error) {
+	if err := f.Validar(); err != nil {
+		return ResultadoConsultaRango{}, err
+	}
+
+	salida := ResultadoConsultaRango{
+		Series:             resultado.Series,
+		Tiempos:            resultado.Tiempos,
+		Valores:            make([][]interface{}, len(resultado.Valores)),
+		NodosNoDisponibles: resultado.NodosNoDisponibles,
+	}
+	for fila := range resultado.Valores {
+		salida.Valores[fila] = make([]interface{}, len(resultado.Series))
+	}
+
+	columna := make([]float64, len(resultado.Tiempos))
+	for col, serie := range resultado.Series {
+		for fila := range resultado.Tiempos {
+			columna[fila] = math.NaN()
+			if col >= len(resultado.Valores[fila]) {
+				continue
+			}
+			switch v := resultado.Valores[fila][col].(type) {
+			case nil:
+			case float64:
+				columna[fila] = v
+			case int64:
+				columna[fila] = float64(v)
+			default:
+				return ResultadoConsultaRango{}, fmt.Errorf("serie %s: tipo %T no admite funciones de ventana", serie, v)
+			}
+		}
+
+		transformada, err := AplicarVentana(resultado.Tiempos, columna, f)
+		if err != nil {
+			return ResultadoConsultaRango{}, err
+		}
+		for fila, v := range transformada {
+			if !math.IsNaN(v) {
+				salida.Valores[fila][col] = v
+			}
+		}
+	}
+
+	return salida, nil
+}
+
+// AplicarVentanaAgregacionTemporal aplica la función de ventana sobre los buckets de un resultado
+// de ConsultarAgregacionTemporal, de forma independiente para cada agregación y cada serie.
+// Los buckets sin datos (NaN) no participan del cálculo.
+func AplicarVentanaAgregacionTemporal(resultado ResultadoAgregacionTemporal, f FuncionVentana) (ResultadoAgregacionTemporal, error) {
+	if err := f.Validar(); err != nil {
+		return ResultadoAgregacionTemporal{}, err
+	}
+
+	salida := ResultadoAgregacionTemporal{
+		Series:             resultado.Series,
+		Tiempos:            resultado.Tiempos,
+		Agregaciones:       resultado.Agregaciones,
+		Valores:            make([][][]float64, len(resultado.Valores)),
+		NodosNoDisponibles: resultado.NodosNoDisponibles,
+	}
+
+	columna := make([]float64, len(resultado.Tiempos))
+	for agg := range resultado.Valores {
+		salida.Valores[agg] = make([][]float64, len(resultado.Tiempos))
+		for b := range resultado.Tiempos {
+			salida.Valores[agg][b] = make([]float64, len(resultado.Series))
+		}
+
+		for s := range resultado.Series {
+			for b := range resultado.Tiempos {
+				columna[b] = resultado.Valores[agg][b][s]
+			}
+			transformada, err := AplicarVentana(resultado.Tiempos, columna, f)
+			if err != nil {
+				return ResultadoAgregacionTemporal{}, err
+			}
+			for b, v := range transformada {
+				salida.Valores[agg][b][s] = v
+			}
+		}
+	}
+
+	return salida, nil
+}
@@ -0,0 +1,201 @@
+package tipos
+
+import (
+	"math"
+	"testing"
+)
+
+// compararFloats compara dos slices tratando NaN como igual a NaN
+func compararFloats(t *testing.T, esperado, obtenido []float64) {
+	t.Helper()
+	if len(esperado) != len(obtenido) {
+		t.Fatalf("Longitud esperada %d, obtenida %d", len(esperado), len(obtenido))
+	}
+	for i := range esperado {
+		if math.IsNaN(esperado[i]) && math.IsNaN(obtenido[i]) {
+			continue
+		}
+		if math.Abs(esperado[i]-obtenido[i]) > 1e-9 {
+			t.Errorf("Posición %d: esperado %v, obtenido %v", i, esperado[i], obtenido[i])
+		}
+	}
+}
+
+// ==================== Tests de FuncionVentana.Validar ====================
+
+// TestFuncionVentana_Validar verifica la validación de parámetros por tipo
+func TestFuncionVentana_Validar(t *testing.T) {
+	casos := []struct {
+		nombre  string
+		ventana FuncionVentana
+		valida  bool
+	}{
+		{"media por puntos", FuncionVentana{Tipo: VentanaMediaMovil, Puntos: 3}, true},
+		{"media por duración", FuncionVentana{Tipo: VentanaMediaMovil, Duracion: 10}, true},
+		{"media sin parámetros", FuncionVentana{Tipo: VentanaMediaMovil}, false},
+		{"media con ambos", FuncionVentana{Tipo: VentanaMediaMovil, Puntos: 3, Duracion: 10}, false},
+		{"ewma válida", FuncionVentana{Tipo: VentanaEWMA, Alfa: 0.5}, true},
+		{"ewma alfa cero", FuncionVentana{Tipo: VentanaEWMA}, false},
+		{"ewma alfa mayor a uno", FuncionVentana{Tipo: VentanaEWMA, Alfa: 1.5}, false},
+		{"suma acumulada", FuncionVentana{Tipo: VentanaSumaAcumulada}, true},
+		{"diferencia", FuncionVentana{Tipo: VentanaDiferencia}, true},
+		{"tipo desconocido", FuncionVentana{Tipo: "mediana"}, false},
+	}
+
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			err := c.ventana.Validar()
+			if c.valida && err != nil {
+				t.Errorf("Se esperaba válida, error: %v", err)
+			}
+			if !c.valida && err == nil {
+				t.Error("Se esperaba error de validación")
+			}
+		})
+	}
+}
+
+// ==================== Tests de AplicarVentana ====================
+
+// TestAplicarVentana_MediaMovilPuntos verifica la media móvil simple por cantidad de puntos
+func TestAplicarVentana_MediaMovilPuntos(t *testing.T) {
+	tiempos := []int64{1, 2, 3, 4, 5}
+	valores := []float64{1, 2, 3, 4, 5}
+
+	salida, err := AplicarVentana(tiempos, valores, FuncionVentana{Tipo: VentanaMediaMovil, Puntos: 3})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	compararFloats(t, []float64{math.NaN(), math.NaN(), 2, 3, 4}, salida)
+}
+
+// TestAplicarVentana_MediaMovilDuracion verifica la media móvil por duración (t - D, t]
+func TestAplicarVentana_MediaMovilDuracion(t *testing.T) {
+	tiempos := []int64{0, 10, 20, 30}
+	valores := []float64{2, 4, 6, 8}
+
+	salida, err := AplicarVentana(tiempos, valores, FuncionVentana{Tipo: VentanaMediaMovil, Duracion: 20})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	compararFloats(t, []float64{2, 3, 5, 7}, salida)
+}
+
+// TestAplicarVentana_EWMA verifica la media móvil exponencial
+func TestAplicarVentana_EWMA(t *testing.T) {
+	tiempos := []int64{1, 2, 3}
+	valores := []float64{10, 20, 30}
+
+	salida, err := AplicarVentana(tiempos, valores, FuncionVentana{Tipo: VentanaEWMA, Alfa: 0.5})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	compararFloats(t, []float64{10, 15, 22.5}, salida)
+}
+
+// TestAplicarVentana_SumaAcumuladaYDiferencia verifica suma acumulada y diferencia
+func TestAplicarVentana_SumaAcumuladaYDiferencia(t *testing.T) {
+	tiempos := []int64{1, 2, 3, 4}
+	valores := []float64{1, 3, 6, 10}
+
+	suma, err := AplicarVentana(tiempos, valores, FuncionVentana{Tipo: VentanaSumaAcumulada})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	compararFloats(t, []float64{1, 4, 10, 20}, suma)
+
+	diferencia, err := AplicarVentana(tiempos, valores, FuncionVentana{Tipo: VentanaDiferencia})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	compararFloats(t, []float64{math.NaN(), 2, 3, 4}, diferencia)
+}
+
+// TestAplicarVentana_IgnoraFaltantes verifica que los NaN no participan del cálculo
+func TestAplicarVentana_IgnoraFaltantes(t *testing.T) {
+	tiempos := []int64{1, 2, 3, 4}
+	valores := []float64{1, math.NaN(), 3, 5}
+
+	salida, err := AplicarVentana(tiempos, valores, FuncionVentana{Tipo: VentanaMediaMovil, Puntos: 2})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	compararFloats(t, []float64{math.NaN(), math.NaN(), 2, 4}, salida)
+}
+
+// ==================== Tests sobre resultados de consulta ====================
+
+// TestAplicarVentanaRango_Tabular verifica la transformación por columna de un resultado tabular
+func TestAplicarVentanaRango_Tabular(t *testing.T) {
+	resultado := ResultadoConsultaRango{
+		Series:  []string{"a/temp", "b/temp"},
+		Tiempos: []int64{1, 2, 3},
+		Valores: [][]interface{}{
+			{int64(1), 10.0},
+			{nil, 20.0},
+			{int64(4), nil},
+		},
+		NodosNoDisponibles: []string{"nodo-x"},
+	}
+
+	salida, err := AplicarVentanaRango(resultado, FuncionVentana{Tipo: VentanaSumaAcumulada})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+
+	esperado := [][]interface{}{
+		{1.0, 10.0},
+		{nil, 30.0},
+		{5.0, nil},
+	}
+	for fila := range esperado {
+		for col := range esperado[fila] {
+			if salida.Valores[fila][col] != esperado[fila][col] {
+				t.Errorf("[%d][%d]: esperado %v, obtenido %v", fila, col, esperado[fila][col], salida.Valores[fila][col])
+			}
+		}
+	}
+	if len(salida.NodosNoDisponibles) != 1 {
+		t.Error("Se esperaba conservar NodosNoDisponibles")
+	}
+	// El resultado original no debe modificarse
+	if resultado.Valores[2][0] != int64(4) {
+		t.Error("El resultado original fue modificado")
+	}
+}
+
+// TestAplicarVentanaRango_NoNumerico verifica el error con series no numéricas
+func TestAplicarVentanaRango_NoNumerico(t *testing.T) {
+	resultado := ResultadoConsultaRango{
+		Series:  []string{"a/estado"},
+		Tiempos: []int64{1},
+		Valores: [][]interface{}{{"encendido"}},
+	}
+
+	if _, err := AplicarVentanaRango(resultado, FuncionVentana{Tipo: VentanaDiferencia}); err == nil {
+		t.Error("Se esperaba error para serie de texto")
+	}
+}
+
+// TestAplicarVentanaAgregacionTemporal verifica la transformación sobre buckets
+func TestAplicarVentanaAgregacionTemporal(t *testing.T) {
+	resultado := ResultadoAgregacionTemporal{
+		Series:       []string{"a/temp"},
+		Tiempos:      []int64{0, 10, 20},
+		Agregaciones: []TipoAgregacion{AgregacionPromedio, AgregacionMaximo},
+		Valores: [][][]float64{
+			{{1}, {math.NaN()}, {4}},
+			{{2}, {6}, {7}},
+		},
+	}
+
+	salida, err := AplicarVentanaAgregacionTemporal(resultado, FuncionVentana{Tipo: VentanaDiferencia})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+
+	compararFloats(t, []float64{math.NaN(), math.NaN(), 3},
+		[]float64{salida.Valores[0][0][0], salida.Valores[0][1][0], salida.Valores[0][2][0]})
+	compararFloats(t, []float64{math.NaN(), 4, 1},
+		[]float64{salida.Valores[1][0][0], salida.Valores[1][1][0], salida.Valores[1][2][0]})
+}
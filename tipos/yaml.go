@@ -0,0 +1,23 @@
+package tipos
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DecodificarYAML decodifica un documento YAML (o JSON, que es YAML válido) en destino
+// usando los tags y decodificadores JSON, de modo que los archivos de configuración
+// aceptan los mismos formatos que la API (Duracion "15m", TipoDatos, MarcaTiempo).
+func DecodificarYAML(datos []byte, destino interface{}) error {
+	var documento interface{}
+	if err := yaml.Unmarshal(datos, &documento); err != nil {
+		return err
+	}
+	datosJSON, err := json.Marshal(documento)
+	if err != nil {
+		return fmt.Errorf("el documento no es representable en JSON: %v", err)
+	}
+	return json.Unmarshal(datosJSON, destino)
+}
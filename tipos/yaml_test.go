@@ -0,0 +1,55 @@
+package tipos
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDecodificarYAML verifica que se usen los tags y decodificadores JSON
+func TestDecodificarYAML(t *testing.T) {
+	documento := []byte(`
+serie:
+  path: sensor/temp
+  tipo_datos: Real
+  tags:
+    unidad: celsius
+intervalo: 15m
+limite: 2
+`)
+	var destino struct {
+		Serie     Serie    `json:"serie"`
+		Intervalo Duracion `json:"intervalo"`
+		Limite    int      `json:"limite"`
+	}
+	if err := DecodificarYAML(documento, &destino); err != nil {
+		t.Fatalf("Error decodificando: %v", err)
+	}
+
+	if destino.Serie.Path != "sensor/temp" || destino.Serie.TipoDatos != Real {
+		t.Errorf("Serie incorrecta: %+v", destino.Serie)
+	}
+	if destino.Serie.Tags["unidad"] != "celsius" {
+		t.Errorf("Tags incorrectos: %v", destino.Serie.Tags)
+	}
+	if destino.Intervalo.Duration() != 15*time.Minute {
+		t.Errorf("Intervalo incorrecto: %v", destino.Intervalo.Duration())
+	}
+	if destino.Limite != 2 {
+		t.Errorf("Límite incorrecto: %d", destino.Limite)
+	}
+
+	// JSON también es YAML válido
+	if err := DecodificarYAML([]byte(`{"limite": 3}`), &destino); err != nil || destino.Limite != 3 {
+		t.Errorf("JSON no decodificado: %v, límite %d", err, destino.Limite)
+	}
+
+	// Errores de sintaxis y de tipos
+	if err := DecodificarYAML([]byte("serie: [sin cerrar"), &destino); err == nil {
+		t.Error("Se esperaba error de sintaxis")
+	}
+	if err := DecodificarYAML([]byte("limite: muchos"), &destino); err == nil {
+		t.Error("Se esperaba error de tipo")
+	}
+
+	t.Log("✓ DecodificarYAML usa los decodificadores JSON")
+}